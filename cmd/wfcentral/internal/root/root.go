@@ -35,6 +35,12 @@ func New() (*cobra.Command, error) {
 	flags.IntVar(&cfg.LogStage, "log-stage", 1,
 		"enable stage-aware logging (1-6)")
 
+	// Client flags used by commands that call a running control plane
+	flags.StringVar(&cfg.Server, "server", cfg.Server,
+		"control plane address for client commands")
+	flags.StringVar(&cfg.CredentialFile, "credential-file", "",
+		"file containing the API key or token for client commands (defaults to $"+options.CredentialEnv+")")
+
 	// Add staged command groups
 	if err := stage1.AddCommands(cmd, cfg); err != nil {
 		return nil, fmt.Errorf("adding stage1 commands: %w", err)
@@ -1,27 +1,48 @@
 package stage1
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/wrale/wrale-fleet/cmd/wfcentral/options"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"gopkg.in/yaml.v3"
 )
 
+// redactedValue replaces secret values in redacted output.
+const redactedValue = "[REDACTED]"
+
+// secretKeyMarkers identify configuration keys whose values are secrets.
+var secretKeyMarkers = []string{
+	"password", "passwd", "secret", "token", "credential", "private_key", "privatekey", "api_key", "apikey",
+}
+
 // newConfigShowCmd creates the config show command
 func newConfigShowCmd(cfg *options.Config) (*cobra.Command, error) {
-	var redactSecrets bool
+	var (
+		redactSecrets bool
+		output        string
+	)
 
 	cmd := &cobra.Command{
 		Use:   "show NAME",
 		Short: "Show current configuration",
 		Long: `Display the current configuration for a specific device.
 
-This command shows the full device configuration including:
-- System settings
-- Service configurations
-- Security policies
-- Resource limits
+The device can be given by name or ID. This command shows the
+configuration the device last applied, its version and hash, and the
+most recent deployment targeting the device.
 
 The configuration can be displayed with sensitive information redacted
 using the --redact-secrets flag. This is useful when sharing configurations
@@ -33,48 +54,101 @@ for troubleshooting or documentation purposes.`,
   wfcentral device config show device-1 --redact-secrets`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return showDeviceConfig(cmd.Context(), cfg, args[0], redactSecrets)
+			return showDeviceConfig(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], redactSecrets, output)
 		},
 	}
 
 	cmd.Flags().BoolVar(&redactSecrets, "redact-secrets", false,
 		"redact sensitive information from the configuration output")
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newConfigEffectiveCmd creates the config effective command
+func newConfigEffectiveCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		redactSecrets bool
+		output        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "effective NAME",
+		Short: "Show configuration with group settings applied",
+		Long: `Display the effective configuration of a specific device.
+
+The effective configuration combines, from least to most specific:
+1. The config template and policy overrides of each group the device
+   belongs to, root groups first and their descendants after them
+2. The device's own configuration
+
+Each key is listed with the group, or the device, that set its value.`,
+		Example: `  # Show the effective configuration of a device
+  wfcentral device config effective device-1
+
+  # Show it as JSON, including the source of each key
+  wfcentral device config effective device-1 --output json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return showEffectiveConfig(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], redactSecrets, output)
+		},
+	}
+
+	cmd.Flags().BoolVar(&redactSecrets, "redact-secrets", false,
+		"redact sensitive information from the configuration output")
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
 
 	return cmd, nil
 }
 
 // newConfigValidateCmd creates the config validate command
 func newConfigValidateCmd(cfg *options.Config) (*cobra.Command, error) {
-	var configFile string
+	var (
+		configFile string
+		template   string
+		variables  []string
+		verbose    bool
+	)
 
 	cmd := &cobra.Command{
 		Use:   "validate NAME",
 		Short: "Validate configuration file",
 		Long: `Validate a configuration file for a specific device.
 
-This command performs comprehensive validation including:
-- Syntax checking
-- Schema validation
-- Policy compliance
-- Resource limit verification
-- Security requirement checks
-
-The validation process ensures the configuration is safe to apply
-and meets all system requirements. Any validation errors will be
-reported with clear explanations and suggested fixes.`,
+The file may be YAML or JSON. It is rendered for the device as apply
+would render it, then checked against the schema of the configuration
+template, given with --template or, by default, the template of the
+device's latest deployment. Every violation, including template variables
+without a value, is reported with the path of the offending value.
+Validation never changes anything on the control plane.`,
 		Example: `  # Validate configuration file for a device
   wfcentral device config validate device-1 --config new-config.yaml
 
+  # Validate with a device-specific value for a template variable
+  wfcentral device config validate device-1 --config new-config.yaml --var network.port=8443
+
   # Validate with detailed error reporting
   wfcentral device config validate device-1 --config new-config.yaml --verbose`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return validateDeviceConfig(cmd.Context(), cfg, args[0], configFile)
+			vars, err := parseVariables(variables)
+			if err != nil {
+				return err
+			}
+			return validateDeviceConfig(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], configFile, template, vars, verbose)
 		},
 	}
 
 	cmd.Flags().StringVar(&configFile, "config", "",
 		"path to configuration file to validate")
+	cmd.Flags().StringVar(&template, "template", "",
+		"configuration template name or ID (defaults to the template of the latest deployment)")
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"template variable value for the device as NAME=VALUE, with VALUE read as YAML (repeatable)")
+	cmd.Flags().BoolVar(&verbose, "verbose", false,
+		"show the template used and the changes against the current configuration")
 
 	if err := cmd.MarkFlagRequired("config"); err != nil {
 		return nil, fmt.Errorf("marking config flag as required: %w", err)
@@ -87,6 +161,10 @@ reported with clear explanations and suggested fixes.`,
 func newConfigApplyCmd(cfg *options.Config) (*cobra.Command, error) {
 	var (
 		configFile string
+		template   string
+		variables  []string
+		backupDir  string
+		noBackup   bool
 		dryRun     bool
 		force      bool
 	)
@@ -98,31 +176,63 @@ func newConfigApplyCmd(cfg *options.Config) (*cobra.Command, error) {
 
 This command applies a configuration file to a device, performing
 these steps:
-1. Validate the configuration
-2. Create a backup of the current configuration
-3. Apply the new configuration
-4. Verify the application was successful
-5. Monitor for any issues during the change
-
-The --dry-run flag can be used to simulate the application process
-without making actual changes. The --force flag bypasses confirmation
-prompts but still performs validation.`,
+1. Render the configuration for the device and validate it against the
+   template schema
+2. Show the changes against the device's current configuration
+3. Back up the device's current configuration to a file
+4. Store the rendered configuration as a new template version
+5. Create a deployment of that version to the device
+
+Rendering replaces ${device.*} placeholders with facts of the device and
+sets each template variable from, by precedence, the --var values, the
+variables of the device's groups (nested groups first) and the variable
+default. A variable the file already sets keeps its value unless a --var
+or group value is given.
+
+The backup is written to --backup-dir, the current directory by default,
+as NAME-config-TIMESTAMP.json with owner-only permissions. Nothing is
+applied if it cannot be written; --no-backup skips it. The device's
+previous configurations also stay in its configuration history, so an
+earlier version can always be applied again.
+
+The --dry-run flag validates and shows the changes without storing
+anything. The --force flag bypasses the confirmation prompt but still
+performs validation.`,
 		Example: `  # Apply configuration to a device
   wfcentral device config apply device-1 --config new-config.yaml
 
   # Simulate configuration application
   wfcentral device config apply device-1 --config new-config.yaml --dry-run
 
-  # Apply without confirmation prompt
-  wfcentral device config apply device-1 --config new-config.yaml --force`,
+  # Apply with a device-specific value for a template variable
+  wfcentral device config apply device-1 --config new-config.yaml --var log_level=debug
+
+  # Apply without confirmation prompt, keeping backups in one place
+  wfcentral device config apply device-1 --config new-config.yaml --force --backup-dir ./backups`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return applyDeviceConfig(cmd.Context(), cfg, args[0], configFile, dryRun, force)
+			if noBackup {
+				backupDir = ""
+			}
+			vars, err := parseVariables(variables)
+			if err != nil {
+				return err
+			}
+			return applyDeviceConfig(cmd.Context(), cmd.InOrStdin(), cmd.OutOrStdout(), cfg,
+				args[0], configFile, template, vars, backupDir, dryRun, force)
 		},
 	}
 
 	cmd.Flags().StringVar(&configFile, "config", "",
 		"path to configuration file to apply")
+	cmd.Flags().StringVar(&template, "template", "",
+		"configuration template name or ID (defaults to the template of the latest deployment)")
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"template variable value for the device as NAME=VALUE, with VALUE read as YAML (repeatable)")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", ".",
+		"directory to back up the current configuration to before applying")
+	cmd.Flags().BoolVar(&noBackup, "no-backup", false,
+		"apply without backing up the current configuration")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false,
 		"validate and simulate configuration application without making changes")
 	cmd.Flags().BoolVar(&force, "force", false,
@@ -136,32 +246,472 @@ prompts but still performs validation.`,
 }
 
 // showDeviceConfig implements the config show command functionality
-func showDeviceConfig(ctx context.Context, cfg *options.Config, deviceName string, redactSecrets bool) error {
-	// Implementation would:
-	// 1. Connect to the control plane
-	// 2. Retrieve device configuration
-	// 3. Optionally redact sensitive information
-	// 4. Format and display the configuration
-	return fmt.Errorf("not implemented")
+func showDeviceConfig(ctx context.Context, w io.Writer, cfg *options.Config, deviceName string, redactSecrets bool, output string) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	dev, err := c.FindDevice(ctx, deviceName)
+	if err != nil {
+		return err
+	}
+	current, err := c.DeviceConfig(ctx, dev.ID)
+	if err != nil {
+		return err
+	}
+	if redactSecrets {
+		if current.Config, err = redactConfig(current.Config); err != nil {
+			return err
+		}
+		if current.Deployment != nil && current.Deployment.ConfigVersion != nil {
+			version := *current.Deployment.ConfigVersion
+			if version.Config, err = redactConfig(version.Config); err != nil {
+				return err
+			}
+			deployment := *current.Deployment
+			deployment.ConfigVersion = &version
+			current.Deployment = &deployment
+		}
+	}
+
+	if output != outputTable {
+		return writeStructured(w, output, current)
+	}
+
+	tw := newTable(w)
+	fmt.Fprintf(tw, "Device:\t%s (%s)\n", dev.Name, dev.ID)
+	if current.Version > 0 {
+		fmt.Fprintf(tw, "Version:\t%d\n", current.Version)
+	}
+	fmt.Fprintf(tw, "Hash:\t%s\n", orDash(current.Hash))
+	if d := current.Deployment; d != nil {
+		fmt.Fprintf(tw, "Deployment:\t%s (%s", d.ID, d.Status)
+		if d.ConfigVersion != nil {
+			fmt.Fprintf(tw, ", version %d", d.ConfigVersion.Number)
+		}
+		fmt.Fprintf(tw, ", %s)\n", formatTime(d.DeployedAt))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	if len(current.Config) == 0 {
+		_, err := fmt.Fprintln(w, "No configuration has been applied.")
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(current.Config, &doc); err != nil {
+		return fmt.Errorf("decoding device configuration: %w", err)
+	}
+	return writeStructured(w, outputYAML, doc)
+}
+
+// showEffectiveConfig implements the config effective command functionality
+func showEffectiveConfig(ctx context.Context, w io.Writer, cfg *options.Config, deviceName string, redactSecrets bool, output string) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	dev, err := c.FindDevice(ctx, deviceName)
+	if err != nil {
+		return err
+	}
+	effective, err := c.EffectiveDeviceConfig(ctx, dev.ID)
+	if err != nil {
+		return err
+	}
+	if redactSecrets {
+		if effective.Config, err = redactConfig(effective.Config); err != nil {
+			return err
+		}
+	}
+
+	if output != outputTable {
+		return writeStructured(w, output, effective)
+	}
+
+	tw := newTable(w)
+	fmt.Fprintf(tw, "Device:\t%s (%s)\n", dev.Name, dev.ID)
+	fmt.Fprintf(tw, "Groups:\t%d\n", len(effective.Groups))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	if len(effective.Sources) == 0 {
+		_, err := fmt.Fprintln(w, "No configuration is set by the device or its groups.")
+		return err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(effective.Config, &doc); err != nil {
+		return fmt.Errorf("decoding effective configuration: %w", err)
+	}
+	keys := make([]string, 0, len(effective.Sources))
+	for key := range effective.Sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tw = newTable(w)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, key := range keys {
+		value, err := json.Marshal(lookupKey(doc, key))
+		if err != nil {
+			return fmt.Errorf("encoding value of %s: %w", key, err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, value, formatSource(effective.Sources[key]))
+	}
+	return tw.Flush()
+}
+
+// lookupKey returns the value at a dot-separated path of a configuration
+func lookupKey(doc interface{}, key string) interface{} {
+	for _, part := range strings.Split(key, ".") {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = obj[part]
+	}
+	return doc
+}
+
+// formatSource describes the layer an effective configuration key came from
+func formatSource(src group.Source) string {
+	switch src.Layer {
+	case group.SourceConfigTemplate:
+		return fmt.Sprintf("group %s (template)", src.GroupName)
+	case group.SourcePolicyOverride:
+		return fmt.Sprintf("group %s (policy override)", src.GroupName)
+	default:
+		return src.Layer
+	}
 }
 
 // validateDeviceConfig implements the config validate command functionality
-func validateDeviceConfig(ctx context.Context, cfg *options.Config, deviceName, configFile string) error {
-	// Implementation would:
-	// 1. Read and parse the configuration file
-	// 2. Connect to the control plane
-	// 3. Perform validation checks
-	// 4. Report any validation errors
-	return fmt.Errorf("not implemented")
-}
-
-// applyDeviceConfig implements the config apply command functionality
-func applyDeviceConfig(ctx context.Context, cfg *options.Config, deviceName, configFile string, dryRun, force bool) error {
-	// Implementation would:
-	// 1. Validate the configuration
-	// 2. Create configuration backup
-	// 3. Apply the configuration if not dry-run
-	// 4. Verify the application
-	// 5. Monitor for issues
-	return fmt.Errorf("not implemented")
+func validateDeviceConfig(ctx context.Context, w io.Writer, cfg *options.Config, deviceName, configFile, template string,
+	variables map[string]interface{}, verbose bool) error {
+	doc, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	dev, err := c.FindDevice(ctx, deviceName)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.ValidateDeviceConfig(ctx, dev.ID, &api.ConfigRequest{Template: template, Config: doc, Variables: variables})
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Fprintf(w, "Device:   %s (%s)\n", dev.Name, dev.ID)
+		fmt.Fprintf(w, "Template: %s (%s)\n", result.TemplateName, result.TemplateID)
+		current, err := c.DeviceConfig(ctx, dev.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		if err := writeConfigDiff(w, current.Config, renderedConfig(result, doc)); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+
+	if !result.Valid {
+		fmt.Fprintf(w, "Configuration %s is invalid for device %s:\n", configFile, dev.Name)
+		for _, v := range result.Violations {
+			fmt.Fprintf(w, "  - %s\n", v)
+		}
+		return fmt.Errorf("configuration validation failed with %d violation(s)", len(result.Violations))
+	}
+
+	_, err = fmt.Fprintf(w, "Configuration %s is valid for device %s (template %s).\n",
+		configFile, dev.Name, result.TemplateName)
+	return err
+}
+
+// applyDeviceConfig implements the config apply command functionality. The
+// current configuration is backed up to backupDir unless it is empty.
+func applyDeviceConfig(ctx context.Context, in io.Reader, w io.Writer, cfg *options.Config, deviceName, configFile, template string,
+	variables map[string]interface{}, backupDir string, dryRun, force bool) error {
+	doc, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	dev, err := c.FindDevice(ctx, deviceName)
+	if err != nil {
+		return err
+	}
+
+	req := &api.ConfigRequest{Template: template, Config: doc, Variables: variables}
+	result, err := c.ValidateDeviceConfig(ctx, dev.ID, req)
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		fmt.Fprintf(w, "Configuration %s is invalid for device %s:\n", configFile, dev.Name)
+		for _, v := range result.Violations {
+			fmt.Fprintf(w, "  - %s\n", v)
+		}
+		return fmt.Errorf("configuration validation failed with %d violation(s)", len(result.Violations))
+	}
+	// Pin the template so the applied version matches what was validated.
+	req.Template = result.TemplateID
+
+	current, err := c.DeviceConfig(ctx, dev.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Changes to device %s (template %s):\n", dev.Name, result.TemplateName)
+	if err := writeConfigDiff(w, current.Config, renderedConfig(result, doc)); err != nil {
+		return err
+	}
+
+	if dryRun {
+		_, err := fmt.Fprintln(w, "\nDry run: configuration is valid; no changes were made.")
+		return err
+	}
+	if !force {
+		ok, err := confirm(in, w, fmt.Sprintf("\nApply this configuration to device %s?", dev.Name))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			_, err := fmt.Fprintln(w, "Aborted; no changes were made.")
+			return err
+		}
+	}
+
+	if backupDir != "" && len(current.Config) > 0 {
+		path, err := backupConfig(backupDir, dev.Name, current.Config, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\nBacked up the current configuration to %s.\n", path)
+	}
+
+	applied, err := c.ApplyDeviceConfig(ctx, dev.ID, req)
+	if err != nil {
+		return err
+	}
+	deployment := applied.Deployment
+
+	fmt.Fprintln(w)
+	if deployment.ConfigVersion != nil {
+		fmt.Fprintf(w, "Created configuration version %d (hash %s).\n",
+			deployment.ConfigVersion.Number, deployment.ConfigVersion.Hash)
+	}
+	_, err = fmt.Fprintf(w, "Deployment %s is %s.\n", deployment.ID, deployment.Status)
+	return err
+}
+
+// renderedConfig returns the configuration a validation rendered for the
+// device, or the submitted one when the control plane did not return it.
+func renderedConfig(result *api.ConfigValidationResponse, submitted json.RawMessage) json.RawMessage {
+	if len(result.Config) > 0 {
+		return result.Config
+	}
+	return submitted
+}
+
+// parseVariables parses NAME=VALUE template variable flags. Values are
+// read as YAML, so 8080 is a number and true a boolean; quote a value to
+// pass it as a string.
+func parseVariables(flags []string) (map[string]interface{}, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	vars := make(map[string]interface{}, len(flags))
+	for _, flag := range flags {
+		name, raw, ok := strings.Cut(flag, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid variable %q: expected NAME=VALUE", flag)
+		}
+		if raw == "" {
+			vars[name] = ""
+			continue
+		}
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+			return nil, fmt.Errorf("parsing value of variable %s: %w", name, err)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// backupConfig writes a device configuration to a new file in dir and
+// returns its path. Configurations may hold secrets, so only the owner can
+// read the file.
+func backupConfig(dir, deviceName string, config json.RawMessage, now time.Time) (string, error) {
+	var doc bytes.Buffer
+	if err := json.Indent(&doc, config, "", "  "); err != nil {
+		return "", fmt.Errorf("formatting configuration backup: %w", err)
+	}
+	doc.WriteByte('\n')
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("creating backup directory: %w", err)
+	}
+	name := fmt.Sprintf("%s-config-%s.json", deviceName, now.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("creating configuration backup: %w", err)
+	}
+	if _, err := f.Write(doc.Bytes()); err != nil {
+		f.Close()
+		return "", fmt.Errorf("writing configuration backup: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("writing configuration backup: %w", err)
+	}
+	return path, nil
+}
+
+// readConfigFile reads a YAML or JSON configuration file and returns it as
+// a JSON document. The top level must be a mapping.
+func readConfigFile(path string) (json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading configuration file: %w", err)
+	}
+
+	// YAML is a superset of JSON, so one decoder handles both formats.
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing configuration file %s: %w", path, err)
+	}
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("configuration file %s must contain a mapping at the top level", path)
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("converting configuration file %s to JSON: %w", path, err)
+	}
+	return out, nil
+}
+
+// writeConfigDiff prints the line changes between two configurations,
+// rendered as indented JSON with sorted keys.
+func writeConfigDiff(w io.Writer, oldConfig, newConfig json.RawMessage) error {
+	oldLines, err := configLines(oldConfig)
+	if err != nil {
+		return err
+	}
+	newLines, err := configLines(newConfig)
+	if err != nil {
+		return err
+	}
+
+	changes := diffLines(oldLines, newLines)
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "  (no changes)")
+		return err
+	}
+	for _, line := range changes {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configLines renders a configuration as indented JSON lines.
+func configLines(config json.RawMessage) ([]string, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(config, &doc); err != nil {
+		return nil, fmt.Errorf("decoding configuration: %w", err)
+	}
+	pretty, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(pretty), "\n"), nil
+}
+
+// redactConfig replaces the values of secret-looking keys.
+func redactConfig(config json.RawMessage) (json.RawMessage, error) {
+	if len(config) == 0 {
+		return config, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(config, &doc); err != nil {
+		return nil, fmt.Errorf("decoding configuration: %w", err)
+	}
+	return json.Marshal(redactValue(doc))
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if isSecretKey(k) {
+				t[k] = redactedValue
+				continue
+			}
+			t[k] = redactValue(child)
+		}
+	case []interface{}:
+		for i, child := range t {
+			t[i] = redactValue(child)
+		}
+	}
+	return v
+}
+
+// isSecretKey reports whether a configuration key names a secret.
+func isSecretKey(key string) bool {
+	normalized := strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	for _, marker := range secretKeyMarkers {
+		if strings.Contains(normalized, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// confirm asks a yes/no question, defaulting to no.
+func confirm(in io.Reader, w io.Writer, question string) (bool, error) {
+	if _, err := fmt.Fprintf(w, "%s [y/N]: ", question); err != nil {
+		return false, err
+	}
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("reading confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
 }
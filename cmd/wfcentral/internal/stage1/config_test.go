@@ -0,0 +1,205 @@
+package stage1
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+)
+
+// configRoutes answers the requests of the config commands for device
+// dev-1, whose current configuration is {"mode":"old"}. Validation renders
+// a site into the submitted configuration.
+func configRoutes() map[string]interface{} {
+	return withDevices(map[string]interface{}{
+		"GET " + api.DeviceConfigPath("dev-1"): api.DeviceConfigResponse{
+			DeviceID: "dev-1",
+			Config:   json.RawMessage(`{"mode":"old"}`),
+			Hash:     "abc",
+			Version:  1,
+		},
+		"POST " + api.DeviceConfigValidatePath("dev-1"): api.ConfigValidationResponse{
+			TemplateID:   "tpl-1",
+			TemplateName: "edge",
+			Valid:        true,
+			Config:       json.RawMessage(`{"mode":"new","site":"plant-3"}`),
+		},
+		"POST " + api.DeviceConfigPath("dev-1"): api.ConfigApplyResponse{
+			Deployment: &config.Deployment{
+				ID:            "dep-1",
+				DeviceID:      "dev-1",
+				Status:        config.DeploymentStatusPending,
+				ConfigVersion: &config.Version{Number: 2, Hash: "def"},
+			},
+		},
+	})
+}
+
+func TestConfigApplyBacksUpCurrentConfig(t *testing.T) {
+	backupDir := filepath.Join(t.TempDir(), "backups")
+	routes := configRoutes()
+
+	// The backup must be on disk before the new configuration is applied.
+	apply := routes["POST "+api.DeviceConfigPath("dev-1")]
+	routes["POST "+api.DeviceConfigPath("dev-1")] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matches, err := filepath.Glob(filepath.Join(backupDir, "edge-1-config-*.json"))
+		if err != nil || len(matches) != 1 {
+			apierror.Write(w, apierror.BadRequest("no backup written before apply"))
+			return
+		}
+		_ = apierror.WriteJSON(w, http.StatusOK, apply)
+	})
+	central, cfg := newFakeCentral(t, routes)
+
+	out, err := runCommand(t, cfg, "y\n", "device", "config", "apply", "dev-1",
+		"--config", writeConfigFile(t, "mode: new\n"), "--backup-dir", backupDir)
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "Backed up the current configuration to "+backupDir)
+	assert.Contains(t, out, "Created configuration version 2 (hash def).")
+	assert.Contains(t, out, "Deployment dep-1 is pending.")
+
+	matches, err := filepath.Glob(filepath.Join(backupDir, "edge-1-config-*.json"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	info, err := os.Stat(matches[0])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	data, err := os.ReadFile(matches[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"mode":"old"}`, string(data))
+
+	var req api.ConfigRequest
+	central.request(http.MethodPost, api.DeviceConfigPath("dev-1")).decodeBody(t, &req)
+	assert.Equal(t, "tpl-1", req.Template, "the validated template is pinned")
+	assert.JSONEq(t, `{"mode":"new"}`, string(req.Config))
+}
+
+func TestConfigApplyWithoutBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		stdin   string
+		args    []string
+		applied bool
+	}{
+		{name: "no backup", args: []string{"--force", "--no-backup"}, applied: true},
+		{name: "dry run", args: []string{"--dry-run"}},
+		{name: "declined", stdin: "n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backupDir := filepath.Join(t.TempDir(), "backups")
+			central, cfg := newFakeCentral(t, configRoutes())
+
+			args := append([]string{"device", "config", "apply", "dev-1",
+				"--config", writeConfigFile(t, "mode: new\n"), "--backup-dir", backupDir}, tt.args...)
+			out, err := runCommand(t, cfg, tt.stdin, args...)
+			require.NoError(t, err, out)
+			assert.NotContains(t, out, "Backed up")
+			assert.NoDirExists(t, backupDir)
+
+			applied := central.received(http.MethodPost, api.DeviceConfigPath("dev-1"))
+			assert.Equal(t, tt.applied, len(applied) == 1)
+		})
+	}
+}
+
+func TestConfigCommands(t *testing.T) {
+	runCLITests(t, configRoutes, []cliTest{
+		{
+			name: "show",
+			args: []string{"device", "config", "show", "dev-1"},
+			want: []string{"edge-1 (dev-1)", "abc", "\nmode: old\n"},
+		},
+		{
+			name: "show resolves devices by name",
+			args: []string{"device", "config", "show", "edge-1", "-o", "json"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Equal(t, "name=edge-1", central.request(http.MethodGet, api.PathDevices).query)
+				var resp api.DeviceConfigResponse
+				require.NoError(t, json.Unmarshal([]byte(out), &resp))
+				assert.Equal(t, 1, resp.Version)
+				assert.JSONEq(t, `{"mode":"old"}`, string(resp.Config))
+			},
+		},
+		{
+			name:    "show unknown device",
+			args:    []string{"device", "config", "show", "edge-9"},
+			wantErr: `device "edge-9" not found`,
+		},
+		{
+			name: "validate",
+			args: []string{"device", "config", "validate", "dev-1", "--config", configArg, "--template", "edge"},
+			want: []string{"is valid for device edge-1 (template edge).\n"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.ConfigRequest
+				central.request(http.MethodPost, api.DeviceConfigValidatePath("dev-1")).decodeBody(t, &req)
+				assert.Equal(t, "edge", req.Template)
+				assert.JSONEq(t, `{"mode":"new"}`, string(req.Config))
+				assert.Empty(t, central.received(http.MethodGet, api.DeviceConfigPath("dev-1")),
+					"the current configuration is only fetched for the diff")
+			},
+		},
+		{
+			name: "validate with variables",
+			args: []string{"device", "config", "validate", "dev-1", "--config", configArg,
+				"--var", "network.port=8443", "--var", "site=plant-3", "--var", `label="8080"`, "--var", "note="},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.ConfigRequest
+				central.request(http.MethodPost, api.DeviceConfigValidatePath("dev-1")).decodeBody(t, &req)
+				assert.Equal(t, map[string]interface{}{
+					"network.port": float64(8443),
+					"site":         "plant-3",
+					"label":        "8080",
+					"note":         "",
+				}, req.Variables)
+			},
+		},
+		{
+			name: "validate verbose shows the diff",
+			args: []string{"device", "config", "validate", "dev-1", "--config", configArg, "--verbose"},
+			want: []string{"Template: edge (tpl-1)", `+   "site": "plant-3"`},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				central.request(http.MethodGet, api.DeviceConfigPath("dev-1"))
+			},
+		},
+		{
+			name:    "validate needs a file",
+			args:    []string{"device", "config", "validate", "dev-1"},
+			wantErr: `required flag(s) "config" not set`,
+		},
+	})
+
+	runCLITests(t, func() map[string]interface{} {
+		routes := configRoutes()
+		routes["POST "+api.DeviceConfigValidatePath("dev-1")] = api.ConfigValidationResponse{
+			TemplateID:   "tpl-1",
+			TemplateName: "edge",
+			Violations: []config.Violation{
+				{Path: "/mode", Keyword: "enum", Message: "value must be one of \"old\", \"new\""},
+			},
+		}
+		return routes
+	}, []cliTest{
+		{
+			name:    "validate lists violations",
+			args:    []string{"device", "config", "validate", "dev-1", "--config", configArg},
+			want:    []string{"is invalid for device edge-1:\n  - /mode: value must be one of \"old\", \"new\"\n"},
+			wantErr: "configuration validation failed with 1 violation(s)",
+		},
+		{
+			name:    "apply refuses invalid configurations",
+			args:    []string{"device", "config", "apply", "dev-1", "--config", configArg, "--force"},
+			wantErr: "configuration validation failed with 1 violation(s)",
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Empty(t, central.received(http.MethodPost, api.DeviceConfigPath("dev-1")))
+			},
+		},
+	})
+}
@@ -2,14 +2,39 @@ package stage1
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/wrale/wrale-fleet/cmd/wfcentral/options"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
 )
 
+// defaultStatusHistory is the number of reports shown by --history without
+// an explicit count.
+const defaultStatusHistory = 20
+
+// deviceListOptions holds the flags of the device list command
+type deviceListOptions struct {
+	output string
+	name   string
+	status string
+	tags   map[string]string
+	match  string
+}
+
 // newDeviceListCmd creates the device list command
 func newDeviceListCmd(cfg *options.Config) (*cobra.Command, error) {
+	opts := &deviceListOptions{}
+
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all registered devices",
@@ -22,53 +47,94 @@ the status and health commands.`,
   wfcentral device list
 
   # List devices with detailed output
-  wfcentral device list --output wide`,
+  wfcentral device list --output wide
+
+  # List offline devices in the lab as YAML
+  wfcentral device list --status offline --tag site=lab --output yaml
+
+  # List devices matching an expression over device fields
+  wfcentral device list --match 'tags.site == "plant-3" && network.hostname =~ "^pi-"'`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return listDevices(cmd.Context(), cfg)
+			return listDevices(cmd.Context(), cmd.OutOrStdout(), cfg, opts)
 		},
 	}
 
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable,
+		"output format (table, wide, json, yaml)")
+	cmd.Flags().StringVar(&opts.name, "name", "",
+		"only list devices with this name")
+	cmd.Flags().StringVar(&opts.status, "status", "",
+		"only list devices with this status (online, offline, error, maintenance, unknown)")
+	cmd.Flags().StringToStringVar(&opts.tags, "tag", nil,
+		"only list devices carrying these tags (key=value, repeatable)")
+	cmd.Flags().StringVar(&opts.match, "match", "",
+		"only list devices matching this expression over device fields")
+
 	return cmd, nil
 }
 
 // newDeviceStatusCmd creates the device status command
 func newDeviceStatusCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		output  string
+		history int
+	)
+
 	cmd := &cobra.Command{
 		Use:   "status NAME",
 		Short: "Show device status",
 		Long: `Display detailed status information for a specific device.
 
-This command shows comprehensive status information including:
-- Connection state and history
-- Current configuration
-- Resource utilization
-- Recent events and alerts`,
+The device can be given by name or ID. This command shows:
+- Connection state and the most recent health report
+- Current configuration version
+- Network identity and tags
+
+With --history the recent health reports are listed as well, showing how
+the device's state changed over time.`,
 		Example: `  # Show status for a device
   wfcentral device status device-1
 
   # Show status with full history
-  wfcentral device status device-1 --history`,
+  wfcentral device status device-1 --history
+
+  # Show the last 50 reports as JSON
+  wfcentral device status device-1 --history=50 --output json`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return showDeviceStatus(cmd.Context(), cfg, args[0])
+			return showDeviceStatus(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], output, history)
 		},
 	}
 
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+	cmd.Flags().IntVar(&history, "history", 0,
+		"include up to this many recent health reports")
+	cmd.Flags().Lookup("history").NoOptDefVal = fmt.Sprint(defaultStatusHistory)
+
 	return cmd, nil
 }
 
 // newDeviceHealthCmd creates the device health command
 func newDeviceHealthCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		output   string
+		extended bool
+	)
+
 	cmd := &cobra.Command{
 		Use:   "health NAME",
 		Short: "Show device health metrics",
 		Long: `Display detailed health metrics for a specific device.
 
-This command shows comprehensive health information including:
-- System metrics (CPU, memory, disk)
-- Service status
-- Recent health checks
-- Performance metrics`,
+The device can be given by name or ID. This command shows the latest
+health report received from the device agent, including:
+- Overall health and readiness
+- Agent version and uptime
+- Status of each monitored component
+
+With --extended each component's last check, last error, version and
+connection statistics are shown as well.`,
 		Example: `  # Show health metrics for a device
   wfcentral device health device-1
 
@@ -76,24 +142,276 @@ This command shows comprehensive health information including:
   wfcentral device health device-1 --extended`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return showDeviceHealth(cmd.Context(), cfg, args[0])
+			return showDeviceHealth(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], output, extended)
 		},
 	}
 
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+	cmd.Flags().BoolVar(&extended, "extended", false,
+		"show per-component details and connection statistics")
+
 	return cmd, nil
 }
 
 // listDevices implements the device list command functionality
-func listDevices(ctx context.Context, cfg *options.Config) error {
-	return fmt.Errorf("not implemented")
+func listDevices(ctx context.Context, w io.Writer, cfg *options.Config, opts *deviceListOptions) error {
+	if err := checkOutput(opts.output, outputTable, outputWide, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	devices, err := c.ListDevices(ctx, api.DeviceFilter{
+		Name:   opts.name,
+		Status: device.Status(opts.status),
+		Tags:   opts.tags,
+		Match:  opts.match,
+	})
+	if err != nil {
+		return err
+	}
+	switch opts.output {
+	case outputJSON, outputYAML:
+		sortDevices(devices)
+		return writeStructured(w, opts.output, api.DeviceListResponse{Devices: devices})
+	}
+
+	if len(devices) == 0 {
+		_, err := fmt.Fprintln(w, "No devices found.")
+		return err
+	}
+	return writeDeviceTable(w, devices, opts.output == outputWide)
+}
+
+// sortDevices orders devices by name, then ID
+func sortDevices(devices []*device.Device) {
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Name != devices[j].Name {
+			return devices[i].Name < devices[j].Name
+		}
+		return devices[i].ID < devices[j].ID
+	})
+}
+
+// writeDeviceTable lists devices ordered by name
+func writeDeviceTable(w io.Writer, devices []*device.Device, wide bool) error {
+	sortDevices(devices)
+
+	now := time.Now()
+	tw := newTable(w)
+	if wide {
+		fmt.Fprintln(tw, "NAME\tID\tSTATUS\tUPDATED\tIP ADDRESS\tHOSTNAME\tCONFIG\tTAGS\tCREATED")
+	} else {
+		fmt.Fprintln(tw, "NAME\tID\tSTATUS\tUPDATED")
+	}
+	for _, d := range devices {
+		if wide {
+			var ip, hostname string
+			if d.NetworkInfo != nil {
+				ip, hostname = d.NetworkInfo.IPAddress, d.NetworkInfo.Hostname
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				d.Name, d.ID, d.Status, formatAge(d.UpdatedAt, now),
+				orDash(ip), orDash(hostname), configVersion(d), formatTags(d.Tags),
+				formatAge(d.CreatedAt, now))
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Name, d.ID, d.Status, formatAge(d.UpdatedAt, now))
+	}
+	return tw.Flush()
+}
+
+// deviceStatusView is the structured output of the device status command.
+type deviceStatusView struct {
+	Device  *device.Device         `json:"device"`
+	Health  *health.DeviceReport   `json:"health,omitempty"`
+	History []*health.DeviceReport `json:"history,omitempty"`
 }
 
 // showDeviceStatus implements the device status command functionality
-func showDeviceStatus(ctx context.Context, cfg *options.Config, deviceName string) error {
-	return fmt.Errorf("not implemented")
+func showDeviceStatus(ctx context.Context, w io.Writer, cfg *options.Config, nameOrID, output string, history int) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+	if history < 0 {
+		return fmt.Errorf("history must not be negative")
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	dev, err := c.FindDevice(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+
+	view := deviceStatusView{Device: dev}
+	reports, err := c.DeviceHealth(ctx, dev.ID, history)
+	switch {
+	case err == nil:
+		view.Health = reports.Latest
+		view.History = reports.History
+	case !isNotFound(err):
+		return err
+	}
+
+	if output != outputTable {
+		return writeStructured(w, output, view)
+	}
+
+	now := time.Now()
+	tw := newTable(w)
+	fmt.Fprintf(tw, "Name:\t%s\n", dev.Name)
+	fmt.Fprintf(tw, "ID:\t%s\n", dev.ID)
+	fmt.Fprintf(tw, "Tenant:\t%s\n", dev.TenantID)
+	fmt.Fprintf(tw, "Status:\t%s\n", dev.Status)
+	if view.Health != nil {
+		fmt.Fprintf(tw, "Last Report:\t%s (%s ago)\n",
+			formatTime(view.Health.ReceivedAt), formatAge(view.Health.ReceivedAt, now))
+		fmt.Fprintf(tw, "Health:\t%s\n", view.Health.Status)
+	} else {
+		fmt.Fprintf(tw, "Last Report:\tnever\n")
+	}
+	fmt.Fprintf(tw, "Config:\t%s\n", configVersion(dev))
+	if dev.NetworkInfo != nil {
+		fmt.Fprintf(tw, "IP Address:\t%s\n", orDash(dev.NetworkInfo.IPAddress))
+		fmt.Fprintf(tw, "Hostname:\t%s\n", orDash(dev.NetworkInfo.Hostname))
+	}
+	fmt.Fprintf(tw, "Tags:\t%s\n", formatTags(dev.Tags))
+	fmt.Fprintf(tw, "Created:\t%s\n", formatTime(dev.CreatedAt))
+	fmt.Fprintf(tw, "Updated:\t%s\n", formatTime(dev.UpdatedAt))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if history == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	if len(view.History) == 0 {
+		_, err := fmt.Fprintln(w, "No health reports received.")
+		return err
+	}
+	fmt.Fprintln(w, "History:")
+	tw = newTable(w)
+	fmt.Fprintln(tw, "  RECEIVED\tHEALTH\tREADY\tVERSION\tUPTIME")
+	for _, r := range view.History {
+		fmt.Fprintf(tw, "  %s\t%s\t%t\t%s\t%s\n",
+			formatTime(r.ReceivedAt), r.Status, r.Ready, versionString(r.Version), formatUptime(r.Uptime))
+	}
+	return tw.Flush()
 }
 
 // showDeviceHealth implements the device health command functionality
-func showDeviceHealth(ctx context.Context, cfg *options.Config, deviceName string) error {
-	return fmt.Errorf("not implemented")
+func showDeviceHealth(ctx context.Context, w io.Writer, cfg *options.Config, nameOrID, output string, extended bool) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	dev, err := c.FindDevice(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+
+	reports, err := c.DeviceHealth(ctx, dev.ID, 0)
+	if err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("device %s (%s) has not reported health yet", dev.Name, dev.ID)
+		}
+		return err
+	}
+	report := reports.Latest
+
+	if output != outputTable {
+		return writeStructured(w, output, report)
+	}
+
+	now := time.Now()
+	tw := newTable(w)
+	fmt.Fprintf(tw, "Device:\t%s (%s)\n", dev.Name, dev.ID)
+	fmt.Fprintf(tw, "Health:\t%s\n", report.Status)
+	fmt.Fprintf(tw, "Ready:\t%t\n", report.Ready)
+	fmt.Fprintf(tw, "Version:\t%s\n", versionString(report.Version))
+	fmt.Fprintf(tw, "Uptime:\t%s\n", formatUptime(report.Uptime))
+	fmt.Fprintf(tw, "Reported:\t%s\n", formatTime(report.ReportedAt))
+	fmt.Fprintf(tw, "Received:\t%s (%s ago)\n", formatTime(report.ReceivedAt), formatAge(report.ReceivedAt, now))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(report.Components) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(report.Components))
+	for name := range report.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w)
+	tw = newTable(w)
+	if extended {
+		fmt.Fprintln(tw, "COMPONENT\tSTATUS\tMESSAGE\tLAST CHECKED\tLAST ERROR\tVERSION\tUPTIME\tCONNECTIONS\tFAILURES")
+	} else {
+		fmt.Fprintln(tw, "COMPONENT\tSTATUS\tMESSAGE")
+	}
+	for _, name := range names {
+		comp := report.Components[name]
+		if !extended {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, comp.Status, orDash(comp.Message))
+			continue
+		}
+		connections, failures := "-", "-"
+		if comp.Stats != nil {
+			connections = fmt.Sprintf("%d/%d", comp.Stats.ActiveConnections, comp.Stats.TotalConnections)
+			failures = fmt.Sprint(comp.Stats.ConnectionFailures)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name, comp.Status, orDash(comp.Message), formatAge(comp.LastChecked, now),
+			orDash(comp.LastError), versionString(comp.Version), formatUptime(comp.Uptime),
+			connections, failures)
+	}
+	return tw.Flush()
+}
+
+// isNotFound reports whether err is a 404 from the control plane.
+func isNotFound(err error) bool {
+	var apiErr *apierror.Error
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// configVersion describes the device's current configuration version.
+func configVersion(d *device.Device) string {
+	if len(d.ConfigHistory) == 0 {
+		return "-"
+	}
+	latest := d.ConfigHistory[len(d.ConfigHistory)-1]
+	return strings.TrimSpace(fmt.Sprintf("v%d %s", latest.Version, shortHash(latest.Hash)))
+}
+
+// versionString renders an agent version, or "-" when unknown.
+func versionString(v *health.Version) string {
+	if v == nil || v.Version == "" {
+		return "-"
+	}
+	return v.Version
+}
+
+// formatUptime renders an uptime rounded to the second.
+func formatUptime(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return d.Round(time.Second).String()
 }
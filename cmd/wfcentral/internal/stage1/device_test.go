@@ -0,0 +1,180 @@
+package stage1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+)
+
+// deviceRoutes answers the requests of the device commands. Device dev-1
+// has reported health twice, dev-2 never has.
+func deviceRoutes() map[string]interface{} {
+	now := time.Now().UTC()
+	older := &health.DeviceReport{
+		TenantID:   "tenant-a",
+		DeviceID:   "dev-1",
+		Status:     health.StatusDegraded,
+		Version:    &health.Version{Version: "1.2.0"},
+		Uptime:     time.Hour,
+		ReceivedAt: now.Add(-time.Minute),
+	}
+	latest := &health.DeviceReport{
+		TenantID: "tenant-a",
+		DeviceID: "dev-1",
+		Status:   health.StatusHealthy,
+		Ready:    true,
+		Version:  &health.Version{Version: "1.2.1"},
+		Uptime:   90 * time.Second,
+		Components: map[string]*health.HealthStatus{
+			"storage": {Status: health.StatusHealthy, LastChecked: now},
+			"network": {
+				Status:    health.StatusDegraded,
+				Message:   "packet loss",
+				LastError: "timeout",
+				Stats:     &health.ConnectionStats{ActiveConnections: 2, TotalConnections: 9, ConnectionFailures: 1},
+			},
+		},
+		ReportedAt: now,
+		ReceivedAt: now,
+	}
+	return withDevices(map[string]interface{}{
+		"GET " + api.DeviceHealthPath("dev-1"): http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp := api.DeviceHealthResponse{Latest: latest}
+			if r.URL.Query().Get("history") != "" {
+				resp.History = []*health.DeviceReport{latest, older}
+			}
+			_ = apierror.WriteJSON(w, http.StatusOK, resp)
+		}),
+	})
+}
+
+func TestDeviceListCommand(t *testing.T) {
+	runCLITests(t, deviceRoutes, []cliTest{
+		{
+			name: "table",
+			args: []string{"device", "list"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				require.Len(t, lines, 3)
+				assert.Equal(t, []string{"NAME", "ID", "STATUS", "UPDATED"}, strings.Fields(lines[0]))
+				assert.Equal(t, []string{"edge-1", "dev-1", "online"}, strings.Fields(lines[1])[:3])
+				assert.Equal(t, []string{"edge-2", "dev-2", "offline"}, strings.Fields(lines[2])[:3])
+			},
+		},
+		{
+			name: "wide",
+			args: []string{"device", "list", "-o", "wide"},
+			want: []string{"IP ADDRESS", "HOSTNAME", "CONFIG", "TAGS"},
+		},
+		{
+			name: "filters are sent to the control plane",
+			args: []string{"device", "list", "--status", "offline", "--tag", "site=lab", "--match", "tags.rack == \"r1\""},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				query := central.request(http.MethodGet, api.PathDevices).query
+				assert.Equal(t, "match=tags.rack+%3D%3D+%22r1%22&status=offline&tag=site%3Dlab", query)
+			},
+		},
+		{
+			name: "json",
+			args: []string{"device", "list", "-o", "json"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var resp api.DeviceListResponse
+				require.NoError(t, json.Unmarshal([]byte(out), &resp))
+				require.Len(t, resp.Devices, 2)
+				assert.Equal(t, "dev-1", resp.Devices[0].ID)
+			},
+		},
+		{
+			name:    "unsupported output",
+			args:    []string{"device", "list", "-o", "xml"},
+			wantErr: `unsupported output format "xml" (supported: table, wide, json, yaml)`,
+		},
+	})
+
+	runCLITests(t, func() map[string]interface{} {
+		return map[string]interface{}{
+			"GET " + api.PathDevices: api.DeviceListResponse{},
+		}
+	}, []cliTest{
+		{
+			name: "no devices",
+			args: []string{"device", "list"},
+			want: []string{"No devices found."},
+		},
+	})
+}
+
+func TestDeviceStatusCommand(t *testing.T) {
+	runCLITests(t, deviceRoutes, []cliTest{
+		{
+			name: "by name",
+			args: []string{"device", "status", "edge-1"},
+			want: []string{"dev-1", "tenant-a", "healthy"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Equal(t, "", central.request(http.MethodGet, api.DeviceHealthPath("dev-1")).query)
+				assert.NotContains(t, out, "History:")
+			},
+		},
+		{
+			name: "history",
+			args: []string{"device", "status", "dev-1", "--history"},
+			want: []string{"History:", "1.2.1", "1.2.0", "1h0m0s"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Equal(t, "history=20", central.request(http.MethodGet, api.DeviceHealthPath("dev-1")).query)
+			},
+		},
+		{
+			name: "never reported",
+			args: []string{"device", "status", "dev-2", "--history=5"},
+			want: []string{"never", "No health reports received."},
+		},
+		{
+			name:    "negative history",
+			args:    []string{"device", "status", "dev-1", "--history=-1"},
+			wantErr: "history must not be negative",
+		},
+		{
+			name:    "unknown device",
+			args:    []string{"device", "status", "edge-9"},
+			wantErr: `device "edge-9" not found`,
+		},
+	})
+}
+
+func TestDeviceHealthCommand(t *testing.T) {
+	runCLITests(t, deviceRoutes, []cliTest{
+		{
+			name: "components",
+			args: []string{"device", "health", "edge-1"},
+			want: []string{"edge-1 (dev-1)", "true", "1.2.1", "1m30s"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				assert.Equal(t, []string{"network", "degraded", "packet", "loss"}, strings.Fields(lines[len(lines)-2]))
+				assert.Equal(t, []string{"storage", "healthy", "-"}, strings.Fields(lines[len(lines)-1]))
+			},
+		},
+		{
+			name: "extended",
+			args: []string{"device", "health", "dev-1", "--extended"},
+			want: []string{"LAST ERROR", "CONNECTIONS", "timeout", "2/9"},
+		},
+		{
+			name: "yaml",
+			args: []string{"device", "health", "dev-1", "-o", "yaml"},
+			want: []string{"status: healthy\n", "device_id: dev-1\n"},
+		},
+		{
+			name:    "never reported",
+			args:    []string{"device", "health", "dev-2"},
+			wantErr: "device edge-2 (dev-2) has not reported health yet",
+		},
+	})
+}
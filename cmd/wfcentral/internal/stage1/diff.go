@@ -0,0 +1,82 @@
+package stage1
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// diffLines returns a line diff of a and b, prefixing removed lines with
+// "- ", added lines with "+ " and unchanged context with "  ". Unchanged
+// runs longer than the context are elided with "  ...". It returns nil when
+// the inputs are equal.
+func diffLines(a, b []string) []string {
+	// Longest common subsequence table, filled from the end.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type op struct {
+		kind byte // ' ', '-' or '+'
+		line string
+	}
+	var ops []op
+	changed := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			ops = append(ops, op{'+', b[j]})
+			changed = true
+			j++
+		default:
+			ops = append(ops, op{'-', a[i]})
+			changed = true
+			i++
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	// Keep only the context around changes.
+	keep := make([]bool, len(ops))
+	for k, o := range ops {
+		if o.kind == ' ' {
+			continue
+		}
+		for c := k - diffContext; c <= k+diffContext; c++ {
+			if c >= 0 && c < len(ops) {
+				keep[c] = true
+			}
+		}
+	}
+
+	var out []string
+	elided := false
+	for k, o := range ops {
+		if !keep[k] {
+			if !elided {
+				out = append(out, "  ...")
+				elided = true
+			}
+			continue
+		}
+		elided = false
+		out = append(out, string(o.kind)+" "+o.line)
+	}
+	return out
+}
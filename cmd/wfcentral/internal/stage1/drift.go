@@ -0,0 +1,77 @@
+package stage1
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wrale/wrale-fleet/cmd/wfcentral/options"
+)
+
+// newDeviceDriftCmd creates the device drift command
+func newDeviceDriftCmd(cfg *options.Config) (*cobra.Command, error) {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "List devices with configuration drift",
+		Long: `Display devices whose reported configuration differs from the
+configuration of their latest completed deployment.
+
+Devices report the hash of the configuration they run with every health
+report. The control plane compares it with the deployed configuration
+periodically; a device stays listed until it reports the expected hash
+again. When the server runs with --drift-remediate, the REMEDIATION column
+shows the deployment created to restore the expected configuration.`,
+		Example: `  # List drifted devices
+  wfcentral device drift
+
+  # List drifted devices as JSON
+  wfcentral device drift --output json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listDrift(cmd.Context(), cmd.OutOrStdout(), cfg, output)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// listDrift shows the tenant's drifted devices
+func listDrift(ctx context.Context, w io.Writer, cfg *options.Config, output string) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	drifts, err := c.ListDrift(ctx)
+	if err != nil {
+		return err
+	}
+
+	if output != outputTable {
+		return writeStructured(w, output, drifts)
+	}
+	if len(drifts) == 0 {
+		_, err := fmt.Fprintln(w, "No configuration drift detected.")
+		return err
+	}
+
+	now := time.Now()
+	tw := newTable(w)
+	fmt.Fprintln(tw, "DEVICE\tTEMPLATE\tVERSION\tEXPECTED\tREPORTED\tREMEDIATION\tDETECTED")
+	for _, d := range drifts {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			d.DeviceID, d.TemplateID, d.Version, shortHash(d.ExpectedHash), shortHash(d.ReportedHash),
+			orDash(d.RemediationID), formatAge(d.DetectedAt, now))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,87 @@
+package stage1
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/drift"
+)
+
+func TestDeviceDriftCommand(t *testing.T) {
+	drifted := func() map[string]interface{} {
+		return map[string]interface{}{
+			"GET " + api.PathDrift: api.DriftListResponse{Drift: []drift.Drift{
+				{
+					TenantID:     "tenant-a",
+					DeviceID:     "dev-1",
+					DeploymentID: "dep-1",
+					TemplateID:   "tpl-1",
+					Version:      3,
+					ExpectedHash: "0123456789abcdef",
+					ReportedHash: "fedcba9876543210",
+					DetectedAt:   time.Now().Add(-5 * time.Minute),
+				},
+				{
+					TenantID:      "tenant-a",
+					DeviceID:      "dev-2",
+					DeploymentID:  "dep-2",
+					TemplateID:    "tpl-1",
+					Version:       2,
+					ExpectedHash:  "abc",
+					ReportedHash:  "def",
+					DetectedAt:    time.Now().Add(-2 * time.Hour),
+					RemediationID: "dep-3",
+				},
+			}},
+		}
+	}
+
+	runCLITests(t, drifted, []cliTest{
+		{
+			name: "table",
+			args: []string{"device", "drift"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				require.Len(t, lines, 3)
+				assert.Equal(t, []string{"DEVICE", "TEMPLATE", "VERSION", "EXPECTED", "REPORTED", "REMEDIATION", "DETECTED"},
+					strings.Fields(lines[0]))
+				assert.Equal(t, []string{"dev-1", "tpl-1", "3", "0123456789ab", "fedcba987654", "-", "5m"},
+					strings.Fields(lines[1]))
+				assert.Equal(t, []string{"dev-2", "tpl-1", "2", "abc", "def", "dep-3", "2h"},
+					strings.Fields(lines[2]))
+			},
+		},
+		{
+			name: "json",
+			args: []string{"device", "drift", "-o", "json"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var drifts []drift.Drift
+				require.NoError(t, json.Unmarshal([]byte(out), &drifts))
+				require.Len(t, drifts, 2)
+				assert.Equal(t, "fedcba9876543210", drifts[0].ReportedHash)
+			},
+		},
+		{
+			name:    "takes no arguments",
+			args:    []string{"device", "drift", "dev-1"},
+			wantErr: `unknown command "dev-1"`,
+		},
+	})
+
+	runCLITests(t, func() map[string]interface{} {
+		return map[string]interface{}{
+			"GET " + api.PathDrift: api.DriftListResponse{Drift: []drift.Drift{}},
+		}
+	}, []cliTest{
+		{
+			name: "no drift",
+			args: []string{"device", "drift"},
+			want: []string{"No configuration drift detected.\n"},
+		},
+	})
+}
@@ -0,0 +1,954 @@
+package stage1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wrale/wrale-fleet/cmd/wfcentral/options"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+)
+
+// groupQueryOptions holds the flags selecting the members of a dynamic
+// group
+type groupQueryOptions struct {
+	tags    map[string]string
+	status  string
+	regions []string
+	match   string
+}
+
+// addFlags registers the query flags on cmd
+func (o *groupQueryOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringToStringVar(&o.tags, "tag", nil,
+		"members carry these tags (key=value, repeatable)")
+	cmd.Flags().StringVar(&o.status, "status", "",
+		"members have this status (online, offline, error, maintenance, unknown)")
+	cmd.Flags().StringSliceVar(&o.regions, "region", nil,
+		"members' region tag is one of these regions (repeatable)")
+	cmd.Flags().StringVar(&o.match, "match", "",
+		"members match this expression over device fields")
+}
+
+// query builds the membership query, or nil when no query flag was set
+func (o *groupQueryOptions) query() *group.MembershipQuery {
+	if len(o.tags) == 0 && o.status == "" && len(o.regions) == 0 && o.match == "" {
+		return nil
+	}
+	q := &group.MembershipQuery{
+		Tags:    o.tags,
+		Status:  device.Status(o.status),
+		Regions: o.regions,
+	}
+	if o.match != "" {
+		q.Custom = group.CustomExpression(o.match)
+	}
+	return q
+}
+
+// groupCreateOptions holds the flags of the group create command
+type groupCreateOptions struct {
+	description string
+	parent      string
+	dynamic     bool
+	query       groupQueryOptions
+	output      string
+}
+
+// newGroupCreateCmd creates the group create command
+func newGroupCreateCmd(cfg *options.Config) (*cobra.Command, error) {
+	opts := &groupCreateOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create a device group",
+		Long: `Create a device group, optionally beneath a parent group.
+
+Static groups hold the devices added to them with "group add". Dynamic
+groups hold every device matching their query, and their membership
+follows devices as they change. Setting any query flag creates a dynamic
+group; --match takes an expression over device fields such as
+  tags.site == "plant-3" && status in ["online", "maintenance"]`,
+		Example: `  # Create a region group
+  wfcentral group create eu --description "European sites"
+
+  # Create a dynamic group of a site's Raspberry Pis beneath it
+  wfcentral group create plant-3-pis --parent eu \
+    --match 'tags.site == "plant-3" && network.hostname =~ "^pi-"'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return createGroup(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.description, "description", "",
+		"description of the group")
+	cmd.Flags().StringVar(&opts.parent, "parent", "",
+		"name or ID of the group to create the group beneath")
+	cmd.Flags().BoolVar(&opts.dynamic, "dynamic", false,
+		"create a dynamic group; implied by the query flags")
+	opts.query.addFlags(cmd)
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newGroupListCmd creates the group list command
+func newGroupListCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		groupType string
+		parent    string
+		output    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List all groups",
+		Long: `Display the device groups of the tenant, ordered by name, with their
+type, place in the hierarchy and number of devices.`,
+		Example: `  # List all groups
+  wfcentral group list
+
+  # List the dynamic groups beneath a region
+  wfcentral group list --type dynamic --parent eu`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listGroups(cmd.Context(), cmd.OutOrStdout(), cfg, group.Type(groupType), parent, output)
+		},
+	}
+
+	cmd.Flags().StringVar(&groupType, "type", "",
+		"only list groups of this type (static, dynamic)")
+	cmd.Flags().StringVar(&parent, "parent", "",
+		"only list the direct children of this group")
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, wide, json, yaml)")
+
+	return cmd, nil
+}
+
+// newGroupShowCmd creates the group show command
+func newGroupShowCmd(cfg *options.Config) (*cobra.Command, error) {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "show NAME",
+		Short: "Show a group",
+		Long: `Display a device group: its type, place in the hierarchy, query and
+configuration. The group can be given by name or ID.`,
+		Example: `  # Show a group
+  wfcentral group show eu`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return groupAction(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], output,
+				func(ctx context.Context, c *client.Client, g *group.Group) (*group.Group, error) {
+					return g, nil
+				})
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newGroupStatusCmd creates the group status command
+func newGroupStatusCmd(cfg *options.Config) (*cobra.Command, error) {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "status NAME",
+		Short: "Show device status across a group and its subgroups",
+		Long: `Display how many devices a group and every group beneath it hold, by
+status, how many of them passed their last compliance check, and how many
+run a configuration that drifted from their latest deployment. A device
+in several of the groups is counted once.`,
+		Example: `  # Show the state of a region
+  wfcentral group status eu`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return showGroupStatus(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], output)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newGroupUpdateCmd creates the group update command
+func newGroupUpdateCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		name        string
+		description string
+		match       string
+		variables   []string
+		unset       []string
+		output      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "update NAME",
+		Short: "Change the settings of a group",
+		Long: `Change the name, description, template variables or, for a dynamic
+group, the --match expression of a group. Settings that are not given are
+kept. Membership of a dynamic group is evaluated again when its
+expression changes.
+
+Template variables set with --var are used when a configuration is
+applied to a device of the group or of its subgroups, unless a subgroup
+or the device sets the variable itself. Values are read as YAML, so 8080
+is a number and true a boolean.`,
+		Example: `  # Rename a group
+  wfcentral group update eu --name europe
+
+  # Narrow a dynamic group to online devices
+  wfcentral group update plant-3-pis --match 'tags.site == "plant-3" && status == "online"'
+
+  # Set template variables for the devices of a group
+  wfcentral group update plant-3-pis --var site=plant-3 --var network.port=8443`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			vars, err := parseVariables(variables)
+			if err != nil {
+				return err
+			}
+			return groupAction(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], output,
+				func(ctx context.Context, c *client.Client, g *group.Group) (*group.Group, error) {
+					req := &api.GroupUpdateRequest{
+						Name:        g.Name,
+						Description: g.Description,
+						Query:       g.Query,
+						Properties:  g.Properties,
+					}
+					if flags.Changed("name") {
+						req.Name = name
+					}
+					if flags.Changed("description") {
+						req.Description = description
+					}
+					if flags.Changed("match") {
+						if g.Type != group.TypeDynamic {
+							return nil, fmt.Errorf("group %s is static; only dynamic groups have an expression", g.Name)
+						}
+						if req.Query == nil {
+							req.Query = &group.MembershipQuery{}
+						}
+						req.Query.Custom = nil
+						if match != "" {
+							req.Query.Custom = group.CustomExpression(match)
+						}
+					}
+					if len(unset) > 0 || len(vars) > 0 {
+						if err := setGroupVariables(&req.Properties, vars, unset); err != nil {
+							return nil, err
+						}
+					}
+					return c.UpdateGroup(ctx, g.ID, req)
+				})
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "",
+		"new name of the group")
+	cmd.Flags().StringVar(&description, "description", "",
+		"new description of the group")
+	cmd.Flags().StringVar(&match, "match", "",
+		"new membership expression of a dynamic group (empty removes it)")
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"set a template variable as NAME=VALUE (repeatable)")
+	cmd.Flags().StringArrayVar(&unset, "unset-var", nil,
+		"remove a template variable (repeatable)")
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newGroupDeleteCmd creates the group delete command
+func newGroupDeleteCmd(cfg *options.Config) (*cobra.Command, error) {
+	cmd := &cobra.Command{
+		Use:   "delete NAME",
+		Short: "Delete a group and its subgroups",
+		Long: `Delete a device group together with every group beneath it. The
+devices themselves are not affected.`,
+		Example: `  # Delete a group
+  wfcentral group delete plant-3-pis`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return deleteGroup(cmd.Context(), cmd.OutOrStdout(), cfg, args[0])
+		},
+	}
+
+	return cmd, nil
+}
+
+// newGroupMoveCmd creates the group move command
+func newGroupMoveCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		parent string
+		root   bool
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "move NAME",
+		Short: "Move a group in the hierarchy",
+		Long: `Move a group, with every group beneath it, under a new parent with
+--parent, or to the top of the hierarchy with --root. Moves that would
+place a group beneath itself are refused.`,
+		Example: `  # Move a site beneath a region
+  wfcentral group move plant-3 --parent eu
+
+  # Make a group a root group
+  wfcentral group move plant-3 --root`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if parent == "" && !root {
+				return fmt.Errorf("either --parent or --root is required")
+			}
+			return groupAction(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], output,
+				func(ctx context.Context, c *client.Client, g *group.Group) (*group.Group, error) {
+					parentID := ""
+					if parent != "" {
+						p, err := c.FindGroup(ctx, parent)
+						if err != nil {
+							return nil, err
+						}
+						parentID = p.ID
+					}
+					return c.MoveGroup(ctx, g.ID, parentID)
+				})
+		},
+	}
+
+	cmd.Flags().StringVar(&parent, "parent", "",
+		"name or ID of the new parent group")
+	cmd.Flags().BoolVar(&root, "root", false,
+		"move the group to the top of the hierarchy")
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+	cmd.MarkFlagsMutuallyExclusive("parent", "root")
+
+	return cmd, nil
+}
+
+// newGroupAddCmd creates the group add command
+func newGroupAddCmd(cfg *options.Config) (*cobra.Command, error) {
+	cmd := &cobra.Command{
+		Use:   "add NAME DEVICE...",
+		Short: "Add devices to a static group",
+		Long: `Add one or more devices, given by name or ID, to a static group.
+Dynamic groups take their members from their query instead.`,
+		Example: `  # Add two devices to a group
+  wfcentral group add lab device-1 device-2`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return changeGroupDevices(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], args[1:], true)
+		},
+	}
+
+	return cmd, nil
+}
+
+// newGroupRemoveCmd creates the group remove command
+func newGroupRemoveCmd(cfg *options.Config) (*cobra.Command, error) {
+	cmd := &cobra.Command{
+		Use:   "remove NAME DEVICE...",
+		Short: "Remove devices from a static group",
+		Long:  `Remove one or more devices, given by name or ID, from a static group.`,
+		Example: `  # Remove a device from a group
+  wfcentral group remove lab device-1`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return changeGroupDevices(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], args[1:], false)
+		},
+	}
+
+	return cmd, nil
+}
+
+// newGroupDevicesCmd creates the group devices command
+func newGroupDevicesCmd(cfg *options.Config) (*cobra.Command, error) {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "devices NAME",
+		Short: "List the devices of a group",
+		Long: `Display the devices of a group. The members of a dynamic group are
+the devices currently matching its query.`,
+		Example: `  # List the devices of a group
+  wfcentral group devices plant-3-pis`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listGroupDevices(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], output)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, wide, json, yaml)")
+
+	return cmd, nil
+}
+
+// newGroupTreeCmd creates the group tree command
+func newGroupTreeCmd(cfg *options.Config) (*cobra.Command, error) {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "tree [NAME]",
+		Short: "Show the group hierarchy",
+		Long: `Display the group hierarchy of the tenant as a tree, or only the
+subtree beneath the given group, with each group's type and number of
+devices.`,
+		Example: `  # Show the whole hierarchy
+  wfcentral group tree
+
+  # Show the groups beneath a region
+  wfcentral group tree eu`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := ""
+			if len(args) == 1 {
+				root = args[0]
+			}
+			return showGroupTree(cmd.Context(), cmd.OutOrStdout(), cfg, root, output)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newGroupDeployCmd creates the group deploy command
+func newGroupDeployCmd(cfg *options.Config) (*cobra.Command, error) {
+	opts := &rolloutStartOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "deploy NAME CONFIG",
+		Short: "Deploy a configuration to a group",
+		Long: `Roll out a configuration file to the devices of a group. The file
+becomes a new version of the template after it is validated against the
+template schema.
+
+This is "rollout start" with the group given by name or ID and the
+configuration file as an argument; see "wfcentral rollout start --help"
+for how waves are formed and when a rollout halts.`,
+		Example: `  # Deploy a configuration to a group at once
+  wfcentral group deploy plant-3-pis edge.yaml --template edge
+
+  # Deploy in canary waves
+  wfcentral group deploy plant-3-pis edge.yaml --template edge --canary 10 --batch-size 20`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := options.NewClient(cfg)
+			if err != nil {
+				return err
+			}
+			g, err := c.FindGroup(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			opts.configFile = args[1]
+			return startRollout(cmd.Context(), cmd.OutOrStdout(), cfg, g.ID, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.template, "template", "",
+		"configuration template name or ID")
+	addStrategyFlags(cmd, &opts.strategy)
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	if err := cmd.MarkFlagRequired("template"); err != nil {
+		return nil, fmt.Errorf("marking template flag as required: %w", err)
+	}
+
+	return cmd, nil
+}
+
+// newGroupFsckCmd creates the group fsck command
+func newGroupFsckCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		dryRun bool
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Check and repair the group hierarchy",
+		Long: `Check the group hierarchy of the tenant and repair it.
+
+Each group's parent is taken as the truth: paths, depths and lists of
+children are rebuilt from it. Groups whose parent no longer exists, and
+one group of each cycle of parents, are moved beneath a root group named
+"quarantine", created when needed, from where they can be moved back
+with "group move".
+
+Use --dry-run to see the repairs without making them.`,
+		Example: `  # Show what would be repaired
+  wfcentral group fsck --dry-run
+
+  # Repair the hierarchy
+  wfcentral group fsck`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return repairGroups(cmd.Context(), cmd.OutOrStdout(), cfg, dryRun, output)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false,
+		"report the repairs without making them")
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// createGroup implements the group create command functionality
+func createGroup(ctx context.Context, w io.Writer, cfg *options.Config, name string, opts *groupCreateOptions) error {
+	if err := checkOutput(opts.output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	req := &api.GroupRequest{
+		Name:        name,
+		Description: opts.description,
+		Type:        group.TypeStatic,
+		Query:       opts.query.query(),
+	}
+	if opts.dynamic || req.Query != nil {
+		req.Type = group.TypeDynamic
+		if req.Query == nil {
+			req.Query = &group.MembershipQuery{}
+		}
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	if opts.parent != "" {
+		parent, err := c.FindGroup(ctx, opts.parent)
+		if err != nil {
+			return err
+		}
+		req.Parent = parent.ID
+	}
+
+	g, err := c.CreateGroup(ctx, req)
+	if err != nil {
+		return err
+	}
+	return writeGroup(w, g, opts.output)
+}
+
+// listGroups implements the group list command functionality
+func listGroups(ctx context.Context, w io.Writer, cfg *options.Config, groupType group.Type, parent, output string) error {
+	if err := checkOutput(output, outputTable, outputWide, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	parentID := ""
+	if parent != "" {
+		p, err := c.FindGroup(ctx, parent)
+		if err != nil {
+			return err
+		}
+		parentID = p.ID
+	}
+	groups, err := c.ListGroups(ctx, groupType, parentID, "")
+	if err != nil {
+		return err
+	}
+
+	if output == outputJSON || output == outputYAML {
+		return writeStructured(w, output, groups)
+	}
+	if len(groups) == 0 {
+		_, err := fmt.Fprintln(w, "No groups found.")
+		return err
+	}
+
+	names := make(map[string]string, len(groups))
+	for _, g := range groups {
+		names[g.ID] = g.Name
+	}
+
+	now := time.Now()
+	tw := newTable(w)
+	if output == outputWide {
+		fmt.Fprintln(tw, "NAME\tID\tTYPE\tPARENT\tDEPTH\tDEVICES\tQUERY\tAGE")
+	} else {
+		fmt.Fprintln(tw, "NAME\tTYPE\tPARENT\tDEVICES\tAGE")
+	}
+	for _, g := range groups {
+		parentName := g.ParentID
+		if name, ok := names[g.ParentID]; ok {
+			parentName = name
+		}
+		if output == outputWide {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+				g.Name, g.ID, g.Type, orDash(parentName), g.Ancestry.Depth, g.DeviceCount,
+				formatQuery(g.Query), formatAge(g.CreatedAt, now))
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n",
+			g.Name, g.Type, orDash(parentName), g.DeviceCount, formatAge(g.CreatedAt, now))
+	}
+	return tw.Flush()
+}
+
+// groupAction resolves a group by name or ID, runs a request returning a
+// single group and shows it
+func groupAction(ctx context.Context, w io.Writer, cfg *options.Config, nameOrID, output string,
+	do func(ctx context.Context, c *client.Client, g *group.Group) (*group.Group, error)) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	g, err := c.FindGroup(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+	g, err = do(ctx, c, g)
+	if err != nil {
+		return err
+	}
+	return writeGroup(w, g, output)
+}
+
+// deleteGroup implements the group delete command functionality
+func deleteGroup(ctx context.Context, w io.Writer, cfg *options.Config, nameOrID string) error {
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	g, err := c.FindGroup(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+	if err := c.DeleteGroup(ctx, g.ID); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Group %s (%s) deleted.\n", g.Name, g.ID)
+	return err
+}
+
+// changeGroupDevices adds devices to or removes them from a static group
+func changeGroupDevices(ctx context.Context, w io.Writer, cfg *options.Config, nameOrID string, devices []string, add bool) error {
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	g, err := c.FindGroup(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range devices {
+		dev, err := c.FindDevice(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if add {
+			if _, err := c.AddGroupDevice(ctx, g.ID, dev.ID); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Device %s added to group %s.\n", dev.Name, g.Name)
+			continue
+		}
+		if err := c.RemoveGroupDevice(ctx, g.ID, dev.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Device %s removed from group %s.\n", dev.Name, g.Name)
+	}
+	return nil
+}
+
+// listGroupDevices implements the group devices command functionality
+func listGroupDevices(ctx context.Context, w io.Writer, cfg *options.Config, nameOrID, output string) error {
+	if err := checkOutput(output, outputTable, outputWide, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	g, err := c.FindGroup(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+	devices, err := c.GroupDevices(ctx, g.ID)
+	if err != nil {
+		return err
+	}
+
+	if output == outputJSON || output == outputYAML {
+		return writeStructured(w, output, devices)
+	}
+	if len(devices) == 0 {
+		_, err := fmt.Fprintf(w, "Group %s has no devices.\n", g.Name)
+		return err
+	}
+	return writeDeviceTable(w, devices, output == outputWide)
+}
+
+// rollupStatuses orders the statuses shown by the group status command
+var rollupStatuses = []device.Status{
+	device.StatusOnline,
+	device.StatusOffline,
+	device.StatusError,
+	device.StatusMaintenance,
+	device.StatusUnknown,
+}
+
+// showGroupStatus implements the group status command functionality
+func showGroupStatus(ctx context.Context, w io.Writer, cfg *options.Config, nameOrID, output string) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	g, err := c.FindGroup(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+	rollup, err := c.GroupRollup(ctx, g.ID)
+	if err != nil {
+		return err
+	}
+
+	if output != outputTable {
+		return writeStructured(w, output, rollup)
+	}
+
+	tw := newTable(w)
+	fmt.Fprintf(tw, "Group:\t%s (%s)\n", g.Name, g.ID)
+	fmt.Fprintf(tw, "Devices:\t%d\n", rollup.Devices)
+	for _, status := range rollupStatuses {
+		fmt.Fprintf(tw, "  %s:\t%d\n", status, rollup.ByStatus[status])
+	}
+	fmt.Fprintf(tw, "Compliant:\t%d (%.1f%%)\n", rollup.Compliant, rollup.ComplianceRatio*100)
+	fmt.Fprintf(tw, "Drifted:\t%d\n", rollup.Drifted)
+	return tw.Flush()
+}
+
+// showGroupTree implements the group tree command functionality
+func showGroupTree(ctx context.Context, w io.Writer, cfg *options.Config, root, output string) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	rootID := ""
+	if root != "" {
+		g, err := c.FindGroup(ctx, root)
+		if err != nil {
+			return err
+		}
+		rootID = g.ID
+	}
+	roots, err := c.GroupTree(ctx, rootID)
+	if err != nil {
+		return err
+	}
+
+	if output != outputTable {
+		return writeStructured(w, output, roots)
+	}
+	if len(roots) == 0 {
+		_, err := fmt.Fprintln(w, "No groups found.")
+		return err
+	}
+	for _, node := range roots {
+		writeGroupNode(w, node, "", "")
+	}
+	return nil
+}
+
+// repairGroups implements the group fsck command functionality
+func repairGroups(ctx context.Context, w io.Writer, cfg *options.Config, dryRun bool, output string) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	report, err := c.RepairGroups(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if output != outputTable {
+		return writeStructured(w, output, report)
+	}
+	if len(report.Actions) == 0 {
+		_, err := fmt.Fprintf(w, "Group hierarchy is consistent; %d groups checked.\n", report.Groups)
+		return err
+	}
+
+	tw := newTable(w)
+	fmt.Fprintln(tw, "GROUP\tID\tREPAIR\tDETAIL")
+	for _, action := range report.Actions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", action.GroupName, action.GroupID, action.Kind, action.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		_, err = fmt.Fprintf(w, "\n%d repairs needed, %d groups checked; nothing changed (dry run).\n",
+			len(report.Actions), report.Groups)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n%d repairs made, %d groups checked.\n", len(report.Actions), report.Groups)
+	return err
+}
+
+// writeGroupNode draws a group and its subgroups with box-drawing
+// connectors. prefix is written before the group's own line and
+// childPrefix before the lines of its subgroups.
+func writeGroupNode(w io.Writer, node *api.GroupNode, prefix, childPrefix string) {
+	g := node.Group
+	fmt.Fprintf(w, "%s%s (%s, %s)\n", prefix, g.Name, g.Type, pluralDevices(g.DeviceCount))
+	for i, child := range node.Children {
+		if i == len(node.Children)-1 {
+			writeGroupNode(w, child, childPrefix+"└── ", childPrefix+"    ")
+			continue
+		}
+		writeGroupNode(w, child, childPrefix+"├── ", childPrefix+"│   ")
+	}
+}
+
+// setGroupVariables removes the unset template variables of a group, then
+// sets the given ones.
+func setGroupVariables(props *group.Properties, vars map[string]interface{}, unset []string) error {
+	updated := make(map[string]json.RawMessage, len(props.Variables)+len(vars))
+	for name, value := range props.Variables {
+		updated[name] = value
+	}
+	for _, name := range unset {
+		delete(updated, name)
+	}
+	for name, value := range vars {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("encoding value of variable %s: %w", name, err)
+		}
+		updated[name] = encoded
+	}
+	props.Variables = updated
+	return nil
+}
+
+// writeGroup shows a single group
+func writeGroup(w io.Writer, g *group.Group, output string) error {
+	if output != outputTable {
+		return writeStructured(w, output, g)
+	}
+
+	tw := newTable(w)
+	fmt.Fprintf(tw, "Name:\t%s\n", g.Name)
+	fmt.Fprintf(tw, "ID:\t%s\n", g.ID)
+	fmt.Fprintf(tw, "Type:\t%s\n", g.Type)
+	if g.Description != "" {
+		fmt.Fprintf(tw, "Description:\t%s\n", g.Description)
+	}
+	fmt.Fprintf(tw, "Parent:\t%s\n", orDash(g.ParentID))
+	fmt.Fprintf(tw, "Path:\t%s\n", g.Ancestry.Path)
+	fmt.Fprintf(tw, "Depth:\t%d\n", g.Ancestry.Depth)
+	fmt.Fprintf(tw, "Subgroups:\t%d\n", len(g.Ancestry.Children))
+	fmt.Fprintf(tw, "Devices:\t%d\n", g.DeviceCount)
+	if g.Type == group.TypeDynamic {
+		fmt.Fprintf(tw, "Query:\t%s\n", formatQuery(g.Query))
+	}
+	if len(g.Properties.ConfigTemplate) > 0 {
+		fmt.Fprintf(tw, "Config Template:\t%s\n", string(g.Properties.ConfigTemplate))
+	}
+	if len(g.Properties.PolicyOverrides) > 0 {
+		keys := make([]string, 0, len(g.Properties.PolicyOverrides))
+		for key := range g.Properties.PolicyOverrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(tw, "Policy Overrides:\t%s\n", strings.Join(keys, ","))
+	}
+	if len(g.Properties.Metadata) > 0 {
+		fmt.Fprintf(tw, "Metadata:\t%s\n", formatTags(g.Properties.Metadata))
+	}
+	if len(g.Properties.Variables) > 0 {
+		vars := make(map[string]string, len(g.Properties.Variables))
+		for name, value := range g.Properties.Variables {
+			vars[name] = string(value)
+		}
+		fmt.Fprintf(tw, "Variables:\t%s\n", formatTags(vars))
+	}
+	fmt.Fprintf(tw, "Created:\t%s\n", formatTime(g.CreatedAt))
+	fmt.Fprintf(tw, "Updated:\t%s\n", formatTime(g.UpdatedAt))
+	return tw.Flush()
+}
+
+// formatQuery summarizes a membership query on one line
+func formatQuery(q *group.MembershipQuery) string {
+	if q == nil {
+		return "-"
+	}
+	var parts []string
+	if len(q.Tags) > 0 {
+		parts = append(parts, "tags "+formatTags(q.Tags))
+	}
+	if q.Status != "" {
+		parts = append(parts, "status "+string(q.Status))
+	}
+	if len(q.Regions) > 0 {
+		parts = append(parts, "regions "+strings.Join(q.Regions, ","))
+	}
+	if source, err := q.Expression(); err != nil {
+		parts = append(parts, "custom "+string(q.Custom))
+	} else if source != "" {
+		parts = append(parts, "match "+source)
+	}
+	if len(parts) == 0 {
+		return "all devices"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// pluralDevices renders a device count with its noun
+func pluralDevices(n int) string {
+	if n == 1 {
+		return "1 device"
+	}
+	return fmt.Sprintf("%d devices", n)
+}
@@ -0,0 +1,395 @@
+package stage1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+)
+
+// groupRoutes answers the requests of the group commands. Group eu holds
+// the dynamic group plant-3-pis.
+func groupRoutes() map[string]interface{} {
+	eu := &group.Group{
+		ID:          "grp-eu",
+		TenantID:    "tenant-a",
+		Name:        "eu",
+		Description: "European sites",
+		Type:        group.TypeStatic,
+		Ancestry:    group.AncestryInfo{Path: "/grp-eu", PathParts: []string{"grp-eu"}, Children: []string{"grp-pis"}},
+		Properties: group.Properties{Variables: map[string]json.RawMessage{
+			"site":      json.RawMessage(`"eu"`),
+			"log_level": json.RawMessage(`"info"`),
+		}},
+		DeviceCount: 2,
+	}
+	pis := &group.Group{
+		ID:          "grp-pis",
+		TenantID:    "tenant-a",
+		Name:        "plant-3-pis",
+		Description: "Raspberry Pis of plant 3",
+		Type:        group.TypeDynamic,
+		ParentID:    "grp-eu",
+		Ancestry:    group.AncestryInfo{Path: "/grp-eu/grp-pis", PathParts: []string{"grp-eu", "grp-pis"}, Depth: 1},
+		Query:       &group.MembershipQuery{Custom: group.CustomExpression(`tags.site == "plant-3"`)},
+		DeviceCount: 1,
+	}
+	lab := &group.Group{ID: "grp-lab", TenantID: "tenant-a", Name: "lab", Type: group.TypeStatic,
+		ParentID: "grp-eu", Ancestry: group.AncestryInfo{Path: "/grp-eu/grp-lab", Depth: 1}}
+	devices := fleetDevices()
+
+	return withDevices(map[string]interface{}{
+		"GET " + api.PathGroups: byName([]*group.Group{eu, pis},
+			func(g *group.Group) string { return g.Name },
+			func(groups []*group.Group) interface{} { return api.GroupListResponse{Groups: groups} }),
+		"GET " + api.GroupPath("grp-eu"):                   api.GroupResponse{Group: eu},
+		"GET " + api.GroupPath("grp-pis"):                  api.GroupResponse{Group: pis},
+		"POST " + api.PathGroups:                           reply{status: http.StatusCreated, body: api.GroupResponse{Group: lab}},
+		"PUT " + api.GroupPath("grp-pis"):                  api.GroupResponse{Group: pis},
+		"PUT " + api.GroupPath("grp-eu"):                   api.GroupResponse{Group: eu},
+		"DELETE " + api.GroupPath("grp-pis"):               reply{status: http.StatusNoContent},
+		"POST " + api.GroupMovePath("grp-pis"):             api.GroupResponse{Group: pis},
+		"POST " + api.GroupDevicesPath("grp-eu"):           api.GroupResponse{Group: eu},
+		"DELETE " + api.GroupDevicePath("grp-eu", "dev-1"): reply{status: http.StatusNoContent},
+		"GET " + api.GroupDevicesPath("grp-eu"):            api.DeviceListResponse{Devices: devices},
+		"GET " + api.GroupDevicesPath("grp-pis"):           api.DeviceListResponse{Devices: []*device.Device{}},
+		"GET " + api.GroupRollupPath("grp-eu"): api.GroupRollupResponse{Rollup: &group.Rollup{
+			GroupID:         "grp-eu",
+			Devices:         2,
+			ByStatus:        map[device.Status]int{device.StatusOnline: 1, device.StatusOffline: 1},
+			Compliant:       1,
+			ComplianceRatio: 0.5,
+			Drifted:         1,
+		}},
+		"GET " + api.PathGroupTree: api.GroupTreeResponse{Roots: []*api.GroupNode{
+			{Group: eu, Children: []*api.GroupNode{{Group: lab}, {Group: pis}}},
+		}},
+		"POST " + api.PathGroupRepair: api.GroupRepairResponse{Report: &group.RepairReport{
+			TenantID: "tenant-a",
+			Groups:   3,
+			Actions: []group.RepairAction{{
+				GroupID:   "grp-lost",
+				GroupName: "lost",
+				Kind:      group.RepairReparented,
+				Detail:    "parent grp-gone not found; moved beneath quarantine group",
+			}},
+		}},
+		"POST " + api.PathRollouts: reply{status: http.StatusCreated, body: api.RolloutResponse{Rollout: &rollout.Rollout{
+			ID: "ro-1", GroupID: "grp-pis", TemplateID: "tpl-1", Version: 3, Status: rollout.StatusRunning,
+		}}},
+	})
+}
+
+func TestGroupCommands(t *testing.T) {
+	runCLITests(t, groupRoutes, []cliTest{
+		{
+			name: "create static group beneath a parent given by name",
+			args: []string{"group", "create", "lab", "--parent", "eu", "--description", "Test lab"},
+			want: []string{"lab", "grp-lab", "/grp-eu/grp-lab"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupRequest
+				central.request(http.MethodPost, api.PathGroups).decodeBody(t, &req)
+				assert.Equal(t, api.GroupRequest{
+					Name:        "lab",
+					Description: "Test lab",
+					Type:        group.TypeStatic,
+					Parent:      "grp-eu",
+				}, req)
+			},
+		},
+		{
+			name: "query flags create a dynamic group",
+			args: []string{"group", "create", "pis", "--tag", "site=plant-3", "--status", "online",
+				"--region", "eu-west,eu-north", "--match", `network.hostname =~ "^pi-"`},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupRequest
+				central.request(http.MethodPost, api.PathGroups).decodeBody(t, &req)
+				assert.Equal(t, group.TypeDynamic, req.Type)
+				require.NotNil(t, req.Query)
+				assert.Equal(t, map[string]string{"site": "plant-3"}, req.Query.Tags)
+				assert.Equal(t, device.StatusOnline, req.Query.Status)
+				assert.Equal(t, []string{"eu-west", "eu-north"}, req.Query.Regions)
+				source, err := req.Query.Expression()
+				require.NoError(t, err)
+				assert.Equal(t, `network.hostname =~ "^pi-"`, source)
+			},
+		},
+		{
+			name: "dynamic group without query flags matches all devices",
+			args: []string{"group", "create", "all", "--dynamic"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupRequest
+				central.request(http.MethodPost, api.PathGroups).decodeBody(t, &req)
+				assert.Equal(t, group.TypeDynamic, req.Type)
+				assert.NotNil(t, req.Query)
+			},
+		},
+		{
+			name:    "create with unknown parent",
+			args:    []string{"group", "create", "lab", "--parent", "nowhere"},
+			wantErr: `group "nowhere" not found`,
+		},
+		{
+			name: "list shows parent names",
+			args: []string{"group", "list"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				require.Len(t, lines, 3)
+				assert.Equal(t, []string{"NAME", "TYPE", "PARENT", "DEVICES", "AGE"}, strings.Fields(lines[0]))
+				assert.Equal(t, []string{"eu", "static", "-", "2", "-"}, strings.Fields(lines[1]))
+				assert.Equal(t, []string{"plant-3-pis", "dynamic", "eu", "1", "-"}, strings.Fields(lines[2]))
+			},
+		},
+		{
+			name: "list filters by type and parent",
+			args: []string{"group", "list", "--type", "dynamic", "--parent", "eu"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Equal(t, "parent=grp-eu&type=dynamic", central.request(http.MethodGet, api.PathGroups).query)
+			},
+		},
+		{
+			name: "list wide shows queries",
+			args: []string{"group", "list", "-o", "wide"},
+			want: []string{"QUERY", `match tags.site == "plant-3"`, "grp-pis"},
+		},
+		{
+			name: "list as json",
+			args: []string{"group", "list", "-o", "json"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var groups []*group.Group
+				require.NoError(t, json.Unmarshal([]byte(out), &groups))
+				require.Len(t, groups, 2)
+				assert.Equal(t, "grp-pis", groups[1].ID)
+			},
+		},
+		{
+			name:    "list rejects unknown output formats",
+			args:    []string{"group", "list", "-o", "xml"},
+			wantErr: `unsupported output format "xml"`,
+		},
+		{
+			name: "show resolves groups by name",
+			args: []string{"group", "show", "plant-3-pis"},
+			want: []string{"grp-pis", "Raspberry Pis of plant 3", "/grp-eu/grp-pis", `match tags.site == "plant-3"`},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Equal(t, "name=plant-3-pis", central.request(http.MethodGet, api.PathGroups).query)
+			},
+		},
+		{
+			name: "show as yaml",
+			args: []string{"group", "show", "grp-eu", "-o", "yaml"},
+			want: []string{"id: grp-eu\n", "name: eu\n", "device_count: 2\n"},
+		},
+		{
+			name:    "show unknown group",
+			args:    []string{"group", "show", "nowhere"},
+			wantErr: `group "nowhere" not found`,
+		},
+		{
+			name: "status shows the rollup",
+			args: []string{"group", "status", "eu"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				assert.Equal(t, []string{"Group:", "eu", "(grp-eu)"}, strings.Fields(lines[0]))
+				assert.Equal(t, []string{"Devices:", "2"}, strings.Fields(lines[1]))
+				assert.Equal(t, []string{"online:", "1"}, strings.Fields(lines[2]))
+				assert.Equal(t, []string{"offline:", "1"}, strings.Fields(lines[3]))
+				assert.Equal(t, []string{"error:", "0"}, strings.Fields(lines[4]))
+				assert.Equal(t, []string{"Compliant:", "1", "(50.0%)"}, strings.Fields(lines[7]))
+				assert.Equal(t, []string{"Drifted:", "1"}, strings.Fields(lines[8]))
+			},
+		},
+		{
+			name: "update keeps settings that are not given",
+			args: []string{"group", "update", "plant-3-pis", "--name", "pis", "--match", `tags.site == "plant-4"`},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupUpdateRequest
+				central.request(http.MethodPut, api.GroupPath("grp-pis")).decodeBody(t, &req)
+				assert.Equal(t, "pis", req.Name)
+				assert.Equal(t, "Raspberry Pis of plant 3", req.Description)
+				source, err := req.Query.Expression()
+				require.NoError(t, err)
+				assert.Equal(t, `tags.site == "plant-4"`, source)
+			},
+		},
+		{
+			name: "update with an empty match removes the expression",
+			args: []string{"group", "update", "grp-pis", "--match", ""},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupUpdateRequest
+				central.request(http.MethodPut, api.GroupPath("grp-pis")).decodeBody(t, &req)
+				require.NotNil(t, req.Query)
+				assert.Empty(t, req.Query.Custom)
+			},
+		},
+		{
+			name: "update sets and removes variables",
+			args: []string{"group", "update", "eu", "--var", "log_level=debug", "--var", "network.port=8443", "--unset-var", "site"},
+			want: []string{"Variables:", `log_level="info",site="eu"`},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupUpdateRequest
+				central.request(http.MethodPut, api.GroupPath("grp-eu")).decodeBody(t, &req)
+				assert.Equal(t, map[string]json.RawMessage{
+					"log_level":    json.RawMessage(`"debug"`),
+					"network.port": json.RawMessage(`8443`),
+				}, req.Properties.Variables)
+			},
+		},
+		{
+			name:    "update rejects a malformed variable",
+			args:    []string{"group", "update", "eu", "--var", "site"},
+			wantErr: `invalid variable "site": expected NAME=VALUE`,
+		},
+		{
+			name:    "update rejects an expression for a static group",
+			args:    []string{"group", "update", "eu", "--match", "true"},
+			wantErr: "group eu is static",
+		},
+		{
+			name: "delete",
+			args: []string{"group", "delete", "plant-3-pis"},
+			want: []string{"Group plant-3-pis (grp-pis) deleted.\n"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				central.request(http.MethodDelete, api.GroupPath("grp-pis"))
+			},
+		},
+		{
+			name: "move beneath a parent given by name",
+			args: []string{"group", "move", "grp-pis", "--parent", "eu"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupMoveRequest
+				central.request(http.MethodPost, api.GroupMovePath("grp-pis")).decodeBody(t, &req)
+				assert.Equal(t, "grp-eu", req.Parent)
+			},
+		},
+		{
+			name: "move to the root",
+			args: []string{"group", "move", "grp-pis", "--root"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupMoveRequest
+				central.request(http.MethodPost, api.GroupMovePath("grp-pis")).decodeBody(t, &req)
+				assert.Empty(t, req.Parent)
+			},
+		},
+		{
+			name:    "move needs a destination",
+			args:    []string{"group", "move", "grp-pis"},
+			wantErr: "either --parent or --root is required",
+		},
+		{
+			name:    "move takes one destination",
+			args:    []string{"group", "move", "grp-pis", "--root", "--parent", "eu"},
+			wantErr: "none of the others can be",
+		},
+		{
+			name: "add devices by ID and name",
+			args: []string{"group", "add", "eu", "dev-1", "edge-2"},
+			want: []string{"Device edge-1 added to group eu.\nDevice edge-2 added to group eu.\n"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				requests := central.received(http.MethodPost, api.GroupDevicesPath("grp-eu"))
+				require.Len(t, requests, 2)
+				for i, id := range []string{"dev-1", "dev-2"} {
+					var req api.GroupDeviceRequest
+					requests[i].decodeBody(t, &req)
+					assert.Equal(t, id, req.Device)
+				}
+			},
+		},
+		{
+			name:    "add needs a device",
+			args:    []string{"group", "add", "eu"},
+			wantErr: "requires at least 2 arg(s)",
+		},
+		{
+			name: "remove",
+			args: []string{"group", "remove", "eu", "edge-1"},
+			want: []string{"Device edge-1 removed from group eu.\n"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				central.request(http.MethodDelete, api.GroupDevicePath("grp-eu", "dev-1"))
+			},
+		},
+		{
+			name: "devices",
+			args: []string{"group", "devices", "eu"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				require.Len(t, lines, 3)
+				assert.Equal(t, []string{"NAME", "ID", "STATUS", "UPDATED"}, strings.Fields(lines[0]))
+				assert.Equal(t, []string{"edge-1", "dev-1", "online"}, strings.Fields(lines[1])[:3])
+				assert.Equal(t, []string{"edge-2", "dev-2", "offline"}, strings.Fields(lines[2])[:3])
+			},
+		},
+		{
+			name: "devices of an empty group",
+			args: []string{"group", "devices", "plant-3-pis"},
+			want: []string{"Group plant-3-pis has no devices.\n"},
+		},
+		{
+			name: "tree",
+			args: []string{"group", "tree"},
+			want: []string{"eu (static, 2 devices)\n" +
+				"├── lab (static, 0 devices)\n" +
+				"└── plant-3-pis (dynamic, 1 device)\n"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Empty(t, central.request(http.MethodGet, api.PathGroupTree).query)
+			},
+		},
+		{
+			name: "tree beneath a group",
+			args: []string{"group", "tree", "eu"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Equal(t, "root=grp-eu", central.request(http.MethodGet, api.PathGroupTree).query)
+			},
+		},
+		{
+			name: "deploy starts a rollout of the file",
+			args: []string{"group", "deploy", "plant-3-pis", configArg, "--template", "edge",
+				"--canary", "10", "--batch-size", "20"},
+			want: []string{"ro-1", "running"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.RolloutRequest
+				central.request(http.MethodPost, api.PathRollouts).decodeBody(t, &req)
+				assert.Equal(t, "grp-pis", req.Group)
+				assert.Equal(t, "edge", req.Template)
+				assert.Zero(t, req.Version)
+				assert.JSONEq(t, `{"mode":"new"}`, string(req.Config))
+				assert.Equal(t, 10, req.Strategy.CanaryPercent)
+				assert.Equal(t, 20, req.Strategy.BatchSize)
+				assert.Equal(t, rollout.DefaultWaveTimeout, req.Strategy.WaveTimeout)
+			},
+		},
+		{
+			name:    "deploy needs a template",
+			args:    []string{"group", "deploy", "plant-3-pis", configArg},
+			wantErr: `required flag(s) "template" not set`,
+		},
+		{
+			name: "fsck dry run",
+			args: []string{"group", "fsck", "--dry-run"},
+			want: []string{"lost", "grp-lost", "reparent", "parent grp-gone not found",
+				"1 repairs needed, 3 groups checked; nothing changed (dry run).\n"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupRepairRequest
+				central.request(http.MethodPost, api.PathGroupRepair).decodeBody(t, &req)
+				assert.True(t, req.DryRun)
+			},
+		},
+		{
+			name: "fsck",
+			args: []string{"group", "fsck"},
+			want: []string{"1 repairs made, 3 groups checked.\n"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.GroupRepairRequest
+				central.request(http.MethodPost, api.PathGroupRepair).decodeBody(t, &req)
+				assert.False(t, req.DryRun)
+			},
+		},
+	})
+}
@@ -0,0 +1,414 @@
+package stage1
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wrale/wrale-fleet/cmd/wfcentral/options"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+)
+
+// rolloutStartOptions holds the flags of the rollout start command
+type rolloutStartOptions struct {
+	template   string
+	version    int
+	configFile string
+	strategy   rollout.Strategy
+	output     string
+}
+
+// newRolloutStartCmd creates the rollout start command
+func newRolloutStartCmd(cfg *options.Config) (*cobra.Command, error) {
+	opts := &rolloutStartOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "start GROUP",
+		Short: "Roll out a configuration to a group",
+		Long: `Start a staged rollout of a configuration version to the devices of a
+group, given by ID.
+
+The version is either an existing valid version of the template, given
+with --version, or a new version created from a configuration file with
+--config. The file is validated against the template schema first.
+
+Devices are deployed to in waves:
+1. A canary wave with --canary percent of the group
+2. Batches of --batch-size devices, --pause apart
+
+Each wave waits until its devices acknowledge the deployment or
+--wave-timeout passes. When more than --failure-threshold percent of a
+wave's devices fail, the rollout halts and no further waves start until
+it is resumed or aborted.`,
+		Example: `  # Roll out a new configuration, 10% canary then batches of 20
+  wfcentral rollout start 7d9c... --template edge --config edge.yaml \
+    --canary 10 --batch-size 20 --pause 5m --failure-threshold 5
+
+  # Roll out an existing version to the whole group at once
+  wfcentral rollout start 7d9c... --template edge --version 4`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return startRollout(cmd.Context(), cmd.OutOrStdout(), cfg, args[0], opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.template, "template", "",
+		"configuration template name or ID")
+	cmd.Flags().IntVar(&opts.version, "version", 0,
+		"existing configuration version to roll out")
+	cmd.Flags().StringVar(&opts.configFile, "config", "",
+		"path to a configuration file to roll out as a new version")
+	addStrategyFlags(cmd, &opts.strategy)
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	if err := cmd.MarkFlagRequired("template"); err != nil {
+		return nil, fmt.Errorf("marking template flag as required: %w", err)
+	}
+	cmd.MarkFlagsMutuallyExclusive("version", "config")
+
+	return cmd, nil
+}
+
+// newRolloutListCmd creates the rollout list command
+func newRolloutListCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		group  string
+		status string
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List configuration rollouts",
+		Long: `Display the configuration rollouts of the tenant, oldest first, with
+their status and how many devices have applied the configuration.`,
+		Example: `  # List all rollouts
+  wfcentral rollout list
+
+  # List halted rollouts as JSON
+  wfcentral rollout list --status halted --output json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listRollouts(cmd.Context(), cmd.OutOrStdout(), cfg, group, status, output)
+		},
+	}
+
+	cmd.Flags().StringVar(&group, "group", "",
+		"only list rollouts to this group ID")
+	cmd.Flags().StringVar(&status, "status", "",
+		"only list rollouts with this status (running, paused, halted, aborted, completed)")
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newRolloutStatusCmd creates the rollout status command
+func newRolloutStatusCmd(cfg *options.Config) (*cobra.Command, error) {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "status ID",
+		Short: "Show rollout progress",
+		Long: `Display the progress of a configuration rollout: its status, the
+outcome of each wave and, for halted or aborted rollouts, the reason.`,
+		Example: `  # Show the progress of a rollout
+  wfcentral rollout status 3f2a...`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rolloutAction(cmd.Context(), cmd.OutOrStdout(), cfg, output,
+				func(ctx context.Context, c *client.Client) (*rollout.Rollout, error) {
+					return c.GetRollout(ctx, args[0])
+				})
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newRolloutPauseCmd creates the rollout pause command
+func newRolloutPauseCmd(cfg *options.Config) (*cobra.Command, error) {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "pause ID",
+		Short: "Pause a rollout",
+		Long: `Stop a running rollout from starting new waves. Devices of the wave in
+progress still apply the configuration; their outcome is evaluated when
+the rollout is resumed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rolloutAction(cmd.Context(), cmd.OutOrStdout(), cfg, output,
+				func(ctx context.Context, c *client.Client) (*rollout.Rollout, error) {
+					return c.PauseRollout(ctx, args[0])
+				})
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newRolloutResumeCmd creates the rollout resume command
+func newRolloutResumeCmd(cfg *options.Config) (*cobra.Command, error) {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "resume ID",
+		Short: "Resume a paused or halted rollout",
+		Long: `Continue a paused rollout, or a rollout halted by a failed wave. A
+halted rollout continues with the wave after the one that failed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rolloutAction(cmd.Context(), cmd.OutOrStdout(), cfg, output,
+				func(ctx context.Context, c *client.Client) (*rollout.Rollout, error) {
+					return c.ResumeRollout(ctx, args[0])
+				})
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// newRolloutAbortCmd creates the rollout abort command
+func newRolloutAbortCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		reason string
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "abort ID",
+		Short: "Abort a rollout",
+		Long: `Stop a rollout for good. Deployments its devices have not yet
+acknowledged are failed with the reason; devices that already applied
+the configuration keep it.`,
+		Example: `  # Abort a rollout
+  wfcentral rollout abort 3f2a... --reason "wrong MQTT broker"`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rolloutAction(cmd.Context(), cmd.OutOrStdout(), cfg, output,
+				func(ctx context.Context, c *client.Client) (*rollout.Rollout, error) {
+					return c.AbortRollout(ctx, args[0], reason)
+				})
+		},
+	}
+
+	cmd.Flags().StringVar(&reason, "reason", "",
+		"why the rollout is aborted")
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable,
+		"output format (table, json, yaml)")
+
+	return cmd, nil
+}
+
+// addStrategyFlags registers the flags shaping the waves of a rollout
+func addStrategyFlags(cmd *cobra.Command, s *rollout.Strategy) {
+	cmd.Flags().IntVar(&s.CanaryPercent, "canary", 0,
+		"percentage of the group deployed to in the first wave (0 skips the canary)")
+	cmd.Flags().IntVar(&s.BatchSize, "batch-size", 0,
+		"devices per wave after the canary (0 deploys to the rest at once)")
+	cmd.Flags().DurationVar(&s.Pause, "pause", 0,
+		"time to wait between waves")
+	cmd.Flags().IntVar(&s.FailureThreshold, "failure-threshold", 0,
+		"highest percentage of failed devices a wave may have before the rollout halts")
+	cmd.Flags().DurationVar(&s.WaveTimeout, "wave-timeout", rollout.DefaultWaveTimeout,
+		"time a wave waits for its devices before counting them as failed")
+}
+
+// startRollout implements the rollout start command functionality
+func startRollout(ctx context.Context, w io.Writer, cfg *options.Config, groupID string, opts *rolloutStartOptions) error {
+	if err := checkOutput(opts.output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+	if opts.version == 0 && opts.configFile == "" {
+		return fmt.Errorf("either --version or --config is required")
+	}
+
+	req := &api.RolloutRequest{
+		Group:    groupID,
+		Template: opts.template,
+		Version:  opts.version,
+		Strategy: opts.strategy,
+	}
+	if opts.configFile != "" {
+		var err error
+		if req.Config, err = readConfigFile(opts.configFile); err != nil {
+			return err
+		}
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	r, err := c.StartRollout(ctx, req)
+	if err != nil {
+		return err
+	}
+	return writeRollout(w, r, opts.output)
+}
+
+// listRollouts implements the rollout list command functionality
+func listRollouts(ctx context.Context, w io.Writer, cfg *options.Config, group, status, output string) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	rollouts, err := c.ListRollouts(ctx, group, rollout.Status(status))
+	if err != nil {
+		return err
+	}
+
+	if output != outputTable {
+		return writeStructured(w, output, rollouts)
+	}
+	if len(rollouts) == 0 {
+		_, err := fmt.Fprintln(w, "No rollouts found.")
+		return err
+	}
+
+	now := time.Now()
+	tw := newTable(w)
+	fmt.Fprintln(tw, "ID\tGROUP\tTEMPLATE\tVERSION\tSTATUS\tWAVE\tSUCCEEDED\tFAILED\tAGE")
+	for _, r := range rollouts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d/%d\t%d/%d\t%d\t%s\n",
+			r.ID, r.GroupID, r.TemplateID, r.Version, r.Status,
+			r.CurrentWave+1, len(r.Waves), r.Progress.Succeeded, r.Progress.Devices, r.Progress.Failed,
+			formatAge(r.CreatedAt, now))
+	}
+	return tw.Flush()
+}
+
+// rolloutAction runs a request returning a single rollout and shows it
+func rolloutAction(ctx context.Context, w io.Writer, cfg *options.Config, output string,
+	do func(ctx context.Context, c *client.Client) (*rollout.Rollout, error)) error {
+	if err := checkOutput(output, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	c, err := options.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	r, err := do(ctx, c)
+	if err != nil {
+		return err
+	}
+	return writeRollout(w, r, output)
+}
+
+// writeRollout shows a rollout and the outcome of each of its waves
+func writeRollout(w io.Writer, r *rollout.Rollout, output string) error {
+	if output != outputTable {
+		return writeStructured(w, output, r)
+	}
+
+	tw := newTable(w)
+	fmt.Fprintf(tw, "ID:\t%s\n", r.ID)
+	fmt.Fprintf(tw, "Group:\t%s\n", r.GroupID)
+	fmt.Fprintf(tw, "Template:\t%s\n", r.TemplateID)
+	fmt.Fprintf(tw, "Version:\t%d\n", r.Version)
+	fmt.Fprintf(tw, "Status:\t%s\n", r.Status)
+	if r.Reason != "" {
+		fmt.Fprintf(tw, "Reason:\t%s\n", r.Reason)
+	}
+	fmt.Fprintf(tw, "Strategy:\t%s\n", formatStrategy(r.Strategy))
+	progress := fmt.Sprintf("%d/%d succeeded, %d failed, %d pending",
+		r.Progress.Succeeded, r.Progress.Devices, r.Progress.Failed, r.Progress.Pending)
+	if r.Progress.Superseded > 0 {
+		progress += fmt.Sprintf(", %d superseded", r.Progress.Superseded)
+	}
+	fmt.Fprintf(tw, "Progress:\t%s\n", progress)
+	fmt.Fprintf(tw, "Created:\t%s\n", formatTime(r.CreatedAt))
+	if r.CreatedBy != "" {
+		fmt.Fprintf(tw, "Created By:\t%s\n", r.CreatedBy)
+	}
+	if r.CompletedAt != nil {
+		fmt.Fprintf(tw, "Completed:\t%s\n", formatTime(*r.CompletedAt))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Waves:")
+	tw = newTable(w)
+	fmt.Fprintln(tw, "  WAVE\tSTATUS\tDEVICES\tSUCCEEDED\tFAILED\tSTARTED")
+	for _, wave := range r.Waves {
+		succeeded, failed := 0, 0
+		for _, t := range wave.Targets {
+			switch t.Status {
+			case config.DeploymentStatusCompleted:
+				succeeded++
+			case config.DeploymentStatusFailed:
+				failed++
+			}
+		}
+		name := fmt.Sprint(wave.Number)
+		if wave.Canary {
+			name += " (canary)"
+		}
+		started := "-"
+		if wave.StartedAt != nil {
+			started = formatTime(*wave.StartedAt)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\t%d\t%s\n",
+			name, wave.Status, len(wave.Targets), succeeded, failed, started)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// Failed devices are listed so operators can see why a wave halted.
+	var failures []rollout.Target
+	for _, wave := range r.Waves {
+		for _, t := range wave.Targets {
+			if t.Status == config.DeploymentStatusFailed {
+				failures = append(failures, t)
+			}
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Failed Devices:")
+	tw = newTable(w)
+	fmt.Fprintln(tw, "  DEVICE\tERROR")
+	for _, t := range failures {
+		fmt.Fprintf(tw, "  %s\t%s\n", t.DeviceID, orDash(t.Error))
+	}
+	return tw.Flush()
+}
+
+// formatStrategy summarizes a rollout strategy on one line
+func formatStrategy(s rollout.Strategy) string {
+	return fmt.Sprintf("canary %d%%, batches of %s, pause %s, halt above %d%% failed, wave timeout %s",
+		s.CanaryPercent, batchSize(s.BatchSize), s.Pause, s.FailureThreshold, s.WaveTimeout)
+}
+
+// batchSize renders a batch size, where zero means the remaining devices
+func batchSize(n int) string {
+	if n == 0 {
+		return "all"
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,208 @@
+package stage1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+)
+
+// rolloutRoutes answers the requests of the rollout commands. Rollout ro-1
+// halted when its second wave failed.
+func rolloutRoutes() map[string]interface{} {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	halted := &rollout.Rollout{
+		ID:         "ro-1",
+		TenantID:   "tenant-a",
+		GroupID:    "grp-eu",
+		TemplateID: "tpl-1",
+		Version:    4,
+		Strategy: rollout.Strategy{
+			CanaryPercent:    10,
+			BatchSize:        20,
+			Pause:            5 * time.Minute,
+			FailureThreshold: 5,
+			WaveTimeout:      10 * time.Minute,
+		},
+		Status: rollout.StatusHalted,
+		Reason: "wave 2 failed: 1 of 1 devices failed",
+		Waves: []rollout.Wave{
+			{Number: 1, Canary: true, Status: rollout.WaveStatusSucceeded, StartedAt: &started, Targets: []rollout.Target{
+				{DeviceID: "dev-1", DeploymentID: "dep-1", Status: config.DeploymentStatusCompleted},
+			}},
+			{Number: 2, Status: rollout.WaveStatusFailed, StartedAt: &started, Targets: []rollout.Target{
+				{DeviceID: "dev-2", DeploymentID: "dep-2", Status: config.DeploymentStatusFailed, Error: "wave timed out"},
+			}},
+			{Number: 3, Status: rollout.WaveStatusPending, Targets: []rollout.Target{
+				{DeviceID: "dev-3", Status: config.DeploymentStatusPending},
+			}},
+		},
+		CurrentWave: 1,
+		Progress:    rollout.Progress{Devices: 3, Succeeded: 1, Failed: 1, Pending: 1},
+		CreatedBy:   "ops@example.com",
+		CreatedAt:   started,
+	}
+	running := *halted
+	running.Status, running.Reason = rollout.StatusRunning, ""
+	aborted := *halted
+	aborted.Status, aborted.Reason = rollout.StatusAborted, "wrong MQTT broker"
+
+	return map[string]interface{}{
+		"POST " + api.PathRollouts:              reply{status: http.StatusCreated, body: api.RolloutResponse{Rollout: &running}},
+		"GET " + api.PathRollouts:               api.RolloutListResponse{Rollouts: []*rollout.Rollout{halted}},
+		"GET " + api.RolloutPath("ro-1"):        api.RolloutResponse{Rollout: halted},
+		"POST " + api.RolloutPausePath("ro-1"):  api.RolloutResponse{Rollout: &running},
+		"POST " + api.RolloutResumePath("ro-1"): api.RolloutResponse{Rollout: &running},
+		"POST " + api.RolloutAbortPath("ro-1"):  api.RolloutResponse{Rollout: &aborted},
+	}
+}
+
+func TestRolloutCommands(t *testing.T) {
+	runCLITests(t, rolloutRoutes, []cliTest{
+		{
+			name: "start an existing version",
+			args: []string{"rollout", "start", "grp-eu", "--template", "edge", "--version", "4",
+				"--canary", "10", "--batch-size", "20", "--pause", "5m", "--failure-threshold", "5", "--wave-timeout", "10m"},
+			want: []string{"running", "canary 10%, batches of 20, pause 5m0s, halt above 5% failed, wave timeout 10m0s"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.RolloutRequest
+				central.request(http.MethodPost, api.PathRollouts).decodeBody(t, &req)
+				assert.Equal(t, api.RolloutRequest{
+					Group:    "grp-eu",
+					Template: "edge",
+					Version:  4,
+					Strategy: rollout.Strategy{
+						CanaryPercent:    10,
+						BatchSize:        20,
+						Pause:            5 * time.Minute,
+						FailureThreshold: 5,
+						WaveTimeout:      10 * time.Minute,
+					},
+				}, req)
+			},
+		},
+		{
+			name: "start a new version from a file",
+			args: []string{"rollout", "start", "grp-eu", "--template", "edge", "--config", configArg},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.RolloutRequest
+				central.request(http.MethodPost, api.PathRollouts).decodeBody(t, &req)
+				assert.Zero(t, req.Version)
+				assert.JSONEq(t, `{"mode":"new"}`, string(req.Config))
+				assert.Equal(t, rollout.DefaultWaveTimeout, req.Strategy.WaveTimeout)
+			},
+		},
+		{
+			name:    "start needs a version or a file",
+			args:    []string{"rollout", "start", "grp-eu", "--template", "edge"},
+			wantErr: "either --version or --config is required",
+		},
+		{
+			name:    "start takes a version or a file, not both",
+			args:    []string{"rollout", "start", "grp-eu", "--template", "edge", "--version", "4", "--config", configArg},
+			wantErr: "none of the others can be",
+		},
+		{
+			name:    "start needs a template",
+			args:    []string{"rollout", "start", "grp-eu", "--version", "4"},
+			wantErr: `required flag(s) "template" not set`,
+		},
+		{
+			name: "list",
+			args: []string{"rollout", "list", "--group", "grp-eu", "--status", "halted"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Equal(t, "group=grp-eu&status=halted", central.request(http.MethodGet, api.PathRollouts).query)
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				require.Len(t, lines, 2)
+				assert.Equal(t, []string{"ID", "GROUP", "TEMPLATE", "VERSION", "STATUS", "WAVE", "SUCCEEDED", "FAILED", "AGE"},
+					strings.Fields(lines[0]))
+				assert.Equal(t, []string{"ro-1", "grp-eu", "tpl-1", "4", "halted", "2/3", "1/3", "1"},
+					strings.Fields(lines[1])[:8])
+			},
+		},
+		{
+			name: "list as json",
+			args: []string{"rollout", "list", "-o", "json"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				assert.Empty(t, central.request(http.MethodGet, api.PathRollouts).query)
+				var rollouts []*rollout.Rollout
+				require.NoError(t, json.Unmarshal([]byte(out), &rollouts))
+				require.Len(t, rollouts, 1)
+				assert.Equal(t, rollout.StatusHalted, rollouts[0].Status)
+			},
+		},
+		{
+			name:    "list rejects the wide format",
+			args:    []string{"rollout", "list", "-o", "wide"},
+			wantErr: `unsupported output format "wide"`,
+		},
+		{
+			name: "status shows waves and failed devices",
+			args: []string{"rollout", "status", "ro-1"},
+			want: []string{"wave 2 failed: 1 of 1 devices failed", "ops@example.com"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				lines := strings.Split(out, "\n")
+				assert.Contains(t, lines, "Waves:")
+				assert.Contains(t, lines, "Failed Devices:")
+
+				fields := make([][]string, 0, len(lines))
+				for _, line := range lines {
+					fields = append(fields, strings.Fields(line))
+				}
+				assert.Contains(t, fields, []string{"Status:", "halted"})
+				assert.Contains(t, fields, []string{"Progress:", "1/3", "succeeded,", "1", "failed,", "1", "pending"})
+				assert.Contains(t, fields, []string{"1", "(canary)", "succeeded", "1", "1", "0", formatTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))})
+				assert.Contains(t, fields, []string{"3", "pending", "1", "0", "0", "-"})
+				assert.Contains(t, fields, []string{"dev-2", "wave", "timed", "out"})
+			},
+		},
+		{
+			name: "status as yaml",
+			args: []string{"rollout", "status", "ro-1", "-o", "yaml"},
+			want: []string{"id: ro-1\n", "status: halted\n", "current_wave: 1\n"},
+		},
+		{
+			name:    "status of an unknown rollout",
+			args:    []string{"rollout", "status", "ro-2"},
+			wantErr: "getting rollout ro-2",
+		},
+		{
+			name: "pause",
+			args: []string{"rollout", "pause", "ro-1"},
+			want: []string{"running"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				central.request(http.MethodPost, api.RolloutPausePath("ro-1"))
+			},
+		},
+		{
+			name: "resume",
+			args: []string{"rollout", "resume", "ro-1"},
+			want: []string{"running"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				central.request(http.MethodPost, api.RolloutResumePath("ro-1"))
+			},
+		},
+		{
+			name: "abort with a reason",
+			args: []string{"rollout", "abort", "ro-1", "--reason", "wrong MQTT broker"},
+			want: []string{"aborted", "wrong MQTT broker"},
+			check: func(t *testing.T, central *fakeCentral, out string) {
+				var req api.RolloutAbortRequest
+				central.request(http.MethodPost, api.RolloutAbortPath("ro-1")).decodeBody(t, &req)
+				assert.Equal(t, "wrong MQTT broker", req.Reason)
+			},
+		},
+		{
+			name:    "abort needs a rollout",
+			args:    []string{"rollout", "abort"},
+			wantErr: "accepts 1 arg(s), received 0",
+		},
+	})
+}
@@ -28,32 +28,71 @@ func newStartCmd(cfg *options.Config) (*cobra.Command, error) {
 
 The server requires both a main API port for device management and a separate
 management port for health and readiness endpoints. The management port must
-be explicitly configured for security reasons.`,
+be explicitly configured for security reasons.
+
+Settings may also be read from a YAML file with --config. Flags given on the
+command line take precedence over the file. Storage backends are selected per
+domain by name, and an unknown name stops startup before any store is opened.`,
 		Example: `  # Start server with default settings
   wfcentral start --management-port 8601
 
   # Start with custom ports and data directory
   wfcentral start --port 8700 --management-port 8701 --data-dir /data/wfcentral
 
+  # Start with in-memory device storage (devices are lost on restart)
+  wfcentral start --management-port 8601 --device-storage memory
+
   # Start with full health endpoint exposure
-  wfcentral start --management-port 8601 --health-exposure full`,
+  wfcentral start --management-port 8601 --health-exposure full
+
+  # Keep configuration templates in PostgreSQL
+  wfcentral start --management-port 8601 --config-storage postgres \
+    --config-dsn "postgres://fleet@db/fleet?sslmode=require"
+
+  # Load settings from a file, overriding the data directory
+  wfcentral start --config /etc/wfcentral/wfcentral.yaml --data-dir /data/wfcentral`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if cfg.ConfigFile != "" {
+				if err := cfg.LoadFile(cfg.ConfigFile, cmd.Flags().Changed); err != nil {
+					return err
+				}
+			}
 			return startServer(cmd.Context(), cfg)
 		},
 	}
 
+	cmd.Flags().StringVar(&cfg.ConfigFile, "config", "",
+		"path to a YAML configuration file")
 	cmd.Flags().StringVar(&cfg.Port, "port", cfg.Port,
 		"main API port for device management")
 	cmd.Flags().StringVar(&cfg.ManagementPort, "management-port", cfg.ManagementPort,
 		"management API port for health and readiness endpoints")
 	cmd.Flags().StringVar(&cfg.DataDir, "data-dir", cfg.DataDir,
 		"data directory path")
+	cmd.Flags().StringVar(&cfg.DeviceStorage, "device-storage", cfg.DeviceStorage,
+		"device storage backend (bolt, memory)")
+	cmd.Flags().StringVar(&cfg.GroupStorage, "group-storage", cfg.GroupStorage,
+		"group storage backend (memory)")
+	cmd.Flags().StringVar(&cfg.ConfigStorage, "config-storage", cfg.ConfigStorage,
+		"configuration storage backend (memory, postgres)")
+	cmd.Flags().StringVar(&cfg.ConfigDSN, "config-dsn", cfg.ConfigDSN,
+		"connection string for the postgres configuration backend")
+	cmd.Flags().StringVar(&cfg.HealthStorage, "health-storage", cfg.HealthStorage,
+		"health storage backend (memory)")
+	cmd.Flags().StringVar(&cfg.LoggingStorage, "logging-storage", cfg.LoggingStorage,
+		"event log storage backend (memory, wal)")
+	cmd.Flags().StringVar(&cfg.RolloutStorage, "rollout-storage", cfg.RolloutStorage,
+		"rollout storage backend (bolt, memory)")
 	cmd.Flags().StringVar(&cfg.HealthExposure, "health-exposure", cfg.HealthExposure,
 		"level of information exposed in health endpoints (minimal, standard, full)")
-
-	if err := cmd.MarkFlagRequired("management-port"); err != nil {
-		return nil, fmt.Errorf("marking management-port flag as required: %w", err)
-	}
+	cmd.Flags().DurationVar(&cfg.HealthReportInterval, "health-report-interval", cfg.HealthReportInterval,
+		"expected interval between device health reports")
+	cmd.Flags().IntVar(&cfg.MissedHealthReports, "missed-health-reports", cfg.MissedHealthReports,
+		"consecutive missed health reports before a device is marked offline")
+	cmd.Flags().DurationVar(&cfg.DriftInterval, "drift-interval", cfg.DriftInterval,
+		"interval between configuration drift checks")
+	cmd.Flags().BoolVar(&cfg.DriftRemediate, "drift-remediate", cfg.DriftRemediate,
+		"re-deploy the expected configuration to devices found drifted")
 
 	return cmd, nil
 }
@@ -146,12 +185,11 @@ func startServer(ctx context.Context, cfg *options.Config) error {
 	log := zap.New(core,
 		zap.AddCaller(),
 		zap.Fields(
-			zap.Int("stage", cfg.LogStage),
 			zap.String("component", "wfcentral"),
 		),
 	)
 
-	// Add stage awareness
+	// Add stage awareness, which also adds the stage field
 	log = logging.WithStage(log, cfg.LogStage)
 
 	defer func() {
@@ -179,10 +217,20 @@ func startServer(ctx context.Context, cfg *options.Config) error {
 		zap.String("log_level", cfg.LogLevel),
 	)
 
+	// Cancelling the run context triggers the server's graceful shutdown,
+	// which releases resources such as the device database lock.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// Handle shutdown signal in a separate goroutine
 	go func() {
-		sig := <-sigChan
-		log.Info("received shutdown signal", zap.String("signal", sig.String()))
+		select {
+		case sig := <-sigChan:
+			log.Info("received shutdown signal", zap.String("signal", sig.String()))
+			cancel()
+		case <-runCtx.Done():
+			return
+		}
 
 		// Create context with timeout for graceful shutdown
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
@@ -192,13 +240,14 @@ func startServer(ctx context.Context, cfg *options.Config) error {
 		select {
 		case <-shutdownCtx.Done():
 			log.Warn("shutdown timed out", zap.Duration("timeout", shutdownTimeout))
-		case <-ctx.Done():
+			os.Exit(1)
+		case <-srv.Stopped():
 			log.Info("shutdown completed")
 		}
 	}()
 
 	// Run server until shutdown
-	if err := srv.Start(ctx); err != nil {
+	if err := srv.Start(runCtx); err != nil {
 		log.Error("server error", zap.Error(err))
 		return err
 	}
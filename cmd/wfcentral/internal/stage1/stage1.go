@@ -51,8 +51,12 @@ Device commands provide comprehensive management capabilities including:
   # Show device health metrics
   wfcentral device health device-1
 
+  # List devices whose configuration drifted from their deployments
+  wfcentral device drift
+
   # Manage device configuration
   wfcentral device config show device-1
+  wfcentral device config effective device-1
   wfcentral device config validate device-1 --config new-config.yaml
   wfcentral device config apply device-1 --config new-config.yaml`,
 	}
@@ -76,6 +80,12 @@ Device commands provide comprehensive management capabilities including:
 	}
 	deviceCmd.AddCommand(healthCmd)
 
+	driftCmd, err := newDeviceDriftCmd(cfg)
+	if err != nil {
+		return fmt.Errorf("creating device drift command: %w", err)
+	}
+	deviceCmd.AddCommand(driftCmd)
+
 	// Device configuration commands
 	configCmd := &cobra.Command{
 		Use:   "config",
@@ -84,6 +94,7 @@ Device commands provide comprehensive management capabilities including:
 
 Configuration management includes:
 - Viewing current configurations
+- Viewing configurations with group settings applied
 - Validating new configurations
 - Applying configuration changes
 - Monitoring configuration status`,
@@ -96,6 +107,12 @@ Configuration management includes:
 	}
 	configCmd.AddCommand(showCmd)
 
+	effectiveCmd, err := newConfigEffectiveCmd(cfg)
+	if err != nil {
+		return fmt.Errorf("creating config effective command: %w", err)
+	}
+	configCmd.AddCommand(effectiveCmd)
+
 	validateCmd, err := newConfigValidateCmd(cfg)
 	if err != nil {
 		return fmt.Errorf("creating config validate command: %w", err)
@@ -114,5 +131,98 @@ Configuration management includes:
 	// Add device command to root
 	root.AddCommand(deviceCmd)
 
+	// Device group commands
+	groupCmd := &cobra.Command{
+		Use:   "group",
+		Short: "Manage device groups",
+		Long: `Commands for organizing devices into groups and group hierarchies.
+
+Groups come in two kinds:
+- Static groups, whose devices are added and removed by hand
+- Dynamic groups, whose devices are those matching a query
+
+Groups nest beneath a parent group, and configuration set on a group
+applies to the devices of every group beneath it.`,
+		Example: `  # Build a small hierarchy
+  wfcentral group create eu
+  wfcentral group create plant-3 --parent eu --tag site=plant-3
+
+  # Show it, and the state of the devices in it
+  wfcentral group tree
+  wfcentral group status eu
+
+  # Deploy a configuration to a group
+  wfcentral group deploy plant-3 edge.yaml --template edge`,
+	}
+
+	groupCommands := []struct {
+		name string
+		new  func(*options.Config) (*cobra.Command, error)
+	}{
+		{"create", newGroupCreateCmd},
+		{"list", newGroupListCmd},
+		{"show", newGroupShowCmd},
+		{"status", newGroupStatusCmd},
+		{"update", newGroupUpdateCmd},
+		{"delete", newGroupDeleteCmd},
+		{"move", newGroupMoveCmd},
+		{"add", newGroupAddCmd},
+		{"remove", newGroupRemoveCmd},
+		{"devices", newGroupDevicesCmd},
+		{"tree", newGroupTreeCmd},
+		{"deploy", newGroupDeployCmd},
+		{"fsck", newGroupFsckCmd},
+	}
+	for _, sub := range groupCommands {
+		subCmd, err := sub.new(cfg)
+		if err != nil {
+			return fmt.Errorf("creating group %s command: %w", sub.name, err)
+		}
+		groupCmd.AddCommand(subCmd)
+	}
+	root.AddCommand(groupCmd)
+
+	// Staged configuration rollout commands
+	rolloutCmd := &cobra.Command{
+		Use:   "rollout",
+		Short: "Roll out configurations to device groups",
+		Long: `Commands for staged configuration rollouts to device groups.
+
+A rollout deploys a configuration version to a group in waves:
+- A canary wave with a small share of the group
+- Batches of devices with a pause between them
+- An automatic halt when a wave's failure rate exceeds the threshold
+
+Rollouts can be paused, resumed and aborted while they run.`,
+		Example: `  # Start a canary rollout of a new configuration
+  wfcentral rollout start 7d9c... --template edge --config edge.yaml --canary 10 --batch-size 20
+
+  # Follow its progress
+  wfcentral rollout status 3f2a...
+
+  # Resume it after a halt
+  wfcentral rollout resume 3f2a...`,
+	}
+
+	rolloutCommands := []struct {
+		name string
+		new  func(*options.Config) (*cobra.Command, error)
+	}{
+		{"start", newRolloutStartCmd},
+		{"list", newRolloutListCmd},
+		{"status", newRolloutStatusCmd},
+		{"pause", newRolloutPauseCmd},
+		{"resume", newRolloutResumeCmd},
+		{"abort", newRolloutAbortCmd},
+	}
+	for _, sub := range rolloutCommands {
+		subCmd, err := sub.new(cfg)
+		if err != nil {
+			return fmt.Errorf("creating rollout %s command: %w", sub.name, err)
+		}
+		rolloutCmd.AddCommand(subCmd)
+	}
+	root.AddCommand(rolloutCmd)
+
 	return nil
 }
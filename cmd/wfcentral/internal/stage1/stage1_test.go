@@ -0,0 +1,232 @@
+package stage1
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/cmd/wfcentral/options"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+)
+
+// reply is a canned control plane response with a status other than 200.
+// A nil body writes no body.
+type reply struct {
+	status int
+	body   interface{}
+}
+
+// recordedRequest is a request received by fakeCentral
+type recordedRequest struct {
+	method string
+	path   string
+	query  string
+	body   []byte
+}
+
+// fakeCentral serves canned responses keyed by "METHOD /path" and records
+// every request it receives. A route answered with an http.HandlerFunc
+// calls it, one answered with an error writes the error and one answered
+// with any other value replies 200 with the value as JSON. Unknown routes
+// answer 404.
+type fakeCentral struct {
+	t      *testing.T
+	routes map[string]interface{}
+
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+// newFakeCentral starts a fake control plane and returns a configuration
+// pointing the commands at it.
+func newFakeCentral(t *testing.T, routes map[string]interface{}) (*fakeCentral, *options.Config) {
+	t.Helper()
+	f := &fakeCentral{t: t, routes: routes}
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	t.Setenv(options.CredentialEnv, "test-key")
+	return f, &options.Config{Server: ts.URL}
+}
+
+func (f *fakeCentral) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	assert.NoError(f.t, err)
+	f.mu.Lock()
+	f.requests = append(f.requests, recordedRequest{
+		method: r.Method,
+		path:   r.URL.Path,
+		query:  r.URL.RawQuery,
+		body:   body,
+	})
+	f.mu.Unlock()
+
+	resp, ok := f.routes[r.Method+" "+r.URL.Path]
+	if !ok {
+		apierror.Write(w, apierror.NotFound("not found"))
+		return
+	}
+	if handler, ok := resp.(http.HandlerFunc); ok {
+		handler(w, r)
+		return
+	}
+	status := http.StatusOK
+	if rep, ok := resp.(reply); ok {
+		status, resp = rep.status, rep.body
+	}
+	if resp == nil {
+		w.WriteHeader(status)
+		return
+	}
+	if err, ok := resp.(error); ok {
+		apierror.Write(w, err)
+		return
+	}
+	assert.NoError(f.t, apierror.WriteJSON(w, status, resp))
+}
+
+// received returns the requests received for a route, oldest first
+func (f *fakeCentral) received(method, path string) []recordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []recordedRequest
+	for _, r := range f.requests {
+		if r.method == method && r.path == path {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// request returns the last request received for a route
+func (f *fakeCentral) request(method, path string) *recordedRequest {
+	f.t.Helper()
+	received := f.received(method, path)
+	if len(received) == 0 {
+		f.t.Fatalf("no %s %s request received", method, path)
+	}
+	return &received[len(received)-1]
+}
+
+// decodeBody decodes the JSON body of a recorded request
+func (r *recordedRequest) decodeBody(t *testing.T, v interface{}) {
+	t.Helper()
+	require.NoError(t, json.Unmarshal(r.body, v))
+}
+
+// runCommand runs wfcentral with args and stdin, returning what it wrote
+func runCommand(t *testing.T, cfg *options.Config, stdin string, args ...string) (string, error) {
+	t.Helper()
+	root := &cobra.Command{Use: "wfcentral", SilenceUsage: true, SilenceErrors: true}
+	require.NoError(t, AddCommands(root, cfg))
+
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&out)
+	root.SetIn(strings.NewReader(stdin))
+	root.SetArgs(args)
+	err := root.Execute()
+	return out.String(), err
+}
+
+// cliTest is a command run against a fake control plane. Arguments named
+// configArg are replaced by the path of a configuration file. The output
+// is checked whether or not the command fails.
+type cliTest struct {
+	name    string
+	args    []string
+	want    []string // Substrings of the output
+	wantErr string
+	check   func(t *testing.T, central *fakeCentral, out string)
+}
+
+// configArg stands for a configuration file in cliTest arguments
+const configArg = "{config}"
+
+// writeConfigFile writes a configuration file and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// runCLITests runs each test against a fake control plane serving routes
+func runCLITests(t *testing.T, routes func() map[string]interface{}, tests []cliTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			central, cfg := newFakeCentral(t, routes())
+			args := make([]string, len(tt.args))
+			for i, arg := range tt.args {
+				if arg == configArg {
+					arg = writeConfigFile(t, "mode: new\n")
+				}
+				args[i] = arg
+			}
+
+			out, err := runCommand(t, cfg, "", args...)
+			if tt.wantErr != "" {
+				require.Error(t, err, out)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err, out)
+			}
+			for _, want := range tt.want {
+				assert.Contains(t, out, want)
+			}
+			if tt.check != nil {
+				tt.check(t, central, out)
+			}
+		})
+	}
+}
+
+// byName answers a list request with the items whose name matches the
+// name query parameter, or all items without one
+func byName[T any](items []T, name func(T) string, wrap func([]T) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want := r.URL.Query().Get("name")
+		matched := make([]T, 0, len(items))
+		for _, item := range items {
+			if want == "" || name(item) == want {
+				matched = append(matched, item)
+			}
+		}
+		_ = apierror.WriteJSON(w, http.StatusOK, wrap(matched))
+	}
+}
+
+// fleetDevices returns the devices known to the fake control plane
+func fleetDevices() []*device.Device {
+	now := time.Now().UTC()
+	return []*device.Device{
+		{ID: "dev-1", TenantID: "tenant-a", Name: "edge-1", Status: device.StatusOnline, UpdatedAt: now},
+		{ID: "dev-2", TenantID: "tenant-a", Name: "edge-2", Status: device.StatusOffline, UpdatedAt: now},
+	}
+}
+
+// withDevices adds the routes resolving fleetDevices by ID and name to
+// routes and returns them
+func withDevices(routes map[string]interface{}) map[string]interface{} {
+	devices := fleetDevices()
+	routes["GET "+api.PathDevices] = byName(devices,
+		func(d *device.Device) string { return d.Name },
+		func(devices []*device.Device) interface{} { return api.DeviceListResponse{Devices: devices} })
+	for _, d := range devices {
+		routes["GET "+api.DevicePath(d.ID)] = api.DeviceResponse{Device: d}
+	}
+	return routes
+}
@@ -0,0 +1,39 @@
+package options
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wrale/wrale-fleet/internal/central/client"
+)
+
+// CredentialEnv names the environment variable holding the client
+// credential when no credential file is configured.
+const CredentialEnv = "WFCENTRAL_CREDENTIAL"
+
+// NewClient creates a control plane API client for CLI commands from the
+// configured server address and credential.
+func NewClient(cfg *Config) (*client.Client, error) {
+	credential, err := cfg.credential()
+	if err != nil {
+		return nil, err
+	}
+	if credential == "" {
+		return nil, fmt.Errorf("no credential configured (use --credential-file or %s)", CredentialEnv)
+	}
+	return client.New(cfg.Server, client.WithCredential(credential))
+}
+
+// credential reads the client credential from the credential file or the
+// environment.
+func (c *Config) credential() (string, error) {
+	if c.CredentialFile == "" {
+		return strings.TrimSpace(os.Getenv(CredentialEnv)), nil
+	}
+	data, err := os.ReadFile(c.CredentialFile)
+	if err != nil {
+		return "", fmt.Errorf("reading credential file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,218 @@
+package options
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/central/server"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the YAML configuration file format for wfcentral. Every field is
+// optional; omitted fields keep their defaults. For example:
+//
+//	port: "8600"
+//	management_port: "8601"
+//	data_dir: /var/lib/wfcentral
+//	storage:
+//	  device: bolt
+//	  config: postgres
+//	  config_dsn: postgres://fleet@db/fleet?sslmode=require
+//	  logging: wal
+//	  rollout: bolt
+//	health_reports:
+//	  interval: 1m
+//	  missed_reports: 3
+//	drift:
+//	  interval: 5m
+//	  remediate: true
+//	auth:
+//	  api_keys:
+//	    - id: ops
+//	      tenant_id: acme
+//	      subject: ops-team
+//	      sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    - id: enroll
+//	      tenant_id: acme
+//	      scope: enrollment
+//	      sha256: 53bcbb91525547bf679e9d4f85ea97148fa49ff2b46ef7af8fd4b4a1ec5af13b
+//	  token_issuer: fleet-idp
+//	  token_keys:
+//	    - id: idp-2024
+//	      algorithm: EdDSA
+//	      public_key: 11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=
+//	    - id: ci
+//	      algorithm: HS256
+//	      secret_file: secrets/ci-token.key
+type File struct {
+	Port           string            `yaml:"port"`
+	ManagementPort string            `yaml:"management_port"`
+	DataDir        string            `yaml:"data_dir"`
+	HealthExposure string            `yaml:"health_exposure"`
+	LogLevel       string            `yaml:"log_level"`
+	Storage        FileStorage       `yaml:"storage"`
+	HealthReports  FileHealthReports `yaml:"health_reports"`
+	Drift          FileDrift         `yaml:"drift"`
+	Auth           FileAuth          `yaml:"auth"`
+}
+
+// FileStorage selects storage backends by registered name.
+type FileStorage struct {
+	Device    string `yaml:"device"`
+	Group     string `yaml:"group"`
+	Config    string `yaml:"config"`
+	ConfigDSN string `yaml:"config_dsn"`
+	Health    string `yaml:"health"`
+	Logging   string `yaml:"logging"`
+	Rollout   string `yaml:"rollout"`
+}
+
+// FileHealthReports controls device health report ingestion. Interval is a
+// Go duration string such as "30s" or "1m".
+type FileHealthReports struct {
+	Interval      string `yaml:"interval"`
+	MissedReports int    `yaml:"missed_reports"`
+}
+
+// FileDrift controls configuration drift detection. Interval is a Go
+// duration string such as "5m".
+type FileDrift struct {
+	Interval  string `yaml:"interval"`
+	Remediate *bool  `yaml:"remediate"`
+}
+
+// FileAuth lists the credentials accepted by the API. API keys are given by
+// their SHA-256 hash only.
+type FileAuth struct {
+	APIKeys       []auth.APIKey  `yaml:"api_keys"`
+	TokenKeys     []FileTokenKey `yaml:"token_keys"`
+	TokenIssuer   string         `yaml:"token_issuer"`
+	TokenAudience string         `yaml:"token_audience"`
+}
+
+// FileTokenKey is a bearer token verification key. EdDSA keys carry the
+// base64-encoded public key inline; HS256 secrets are read from secret_file,
+// resolved relative to the configuration file.
+type FileTokenKey struct {
+	ID         string `yaml:"id"`
+	Algorithm  string `yaml:"algorithm"`
+	PublicKey  string `yaml:"public_key"`
+	SecretFile string `yaml:"secret_file"`
+}
+
+// LoadFile reads the YAML file at path and applies its values. isSet
+// reports whether the named command-line flag was given explicitly; such
+// flags take precedence over the file. Unknown keys are rejected so typos
+// fail fast instead of being silently ignored.
+func (c *Config) LoadFile(path string, isSet func(flag string) bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var file File
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	fields := []struct {
+		flag  string
+		value string
+		dest  *string
+	}{
+		{"port", file.Port, &c.Port},
+		{"management-port", file.ManagementPort, &c.ManagementPort},
+		{"data-dir", file.DataDir, &c.DataDir},
+		{"health-exposure", file.HealthExposure, &c.HealthExposure},
+		{"log-level", file.LogLevel, &c.LogLevel},
+		{"device-storage", file.Storage.Device, &c.DeviceStorage},
+		{"group-storage", file.Storage.Group, &c.GroupStorage},
+		{"config-storage", file.Storage.Config, &c.ConfigStorage},
+		{"config-dsn", file.Storage.ConfigDSN, &c.ConfigDSN},
+		{"health-storage", file.Storage.Health, &c.HealthStorage},
+		{"logging-storage", file.Storage.Logging, &c.LoggingStorage},
+		{"rollout-storage", file.Storage.Rollout, &c.RolloutStorage},
+	}
+	for _, f := range fields {
+		if f.value != "" && !isSet(f.flag) {
+			*f.dest = f.value
+		}
+	}
+
+	if file.HealthReports.Interval != "" && !isSet("health-report-interval") {
+		interval, err := time.ParseDuration(file.HealthReports.Interval)
+		if err != nil {
+			return fmt.Errorf("config file %s: health_reports.interval: %w", path, err)
+		}
+		c.HealthReportInterval = interval
+	}
+	if file.HealthReports.MissedReports != 0 && !isSet("missed-health-reports") {
+		c.MissedHealthReports = file.HealthReports.MissedReports
+	}
+	if file.Drift.Interval != "" && !isSet("drift-interval") {
+		interval, err := time.ParseDuration(file.Drift.Interval)
+		if err != nil {
+			return fmt.Errorf("config file %s: drift.interval: %w", path, err)
+		}
+		c.DriftInterval = interval
+	}
+	if file.Drift.Remediate != nil && !isSet("drift-remediate") {
+		c.DriftRemediate = *file.Drift.Remediate
+	}
+
+	authCfg, err := file.Auth.serverConfig(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	c.Auth = authCfg
+
+	return nil
+}
+
+// serverConfig resolves key material and converts the auth section into
+// server configuration. Relative secret files are resolved against baseDir.
+func (a FileAuth) serverConfig(baseDir string) (server.AuthConfig, error) {
+	cfg := server.AuthConfig{
+		APIKeys:       a.APIKeys,
+		TokenIssuer:   a.TokenIssuer,
+		TokenAudience: a.TokenAudience,
+	}
+
+	for _, k := range a.TokenKeys {
+		key := auth.TokenKey{ID: k.ID, Algorithm: auth.Algorithm(k.Algorithm)}
+		switch key.Algorithm {
+		case auth.AlgorithmEdDSA:
+			pub, err := base64.StdEncoding.DecodeString(k.PublicKey)
+			if err != nil {
+				return cfg, fmt.Errorf("token key %q: decoding public_key: %w", k.ID, err)
+			}
+			key.PublicKey = ed25519.PublicKey(pub)
+		case auth.AlgorithmHS256:
+			secretPath := k.SecretFile
+			if secretPath == "" {
+				return cfg, fmt.Errorf("token key %q: secret_file is required for HS256", k.ID)
+			}
+			if !filepath.IsAbs(secretPath) {
+				secretPath = filepath.Join(baseDir, secretPath)
+			}
+			secret, err := os.ReadFile(secretPath)
+			if err != nil {
+				return cfg, fmt.Errorf("token key %q: reading secret_file: %w", k.ID, err)
+			}
+			key.Secret = bytes.TrimSpace(secret)
+		}
+		cfg.TokenKeys = append(cfg.TokenKeys, key)
+	}
+
+	return cfg, nil
+}
@@ -8,8 +8,8 @@ import (
 	"time"
 
 	"github.com/wrale/wrale-fleet/internal/central/server"
-	"github.com/wrale/wrale-fleet/internal/fleet/logging"
-	"github.com/wrale/wrale-fleet/internal/fleet/logging/store/factory"
+	"github.com/wrale/wrale-fleet/internal/fleet/drift"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -23,6 +23,19 @@ type Config struct {
 	// DataDir is the path for persistent storage
 	DataDir string
 
+	// ConfigFile is an optional YAML file providing defaults for these
+	// options; explicitly set flags take precedence over it
+	ConfigFile string
+
+	// Storage backend selection, by registered backend name
+	DeviceStorage  string // Device store backend (bolt, memory)
+	GroupStorage   string // Group store backend (memory)
+	ConfigStorage  string // Config store backend (memory, postgres)
+	ConfigDSN      string // Connection string for the postgres config backend
+	HealthStorage  string // Health store backend (memory)
+	LoggingStorage string // Event log backend (memory, wal)
+	RolloutStorage string // Rollout store backend (bolt, memory)
+
 	// Logging configuration
 	LogLevel string // Logging level (debug, info, warn, error)
 	LogFile  string // Log file path (empty for stdout)
@@ -33,24 +46,62 @@ type Config struct {
 	// This must be explicitly configured for proper security setup
 	ManagementPort string
 
+	// Auth lists the API credentials accepted by the server. It is only
+	// settable through the configuration file so secrets stay off the
+	// command line.
+	Auth server.AuthConfig
+
 	// HealthExposure controls how much information is exposed in health endpoints
 	// Valid values are: "minimal", "standard", "full"
 	// - minimal: Only basic health status
 	// - standard: Includes version and uptime (default)
 	// - full: All available health information
 	HealthExposure string
+
+	// Device health reporting: devices are marked offline after missing
+	// MissedHealthReports consecutive reports at HealthReportInterval
+	HealthReportInterval time.Duration
+	MissedHealthReports  int
+
+	// Configuration drift detection: reported configuration hashes are
+	// compared with deployments every DriftInterval, and drifted devices
+	// are re-deployed their expected configuration when DriftRemediate is
+	// set
+	DriftInterval  time.Duration
+	DriftRemediate bool
+
+	// Server is the control plane address used by client commands such as
+	// "device list"
+	Server string
+
+	// CredentialFile holds the API key or bearer token client commands
+	// authenticate with. The WFCENTRAL_CREDENTIAL environment variable is
+	// used when it is unset, keeping the secret off the command line.
+	CredentialFile string
 }
 
 // New creates a new Config with sensible default values that prioritize security
 // while requiring explicit port configuration. The management port must be
 // explicitly set at runtime, so we don't default it here.
 func New() *Config {
+	storage := server.DefaultStorageConfig()
 	return &Config{
 		Port:           "8600",               // Default main API port
 		DataDir:        "/var/lib/wfcentral", // Default data directory
-		LogLevel:       "info",               // Default log level
-		LogStage:       1,                    // Default to Stage 1 capabilities
-		HealthExposure: "standard",           // Default to standard health information exposure
+		DeviceStorage:  storage.Device,
+		GroupStorage:   storage.Group,
+		ConfigStorage:  storage.Config,
+		HealthStorage:  storage.Health,
+		LoggingStorage: storage.Logging,
+		RolloutStorage: storage.Rollout,
+		LogLevel:       "info",           // Default log level
+		LogStage:       1,                // Default to Stage 1 capabilities
+		HealthExposure: "standard",       // Default to standard health information exposure
+		Server:         "localhost:8600", // Default to the local control plane
+
+		HealthReportInterval: health.DefaultReportInterval,
+		MissedHealthReports:  health.DefaultMissedReports,
+		DriftInterval:        drift.DefaultInterval,
 	}
 }
 
@@ -67,7 +118,7 @@ func NewServer(cfg *Config) (*server.Server, error) {
 
 	// Management port must be explicitly configured
 	if cfg.ManagementPort == "" {
-		return nil, fmt.Errorf("management-port must be specified (use --management-port flag)")
+		return nil, fmt.Errorf("management-port must be specified (use --management-port or management_port in the config file)")
 	}
 
 	// Validate management port
@@ -81,16 +132,20 @@ func NewServer(cfg *Config) (*server.Server, error) {
 		return nil, fmt.Errorf("management port must be different from main API port")
 	}
 
-	// Initialize the logging service
-	loggingStore := factory.NewMemoryStore() // Using factory method instead of direct implementation
-	loggingService, err := logging.NewService(loggingStore, nil,
-		logging.WithRetentionPolicy(logging.EventSystem, 30*24*time.Hour),      // 30 days for system events
-		logging.WithRetentionPolicy(logging.EventSecurity, 90*24*time.Hour),    // 90 days for security events
-		logging.WithRetentionPolicy(logging.EventAudit, 365*24*time.Hour),      // 1 year for audit events
-		logging.WithRetentionPolicy(logging.EventCompliance, 730*24*time.Hour), // 2 years for compliance events
-	)
-	if err != nil {
-		return nil, fmt.Errorf("initializing logging service: %w", err)
+	if cfg.HealthReportInterval <= 0 {
+		return nil, fmt.Errorf("health report interval must be positive")
+	}
+	if cfg.MissedHealthReports < 1 {
+		return nil, fmt.Errorf("missed health reports must be at least 1")
+	}
+	if cfg.DriftInterval <= 0 {
+		return nil, fmt.Errorf("drift interval must be positive")
+	}
+
+	// Reject unknown storage backends before anything is opened
+	storage := cfg.storageConfig()
+	if err := storage.Validate(); err != nil {
+		return nil, err
 	}
 
 	// Convert log level
@@ -144,14 +199,24 @@ func NewServer(cfg *Config) (*server.Server, error) {
 
 	// Create internal server configuration
 	serverConfig := &server.Config{
-		Port:     cfg.Port,
-		DataDir:  cfg.DataDir,
-		LogLevel: cfg.LogLevel,
+		Port:         cfg.Port,
+		DataDir:      cfg.DataDir,
+		LogLevel:     cfg.LogLevel,
+		Stage1Config: &server.Stage1Config{},
+		Storage:      storage,
+		Auth:         cfg.Auth,
+		HealthReports: server.HealthReportConfig{
+			Interval:      cfg.HealthReportInterval,
+			MissedReports: cfg.MissedHealthReports,
+		},
+		Drift: server.DriftConfig{
+			Interval:  cfg.DriftInterval,
+			Remediate: cfg.DriftRemediate,
+		},
 		ManagementConfig: &server.ManagementConfig{
 			Port:          cfg.ManagementPort,
 			ExposureLevel: server.ExposureLevel(cfg.HealthExposure),
 		},
-		LoggingService: loggingService,
 	}
 
 	// Create and validate server instance
@@ -163,6 +228,19 @@ func NewServer(cfg *Config) (*server.Server, error) {
 	return srv, nil
 }
 
+// storageConfig converts the storage options into server configuration
+func (c *Config) storageConfig() server.StorageConfig {
+	return server.StorageConfig{
+		Device:    c.DeviceStorage,
+		Group:     c.GroupStorage,
+		Config:    c.ConfigStorage,
+		ConfigDSN: c.ConfigDSN,
+		Health:    c.HealthStorage,
+		Logging:   c.LoggingStorage,
+		Rollout:   c.RolloutStorage,
+	}
+}
+
 // ValidateHealthExposure checks if the given exposure level is valid.
 // This helper function can be used by CLI commands to validate user input
 // before attempting server creation.
@@ -3,12 +3,18 @@ package stage1
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/wrale/wrale-fleet/cmd/wfdevice/options"
 )
 
 func newNotifyShutdownCmd(cfg *options.Config) (*cobra.Command, error) {
+	var (
+		reason   string
+		returnIn time.Duration
+	)
+
 	cmd := &cobra.Command{
 		Use:   "notify-shutdown",
 		Short: "Signal planned shutdown to control plane",
@@ -20,30 +26,53 @@ This command ensures graceful handling of planned shutdowns by:
 - Ensuring state is properly saved
 - Preventing unnecessary alerts
 
+The notice is signed with the device credentials issued at registration.
+The control plane moves the device into maintenance and does not treat
+missed health reports as an outage until the expected return time. If the
+device has not reported again by then it is marked offline.
+
 This should be called before planned maintenance or
 controlled shutdowns to maintain system health.`,
 		Example: `  # Notify planned shutdown
-  wfdevice notify-shutdown`,
+  wfdevice notify-shutdown
+
+  # Announce a firmware update expected to take 30 minutes
+  wfdevice notify-shutdown --reason "firmware update" --return-in 30m`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runNotifyShutdown(cmd.Context(), cfg)
+			return runNotifyShutdown(cmd.Context(), cfg, reason, returnIn)
 		},
 	}
 
+	cmd.Flags().StringVar(&reason, "reason", "planned shutdown", "Reason recorded with the maintenance window")
+	cmd.Flags().DurationVar(&returnIn, "return-in", 0,
+		"Expected time until the device is back (defaults to the control plane's window)")
+
 	return cmd, nil
 }
 
-func runNotifyShutdown(ctx context.Context, cfg *options.Config) error {
-	// Get handle to running server
-	srv, err := options.GetRunningServer()
+func runNotifyShutdown(ctx context.Context, cfg *options.Config, reason string, returnIn time.Duration) error {
+	if returnIn < 0 {
+		return fmt.Errorf("return-in cannot be negative")
+	}
+
+	// Get handle to the running server, or act on the stored registration
+	srv, err := options.CommandServer(cfg)
 	if err != nil {
 		return fmt.Errorf("getting server handle: %w", err)
 	}
 
+	var expectedReturn time.Time
+	if returnIn > 0 {
+		expectedReturn = time.Now().Add(returnIn)
+	}
+
 	// Send shutdown notification
-	if err := srv.NotifyShutdown(ctx); err != nil {
+	resp, err := srv.NotifyShutdown(ctx, reason, expectedReturn)
+	if err != nil {
 		return fmt.Errorf("notifying shutdown: %w", err)
 	}
 
-	fmt.Println("Successfully notified control plane of planned shutdown")
+	fmt.Printf("Successfully notified control plane of planned shutdown (maintenance until %s)\n",
+		resp.MaintenanceUntil.Local().Format(time.RFC3339))
 	return nil
 }
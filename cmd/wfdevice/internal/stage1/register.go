@@ -13,6 +13,7 @@ func newRegisterCmd(cfg *options.Config) (*cobra.Command, error) {
 	var (
 		name         string
 		controlPlane string
+		token        string
 		tags         []string
 	)
 
@@ -28,24 +29,32 @@ Registration includes:
 - Initializing security credentials
 - Verifying connectivity
 
+The enrollment token is a tenant API key or signed token accepted by the
+control plane. The device ID and credentials issued in return are stored in
+the data directory, so registering again, or starting the agent, reuses the
+existing identity.
+
 Tags can be specified as key=value pairs and are used for:
 - Device grouping
 - Policy application
 - Resource organization
 - Operation targeting`,
 		Example: `  # Register with required fields
-  wfdevice register --name device-1 --control-plane central.example.com
+  wfdevice register --name device-1 --control-plane central.example.com \
+    --enrollment-token $WFDEVICE_ENROLLMENT_TOKEN
 
   # Register with tags
   wfdevice register --name device-1 --control-plane central.example.com \
+    --enrollment-token $WFDEVICE_ENROLLMENT_TOKEN \
     --tags environment=production,location=datacenter-1`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runRegister(cmd.Context(), cfg, name, controlPlane, tags)
+			return runRegister(cmd.Context(), cfg, name, controlPlane, token, tags)
 		},
 	}
 
 	cmd.Flags().StringVar(&name, "name", "", "Device name")
 	cmd.Flags().StringVar(&controlPlane, "control-plane", "", "Control plane address")
+	cmd.Flags().StringVar(&token, "enrollment-token", "", "Credential used to enroll the device with the control plane")
 	cmd.Flags().StringSliceVar(&tags, "tags", []string{}, "Device tags (key=value)")
 
 	// Mark required flags and handle potential errors
@@ -59,7 +68,7 @@ Tags can be specified as key=value pairs and are used for:
 	return cmd, nil
 }
 
-func runRegister(ctx context.Context, cfg *options.Config, name, controlPlane string, tags []string) error {
+func runRegister(ctx context.Context, cfg *options.Config, name, controlPlane, token string, tags []string) error {
 	// Validate registration parameters
 	if err := validateRegisterParams(name, controlPlane); err != nil {
 		return fmt.Errorf("invalid parameters: %w", err)
@@ -72,17 +81,19 @@ func runRegister(ctx context.Context, cfg *options.Config, name, controlPlane st
 	}
 
 	// Initialize registration client
-	client, err := options.NewRegistrationClient(controlPlane)
+	client, err := options.NewRegistrationClient(controlPlane, token, cfg.DataDir)
 	if err != nil {
 		return fmt.Errorf("initializing registration client: %w", err)
 	}
 
 	// Perform registration
-	if err := client.Register(ctx, name, tagMap); err != nil {
+	reg, err := client.Register(ctx, name, tagMap)
+	if err != nil {
 		return fmt.Errorf("registering device: %w", err)
 	}
 
-	fmt.Printf("Successfully registered device '%s' with control plane\n", name)
+	fmt.Printf("Successfully registered device '%s' with control plane (device ID %s, tenant %s)\n",
+		reg.Name, reg.DeviceID, reg.TenantID)
 	return nil
 }
 
@@ -39,7 +39,8 @@ The agent runs until stopped by either:
   wfdevice start --management-port 9091 --health-exposure full
 
   # Start with device name and control plane connection
-  wfdevice start --management-port 9091 --name device1 --control-plane localhost:8600`,
+  wfdevice start --management-port 9091 --name device1 --control-plane localhost:8600 \
+    --enrollment-token $WFDEVICE_ENROLLMENT_TOKEN`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runStart(cmd.Context(), cfg)
 		},
@@ -56,6 +57,10 @@ The agent runs until stopped by either:
 		"device name for identification")
 	cmd.Flags().StringVar(&cfg.ControlPlane, "control-plane", cfg.ControlPlane,
 		"control plane address for registration")
+	cmd.Flags().StringVar(&cfg.EnrollmentToken, "enrollment-token", cfg.EnrollmentToken,
+		"credential used to register with the control plane on first start")
+	cmd.Flags().DurationVar(&cfg.ConfigWatch, "config-watch", cfg.ConfigWatch,
+		"how long health checks must pass after a configuration change before it is kept (0 disables rollback)")
 
 	// Mark management port as required for security
 	if err := cmd.MarkFlagRequired("management-port"); err != nil {
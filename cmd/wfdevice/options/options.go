@@ -53,6 +53,14 @@ type Config struct {
 	Name         string            // Device identifier
 	ControlPlane string            // Control plane address
 	Tags         map[string]string // Device metadata tags
+
+	// EnrollmentToken authenticates the first registration with the control
+	// plane. Later calls use the device credentials issued at registration.
+	EnrollmentToken string
+
+	// ConfigWatch is how long health checks must keep passing after a
+	// configuration change before it is kept (zero disables the watch)
+	ConfigWatch time.Duration
 }
 
 // New creates a new Config with default values.
@@ -63,6 +71,7 @@ func New() *Config {
 		LogLevel:       "info",              // Default log level
 		LogStage:       1,                   // Default to Stage 1 capabilities
 		HealthExposure: "standard",          // Default to standard health information exposure
+		ConfigWatch:    30 * time.Second,    // Default post-apply health watch
 		Tags:           make(map[string]string),
 	}
 }
@@ -119,6 +128,10 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid health exposure level: %s (must be minimal, standard, or full)", c.HealthExposure)
 	}
 
+	if c.ConfigWatch < 0 {
+		return fmt.Errorf("invalid config watch period: %s (must not be negative)", c.ConfigWatch)
+	}
+
 	return nil
 }
 
@@ -195,6 +208,7 @@ func NewServer(cfg *Config) (*server.Server, error) {
 		server.WithDataDir(cfg.DataDir),
 		server.WithManagementPort(cfg.ManagementPort),
 		server.WithHealthExposure(cfg.HealthExposure),
+		server.WithConfigWatch(cfg.ConfigWatch),
 		server.WithLogging(loggingService),
 	)
 
@@ -208,6 +222,9 @@ func NewServer(cfg *Config) (*server.Server, error) {
 	if len(cfg.Tags) > 0 {
 		opts = append(opts, server.WithTags(cfg.Tags))
 	}
+	if cfg.EnrollmentToken != "" {
+		opts = append(opts, server.WithEnrollmentToken(cfg.EnrollmentToken))
+	}
 
 	// Create server instance
 	srv, err := server.New(logger, opts...)
@@ -244,58 +261,87 @@ func ClearRunningServer() {
 	globalServerLock.Unlock()
 }
 
+// CommandServer returns the running server when the command executes inside
+// the agent process. Otherwise it creates a server that is never started and
+// acts on the registration stored in cfg.DataDir, which lets one-shot
+// commands talk to the control plane on the agent's behalf.
+func CommandServer(cfg *Config) (*server.Server, error) {
+	if srv, err := GetRunningServer(); err == nil {
+		return srv, nil
+	}
+	oneShot := *cfg
+	return newCommandServer(&oneShot)
+}
+
+// newCommandServer creates a server for a one-shot command. The ports are
+// only needed to pass validation; the server never listens.
+func newCommandServer(cfg *Config) (*server.Server, error) {
+	if cfg.Port == "" {
+		cfg.Port = "9090"
+	}
+	if cfg.ManagementPort == "" || cfg.ManagementPort == cfg.Port {
+		mgmtPort := 9091
+		if port, err := strconv.Atoi(cfg.Port); err == nil {
+			mgmtPort = port + 1
+		}
+		cfg.ManagementPort = strconv.Itoa(mgmtPort)
+	}
+	cfg.HealthExposure = "minimal" // Use minimal exposure for one-shot commands
+
+	return NewServer(cfg)
+}
+
 // NewRegistrationClient creates a new client for device registration.
-// Implements registration with the control plane.
-func NewRegistrationClient(controlPlane string) (*RegistrationClient, error) {
+// The enrollment token authenticates the device with the control plane and
+// the resulting registration is stored in dataDir.
+func NewRegistrationClient(controlPlane, enrollmentToken, dataDir string) (*RegistrationClient, error) {
 	if controlPlane == "" {
 		return nil, fmt.Errorf("control plane address is required")
 	}
+	if dataDir == "" {
+		return nil, fmt.Errorf("data directory is required")
+	}
 
 	return &RegistrationClient{
-		controlPlane: controlPlane,
-		timeout:      30 * time.Second,
+		controlPlane:    controlPlane,
+		enrollmentToken: enrollmentToken,
+		dataDir:         dataDir,
+		timeout:         30 * time.Second,
 	}, nil
 }
 
 // RegistrationClient handles device registration with the control plane.
 type RegistrationClient struct {
-	controlPlane string
-	timeout      time.Duration
+	controlPlane    string
+	enrollmentToken string
+	dataDir         string
+	timeout         time.Duration
 }
 
-// Register registers a device with the control plane.
-func (c *RegistrationClient) Register(ctx context.Context, name string, tags map[string]string) error {
+// Register registers a device with the control plane and returns the
+// registration in effect. A device that is already registered with the same
+// control plane keeps its existing identity.
+func (c *RegistrationClient) Register(ctx context.Context, name string, tags map[string]string) (*server.Registration, error) {
 	// Validate registration parameters
 	if name == "" {
-		return fmt.Errorf("device name is required")
+		return nil, fmt.Errorf("device name is required")
 	}
 
 	// Create a server instance for registration
-	cfg := &Config{
-		Name:         name,
-		ControlPlane: c.controlPlane,
-		Tags:         tags,
-	}
-
-	// Set required fields with defaults for registration
-	cfg.Port = "9090"
-	cfg.ManagementPort = "9091"
-	cfg.HealthExposure = "minimal" // Use minimal exposure during registration
-	cfg.DataDir = "/var/lib/wfdevice"
-
-	srv, err := NewServer(cfg)
+	cfg := New()
+	cfg.Name = name
+	cfg.ControlPlane = c.controlPlane
+	cfg.EnrollmentToken = c.enrollmentToken
+	cfg.DataDir = c.dataDir
+	cfg.Tags = tags
+
+	srv, err := newCommandServer(cfg)
 	if err != nil {
-		return fmt.Errorf("creating server for registration: %w", err)
+		return nil, fmt.Errorf("creating server for registration: %w", err)
 	}
 
-	// Set as the running server
-	SetRunningServer(srv)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 
-	// Run the server to complete registration
-	if err := srv.Run(ctx); err != nil {
-		ClearRunningServer()
-		return fmt.Errorf("running server for registration: %w", err)
-	}
-
-	return nil
+	return srv.Register(ctx)
 }
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+// configFile is the name of the file storing the applied configuration
+const configFile = "config.json"
+
+// ApplyConfig validates and applies a configuration document, persisting it
+// in the data directory so it survives restarts. It returns the SHA-256 of
+// the configuration in effect afterwards. Applying the configuration the
+// device already runs changes nothing.
+//
+// A changed configuration is kept only if the registered health checks keep
+// passing for the configured watch period. Otherwise the previous
+// configuration is restored and a *RollbackError is returned.
+func (s *Server) ApplyConfig(ctx context.Context, config json.RawMessage, appliedBy string) (string, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(config, &doc); err != nil || doc == nil {
+		return "", fmt.Errorf("configuration must be a JSON object")
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	hash, previous, err := s.setConfig(config, appliedBy)
+	if err != nil || previous == nil {
+		return hash, err
+	}
+
+	if err := s.watchConfig(ctx); err != nil {
+		return s.rollbackConfig(hash, previous, err)
+	}
+	return hash, nil
+}
+
+// setConfig persists and applies a configuration. It returns the history
+// entry the configuration replaced, or nil when nothing changed. A device
+// without configuration history replaces an empty entry.
+func (s *Server) setConfig(config json.RawMessage, appliedBy string) (string, *device.ConfigVersion, error) {
+	hash := configHash(config)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if hash == s.device.LastConfigHash {
+		return hash, nil, nil
+	}
+	previous := &device.ConfigVersion{}
+	if n := len(s.device.ConfigHistory); n > 0 {
+		entry := s.device.ConfigHistory[n-1]
+		previous = &entry
+	}
+
+	if s.cfg.DataDir != "" {
+		if err := s.saveConfig(config); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := s.device.SetConfig(config, appliedBy); err != nil {
+		return "", nil, err
+	}
+
+	s.logger.Info("applied device configuration",
+		zap.String("name", s.device.Name),
+		zap.String("hash", hash),
+		zap.String("applied_by", appliedBy),
+		zap.Int("config_size", len(config)))
+	return hash, previous, nil
+}
+
+// startConfigSync begins polling the control plane for pending
+// deployments. The first poll happens immediately.
+func (s *Server) startConfigSync() {
+	s.stopConfig = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.syncConfig(); err != nil {
+				s.logger.Error("failed to synchronize configuration", zap.Error(err))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.stopConfig:
+				return
+			}
+		}
+	}()
+}
+
+// syncConfig applies the pending deployment, if any, and acknowledges it.
+// A deployment that cannot be applied is acknowledged with the reason so
+// the control plane marks it failed instead of offering it again.
+func (s *Server) syncConfig() error {
+	s.mu.RLock()
+	reg := s.registration
+	s.mu.RUnlock()
+	if reg == nil {
+		return fmt.Errorf("device not registered with control plane")
+	}
+
+	c, err := client.New(reg.ControlPlane, client.WithBearerToken(reg.Credentials.Token))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), configSyncTimeout)
+	delivery, err := c.PendingConfig(ctx, reg.DeviceID)
+	cancel()
+	if err != nil || delivery == nil {
+		return err
+	}
+
+	// Applying includes the health watch, so it is not bound by the
+	// request timeout
+	ack := &api.ConfigAck{DeploymentID: delivery.DeploymentID}
+	var rollback *RollbackError
+	if got := configHash(delivery.Config); got != delivery.Hash {
+		ack.Error = fmt.Sprintf("received configuration hash %s does not match %s", got, delivery.Hash)
+	} else if hash, err := s.ApplyConfig(context.Background(), delivery.Config, "deployment "+delivery.DeploymentID); errors.As(err, &rollback) {
+		ack.Error = rollback.Error()
+		ack.Hash = rollback.RestoredHash
+		ack.RolledBack = true
+	} else if err != nil {
+		ack.Error = err.Error()
+	} else {
+		ack.Hash = hash
+	}
+
+	if ack.Error != "" {
+		s.logger.Warn("rejected configuration deployment",
+			zap.String("deployment_id", delivery.DeploymentID),
+			zap.String("error", ack.Error))
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), configSyncTimeout)
+	defer cancel()
+	if _, err := c.AcknowledgeConfig(ctx, reg.DeviceID, ack); err != nil {
+		return err
+	}
+	return nil
+}
+
+// configPath returns the path to the applied configuration file
+func (s *Server) configPath() string {
+	return filepath.Join(s.cfg.DataDir, configFile)
+}
+
+// loadConfig restores the configuration applied before a restart. The
+// caller must hold s.mu.
+func (s *Server) loadConfig() error {
+	if s.cfg.DataDir == "" {
+		return nil
+	}
+	path := s.configPath()
+	if err := validatePath(path); err != nil {
+		return fmt.Errorf("invalid config path: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	if !json.Valid(bytes.TrimSpace(data)) {
+		return fmt.Errorf("config file %s is not valid JSON", path)
+	}
+
+	// Seed the history so a failing change can return to this configuration
+	return s.device.SetConfig(json.RawMessage(data), "restored from "+path)
+}
+
+// saveConfig writes the configuration atomically with restrictive
+// permissions. The caller must hold s.mu.
+func (s *Server) saveConfig(config json.RawMessage) error {
+	if err := s.ensureDataDir(); err != nil {
+		return err
+	}
+
+	path := s.configPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, config, filePermissions); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// configHash returns the SHA-256 of a configuration, matching the hashes
+// recorded by the control plane.
+func configHash(config []byte) string {
+	sum := sha256.Sum256(config)
+	return hex.EncodeToString(sum[:])
+}
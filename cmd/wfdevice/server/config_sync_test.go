@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+)
+
+func TestSyncConfigAppliesAndAcknowledges(t *testing.T) {
+	delivered := json.RawMessage(`{"log_level":"debug"}`)
+	var acks []api.ConfigAck
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer device-token", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case api.DeviceConfigPendingPath("dev-123"):
+			_ = json.NewEncoder(w).Encode(api.ConfigDelivery{
+				DeploymentID: "deploy-1",
+				Version:      2,
+				Hash:         configHash(delivered),
+				Config:       delivered,
+			})
+		case api.DeviceConfigAckPath("dev-123"):
+			var ack api.ConfigAck
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&ack))
+			acks = append(acks, ack)
+			_ = json.NewEncoder(w).Encode(api.ConfigAckResponse{})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer central.Close()
+
+	dataDir := t.TempDir()
+	srv := newTestServer(t, WithDataDir(dataDir), WithConfigWatch(0))
+	srv.registration = &Registration{
+		DeviceID:     "dev-123",
+		ControlPlane: central.URL,
+		Credentials:  api.Credentials{Token: "device-token"},
+	}
+
+	// Delivering the same deployment twice applies it once and
+	// acknowledges it both times.
+	require.NoError(t, srv.syncConfig())
+	require.NoError(t, srv.syncConfig())
+	require.Len(t, acks, 2)
+	for _, ack := range acks {
+		assert.Equal(t, api.ConfigAck{DeploymentID: "deploy-1", Hash: configHash(delivered)}, ack)
+	}
+	assert.Len(t, srv.device.ConfigHistory, 1)
+
+	data, err := os.ReadFile(filepath.Join(dataDir, configFile))
+	require.NoError(t, err)
+	assert.Equal(t, string(delivered), string(data))
+
+	// A restarted agent resumes with the applied configuration.
+	restarted := newTestServer(t, WithDataDir(dataDir), WithConfigWatch(0))
+	require.NoError(t, restarted.loadConfig())
+	assert.Equal(t, string(delivered), string(restarted.device.Config))
+	assert.Equal(t, configHash(delivered), restarted.device.LastConfigHash)
+}
+
+func TestSyncConfigReportsRejectedConfig(t *testing.T) {
+	var ack api.ConfigAck
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == api.DeviceConfigAckPath("dev-123") {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&ack))
+			_ = json.NewEncoder(w).Encode(api.ConfigAckResponse{})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.ConfigDelivery{
+			DeploymentID: "deploy-1",
+			Hash:         configHash([]byte(`[1,2]`)),
+			Config:       json.RawMessage(`[1,2]`),
+		})
+	}))
+	defer central.Close()
+
+	srv := newTestServer(t, WithDataDir(t.TempDir()), WithConfigWatch(0))
+	srv.registration = &Registration{DeviceID: "dev-123", ControlPlane: central.URL}
+
+	require.NoError(t, srv.syncConfig())
+	assert.Equal(t, "deploy-1", ack.DeploymentID)
+	assert.Empty(t, ack.Hash)
+	assert.Equal(t, "configuration must be a JSON object", ack.Error)
+	assert.Nil(t, srv.device.Config)
+}
+
+// checkerFunc adapts a function to the health.HealthChecker interface
+type checkerFunc func(ctx context.Context) error
+
+func (f checkerFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestApplyConfigRollsBackOnFailedHealthCheck(t *testing.T) {
+	var ack api.ConfigAck
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == api.DeviceConfigAckPath("dev-123") {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&ack))
+			_ = json.NewEncoder(w).Encode(api.ConfigAckResponse{})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.ConfigDelivery{
+			DeploymentID: "deploy-2",
+			Hash:         configHash([]byte(`{"workers":0}`)),
+			Config:       json.RawMessage(`{"workers":0}`),
+		})
+	}))
+	defer central.Close()
+
+	dataDir := t.TempDir()
+	srv := newTestServer(t, WithDataDir(dataDir), WithConfigWatch(20*time.Millisecond))
+	srv.registration = &Registration{DeviceID: "dev-123", ControlPlane: central.URL}
+
+	var broken bool
+	require.NoError(t, srv.health.RegisterComponent(context.Background(), "workers",
+		checkerFunc(func(context.Context) error {
+			if broken {
+				return errors.New("no workers running")
+			}
+			return nil
+		}),
+		health.ComponentInfo{Name: "workers", Critical: true}))
+
+	good := json.RawMessage(`{"workers":4}`)
+	hash, err := srv.ApplyConfig(context.Background(), good, "local api")
+	require.NoError(t, err)
+	assert.Equal(t, configHash(good), hash)
+
+	broken = true
+	hash, err = srv.ApplyConfig(context.Background(), json.RawMessage(`{"workers":0}`), "local api")
+	var rollback *RollbackError
+	require.True(t, errors.As(err, &rollback), "got %v", err)
+	assert.Equal(t, configHash(good), hash)
+	assert.Equal(t, configHash(good), rollback.RestoredHash)
+	assert.Contains(t, rollback.Error(), "workers: no workers running")
+
+	assert.Equal(t, string(good), string(srv.device.Config))
+	require.Len(t, srv.device.ConfigHistory, 3)
+	assert.Equal(t, "rollback to version 1", srv.device.ConfigHistory[2].AppliedBy)
+	data, err := os.ReadFile(filepath.Join(dataDir, configFile))
+	require.NoError(t, err)
+	assert.Equal(t, string(good), string(data))
+
+	// Deployments report the rollback to the control plane.
+	require.NoError(t, srv.syncConfig())
+	assert.Equal(t, "deploy-2", ack.DeploymentID)
+	assert.True(t, ack.RolledBack)
+	assert.Equal(t, configHash(good), ack.Hash)
+	assert.Contains(t, ack.Error, "no workers running")
+	assert.Equal(t, string(good), string(srv.device.Config))
+}
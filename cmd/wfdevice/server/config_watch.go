@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"go.uber.org/zap"
+)
+
+// RollbackError reports a configuration change that was undone because
+// health checks failed after it was applied.
+type RollbackError struct {
+	// Hash identifies the configuration that failed
+	Hash string
+
+	// RestoredHash identifies the configuration in effect after the
+	// rollback. It is empty when the device had no configuration before.
+	RestoredHash string
+
+	// Err is the health check failure
+	Err error
+}
+
+// Error implements the error interface
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("configuration rolled back after failed health check: %v", e.Err)
+}
+
+// Unwrap returns the health check failure
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// watchConfig runs the registered health checks until the watch period
+// ends, returning the first failure. A canceled context ends the watch
+// early without failing it.
+func (s *Server) watchConfig(ctx context.Context) error {
+	period := s.cfg.ConfigWatch
+	if period <= 0 {
+		return nil
+	}
+	interval := configWatchInterval
+	if interval > period {
+		interval = period
+	}
+
+	deadline := time.NewTimer(period)
+	defer deadline.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.checkConfigHealth(ctx); err != nil {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			// The last check covers the end of the watch period
+			return s.checkConfigHealth(ctx)
+		case <-ctx.Done():
+			s.logger.Warn("configuration health watch interrupted", zap.Error(ctx.Err()))
+			return nil
+		}
+	}
+}
+
+// checkConfigHealth runs the registered health checks once. Degraded
+// components do not fail the check.
+func (s *Server) checkConfigHealth(ctx context.Context) error {
+	resp, err := s.health.CheckHealth(ctx)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("running health checks: %w", err)
+	}
+	if resp.Status != health.StatusUnhealthy {
+		return nil
+	}
+
+	var failures []string
+	for name, status := range resp.Components {
+		if status.Status == health.StatusUnhealthy {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, status.LastError))
+		}
+	}
+	sort.Strings(failures)
+	return fmt.Errorf("unhealthy components: %s", strings.Join(failures, ", "))
+}
+
+// rollbackConfig restores the configuration that a failed change replaced
+// and returns the *RollbackError describing it. An empty previous entry
+// removes the configuration entirely.
+func (s *Server) rollbackConfig(failed string, previous *device.ConfigVersion, cause error) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rollback := &RollbackError{Hash: failed, RestoredHash: previous.Hash, Err: cause}
+	s.logger.Warn("rolling back device configuration",
+		zap.String("name", s.device.Name),
+		zap.String("failed_hash", failed),
+		zap.String("restored_hash", previous.Hash),
+		zap.Error(cause))
+
+	if len(previous.Config) == 0 {
+		if s.cfg.DataDir != "" {
+			if err := os.Remove(s.configPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return failed, fmt.Errorf("removing config after failed health check: %w", err)
+			}
+		}
+		s.device.Config = nil
+		s.device.LastConfigHash = ""
+		return "", rollback
+	}
+
+	if s.cfg.DataDir != "" {
+		if err := s.saveConfig(previous.Config); err != nil {
+			return failed, fmt.Errorf("restoring config after failed health check: %w", err)
+		}
+	}
+	appliedBy := fmt.Sprintf("rollback to version %d", previous.Version)
+	if err := s.device.SetConfig(previous.Config, appliedBy); err != nil {
+		return failed, err
+	}
+	return previous.Hash, rollback
+}
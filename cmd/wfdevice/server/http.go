@@ -1,11 +1,13 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/wrale/wrale-fleet/internal/apierror"
 	"go.uber.org/zap"
 )
 
@@ -29,15 +31,13 @@ func (s *Server) handleStatus() http.HandlerFunc {
 		s.mu.RUnlock()
 
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error getting status: %v", err), http.StatusInternalServerError)
+			apierror.Write(w, err)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(status); err != nil {
 			s.logger.Error("failed to encode status response", zap.Error(err))
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
 		}
 	}
 }
@@ -51,7 +51,7 @@ func (s *Server) handleConfig() http.HandlerFunc {
 		case http.MethodPut:
 			s.handleUpdateConfig(w, r)
 		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			apierror.Write(w, apierror.MethodNotAllowed())
 		}
 	}
 }
@@ -64,28 +64,29 @@ func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(s.device.Config); err != nil {
 		s.logger.Error("failed to encode config response", zap.Error(err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
 	}
 }
 
 // handleUpdateConfig applies new device configuration
 func (s *Server) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	var newConfig json.RawMessage
 	if err := json.NewDecoder(r.Body).Decode(&newConfig); err != nil {
-		http.Error(w, "Invalid configuration format", http.StatusBadRequest)
+		apierror.Write(w, apierror.BadRequest("invalid configuration format"))
 		return
 	}
 
-	// Store new configuration
-	s.device.Config = newConfig
-
-	s.logger.Info("applied new device configuration",
-		zap.String("name", s.device.Name),
-		zap.Int("config_size", len(newConfig)))
+	// The health watch outlives a disconnecting client so a failing change
+	// is still rolled back
+	_, err := s.ApplyConfig(context.WithoutCancel(r.Context()), newConfig, "local api")
+	var rollback *RollbackError
+	if errors.As(err, &rollback) {
+		apierror.Write(w, apierror.New(http.StatusConflict, apierror.CodeConflict, rollback.Error()))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest(err.Error()))
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -106,8 +107,6 @@ func (s *Server) handleMetrics() http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(metrics); err != nil {
 			s.logger.Error("failed to encode metrics response", zap.Error(err))
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
 		}
 	}
 }
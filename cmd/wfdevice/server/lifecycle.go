@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
 	"github.com/wrale/wrale-fleet/internal/fleet/device"
 	"github.com/wrale/wrale-fleet/internal/fleet/health"
 	"go.uber.org/zap"
@@ -25,6 +27,14 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 	defer s.removePIDFile()
 
+	// Restore the configuration applied before the last restart
+	s.mu.Lock()
+	err := s.loadConfig()
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
 	// Initialize HTTP server with security timeouts
 	s.httpSrv = &http.Server{
 		Addr:              ":" + s.cfg.Port,
@@ -51,19 +61,21 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}()
 
-	// Register with control plane if name is provided
-	if s.cfg.Name != "" {
-		regCtx, cancel := context.WithTimeout(ctx, registrationTimeout)
-		defer cancel()
+	// Restore the stored registration or register with the control plane
+	regCtx, cancel := context.WithTimeout(ctx, registrationTimeout)
+	defer cancel()
 
-		if err := s.register(regCtx); err != nil {
-			return fmt.Errorf("device registration failed: %w", err)
-		}
-
-		// Start health reporting after successful registration
+	registered, err := s.register(regCtx)
+	if err != nil {
+		return fmt.Errorf("device registration failed: %w", err)
+	}
+	if registered {
+		// Start health reporting and configuration delivery after
+		// successful registration
 		s.startHealthReporting()
+		s.startConfigSync()
 	} else {
-		s.logger.Info("device name not provided, skipping registration",
+		s.logger.Info("device name or control plane not provided, skipping registration",
 			zap.String("status", string(s.device.Status)))
 	}
 
@@ -94,35 +106,62 @@ func (s *Server) Status(ctx context.Context) (*DeviceStatus, error) {
 		lastCheck = resp.LastChecked
 	}
 
-	return &DeviceStatus{
+	status := &DeviceStatus{
 		Name:            s.cfg.Name,
 		Status:          s.device.Status,
 		Tags:            s.device.Tags,
 		ControlPlane:    s.cfg.ControlPlane,
 		Registered:      s.registered,
 		LastHealthCheck: lastCheck,
-	}, nil
+	}
+	if s.registration != nil {
+		status.Name = s.registration.Name
+		status.DeviceID = s.registration.DeviceID
+		status.TenantID = s.registration.TenantID
+	}
+	return status, nil
 }
 
-// NotifyShutdown informs the control plane of a planned shutdown
-func (s *Server) NotifyShutdown(ctx context.Context) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// NotifyShutdown informs the control plane of a planned shutdown. The
+// control plane moves the device into maintenance until expectedReturn; a
+// zero expectedReturn selects the control plane's default window. The
+// stored registration is used when the agent itself has not registered in
+// this process, so the notice can be sent by a separate CLI invocation.
+func (s *Server) NotifyShutdown(ctx context.Context, reason string, expectedReturn time.Time) (*api.ShutdownResponse, error) {
+	s.mu.Lock()
+	if s.registration == nil {
+		if reg := s.storedRegistration(); reg != nil && !reg.expired(time.Now()) {
+			s.adoptRegistration(reg)
+		}
+	}
+	reg := s.registration
+	s.mu.Unlock()
 
-	if !s.registered {
-		return fmt.Errorf("device not registered with control plane")
+	if reg == nil {
+		return nil, fmt.Errorf("device not registered with control plane")
 	}
 
-	s.notifyShutdown()
-	return nil
+	resp, err := s.notifyShutdown(ctx, reg, reason, expectedReturn)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.shutdownNotified = true
+	s.device.Status = device.StatusMaintenance
+	s.mu.Unlock()
+	return resp, nil
 }
 
 // shutdown performs a graceful server shutdown
 func (s *Server) shutdown() error {
-	// Stop health reporting
+	// Stop health reporting and configuration delivery
 	if s.stopHealth != nil {
 		close(s.stopHealth)
 	}
+	if s.stopConfig != nil {
+		close(s.stopConfig)
+	}
 
 	// Stop management server first if running
 	if s.mgmtServer != nil {
@@ -131,12 +170,19 @@ func (s *Server) shutdown() error {
 		}
 	}
 
-	// Notify control plane of shutdown if registered
-	s.mu.RLock()
-	if s.registered {
-		s.notifyShutdown()
+	// Notify control plane of shutdown if registered, unless a notice with
+	// a reason and return time was already sent
+	s.mu.Lock()
+	s.shuttingDown = true
+	reg, notified := s.registration, s.shutdownNotified
+	s.mu.Unlock()
+	if reg != nil && !notified {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownNoticeTimeout)
+		if _, err := s.notifyShutdown(ctx, reg, "agent stopped", time.Time{}); err != nil {
+			s.logger.Warn("failed to notify control plane of shutdown", zap.Error(err))
+		}
+		cancel()
 	}
-	s.mu.RUnlock()
 
 	// Shutdown main HTTP server
 	if s.httpSrv != nil {
@@ -180,54 +226,45 @@ func (s *Server) CheckHealth(ctx context.Context) error {
 	return nil
 }
 
-// register handles device registration with the control plane
-func (s *Server) register(ctx context.Context) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// notifyShutdown sends a shutdown notice signed with the device credentials
+func (s *Server) notifyShutdown(ctx context.Context, reg *Registration, reason string, expectedReturn time.Time) (*api.ShutdownResponse, error) {
+	s.logger.Info("notifying control plane of shutdown",
+		zap.String("device_id", reg.DeviceID),
+		zap.String("reason", reason),
+		zap.Time("expected_return", expectedReturn))
 
-	// Validate registration requirements
-	if s.cfg.Name == "" {
-		return fmt.Errorf("device name is required for registration")
+	c, err := client.New(reg.ControlPlane, client.WithBearerToken(reg.Credentials.Token))
+	if err != nil {
+		return nil, err
 	}
-
-	s.logger.Info("registering device with control plane",
-		zap.String("name", s.cfg.Name),
-		zap.String("control_plane", s.cfg.ControlPlane),
-	)
-
-	// Update device identity now that we have the name
-	s.device.Name = s.cfg.Name
-
-	// TODO: Implement actual registration logic with control plane
-	time.Sleep(time.Second)
-
-	s.registered = true
-	s.device.Status = device.StatusOnline
-
-	s.logger.Info("device registration successful")
-	return nil
+	return c.NotifyShutdown(ctx, reg.DeviceID, &api.ShutdownNotice{
+		Reason:         reason,
+		ExpectedReturn: expectedReturn,
+	})
 }
 
-// notifyShutdown informs the control plane of planned shutdown
-func (s *Server) notifyShutdown() {
-	s.logger.Info("notifying control plane of shutdown")
-	// TODO: Implement shutdown notification to control plane
-}
-
-// startHealthReporting begins periodic health check submissions
+// startHealthReporting begins periodic health report submissions. The first
+// report is sent immediately; later ones follow the interval requested by
+// the control plane, falling back to healthCheckInterval.
 func (s *Server) startHealthReporting() {
 	s.stopHealth = make(chan struct{})
 
 	go func() {
-		ticker := time.NewTicker(healthCheckInterval)
+		interval := healthCheckInterval
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		for {
+			next, err := s.submitHealthReport()
+			if err != nil {
+				s.logger.Error("failed to submit health report", zap.Error(err))
+			} else if next > 0 && next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+
 			select {
 			case <-ticker.C:
-				if err := s.submitHealthReport(); err != nil {
-					s.logger.Error("failed to submit health report", zap.Error(err))
-				}
 			case <-s.stopHealth:
 				return
 			}
@@ -235,9 +272,44 @@ func (s *Server) startHealthReporting() {
 	}()
 }
 
-// submitHealthReport sends a health report to the control plane
-func (s *Server) submitHealthReport() error {
-	s.logger.Debug("submitting health report")
-	// TODO: Implement health report submission to control plane
-	return nil
+// submitHealthReport sends the agent's current health to the control plane
+// and returns the report interval the control plane expects.
+func (s *Server) submitHealthReport() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthReportTimeout)
+	defer cancel()
+
+	s.mu.RLock()
+	reg := s.registration
+	s.mu.RUnlock()
+	if reg == nil {
+		return 0, fmt.Errorf("device not registered with control plane")
+	}
+
+	report, err := s.health.CheckHealth(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("checking health: %w", err)
+	}
+	report.Version = &health.Version{
+		Version: buildVersion,
+		Stage:   uint8(s.Stage()),
+	}
+	report.Uptime = time.Since(s.startTime)
+
+	s.mu.RLock()
+	if s.device != nil {
+		report.ConfigHash = s.device.LastConfigHash
+	}
+	s.mu.RUnlock()
+
+	c, err := client.New(reg.ControlPlane, client.WithBearerToken(reg.Credentials.Token))
+	if err != nil {
+		return 0, err
+	}
+
+	s.logger.Debug("submitting health report", zap.String("status", string(report.Status)))
+	resp, err := c.ReportHealth(ctx, reg.DeviceID, report)
+	if err != nil {
+		return 0, err
+	}
+	return resp.ReportInterval, nil
 }
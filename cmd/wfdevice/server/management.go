@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/wrale/wrale-fleet/internal/apierror"
 	"github.com/wrale/wrale-fleet/internal/fleet/health"
 	"go.uber.org/zap"
 )
@@ -109,7 +110,7 @@ func (m *managementServer) handleHealthCheck() http.HandlerFunc {
 
 		response, err := m.server.health.CheckHealth(checkCtx)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apierror.Write(w, err)
 			return
 		}
 
@@ -146,7 +147,7 @@ func (m *managementServer) handleReadyCheck() http.HandlerFunc {
 		// Check if the system is ready to serve requests
 		ready, err := m.server.health.IsReady(ctx)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apierror.Write(w, err)
 			return
 		}
 
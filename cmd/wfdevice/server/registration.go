@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+// registrationFile is the name of the file storing the device identity
+// issued by the control plane
+const registrationFile = "registration.json"
+
+// Registration is the identity and credentials issued by the control plane.
+// It is persisted in the data directory so a restarted agent keeps its
+// device ID instead of registering again.
+type Registration struct {
+	DeviceID     string          `json:"device_id"`
+	TenantID     string          `json:"tenant_id"`
+	Name         string          `json:"name"`
+	ControlPlane string          `json:"control_plane"`
+	Credentials  api.Credentials `json:"credentials"`
+	RegisteredAt time.Time       `json:"registered_at"`
+}
+
+// expired reports whether the device credential is no longer accepted.
+func (r *Registration) expired(now time.Time) bool {
+	return !r.Credentials.ExpiresAt.IsZero() && !now.Before(r.Credentials.ExpiresAt)
+}
+
+// Register registers the device with the control plane unless a usable
+// registration is already stored, and returns the registration in effect.
+func (s *Server) Register(ctx context.Context) (*Registration, error) {
+	return s.obtainRegistration(ctx, true)
+}
+
+// Registration returns the registration in effect, or nil if the device has
+// not registered.
+func (s *Server) Registration() *Registration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.registration == nil {
+		return nil
+	}
+	reg := *s.registration
+	return &reg
+}
+
+// register restores a stored registration or registers with the control
+// plane. It reports false when the agent has no registration and is not
+// configured to obtain one.
+func (s *Server) register(ctx context.Context) (bool, error) {
+	reg, err := s.obtainRegistration(ctx, false)
+	return reg != nil, err
+}
+
+// obtainRegistration restores a stored registration, renews one whose
+// credential has expired, or registers with the control plane. Unless
+// required is set, it returns nil without an error when the agent has no
+// registration and is not configured to obtain one.
+//
+// The handshake runs without holding s.mu, so configuration sync and
+// status readers are not held up by the network round trip.
+func (s *Server) obtainRegistration(ctx context.Context, required bool) (*Registration, error) {
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+
+	s.mu.Lock()
+	stored := s.storedRegistration()
+	if stored != nil && !stored.expired(time.Now()) {
+		s.adoptRegistration(stored)
+		s.mu.Unlock()
+		return stored, nil
+	}
+	attempt := s.registrationAttempt(stored)
+	s.mu.Unlock()
+
+	if !required && stored == nil && (attempt.request.Name == "" || attempt.controlPlane == "") {
+		return nil, nil
+	}
+
+	reg, err := s.registerWithControlPlane(ctx, attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveRegistration(reg); err != nil {
+		return nil, err
+	}
+	s.adoptRegistration(reg)
+	return reg, nil
+}
+
+// storedRegistration returns the persisted registration if it was issued
+// by the configured control plane, whether or not its credential is still
+// valid. The caller must hold s.mu.
+func (s *Server) storedRegistration() *Registration {
+	reg, err := s.loadRegistration()
+	if err != nil {
+		s.logger.Warn("ignoring unreadable registration", zap.Error(err))
+		return nil
+	}
+	if reg == nil {
+		return nil
+	}
+
+	if s.cfg.ControlPlane != "" {
+		want, err := client.NormalizeAddress(s.cfg.ControlPlane)
+		if err != nil || want != reg.ControlPlane {
+			s.logger.Info("stored registration belongs to another control plane",
+				zap.String("device_id", reg.DeviceID),
+				zap.String("control_plane", reg.ControlPlane))
+			return nil
+		}
+	}
+	if s.cfg.Name != "" && s.cfg.Name != reg.Name {
+		s.logger.Warn("configured name differs from registered name, keeping registered identity",
+			zap.String("name", s.cfg.Name),
+			zap.String("registered_name", reg.Name),
+			zap.String("device_id", reg.DeviceID))
+	}
+	return reg
+}
+
+// registrationAttempt holds what a registration handshake sends, taken
+// from the configuration while holding s.mu
+type registrationAttempt struct {
+	controlPlane    string
+	enrollmentToken string
+	request         api.RegistrationRequest
+}
+
+// registrationAttempt prepares a handshake. A stored registration whose
+// credential has expired is renewed under its device ID, presenting the
+// expired credential as proof, so the device keeps its history, group
+// membership and deployments. The caller must
+// hold s.mu.
+func (s *Server) registrationAttempt(stored *Registration) registrationAttempt {
+	attempt := registrationAttempt{
+		controlPlane:    s.cfg.ControlPlane,
+		enrollmentToken: s.cfg.EnrollmentToken,
+		request: api.RegistrationRequest{
+			Name:        s.cfg.Name,
+			Tags:        s.cfg.Tags,
+			NetworkInfo: s.networkInfo(),
+			Capabilities: api.Capabilities{
+				AgentVersion: buildVersion,
+				Stage:        s.stage,
+			},
+		},
+	}
+	if stored != nil {
+		s.logger.Info("stored device credentials have expired",
+			zap.String("device_id", stored.DeviceID),
+			zap.Time("expires_at", stored.Credentials.ExpiresAt))
+		attempt.request.DeviceID = stored.DeviceID
+		attempt.request.Credential = stored.Credentials.Token
+		attempt.request.Name = stored.Name
+		if attempt.controlPlane == "" {
+			attempt.controlPlane = stored.ControlPlane
+		}
+	}
+	return attempt
+}
+
+// registerWithControlPlane performs the registration handshake. A renewal
+// for a device the control plane no longer knows registers a new device.
+func (s *Server) registerWithControlPlane(ctx context.Context, attempt registrationAttempt) (*Registration, error) {
+	if attempt.request.Name == "" {
+		return nil, fmt.Errorf("device name is required for registration")
+	}
+	if attempt.enrollmentToken == "" {
+		return nil, fmt.Errorf("enrollment token is required for registration")
+	}
+
+	c, err := client.New(attempt.controlPlane, client.WithCredential(attempt.enrollmentToken))
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("registering device with control plane",
+		zap.String("name", attempt.request.Name),
+		zap.String("device_id", attempt.request.DeviceID),
+		zap.String("control_plane", c.BaseURL()),
+	)
+
+	resp, err := c.Register(ctx, &attempt.request)
+	var apiErr *apierror.Error
+	if attempt.request.DeviceID != "" && errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		s.logger.Warn("control plane no longer knows the device, registering it again",
+			zap.String("device_id", attempt.request.DeviceID))
+		attempt.request.DeviceID = ""
+		attempt.request.Credential = ""
+		resp, err = c.Register(ctx, &attempt.request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &Registration{
+		DeviceID:     resp.DeviceID,
+		TenantID:     resp.TenantID,
+		Name:         resp.Name,
+		ControlPlane: c.BaseURL(),
+		Credentials:  resp.Credentials,
+		RegisteredAt: time.Now().UTC(),
+	}
+	s.logger.Info("device registration successful",
+		zap.String("device_id", reg.DeviceID),
+		zap.String("tenant_id", reg.TenantID),
+		zap.Bool("renewed", reg.DeviceID == attempt.request.DeviceID))
+	return reg, nil
+}
+
+// adoptRegistration applies a registration to the agent's device identity.
+// The caller must hold s.mu.
+func (s *Server) adoptRegistration(reg *Registration) {
+	s.registration = reg
+	s.registered = true
+	s.device.ID = reg.DeviceID
+	s.device.TenantID = reg.TenantID
+	s.device.Name = reg.Name
+	s.device.Status = device.StatusOnline
+	if s.cfg.ControlPlane == "" {
+		s.cfg.ControlPlane = reg.ControlPlane
+	}
+}
+
+// networkInfo reports how the control plane can reach this agent.
+func (s *Server) networkInfo() *device.NetworkInfo {
+	info := &device.NetworkInfo{}
+	if hostname, err := os.Hostname(); err == nil {
+		info.Hostname = hostname
+	}
+	if port, err := strconv.Atoi(s.cfg.Port); err == nil {
+		info.Port = port
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				info.IPAddress = ipNet.IP.String()
+				break
+			}
+		}
+	}
+	return info
+}
+
+// registrationPath returns the path to the registration file
+func (s *Server) registrationPath() string {
+	return filepath.Join(s.cfg.DataDir, registrationFile)
+}
+
+// loadRegistration reads the stored registration. It returns nil without an
+// error when the device has never registered.
+func (s *Server) loadRegistration() (*Registration, error) {
+	if s.cfg.DataDir == "" {
+		return nil, nil
+	}
+	path := s.registrationPath()
+	if err := validatePath(path); err != nil {
+		return nil, fmt.Errorf("invalid registration path: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading registration: %w", err)
+	}
+
+	var reg Registration
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return nil, fmt.Errorf("decoding registration: %w", err)
+	}
+	if reg.DeviceID == "" || reg.TenantID == "" || reg.Credentials.Token == "" {
+		return nil, fmt.Errorf("registration file %s is incomplete", path)
+	}
+	return &reg, nil
+}
+
+// saveRegistration writes the registration with restrictive permissions.
+// The file is written to a temporary name and renamed so a crash never
+// leaves a truncated registration behind.
+func (s *Server) saveRegistration(reg *Registration) error {
+	if err := s.ensureDataDir(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding registration: %w", err)
+	}
+
+	path := s.registrationPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, filePermissions); err != nil {
+		return fmt.Errorf("writing registration: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("writing registration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+func newTestServer(t *testing.T, opts ...Option) *Server {
+	loggingService, err := logging.NewService(memory.New(), nil)
+	require.NoError(t, err)
+
+	srv, err := New(zaptest.NewLogger(t), append([]Option{WithLogging(loggingService)}, opts...)...)
+	require.NoError(t, err)
+	return srv
+}
+
+func TestRegisterPersistsAndReusesIdentity(t *testing.T) {
+	var calls int
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, api.PathRegistrations, r.URL.Path)
+		assert.Equal(t, "enroll-key", r.Header.Get(auth.APIKeyHeader))
+
+		var req api.RegistrationRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "edge-1", req.Name)
+		assert.Equal(t, "plant-3", req.Tags["site"])
+		assert.Equal(t, 9090, req.NetworkInfo.Port)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(api.RegistrationResponse{
+			DeviceID: "dev-123",
+			TenantID: "tenant-a",
+			Name:     req.Name,
+			Credentials: api.Credentials{
+				Type:      "Bearer",
+				Token:     "header.claims.signature",
+				ExpiresAt: time.Now().Add(time.Hour).UTC(),
+			},
+		})
+	}))
+	defer central.Close()
+
+	dataDir := t.TempDir()
+	opts := []Option{
+		WithPort("9090"),
+		WithDataDir(dataDir),
+		WithName("edge-1"),
+		WithControlPlane(central.URL),
+		WithTags(map[string]string{"site": "plant-3"}),
+		WithEnrollmentToken("enroll-key"),
+	}
+
+	first := newTestServer(t, opts...)
+	reg, err := first.Register(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "dev-123", reg.DeviceID)
+	assert.Equal(t, "tenant-a", reg.TenantID)
+	assert.True(t, first.IsRegistered())
+	assert.Equal(t, 1, calls)
+
+	info, err := os.Stat(filepath.Join(dataDir, registrationFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// A restarted agent restores the stored identity without registering.
+	second := newTestServer(t, opts...)
+	registered, err := second.register(context.Background())
+	require.NoError(t, err)
+	assert.True(t, registered)
+	assert.Equal(t, 1, calls)
+
+	status, err := second.Status(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "dev-123", status.DeviceID)
+	assert.Equal(t, "tenant-a", status.TenantID)
+
+	// Pointing the agent at another control plane registers again.
+	third := newTestServer(t, append(opts, WithControlPlane("127.0.0.1:1"))...)
+	_, err = third.Register(context.Background())
+	assert.Error(t, err)
+}
+
+func TestRegisterSkippedWithoutConfiguration(t *testing.T) {
+	srv := newTestServer(t, WithDataDir(t.TempDir()))
+	registered, err := srv.register(context.Background())
+	require.NoError(t, err)
+	assert.False(t, registered)
+	assert.Nil(t, srv.Registration())
+}
+
+func TestRegisterRenewsExpiredCredentials(t *testing.T) {
+	var requests []api.RegistrationRequest
+	known := true
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.RegistrationRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		deviceID := req.DeviceID
+		switch {
+		case deviceID == "":
+			deviceID = "dev-new"
+			w.WriteHeader(http.StatusCreated)
+		case !known:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{"status": http.StatusNotFound, "code": "DEVICE_NOT_FOUND", "message": "device not found"},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.RegistrationResponse{
+			DeviceID: deviceID,
+			TenantID: "tenant-a",
+			Name:     req.Name,
+			Credentials: api.Credentials{
+				Type:      "Bearer",
+				Token:     "renewed.claims.signature",
+				ExpiresAt: time.Now().Add(time.Hour).UTC(),
+			},
+		})
+	}))
+	defer central.Close()
+
+	dataDir := t.TempDir()
+	srv := newTestServer(t,
+		WithDataDir(dataDir),
+		WithName("edge-1"),
+		WithControlPlane(central.URL),
+		WithEnrollmentToken("enroll-key"),
+	)
+	expired := func() {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		require.NoError(t, srv.saveRegistration(&Registration{
+			DeviceID:     "dev-123",
+			TenantID:     "tenant-a",
+			Name:         "edge-1",
+			ControlPlane: central.URL,
+			Credentials: api.Credentials{
+				Type:      "Bearer",
+				Token:     "expired.claims.signature",
+				ExpiresAt: time.Now().Add(-time.Minute).UTC(),
+			},
+		}))
+	}
+
+	// An expired credential is renewed under the stored device ID.
+	expired()
+	reg, err := srv.Register(context.Background())
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "dev-123", requests[0].DeviceID)
+	assert.Equal(t, "expired.claims.signature", requests[0].Credential, "the expired credential proves the device's identity")
+	assert.Equal(t, "dev-123", reg.DeviceID)
+	assert.Equal(t, "renewed.claims.signature", reg.Credentials.Token)
+
+	stored, err := srv.loadRegistration()
+	require.NoError(t, err)
+	assert.Equal(t, "dev-123", stored.DeviceID)
+	assert.False(t, stored.expired(time.Now()))
+
+	// A device the control plane has forgotten registers anew.
+	known = false
+	expired()
+	reg, err = srv.Register(context.Background())
+	require.NoError(t, err)
+	require.Len(t, requests, 3)
+	assert.Equal(t, "dev-123", requests[1].DeviceID)
+	assert.Empty(t, requests[2].DeviceID)
+	assert.Empty(t, requests[2].Credential)
+	assert.Equal(t, "dev-new", reg.DeviceID)
+}
+
+func TestRegisterDoesNotBlockReadersDuringHandshake(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(api.RegistrationResponse{
+			DeviceID:    "dev-123",
+			TenantID:    "tenant-a",
+			Name:        "edge-1",
+			Credentials: api.Credentials{Type: "Bearer", Token: "header.claims.signature"},
+		})
+	}))
+	defer central.Close()
+
+	srv := newTestServer(t,
+		WithDataDir(t.TempDir()),
+		WithName("edge-1"),
+		WithControlPlane(central.URL),
+		WithEnrollmentToken("enroll-key"),
+	)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := srv.Register(context.Background())
+		done <- err
+	}()
+	<-arrived
+
+	read := make(chan *Registration, 1)
+	go func() { read <- srv.Registration() }()
+	select {
+	case reg := <-read:
+		assert.Nil(t, reg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("reading the registration blocked on the handshake")
+	}
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, "dev-123", srv.Registration().DeviceID)
+}
@@ -9,15 +9,22 @@ import (
 
 	"github.com/wrale/wrale-fleet/internal/fleet/device"
 	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	healthmemory "github.com/wrale/wrale-fleet/internal/fleet/health/store/memory"
 	"github.com/wrale/wrale-fleet/internal/fleet/logging"
 	"go.uber.org/zap"
 )
 
 const (
 	// Default timeouts and intervals
-	registrationTimeout = 30 * time.Second
-	readHeaderTimeout   = 10 * time.Second
-	healthCheckInterval = 60 * time.Second
+	registrationTimeout   = 30 * time.Second
+	readHeaderTimeout     = 10 * time.Second
+	healthCheckInterval   = 60 * time.Second
+	healthReportTimeout   = 10 * time.Second
+	shutdownNoticeTimeout = 5 * time.Second
+	configPollInterval    = 30 * time.Second
+	configSyncTimeout     = 10 * time.Second
+	configWatchPeriod     = 30 * time.Second
+	configWatchInterval   = 5 * time.Second
 )
 
 // Server represents the device agent server instance
@@ -31,9 +38,17 @@ type Server struct {
 	// Synchronization
 	mu sync.RWMutex
 
+	// applyMu serializes configuration changes, including the health
+	// watch that follows each of them
+	applyMu sync.Mutex
+
+	// registerMu serializes registration handshakes, which run without
+	// holding mu
+	registerMu sync.Mutex
+
 	// HTTP servers
 	httpSrv    *http.Server
-	mgmtServer *ManagementServer
+	mgmtServer *managementServer
 
 	// Configuration
 	cfg     *Config
@@ -43,18 +58,67 @@ type Server struct {
 	// State
 	startTime    time.Time
 	registered   bool
+	registration *Registration
 	stopHealth   chan struct{}
+	stopConfig   chan struct{}
 	shuttingDown bool
+
+	// shutdownNotified is set once a shutdown notice reached the control
+	// plane, so stopping the agent does not replace its return window
+	shutdownNotified bool
 }
 
 // Config holds server configuration options
 type Config struct {
-	Name         string
-	Port         string
-	DataDir      string
-	LogLevel     string
-	ControlPlane string
-	Stage        int
+	Name             string
+	Port             string
+	DataDir          string
+	LogLevel         string
+	ControlPlane     string
+	Stage            int
+	Tags             map[string]string
+	ManagementConfig *ManagementConfig
+
+	// ConfigWatch is how long health checks must keep passing after a
+	// configuration change before it is kept. Zero disables the watch.
+	ConfigWatch time.Duration
+
+	// EnrollmentToken authenticates the first registration with the
+	// control plane. It may be a tenant API key or a signed bearer token.
+	EnrollmentToken string
+}
+
+// ExposureLevel controls how much information is exposed in management endpoints
+type ExposureLevel string
+
+const (
+	// ExposureMinimal provides only basic health status
+	ExposureMinimal ExposureLevel = "minimal"
+	// ExposureStandard includes version and uptime information
+	ExposureStandard ExposureLevel = "standard"
+	// ExposureFull provides all available health information
+	ExposureFull ExposureLevel = "full"
+)
+
+// ManagementConfig holds configuration for the management server
+type ManagementConfig struct {
+	// Port for the management server (must be different from main API port)
+	Port string
+
+	// ExposureLevel controls information exposure in health endpoints
+	ExposureLevel ExposureLevel
+}
+
+// DeviceStatus is the agent's view of its own state
+type DeviceStatus struct {
+	Name            string            `json:"name"`
+	DeviceID        string            `json:"device_id,omitempty"`
+	TenantID        string            `json:"tenant_id,omitempty"`
+	Status          device.Status     `json:"status"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	ControlPlane    string            `json:"control_plane,omitempty"`
+	Registered      bool              `json:"registered"`
+	LastHealthCheck time.Time         `json:"last_health_check,omitempty"`
 }
 
 // Option is a functional option for configuring the server
@@ -95,18 +159,113 @@ func WithPIDFile(path string) Option {
 	}
 }
 
-// New creates a new server instance with the provided configuration and options
-func New(cfg *Config, logger *zap.Logger, opts ...Option) (*Server, error) {
+// WithPort sets the main API port
+func WithPort(port string) Option {
+	return func(s *Server) error {
+		s.cfg.Port = port
+		return nil
+	}
+}
+
+// WithDataDir sets the directory holding the PID file and registration state
+func WithDataDir(dir string) Option {
+	return func(s *Server) error {
+		s.cfg.DataDir = dir
+		return nil
+	}
+}
+
+// WithManagementPort sets the port of the management server
+func WithManagementPort(port string) Option {
+	return func(s *Server) error {
+		s.managementConfig().Port = port
+		return nil
+	}
+}
+
+// WithHealthExposure sets how much detail the health endpoints expose
+func WithHealthExposure(level string) Option {
+	return func(s *Server) error {
+		switch ExposureLevel(level) {
+		case ExposureMinimal, ExposureStandard, ExposureFull:
+		default:
+			return fmt.Errorf("invalid health exposure level: %s", level)
+		}
+		s.managementConfig().ExposureLevel = ExposureLevel(level)
+		return nil
+	}
+}
+
+// WithName sets the device name used at registration
+func WithName(name string) Option {
+	return func(s *Server) error {
+		s.cfg.Name = name
+		s.device.Name = name
+		return nil
+	}
+}
+
+// WithControlPlane sets the control plane address
+func WithControlPlane(addr string) Option {
+	return func(s *Server) error {
+		s.cfg.ControlPlane = addr
+		return nil
+	}
+}
+
+// WithTags sets the device tags reported at registration
+func WithTags(tags map[string]string) Option {
+	return func(s *Server) error {
+		s.cfg.Tags = make(map[string]string, len(tags))
+		for k, v := range tags {
+			s.cfg.Tags[k] = v
+			s.device.Tags[k] = v
+		}
+		return nil
+	}
+}
+
+// WithConfigWatch sets how long health checks are watched after a
+// configuration change. A failing check rolls the change back.
+func WithConfigWatch(period time.Duration) Option {
+	return func(s *Server) error {
+		if period < 0 {
+			return fmt.Errorf("invalid config watch period: %s", period)
+		}
+		s.cfg.ConfigWatch = period
+		return nil
+	}
+}
+
+// WithEnrollmentToken sets the credential used for first registration
+func WithEnrollmentToken(token string) Option {
+	return func(s *Server) error {
+		s.cfg.EnrollmentToken = token
+		return nil
+	}
+}
+
+// managementConfig returns the management configuration, creating it on
+// first use.
+func (s *Server) managementConfig() *ManagementConfig {
+	if s.cfg.ManagementConfig == nil {
+		s.cfg.ManagementConfig = &ManagementConfig{ExposureLevel: ExposureStandard}
+	}
+	return s.cfg.ManagementConfig
+}
+
+// New creates a new server instance with the provided options
+func New(logger *zap.Logger, opts ...Option) (*Server, error) {
 	if logger == nil {
 		return nil, fmt.Errorf("logger is required")
 	}
 
 	s := &Server{
 		logger:    logger,
-		cfg:       cfg,
+		cfg:       &Config{Stage: 1, ConfigWatch: configWatchPeriod},
 		stage:     1, // Default to Stage 1
 		startTime: time.Now().UTC(),
-		device:    device.New("", cfg.Name), // TenantID will be set during registration
+		device:    device.New("", ""), // Identity is assigned during registration
 	}
 
 	// Apply options
@@ -121,12 +280,23 @@ func New(cfg *Config, logger *zap.Logger, opts ...Option) (*Server, error) {
 		return nil, fmt.Errorf("logging service is required")
 	}
 	if s.health == nil {
-		return nil, fmt.Errorf("health service is required")
+		s.health = health.NewService(healthmemory.New(), logger)
+	}
+	if err := s.registerHealthChecks(); err != nil {
+		return nil, err
+	}
+	if s.cfg.ManagementConfig != nil && s.cfg.ManagementConfig.Port != "" {
+		s.mgmtServer = newManagementServer(s)
 	}
 
 	return s, nil
 }
 
+// GetStartTime returns the time the agent was created
+func (s *Server) GetStartTime() time.Time {
+	return s.startTime
+}
+
 // Config returns a copy of the current server configuration
 func (s *Server) Config() Config {
 	s.mu.RLock()
@@ -0,0 +1,355 @@
+// Package apierror translates domain errors from the fleet packages into a
+// consistent JSON error envelope for the HTTP APIs served by wfcentral and
+// wfdevice.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/expr"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	"github.com/wrale/wrale-fleet/internal/tenant"
+)
+
+// Error codes emitted by the HTTP layer itself rather than a domain package.
+const (
+	CodeBadRequest       = "BAD_REQUEST"
+	CodeNotFound         = "NOT_FOUND"
+	CodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	CodeUnauthenticated  = "UNAUTHENTICATED"
+	CodeForbidden        = "FORBIDDEN"
+	CodeConflict         = "CONFLICT"
+	CodeRequestTooLarge  = "REQUEST_TOO_LARGE"
+	CodeUnavailable      = "UNAVAILABLE"
+	CodeTimeout          = "TIMEOUT"
+	CodeInternal         = "INTERNAL"
+)
+
+// internalMessage replaces the message of every server-side failure.
+const internalMessage = "internal server error"
+
+// Error is the wire representation of a failed API request. It doubles as an
+// error value so handlers can return HTTP-level failures through the same
+// path as domain errors.
+type Error struct {
+	Status  int                    `json:"status"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Op      string                 `json:"op,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Op != "" {
+		return e.Op + ": " + e.Message
+	}
+	return e.Message
+}
+
+// Response is the JSON envelope wrapping an Error in every failed response.
+type Response struct {
+	Error *Error `json:"error"`
+}
+
+// New creates an Error with the given HTTP status, code and message.
+func New(status int, code, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+// BadRequest creates a 400 error for malformed client input.
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+// NotFound creates a 404 error for unknown resources.
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+// Unauthenticated creates a 401 error for requests without valid credentials.
+func Unauthenticated(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthenticated, message)
+}
+
+// Unavailable creates a 503 error for services that cannot take requests.
+func Unavailable(message string) *Error {
+	return New(http.StatusServiceUnavailable, CodeUnavailable, message)
+}
+
+// MethodNotAllowed creates a 405 error.
+func MethodNotAllowed() *Error {
+	return New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+}
+
+// From translates err into an Error. Domain errors keep their code, message,
+// operation and fields; anything unrecognized becomes an opaque 500 so that
+// internal details never reach the client.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var (
+		out        *Error
+		deviceErr  *device.Error
+		groupErr   *group.Error
+		configErr  *config.Error
+		rolloutErr *rollout.Error
+		tenantErr  *tenant.Error
+		loggingErr *logging.DomainError
+		authErr    *auth.Error
+		exprErr    *expr.Error
+		maxBytes   *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &deviceErr):
+		out = &Error{
+			Status:  deviceStatus(deviceErr.Code),
+			Code:    deviceErr.Code,
+			Message: deviceErr.Message,
+			Op:      deviceErr.Op,
+			Fields:  deviceErr.Fields,
+		}
+	case errors.As(err, &groupErr):
+		out = &Error{
+			Status:  groupStatus(groupErr.Code),
+			Code:    groupErr.Code,
+			Message: groupErr.Message,
+			Op:      groupErr.Op,
+			Fields:  groupErr.Fields,
+		}
+	case errors.As(err, &configErr):
+		out = &Error{
+			Status:  configStatus(configErr.Code),
+			Code:    string(configErr.Code),
+			Message: configErr.Message,
+			Op:      configErr.Op,
+			Fields:  configErr.Fields,
+		}
+	case errors.As(err, &rolloutErr):
+		out = &Error{
+			Status:  rolloutStatus(rolloutErr.Code),
+			Code:    rolloutErr.Code,
+			Message: rolloutErr.Message,
+			Op:      rolloutErr.Op,
+			Fields:  rolloutErr.Fields,
+		}
+	case errors.As(err, &tenantErr):
+		out = &Error{
+			Status:  tenantStatus(tenantErr.Code),
+			Code:    tenantErr.Code,
+			Message: tenantErr.Message,
+			Op:      tenantErr.Op,
+		}
+	case errors.As(err, &loggingErr):
+		out = &Error{
+			Status:  loggingStatus(loggingErr.Code),
+			Code:    loggingErr.Code,
+			Message: loggingErr.Message,
+			Op:      loggingErr.Op,
+		}
+	case errors.As(err, &authErr):
+		out = &Error{
+			Status:  authStatus(authErr.Code),
+			Code:    authErr.Code,
+			Message: authErr.Message,
+			Op:      authErr.Op,
+		}
+	case errors.As(err, &exprErr):
+		// Expressions come from the request, so any error is the client's.
+		out = &Error{
+			Status:  http.StatusBadRequest,
+			Code:    exprErr.Code,
+			Message: exprErr.Message,
+			Op:      exprErr.Op,
+			Fields:  exprErr.Fields,
+		}
+	case errors.As(err, &maxBytes):
+		return New(http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "request body too large")
+	default:
+		return fromSentinel(err)
+	}
+
+	return redact(out)
+}
+
+// fromSentinel maps the plain sentinel errors exported by the domain packages
+// and the standard library.
+func fromSentinel(err error) *Error {
+	switch {
+	case errors.Is(err, logging.ErrEventNotFound):
+		return NotFound(err.Error())
+	case errors.Is(err, logging.ErrMissingTenant),
+		errors.Is(err, logging.ErrMissingMessage),
+		errors.Is(err, logging.ErrInvalidLevel),
+		errors.Is(err, logging.ErrInvalidRetention):
+		return BadRequest(err.Error())
+	case errors.Is(err, logging.ErrStoreNotInitialized):
+		return Unavailable("service not initialized")
+	case errors.Is(err, health.ErrComponentNotRegistered),
+		errors.Is(err, health.ErrStatusNotFound),
+		errors.Is(err, health.ErrReportNotFound),
+		errors.Is(err, health.ErrMaintenanceNotFound):
+		return NotFound(err.Error())
+	case errors.Is(err, health.ErrInvalidReport),
+		errors.Is(err, health.ErrInvalidMaintenance):
+		return BadRequest(err.Error())
+	case errors.Is(err, health.ErrComponentExists):
+		return New(http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(http.StatusGatewayTimeout, CodeTimeout, "request timed out")
+	case errors.Is(err, context.Canceled):
+		return Unavailable("request cancelled")
+	default:
+		return New(http.StatusInternalServerError, CodeInternal, internalMessage)
+	}
+}
+
+// redact strips details that must not leave the server. Server-side failures
+// are reduced to a generic message, and authorization failures drop their
+// fields because those may name another tenant.
+func redact(e *Error) *Error {
+	switch {
+	case e.Status >= http.StatusInternalServerError:
+		return New(e.Status, CodeInternal, internalMessage)
+	case e.Status == http.StatusUnauthorized, e.Status == http.StatusForbidden:
+		e.Fields = nil
+	}
+	return e
+}
+
+func deviceStatus(code string) int {
+	switch code {
+	case device.ErrCodeDeviceNotFound:
+		return http.StatusNotFound
+	case device.ErrCodeDeviceExists, device.ErrCodeInvalidOperation:
+		return http.StatusConflict
+	case device.ErrCodeInvalidDevice:
+		return http.StatusBadRequest
+	case device.ErrCodeUnauthorized:
+		// Handlers resolve the caller's tenant before calling a service, so
+		// an authorization failure surfacing here is a tenant mismatch.
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func groupStatus(code string) int {
+	switch code {
+	case group.ErrCodeGroupNotFound:
+		return http.StatusNotFound
+	case group.ErrCodeGroupExists, group.ErrCodeInvalidOperation, group.ErrCodeCyclicDependency:
+		return http.StatusConflict
+	case group.ErrCodeInvalidGroup, group.ErrCodeInvalidInput:
+		return http.StatusBadRequest
+	case group.ErrCodeInvalidHierarchy:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func configStatus(code config.ErrorCode) int {
+	switch code {
+	case config.ErrTemplateNotFound, config.ErrVersionNotFound, config.ErrDeploymentNotFound:
+		return http.StatusNotFound
+	case config.ErrInvalidTemplate, config.ErrInvalidVersion, config.ErrInvalidDeployment:
+		return http.StatusBadRequest
+	case config.ErrValidationFailed, config.ErrUnresolvedVariables:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func rolloutStatus(code string) int {
+	switch code {
+	case rollout.ErrCodeRolloutNotFound:
+		return http.StatusNotFound
+	case rollout.ErrCodeInvalidRollout:
+		return http.StatusBadRequest
+	case rollout.ErrCodeInvalidOperation:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func tenantStatus(code string) int {
+	switch code {
+	case tenant.ErrCodeTenantNotFound:
+		return http.StatusNotFound
+	case tenant.ErrCodeDuplicateTenant, tenant.ErrCodeInvalidOperation:
+		return http.StatusConflict
+	case tenant.ErrCodeInvalidTenant:
+		return http.StatusBadRequest
+	case tenant.ErrCodeQuotaExceeded:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func loggingStatus(code string) int {
+	switch code {
+	case logging.ErrCodeNotFound:
+		return http.StatusNotFound
+	case logging.ErrCodeInvalidInput:
+		return http.StatusBadRequest
+	case logging.ErrCodeValidation:
+		return http.StatusUnprocessableEntity
+	case logging.ErrCodeInvalidOperation:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func authStatus(code string) int {
+	switch code {
+	case auth.ErrCodeMissingCredentials, auth.ErrCodeInvalidCredentials, auth.ErrCodeExpiredCredentials:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Write translates err and writes it as a JSON error envelope.
+func Write(w http.ResponseWriter, err error) {
+	e := From(err)
+	if e == nil {
+		e = New(http.StatusInternalServerError, CodeInternal, internalMessage)
+	}
+	_ = WriteJSON(w, e.Status, Response{Error: e})
+}
+
+// WriteJSON encodes v as the JSON response body with the given status code.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	// Embedded documents such as device configurations must reach clients
+	// byte for byte, since their hashes are compared.
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
@@ -0,0 +1,103 @@
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/expr"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	"github.com/wrale/wrale-fleet/internal/tenant"
+)
+
+func TestFromStatusMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"device not found", device.E("op", device.ErrCodeDeviceNotFound, "missing", nil), http.StatusNotFound, device.ErrCodeDeviceNotFound},
+		{"device exists", device.E("op", device.ErrCodeDeviceExists, "exists", nil), http.StatusConflict, device.ErrCodeDeviceExists},
+		{"device tenant mismatch", device.E("op", device.ErrCodeUnauthorized, "mismatch", nil), http.StatusForbidden, device.ErrCodeUnauthorized},
+		{"invalid device", device.E("op", device.ErrCodeInvalidDevice, "bad", nil), http.StatusBadRequest, device.ErrCodeInvalidDevice},
+		{"device storage", device.E("op", device.ErrCodeStorageError, "disk", nil), http.StatusInternalServerError, CodeInternal},
+		{"group not found", group.E("op", group.ErrCodeGroupNotFound, "missing", nil), http.StatusNotFound, group.ErrCodeGroupNotFound},
+		{"group cycle", group.E("op", group.ErrCodeCyclicDependency, "cycle", nil), http.StatusConflict, group.ErrCodeCyclicDependency},
+		{"group hierarchy", group.E("op", group.ErrCodeInvalidHierarchy, "bad", nil), http.StatusUnprocessableEntity, group.ErrCodeInvalidHierarchy},
+		{"config template", config.NewError("op", config.ErrInvalidTemplate, "bad"), http.StatusBadRequest, string(config.ErrInvalidTemplate)},
+		{"config version missing", config.NewError("op", config.ErrVersionNotFound, "missing"), http.StatusNotFound, string(config.ErrVersionNotFound)},
+		{"config validation", config.NewError("op", config.ErrValidationFailed, "invalid"), http.StatusUnprocessableEntity, string(config.ErrValidationFailed)},
+		{"config unresolved variables", config.NewError("op", config.ErrUnresolvedVariables, "unresolved"), http.StatusUnprocessableEntity, string(config.ErrUnresolvedVariables)},
+		{"rollout missing", rollout.E("op", rollout.ErrCodeRolloutNotFound, "missing", nil), http.StatusNotFound, rollout.ErrCodeRolloutNotFound},
+		{"rollout state", rollout.E("op", rollout.ErrCodeInvalidOperation, "paused", nil), http.StatusConflict, rollout.ErrCodeInvalidOperation},
+		{"tenant quota", tenant.E("op", tenant.ErrCodeQuotaExceeded, "quota", nil), http.StatusForbidden, tenant.ErrCodeQuotaExceeded},
+		{"tenant duplicate", tenant.E("op", tenant.ErrCodeDuplicateTenant, "dup", nil), http.StatusConflict, tenant.ErrCodeDuplicateTenant},
+		{"expression", expr.E("op", expr.ErrCodeUnknownField, "unknown field", nil), http.StatusBadRequest, expr.ErrCodeUnknownField},
+		{"logging input", logging.E("op", logging.ErrCodeInvalidInput, "bad", nil), http.StatusBadRequest, logging.ErrCodeInvalidInput},
+		{"logging sentinel", logging.ErrEventNotFound, http.StatusNotFound, CodeNotFound},
+		{"wrapped domain error", fmt.Errorf("outer: %w", device.ErrDeviceNotFound), http.StatusNotFound, device.ErrCodeDeviceNotFound},
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout, CodeTimeout},
+		{"unknown", assert.AnError, http.StatusInternalServerError, CodeInternal},
+		{"api error", BadRequest("nope"), http.StatusBadRequest, CodeBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := From(tt.err)
+			require.NotNil(t, got)
+			assert.Equal(t, tt.wantStatus, got.Status)
+			assert.Equal(t, tt.wantCode, got.Code)
+		})
+	}
+}
+
+func TestFromRedactsDetails(t *testing.T) {
+	t.Run("server errors hide the cause", func(t *testing.T) {
+		got := From(device.E("Store.Get", device.ErrCodeStorageError, "bolt: page 12 corrupt", nil).
+			WithField("path", "/var/lib/wfcentral/devices.db"))
+		assert.Equal(t, "internal server error", got.Message)
+		assert.Empty(t, got.Op)
+		assert.Nil(t, got.Fields)
+	})
+
+	t.Run("forbidden drops fields", func(t *testing.T) {
+		got := From(device.E("device.ValidateTenantAccess", device.ErrCodeUnauthorized, "unauthorized access to device", nil).
+			WithField("device_tenant", "tenant-b"))
+		assert.Equal(t, "device.ValidateTenantAccess", got.Op)
+		assert.Nil(t, got.Fields)
+	})
+
+	t.Run("client errors keep fields", func(t *testing.T) {
+		got := From(group.E("Service.Get", group.ErrCodeGroupNotFound, "group not found", nil).WithGroupID("g-1"))
+		assert.Equal(t, "Service.Get", got.Op)
+		assert.Equal(t, "g-1", got.Fields[group.FieldGroupID])
+	})
+}
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, fmt.Errorf("lookup: %w", device.E("Store.Get", device.ErrCodeDeviceNotFound, "device not found", nil).
+		WithField("device_id", "d-1")))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var resp Response
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, http.StatusNotFound, resp.Error.Status)
+	assert.Equal(t, device.ErrCodeDeviceNotFound, resp.Error.Code)
+	assert.Equal(t, "device not found", resp.Error.Message)
+	assert.Equal(t, "Store.Get", resp.Error.Op)
+	assert.Equal(t, "d-1", resp.Error.Fields["device_id"])
+}
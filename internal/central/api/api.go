@@ -0,0 +1,521 @@
+// Package api defines the request and response bodies exchanged with the
+// central control plane HTTP API. Both wfcentral and its clients, including
+// the wfdevice agent, use these types so the wire format has a single source
+// of truth.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/drift"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+)
+
+// Paths of the control plane API endpoints.
+const (
+	PathDevices       = "/api/v1/devices"
+	PathRegistrations = "/api/v1/registrations"
+	PathTemplates     = "/api/v1/templates"
+	PathRollouts      = "/api/v1/rollouts"
+	PathDrift         = "/api/v1/drift"
+	PathGroups        = "/api/v1/groups"
+	PathGroupTree     = "/api/v1/groups/tree"
+	PathGroupRepair   = "/api/v1/groups/repair"
+)
+
+// DevicePath returns the path of a device resource.
+func DevicePath(deviceID string) string {
+	return PathDevices + "/" + url.PathEscape(deviceID)
+}
+
+// DeviceHealthPath returns the path devices submit health reports to.
+func DeviceHealthPath(deviceID string) string {
+	return DevicePath(deviceID) + "/health"
+}
+
+// DeviceShutdownPath returns the path devices send shutdown notices to.
+func DeviceShutdownPath(deviceID string) string {
+	return DevicePath(deviceID) + "/shutdown"
+}
+
+// DeviceFilter narrows a device listing. Empty fields match every device;
+// all tags must match.
+type DeviceFilter struct {
+	Name   string
+	Status device.Status
+	Tags   map[string]string
+
+	// Match is an expression over device fields, in the language of the
+	// expr package, that devices must satisfy
+	Match string
+}
+
+// Query encodes the filter as URL query parameters. Tags are sent as
+// repeated tag=key=value parameters.
+func (f DeviceFilter) Query() url.Values {
+	q := url.Values{}
+	if f.Name != "" {
+		q.Set("name", f.Name)
+	}
+	if f.Status != "" {
+		q.Set("status", string(f.Status))
+	}
+	if f.Match != "" {
+		q.Set("match", f.Match)
+	}
+	keys := make([]string, 0, len(f.Tags))
+	for k := range f.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		q.Add("tag", k+"="+f.Tags[k])
+	}
+	return q
+}
+
+// ParseDeviceFilter decodes a filter from URL query parameters.
+func ParseDeviceFilter(q url.Values) (DeviceFilter, error) {
+	f := DeviceFilter{
+		Name:   q.Get("name"),
+		Status: device.Status(q.Get("status")),
+		Match:  q.Get("match"),
+	}
+	for _, tag := range q["tag"] {
+		k, v, ok := strings.Cut(tag, "=")
+		if !ok || k == "" {
+			return DeviceFilter{}, fmt.Errorf("invalid tag filter %q: expected key=value", tag)
+		}
+		if f.Tags == nil {
+			f.Tags = make(map[string]string)
+		}
+		f.Tags[k] = v
+	}
+	return f, nil
+}
+
+// DeviceListResponse is returned when listing devices.
+type DeviceListResponse struct {
+	Devices []*device.Device `json:"devices"`
+}
+
+// DeviceResponse wraps a single device.
+type DeviceResponse struct {
+	Device *device.Device `json:"device"`
+}
+
+// DeviceConfigPath returns the path of a device's configuration.
+func DeviceConfigPath(deviceID string) string {
+	return DevicePath(deviceID) + "/config"
+}
+
+// DeviceConfigEffectivePath returns the path of a device's effective
+// configuration, with the configuration of its groups applied.
+func DeviceConfigEffectivePath(deviceID string) string {
+	return DeviceConfigPath(deviceID) + "/effective"
+}
+
+// DeviceConfigValidatePath returns the path configurations are validated
+// against without being applied.
+func DeviceConfigValidatePath(deviceID string) string {
+	return DeviceConfigPath(deviceID) + "/validate"
+}
+
+// DeviceConfigPendingPath returns the path device agents poll for the
+// configuration they should apply next.
+func DeviceConfigPendingPath(deviceID string) string {
+	return DeviceConfigPath(deviceID) + "/pending"
+}
+
+// DeviceConfigAckPath returns the path device agents acknowledge
+// deployments on.
+func DeviceConfigAckPath(deviceID string) string {
+	return DeviceConfigPath(deviceID) + "/ack"
+}
+
+// TemplatePath returns the path of a configuration template.
+func TemplatePath(templateID string) string {
+	return PathTemplates + "/" + url.PathEscape(templateID)
+}
+
+// GroupPath returns the path of a group resource.
+func GroupPath(groupID string) string {
+	return PathGroups + "/" + url.PathEscape(groupID)
+}
+
+// GroupMovePath returns the path that moves a group in the hierarchy.
+func GroupMovePath(groupID string) string {
+	return GroupPath(groupID) + "/move"
+}
+
+// GroupDevicesPath returns the path of a group's devices.
+func GroupDevicesPath(groupID string) string {
+	return GroupPath(groupID) + "/devices"
+}
+
+// GroupRollupPath returns the path of the aggregates of a group and its
+// subgroups.
+func GroupRollupPath(groupID string) string {
+	return GroupPath(groupID) + "/rollup"
+}
+
+// GroupDevicePath returns the path of a device's membership of a static
+// group.
+func GroupDevicePath(groupID, deviceID string) string {
+	return GroupDevicesPath(groupID) + "/" + url.PathEscape(deviceID)
+}
+
+// RolloutPath returns the path of a rollout resource.
+func RolloutPath(rolloutID string) string {
+	return PathRollouts + "/" + url.PathEscape(rolloutID)
+}
+
+// RolloutPausePath returns the path that pauses a rollout.
+func RolloutPausePath(rolloutID string) string {
+	return RolloutPath(rolloutID) + "/pause"
+}
+
+// RolloutResumePath returns the path that resumes a paused or halted
+// rollout.
+func RolloutResumePath(rolloutID string) string {
+	return RolloutPath(rolloutID) + "/resume"
+}
+
+// RolloutAbortPath returns the path that aborts a rollout.
+func RolloutAbortPath(rolloutID string) string {
+	return RolloutPath(rolloutID) + "/abort"
+}
+
+// Capabilities describes what a device agent supports. It is reported at
+// registration so the control plane can target operations appropriately.
+type Capabilities struct {
+	// AgentVersion is the wfdevice build version
+	AgentVersion string `json:"agent_version,omitempty"`
+
+	// Stage is the highest capability stage the agent implements
+	Stage int `json:"stage,omitempty"`
+
+	// SecureBootEnabled reports whether the device booted with secure boot
+	SecureBootEnabled bool `json:"secure_boot_enabled,omitempty"`
+
+	// SecurityVersion is the agent's security patch level
+	SecurityVersion string `json:"security_version,omitempty"`
+
+	// Offline describes airgapped operation support
+	Offline *device.OfflineCapabilities `json:"offline,omitempty"`
+}
+
+// RegistrationRequest is sent by a device agent to join the fleet. The
+// tenant is taken from the enrollment credential authenticating the request.
+type RegistrationRequest struct {
+	// DeviceID renews the registration of a device registered before,
+	// typically one whose credential expired. The device keeps its ID,
+	// history, group membership and deployments. Empty registers a new
+	// device.
+	DeviceID string `json:"device_id,omitempty"`
+
+	// Credential is the credential last issued to DeviceID and is required
+	// with it. It may have expired; it proves the caller is the device
+	// rather than anyone holding the enrollment credential.
+	Credential string `json:"credential,omitempty"`
+
+	Name         string              `json:"name"`
+	Tags         map[string]string   `json:"tags,omitempty"`
+	NetworkInfo  *device.NetworkInfo `json:"network_info,omitempty"`
+	Capabilities Capabilities        `json:"capabilities"`
+}
+
+// Credentials are issued to a registered device for all later calls.
+type Credentials struct {
+	// Type is the HTTP authorization scheme, currently always "Bearer"
+	Type string `json:"type"`
+
+	// Token is the signed device credential
+	Token string `json:"token"`
+
+	// ExpiresAt is when the credential stops being accepted
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RegistrationResponse is returned after a successful registration.
+type RegistrationResponse struct {
+	DeviceID    string      `json:"device_id"`
+	TenantID    string      `json:"tenant_id"`
+	Name        string      `json:"name"`
+	Credentials Credentials `json:"credentials"`
+}
+
+// HealthReportResponse acknowledges an accepted health report.
+type HealthReportResponse struct {
+	// DeviceStatus is the device status after the report was applied
+	DeviceStatus device.Status `json:"device_status"`
+
+	// ReportInterval is how often the control plane expects reports
+	ReportInterval time.Duration `json:"report_interval"`
+}
+
+// DeviceHealthResponse returns the reports received from a device. History
+// is only included when requested.
+type DeviceHealthResponse struct {
+	Latest  *health.DeviceReport   `json:"latest"`
+	History []*health.DeviceReport `json:"history,omitempty"`
+}
+
+// ShutdownNotice announces a planned device shutdown. The control plane
+// moves the device into maintenance and does not treat missed health reports
+// as an outage until ExpectedReturn.
+type ShutdownNotice struct {
+	// Reason is a free-form description recorded with the maintenance window
+	Reason string `json:"reason,omitempty"`
+
+	// ExpectedReturn is when the device expects to report again. When
+	// omitted the control plane applies its default window.
+	ExpectedReturn time.Time `json:"expected_return,omitempty"`
+}
+
+// ShutdownResponse acknowledges a shutdown notice.
+type ShutdownResponse struct {
+	DeviceStatus     device.Status `json:"device_status"`
+	MaintenanceUntil time.Time     `json:"maintenance_until"`
+}
+
+// TemplateRequest creates a configuration template.
+type TemplateRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Schema      json.RawMessage   `json:"schema"`
+	Default     json.RawMessage   `json:"default,omitempty"`
+	Variables   []config.Variable `json:"variables,omitempty"`
+}
+
+// TemplateResponse wraps a single configuration template.
+type TemplateResponse struct {
+	Template *config.Template `json:"template"`
+}
+
+// TemplateListResponse is returned when listing configuration templates.
+type TemplateListResponse struct {
+	Templates []*config.Template `json:"templates"`
+}
+
+// DeviceConfigResponse describes the configuration a device runs and the
+// latest deployment targeting it.
+type DeviceConfigResponse struct {
+	DeviceID string          `json:"device_id"`
+	Config   json.RawMessage `json:"config,omitempty"`
+	Hash     string          `json:"hash,omitempty"`
+
+	// Version is the number of the device's latest configuration history
+	// entry, zero when it has never been configured
+	Version int `json:"version,omitempty"`
+
+	// Deployment is the most recent deployment to the device, if any
+	Deployment *config.Deployment `json:"deployment,omitempty"`
+}
+
+// EffectiveConfigResponse returns a device's effective configuration and
+// the group or device each key came from.
+type EffectiveConfigResponse struct {
+	EffectiveConfig *group.EffectiveConfig `json:"effective_config"`
+}
+
+// ConfigRequest carries a configuration to validate or apply to a device.
+type ConfigRequest struct {
+	// Template names the template, by ID or name, whose schema the
+	// configuration must satisfy. When omitted the template of the
+	// device's latest deployment is used.
+	Template string `json:"template,omitempty"`
+
+	// Config is the configuration document. It is rendered for the
+	// device before it is validated: ${...} placeholders are replaced with
+	// device facts and template variables are set from Variables and the
+	// variables of the device's groups.
+	Config json.RawMessage `json:"config"`
+
+	// Variables holds template variable values for this device, which
+	// override those set on its groups
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// ConfigValidationResponse reports the result of validating a
+// configuration. Nothing is stored by validation.
+type ConfigValidationResponse struct {
+	TemplateID   string             `json:"template_id"`
+	TemplateName string             `json:"template_name"`
+	Valid        bool               `json:"valid"`
+	Violations   []config.Violation `json:"violations,omitempty"`
+
+	// Config is the configuration rendered for the device, which is what
+	// applying the request stores. It is omitted when variables could not
+	// be resolved.
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// ConfigApplyResponse returns the deployment created for an applied
+// configuration. The deployment carries the new configuration version.
+type ConfigApplyResponse struct {
+	Deployment *config.Deployment `json:"deployment"`
+}
+
+// ConfigDelivery is a pending deployment handed to a device agent.
+type ConfigDelivery struct {
+	DeploymentID string `json:"deployment_id"`
+	TemplateID   string `json:"template_id,omitempty"`
+	Version      int    `json:"version"`
+
+	// Hash is the SHA-256 of Config exactly as sent. The agent verifies it
+	// before applying and acknowledges with the hash of what it applied.
+	Hash   string          `json:"hash"`
+	Config json.RawMessage `json:"config"`
+}
+
+// ConfigAck reports the outcome of a deployment from the device agent.
+type ConfigAck struct {
+	DeploymentID string `json:"deployment_id"`
+
+	// Hash is the SHA-256 of the configuration the device runs after
+	// applying the deployment
+	Hash string `json:"hash,omitempty"`
+
+	// Error explains why the device could not apply the configuration.
+	// It is empty when the configuration was applied.
+	Error string `json:"error,omitempty"`
+
+	// RolledBack reports that the configuration was applied but failed
+	// the device's health checks, and the device returned to the
+	// configuration identified by Hash
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// ConfigAckResponse returns the deployment after an acknowledgement.
+type ConfigAckResponse struct {
+	Deployment *config.Deployment `json:"deployment"`
+}
+
+// RolloutRequest starts a rollout of a configuration version to the devices
+// of a group.
+type RolloutRequest struct {
+	// Group is the ID of the group whose devices receive the configuration
+	Group string `json:"group"`
+
+	// Template names the template, by ID or name, the version belongs to
+	Template string `json:"template"`
+
+	// Version is the number of an existing valid version to roll out.
+	// It must be zero when Config is set.
+	Version int `json:"version,omitempty"`
+
+	// Config creates a new version from this configuration, validated
+	// against the template, and rolls it out
+	Config json.RawMessage `json:"config,omitempty"`
+
+	// Strategy controls the waves of the rollout and when it halts
+	Strategy rollout.Strategy `json:"strategy"`
+}
+
+// RolloutAbortRequest aborts a rollout.
+type RolloutAbortRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// RolloutResponse wraps a single rollout.
+type RolloutResponse struct {
+	Rollout *rollout.Rollout `json:"rollout"`
+}
+
+// RolloutListResponse is returned when listing rollouts.
+type RolloutListResponse struct {
+	Rollouts []*rollout.Rollout `json:"rollouts"`
+}
+
+// DriftListResponse is returned when listing devices whose reported
+// configuration has drifted from their latest completed deployment.
+type DriftListResponse struct {
+	Drift []drift.Drift `json:"drift"`
+}
+
+// GroupRequest creates a group.
+type GroupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Type is static or dynamic. It defaults to dynamic when Query is set
+	// and static otherwise.
+	Type group.Type `json:"type,omitempty"`
+
+	// Parent is the ID of the group to create the group under; empty
+	// creates a root group
+	Parent string `json:"parent,omitempty"`
+
+	// Query selects the members of a dynamic group
+	Query *group.MembershipQuery `json:"query,omitempty"`
+
+	Properties *group.Properties `json:"properties,omitempty"`
+}
+
+// GroupUpdateRequest replaces the editable fields of a group. Its type and
+// position in the hierarchy are changed through other endpoints.
+type GroupUpdateRequest struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Query       *group.MembershipQuery `json:"query,omitempty"`
+	Properties  group.Properties       `json:"properties"`
+}
+
+// GroupMoveRequest moves a group, with its subgroups, under a new parent.
+type GroupMoveRequest struct {
+	// Parent is the ID of the new parent; empty makes the group a root
+	Parent string `json:"parent"`
+}
+
+// GroupDeviceRequest adds a device to a static group.
+type GroupDeviceRequest struct {
+	Device string `json:"device"`
+}
+
+// GroupResponse wraps a single group.
+type GroupResponse struct {
+	Group *group.Group `json:"group"`
+}
+
+// GroupListResponse is returned when listing groups.
+type GroupListResponse struct {
+	Groups []*group.Group `json:"groups"`
+}
+
+// GroupRollupResponse wraps the aggregates of a group and its subgroups.
+type GroupRollupResponse struct {
+	Rollup *group.Rollup `json:"rollup"`
+}
+
+// GroupNode is a group with its subgroups in a hierarchy view.
+type GroupNode struct {
+	Group    *group.Group `json:"group"`
+	Children []*GroupNode `json:"children,omitempty"`
+}
+
+// GroupRepairRequest repairs the tenant's group hierarchy. A dry run only
+// reports the repairs.
+type GroupRepairRequest struct {
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// GroupRepairResponse wraps the outcome of a group hierarchy repair.
+type GroupRepairResponse struct {
+	Report *group.RepairReport `json:"report"`
+}
+
+// GroupTreeResponse is the group hierarchy of a tenant, or of a subtree.
+// Siblings are ordered by name.
+type GroupTreeResponse struct {
+	Roots []*GroupNode `json:"roots"`
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requireCode(t *testing.T, err error, code string) {
+	t.Helper()
+	require.Error(t, err)
+	var authErr *Error
+	require.True(t, errors.As(err, &authErr), "expected *auth.Error, got %T", err)
+	assert.Equal(t, code, authErr.Code)
+}
+
+func TestAPIKeyStore(t *testing.T) {
+	store, err := NewAPIKeyStore([]APIKey{
+		{ID: "ops", TenantID: "tenant-a", Subject: "ops-team", SHA256: HashAPIKey("k1")},
+		{ID: "ci", TenantID: "tenant-b", SHA256: HashAPIKey("k2")},
+		{ID: "enroll", TenantID: "tenant-a", SHA256: HashAPIKey("k4"), Scope: ScopeEnrollment},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, store.Len())
+
+	p, err := store.Lookup("k1")
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{TenantID: "tenant-a", Subject: "ops-team", Method: MethodAPIKey, CredentialID: "ops", Scope: ScopeOperator}, p)
+
+	p, err = store.Lookup("k2")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", p.Subject, "subject defaults to the key id")
+	assert.Equal(t, ScopeOperator, p.Scope, "keys without a scope are operator keys")
+
+	p, err = store.Lookup("k4")
+	require.NoError(t, err)
+	assert.Equal(t, ScopeEnrollment, p.Scope)
+
+	_, err = store.Lookup("k3")
+	requireCode(t, err, ErrCodeInvalidCredentials)
+}
+
+func TestAPIKeyStoreRejectsBadConfig(t *testing.T) {
+	tests := map[string][]APIKey{
+		"missing tenant": {{ID: "a", SHA256: HashAPIKey("x")}},
+		"bad hash":       {{ID: "a", TenantID: "t", SHA256: "not-hex"}},
+		"unknown scope":  {{ID: "a", TenantID: "t", SHA256: HashAPIKey("x"), Scope: "admin"}},
+		"duplicate id": {
+			{ID: "a", TenantID: "t", SHA256: HashAPIKey("x")},
+			{ID: "a", TenantID: "t", SHA256: HashAPIKey("y")},
+		},
+		"duplicate hash": {
+			{ID: "a", TenantID: "t", SHA256: HashAPIKey("x")},
+			{ID: "b", TenantID: "u", SHA256: HashAPIKey("x")},
+		},
+	}
+	for name, keys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewAPIKeyStore(keys)
+			requireCode(t, err, ErrCodeInvalidKey)
+		})
+	}
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	secret := []byte(strings.Repeat("s", 32))
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	hmacSigner, err := NewHMACSigner("shared", secret)
+	require.NoError(t, err)
+	edSigner, err := NewEd25519Signer("edge", priv)
+	require.NoError(t, err)
+
+	verifier, err := NewTokenVerifier([]TokenKey{
+		{ID: "shared", Algorithm: AlgorithmHS256, Secret: secret},
+		{ID: "edge", Algorithm: AlgorithmEdDSA, PublicKey: pub},
+	}, WithIssuer("wfcentral"))
+	require.NoError(t, err)
+
+	claims := Claims{
+		Issuer:    "wfcentral",
+		Subject:   "svc",
+		TenantID:  "tenant-a",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+
+	for _, signer := range []*TokenSigner{hmacSigner, edSigner} {
+		token, err := signer.Sign(claims)
+		require.NoError(t, err)
+
+		got, kid, err := verifier.Verify(token, now)
+		require.NoError(t, err)
+		assert.Equal(t, signer.keyID, kid)
+		assert.Equal(t, claims, *got)
+	}
+}
+
+func TestTokenVerifyFailures(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	secret := []byte(strings.Repeat("s", 32))
+	signer, err := NewHMACSigner("shared", secret)
+	require.NoError(t, err)
+	other, err := NewHMACSigner("shared", []byte(strings.Repeat("o", 32)))
+	require.NoError(t, err)
+	verifier, err := NewTokenVerifier([]TokenKey{{ID: "shared", Algorithm: AlgorithmHS256, Secret: secret}},
+		WithAudience("fleet"))
+	require.NoError(t, err)
+
+	valid := Claims{Subject: "svc", TenantID: "t", Audience: "fleet", ExpiresAt: now.Add(time.Hour).Unix()}
+	sign := func(s *TokenSigner, c Claims) string {
+		token, err := s.Sign(c)
+		require.NoError(t, err)
+		return token
+	}
+	with := func(mutate func(*Claims)) Claims {
+		c := valid
+		mutate(&c)
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"malformed", "abc", ErrCodeInvalidCredentials},
+		{"wrong secret", sign(other, valid), ErrCodeInvalidCredentials},
+		{"expired", sign(signer, with(func(c *Claims) { c.ExpiresAt = now.Add(-time.Hour).Unix() })), ErrCodeExpiredCredentials},
+		{"no expiry", sign(signer, with(func(c *Claims) { c.ExpiresAt = 0 })), ErrCodeInvalidCredentials},
+		{"not yet valid", sign(signer, with(func(c *Claims) { c.NotBefore = now.Add(time.Hour).Unix() })), ErrCodeInvalidCredentials},
+		{"missing tenant", sign(signer, with(func(c *Claims) { c.TenantID = "" })), ErrCodeInvalidCredentials},
+		{"wrong audience", sign(signer, with(func(c *Claims) { c.Audience = "other" })), ErrCodeInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := verifier.Verify(tt.token, now)
+			requireCode(t, err, tt.code)
+		})
+	}
+
+	t.Run("expiry ignored", func(t *testing.T) {
+		token := sign(signer, with(func(c *Claims) { c.ExpiresAt = now.Add(-time.Hour).Unix() }))
+		got, _, err := verifier.VerifyIgnoringExpiry(token, now)
+		require.NoError(t, err)
+		assert.Equal(t, "svc", got.Subject)
+
+		_, _, err = verifier.VerifyIgnoringExpiry(sign(other, valid), now)
+		requireCode(t, err, ErrCodeInvalidCredentials)
+	})
+
+	t.Run("within clock skew", func(t *testing.T) {
+		token := sign(signer, with(func(c *Claims) { c.ExpiresAt = now.Add(-30 * time.Second).Unix() }))
+		_, _, err := verifier.Verify(token, now)
+		assert.NoError(t, err)
+	})
+}
+
+func TestNewTokenVerifierRejectsWeakKeys(t *testing.T) {
+	_, err := NewTokenVerifier([]TokenKey{{ID: "k", Algorithm: AlgorithmHS256, Secret: []byte("short")}})
+	requireCode(t, err, ErrCodeInvalidKey)
+
+	_, err = NewTokenVerifier([]TokenKey{{ID: "k", Algorithm: "none"}})
+	requireCode(t, err, ErrCodeInvalidKey)
+}
+
+func TestAuthenticatorWithoutSources(t *testing.T) {
+	a := NewAuthenticator(nil, nil)
+	assert.False(t, a.Enabled())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(APIKeyHeader, "anything")
+	_, err := a.Authenticate(req)
+	requireCode(t, err, ErrCodeInvalidCredentials)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	_, err = a.Authenticate(req)
+	requireCode(t, err, ErrCodeMissingCredentials)
+}
@@ -0,0 +1,42 @@
+package auth
+
+import "fmt"
+
+// Error codes for authentication failures
+const (
+	ErrCodeMissingCredentials = "MISSING_CREDENTIALS"
+	ErrCodeInvalidCredentials = "INVALID_CREDENTIALS"
+	ErrCodeExpiredCredentials = "EXPIRED_CREDENTIALS"
+	ErrCodeInvalidKey         = "INVALID_KEY"
+)
+
+// Error represents an authentication error
+type Error struct {
+	Code    string
+	Message string
+	Op      string
+	Err     error
+}
+
+// Error returns the string representation of the error
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Op, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Op, e.Message)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// E creates a new Error
+func E(op, code, message string, err error) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+		Op:      op,
+		Err:     err,
+	}
+}
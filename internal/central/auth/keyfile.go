@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// keyFilePermissions restricts signing keys to the owning user
+	keyFilePermissions = 0600
+
+	// keyDirPermissions is used when creating the key's directory
+	keyDirPermissions = 0750
+
+	// ed25519PEMType is the PEM block type for stored signing keys
+	ed25519PEMType = "WRALE ED25519 PRIVATE KEY"
+)
+
+// LoadOrCreateEd25519Key reads the Ed25519 signing key stored at path,
+// generating and persisting a new one if the file does not exist. The key
+// is stored as a PEM block holding the 32-byte seed.
+func LoadOrCreateEd25519Key(path string) (ed25519.PrivateKey, error) {
+	const op = "LoadOrCreateEd25519Key"
+
+	// #nosec G304 -- path comes from server configuration
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != ed25519PEMType || len(block.Bytes) != ed25519.SeedSize {
+			return nil, E(op, ErrCodeInvalidKey, fmt.Sprintf("%s does not contain an ed25519 signing key", path), nil)
+		}
+		return ed25519.NewKeyFromSeed(block.Bytes), nil
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, E(op, ErrCodeInvalidKey, "reading signing key", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, E(op, ErrCodeInvalidKey, "generating signing key", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), keyDirPermissions); err != nil {
+		return nil, E(op, ErrCodeInvalidKey, "creating key directory", err)
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{Type: ed25519PEMType, Bytes: key.Seed()})
+	// O_EXCL guards against two processes generating different keys
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, keyFilePermissions)
+	if err != nil {
+		return nil, E(op, ErrCodeInvalidKey, "creating signing key file", err)
+	}
+	if _, err := f.Write(encoded); err != nil {
+		f.Close()
+		return nil, E(op, ErrCodeInvalidKey, "writing signing key", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, E(op, ErrCodeInvalidKey, "writing signing key", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Algorithm names a token signing algorithm. Values follow the JOSE "alg"
+// registry so tokens are standard compact JWTs.
+type Algorithm string
+
+const (
+	// AlgorithmHS256 signs with a shared HMAC-SHA256 secret
+	AlgorithmHS256 Algorithm = "HS256"
+
+	// AlgorithmEdDSA signs with an Ed25519 key; verifiers only need the
+	// public key, which suits offline and airgapped verification
+	AlgorithmEdDSA Algorithm = "EdDSA"
+)
+
+// DefaultClockSkew is the leeway applied to token time claims.
+const DefaultClockSkew = time.Minute
+
+// minHMACSecretSize is the smallest accepted HS256 secret in bytes.
+const minHMACSecretSize = 32
+
+// Claims are the token claims understood by the control plane.
+type Claims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud,omitempty"`
+	TenantID  string `json:"tid"`
+	DeviceID  string `json:"did,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// tokenHeader is the JOSE header of a token.
+type tokenHeader struct {
+	Algorithm Algorithm `json:"alg"`
+	Type      string    `json:"typ,omitempty"`
+	KeyID     string    `json:"kid"`
+}
+
+// TokenKey is a key used to verify tokens. Secret is used with HS256 and
+// PublicKey with EdDSA.
+type TokenKey struct {
+	ID        string
+	Algorithm Algorithm
+	Secret    []byte
+	PublicKey ed25519.PublicKey
+}
+
+func (k TokenKey) validate() error {
+	const op = "TokenKey.validate"
+	if k.ID == "" {
+		return E(op, ErrCodeInvalidKey, "token key requires an id", nil)
+	}
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		if len(k.Secret) < minHMACSecretSize {
+			return E(op, ErrCodeInvalidKey,
+				fmt.Sprintf("token key %q: HS256 secret must be at least %d bytes", k.ID, minHMACSecretSize), nil)
+		}
+	case AlgorithmEdDSA:
+		if len(k.PublicKey) != ed25519.PublicKeySize {
+			return E(op, ErrCodeInvalidKey, fmt.Sprintf("token key %q: invalid ed25519 public key", k.ID), nil)
+		}
+	default:
+		return E(op, ErrCodeInvalidKey, fmt.Sprintf("token key %q: unsupported algorithm %q", k.ID, k.Algorithm), nil)
+	}
+	return nil
+}
+
+// TokenVerifier checks token signatures and time claims against a fixed set
+// of keys. It never makes network calls.
+type TokenVerifier struct {
+	keys      map[string]TokenKey
+	issuer    string
+	audience  string
+	clockSkew time.Duration
+}
+
+// VerifierOption configures a TokenVerifier.
+type VerifierOption func(*TokenVerifier)
+
+// WithIssuer requires tokens to carry the given issuer.
+func WithIssuer(issuer string) VerifierOption {
+	return func(v *TokenVerifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience requires tokens to carry the given audience.
+func WithAudience(audience string) VerifierOption {
+	return func(v *TokenVerifier) {
+		v.audience = audience
+	}
+}
+
+// WithClockSkew overrides the leeway applied to time claims.
+func WithClockSkew(skew time.Duration) VerifierOption {
+	return func(v *TokenVerifier) {
+		v.clockSkew = skew
+	}
+}
+
+// NewTokenVerifier creates a verifier trusting the given keys.
+func NewTokenVerifier(keys []TokenKey, opts ...VerifierOption) (*TokenVerifier, error) {
+	v := &TokenVerifier{
+		keys:      make(map[string]TokenKey, len(keys)),
+		clockSkew: DefaultClockSkew,
+	}
+	for _, k := range keys {
+		if err := k.validate(); err != nil {
+			return nil, err
+		}
+		if _, exists := v.keys[k.ID]; exists {
+			return nil, E("NewTokenVerifier", ErrCodeInvalidKey, fmt.Sprintf("duplicate token key id %q", k.ID), nil)
+		}
+		v.keys[k.ID] = k
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v, nil
+}
+
+// Len returns the number of trusted keys.
+func (v *TokenVerifier) Len() int {
+	return len(v.keys)
+}
+
+// Verify checks the token and returns its claims and the ID of the key that
+// signed it. The algorithm is taken from the trusted key, never from the
+// token header alone, so a token cannot downgrade its own verification.
+func (v *TokenVerifier) Verify(token string, now time.Time) (*Claims, string, error) {
+	return v.verify("TokenVerifier.Verify", token, now, true)
+}
+
+// VerifyIgnoringExpiry checks the token like Verify but accepts it after
+// it expired. It proves possession of a credential, such as a device
+// renewing the credential it was issued, and must not authorize requests.
+func (v *TokenVerifier) VerifyIgnoringExpiry(token string, now time.Time) (*Claims, string, error) {
+	return v.verify("TokenVerifier.VerifyIgnoringExpiry", token, now, false)
+}
+
+func (v *TokenVerifier) verify(op, token string, now time.Time, checkExpiry bool) (*Claims, string, error) {
+	invalid := func(msg string, err error) (*Claims, string, error) {
+		return nil, "", E(op, ErrCodeInvalidCredentials, msg, err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return invalid("malformed token", nil)
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return invalid("malformed token header", err)
+	}
+
+	key, ok := v.keys[header.KeyID]
+	if !ok || key.Algorithm != header.Algorithm {
+		return invalid("token signed by an unknown key", nil)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return invalid("malformed token signature", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch key.Algorithm {
+	case AlgorithmHS256:
+		if !hmac.Equal(sig, hmacSHA256(key.Secret, signed)) {
+			return invalid("invalid token signature", nil)
+		}
+	case AlgorithmEdDSA:
+		if !ed25519.Verify(key.PublicKey, signed, sig) {
+			return invalid("invalid token signature", nil)
+		}
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return invalid("malformed token claims", err)
+	}
+	if claims.TenantID == "" || claims.Subject == "" {
+		return invalid("token is missing tenant or subject", nil)
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return invalid("token issuer not accepted", nil)
+	}
+	if v.audience != "" && claims.Audience != v.audience {
+		return invalid("token audience not accepted", nil)
+	}
+
+	if claims.ExpiresAt == 0 {
+		return invalid("token has no expiry", nil)
+	}
+	unix := now.Unix()
+	skew := int64(v.clockSkew / time.Second)
+	if checkExpiry && unix > claims.ExpiresAt+skew {
+		return nil, "", E(op, ErrCodeExpiredCredentials, "token has expired", nil)
+	}
+	if claims.NotBefore != 0 && unix+skew < claims.NotBefore {
+		return invalid("token is not yet valid", nil)
+	}
+
+	return &claims, key.ID, nil
+}
+
+// TokenSigner issues tokens that a TokenVerifier holding the matching key
+// will accept.
+type TokenSigner struct {
+	keyID      string
+	algorithm  Algorithm
+	secret     []byte
+	privateKey ed25519.PrivateKey
+}
+
+// NewHMACSigner creates an HS256 signer with the given key ID and secret.
+func NewHMACSigner(keyID string, secret []byte) (*TokenSigner, error) {
+	if err := (TokenKey{ID: keyID, Algorithm: AlgorithmHS256, Secret: secret}).validate(); err != nil {
+		return nil, err
+	}
+	return &TokenSigner{keyID: keyID, algorithm: AlgorithmHS256, secret: secret}, nil
+}
+
+// NewEd25519Signer creates an EdDSA signer with the given key ID and key.
+func NewEd25519Signer(keyID string, key ed25519.PrivateKey) (*TokenSigner, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, E("NewEd25519Signer", ErrCodeInvalidKey, "invalid ed25519 private key", nil)
+	}
+	pub, _ := key.Public().(ed25519.PublicKey)
+	if err := (TokenKey{ID: keyID, Algorithm: AlgorithmEdDSA, PublicKey: pub}).validate(); err != nil {
+		return nil, err
+	}
+	return &TokenSigner{keyID: keyID, algorithm: AlgorithmEdDSA, privateKey: key}, nil
+}
+
+// VerificationKey returns the key a TokenVerifier needs to accept tokens
+// issued by this signer.
+func (s *TokenSigner) VerificationKey() TokenKey {
+	key := TokenKey{ID: s.keyID, Algorithm: s.algorithm}
+	switch s.algorithm {
+	case AlgorithmHS256:
+		key.Secret = s.secret
+	case AlgorithmEdDSA:
+		key.PublicKey, _ = s.privateKey.Public().(ed25519.PublicKey)
+	}
+	return key
+}
+
+// Sign encodes and signs the claims.
+func (s *TokenSigner) Sign(claims Claims) (string, error) {
+	header, err := encodeSegment(tokenHeader{Algorithm: s.algorithm, Type: "JWT", KeyID: s.keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := header + "." + payload
+	var sig []byte
+	switch s.algorithm {
+	case AlgorithmHS256:
+		sig = hmacSHA256(s.secret, []byte(signed))
+	case AlgorithmEdDSA:
+		sig = ed25519.Sign(s.privateKey, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func hmacSHA256(secret, data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("encoding token segment: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
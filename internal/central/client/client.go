@@ -0,0 +1,582 @@
+// Package client is an HTTP client for the central control plane API. It is
+// shared by the wfcentral CLI and the wfdevice agent.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/drift"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+)
+
+const (
+	// defaultTimeout bounds each request unless overridden
+	defaultTimeout = 30 * time.Second
+
+	// maxResponseBytes bounds the size of decoded response bodies
+	maxResponseBytes = 10 << 20
+)
+
+// Client calls the control plane API.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	apiKey     string
+	token      string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces the underlying HTTP client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithAPIKey authenticates requests with a static API key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithBearerToken authenticates requests with a signed bearer token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithCredential authenticates requests with a credential of either kind.
+// Signed tokens have three dot-separated segments; anything else is sent as
+// an API key.
+func WithCredential(credential string) Option {
+	return func(c *Client) {
+		if strings.Count(credential, ".") == 2 {
+			c.token = credential
+			return
+		}
+		c.apiKey = credential
+	}
+}
+
+// New creates a client for the control plane at address. A bare host:port
+// address is treated as plain HTTP, matching the control plane's listener.
+func New(address string, opts ...Option) (*Client, error) {
+	base, err := NormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		baseURL:    base,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// NormalizeAddress turns a control plane address into a base URL without a
+// trailing slash.
+func NormalizeAddress(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", fmt.Errorf("control plane address is required")
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		return "", fmt.Errorf("unsupported control plane address %q: scheme must be http or https", address)
+	}
+	return strings.TrimRight(address, "/"), nil
+}
+
+// BaseURL returns the normalized control plane URL.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Register enrolls a device and returns its identity and credentials.
+func (c *Client) Register(ctx context.Context, req *api.RegistrationRequest) (*api.RegistrationResponse, error) {
+	var resp api.RegistrationResponse
+	if err := c.do(ctx, http.MethodPost, api.PathRegistrations, req, &resp); err != nil {
+		return nil, fmt.Errorf("registering device: %w", err)
+	}
+	return &resp, nil
+}
+
+// ReportHealth submits a device health report.
+func (c *Client) ReportHealth(ctx context.Context, deviceID string, report *health.HealthResponse) (*api.HealthReportResponse, error) {
+	var resp api.HealthReportResponse
+	if err := c.do(ctx, http.MethodPost, api.DeviceHealthPath(deviceID), report, &resp); err != nil {
+		return nil, fmt.Errorf("reporting health: %w", err)
+	}
+	return &resp, nil
+}
+
+// NotifyShutdown announces a planned shutdown of a device.
+func (c *Client) NotifyShutdown(ctx context.Context, deviceID string, notice *api.ShutdownNotice) (*api.ShutdownResponse, error) {
+	var resp api.ShutdownResponse
+	if err := c.do(ctx, http.MethodPost, api.DeviceShutdownPath(deviceID), notice, &resp); err != nil {
+		return nil, fmt.Errorf("notifying shutdown: %w", err)
+	}
+	return &resp, nil
+}
+
+// ListDevices returns the devices of the caller's tenant matching filter.
+func (c *Client) ListDevices(ctx context.Context, filter api.DeviceFilter) ([]*device.Device, error) {
+	path := api.PathDevices
+	if q := filter.Query(); len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var resp api.DeviceListResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("listing devices: %w", err)
+	}
+	return resp.Devices, nil
+}
+
+// GetDevice returns a device by ID.
+func (c *Client) GetDevice(ctx context.Context, deviceID string) (*device.Device, error) {
+	var resp api.DeviceResponse
+	if err := c.do(ctx, http.MethodGet, api.DevicePath(deviceID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting device %s: %w", deviceID, err)
+	}
+	return resp.Device, nil
+}
+
+// FindDevice resolves a device by ID or, failing that, by name. A name
+// shared by several devices is rejected so commands never act on the wrong
+// one; callers should use the device ID instead.
+func (c *Client) FindDevice(ctx context.Context, nameOrID string) (*device.Device, error) {
+	dev, err := c.GetDevice(ctx, nameOrID)
+	if err == nil {
+		return dev, nil
+	}
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		return nil, err
+	}
+
+	devices, err := c.ListDevices(ctx, api.DeviceFilter{Name: nameOrID})
+	if err != nil {
+		return nil, err
+	}
+	switch len(devices) {
+	case 0:
+		return nil, apierror.NotFound(fmt.Sprintf("device %q not found", nameOrID))
+	case 1:
+		return devices[0], nil
+	default:
+		ids := make([]string, len(devices))
+		for i, d := range devices {
+			ids[i] = d.ID
+		}
+		return nil, apierror.New(http.StatusConflict, apierror.CodeConflict,
+			fmt.Sprintf("device name %q is ambiguous (%s); use the device ID", nameOrID, strings.Join(ids, ", ")))
+	}
+}
+
+// DeviceHealth returns the latest health report of a device and, when
+// history is positive, up to that many recent reports, newest first.
+func (c *Client) DeviceHealth(ctx context.Context, deviceID string, history int) (*api.DeviceHealthResponse, error) {
+	path := api.DeviceHealthPath(deviceID)
+	if history > 0 {
+		path += "?history=" + strconv.Itoa(history)
+	}
+
+	var resp api.DeviceHealthResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting health of device %s: %w", deviceID, err)
+	}
+	return &resp, nil
+}
+
+// DeviceConfig returns a device's current configuration and its latest
+// deployment.
+func (c *Client) DeviceConfig(ctx context.Context, deviceID string) (*api.DeviceConfigResponse, error) {
+	var resp api.DeviceConfigResponse
+	if err := c.do(ctx, http.MethodGet, api.DeviceConfigPath(deviceID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting config of device %s: %w", deviceID, err)
+	}
+	return &resp, nil
+}
+
+// EffectiveDeviceConfig returns a device's configuration with the
+// configuration of its groups applied, and the source of each key.
+func (c *Client) EffectiveDeviceConfig(ctx context.Context, deviceID string) (*group.EffectiveConfig, error) {
+	var resp api.EffectiveConfigResponse
+	if err := c.do(ctx, http.MethodGet, api.DeviceConfigEffectivePath(deviceID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting effective config of device %s: %w", deviceID, err)
+	}
+	return resp.EffectiveConfig, nil
+}
+
+// ValidateDeviceConfig checks a configuration for a device against its
+// template without storing anything.
+func (c *Client) ValidateDeviceConfig(ctx context.Context, deviceID string, req *api.ConfigRequest) (*api.ConfigValidationResponse, error) {
+	var resp api.ConfigValidationResponse
+	if err := c.do(ctx, http.MethodPost, api.DeviceConfigValidatePath(deviceID), req, &resp); err != nil {
+		return nil, fmt.Errorf("validating config of device %s: %w", deviceID, err)
+	}
+	return &resp, nil
+}
+
+// ApplyDeviceConfig stores a configuration as a new version and deploys it
+// to a device.
+func (c *Client) ApplyDeviceConfig(ctx context.Context, deviceID string, req *api.ConfigRequest) (*api.ConfigApplyResponse, error) {
+	var resp api.ConfigApplyResponse
+	if err := c.do(ctx, http.MethodPost, api.DeviceConfigPath(deviceID), req, &resp); err != nil {
+		return nil, fmt.Errorf("applying config to device %s: %w", deviceID, err)
+	}
+	return &resp, nil
+}
+
+// PendingConfig returns the configuration a device should apply next, or
+// nil when the device is up to date.
+func (c *Client) PendingConfig(ctx context.Context, deviceID string) (*api.ConfigDelivery, error) {
+	var resp api.ConfigDelivery
+	if err := c.do(ctx, http.MethodGet, api.DeviceConfigPendingPath(deviceID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting pending config of device %s: %w", deviceID, err)
+	}
+	if resp.DeploymentID == "" {
+		return nil, nil
+	}
+	return &resp, nil
+}
+
+// AcknowledgeConfig reports the outcome of a deployment on a device.
+func (c *Client) AcknowledgeConfig(ctx context.Context, deviceID string, ack *api.ConfigAck) (*api.ConfigAckResponse, error) {
+	var resp api.ConfigAckResponse
+	if err := c.do(ctx, http.MethodPost, api.DeviceConfigAckPath(deviceID), ack, &resp); err != nil {
+		return nil, fmt.Errorf("acknowledging deployment %s: %w", ack.DeploymentID, err)
+	}
+	return &resp, nil
+}
+
+// CreateGroup creates a device group.
+func (c *Client) CreateGroup(ctx context.Context, req *api.GroupRequest) (*group.Group, error) {
+	var resp api.GroupResponse
+	if err := c.do(ctx, http.MethodPost, api.PathGroups, req, &resp); err != nil {
+		return nil, fmt.Errorf("creating group %s: %w", req.Name, err)
+	}
+	return resp.Group, nil
+}
+
+// ListGroups returns the tenant's groups, ordered by name, optionally
+// limited to one type, the children of one parent, or one name.
+func (c *Client) ListGroups(ctx context.Context, groupType group.Type, parentID, name string) ([]*group.Group, error) {
+	q := url.Values{}
+	if groupType != "" {
+		q.Set("type", string(groupType))
+	}
+	if parentID != "" {
+		q.Set("parent", parentID)
+	}
+	if name != "" {
+		q.Set("name", name)
+	}
+	path := api.PathGroups
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var resp api.GroupListResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("listing groups: %w", err)
+	}
+	return resp.Groups, nil
+}
+
+// GetGroup returns a group by ID.
+func (c *Client) GetGroup(ctx context.Context, groupID string) (*group.Group, error) {
+	var resp api.GroupResponse
+	if err := c.do(ctx, http.MethodGet, api.GroupPath(groupID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting group %s: %w", groupID, err)
+	}
+	return resp.Group, nil
+}
+
+// FindGroup resolves a group by ID or, failing that, by name. A name shared
+// by several groups is rejected; callers should use the group ID instead.
+func (c *Client) FindGroup(ctx context.Context, nameOrID string) (*group.Group, error) {
+	g, err := c.GetGroup(ctx, nameOrID)
+	if err == nil {
+		return g, nil
+	}
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		return nil, err
+	}
+
+	groups, err := c.ListGroups(ctx, "", "", nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	switch len(groups) {
+	case 0:
+		return nil, apierror.NotFound(fmt.Sprintf("group %q not found", nameOrID))
+	case 1:
+		return groups[0], nil
+	default:
+		ids := make([]string, len(groups))
+		for i, g := range groups {
+			ids[i] = g.ID
+		}
+		return nil, apierror.New(http.StatusConflict, apierror.CodeConflict,
+			fmt.Sprintf("group name %q is ambiguous (%s); use the group ID", nameOrID, strings.Join(ids, ", ")))
+	}
+}
+
+// UpdateGroup replaces the editable fields of a group.
+func (c *Client) UpdateGroup(ctx context.Context, groupID string, req *api.GroupUpdateRequest) (*group.Group, error) {
+	var resp api.GroupResponse
+	if err := c.do(ctx, http.MethodPut, api.GroupPath(groupID), req, &resp); err != nil {
+		return nil, fmt.Errorf("updating group %s: %w", groupID, err)
+	}
+	return resp.Group, nil
+}
+
+// DeleteGroup deletes a group and its subgroups.
+func (c *Client) DeleteGroup(ctx context.Context, groupID string) error {
+	if err := c.do(ctx, http.MethodDelete, api.GroupPath(groupID), nil, nil); err != nil {
+		return fmt.Errorf("deleting group %s: %w", groupID, err)
+	}
+	return nil
+}
+
+// MoveGroup moves a group, with its subgroups, under a new parent. An
+// empty parent ID makes the group a root.
+func (c *Client) MoveGroup(ctx context.Context, groupID, parentID string) (*group.Group, error) {
+	var resp api.GroupResponse
+	if err := c.do(ctx, http.MethodPost, api.GroupMovePath(groupID),
+		&api.GroupMoveRequest{Parent: parentID}, &resp); err != nil {
+		return nil, fmt.Errorf("moving group %s: %w", groupID, err)
+	}
+	return resp.Group, nil
+}
+
+// GroupDevices returns the devices of a group. The members of a dynamic
+// group are evaluated from its query.
+func (c *Client) GroupDevices(ctx context.Context, groupID string) ([]*device.Device, error) {
+	var resp api.DeviceListResponse
+	if err := c.do(ctx, http.MethodGet, api.GroupDevicesPath(groupID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("listing devices of group %s: %w", groupID, err)
+	}
+	return resp.Devices, nil
+}
+
+// AddGroupDevice adds a device to a static group.
+func (c *Client) AddGroupDevice(ctx context.Context, groupID, deviceID string) (*group.Group, error) {
+	var resp api.GroupResponse
+	if err := c.do(ctx, http.MethodPost, api.GroupDevicesPath(groupID),
+		&api.GroupDeviceRequest{Device: deviceID}, &resp); err != nil {
+		return nil, fmt.Errorf("adding device %s to group %s: %w", deviceID, groupID, err)
+	}
+	return resp.Group, nil
+}
+
+// RemoveGroupDevice removes a device from a static group.
+func (c *Client) RemoveGroupDevice(ctx context.Context, groupID, deviceID string) error {
+	if err := c.do(ctx, http.MethodDelete, api.GroupDevicePath(groupID, deviceID), nil, nil); err != nil {
+		return fmt.Errorf("removing device %s from group %s: %w", deviceID, groupID, err)
+	}
+	return nil
+}
+
+// GroupTree returns the tenant's group hierarchy or, with a root group ID,
+// the subtree beneath that group.
+func (c *Client) GroupTree(ctx context.Context, rootID string) ([]*api.GroupNode, error) {
+	path := api.PathGroupTree
+	if rootID != "" {
+		path += "?" + url.Values{"root": {rootID}}.Encode()
+	}
+
+	var resp api.GroupTreeResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting group tree: %w", err)
+	}
+	return resp.Roots, nil
+}
+
+// RepairGroups rebuilds the tenant's group hierarchy from each group's
+// parent and moves orphaned groups beneath a quarantine group. A dry run
+// only reports the repairs.
+func (c *Client) RepairGroups(ctx context.Context, dryRun bool) (*group.RepairReport, error) {
+	var resp api.GroupRepairResponse
+	if err := c.do(ctx, http.MethodPost, api.PathGroupRepair, &api.GroupRepairRequest{DryRun: dryRun}, &resp); err != nil {
+		return nil, fmt.Errorf("repairing group hierarchy: %w", err)
+	}
+	return resp.Report, nil
+}
+
+// GroupRollup returns the device counts by status, compliance and config
+// drift of a group and all its subgroups.
+func (c *Client) GroupRollup(ctx context.Context, groupID string) (*group.Rollup, error) {
+	var resp api.GroupRollupResponse
+	if err := c.do(ctx, http.MethodGet, api.GroupRollupPath(groupID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting rollup of group %s: %w", groupID, err)
+	}
+	return resp.Rollup, nil
+}
+
+// StartRollout starts a staged rollout of a configuration version to a
+// group.
+func (c *Client) StartRollout(ctx context.Context, req *api.RolloutRequest) (*rollout.Rollout, error) {
+	var resp api.RolloutResponse
+	if err := c.do(ctx, http.MethodPost, api.PathRollouts, req, &resp); err != nil {
+		return nil, fmt.Errorf("starting rollout to group %s: %w", req.Group, err)
+	}
+	return resp.Rollout, nil
+}
+
+// ListRollouts returns the tenant's rollouts, optionally limited to a group
+// and status.
+func (c *Client) ListRollouts(ctx context.Context, groupID string, status rollout.Status) ([]*rollout.Rollout, error) {
+	q := url.Values{}
+	if groupID != "" {
+		q.Set("group", groupID)
+	}
+	if status != "" {
+		q.Set("status", string(status))
+	}
+	path := api.PathRollouts
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var resp api.RolloutListResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("listing rollouts: %w", err)
+	}
+	return resp.Rollouts, nil
+}
+
+// GetRollout returns a rollout with its progress.
+func (c *Client) GetRollout(ctx context.Context, rolloutID string) (*rollout.Rollout, error) {
+	var resp api.RolloutResponse
+	if err := c.do(ctx, http.MethodGet, api.RolloutPath(rolloutID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting rollout %s: %w", rolloutID, err)
+	}
+	return resp.Rollout, nil
+}
+
+// PauseRollout stops a rollout from starting new waves.
+func (c *Client) PauseRollout(ctx context.Context, rolloutID string) (*rollout.Rollout, error) {
+	var resp api.RolloutResponse
+	if err := c.do(ctx, http.MethodPost, api.RolloutPausePath(rolloutID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("pausing rollout %s: %w", rolloutID, err)
+	}
+	return resp.Rollout, nil
+}
+
+// ResumeRollout continues a paused or halted rollout.
+func (c *Client) ResumeRollout(ctx context.Context, rolloutID string) (*rollout.Rollout, error) {
+	var resp api.RolloutResponse
+	if err := c.do(ctx, http.MethodPost, api.RolloutResumePath(rolloutID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("resuming rollout %s: %w", rolloutID, err)
+	}
+	return resp.Rollout, nil
+}
+
+// AbortRollout stops a rollout for good. Deployments its devices have not
+// acknowledged are failed with the reason.
+func (c *Client) AbortRollout(ctx context.Context, rolloutID, reason string) (*rollout.Rollout, error) {
+	var resp api.RolloutResponse
+	if err := c.do(ctx, http.MethodPost, api.RolloutAbortPath(rolloutID),
+		&api.RolloutAbortRequest{Reason: reason}, &resp); err != nil {
+		return nil, fmt.Errorf("aborting rollout %s: %w", rolloutID, err)
+	}
+	return resp.Rollout, nil
+}
+
+// ListDrift returns the tenant's devices whose reported configuration has
+// drifted from their latest completed deployment.
+func (c *Client) ListDrift(ctx context.Context) ([]drift.Drift, error) {
+	var resp api.DriftListResponse
+	if err := c.do(ctx, http.MethodGet, api.PathDrift, nil, &resp); err != nil {
+		return nil, fmt.Errorf("listing configuration drift: %w", err)
+	}
+	return resp.Drift, nil
+}
+
+// do performs a JSON request. Error responses are decoded into
+// *apierror.Error so callers can inspect the status and code.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.apiKey != "":
+		req.Header.Set(auth.APIKeyHeader, c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	limited := io.LimitReader(resp.Body, maxResponseBytes)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeError(resp.StatusCode, limited)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(limited).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// decodeError reads an error envelope, falling back to a generic error when
+// the body is not one (for example from a proxy).
+func decodeError(status int, body io.Reader) error {
+	var envelope apierror.Response
+	if err := json.NewDecoder(body).Decode(&envelope); err == nil && envelope.Error != nil {
+		if envelope.Error.Status == 0 {
+			envelope.Error.Status = status
+		}
+		return envelope.Error
+	}
+	return apierror.New(status, apierror.CodeInternal, http.StatusText(status))
+}
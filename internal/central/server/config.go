@@ -3,6 +3,16 @@ package server
 import (
 	"fmt"
 	"strconv"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	configfactory "github.com/wrale/wrale-fleet/internal/fleet/config/store/factory"
+	devicefactory "github.com/wrale/wrale-fleet/internal/fleet/device/store/factory"
+	groupfactory "github.com/wrale/wrale-fleet/internal/fleet/group/store/factory"
+	healthfactory "github.com/wrale/wrale-fleet/internal/fleet/health/store/factory"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	loggingfactory "github.com/wrale/wrale-fleet/internal/fleet/logging/store/factory"
+	rolloutfactory "github.com/wrale/wrale-fleet/internal/fleet/rollout/store/factory"
 )
 
 // Config holds the server configuration.
@@ -19,38 +29,162 @@ type Config struct {
 	// Stage1Config holds Stage 1 specific configuration
 	Stage1Config *Stage1Config
 
+	// Storage selects the storage backend for each domain
+	Storage StorageConfig
+
 	// ManagementConfig holds configuration for the management API
 	ManagementConfig *ManagementConfig
+
+	// Auth lists the credentials accepted by the device management API.
+	// Without any credentials every API request is rejected.
+	Auth AuthConfig
+
+	// HealthReports controls device health report ingestion
+	HealthReports HealthReportConfig
+
+	// Drift controls detection of devices running a configuration other
+	// than the one last deployed to them
+	Drift DriftConfig
+
+	// LoggingService records system, security and audit events. When nil the
+	// server creates one backed by the Storage.Logging backend.
+	LoggingService *logging.Service
 }
 
 // Stage1Config holds configuration specific to Stage 1 capabilities.
 type Stage1Config struct {
-	// DeviceStorageType specifies the device storage backend (e.g., "memory", "postgres")
+	// DeviceStorageType selects the device storage backend.
+	//
+	// Deprecated: use StorageConfig.Device. A value here is used when
+	// Storage.Device is empty and must match it otherwise.
 	DeviceStorageType string
 
 	// Additional Stage 1 specific settings can be added here
 }
 
-// ExposureLevel defines how much information is exposed in health endpoints
-type ExposureLevel string
+// AuthConfig lists the credentials accepted by the device management API.
+type AuthConfig struct {
+	// APIKeys are static keys, stored as SHA-256 hashes
+	APIKeys []auth.APIKey
 
-const (
-	// ExposureMinimal provides only basic health status
-	ExposureMinimal ExposureLevel = "minimal"
-	// ExposureStandard includes version and uptime information
-	ExposureStandard ExposureLevel = "standard"
-	// ExposureFull provides all available health information
-	ExposureFull ExposureLevel = "full"
-)
+	// TokenKeys verify signed bearer tokens offline
+	TokenKeys []auth.TokenKey
 
-// ManagementConfig holds configuration for the management API endpoints
-type ManagementConfig struct {
-	// Port for management API endpoints (health, readiness)
-	// This must be explicitly configured for proper security setup
-	Port string
+	// TokenIssuer, when set, is required as the token "iss" claim
+	TokenIssuer string
+
+	// TokenAudience, when set, is required as the token "aud" claim
+	TokenAudience string
 
-	// ExposureLevel controls how much information is exposed in health endpoints
-	ExposureLevel ExposureLevel
+	// DeviceCredentialTTL is the lifetime of credentials issued to devices
+	// at registration. Zero selects defaultDeviceCredentialTTL.
+	DeviceCredentialTTL time.Duration
+}
+
+// HealthReportConfig controls how device health reports are judged.
+type HealthReportConfig struct {
+	// Interval is how often devices are expected to report. Zero selects
+	// health.DefaultReportInterval.
+	Interval time.Duration
+
+	// MissedReports is how many consecutive reports a device may miss
+	// before it is marked offline. Zero selects health.DefaultMissedReports.
+	MissedReports int
+}
+
+// DriftConfig controls configuration drift detection.
+type DriftConfig struct {
+	// Interval is how often reported configuration hashes are compared
+	// with deployments. Zero selects drift.DefaultInterval.
+	Interval time.Duration
+
+	// Remediate re-deploys the expected configuration once to each device
+	// found drifted
+	Remediate bool
+}
+
+// StorageConfig selects the storage backend for each domain. Names refer to
+// backends registered in the domain's store factory registry; an empty name
+// selects the domain's backend from DefaultStorageConfig.
+type StorageConfig struct {
+	// Device selects the device store backend (e.g. "memory", "bolt")
+	Device string
+
+	// Group selects the group store backend (e.g. "memory")
+	Group string
+
+	// Config selects the configuration store backend (e.g. "memory", "postgres")
+	Config string
+
+	// ConfigDSN is the connection string for database-backed config stores
+	ConfigDSN string
+
+	// Health selects the health store backend (e.g. "memory")
+	Health string
+
+	// Logging selects the event log backend (e.g. "memory", "wal")
+	Logging string
+
+	// Rollout selects the rollout store backend (e.g. "memory", "bolt")
+	Rollout string
+}
+
+// DefaultStorageConfig returns the backend used for each domain without an
+// explicit one. Devices, rollouts and the event log are durable by default.
+// Groups and health state are kept in memory, and so are configurations
+// because the postgres backend needs an explicit DSN.
+func DefaultStorageConfig() StorageConfig {
+	return StorageConfig{
+		Device:  "bolt",
+		Group:   "memory",
+		Config:  "memory",
+		Health:  "memory",
+		Logging: "wal",
+		Rollout: "bolt",
+	}
+}
+
+// setDefaults fills empty backend names from DefaultStorageConfig
+func (c *StorageConfig) setDefaults() {
+	defaults := DefaultStorageConfig()
+	fields := []struct {
+		name     *string
+		fallback string
+	}{
+		{&c.Device, defaults.Device},
+		{&c.Group, defaults.Group},
+		{&c.Config, defaults.Config},
+		{&c.Health, defaults.Health},
+		{&c.Logging, defaults.Logging},
+		{&c.Rollout, defaults.Rollout},
+	}
+	for _, f := range fields {
+		if *f.name == "" {
+			*f.name = f.fallback
+		}
+	}
+}
+
+// Validate checks that every selected backend is registered, so unknown
+// names are rejected before any store is opened.
+func (c *StorageConfig) Validate() error {
+	checks := []struct {
+		registry interface{ Validate(string) error }
+		name     string
+	}{
+		{devicefactory.Registry, c.Device},
+		{groupfactory.Registry, c.Group},
+		{configfactory.Registry, c.Config},
+		{healthfactory.Registry, c.Health},
+		{loggingfactory.Registry, c.Logging},
+		{rolloutfactory.Registry, c.Rollout},
+	}
+	for _, check := range checks {
+		if err := check.registry.Validate(check.name); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Validate checks the configuration for errors and ensures all required values
@@ -73,6 +207,22 @@ func (c *Config) Validate() error {
 		c.LogLevel = defaultLogLevel
 	}
 
+	// Validate storage backend selection
+	if c.Stage1Config != nil && c.Stage1Config.DeviceStorageType != "" {
+		switch c.Storage.Device {
+		case "":
+			c.Storage.Device = c.Stage1Config.DeviceStorageType
+		case c.Stage1Config.DeviceStorageType:
+		default:
+			return fmt.Errorf("device storage type %q conflicts with storage device backend %q",
+				c.Stage1Config.DeviceStorageType, c.Storage.Device)
+		}
+	}
+	c.Storage.setDefaults()
+	if err := c.Storage.Validate(); err != nil {
+		return err
+	}
+
 	// Require management configuration
 	if c.ManagementConfig == nil {
 		return fmt.Errorf("management configuration must be provided")
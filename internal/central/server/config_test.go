@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDeviceStorageType(t *testing.T) {
+	tests := []struct {
+		name        string
+		storageType string
+		device      string
+		want        string
+		wantErr     string
+	}{
+		{name: "unset", want: DefaultStorageConfig().Device},
+		{name: "selects the device backend", storageType: "bolt", want: "bolt"},
+		{name: "matches the device backend", storageType: "bolt", device: "bolt", want: "bolt"},
+		{
+			name:        "conflicts with the device backend",
+			storageType: "bolt",
+			device:      "memory",
+			wantErr:     `device storage type "bolt" conflicts with storage device backend "memory"`,
+		},
+		{name: "unknown backend", storageType: "tape", wantErr: "tape"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:             "8080",
+				Stage1Config:     &Stage1Config{DeviceStorageType: tt.storageType},
+				Storage:          StorageConfig{Device: tt.device},
+				ManagementConfig: &ManagementConfig{Port: "8081"},
+			}
+			err := cfg.Validate()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cfg.Storage.Device)
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+// handleDeviceConfig serves a device's configuration.
+// - GET: Current configuration and latest deployment
+// - POST: Validate a configuration and deploy it as a new version
+func (s *Server) handleDeviceConfig(w http.ResponseWriter, r *http.Request, tenantID, deviceID string) {
+	ctx := r.Context()
+
+	if err := authorizeDevice(r, deviceID); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		dev, err := s.device.Get(ctx, tenantID, deviceID)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		out := api.DeviceConfigResponse{
+			DeviceID: dev.ID,
+			Config:   dev.Config,
+			Hash:     dev.LastConfigHash,
+		}
+		if n := len(dev.ConfigHistory); n > 0 {
+			out.Version = dev.ConfigHistory[n-1].Version
+		}
+		if s.config != nil {
+			deployment, err := s.config.LatestDeployment(ctx, tenantID, deviceID)
+			if err != nil && !isConfigCode(err, config.ErrDeploymentNotFound) {
+				apierror.Write(w, err)
+				return
+			}
+			out.Deployment = deployment
+		}
+
+		if err := apierror.WriteJSON(w, http.StatusOK, out); err != nil {
+			s.logger.Error("failed to encode device config response",
+				zap.Error(err),
+				zap.String("device_id", deviceID),
+				zap.String("tenant_id", tenantID))
+		}
+
+	case http.MethodPost:
+		if err := requireOperator(r); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		req, template, dev, ok := s.decodeConfigRequest(w, r, tenantID, deviceID)
+		if !ok {
+			return
+		}
+		values, err := s.renderValues(ctx, req, dev)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		deployment, err := s.config.ApplyConfig(ctx, tenantID, template.ID, deviceID, values, createdBy(r))
+		if err != nil {
+			s.logger.Warn("rejected device configuration",
+				zap.Error(err),
+				zap.String("device_id", deviceID),
+				zap.String("tenant_id", tenantID),
+				zap.String("template_id", template.ID))
+			apierror.Write(w, err)
+			return
+		}
+
+		if err := apierror.WriteJSON(w, http.StatusCreated, api.ConfigApplyResponse{
+			Deployment: deployment,
+		}); err != nil {
+			s.logger.Error("failed to encode config apply response",
+				zap.Error(err),
+				zap.String("device_id", deviceID),
+				zap.String("tenant_id", tenantID))
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		apierror.Write(w, apierror.MethodNotAllowed())
+	}
+}
+
+// handleDeviceConfigEffective serves the configuration a device ends up
+// with once the configuration of its groups and their ancestors is applied
+// beneath its own.
+// - GET: Effective configuration and the source of each key
+func (s *Server) handleDeviceConfigEffective(w http.ResponseWriter, r *http.Request, tenantID, deviceID string) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		apierror.Write(w, apierror.MethodNotAllowed())
+		return
+	}
+	if err := authorizeDevice(r, deviceID); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	if s.group == nil {
+		apierror.Write(w, apierror.Unavailable("group management is not available"))
+		return
+	}
+
+	dev, err := s.device.Get(ctx, tenantID, deviceID)
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	effective, err := s.group.EffectiveConfig(ctx, dev)
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	if err := apierror.WriteJSON(w, http.StatusOK, api.EffectiveConfigResponse{
+		EffectiveConfig: effective,
+	}); err != nil {
+		s.logger.Error("failed to encode effective config response",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID))
+	}
+}
+
+// handleDeviceConfigValidate renders and validates a configuration for a
+// device without storing anything.
+// - POST: Validate a configuration (body is an api.ConfigRequest)
+func (s *Server) handleDeviceConfigValidate(w http.ResponseWriter, r *http.Request, tenantID, deviceID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apierror.Write(w, apierror.MethodNotAllowed())
+		return
+	}
+	if err := authorizeDevice(r, deviceID); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	req, template, dev, ok := s.decodeConfigRequest(w, r, tenantID, deviceID)
+	if !ok {
+		return
+	}
+	values, err := s.renderValues(r.Context(), req, dev)
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	rendered, violations, err := s.config.PreviewConfig(r.Context(), tenantID, template.ID, values)
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	if err := apierror.WriteJSON(w, http.StatusOK, api.ConfigValidationResponse{
+		TemplateID:   template.ID,
+		TemplateName: template.Name,
+		Valid:        len(violations) == 0,
+		Violations:   violations,
+		Config:       rendered,
+	}); err != nil {
+		s.logger.Error("failed to encode config validation response",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID))
+	}
+}
+
+// decodeConfigRequest parses a configuration request for an existing
+// device and resolves the device and the template. It writes the error
+// response and returns false on failure.
+func (s *Server) decodeConfigRequest(w http.ResponseWriter, r *http.Request, tenantID, deviceID string) (*api.ConfigRequest, *config.Template, *device.Device, bool) {
+	ctx := r.Context()
+
+	if s.config == nil {
+		apierror.Write(w, apierror.Unavailable("configuration management is not available"))
+		return nil, nil, nil, false
+	}
+
+	var req api.ConfigRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apierror.Write(w, err)
+		return nil, nil, nil, false
+	}
+	if len(req.Config) == 0 || bytes.Equal(req.Config, []byte("null")) {
+		apierror.Write(w, apierror.BadRequest("config is required"))
+		return nil, nil, nil, false
+	}
+	if !json.Valid(req.Config) {
+		apierror.Write(w, apierror.BadRequest("config is not valid JSON"))
+		return nil, nil, nil, false
+	}
+
+	dev, err := s.device.Get(ctx, tenantID, deviceID)
+	if err != nil {
+		apierror.Write(w, err)
+		return nil, nil, nil, false
+	}
+
+	templateRef := req.Template
+	if templateRef == "" {
+		deployment, err := s.config.LatestDeployment(ctx, tenantID, deviceID)
+		switch {
+		case isConfigCode(err, config.ErrDeploymentNotFound):
+			apierror.Write(w, apierror.BadRequest("template is required: the device has no previous deployment"))
+			return nil, nil, nil, false
+		case err != nil:
+			apierror.Write(w, err)
+			return nil, nil, nil, false
+		}
+		templateRef = deployment.ConfigVersion.TemplateID
+	}
+
+	template, err := s.config.FindTemplate(ctx, tenantID, templateRef)
+	if err != nil {
+		apierror.Write(w, err)
+		return nil, nil, nil, false
+	}
+	return &req, template, dev, true
+}
+
+// renderValues collects what a configuration request is rendered with: the
+// request's own variable values and those of the device's groups, ordered
+// from least to most specific.
+func (s *Server) renderValues(ctx context.Context, req *api.ConfigRequest, dev *device.Device) (config.RenderValues, error) {
+	values := config.RenderValues{
+		Config: req.Config,
+		Device: req.Variables,
+		Target: dev,
+	}
+	if s.group == nil {
+		return values, nil
+	}
+
+	groups, err := s.group.DeviceGroups(ctx, dev.TenantID, dev.ID)
+	if err != nil {
+		return config.RenderValues{}, err
+	}
+	for _, g := range groups {
+		if len(g.Properties.Variables) == 0 {
+			continue
+		}
+		vars := make(map[string]interface{}, len(g.Properties.Variables))
+		for name, value := range g.Properties.Variables {
+			vars[name] = value
+		}
+		values.Groups = append(values.Groups, vars)
+	}
+	return values, nil
+}
+
+// requireOperator rejects device credentials. Devices may read and report
+// on their own device but only operators may manage the fleet.
+func requireOperator(r *http.Request) error {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal != nil && principal.DeviceID != "" {
+		return apierror.New(http.StatusForbidden, apierror.CodeForbidden,
+			"operation requires operator credentials")
+	}
+	return nil
+}
+
+// createdBy names the caller for audit fields.
+func createdBy(r *http.Request) string {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	switch {
+	case principal == nil:
+		return ""
+	case principal.Subject != "":
+		return principal.Subject
+	default:
+		return principal.CredentialID
+	}
+}
+
+// isConfigCode reports whether err is a configuration error with code.
+func isConfigCode(err error, code config.ErrorCode) bool {
+	var configErr *config.Error
+	return errors.As(err, &configErr) && configErr.Code == code
+}
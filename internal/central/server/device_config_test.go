@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	configmemory "github.com/wrale/wrale-fleet/internal/fleet/config/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	devicememory "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	groupmemory "github.com/wrale/wrale-fleet/internal/fleet/group/store/memory"
+)
+
+func TestDeviceConfigWorkflow(t *testing.T) {
+	s := newTestStage1Server(t)
+	s.config = config.NewService(configmemory.New(), s.logger)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	dev, err := s.device.Register(ctx, "tenant-a", "edge-1")
+	require.NoError(t, err)
+
+	rec := doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPost, api.PathTemplates,
+		`{"name":"edge","schema":{"type":"object","required":["port"],"properties":{"port":{"type":"integer"}}}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	c, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+
+	// Without a template or an earlier deployment there is nothing to
+	// validate against.
+	_, err = c.ValidateDeviceConfig(context.Background(), dev.ID, &api.ConfigRequest{Config: json.RawMessage(`{"port":1}`)})
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+
+	// Validation reports violations and stores nothing.
+	result, err := c.ValidateDeviceConfig(context.Background(), dev.ID, &api.ConfigRequest{
+		Template: "edge",
+		Config:   json.RawMessage(`{"port":"http"}`),
+	})
+	require.NoError(t, err)
+	assert.False(t, result.Valid)
+	require.Len(t, result.Violations, 1)
+	assert.Equal(t, "/port", result.Violations[0].Path)
+
+	current, err := c.DeviceConfig(context.Background(), dev.ID)
+	require.NoError(t, err)
+	assert.Nil(t, current.Deployment)
+
+	// Invalid configurations are rejected on apply as well.
+	_, err = c.ApplyDeviceConfig(context.Background(), dev.ID, &api.ConfigRequest{
+		Template: "edge",
+		Config:   json.RawMessage(`{}`),
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Status)
+	assert.NotEmpty(t, apiErr.Fields["violations"])
+
+	applied, err := c.ApplyDeviceConfig(context.Background(), dev.ID, &api.ConfigRequest{
+		Template: "edge",
+		Config:   json.RawMessage(`{ "port": 8080 }`),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, applied.Deployment.ConfigVersion)
+	assert.Equal(t, "pending", applied.Deployment.Status)
+	assert.Equal(t, 1, applied.Deployment.ConfigVersion.Number)
+	assert.Equal(t, config.ValidationStatusValid, applied.Deployment.ConfigVersion.Status)
+	assert.JSONEq(t, `{"port":8080}`, string(applied.Deployment.ConfigVersion.Config))
+
+	// Later changes default to the template of the latest deployment.
+	result, err = c.ValidateDeviceConfig(context.Background(), dev.ID, &api.ConfigRequest{
+		Config: json.RawMessage(`{"port":9090}`),
+	})
+	require.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, "edge", result.TemplateName)
+
+	current, err = c.DeviceConfig(context.Background(), dev.ID)
+	require.NoError(t, err)
+	require.NotNil(t, current.Deployment)
+	assert.Equal(t, applied.Deployment.ID, current.Deployment.ID)
+}
+
+func TestDeviceConfigRendering(t *testing.T) {
+	s := newTestStage1Server(t)
+	deviceStore := devicememory.New()
+	s.device = device.NewService(deviceStore, s.logger)
+	s.group = group.NewService(groupmemory.New(deviceStore), deviceStore, s.logger)
+	s.config = config.NewService(configmemory.New(), s.logger)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	dev, err := s.device.Register(ctx, "tenant-a", "edge-1")
+	require.NoError(t, err)
+
+	template := config.NewTemplate("tenant-a", "edge", json.RawMessage(
+		`{"type":"object","properties":{"port":{"type":"integer"}}}`))
+	require.NoError(t, template.AddVariable(config.Variable{Name: "site", Type: "string", Required: true}))
+	require.NoError(t, template.AddVariable(config.Variable{Name: "port", Type: "integer", Default: 8080}))
+	_, err = s.config.AddTemplate(ctx, template)
+	require.NoError(t, err)
+
+	site, err := s.group.Create(ctx, "tenant-a", "site", group.TypeStatic)
+	require.NoError(t, err)
+	site.Properties.Variables = map[string]json.RawMessage{"site": json.RawMessage(`"plant-3"`), "port": json.RawMessage(`443`)}
+	require.NoError(t, s.group.Update(ctx, site))
+	require.NoError(t, s.group.AddDevice(ctx, "tenant-a", site.ID, dev))
+
+	c, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+
+	// Device values override group values, and placeholders are expanded.
+	req := &api.ConfigRequest{
+		Template:  "edge",
+		Config:    json.RawMessage(`{"name":"${device.name}"}`),
+		Variables: map[string]interface{}{"port": 8443},
+	}
+	result, err := c.ValidateDeviceConfig(context.Background(), dev.ID, req)
+	require.NoError(t, err)
+	assert.True(t, result.Valid, result.Violations)
+	want := `{"name":"edge-1","site":"plant-3","port":8443}`
+	assert.JSONEq(t, want, string(result.Config))
+
+	applied, err := c.ApplyDeviceConfig(context.Background(), dev.ID, req)
+	require.NoError(t, err)
+	assert.JSONEq(t, want, string(applied.Deployment.ConfigVersion.Config))
+
+	// Unresolved variables are violations on validation and rejected on
+	// apply.
+	require.NoError(t, s.group.RemoveDevice(ctx, "tenant-a", site.ID, dev.ID))
+	result, err = c.ValidateDeviceConfig(context.Background(), dev.ID, req)
+	require.NoError(t, err)
+	assert.False(t, result.Valid)
+	assert.Empty(t, result.Config)
+	assert.Equal(t, []config.Violation{
+		{Path: "/site", Keyword: "variable", Message: "site: no value provided"},
+	}, result.Violations)
+
+	_, err = c.ApplyDeviceConfig(context.Background(), dev.ID, req)
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Status)
+	assert.NotEmpty(t, apiErr.Fields["unresolved"])
+}
+
+func TestDeviceConfigDelivery(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	store := configmemory.New()
+	s.config = config.NewService(store, s.logger)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	operator, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+	reg, err := operator.Register(context.Background(), &api.RegistrationRequest{Name: "edge-1"})
+	require.NoError(t, err)
+	agent, err := client.New(ts.URL, client.WithBearerToken(reg.Credentials.Token))
+	require.NoError(t, err)
+
+	rec := doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPost, api.PathTemplates,
+		`{"name":"edge","schema":{"type":"object"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	// Nothing is pending before the first deployment.
+	delivery, err := agent.PendingConfig(context.Background(), reg.DeviceID)
+	require.NoError(t, err)
+	assert.Nil(t, delivery)
+
+	apply := func(cfg string) *config.Deployment {
+		resp, err := operator.ApplyDeviceConfig(context.Background(), reg.DeviceID, &api.ConfigRequest{
+			Template: "edge",
+			Config:   json.RawMessage(cfg),
+		})
+		require.NoError(t, err)
+		return resp.Deployment
+	}
+
+	// A device reporting a different hash fails the deployment.
+	first := apply(`{"port": 8080}`)
+	delivery, err = agent.PendingConfig(context.Background(), reg.DeviceID)
+	require.NoError(t, err)
+	require.NotNil(t, delivery)
+	assert.Equal(t, first.ID, delivery.DeploymentID)
+	assert.Equal(t, `{"port":8080}`, string(delivery.Config))
+
+	ack, err := agent.AcknowledgeConfig(context.Background(), reg.DeviceID, &api.ConfigAck{
+		DeploymentID: delivery.DeploymentID,
+		Hash:         "0000",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, config.DeploymentStatusFailed, ack.Deployment.Status)
+	assert.Contains(t, ack.Deployment.Error, "expected")
+
+	// A newer deployment supersedes one the device has not picked up yet.
+	second := apply(`{"port": 8081}`)
+	third := apply(`{"port": 8082, "tls": "<on>"}`)
+	rec = doDeviceRequest(t, s.routes(), "tenant-a", http.MethodGet, api.DeviceConfigPath(reg.DeviceID), "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	delivery, err = agent.PendingConfig(context.Background(), reg.DeviceID)
+	require.NoError(t, err)
+	require.NotNil(t, delivery)
+	assert.Equal(t, third.ID, delivery.DeploymentID)
+	assert.JSONEq(t, `{"port":8082,"tls":"<on>"}`, string(delivery.Config))
+
+	// Only the device itself can acknowledge.
+	_, err = operator.AcknowledgeConfig(context.Background(), reg.DeviceID, &api.ConfigAck{
+		DeploymentID: delivery.DeploymentID,
+		Hash:         delivery.Hash,
+	})
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusForbidden, apiErr.Status)
+
+	for i := 0; i < 2; i++ {
+		ack, err = agent.AcknowledgeConfig(context.Background(), reg.DeviceID, &api.ConfigAck{
+			DeploymentID: delivery.DeploymentID,
+			Hash:         delivery.Hash,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, config.DeploymentStatusCompleted, ack.Deployment.Status)
+	}
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	stored, err := s.device.Get(ctx, "tenant-a", reg.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, delivery.Hash, stored.LastConfigHash)
+	require.Len(t, stored.ConfigHistory, 1)
+	assert.Equal(t, delivery.Hash, stored.ConfigHistory[0].Hash)
+
+	superseded, err := store.GetDeployment(ctx, "tenant-a", second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, config.DeploymentStatusSuperseded, superseded.Status)
+	assert.Equal(t, third.ID, superseded.SupersededBy)
+	assert.Empty(t, superseded.Error)
+
+	// A failed deployment cannot be completed afterwards.
+	_, err = agent.AcknowledgeConfig(context.Background(), reg.DeviceID, &api.ConfigAck{
+		DeploymentID: first.ID,
+		Hash:         delivery.Hash,
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+
+	// Neither can a superseded one.
+	_, err = agent.AcknowledgeConfig(context.Background(), reg.DeviceID, &api.ConfigAck{
+		DeploymentID: second.ID,
+		Hash:         delivery.Hash,
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+	assert.Contains(t, apiErr.Message, "superseded by deployment "+third.ID)
+
+	delivery, err = agent.PendingConfig(context.Background(), reg.DeviceID)
+	require.NoError(t, err)
+	assert.Nil(t, delivery)
+}
+
+func TestDeviceConfigRollback(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	store := configmemory.New()
+	s.config = config.NewService(store, s.logger)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	operator, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+	reg, err := operator.Register(context.Background(), &api.RegistrationRequest{Name: "edge-1"})
+	require.NoError(t, err)
+	agent, err := client.New(ts.URL, client.WithBearerToken(reg.Credentials.Token))
+	require.NoError(t, err)
+
+	rec := doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPost, api.PathTemplates,
+		`{"name":"edge","schema":{"type":"object"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	applied, err := operator.ApplyDeviceConfig(context.Background(), reg.DeviceID, &api.ConfigRequest{
+		Template: "edge",
+		Config:   json.RawMessage(`{"workers":0}`),
+	})
+	require.NoError(t, err)
+
+	// A rollback must say why.
+	_, err = agent.AcknowledgeConfig(context.Background(), reg.DeviceID, &api.ConfigAck{
+		DeploymentID: applied.Deployment.ID,
+		RolledBack:   true,
+	})
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+
+	for i := 0; i < 2; i++ {
+		ack, err := agent.AcknowledgeConfig(context.Background(), reg.DeviceID, &api.ConfigAck{
+			DeploymentID: applied.Deployment.ID,
+			Error:        "configuration rolled back after failed health check: unhealthy components: workers",
+			RolledBack:   true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, config.DeploymentStatusFailed, ack.Deployment.Status)
+		assert.Contains(t, ack.Deployment.Error, "unhealthy components: workers")
+		assert.True(t, ack.Deployment.RolledBack)
+	}
+
+	// The version is shared with other devices, so one rollback must not
+	// mark it
+	version := applied.Deployment.ConfigVersion
+	stored, err := store.GetVersion(context.Background(), "tenant-a", version.TemplateID, version.Number)
+	require.NoError(t, err)
+	assert.Equal(t, version.Status, stored.Status)
+	assert.NotEqual(t, config.ValidationStatusRollback, stored.Status)
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	dev, err := s.device.Get(ctx, "tenant-a", reg.DeviceID)
+	require.NoError(t, err)
+	assert.Empty(t, dev.ConfigHistory)
+}
+
+func TestDeviceEffectiveConfig(t *testing.T) {
+	s := newTestStage1Server(t)
+	deviceStore := devicememory.New()
+	s.device = device.NewService(deviceStore, s.logger)
+	s.group = group.NewService(groupmemory.New(deviceStore), deviceStore, s.logger)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	dev, err := s.device.Register(ctx, "tenant-a", "edge-1")
+	require.NoError(t, err)
+	require.NoError(t, dev.SetConfig(json.RawMessage(`{"port":8443}`), "operator"))
+	require.NoError(t, deviceStore.Update(ctx, dev))
+
+	site, err := s.group.Create(ctx, "tenant-a", "site", group.TypeStatic)
+	require.NoError(t, err)
+	site.Properties.ConfigTemplate = json.RawMessage(`{"port":443,"log":{"level":"info"}}`)
+	site.Properties.PolicyOverrides["log.level"] = json.RawMessage(`"warn"`)
+	require.NoError(t, s.group.Update(ctx, site))
+	require.NoError(t, s.group.AddDevice(ctx, "tenant-a", site.ID, dev))
+
+	c, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+
+	effective, err := c.EffectiveDeviceConfig(context.Background(), dev.ID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"port":8443,"log":{"level":"warn"}}`, string(effective.Config))
+	assert.Equal(t, []string{site.ID}, effective.Groups)
+	assert.Equal(t, group.Source{Layer: group.SourceDevice}, effective.Sources["port"])
+	assert.Equal(t, group.Source{Layer: group.SourcePolicyOverride, GroupID: site.ID, GroupName: "site"},
+		effective.Sources["log.level"])
+
+	rec := doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPost, api.DeviceConfigEffectivePath(dev.ID), "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
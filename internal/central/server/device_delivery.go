@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"go.uber.org/zap"
+)
+
+// handleDeviceConfigPending hands device agents the configuration they
+// should apply next.
+// - GET: The pending deployment as an api.ConfigDelivery, or 204 when the
+// device is up to date
+func (s *Server) handleDeviceConfigPending(w http.ResponseWriter, r *http.Request, tenantID, deviceID string) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		apierror.Write(w, apierror.MethodNotAllowed())
+		return
+	}
+	if err := authorizeDevice(r, deviceID); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	if s.config == nil {
+		apierror.Write(w, apierror.Unavailable("configuration management is not available"))
+		return
+	}
+	if _, err := s.device.Get(ctx, tenantID, deviceID); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	deployment, err := s.config.PendingDeployment(ctx, tenantID, deviceID)
+	if isConfigCode(err, config.ErrDeploymentNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	if deployment.ConfigVersion == nil {
+		apierror.Write(w, config.NewError("deliver deployment", config.ErrInvalidDeployment,
+			"deployment has no configuration version"))
+		return
+	}
+
+	delivered, hash, err := deployment.ConfigVersion.Delivery()
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	s.logger.Debug("delivering configuration deployment",
+		zap.String("deployment_id", deployment.ID),
+		zap.String("device_id", deviceID),
+		zap.String("tenant_id", tenantID),
+		zap.Int("version", deployment.ConfigVersion.Number))
+
+	if err := apierror.WriteJSON(w, http.StatusOK, api.ConfigDelivery{
+		DeploymentID: deployment.ID,
+		TemplateID:   deployment.ConfigVersion.TemplateID,
+		Version:      deployment.ConfigVersion.Number,
+		Hash:         hash,
+		Config:       delivered,
+	}); err != nil {
+		s.logger.Error("failed to encode config delivery",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID))
+	}
+}
+
+// handleDeviceConfigAck records the outcome of a deployment reported by the
+// device agent. A successful deployment becomes the device's current
+// configuration and is appended to its configuration history. A deployment
+// the device rolled back fails and is flagged as rolled back.
+// - POST: Acknowledge a deployment (body is an api.ConfigAck)
+func (s *Server) handleDeviceConfigAck(w http.ResponseWriter, r *http.Request, tenantID, deviceID string) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apierror.Write(w, apierror.MethodNotAllowed())
+		return
+	}
+	// Only the device can vouch for what it runs
+	principal, _ := auth.PrincipalFromContext(ctx)
+	if principal == nil || principal.DeviceID != deviceID {
+		apierror.Write(w, apierror.New(http.StatusForbidden, apierror.CodeForbidden,
+			"deployments must be acknowledged with the device's own credentials"))
+		return
+	}
+	if s.config == nil {
+		apierror.Write(w, apierror.Unavailable("configuration management is not available"))
+		return
+	}
+
+	var ack api.ConfigAck
+	if err := decodeJSON(w, r, &ack); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	if ack.DeploymentID == "" {
+		apierror.Write(w, apierror.BadRequest("deployment_id is required"))
+		return
+	}
+	if ack.Error == "" && ack.Hash == "" {
+		apierror.Write(w, apierror.BadRequest("hash is required when the configuration was applied"))
+		return
+	}
+	if ack.RolledBack && ack.Error == "" {
+		apierror.Write(w, apierror.BadRequest("error is required when the configuration was rolled back"))
+		return
+	}
+
+	var deployment *config.Deployment
+	var err error
+	if ack.RolledBack {
+		deployment, err = s.config.RollBackDeployment(ctx, tenantID, deviceID, ack.DeploymentID, ack.Error)
+	} else {
+		deployment, err = s.config.AcknowledgeDeployment(ctx, tenantID, deviceID, ack.DeploymentID, ack.Hash, ack.Error)
+	}
+	if err != nil {
+		s.logger.Warn("rejected deployment acknowledgement",
+			zap.Error(err),
+			zap.String("deployment_id", ack.DeploymentID),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID))
+		apierror.Write(w, err)
+		return
+	}
+
+	if deployment.Status == config.DeploymentStatusCompleted {
+		if err := s.recordAppliedConfig(ctx, tenantID, deviceID, deployment); err != nil {
+			s.logger.Error("failed to record applied configuration",
+				zap.Error(err),
+				zap.String("deployment_id", deployment.ID),
+				zap.String("device_id", deviceID),
+				zap.String("tenant_id", tenantID))
+			apierror.Write(w, err)
+			return
+		}
+	}
+
+	if err := apierror.WriteJSON(w, http.StatusOK, api.ConfigAckResponse{
+		Deployment: deployment,
+	}); err != nil {
+		s.logger.Error("failed to encode config ack response",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID))
+	}
+}
+
+// recordAppliedConfig makes a completed deployment the device's current
+// configuration. It does nothing when the device already runs it, so
+// repeated acknowledgements add a single history entry.
+func (s *Server) recordAppliedConfig(ctx context.Context, tenantID, deviceID string, deployment *config.Deployment) error {
+	dev, err := s.device.Get(ctx, tenantID, deviceID)
+	if err != nil {
+		return err
+	}
+
+	version := deployment.ConfigVersion
+	delivered, hash, err := version.Delivery()
+	if err != nil {
+		return err
+	}
+	// Health reports may already have recorded the hash, so the stored
+	// configuration decides whether anything changed.
+	if dev.LastConfigHash == hash && bytes.Equal(dev.Config, delivered) {
+		return nil
+	}
+
+	appliedBy := version.CreatedBy
+	if appliedBy == "" {
+		appliedBy = "deployment " + deployment.ID
+	}
+	if err := dev.SetConfig(delivered, appliedBy); err != nil {
+		return err
+	}
+	if version.ValidatedAt != nil {
+		validatedAt := *version.ValidatedAt
+		dev.ConfigHistory[len(dev.ConfigHistory)-1].ValidatedAt = &validatedAt
+	}
+	return s.device.Update(ctx, dev)
+}
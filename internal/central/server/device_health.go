@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"go.uber.org/zap"
+)
+
+// maxHealthHistory bounds the history returned for a single request.
+const maxHealthHistory = 100
+
+// serveDeviceSubresource dispatches requests below /api/v1/devices/{id}/.
+func (s *Server) serveDeviceSubresource(w http.ResponseWriter, r *http.Request, tenantID, deviceID, subresource string) {
+	switch subresource {
+	case "health":
+		s.handleDeviceHealth(w, r, tenantID, deviceID)
+	case "shutdown":
+		s.handleDeviceShutdown(w, r, tenantID, deviceID)
+	case "config":
+		s.handleDeviceConfig(w, r, tenantID, deviceID)
+	case "config/effective":
+		s.handleDeviceConfigEffective(w, r, tenantID, deviceID)
+	case "config/validate":
+		s.handleDeviceConfigValidate(w, r, tenantID, deviceID)
+	case "config/pending":
+		s.handleDeviceConfigPending(w, r, tenantID, deviceID)
+	case "config/ack":
+		s.handleDeviceConfigAck(w, r, tenantID, deviceID)
+	default:
+		apierror.Write(w, apierror.NotFound("not found"))
+	}
+}
+
+// authorizeDevice rejects device credentials acting on another device.
+// Tenant credentials may act on any device of their tenant.
+func authorizeDevice(r *http.Request, deviceID string) error {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal != nil && principal.DeviceID != "" && principal.DeviceID != deviceID {
+		return apierror.New(http.StatusForbidden, apierror.CodeForbidden,
+			"device credentials are limited to their own device")
+	}
+	return nil
+}
+
+// handleDeviceHealth accepts health reports from device agents and returns
+// the reports received so far.
+// - POST: Submit a health report (body is a health.HealthResponse)
+// - GET: Latest report; ?history=N adds up to N recent reports
+func (s *Server) handleDeviceHealth(w http.ResponseWriter, r *http.Request, tenantID, deviceID string) {
+	ctx := r.Context()
+
+	if s.reports == nil {
+		apierror.Write(w, apierror.Unavailable("health reporting is not available"))
+		return
+	}
+	if err := authorizeDevice(r, deviceID); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var resp health.HealthResponse
+		if err := decodeJSON(w, r, &resp); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		status, err := s.reports.Submit(ctx, health.NewDeviceReport(tenantID, deviceID, &resp))
+		if err != nil {
+			s.logger.Warn("rejected device health report",
+				zap.Error(err),
+				zap.String("device_id", deviceID),
+				zap.String("tenant_id", tenantID),
+				zap.String("remote_addr", r.RemoteAddr))
+			apierror.Write(w, err)
+			return
+		}
+		if resp.ConfigHash != "" {
+			s.recordReportedConfigHash(ctx, tenantID, deviceID, resp.ConfigHash)
+		}
+
+		if err := apierror.WriteJSON(w, http.StatusAccepted, api.HealthReportResponse{
+			DeviceStatus:   status,
+			ReportInterval: s.reports.Interval(),
+		}); err != nil {
+			s.logger.Error("failed to encode health report response",
+				zap.Error(err),
+				zap.String("device_id", deviceID),
+				zap.String("tenant_id", tenantID))
+		}
+
+	case http.MethodGet:
+		history := 0
+		if v := r.URL.Query().Get("history"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				apierror.Write(w, apierror.BadRequest("history must be a non-negative integer"))
+				return
+			}
+			history = n
+			if history > maxHealthHistory {
+				history = maxHealthHistory
+			}
+		}
+
+		// Resolve the device first so an unknown device is reported as
+		// such rather than as a device without reports.
+		if _, err := s.device.Get(ctx, tenantID, deviceID); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		latest, err := s.reports.Latest(ctx, tenantID, deviceID)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		out := api.DeviceHealthResponse{Latest: latest}
+		if history > 0 {
+			out.History, err = s.reports.History(ctx, tenantID, deviceID, history)
+			if err != nil {
+				apierror.Write(w, err)
+				return
+			}
+		}
+
+		if err := apierror.WriteJSON(w, http.StatusOK, out); err != nil {
+			s.logger.Error("failed to encode device health response",
+				zap.Error(err),
+				zap.String("device_id", deviceID),
+				zap.String("tenant_id", tenantID))
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		apierror.Write(w, apierror.MethodNotAllowed())
+	}
+}
+
+// recordReportedConfigHash stores the configuration hash a device reports
+// running, which drift detection compares with its deployments. Failures
+// are logged only; the health report itself was accepted.
+func (s *Server) recordReportedConfigHash(ctx context.Context, tenantID, deviceID, hash string) {
+	dev, err := s.device.Get(ctx, tenantID, deviceID)
+	if err != nil {
+		s.logger.Warn("failed to load device for reported configuration hash",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID))
+		return
+	}
+	if dev.LastConfigHash == hash {
+		return
+	}
+	dev.LastConfigHash = hash
+	if err := s.device.Update(ctx, dev); err != nil {
+		s.logger.Warn("failed to record reported configuration hash",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID))
+	}
+}
+
+// handleDeviceShutdown accepts planned shutdown notices. The device moves
+// into maintenance and missed health reports are not treated as an outage
+// until the announced return.
+// - POST: Submit a shutdown notice (body is an api.ShutdownNotice)
+func (s *Server) handleDeviceShutdown(w http.ResponseWriter, r *http.Request, tenantID, deviceID string) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apierror.Write(w, apierror.MethodNotAllowed())
+		return
+	}
+	if s.reports == nil {
+		apierror.Write(w, apierror.Unavailable("health reporting is not available"))
+		return
+	}
+	// Only the device itself can announce its shutdown, so the notice must
+	// carry the signed credential issued to it at registration.
+	principal, _ := auth.PrincipalFromContext(ctx)
+	if principal == nil || principal.DeviceID != deviceID {
+		apierror.Write(w, apierror.New(http.StatusForbidden, apierror.CodeForbidden,
+			"shutdown notices must be signed with the device's own credentials"))
+		return
+	}
+
+	var notice api.ShutdownNotice
+	if err := decodeJSON(w, r, &notice); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	window, err := s.reports.BeginMaintenance(ctx, tenantID, deviceID, notice.Reason, notice.ExpectedReturn)
+	if err != nil {
+		s.logger.Warn("rejected device shutdown notice",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID),
+			zap.String("remote_addr", r.RemoteAddr))
+		apierror.Write(w, err)
+		return
+	}
+
+	if err := apierror.WriteJSON(w, http.StatusAccepted, api.ShutdownResponse{
+		DeviceStatus:     device.StatusMaintenance,
+		MaintenanceUntil: window.Until,
+	}); err != nil {
+		s.logger.Error("failed to encode shutdown response",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID))
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	healthmemory "github.com/wrale/wrale-fleet/internal/fleet/health/store/memory"
+)
+
+func TestDeviceHealthReports(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	s.reports = health.NewReportService(healthmemory.NewReportStore(0), s.device, s.logger,
+		health.WithReportInterval(30*time.Second))
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	enroll, err := client.New(ts.URL, client.WithAPIKey(testEnrollmentKey))
+	require.NoError(t, err)
+	first, err := enroll.Register(context.Background(), &api.RegistrationRequest{Name: "edge-1"})
+	require.NoError(t, err)
+	second, err := enroll.Register(context.Background(), &api.RegistrationRequest{Name: "edge-2"})
+	require.NoError(t, err)
+
+	agent, err := client.New(ts.URL, client.WithBearerToken(first.Credentials.Token))
+	require.NoError(t, err)
+
+	resp, err := agent.ReportHealth(context.Background(), first.DeviceID, &health.HealthResponse{
+		Status: health.StatusUnhealthy,
+		Components: map[string]*health.HealthStatus{
+			"device_agent": {Status: health.StatusUnhealthy, LastError: "disk full"},
+		},
+		Version:     &health.Version{Version: "1.0.0", Stage: 1},
+		Uptime:      time.Minute,
+		LastChecked: time.Now().UTC(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusError, resp.DeviceStatus)
+	assert.Equal(t, 30*time.Second, resp.ReportInterval)
+
+	// A device cannot report on behalf of another device.
+	_, err = agent.ReportHealth(context.Background(), second.DeviceID, &health.HealthResponse{Status: health.StatusHealthy})
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusForbidden, apiErr.Status)
+
+	// Operators read the latest report and history with tenant credentials.
+	rec := doDeviceRequest(t, s.routes(), "tenant-a", http.MethodGet,
+		api.DeviceHealthPath(first.DeviceID)+"?history=5", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var got api.DeviceHealthResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	require.NotNil(t, got.Latest)
+	assert.Equal(t, "disk full", got.Latest.Components["device_agent"].LastError)
+	assert.Len(t, got.History, 1)
+
+	// A device that never reported has no health yet.
+	rec = doDeviceRequest(t, s.routes(), "tenant-a", http.MethodGet, api.DeviceHealthPath(second.DeviceID), "")
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+
+	// Invalid reports are rejected.
+	rec = doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPost, api.DeviceHealthPath(second.DeviceID),
+		`{"status":"sideways"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	assert.Equal(t, apierror.CodeBadRequest, decodeErrorCode(t, rec))
+}
+
+func TestDeviceShutdownNotice(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	s.reports = health.NewReportService(healthmemory.NewReportStore(0), s.device, s.logger)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	enroll, err := client.New(ts.URL, client.WithAPIKey(testEnrollmentKey))
+	require.NoError(t, err)
+	reg, err := enroll.Register(context.Background(), &api.RegistrationRequest{Name: "edge-1"})
+	require.NoError(t, err)
+
+	// Tenant credentials cannot speak for the device.
+	_, err = enroll.NotifyShutdown(context.Background(), reg.DeviceID, &api.ShutdownNotice{Reason: "reboot"})
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusForbidden, apiErr.Status)
+
+	agent, err := client.New(ts.URL, client.WithBearerToken(reg.Credentials.Token))
+	require.NoError(t, err)
+	expected := time.Now().Add(20 * time.Minute).UTC().Truncate(time.Second)
+	resp, err := agent.NotifyShutdown(context.Background(), reg.DeviceID, &api.ShutdownNotice{
+		Reason:         "firmware update",
+		ExpectedReturn: expected,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusMaintenance, resp.DeviceStatus)
+	assert.True(t, expected.Equal(resp.MaintenanceUntil))
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	stored, err := s.device.Get(ctx, "tenant-a", reg.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusMaintenance, stored.Status)
+
+	// An expected return in the past is rejected.
+	_, err = agent.NotifyShutdown(context.Background(), reg.DeviceID, &api.ShutdownNotice{
+		ExpectedReturn: time.Now().Add(-time.Hour),
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+// handleDrift reports devices whose configuration drifted from the
+// configuration last deployed to them. Devices may not list the fleet's
+// drift.
+// - GET: List the tenant's drifted devices
+func (s *Server) handleDrift() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID, err := device.TenantFromContext(r.Context())
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			apierror.Write(w, apierror.MethodNotAllowed())
+			return
+		}
+		if err := requireOperator(r); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if s.drift == nil {
+			apierror.Write(w, apierror.Unavailable("configuration drift detection is not available"))
+			return
+		}
+
+		if err := apierror.WriteJSON(w, http.StatusOK, api.DriftListResponse{
+			Drift: s.drift.List(tenantID),
+		}); err != nil {
+			s.logger.Error("failed to encode drift list response",
+				zap.Error(err),
+				zap.String("tenant_id", tenantID))
+		}
+	}
+}
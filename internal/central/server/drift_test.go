@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	configmemory "github.com/wrale/wrale-fleet/internal/fleet/config/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/drift"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	healthmemory "github.com/wrale/wrale-fleet/internal/fleet/health/store/memory"
+)
+
+func TestConfigDrift(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	s.config = config.NewService(configmemory.New(), s.logger)
+	s.reports = health.NewReportService(healthmemory.NewReportStore(0), s.device, s.logger)
+	s.drift = drift.NewDetector(s.config, s.device, s.logger, drift.WithRemediation(true))
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	operator, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+	reg, err := operator.Register(context.Background(), &api.RegistrationRequest{Name: "edge-1"})
+	require.NoError(t, err)
+	agent, err := client.New(ts.URL, client.WithBearerToken(reg.Credentials.Token))
+	require.NoError(t, err)
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	dev, err := s.device.Get(ctx, "tenant-a", reg.DeviceID)
+	require.NoError(t, err)
+	template, err := s.config.CreateTemplate(ctx, "tenant-a", "edge", json.RawMessage(`{"type":"object"}`))
+	require.NoError(t, err)
+	deployment, err := s.config.ApplyConfig(ctx, "tenant-a", template.ID, dev.ID,
+		config.RenderValues{Config: json.RawMessage(`{"port": 80}`), Target: dev}, "operator")
+	require.NoError(t, err)
+	delivered, hash, err := deployment.ConfigVersion.Delivery()
+	require.NoError(t, err)
+
+	report := func(configHash string) {
+		t.Helper()
+		_, err := agent.ReportHealth(context.Background(), dev.ID, &health.HealthResponse{
+			Status:      health.StatusHealthy,
+			LastChecked: time.Now().UTC(),
+			ConfigHash:  configHash,
+		})
+		require.NoError(t, err)
+	}
+
+	// A health report may carry the new hash before the deployment is
+	// acknowledged; the acknowledgement still records the configuration.
+	report(hash)
+	_, err = agent.AcknowledgeConfig(context.Background(), dev.ID, &api.ConfigAck{DeploymentID: deployment.ID, Hash: hash})
+	require.NoError(t, err)
+	got, err := s.device.Get(ctx, "tenant-a", dev.ID)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(delivered), string(got.Config))
+
+	require.NoError(t, s.drift.Check(context.Background(), time.Now().UTC()))
+	drifts, err := operator.ListDrift(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	// The device reports running something else.
+	report("edited-on-device")
+	require.NoError(t, s.drift.Check(context.Background(), time.Now().UTC()))
+	drifts, err = operator.ListDrift(context.Background())
+	require.NoError(t, err)
+	require.Len(t, drifts, 1)
+	assert.Equal(t, dev.ID, drifts[0].DeviceID)
+	assert.Equal(t, hash, drifts[0].ExpectedHash)
+	assert.Equal(t, "edited-on-device", drifts[0].ReportedHash)
+	require.NotEmpty(t, drifts[0].RemediationID)
+
+	// Devices cannot list the fleet's drift.
+	_, err = agent.ListDrift(context.Background())
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusForbidden, apiErr.Status)
+	assert.Equal(t, apierror.CodeForbidden, apiErr.Code)
+
+	// Applying the remediation clears the drift.
+	_, err = agent.AcknowledgeConfig(context.Background(), dev.ID, &api.ConfigAck{DeploymentID: drifts[0].RemediationID, Hash: hash})
+	require.NoError(t, err)
+	require.NoError(t, s.drift.Check(context.Background(), time.Now().UTC()))
+	drifts, err = operator.ListDrift(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
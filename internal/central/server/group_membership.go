@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"go.uber.org/zap"
+)
+
+// recordMembershipChange records a device joining or leaving a dynamic
+// group in the event log.
+func (s *Server) recordMembershipChange(ctx context.Context, event group.MembershipEvent) {
+	if s.logging == nil {
+		return
+	}
+	if err := s.logging.Log(ctx, event.TenantID, logging.EventOperational, logging.LevelInfo,
+		fmt.Sprintf("device %s %s dynamic group %s", event.DeviceID, event.Change, event.GroupName),
+		logging.WithEventContext(logging.EventContext{
+			ComponentID: "group-membership",
+			DeviceID:    event.DeviceID,
+		}),
+		logging.WithEventMetadata(event),
+	); err != nil {
+		s.logger.Warn("failed to record group membership change",
+			zap.Error(err),
+			zap.String("group_id", event.GroupID),
+			zap.String("device_id", event.DeviceID),
+			zap.String("tenant_id", event.TenantID))
+	}
+}
@@ -0,0 +1,642 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"go.uber.org/zap"
+)
+
+// handleGroups handles device group list and creation requests.
+// - GET: List the tenant's groups, optionally filtered by ?type=, ?parent=
+// and ?name=
+// - POST: Create a group (body is an api.GroupRequest)
+func (s *Server) handleGroups() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if s.group == nil {
+			apierror.Write(w, apierror.Unavailable("device groups are not available"))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			q := r.URL.Query()
+			groupType := group.Type(q.Get("type"))
+			if groupType != "" && !validGroupType(groupType) {
+				apierror.Write(w, apierror.BadRequest(fmt.Sprintf("invalid group type %q", groupType)))
+				return
+			}
+			groups, err := s.group.List(ctx, tenantID, group.ListOptions{
+				ParentID: q.Get("parent"),
+				Type:     groupType,
+			})
+			if err != nil {
+				apierror.Write(w, err)
+				return
+			}
+
+			// Names are not indexed by the store, so filter them here.
+			if name := q.Get("name"); name != "" {
+				matched := groups[:0]
+				for _, g := range groups {
+					if g.Name == name {
+						matched = append(matched, g)
+					}
+				}
+				groups = matched
+			}
+			sortGroups(groups)
+
+			if err := apierror.WriteJSON(w, http.StatusOK, api.GroupListResponse{
+				Groups: groups,
+			}); err != nil {
+				s.logger.Error("failed to encode group list response",
+					zap.Error(err),
+					zap.String("tenant_id", tenantID))
+			}
+
+		case http.MethodPost:
+			s.createGroup(w, r, tenantID)
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			apierror.Write(w, apierror.MethodNotAllowed())
+		}
+	}
+}
+
+// createGroup creates a static or dynamic group, optionally beneath a
+// parent. A group that cannot be completed is deleted again so a failed
+// request leaves nothing behind.
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request, tenantID string) {
+	ctx := r.Context()
+
+	if err := requireOperator(r); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	var req api.GroupRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	if req.Type == "" {
+		req.Type = group.TypeStatic
+		if req.Query != nil {
+			req.Type = group.TypeDynamic
+		}
+	}
+	switch {
+	case req.Name == "":
+		apierror.Write(w, apierror.BadRequest("name is required"))
+		return
+	case !validGroupType(req.Type):
+		apierror.Write(w, apierror.BadRequest(fmt.Sprintf("invalid group type %q", req.Type)))
+		return
+	case req.Type == group.TypeStatic && req.Query != nil:
+		apierror.Write(w, apierror.BadRequest("only dynamic groups have a query"))
+		return
+	}
+	if req.Parent != "" {
+		if _, err := s.group.Get(ctx, tenantID, req.Parent); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+	}
+
+	var (
+		created *group.Group
+		err     error
+	)
+	if req.Type == group.TypeDynamic {
+		created, err = s.group.CreateDynamic(ctx, tenantID, req.Name, req.Query)
+	} else {
+		created, err = s.group.Create(ctx, tenantID, req.Name, req.Type)
+	}
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	if err := s.completeGroup(r, created, &req); err != nil {
+		if delErr := s.group.Delete(ctx, tenantID, created.ID); delErr != nil {
+			s.logger.Error("failed to delete incomplete group",
+				zap.Error(delErr),
+				zap.String("group_id", created.ID),
+				zap.String("tenant_id", tenantID))
+		}
+		apierror.Write(w, err)
+		return
+	}
+
+	s.logger.Info("device group created",
+		zap.String("group_id", created.ID),
+		zap.String("tenant_id", tenantID),
+		zap.String("type", string(created.Type)),
+		zap.String("parent_id", created.ParentID),
+		zap.String("by", createdBy(r)))
+
+	w.Header().Set("Location", api.GroupPath(created.ID))
+	s.writeGroup(w, http.StatusCreated, created, tenantID)
+}
+
+// completeGroup applies the parts of a creation request the group service
+// does not take on creation, and refreshes g with the result.
+func (s *Server) completeGroup(r *http.Request, g *group.Group, req *api.GroupRequest) error {
+	ctx := r.Context()
+
+	if req.Description != "" || req.Properties != nil {
+		g.Description = req.Description
+		if req.Properties != nil {
+			if err := g.UpdateProperties(*req.Properties); err != nil {
+				return err
+			}
+		}
+		if err := s.group.Update(ctx, g); err != nil {
+			return err
+		}
+	}
+	if req.Parent != "" {
+		if err := s.group.UpdateHierarchy(ctx, g, req.Parent); err != nil {
+			return err
+		}
+	}
+
+	updated, err := s.group.Get(ctx, g.TenantID, g.ID)
+	if err != nil {
+		return err
+	}
+	*g = *updated
+	return nil
+}
+
+// handleGroupByID handles requests for a single group.
+// - GET /{id}: Retrieve a group
+// - PUT /{id}: Replace the group's editable fields (body is an
+// api.GroupUpdateRequest)
+// - DELETE /{id}: Delete the group and its subgroups
+// - POST /{id}/move: Move the group under a new parent (body is an
+// api.GroupMoveRequest)
+// - GET /{id}/devices: List the group's devices
+// - POST /{id}/devices: Add a device to a static group (body is an
+// api.GroupDeviceRequest)
+// - DELETE /{id}/devices/{deviceID}: Remove a device from a static group
+// - GET /{id}/rollup: Aggregate the devices of the group and its subgroups
+func (s *Server) handleGroupByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		groupID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, api.PathGroups+"/"), "/")
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if groupID == "" {
+			apierror.Write(w, apierror.NotFound("not found"))
+			return
+		}
+		if s.group == nil {
+			apierror.Write(w, apierror.Unavailable("device groups are not available"))
+			return
+		}
+
+		action, deviceID, _ := strings.Cut(action, "/")
+		switch {
+		case action == "":
+			s.serveGroup(w, r, tenantID, groupID)
+		case action == "move":
+			s.moveGroup(w, r, tenantID, groupID)
+		case action == "devices" && deviceID == "":
+			s.serveGroupDevices(w, r, tenantID, groupID)
+		case action == "devices":
+			s.removeGroupDevice(w, r, tenantID, groupID, deviceID)
+		case action == "rollup" && deviceID == "":
+			s.groupRollup(w, r, tenantID, groupID)
+		default:
+			apierror.Write(w, apierror.NotFound("not found"))
+		}
+	}
+}
+
+// serveGroup reads, replaces or deletes a group
+func (s *Server) serveGroup(w http.ResponseWriter, r *http.Request, tenantID, groupID string) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		g, err := s.group.Get(ctx, tenantID, groupID)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		s.writeGroup(w, http.StatusOK, g, tenantID)
+
+	case http.MethodPut:
+		if err := requireOperator(r); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		var req api.GroupUpdateRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		g, err := s.group.Get(ctx, tenantID, groupID)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if g.Type != group.TypeDynamic && req.Query != nil {
+			apierror.Write(w, apierror.BadRequest("only dynamic groups have a query"))
+			return
+		}
+		g.Name = req.Name
+		g.Description = req.Description
+		g.Query = req.Query
+		if err := g.UpdateProperties(req.Properties); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if err := s.group.Update(ctx, g); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		s.logger.Info("device group updated",
+			zap.String("group_id", groupID),
+			zap.String("tenant_id", tenantID),
+			zap.String("by", createdBy(r)))
+		s.writeGroup(w, http.StatusOK, g, tenantID)
+
+	case http.MethodDelete:
+		if err := requireOperator(r); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if err := s.group.Delete(ctx, tenantID, groupID); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		s.logger.Info("device group deleted",
+			zap.String("group_id", groupID),
+			zap.String("tenant_id", tenantID),
+			zap.String("by", createdBy(r)))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		apierror.Write(w, apierror.MethodNotAllowed())
+	}
+}
+
+// groupRollup reports the device counts by status, compliance and config
+// drift of a group and all its subgroups
+func (s *Server) groupRollup(w http.ResponseWriter, r *http.Request, tenantID, groupID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		apierror.Write(w, apierror.MethodNotAllowed())
+		return
+	}
+
+	rollup, err := s.group.Rollup(r.Context(), tenantID, groupID)
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	if err := apierror.WriteJSON(w, http.StatusOK, api.GroupRollupResponse{Rollup: rollup}); err != nil {
+		s.logger.Error("failed to encode group rollup", zap.Error(err))
+	}
+}
+
+// moveGroup moves a group, with its subgroups, under a new parent or to
+// the root of the hierarchy
+func (s *Server) moveGroup(w http.ResponseWriter, r *http.Request, tenantID, groupID string) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apierror.Write(w, apierror.MethodNotAllowed())
+		return
+	}
+	if err := requireOperator(r); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	var req api.GroupMoveRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	g, err := s.group.Get(ctx, tenantID, groupID)
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	if err := s.group.UpdateHierarchy(ctx, g, req.Parent); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	moved, err := s.group.Get(ctx, tenantID, groupID)
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	s.logger.Info("device group moved",
+		zap.String("group_id", groupID),
+		zap.String("tenant_id", tenantID),
+		zap.String("parent_id", req.Parent),
+		zap.String("by", createdBy(r)))
+	s.writeGroup(w, http.StatusOK, moved, tenantID)
+}
+
+// serveGroupDevices lists a group's devices or adds one to a static group
+func (s *Server) serveGroupDevices(w http.ResponseWriter, r *http.Request, tenantID, groupID string) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		devices, err := s.group.ListDevices(ctx, tenantID, groupID)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		sort.Slice(devices, func(i, j int) bool {
+			if devices[i].Name != devices[j].Name {
+				return devices[i].Name < devices[j].Name
+			}
+			return devices[i].ID < devices[j].ID
+		})
+		if err := apierror.WriteJSON(w, http.StatusOK, api.DeviceListResponse{
+			Devices: devices,
+		}); err != nil {
+			s.logger.Error("failed to encode group device list response",
+				zap.Error(err),
+				zap.String("group_id", groupID),
+				zap.String("tenant_id", tenantID))
+		}
+
+	case http.MethodPost:
+		if err := requireOperator(r); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		var req api.GroupDeviceRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if req.Device == "" {
+			apierror.Write(w, apierror.BadRequest("device is required"))
+			return
+		}
+
+		dev, err := s.device.Get(ctx, tenantID, req.Device)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if err := s.group.AddDevice(ctx, tenantID, groupID, dev); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		g, err := s.group.Get(ctx, tenantID, groupID)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		s.writeGroup(w, http.StatusOK, g, tenantID)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		apierror.Write(w, apierror.MethodNotAllowed())
+	}
+}
+
+// removeGroupDevice removes a device from a static group
+func (s *Server) removeGroupDevice(w http.ResponseWriter, r *http.Request, tenantID, groupID, deviceID string) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		apierror.Write(w, apierror.MethodNotAllowed())
+		return
+	}
+	if err := requireOperator(r); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	if err := s.group.RemoveDevice(r.Context(), tenantID, groupID, deviceID); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGroupTree returns the tenant's group hierarchy, or with ?root= the
+// subtree beneath one group. Groups whose parent cannot be found are shown
+// as roots so that nothing is hidden.
+func (s *Server) handleGroupTree() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			apierror.Write(w, apierror.MethodNotAllowed())
+			return
+		}
+		if s.group == nil {
+			apierror.Write(w, apierror.Unavailable("device groups are not available"))
+			return
+		}
+
+		groups, err := s.group.List(ctx, tenantID, group.ListOptions{})
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		roots := groupTree(groups)
+
+		if rootID := r.URL.Query().Get("root"); rootID != "" {
+			node := findGroupNode(roots, rootID)
+			if node == nil {
+				apierror.Write(w, group.E("server.handleGroupTree", group.ErrCodeGroupNotFound,
+					"group not found", nil).WithGroupID(rootID))
+				return
+			}
+			roots = []*api.GroupNode{node}
+		}
+
+		if err := apierror.WriteJSON(w, http.StatusOK, api.GroupTreeResponse{
+			Roots: roots,
+		}); err != nil {
+			s.logger.Error("failed to encode group tree response",
+				zap.Error(err),
+				zap.String("tenant_id", tenantID))
+		}
+	}
+}
+
+// groupTree arranges groups into their hierarchy by parent ID. Groups in
+// a cycle of parent links are reachable from no root, so the first of
+// each cycle is shown as a root as well.
+func groupTree(groups []*group.Group) []*api.GroupNode {
+	sortGroups(groups)
+	exists := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		exists[g.ID] = true
+	}
+	children := make(map[string][]*group.Group)
+	var rootGroups []*group.Group
+	for _, g := range groups {
+		if g.ParentID == "" || g.ParentID == g.ID || !exists[g.ParentID] {
+			rootGroups = append(rootGroups, g)
+			continue
+		}
+		children[g.ParentID] = append(children[g.ParentID], g)
+	}
+
+	placed := make(map[string]bool, len(groups))
+	var build func(g *group.Group) *api.GroupNode
+	build = func(g *group.Group) *api.GroupNode {
+		placed[g.ID] = true
+		node := &api.GroupNode{Group: g}
+		for _, child := range children[g.ID] {
+			if !placed[child.ID] {
+				node.Children = append(node.Children, build(child))
+			}
+		}
+		return node
+	}
+
+	roots := []*api.GroupNode{}
+	for _, g := range rootGroups {
+		roots = append(roots, build(g))
+	}
+	for _, g := range groups {
+		if !placed[g.ID] {
+			roots = append(roots, build(g))
+		}
+	}
+	return roots
+}
+
+// findGroupNode finds a group in a tree
+func findGroupNode(nodes []*api.GroupNode, groupID string) *api.GroupNode {
+	for _, node := range nodes {
+		if node.Group.ID == groupID {
+			return node
+		}
+		if found := findGroupNode(node.Children, groupID); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// sortGroups orders groups by name, then ID
+func sortGroups(groups []*group.Group) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].ID < groups[j].ID
+	})
+}
+
+// validGroupType reports whether t is a known group type
+func validGroupType(t group.Type) bool {
+	return t == group.TypeStatic || t == group.TypeDynamic
+}
+
+// writeGroup responds with a single group
+func (s *Server) writeGroup(w http.ResponseWriter, status int, g *group.Group, tenantID string) {
+	if err := apierror.WriteJSON(w, status, api.GroupResponse{
+		Group: g,
+	}); err != nil {
+		s.logger.Error("failed to encode group response",
+			zap.Error(err),
+			zap.String("group_id", g.ID),
+			zap.String("tenant_id", tenantID))
+	}
+}
+
+// handleGroupRepair rebuilds the ancestry of the tenant's groups from their
+// parents and moves orphaned groups beneath a quarantine root group. A dry
+// run reports the repairs without making them, so it needs no operator
+// role.
+func (s *Server) handleGroupRepair() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			apierror.Write(w, apierror.MethodNotAllowed())
+			return
+		}
+		if s.group == nil {
+			apierror.Write(w, apierror.Unavailable("device groups are not available"))
+			return
+		}
+
+		var req api.GroupRepairRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if !req.DryRun {
+			if err := requireOperator(r); err != nil {
+				apierror.Write(w, err)
+				return
+			}
+		}
+
+		report, err := s.group.RepairHierarchy(ctx, tenantID, req.DryRun)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if !req.DryRun && len(report.Actions) > 0 {
+			s.logger.Info("group hierarchy repaired",
+				zap.String("tenant_id", tenantID),
+				zap.Int("groups", report.Groups),
+				zap.Int("repairs", len(report.Actions)),
+				zap.String("quarantine_id", report.QuarantineID),
+				zap.String("by", createdBy(r)))
+		}
+
+		if err := apierror.WriteJSON(w, http.StatusOK, api.GroupRepairResponse{
+			Report: report,
+		}); err != nil {
+			s.logger.Error("failed to encode group repair response",
+				zap.Error(err),
+				zap.String("tenant_id", tenantID))
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	devicememory "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	groupmemory "github.com/wrale/wrale-fleet/internal/fleet/group/store/memory"
+)
+
+func TestGroupAPI(t *testing.T) {
+	s := newTestStage1Server(t)
+	deviceStore := devicememory.New()
+	s.device = device.NewService(deviceStore, s.logger)
+	groupStore := groupmemory.New(deviceStore)
+	s.group = group.NewService(groupStore, deviceStore, s.logger)
+	s.device.OnChange(s.group.DeviceChanged)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	pi, err := s.device.Register(ctx, "tenant-a", "pi-1")
+	require.NoError(t, err)
+	pi.Tags["site"] = "plant-3"
+	require.NoError(t, s.device.Update(ctx, pi))
+	gw, err := s.device.Register(ctx, "tenant-a", "gw-1")
+	require.NoError(t, err)
+
+	c, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+	var apiErr *apierror.Error
+
+	region, err := c.CreateGroup(context.Background(), &api.GroupRequest{
+		Name:        "eu",
+		Description: "European sites",
+		Properties:  &group.Properties{Metadata: map[string]string{"owner": "ops"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, group.TypeStatic, region.Type)
+	assert.Equal(t, "European sites", region.Description)
+	assert.Equal(t, "ops", region.Properties.Metadata["owner"])
+
+	plant, err := c.CreateGroup(context.Background(), &api.GroupRequest{
+		Name:   "plant-3",
+		Parent: region.ID,
+		Query:  &group.MembershipQuery{Custom: group.CustomExpression(`tags.site == "plant-3"`)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, group.TypeDynamic, plant.Type)
+	assert.Equal(t, region.ID, plant.ParentID)
+	assert.Equal(t, 1, plant.Ancestry.Depth)
+	assert.Equal(t, 1, plant.DeviceCount)
+
+	lab, err := c.CreateGroup(context.Background(), &api.GroupRequest{Name: "lab"})
+	require.NoError(t, err)
+
+	// Invalid groups are rejected and nothing is left behind.
+	_, err = c.CreateGroup(context.Background(), &api.GroupRequest{
+		Name:  "broken",
+		Query: &group.MembershipQuery{Custom: group.CustomExpression(`tags.site ==`)},
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+	assert.Contains(t, apiErr.Message, "invalid custom criteria")
+
+	_, err = c.CreateGroup(context.Background(), &api.GroupRequest{Name: "orphan", Parent: "missing"})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	_, err = c.CreateGroup(context.Background(), &api.GroupRequest{
+		Name: "static", Type: group.TypeStatic, Query: &group.MembershipQuery{},
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+
+	groups, err := c.ListGroups(context.Background(), "", "", "")
+	require.NoError(t, err)
+	require.Len(t, groups, 3)
+	assert.Equal(t, []string{"eu", "lab", "plant-3"}, []string{groups[0].Name, groups[1].Name, groups[2].Name})
+
+	groups, err = c.ListGroups(context.Background(), group.TypeDynamic, "", "")
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, plant.ID, groups[0].ID)
+
+	// Static membership is managed by hand.
+	updated, err := c.AddGroupDevice(context.Background(), lab.ID, gw.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated.DeviceCount)
+	members, err := c.GroupDevices(context.Background(), lab.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, gw.ID, members[0].ID)
+
+	_, err = c.AddGroupDevice(context.Background(), plant.ID, gw.ID)
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusConflict, apiErr.Status)
+
+	_, err = c.AddGroupDevice(context.Background(), lab.ID, "missing")
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	require.NoError(t, c.RemoveGroupDevice(context.Background(), lab.ID, gw.ID))
+	members, err = c.GroupDevices(context.Background(), lab.ID)
+	require.NoError(t, err)
+	assert.Empty(t, members)
+
+	members, err = c.GroupDevices(context.Background(), plant.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, pi.ID, members[0].ID)
+
+	// Rollups count the devices of a group and its subgroups.
+	rollup, err := c.GroupRollup(context.Background(), region.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rollup.Devices)
+	assert.Equal(t, 1, rollup.ByStatus[pi.Status])
+	_, err = c.GroupRollup(context.Background(), "missing")
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	// Updates replace the editable fields.
+	updated, err = c.UpdateGroup(context.Background(), plant.ID, &api.GroupUpdateRequest{
+		Name:  "plant-3 devices",
+		Query: &group.MembershipQuery{Custom: group.CustomExpression(`name =~ "-1$"`)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "plant-3 devices", updated.Name)
+	assert.Equal(t, 2, updated.DeviceCount)
+
+	_, err = c.UpdateGroup(context.Background(), lab.ID, &api.GroupUpdateRequest{
+		Name: "lab", Query: &group.MembershipQuery{},
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+
+	// Groups resolve by ID or by unique name.
+	found, err := c.FindGroup(context.Background(), "lab")
+	require.NoError(t, err)
+	assert.Equal(t, lab.ID, found.ID)
+	_, err = c.FindGroup(context.Background(), "missing")
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	// Moves take the subtree along and refuse cycles.
+	moved, err := c.MoveGroup(context.Background(), region.ID, lab.ID)
+	require.NoError(t, err)
+	assert.Equal(t, lab.ID, moved.ParentID)
+
+	_, err = c.MoveGroup(context.Background(), lab.ID, plant.ID)
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusConflict, apiErr.Status)
+
+	roots, err := c.GroupTree(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	assert.Equal(t, lab.ID, roots[0].Group.ID)
+	require.Len(t, roots[0].Children, 1)
+	assert.Equal(t, region.ID, roots[0].Children[0].Group.ID)
+	require.Len(t, roots[0].Children[0].Children, 1)
+	leaf := roots[0].Children[0].Children[0].Group
+	assert.Equal(t, plant.ID, leaf.ID)
+	assert.Equal(t, 2, leaf.Ancestry.Depth)
+
+	roots, err = c.GroupTree(context.Background(), region.ID)
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	assert.Equal(t, region.ID, roots[0].Group.ID)
+
+	_, err = c.MoveGroup(context.Background(), region.ID, "")
+	require.NoError(t, err)
+	roots, err = c.GroupTree(context.Background(), "")
+	require.NoError(t, err)
+	assert.Len(t, roots, 2)
+
+	// Repairs rebuild what the stored hierarchy gets wrong.
+	report, err := c.RepairGroups(context.Background(), true)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Groups)
+	assert.Empty(t, report.Actions)
+
+	stale, err := s.group.Get(ctx, "tenant-a", lab.ID)
+	require.NoError(t, err)
+	stale.Ancestry.Children = append(stale.Ancestry.Children, "ghost")
+	require.NoError(t, groupStore.Update(ctx, stale))
+	report, err = c.RepairGroups(context.Background(), true)
+	require.NoError(t, err)
+	require.Len(t, report.Actions, 1)
+	assert.Equal(t, group.RepairChildrenRebuilt, report.Actions[0].Kind)
+	report, err = c.RepairGroups(context.Background(), false)
+	require.NoError(t, err)
+	assert.Len(t, report.Actions, 1)
+	report, err = c.RepairGroups(context.Background(), false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Actions)
+
+	// Groups are scoped to their tenant.
+	other, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-b")))
+	require.NoError(t, err)
+	_, err = other.GetGroup(context.Background(), lab.ID)
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+	_, err = other.GroupTree(context.Background(), lab.ID)
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	// Deleting a group takes its subgroups along.
+	require.NoError(t, c.DeleteGroup(context.Background(), region.ID))
+	groups, err = c.ListGroups(context.Background(), "", "", "")
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, lab.ID, groups[0].ID)
+
+	rec := doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPatch, api.GroupPath(lab.ID), "{}")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	rec = doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPost, api.GroupPath(lab.ID)+"/copy", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
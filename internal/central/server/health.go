@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/wrale/wrale-fleet/internal/apierror"
 	"github.com/wrale/wrale-fleet/internal/fleet/health"
 	"go.uber.org/zap"
 )
@@ -125,7 +126,7 @@ func (s *Server) handleHealthCheck() http.HandlerFunc {
 			health.WithTenant(tenantID),
 		)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apierror.Write(w, err)
 			return
 		}
 
@@ -159,7 +160,7 @@ func (s *Server) handleReadyCheck() http.HandlerFunc {
 		// Check if the system is ready to serve requests
 		ready, err := s.health.IsReady(ctx, health.WithTenant(tenantID))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apierror.Write(w, err)
 			return
 		}
 
@@ -3,19 +3,34 @@ package server
 import (
 	"context"
 	"fmt"
+	"io"
+	"path/filepath"
+	"time"
 
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	configfactory "github.com/wrale/wrale-fleet/internal/fleet/config/store/factory"
 	"github.com/wrale/wrale-fleet/internal/fleet/device"
-	"github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	devicefactory "github.com/wrale/wrale-fleet/internal/fleet/device/store/factory"
+	"github.com/wrale/wrale-fleet/internal/fleet/drift"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	groupfactory "github.com/wrale/wrale-fleet/internal/fleet/group/store/factory"
 	"github.com/wrale/wrale-fleet/internal/fleet/health"
-	healthmem "github.com/wrale/wrale-fleet/internal/fleet/health/store/memory"
+	healthfactory "github.com/wrale/wrale-fleet/internal/fleet/health/store/factory"
+	healthmemory "github.com/wrale/wrale-fleet/internal/fleet/health/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	loggingfactory "github.com/wrale/wrale-fleet/internal/fleet/logging/store/factory"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	rolloutfactory "github.com/wrale/wrale-fleet/internal/fleet/rollout/store/factory"
 	"go.uber.org/zap"
 )
 
 // initialize sets up all server components in the proper sequence.
 // The initialization order is critical for proper dependency management:
 // 1. Core services (device, etc.)
-// 2. Health monitoring system
-// 3. Stage-specific capabilities
+// 2. API authentication
+// 3. Health monitoring system
+// 4. Stage-specific capabilities
 func (s *Server) initialize() error {
 	s.logger.Info("initializing central control plane server",
 		zap.String("port", s.cfg.Port),
@@ -28,6 +43,11 @@ func (s *Server) initialize() error {
 		return fmt.Errorf("core services initialization failed: %w", err)
 	}
 
+	// Resolve API credentials before any route is served
+	if err := s.initAuth(); err != nil {
+		return fmt.Errorf("authentication initialization failed: %w", err)
+	}
+
 	// Next initialize health monitoring
 	if err := s.initHealthSystem(); err != nil {
 		return fmt.Errorf("health system initialization failed: %w", err)
@@ -42,21 +62,169 @@ func (s *Server) initialize() error {
 }
 
 // initCoreServices initializes the fundamental services required by the system.
+// Each service's store is opened from the backend selected in cfg.Storage.
 func (s *Server) initCoreServices() error {
-	// Initialize device service
-	s.logger.Info("initializing core services")
-	store := memory.New()
-	s.device = device.NewService(store, s.logger)
+	s.logger.Info("initializing core services",
+		zap.String("device_storage", s.cfg.Storage.Device),
+		zap.String("group_storage", s.cfg.Storage.Group),
+		zap.String("config_storage", s.cfg.Storage.Config),
+		zap.String("logging_storage", s.cfg.Storage.Logging),
+		zap.String("rollout_storage", s.cfg.Storage.Rollout),
+	)
+	ctx := s.baseCtx
+
+	deviceStore, err := devicefactory.Registry.Open(ctx, s.cfg.Storage.Device, devicefactory.Options{
+		DataDir: s.cfg.DataDir,
+	})
+	if err != nil {
+		return fmt.Errorf("device store initialization failed: %w", err)
+	}
+	s.trackStore("device", deviceStore)
+	s.device = device.NewService(deviceStore, s.logger)
+
+	groupStore, err := groupfactory.Registry.Open(ctx, s.cfg.Storage.Group, groupfactory.Options{
+		DataDir:     s.cfg.DataDir,
+		DeviceStore: deviceStore,
+	})
+	if err != nil {
+		return fmt.Errorf("group store initialization failed: %w", err)
+	}
+	s.trackStore("group", groupStore)
+
+	configStore, err := configfactory.Registry.Open(ctx, s.cfg.Storage.Config, configfactory.Options{
+		DataDir: s.cfg.DataDir,
+		DSN:     s.cfg.Storage.ConfigDSN,
+	})
+	if err != nil {
+		return fmt.Errorf("config store initialization failed: %w", err)
+	}
+	s.trackStore("config", configStore)
+	s.config = config.NewService(configStore, s.logger)
+
+	if err := s.initLogging(); err != nil {
+		return fmt.Errorf("logging initialization failed: %w", err)
+	}
+
+	// Drift flags are rebuilt from device reports after a restart.
+	s.drift = drift.NewDetector(s.config, s.device, s.logger,
+		drift.WithInterval(s.cfg.Drift.Interval),
+		drift.WithRemediation(s.cfg.Drift.Remediate),
+		drift.WithAuditor(s.logging),
+	)
+	s.goLoop(s.drift.Run)
+
+	// Group rollups count the devices the drift detector flags.
+	s.group = group.NewService(groupStore, deviceStore, s.logger,
+		group.WithMembershipHandler(s.recordMembershipChange),
+		group.WithDriftChecker(s.drift))
+	s.device.OnChange(s.group.DeviceChanged)
+
+	// Rollouts in a durable store resume after a restart; waves that
+	// timed out while the server was down are scored on the next advance.
+	rolloutStore, err := rolloutfactory.Registry.Open(ctx, s.cfg.Storage.Rollout, rolloutfactory.Options{
+		DataDir: s.cfg.DataDir,
+	})
+	if err != nil {
+		return fmt.Errorf("rollout store initialization failed: %w", err)
+	}
+	s.trackStore("rollout", rolloutStore)
+	s.rollouts = rollout.NewService(rolloutStore, s.config, s.group, s.logger)
+	s.goLoop(s.rollouts.Run)
 
 	return nil
 }
 
+// initLogging uses the injected logging service when present, otherwise it
+// opens the configured event store with the default retention policies.
+func (s *Server) initLogging() error {
+	if s.cfg.LoggingService != nil {
+		s.logging = s.cfg.LoggingService
+		return nil
+	}
+
+	store, err := loggingfactory.Registry.Open(s.baseCtx, s.cfg.Storage.Logging, loggingfactory.Options{
+		DataDir: s.cfg.DataDir,
+	})
+	if err != nil {
+		return err
+	}
+	s.trackStore("logging", store)
+
+	service, err := logging.NewService(store, s.logger,
+		logging.WithRetentionPolicy(logging.EventSystem, 30*24*time.Hour),      // 30 days for system events
+		logging.WithRetentionPolicy(logging.EventSecurity, 90*24*time.Hour),    // 90 days for security events
+		logging.WithRetentionPolicy(logging.EventAudit, 365*24*time.Hour),      // 1 year for audit events
+		logging.WithRetentionPolicy(logging.EventCompliance, 730*24*time.Hour), // 2 years for compliance events
+	)
+	if err != nil {
+		return err
+	}
+	s.logging = service
+	return nil
+}
+
+// initAuth builds the API authenticator from the configured credentials.
+// Device credentials issued at registration are signed with a key kept in
+// the data directory and are always accepted alongside configured keys.
+func (s *Server) initAuth() error {
+	keys, err := auth.NewAPIKeyStore(s.cfg.Auth.APIKeys)
+	if err != nil {
+		return err
+	}
+
+	signingKey, err := auth.LoadOrCreateEd25519Key(filepath.Join(s.cfg.DataDir, deviceSigningKeyFile))
+	if err != nil {
+		return fmt.Errorf("loading device signing key: %w", err)
+	}
+	s.deviceSigner, err = auth.NewEd25519Signer(deviceSigningKeyID, signingKey)
+	if err != nil {
+		return err
+	}
+
+	verifierOpts := []auth.VerifierOption{
+		auth.WithIssuer(s.cfg.Auth.TokenIssuer),
+		auth.WithAudience(s.cfg.Auth.TokenAudience),
+	}
+	tokenKeys := append([]auth.TokenKey{s.deviceSigner.VerificationKey()}, s.cfg.Auth.TokenKeys...)
+	verifier, err := auth.NewTokenVerifier(tokenKeys, verifierOpts...)
+	if err != nil {
+		return err
+	}
+
+	// Renewals must present a credential this server issued to the device
+	s.deviceVerifier, err = auth.NewTokenVerifier([]auth.TokenKey{s.deviceSigner.VerificationKey()}, verifierOpts...)
+	if err != nil {
+		return err
+	}
+
+	s.auth = auth.NewAuthenticator(keys, verifier)
+	if len(s.cfg.Auth.APIKeys) == 0 && len(s.cfg.Auth.TokenKeys) == 0 {
+		s.logger.Warn("no API credentials configured; devices cannot register and API requests will be rejected")
+	}
+	s.logger.Info("API authentication initialized",
+		zap.Int("api_keys", keys.Len()),
+		zap.Int("token_keys", verifier.Len()),
+	)
+	return nil
+}
+
+// trackStore remembers stores that hold resources so they can be released
+// on shutdown.
+func (s *Server) trackStore(name string, store interface{}) {
+	if closer, ok := store.(io.Closer); ok {
+		s.closers = append(s.closers, namedCloser{name: name, closer: closer})
+	}
+}
+
 // initHealthSystem initializes the health monitoring system.
 func (s *Server) initHealthSystem() error {
 	s.logger.Info("initializing health monitoring system")
 
-	// Create health service with memory store
-	healthStore := healthmem.New()
+	healthStore, err := healthfactory.Registry.Open(s.baseCtx, s.cfg.Storage.Health, healthfactory.Options{})
+	if err != nil {
+		return fmt.Errorf("health store initialization failed: %w", err)
+	}
+	s.trackStore("health", healthStore)
 	s.health = health.NewService(healthStore, s.logger)
 
 	// Register base components for health monitoring
@@ -65,29 +233,52 @@ func (s *Server) initHealthSystem() error {
 	}
 
 	// Start periodic health check goroutine
-	go s.runHealthChecks(s.baseCtx)
+	s.goLoop(s.runHealthChecks)
+
+	// Device health reports describe the fleet rather than this process.
+	// They are kept in memory; devices repopulate them within one interval.
+	s.reports = health.NewReportService(healthmemory.NewReportStore(0), s.device, s.logger,
+		health.WithReportInterval(s.cfg.HealthReports.Interval),
+		health.WithMissedReports(s.cfg.HealthReports.MissedReports),
+	)
+	s.goLoop(s.reports.Run)
+	s.logger.Info("device health report ingestion initialized",
+		zap.Duration("offline_after", s.reports.OfflineAfter()))
 
 	return nil
 }
 
-// cleanupDeviceService performs cleanup of device service resources.
-// This is called during server shutdown to ensure proper resource release.
-func (s *Server) cleanupDeviceService(ctx context.Context) error {
-	if s.device == nil {
-		s.logger.Debug("no device service to clean up")
-		return nil
-	}
+// goLoop runs a background loop until the server's base context is
+// canceled. Stop waits for every loop before closing the stores.
+func (s *Server) goLoop(run func(ctx context.Context)) {
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		run(s.baseCtx)
+	}()
+}
 
-	s.logger.Info("cleaning up device service resources")
+// namedCloser pairs a store with its domain name for shutdown logging
+type namedCloser struct {
+	name   string
+	closer io.Closer
+}
 
-	// Cleanup device store if it implements cleanup
-	if closer, ok := s.device.Store().(interface{ Close() error }); ok {
-		if err := closer.Close(); err != nil {
-			s.logger.Error("failed to close device store", zap.Error(err))
-			return fmt.Errorf("closing device store: %w", err)
+// closeStores releases every tracked store in reverse order of opening, so
+// dependent stores close before the stores they rely on. All stores are
+// closed even if some fail; the first error is returned.
+func (s *Server) closeStores() error {
+	var firstErr error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		c := s.closers[i]
+		s.logger.Info("closing store", zap.String("store", c.name))
+		if err := c.closer.Close(); err != nil {
+			s.logger.Error("failed to close store", zap.String("store", c.name), zap.Error(err))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("closing %s store: %w", c.name, err)
+			}
 		}
 	}
-
-	s.logger.Info("device service cleanup completed")
-	return nil
+	s.closers = nil
+	return firstErr
 }
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/wrale/wrale-fleet/internal/apierror"
 	"go.uber.org/zap"
 )
 
@@ -140,7 +141,7 @@ func (s *ManagementServer) updateHealthMetric(name string, value interface{}) {
 func (s *ManagementServer) handleHealth() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			apierror.Write(w, apierror.MethodNotAllowed())
 			return
 		}
 
@@ -150,7 +151,7 @@ func (s *ManagementServer) handleHealth() http.HandlerFunc {
 
 		// Check if we're shutting down
 		if s.shuttingDown {
-			http.Error(w, "Service shutting down", http.StatusServiceUnavailable)
+			apierror.Write(w, apierror.Unavailable("service shutting down"))
 			return
 		}
 
@@ -158,7 +159,7 @@ func (s *ManagementServer) handleHealth() http.HandlerFunc {
 		if s.config.HealthCheck != nil {
 			if err := s.config.HealthCheck(ctx); err != nil {
 				s.lastCheckErr = err
-				http.Error(w, "Service unhealthy: "+err.Error(), http.StatusServiceUnavailable)
+				apierror.Write(w, apierror.Unavailable("service unhealthy: "+err.Error()))
 				return
 			}
 		}
@@ -188,7 +189,6 @@ func (s *ManagementServer) handleHealth() http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(response); err != nil {
 			s.logger.Error("failed to encode health response", zap.Error(err))
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 	}
 }
@@ -198,7 +198,7 @@ func (s *ManagementServer) handleHealth() http.HandlerFunc {
 func (s *ManagementServer) handleReadiness() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			apierror.Write(w, apierror.MethodNotAllowed())
 			return
 		}
 
@@ -208,14 +208,14 @@ func (s *ManagementServer) handleReadiness() http.HandlerFunc {
 
 		// Check if we're shutting down
 		if s.shuttingDown {
-			http.Error(w, "Service shutting down", http.StatusServiceUnavailable)
+			apierror.Write(w, apierror.Unavailable("service shutting down"))
 			return
 		}
 
 		// Verify readiness if check is configured
 		if s.config.ReadinessCheck != nil {
 			if err := s.config.ReadinessCheck(ctx); err != nil {
-				http.Error(w, "Service not ready: "+err.Error(), http.StatusServiceUnavailable)
+				apierror.Write(w, apierror.Unavailable("service not ready: "+err.Error()))
 				return
 			}
 		}
@@ -234,7 +234,6 @@ func (s *ManagementServer) handleReadiness() http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(response); err != nil {
 			s.logger.Error("failed to encode readiness response", zap.Error(err))
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 	}
 }
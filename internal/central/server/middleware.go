@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+// authenticate wraps an API handler so that it only runs for authenticated
+// callers. The caller's tenant is injected with device.ContextWithTenant and
+// the full principal with auth.ContextWithPrincipal. Failed attempts are
+// recorded through the device security monitor for auditing. Enrollment
+// keys are only accepted for device registration.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		principal, err := s.auth.Authenticate(r)
+		if err != nil {
+			reason := "unknown"
+			var authErr *auth.Error
+			if errors.As(err, &authErr) {
+				reason = authErr.Code
+			}
+
+			s.device.Monitor().RecordAuthAttempt(ctx, "", "", r.RemoteAddr, false, map[string]string{
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"reason": reason,
+			})
+			s.logger.Warn("API authentication failed",
+				zap.String("reason", reason),
+				zap.String("path", r.URL.Path),
+				zap.String("remote_addr", r.RemoteAddr))
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wfcentral"`)
+			apierror.Write(w, err)
+			return
+		}
+
+		s.logger.Debug("API request authenticated",
+			zap.String("tenant_id", principal.TenantID),
+			zap.String("subject", principal.Subject),
+			zap.String("method", string(principal.Method)),
+			zap.String("credential_id", principal.CredentialID))
+
+		// Enrollment keys are handed to device agents to register with and
+		// must not reach the operator API
+		if principal.Scope == auth.ScopeEnrollment && r.URL.Path != api.PathRegistrations {
+			s.logger.Warn("enrollment credential used outside registration",
+				zap.String("credential_id", principal.CredentialID),
+				zap.String("path", r.URL.Path),
+				zap.String("remote_addr", r.RemoteAddr))
+			apierror.Write(w, apierror.New(http.StatusForbidden, apierror.CodeForbidden,
+				"enrollment credentials can only register devices"))
+			return
+		}
+
+		ctx = device.ContextWithTenant(ctx, principal.TenantID)
+		ctx = auth.ContextWithPrincipal(ctx, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
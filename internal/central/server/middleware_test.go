@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+)
+
+func TestAuthenticateMiddleware(t *testing.T) {
+	s := newTestStage1Server(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	verifier, err := auth.NewTokenVerifier([]auth.TokenKey{
+		{ID: "edge", Algorithm: auth.AlgorithmEdDSA, PublicKey: pub},
+	})
+	require.NoError(t, err)
+	keys, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "ops", TenantID: "tenant-a", Subject: "ops-team", SHA256: auth.HashAPIKey("secret-key")},
+	})
+	require.NoError(t, err)
+	s.auth = auth.NewAuthenticator(keys, verifier)
+
+	signer, err := auth.NewEd25519Signer("edge", priv)
+	require.NoError(t, err)
+	token, err := signer.Sign(auth.Claims{
+		Subject:   "pipeline",
+		TenantID:  "tenant-b",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	var gotTenant string
+	var gotPrincipal *auth.Principal
+	h := s.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant, _ = device.TenantFromContext(r.Context())
+		gotPrincipal, _ = auth.PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name        string
+		header      string
+		value       string
+		wantStatus  int
+		wantTenant  string
+		wantSubject string
+	}{
+		{"api key header", auth.APIKeyHeader, "secret-key", http.StatusNoContent, "tenant-a", "ops-team"},
+		{"api key scheme", "Authorization", "ApiKey secret-key", http.StatusNoContent, "tenant-a", "ops-team"},
+		{"bearer token", "Authorization", "Bearer " + token, http.StatusNoContent, "tenant-b", "pipeline"},
+		{"no credentials", "", "", http.StatusUnauthorized, "", ""},
+		{"wrong api key", auth.APIKeyHeader, "guess", http.StatusUnauthorized, "", ""},
+		{"tampered token", "Authorization", "Bearer " + token + "x", http.StatusUnauthorized, "", ""},
+		{"unknown scheme", "Authorization", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTenant, gotPrincipal = "", nil
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+			if tt.wantStatus != http.StatusNoContent {
+				assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+				assert.Nil(t, gotPrincipal)
+				return
+			}
+			assert.Equal(t, tt.wantTenant, gotTenant)
+			require.NotNil(t, gotPrincipal)
+			assert.Equal(t, tt.wantTenant, gotPrincipal.TenantID)
+			assert.Equal(t, tt.wantSubject, gotPrincipal.Subject)
+		})
+	}
+}
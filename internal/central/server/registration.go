@@ -0,0 +1,219 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+// handleRegistrations implements the device registration handshake. A device
+// agent authenticates with an enrollment credential for its tenant, reports
+// its identity and capabilities, and receives a device ID together with a
+// signed device credential for all later calls. An agent whose credential
+// expired renews it by naming its device ID and presenting the expired
+// credential.
+func (s *Server) handleRegistrations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			apierror.Write(w, apierror.MethodNotAllowed())
+			return
+		}
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+
+		// A device credential identifies one existing device and must not
+		// be usable to enroll further devices.
+		principal, _ := auth.PrincipalFromContext(ctx)
+		if principal != nil && principal.DeviceID != "" {
+			apierror.Write(w, apierror.New(http.StatusForbidden, apierror.CodeForbidden,
+				"device credentials cannot register devices"))
+			return
+		}
+
+		var req api.RegistrationRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		s.logger.Info("handling device registration",
+			zap.String("tenant_id", tenantID),
+			zap.String("name", req.Name),
+			zap.String("device_id", req.DeviceID),
+			zap.String("remote_addr", r.RemoteAddr))
+
+		dev, status, err := s.registerDevice(r, tenantID, &req)
+		if err != nil {
+			s.logger.Error("failed to register device",
+				zap.Error(err),
+				zap.String("tenant_id", tenantID),
+				zap.String("device_id", req.DeviceID),
+				zap.String("remote_addr", r.RemoteAddr))
+			apierror.Write(w, err)
+			return
+		}
+
+		creds, err := s.issueDeviceCredentials(dev)
+		if err != nil {
+			s.logger.Error("failed to issue device credentials",
+				zap.Error(err),
+				zap.String("device_id", dev.ID),
+				zap.String("tenant_id", tenantID))
+			apierror.Write(w, err)
+			return
+		}
+
+		actor := ""
+		if principal != nil {
+			actor = principal.Subject
+		}
+		action := "register"
+		if status == http.StatusOK {
+			action = "renew"
+		}
+		s.device.Monitor().RecordAuthAttempt(ctx, dev.ID, tenantID, actor, true, map[string]string{
+			"action":     action,
+			"expires_at": creds.ExpiresAt.Format(time.RFC3339),
+		})
+
+		w.Header().Set("Location", api.PathDevices+"/"+dev.ID)
+		if err := apierror.WriteJSON(w, status, api.RegistrationResponse{
+			DeviceID:    dev.ID,
+			TenantID:    dev.TenantID,
+			Name:        dev.Name,
+			Credentials: *creds,
+		}); err != nil {
+			s.logger.Error("failed to encode registration response",
+				zap.Error(err),
+				zap.String("device_id", dev.ID),
+				zap.String("tenant_id", tenantID))
+		}
+	}
+}
+
+// registerDevice stores the device a registration describes and returns it
+// with the response status. A renewal must prove possession of the
+// device's credential and updates the existing device, keeping its name; otherwise the complete device is built, validated and created
+// in one step so a bad request never leaves a partial device behind.
+func (s *Server) registerDevice(r *http.Request, tenantID string, req *api.RegistrationRequest) (*device.Device, int, error) {
+	ctx := r.Context()
+
+	if req.DeviceID != "" {
+		if err := s.verifyRenewal(tenantID, req); err != nil {
+			return nil, 0, err
+		}
+		dev, err := s.device.Get(ctx, tenantID, req.DeviceID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := applyRegistration(dev, req); err != nil {
+			return nil, 0, err
+		}
+		dev.SetStatus(device.StatusOnline)
+		if err := dev.Validate(); err != nil {
+			return nil, 0, err
+		}
+		if err := s.device.Update(ctx, dev); err != nil {
+			return nil, 0, err
+		}
+		return dev, http.StatusOK, nil
+	}
+
+	dev := device.New(tenantID, req.Name)
+	if err := applyRegistration(dev, req); err != nil {
+		return nil, 0, err
+	}
+	dev.SetStatus(device.StatusOnline)
+	if err := dev.Validate(); err != nil {
+		return nil, 0, err
+	}
+	if err := s.device.Create(ctx, dev); err != nil {
+		return nil, 0, err
+	}
+	return dev, http.StatusCreated, nil
+}
+
+// verifyRenewal checks that a renewal presents a credential this server
+// issued to the named device. Expiry is ignored since renewing expired
+// credentials is the point; the credential only proves possession, so the
+// enrollment credential alone cannot obtain credentials for a device.
+func (s *Server) verifyRenewal(tenantID string, req *api.RegistrationRequest) error {
+	if req.Credential == "" {
+		return apierror.BadRequest("renewing a registration requires the device's previous credential")
+	}
+	if s.deviceVerifier == nil {
+		return apierror.Unavailable("registration renewal is not available")
+	}
+	claims, _, err := s.deviceVerifier.VerifyIgnoringExpiry(req.Credential, time.Now())
+	if err != nil || claims.TenantID != tenantID || claims.DeviceID != req.DeviceID {
+		return apierror.New(http.StatusForbidden, apierror.CodeForbidden,
+			"credential was not issued to this device")
+	}
+	return nil
+}
+
+// applyRegistration copies the reported identity and capabilities onto dev.
+func applyRegistration(dev *device.Device, req *api.RegistrationRequest) error {
+	for k, v := range req.Tags {
+		if err := dev.AddTag(k, v); err != nil {
+			return err
+		}
+	}
+	if err := dev.UpdateDiscoveryInfo(device.DiscoveryAutomatic, req.NetworkInfo); err != nil {
+		return err
+	}
+	if req.Capabilities.Offline != nil {
+		if err := dev.UpdateOfflineCapabilities(req.Capabilities.Offline); err != nil {
+			return err
+		}
+	}
+	dev.SecureBootEnabled = req.Capabilities.SecureBootEnabled
+	dev.SecurityVersion = req.Capabilities.SecurityVersion
+	return nil
+}
+
+// issueDeviceCredentials signs a bearer credential bound to the device.
+func (s *Server) issueDeviceCredentials(dev *device.Device) (*api.Credentials, error) {
+	ttl := defaultDeviceCredentialTTL
+	if s.cfg != nil && s.cfg.Auth.DeviceCredentialTTL > 0 {
+		ttl = s.cfg.Auth.DeviceCredentialTTL
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(ttl).Truncate(time.Second)
+	claims := auth.Claims{
+		Subject:   dev.ID,
+		TenantID:  dev.TenantID,
+		DeviceID:  dev.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	}
+	// Device credentials pass through the same verifier as external
+	// tokens, so they carry any configured issuer and audience.
+	if s.cfg != nil {
+		claims.Issuer = s.cfg.Auth.TokenIssuer
+		claims.Audience = s.cfg.Auth.TokenAudience
+	}
+
+	token, err := s.deviceSigner.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &api.Credentials{
+		Type:      "Bearer",
+		Token:     token,
+		ExpiresAt: expires,
+	}, nil
+}
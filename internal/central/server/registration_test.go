@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+)
+
+// testEnrollmentKey is the enrollment-scoped API key of tenant-a
+const testEnrollmentKey = "enroll-tenant-a"
+
+// newTestRegistrationServer returns a server that issues device credentials
+// and trusts them, as initAuth arranges in production.
+func newTestRegistrationServer(t *testing.T) *Server {
+	s := newTestStage1Server(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	s.deviceSigner, err = auth.NewEd25519Signer(deviceSigningKeyID, priv)
+	require.NoError(t, err)
+
+	verifier, err := auth.NewTokenVerifier([]auth.TokenKey{s.deviceSigner.VerificationKey()})
+	require.NoError(t, err)
+	s.deviceVerifier = verifier
+	keys, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "ops", TenantID: "tenant-a", Subject: "ops-team", SHA256: auth.HashAPIKey(testAPIKey("tenant-a"))},
+		{ID: "enroll", TenantID: "tenant-a", Subject: "enrollment", SHA256: auth.HashAPIKey(testEnrollmentKey),
+			Scope: auth.ScopeEnrollment},
+	})
+	require.NoError(t, err)
+	s.auth = auth.NewAuthenticator(keys, verifier)
+	return s
+}
+
+func TestRegistrationHandshake(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	enroll, err := client.New(ts.URL, client.WithAPIKey(testEnrollmentKey))
+	require.NoError(t, err)
+
+	resp, err := enroll.Register(context.Background(), &api.RegistrationRequest{
+		Name: "pi-7",
+		Tags: map[string]string{"site": "plant-3"},
+		NetworkInfo: &device.NetworkInfo{
+			Hostname:  "pi-7",
+			IPAddress: "10.0.0.7",
+			Port:      9090,
+		},
+		Capabilities: api.Capabilities{AgentVersion: "1.2.3", Stage: 1, SecurityVersion: "2024.1"},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.DeviceID)
+	assert.Equal(t, "tenant-a", resp.TenantID)
+	assert.Equal(t, "pi-7", resp.Name)
+	assert.Equal(t, "Bearer", resp.Credentials.Type)
+	assert.True(t, resp.Credentials.ExpiresAt.After(time.Now()))
+
+	// The device is stored with the reported details.
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	stored, err := s.device.Get(ctx, "tenant-a", resp.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusOnline, stored.Status)
+	assert.Equal(t, "plant-3", stored.Tags["site"])
+	assert.Equal(t, "10.0.0.7", stored.NetworkInfo.IPAddress)
+	assert.Equal(t, "2024.1", stored.SecurityVersion)
+
+	// The issued credential authenticates the device for later calls.
+	req, err := http.NewRequest(http.MethodGet, ts.URL+api.PathDevices+"/"+resp.DeviceID, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+resp.Credentials.Token)
+	httpResp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode)
+
+	// A device credential cannot enroll further devices.
+	asDevice, err := client.New(ts.URL, client.WithCredential(resp.Credentials.Token))
+	require.NoError(t, err)
+	_, err = asDevice.Register(context.Background(), &api.RegistrationRequest{Name: "pi-8"})
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusForbidden, apiErr.Status)
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	h := s.routes()
+
+	tests := []struct {
+		name       string
+		tenantID   string
+		method     string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "missing credentials",
+			method:     http.MethodPost,
+			body:       `{"name":"pi"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   auth.ErrCodeMissingCredentials,
+		},
+		{
+			name:       "missing name",
+			tenantID:   "tenant-a",
+			method:     http.MethodPost,
+			body:       `{"capabilities":{}}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   device.ErrCodeInvalidDevice,
+		},
+		{
+			name:       "unknown field",
+			tenantID:   "tenant-a",
+			method:     http.MethodPost,
+			body:       `{"name":"pi","tenant_id":"tenant-b"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeBadRequest,
+		},
+		{
+			name:       "method not allowed",
+			tenantID:   "tenant-a",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantCode:   apierror.CodeMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doDeviceRequest(t, h, tt.tenantID, tt.method, api.PathRegistrations, tt.body)
+			require.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+			assert.Equal(t, tt.wantCode, decodeErrorCode(t, rec))
+		})
+	}
+}
+
+func TestDeviceCredentialsOnDeviceRoutes(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	enroll, err := client.New(ts.URL, client.WithAPIKey(testEnrollmentKey))
+	require.NoError(t, err)
+	self, err := enroll.Register(context.Background(), &api.RegistrationRequest{Name: "pi-1"})
+	require.NoError(t, err)
+	other, err := enroll.Register(context.Background(), &api.RegistrationRequest{Name: "pi-2"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"list devices", http.MethodGet, api.PathDevices, "", http.StatusForbidden},
+		{"create device", http.MethodPost, api.PathDevices, `{"name":"pi-9"}`, http.StatusForbidden},
+		{"get own device", http.MethodGet, api.PathDevices + "/" + self.DeviceID, "", http.StatusOK},
+		{"get other device", http.MethodGet, api.PathDevices + "/" + other.DeviceID, "", http.StatusForbidden},
+		{"update own device", http.MethodPut, api.PathDevices + "/" + self.DeviceID, `{"name":"pi-1","status":"offline"}`, http.StatusForbidden},
+		{"update other device", http.MethodPut, api.PathDevices + "/" + other.DeviceID, `{"name":"pi-2","status":"offline"}`, http.StatusForbidden},
+		{"delete own device", http.MethodDelete, api.PathDevices + "/" + self.DeviceID, "", http.StatusForbidden},
+		{"delete other device", http.MethodDelete, api.PathDevices + "/" + other.DeviceID, "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+self.Credentials.Token)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+
+	// Nothing a device attempted took effect.
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	devices, err := s.device.List(ctx, device.ListOptions{TenantID: "tenant-a"})
+	require.NoError(t, err)
+	assert.Len(t, devices, 2)
+	for _, d := range devices {
+		assert.Equal(t, device.StatusOnline, d.Status)
+	}
+}
+
+func TestRegistrationRenewal(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	enroll, err := client.New(ts.URL, client.WithAPIKey(testEnrollmentKey))
+	require.NoError(t, err)
+	first, err := enroll.Register(context.Background(), &api.RegistrationRequest{Name: "pi-7"})
+	require.NoError(t, err)
+
+	// Renewing keeps the device and its operator-assigned name.
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	dev, err := s.device.Get(ctx, "tenant-a", first.DeviceID)
+	require.NoError(t, err)
+	dev.Name = "pi-7-line-2"
+	dev.SetStatus(device.StatusOffline)
+	require.NoError(t, s.device.Update(ctx, dev))
+
+	// The device presents the credential it was issued, which has expired.
+	expired, err := s.deviceSigner.Sign(auth.Claims{
+		Subject:   first.DeviceID,
+		TenantID:  "tenant-a",
+		DeviceID:  first.DeviceID,
+		ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+	renewed, err := enroll.Register(context.Background(), &api.RegistrationRequest{
+		DeviceID:   first.DeviceID,
+		Credential: expired,
+		Name:       "pi-7",
+		Tags:       map[string]string{"site": "plant-3"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, first.DeviceID, renewed.DeviceID)
+	assert.Equal(t, "pi-7-line-2", renewed.Name)
+	assert.NotEmpty(t, renewed.Credentials.Token)
+
+	devices, err := s.device.List(ctx, device.ListOptions{TenantID: "tenant-a"})
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, device.StatusOnline, devices[0].Status)
+	assert.Equal(t, "plant-3", devices[0].Tags["site"])
+
+	// A device the control plane does not know cannot be renewed.
+	missing, err := s.issueDeviceCredentials(&device.Device{ID: "missing", TenantID: "tenant-a"})
+	require.NoError(t, err)
+	_, err = enroll.Register(context.Background(), &api.RegistrationRequest{
+		DeviceID:   "missing",
+		Credential: missing.Token,
+		Name:       "pi-8",
+	})
+	var apiErr *apierror.Error
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+}
+
+func TestRegistrationRenewalRequiresDeviceCredential(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	enroll, err := client.New(ts.URL, client.WithAPIKey(testEnrollmentKey))
+	require.NoError(t, err)
+	victim, err := enroll.Register(context.Background(), &api.RegistrationRequest{Name: "pi-1"})
+	require.NoError(t, err)
+	other, err := enroll.Register(context.Background(), &api.RegistrationRequest{Name: "pi-2"})
+	require.NoError(t, err)
+
+	foreignSigner, err := auth.NewHMACSigner(deviceSigningKeyID, []byte(strings.Repeat("f", 32)))
+	require.NoError(t, err)
+	forged, err := foreignSigner.Sign(auth.Claims{
+		Subject:   victim.DeviceID,
+		TenantID:  "tenant-a",
+		DeviceID:  victim.DeviceID,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		credential string
+		wantStatus int
+	}{
+		{"no credential", "", http.StatusBadRequest},
+		{"another device's credential", other.Credentials.Token, http.StatusForbidden},
+		{"credential not issued by the server", forged, http.StatusForbidden},
+		{"malformed credential", "not-a-token", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := enroll.Register(context.Background(), &api.RegistrationRequest{
+				DeviceID:   victim.DeviceID,
+				Credential: tt.credential,
+				Name:       "pi-1",
+			})
+			var apiErr *apierror.Error
+			require.True(t, errors.As(err, &apiErr), "got %v", err)
+			assert.Equal(t, tt.wantStatus, apiErr.Status)
+		})
+	}
+}
+
+func TestEnrollmentKeyOnlyRegisters(t *testing.T) {
+	s := newTestRegistrationServer(t)
+	h := s.routes()
+
+	for _, path := range []string{api.PathDevices, api.PathGroups, api.PathRollouts} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(auth.APIKeyHeader, testEnrollmentKey)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code, path)
+		assert.Equal(t, apierror.CodeForbidden, decodeErrorCode(t, rec), path)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, api.PathRegistrations, strings.NewReader(`{"name":"pi-1"}`))
+	req.Header.Set(auth.APIKeyHeader, testEnrollmentKey)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
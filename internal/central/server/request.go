@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+)
+
+// maxRequestBytes bounds the size of JSON request bodies.
+const maxRequestBytes = 1 << 20
+
+// decodeJSON parses a JSON request body into v, rejecting unknown fields and
+// trailing data so that typos surface as client errors. Oversized bodies are
+// reported with their own error so they map to 413.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return err
+		}
+		return apierror.BadRequest("invalid request body: " + err.Error())
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return apierror.BadRequest("invalid request body: unexpected data after JSON object")
+	}
+	return nil
+}
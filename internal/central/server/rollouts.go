@@ -0,0 +1,223 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	"go.uber.org/zap"
+)
+
+// handleRollouts handles configuration rollout list and start requests.
+// - GET: List the tenant's rollouts, optionally filtered by ?group= and
+// ?status=
+// - POST: Start a rollout (body is an api.RolloutRequest)
+func (s *Server) handleRollouts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if s.rollouts == nil {
+			apierror.Write(w, apierror.Unavailable("configuration rollouts are not available"))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			q := r.URL.Query()
+			rollouts, err := s.rollouts.List(ctx, tenantID, rollout.ListOptions{
+				GroupID: q.Get("group"),
+				Status:  rollout.Status(q.Get("status")),
+			})
+			if err != nil {
+				apierror.Write(w, err)
+				return
+			}
+			if err := apierror.WriteJSON(w, http.StatusOK, api.RolloutListResponse{
+				Rollouts: rollouts,
+			}); err != nil {
+				s.logger.Error("failed to encode rollout list response",
+					zap.Error(err),
+					zap.String("tenant_id", tenantID))
+			}
+
+		case http.MethodPost:
+			s.startRollout(w, r, tenantID)
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			apierror.Write(w, apierror.MethodNotAllowed())
+		}
+	}
+}
+
+// startRollout starts a rollout of an existing version, or of a new version
+// created from the configuration in the request.
+func (s *Server) startRollout(w http.ResponseWriter, r *http.Request, tenantID string) {
+	ctx := r.Context()
+
+	if err := requireOperator(r); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	var req api.RolloutRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+	switch {
+	case req.Group == "":
+		apierror.Write(w, apierror.BadRequest("group is required"))
+		return
+	case req.Template == "":
+		apierror.Write(w, apierror.BadRequest("template is required"))
+		return
+	case len(req.Config) > 0 && req.Version != 0:
+		apierror.Write(w, apierror.BadRequest("version and config cannot both be set"))
+		return
+	case len(req.Config) == 0 && req.Version == 0:
+		apierror.Write(w, apierror.BadRequest("version or config is required"))
+		return
+	}
+	if err := req.Strategy.Validate(); err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	template, err := s.config.FindTemplate(ctx, tenantID, req.Template)
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	versionNumber := req.Version
+	if len(req.Config) > 0 {
+		version, err := s.config.CreateValidatedVersion(ctx, tenantID, template.ID, req.Config, createdBy(r))
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		versionNumber = version.Number
+	}
+
+	started, err := s.rollouts.Start(ctx, tenantID, req.Group, template.ID, versionNumber, req.Strategy, createdBy(r))
+	if err != nil {
+		apierror.Write(w, err)
+		return
+	}
+
+	w.Header().Set("Location", api.RolloutPath(started.ID))
+	if err := apierror.WriteJSON(w, http.StatusCreated, api.RolloutResponse{
+		Rollout: started,
+	}); err != nil {
+		s.logger.Error("failed to encode rollout creation response",
+			zap.Error(err),
+			zap.String("rollout_id", started.ID),
+			zap.String("tenant_id", tenantID))
+	}
+}
+
+// handleRolloutByID handles requests for a single rollout.
+// - GET /{id}: Retrieve rollout progress
+// - POST /{id}/pause: Stop starting new waves
+// - POST /{id}/resume: Continue a paused or halted rollout
+// - POST /{id}/abort: Stop the rollout for good (optional body is an
+// api.RolloutAbortRequest)
+func (s *Server) handleRolloutByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		rolloutID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, api.PathRollouts+"/"), "/")
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if rolloutID == "" {
+			apierror.Write(w, apierror.NotFound("not found"))
+			return
+		}
+		if s.rollouts == nil {
+			apierror.Write(w, apierror.Unavailable("configuration rollouts are not available"))
+			return
+		}
+
+		if action == "" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				apierror.Write(w, apierror.MethodNotAllowed())
+				return
+			}
+			found, err := s.rollouts.Get(ctx, tenantID, rolloutID)
+			if err != nil {
+				apierror.Write(w, err)
+				return
+			}
+			s.writeRollout(w, found, tenantID)
+			return
+		}
+
+		if action != "pause" && action != "resume" && action != "abort" {
+			apierror.Write(w, apierror.NotFound("not found"))
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			apierror.Write(w, apierror.MethodNotAllowed())
+			return
+		}
+		if err := requireOperator(r); err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		var updated *rollout.Rollout
+		switch action {
+		case "pause":
+			updated, err = s.rollouts.Pause(ctx, tenantID, rolloutID)
+		case "resume":
+			updated, err = s.rollouts.Resume(ctx, tenantID, rolloutID)
+		case "abort":
+			var req api.RolloutAbortRequest
+			if r.ContentLength != 0 {
+				if err := decodeJSON(w, r, &req); err != nil {
+					apierror.Write(w, err)
+					return
+				}
+			}
+			updated, err = s.rollouts.Abort(ctx, tenantID, rolloutID, req.Reason)
+		}
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
+		s.logger.Info("configuration rollout updated",
+			zap.String("action", action),
+			zap.String("rollout_id", rolloutID),
+			zap.String("tenant_id", tenantID),
+			zap.String("status", string(updated.Status)),
+			zap.String("by", createdBy(r)))
+		s.writeRollout(w, updated, tenantID)
+	}
+}
+
+// writeRollout responds with a single rollout
+func (s *Server) writeRollout(w http.ResponseWriter, r *rollout.Rollout, tenantID string) {
+	if err := apierror.WriteJSON(w, http.StatusOK, api.RolloutResponse{
+		Rollout: r,
+	}); err != nil {
+		s.logger.Error("failed to encode rollout response",
+			zap.Error(err),
+			zap.String("rollout_id", r.ID),
+			zap.String("tenant_id", tenantID))
+	}
+}
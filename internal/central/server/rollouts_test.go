@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	configmemory "github.com/wrale/wrale-fleet/internal/fleet/config/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	devicememory "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	groupmemory "github.com/wrale/wrale-fleet/internal/fleet/group/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	rolloutmemory "github.com/wrale/wrale-fleet/internal/fleet/rollout/store/memory"
+)
+
+func TestRolloutAPI(t *testing.T) {
+	s := newTestStage1Server(t)
+	deviceStore := devicememory.New()
+	s.device = device.NewService(deviceStore, s.logger)
+	s.group = group.NewService(groupmemory.New(deviceStore), deviceStore, s.logger)
+	s.config = config.NewService(configmemory.New(), s.logger)
+	s.rollouts = rollout.NewService(rolloutmemory.New(), s.config, s.group, s.logger)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	edge, err := s.group.Create(ctx, "tenant-a", "edge", group.TypeStatic)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		dev, err := s.device.Register(ctx, "tenant-a", fmt.Sprintf("edge-%d", i))
+		require.NoError(t, err)
+		require.NoError(t, s.group.AddDevice(ctx, "tenant-a", edge.ID, dev))
+	}
+
+	rec := doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPost, api.PathTemplates,
+		`{"name":"edge","schema":{"type":"object","required":["port"],"properties":{"port":{"type":"integer"}}}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	c, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+
+	// Invalid requests are rejected before anything is stored.
+	var apiErr *apierror.Error
+	_, err = c.StartRollout(context.Background(), &api.RolloutRequest{
+		Group: edge.ID, Template: "edge", Version: 1, Config: json.RawMessage(`{"port":1}`),
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+
+	_, err = c.StartRollout(context.Background(), &api.RolloutRequest{
+		Group: edge.ID, Template: "edge", Config: json.RawMessage(`{"port":"80"}`),
+	})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Status)
+
+	started, err := c.StartRollout(context.Background(), &api.RolloutRequest{
+		Group:    edge.ID,
+		Template: "edge",
+		Config:   json.RawMessage(`{"port":80}`),
+		Strategy: rollout.Strategy{CanaryPercent: 30, BatchSize: 2},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusRunning, started.Status)
+	assert.Equal(t, 1, started.Version)
+	require.Len(t, started.Waves, 2)
+	assert.Len(t, started.Waves[0].Targets, 1)
+	assert.Equal(t, rollout.WaveStatusRunning, started.Waves[0].Status)
+
+	// The canary device is handed the configuration.
+	canary := started.Waves[0].Targets[0]
+	pending, err := s.config.PendingDeployment(ctx, "tenant-a", canary.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, canary.DeploymentID, pending.ID)
+
+	paused, err := c.PauseRollout(context.Background(), started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusPaused, paused.Status)
+
+	_, err = c.PauseRollout(context.Background(), started.ID)
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusConflict, apiErr.Status)
+
+	listed, err := c.ListRollouts(context.Background(), edge.ID, "")
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, started.ID, listed[0].ID)
+
+	listed, err = c.ListRollouts(context.Background(), "", rollout.StatusRunning)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+
+	resumed, err := c.ResumeRollout(context.Background(), started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusRunning, resumed.Status)
+
+	aborted, err := c.AbortRollout(context.Background(), started.ID, "wrong port")
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusAborted, aborted.Status)
+	assert.Equal(t, "wrong port", aborted.Reason)
+	assert.Equal(t, config.DeploymentStatusFailed, aborted.Waves[0].Targets[0].Status)
+
+	got, err := c.GetRollout(context.Background(), started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusAborted, got.Status)
+
+	// Rollouts are scoped to their tenant.
+	other, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-b")))
+	require.NoError(t, err)
+	_, err = other.GetRollout(context.Background(), started.ID)
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	rec = doDeviceRequest(t, s.routes(), "tenant-a", http.MethodPost, api.RolloutPath(started.ID)+"/restart", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
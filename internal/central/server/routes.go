@@ -33,13 +33,43 @@ func (s *Server) routes() http.Handler {
 func (s *Server) registerStage1Routes(mux *http.ServeMux) {
 	s.logger.Info("registering Stage 1 API routes")
 
-	// Device management endpoints
-	mux.HandleFunc("/api/v1/devices", s.handleDevices())
-	mux.HandleFunc("/api/v1/devices/", s.handleDeviceByID())
+	// Device management endpoints require an authenticated tenant
+	mux.Handle("/api/v1/devices", s.authenticate(s.handleDevices()))
+	mux.Handle("/api/v1/devices/", s.authenticate(s.handleDeviceByID()))
+
+	// Configuration templates, validated against by device config changes
+	mux.Handle("/api/v1/templates", s.authenticate(s.handleTemplates()))
+	mux.Handle("/api/v1/templates/", s.authenticate(s.handleTemplateByID()))
+
+	// Staged configuration rollouts to device groups
+	mux.Handle("/api/v1/rollouts", s.authenticate(s.handleRollouts()))
+	mux.Handle("/api/v1/rollouts/", s.authenticate(s.handleRolloutByID()))
+
+	// Device groups and their hierarchy
+	mux.Handle("/api/v1/groups", s.authenticate(s.handleGroups()))
+	mux.Handle("/api/v1/groups/tree", s.authenticate(s.handleGroupTree()))
+	mux.Handle("/api/v1/groups/repair", s.authenticate(s.handleGroupRepair()))
+	mux.Handle("/api/v1/groups/", s.authenticate(s.handleGroupByID()))
+
+	// Devices whose configuration drifted from their deployments
+	mux.Handle("/api/v1/drift", s.authenticate(s.handleDrift()))
+
+	// Device agent registration, authenticated by an enrollment credential
+	mux.Handle("/api/v1/registrations", s.authenticate(s.handleRegistrations()))
 
 	s.logger.Debug("Stage 1 routes registered",
 		zap.Strings("endpoints", []string{
 			"/api/v1/devices",
 			"/api/v1/devices/",
+			"/api/v1/templates",
+			"/api/v1/templates/",
+			"/api/v1/rollouts",
+			"/api/v1/rollouts/",
+			"/api/v1/groups",
+			"/api/v1/groups/tree",
+			"/api/v1/groups/repair",
+			"/api/v1/groups/",
+			"/api/v1/drift",
+			"/api/v1/registrations",
 		}))
 }
@@ -9,8 +9,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
 	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/drift"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
 	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
 	"go.uber.org/zap"
 )
 
@@ -23,6 +29,14 @@ const (
 	// readHeaderTimeout defines the amount of time allowed to read
 	// request headers. This helps prevent Slowloris DoS attacks.
 	readHeaderTimeout = 10 * time.Second
+	// defaultDeviceCredentialTTL is the lifetime of device credentials
+	// issued at registration when none is configured.
+	defaultDeviceCredentialTTL = 365 * 24 * time.Hour
+	// deviceSigningKeyFile holds the key signing device credentials,
+	// relative to the data directory.
+	deviceSigningKeyFile = "device-signing.key"
+	// deviceSigningKeyID identifies device credentials in token headers.
+	deviceSigningKeyID = "wfcentral-device"
 )
 
 // Stage represents the server's operational stage/capability level
@@ -42,11 +56,22 @@ type Server struct {
 	logger         *zap.Logger
 	stage          Stage
 	device         *device.Service
+	group          *group.Service
+	config         *config.Service
+	logging        *logging.Service
+	auth           *auth.Authenticator
+	deviceSigner   *auth.TokenSigner
+	deviceVerifier *auth.TokenVerifier
+	closers        []namedCloser
 	httpSrv        *http.Server
 	health         *health.Service
-	mgmtServer     *managementServer
+	reports        *health.ReportService
+	rollouts       *rollout.Service
+	drift          *drift.Detector
+	mgmtServer     *ManagementServer
 	baseCtx        context.Context
 	baseCancel     context.CancelFunc
+	loops          sync.WaitGroup
 	stopOnce       sync.Once
 	stopped        chan struct{}
 	readyChan      chan struct{}
@@ -87,11 +112,20 @@ func New(cfg *Config, logger *zap.Logger) (*Server, error) {
 	// Initialize server components in the correct order
 	if err := s.initialize(); err != nil {
 		cancel() // Clean up context if initialization fails
+		s.loops.Wait()
+		_ = s.closeStores()
 		return nil, fmt.Errorf("server initialization failed: %w", err)
 	}
 
 	// Create management server - now guaranteed to have valid config
-	s.mgmtServer = newManagementServer(s)
+	mgmtServer, err := newManagementServer(cfg.ManagementConfig, logger)
+	if err != nil {
+		cancel()
+		s.loops.Wait()
+		_ = s.closeStores()
+		return nil, fmt.Errorf("management server initialization failed: %w", err)
+	}
+	s.mgmtServer = mgmtServer
 
 	return s, nil
 }
@@ -155,10 +189,12 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 }
 
-// Stop performs a graceful server shutdown.
+// Stop performs a graceful server shutdown. Every step runs even if an
+// earlier one fails; the first error is returned.
 func (s *Server) Stop() error {
 	var err error
 	s.stopOnce.Do(func() {
+		defer close(s.stopped)
 		s.logger.Info("stopping central control plane server")
 
 		// Create shutdown context with timeout
@@ -174,27 +210,30 @@ func (s *Server) Stop() error {
 		if e := s.mgmtServer.stop(ctx); e != nil {
 			s.logger.Error("failed to stop management server", zap.Error(e))
 			err = fmt.Errorf("management server shutdown: %w", e)
-			return
 		}
 
 		// Shutdown main HTTP server
 		if s.httpSrv != nil {
 			if e := s.httpSrv.Shutdown(ctx); e != nil {
-				err = fmt.Errorf("http server shutdown: %w", e)
-				return
+				s.logger.Error("failed to shut down http server", zap.Error(e))
+				if err == nil {
+					err = fmt.Errorf("http server shutdown: %w", e)
+				}
 			}
 		}
 
-		// Clean up device service
-		if e := s.cleanupDeviceService(ctx); e != nil {
-			err = fmt.Errorf("device service cleanup: %w", e)
-			return
+		// Stop the background loops before closing the stores they use
+		s.baseCancel()
+		s.loops.Wait()
+
+		// Release store resources such as database locks
+		if e := s.closeStores(); e != nil && err == nil {
+			err = fmt.Errorf("store cleanup: %w", e)
 		}
 
-		// Cancel base context and close channels
-		s.baseCancel()
-		close(s.stopped)
-		s.logger.Info("server stopped successfully")
+		if err == nil {
+			s.logger.Info("server stopped successfully")
+		}
 	})
 
 	return err
@@ -205,6 +244,11 @@ func (s *Server) Ready() <-chan struct{} {
 	return s.readyChan
 }
 
+// Stopped returns a channel that will be closed once the server has shut down.
+func (s *Server) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
 // Status returns the current health status of the server and its components.
 func (s *Server) Status(ctx context.Context) (*health.HealthResponse, error) {
 	return s.health.CheckHealth(ctx, health.WithTimeout(healthCheckTimeout))
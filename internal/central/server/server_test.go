@@ -0,0 +1,45 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestStopClosesStoresAfterLoops(t *testing.T) {
+	s, err := New(&Config{
+		Port:             "0",
+		DataDir:          t.TempDir(),
+		ManagementConfig: &ManagementConfig{Port: "0"},
+	}, zaptest.NewLogger(t))
+	require.NoError(t, err)
+
+	// A store failing to close must not keep Stop from finishing, and the
+	// background loops must be gone by the time stores close.
+	var loopsStopped bool
+	s.closers = append(s.closers, namedCloser{name: "probe", closer: closerFunc(func() error {
+		loopsStopped = s.baseCtx.Err() != nil
+		return errors.New("close failed")
+	})})
+
+	err = s.Stop()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "close failed")
+	assert.True(t, loopsStopped, "stores closed before the base context was canceled")
+
+	select {
+	case <-s.Stopped():
+	case <-time.After(time.Second):
+		t.Fatal("Stopped did not fire after a failed store close")
+	}
+	assert.NoError(t, s.Stop(), "a second Stop is a no-op")
+}
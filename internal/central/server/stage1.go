@@ -4,8 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
 	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/expr"
 	"go.uber.org/zap"
 )
 
@@ -22,6 +27,65 @@ func (s *Server) initStage1() error {
 	return nil
 }
 
+// deviceRequest is the body accepted when creating or updating a device.
+// Fields not listed here (identity, tenant, timestamps, config history) are
+// owned by the server and cannot be set through the API.
+type deviceRequest struct {
+	Name                string                      `json:"name"`
+	Status              device.Status               `json:"status,omitempty"`
+	Tags                map[string]string           `json:"tags,omitempty"`
+	Config              json.RawMessage             `json:"config,omitempty"`
+	NetworkInfo         *device.NetworkInfo         `json:"network_info,omitempty"`
+	OfflineCapabilities *device.OfflineCapabilities `json:"offline_capabilities,omitempty"`
+}
+
+// decodeDeviceRequest parses and checks a device create or update body.
+func decodeDeviceRequest(w http.ResponseWriter, r *http.Request) (*deviceRequest, error) {
+	var req deviceRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return nil, err
+	}
+
+	if req.Status != "" && !validDeviceStatus(req.Status) {
+		return nil, apierror.BadRequest(fmt.Sprintf("invalid device status %q", req.Status))
+	}
+
+	return &req, nil
+}
+
+// validDeviceStatus reports whether status is a known device status.
+func validDeviceStatus(status device.Status) bool {
+	switch status {
+	case device.StatusUnknown, device.StatusOnline, device.StatusOffline,
+		device.StatusError, device.StatusMaintenance:
+		return true
+	default:
+		return false
+	}
+}
+
+// apply copies the request fields onto dev. Tags are replaced wholesale so
+// that a PUT can remove tags as well as add them.
+func (req *deviceRequest) apply(dev *device.Device, appliedBy string) error {
+	dev.Name = req.Name
+	if req.Status != "" {
+		dev.SetStatus(req.Status)
+	}
+	dev.Tags = make(map[string]string, len(req.Tags))
+	for k, v := range req.Tags {
+		dev.Tags[k] = v
+	}
+	dev.NetworkInfo = req.NetworkInfo
+	dev.OfflineCapabilities = req.OfflineCapabilities
+	if len(req.Config) > 0 {
+		if err := dev.SetConfig(req.Config, appliedBy); err != nil {
+			return err
+		}
+	}
+	dev.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
 // handleDevices handles device list and creation requests.
 // This implements the collection endpoints for device management:
 // - GET: List devices with optional filtering
@@ -36,7 +100,14 @@ func (s *Server) handleDevices() http.HandlerFunc {
 			s.logger.Error("failed to get tenant from context",
 				zap.Error(err),
 				zap.String("remote_addr", r.RemoteAddr))
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+
+		// Device credentials are limited to their own device; the
+		// collection is for operators.
+		if err := requireOperator(r); err != nil {
+			apierror.Write(w, err)
 			return
 		}
 
@@ -46,29 +117,61 @@ func (s *Server) handleDevices() http.HandlerFunc {
 				zap.String("tenant_id", tenantID),
 				zap.String("remote_addr", r.RemoteAddr))
 
+			filter, err := api.ParseDeviceFilter(r.URL.Query())
+			if err != nil {
+				apierror.Write(w, apierror.BadRequest(err.Error()))
+				return
+			}
+			if filter.Status != "" && !validDeviceStatus(filter.Status) {
+				apierror.Write(w, apierror.BadRequest(fmt.Sprintf("invalid device status %q", filter.Status)))
+				return
+			}
+			var match *expr.Program
+			if filter.Match != "" {
+				if match, err = expr.Compile(filter.Match); err != nil {
+					apierror.Write(w, err)
+					return
+				}
+			}
+
 			devices, err := s.device.List(ctx, device.ListOptions{
 				TenantID: tenantID,
+				Status:   filter.Status,
+				Tags:     filter.Tags,
 			})
 			if err != nil {
 				s.logger.Error("failed to list devices",
 					zap.Error(err),
 					zap.String("tenant_id", tenantID),
 					zap.String("remote_addr", r.RemoteAddr))
-				http.Error(w, "internal server error", http.StatusInternalServerError)
+				apierror.Write(w, err)
 				return
 			}
 
+			// Names and expressions are not indexed by the store, so
+			// filter them here.
+			if filter.Name != "" || match != nil {
+				matched := devices[:0]
+				for _, dev := range devices {
+					if filter.Name != "" && dev.Name != filter.Name {
+						continue
+					}
+					if match != nil && !match.Match(dev) {
+						continue
+					}
+					matched = append(matched, dev)
+				}
+				devices = matched
+			}
+
 			// Return devices as JSON response
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(map[string]interface{}{
-				"devices": devices,
+			if err := apierror.WriteJSON(w, http.StatusOK, api.DeviceListResponse{
+				Devices: devices,
 			}); err != nil {
 				s.logger.Error("failed to encode device list response",
 					zap.Error(err),
 					zap.String("tenant_id", tenantID),
 					zap.String("remote_addr", r.RemoteAddr))
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-				return
 			}
 
 		case http.MethodPost:
@@ -76,16 +179,50 @@ func (s *Server) handleDevices() http.HandlerFunc {
 				zap.String("tenant_id", tenantID),
 				zap.String("remote_addr", r.RemoteAddr))
 
-			// TODO: Parse device creation request
-			// TODO: Validate device data
-			// TODO: Create device in store
-			http.Error(w, "not implemented", http.StatusNotImplemented)
+			req, err := decodeDeviceRequest(w, r)
+			if err != nil {
+				apierror.Write(w, err)
+				return
+			}
+
+			// Build and validate the full device, then store it in one
+			// step so that a failure never leaves a partial device behind.
+			dev := device.New(tenantID, req.Name)
+			if err := req.apply(dev, tenantID); err != nil {
+				apierror.Write(w, err)
+				return
+			}
+			if err := dev.Validate(); err != nil {
+				apierror.Write(w, err)
+				return
+			}
+
+			if err := s.device.Create(ctx, dev); err != nil {
+				s.logger.Error("failed to register device",
+					zap.Error(err),
+					zap.String("tenant_id", tenantID),
+					zap.String("remote_addr", r.RemoteAddr))
+				apierror.Write(w, err)
+				return
+			}
+
+			w.Header().Set("Location", "/api/v1/devices/"+dev.ID)
+			if err := apierror.WriteJSON(w, http.StatusCreated, api.DeviceResponse{
+				Device: dev,
+			}); err != nil {
+				s.logger.Error("failed to encode device creation response",
+					zap.Error(err),
+					zap.String("device_id", dev.ID),
+					zap.String("tenant_id", tenantID),
+					zap.String("remote_addr", r.RemoteAddr))
+			}
 
 		default:
 			s.logger.Warn("invalid method for devices endpoint",
 				zap.String("method", r.Method),
 				zap.String("remote_addr", r.RemoteAddr))
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			w.Header().Set("Allow", "GET, POST")
+			apierror.Write(w, apierror.MethodNotAllowed())
 		}
 	}
 }
@@ -95,10 +232,14 @@ func (s *Server) handleDevices() http.HandlerFunc {
 // - GET: Retrieve device details
 // - PUT: Update device configuration
 // - DELETE: Remove device from management
+//
+// Devices are looked up in the caller's tenant only, so a device of another
+// tenant answers 404 like an unknown one rather than 403. This is deliberate:
+// a 403 would tell callers that the ID exists in some other tenant.
 func (s *Server) handleDeviceByID() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		deviceID := r.URL.Path[len("/api/v1/devices/"):]
+		deviceID, subresource, hasSubresource := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/devices/"), "/")
 
 		// Extract tenant ID from context
 		tenantID, err := device.TenantFromContext(ctx)
@@ -107,7 +248,16 @@ func (s *Server) handleDeviceByID() http.HandlerFunc {
 				zap.Error(err),
 				zap.String("device_id", deviceID),
 				zap.String("remote_addr", r.RemoteAddr))
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+
+		if deviceID == "" {
+			apierror.Write(w, apierror.NotFound("not found"))
+			return
+		}
+		if hasSubresource {
+			s.serveDeviceSubresource(w, r, tenantID, deviceID, subresource)
 			return
 		}
 
@@ -117,6 +267,17 @@ func (s *Server) handleDeviceByID() http.HandlerFunc {
 			zap.String("method", r.Method),
 			zap.String("remote_addr", r.RemoteAddr))
 
+		// A device may read its own record; changes are for operators.
+		if r.Method == http.MethodGet {
+			err = authorizeDevice(r, deviceID)
+		} else {
+			err = requireOperator(r)
+		}
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+
 		switch r.Method {
 		case http.MethodGet:
 			dev, err := s.device.Get(ctx, tenantID, deviceID)
@@ -126,33 +287,87 @@ func (s *Server) handleDeviceByID() http.HandlerFunc {
 					zap.String("device_id", deviceID),
 					zap.String("tenant_id", tenantID),
 					zap.String("remote_addr", r.RemoteAddr))
-				http.Error(w, "internal server error", http.StatusInternalServerError)
+				apierror.Write(w, err)
 				return
 			}
 
 			// Return device as JSON response
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(map[string]interface{}{
-				"device": dev,
+			if err := apierror.WriteJSON(w, http.StatusOK, api.DeviceResponse{
+				Device: dev,
 			}); err != nil {
 				s.logger.Error("failed to encode device response",
 					zap.Error(err),
 					zap.String("device_id", deviceID),
 					zap.String("tenant_id", tenantID),
 					zap.String("remote_addr", r.RemoteAddr))
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-				return
 			}
 
 		case http.MethodPut:
-			// TODO: Parse device update request
-			// TODO: Validate updated device data
-			// TODO: Update device in store
-			http.Error(w, "not implemented", http.StatusNotImplemented)
+			req, err := decodeDeviceRequest(w, r)
+			if err != nil {
+				apierror.Write(w, err)
+				return
+			}
+
+			dev, err := s.device.Get(ctx, tenantID, deviceID)
+			if err != nil {
+				s.logger.Error("failed to get device for update",
+					zap.Error(err),
+					zap.String("device_id", deviceID),
+					zap.String("tenant_id", tenantID),
+					zap.String("remote_addr", r.RemoteAddr))
+				apierror.Write(w, err)
+				return
+			}
+
+			if err := req.apply(dev, tenantID); err != nil {
+				apierror.Write(w, err)
+				return
+			}
+			if err := dev.Validate(); err != nil {
+				apierror.Write(w, err)
+				return
+			}
+
+			if err := s.device.Update(ctx, dev); err != nil {
+				s.logger.Error("failed to update device",
+					zap.Error(err),
+					zap.String("device_id", deviceID),
+					zap.String("tenant_id", tenantID),
+					zap.String("remote_addr", r.RemoteAddr))
+				apierror.Write(w, err)
+				return
+			}
+
+			if err := apierror.WriteJSON(w, http.StatusOK, api.DeviceResponse{
+				Device: dev,
+			}); err != nil {
+				s.logger.Error("failed to encode device update response",
+					zap.Error(err),
+					zap.String("device_id", deviceID),
+					zap.String("tenant_id", tenantID),
+					zap.String("remote_addr", r.RemoteAddr))
+			}
 
 		case http.MethodDelete:
-			// TODO: Implement device deletion with proper cleanup
-			http.Error(w, "not implemented", http.StatusNotImplemented)
+			if err := s.device.Delete(ctx, tenantID, deviceID); err != nil {
+				s.logger.Error("failed to delete device",
+					zap.Error(err),
+					zap.String("device_id", deviceID),
+					zap.String("tenant_id", tenantID),
+					zap.String("remote_addr", r.RemoteAddr))
+				apierror.Write(w, err)
+				return
+			}
+			if s.reports != nil {
+				if err := s.reports.Forget(ctx, tenantID, deviceID); err != nil {
+					s.logger.Warn("failed to delete device health reports",
+						zap.Error(err),
+						zap.String("device_id", deviceID),
+						zap.String("tenant_id", tenantID))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
 
 		default:
 			s.logger.Warn("invalid method for device-specific endpoint",
@@ -160,7 +375,8 @@ func (s *Server) handleDeviceByID() http.HandlerFunc {
 				zap.String("device_id", deviceID),
 				zap.String("tenant_id", tenantID),
 				zap.String("remote_addr", r.RemoteAddr))
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			apierror.Write(w, apierror.MethodNotAllowed())
 		}
 	}
 }
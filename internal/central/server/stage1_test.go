@@ -0,0 +1,333 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/central/auth"
+	"github.com/wrale/wrale-fleet/internal/central/client"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/expr"
+	devicememory "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	devicetesting "github.com/wrale/wrale-fleet/internal/fleet/device/testing"
+	"go.uber.org/zap/zaptest"
+)
+
+// testAPIKey returns the API key the test server accepts for a tenant.
+func testAPIKey(tenantID string) string {
+	return "key-" + tenantID
+}
+
+func newTestStage1Server(t *testing.T) *Server {
+	var keys []auth.APIKey
+	for _, tenantID := range []string{"tenant-a", "tenant-b"} {
+		keys = append(keys, auth.APIKey{
+			ID:       tenantID + "-key",
+			TenantID: tenantID,
+			SHA256:   auth.HashAPIKey(testAPIKey(tenantID)),
+		})
+	}
+	keyStore, err := auth.NewAPIKeyStore(keys)
+	require.NoError(t, err)
+
+	return &Server{
+		logger: zaptest.NewLogger(t),
+		stage:  Stage1,
+		device: devicetesting.NewTestService(t),
+		auth:   auth.NewAuthenticator(keyStore, nil),
+	}
+}
+
+func doDeviceRequest(t *testing.T, h http.Handler, tenantID, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if tenantID != "" {
+		req.Header.Set(auth.APIKeyHeader, testAPIKey(tenantID))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeErrorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp apierror.Response
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, rec.Code, resp.Error.Status)
+	return resp.Error.Code
+}
+
+// failingUpdateStore refuses every update
+type failingUpdateStore struct {
+	device.Store
+}
+
+func (failingUpdateStore) Update(context.Context, *device.Device) error {
+	return errors.New("update refused")
+}
+
+func TestDeviceCreateStoresDeviceInOneWrite(t *testing.T) {
+	s := newTestStage1Server(t)
+	store := failingUpdateStore{Store: devicememory.New()}
+	s.device = device.NewService(store, s.logger)
+	h := s.routes()
+
+	rec := doDeviceRequest(t, h, "tenant-a", http.MethodPost, "/api/v1/devices",
+		`{"name":"pi-1","status":"online","tags":{"site":"plant-3"},"config":{"interval":30}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	devices, err := store.List(context.Background(), device.ListOptions{TenantID: "tenant-a"})
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, device.StatusOnline, devices[0].Status)
+	assert.Equal(t, "plant-3", devices[0].Tags["site"])
+	assert.JSONEq(t, `{"interval":30}`, string(devices[0].Config))
+}
+
+func TestDeviceCRUDHandlers(t *testing.T) {
+	s := newTestStage1Server(t)
+	h := s.routes()
+
+	// Create
+	rec := doDeviceRequest(t, h, "tenant-a", http.MethodPost, "/api/v1/devices",
+		`{"name":"pi-1","tags":{"site":"plant-3"},"network_info":{"hostname":"pi-1","port":22}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created struct {
+		Device device.Device `json:"device"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	assert.Equal(t, "pi-1", created.Device.Name)
+	assert.Equal(t, "tenant-a", created.Device.TenantID)
+	assert.Equal(t, "plant-3", created.Device.Tags["site"])
+	assert.Equal(t, "/api/v1/devices/"+created.Device.ID, rec.Header().Get("Location"))
+
+	path := "/api/v1/devices/" + created.Device.ID
+
+	// Update
+	rec = doDeviceRequest(t, h, "tenant-a", http.MethodPut, path,
+		`{"name":"pi-1-renamed","status":"online"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	stored, err := s.device.Get(device.ContextWithTenant(context.Background(), "tenant-a"), "tenant-a", created.Device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pi-1-renamed", stored.Name)
+	assert.Equal(t, device.StatusOnline, stored.Status)
+	assert.Empty(t, stored.Tags)
+
+	// Delete
+	rec = doDeviceRequest(t, h, "tenant-a", http.MethodDelete, path, "")
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	rec = doDeviceRequest(t, h, "tenant-a", http.MethodGet, path, "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, device.ErrCodeDeviceNotFound, decodeErrorCode(t, rec))
+}
+
+func TestDeviceHandlerErrors(t *testing.T) {
+	s := newTestStage1Server(t)
+	h := s.routes()
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	existing, err := s.device.Register(ctx, "tenant-a", "existing")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		tenantID   string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "missing tenant",
+			method:     http.MethodGet,
+			path:       "/api/v1/devices",
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   auth.ErrCodeMissingCredentials,
+		},
+		{
+			name:       "malformed body",
+			tenantID:   "tenant-a",
+			method:     http.MethodPost,
+			path:       "/api/v1/devices",
+			body:       `{"name":`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeBadRequest,
+		},
+		{
+			name:       "unknown field",
+			tenantID:   "tenant-a",
+			method:     http.MethodPost,
+			path:       "/api/v1/devices",
+			body:       `{"name":"pi","tenant_id":"tenant-b"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeBadRequest,
+		},
+		{
+			name:       "missing name",
+			tenantID:   "tenant-a",
+			method:     http.MethodPost,
+			path:       "/api/v1/devices",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   device.ErrCodeInvalidDevice,
+		},
+		{
+			name:       "invalid port",
+			tenantID:   "tenant-a",
+			method:     http.MethodPut,
+			path:       "/api/v1/devices/" + existing.ID,
+			body:       `{"name":"existing","network_info":{"port":70000}}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   device.ErrCodeInvalidDevice,
+		},
+		{
+			name:       "update unknown device",
+			tenantID:   "tenant-a",
+			method:     http.MethodPut,
+			path:       "/api/v1/devices/does-not-exist",
+			body:       `{"name":"ghost"}`,
+			wantStatus: http.StatusNotFound,
+			wantCode:   device.ErrCodeDeviceNotFound,
+		},
+		{
+			name:       "other tenant cannot read",
+			tenantID:   "tenant-b",
+			method:     http.MethodGet,
+			path:       "/api/v1/devices/" + existing.ID,
+			wantStatus: http.StatusNotFound,
+			wantCode:   device.ErrCodeDeviceNotFound,
+		},
+		{
+			name:       "other tenant cannot update",
+			tenantID:   "tenant-b",
+			method:     http.MethodPut,
+			path:       "/api/v1/devices/" + existing.ID,
+			body:       `{"name":"taken"}`,
+			wantStatus: http.StatusNotFound,
+			wantCode:   device.ErrCodeDeviceNotFound,
+		},
+		{
+			name:       "other tenant cannot delete",
+			tenantID:   "tenant-b",
+			method:     http.MethodDelete,
+			path:       "/api/v1/devices/" + existing.ID,
+			wantStatus: http.StatusNotFound,
+			wantCode:   device.ErrCodeDeviceNotFound,
+		},
+		{
+			name:       "method not allowed",
+			tenantID:   "tenant-a",
+			method:     http.MethodPatch,
+			path:       "/api/v1/devices/" + existing.ID,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantCode:   apierror.CodeMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doDeviceRequest(t, h, tt.tenantID, tt.method, tt.path, tt.body)
+			require.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantCode, decodeErrorCode(t, rec))
+		})
+	}
+
+	// Requests from the other tenant left the device untouched
+	stored, err := s.device.Get(ctx, "tenant-a", existing.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "existing", stored.Name)
+}
+
+func TestDeviceListAndLookup(t *testing.T) {
+	s := newTestStage1Server(t)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	edge, err := s.device.Register(ctx, "tenant-a", "edge-1")
+	require.NoError(t, err)
+	edge.Tags = map[string]string{"site": "lab"}
+	require.NoError(t, s.device.Update(ctx, edge))
+	require.NoError(t, s.device.UpdateStatus(ctx, "tenant-a", edge.ID, device.StatusOnline))
+	_, err = s.device.Register(ctx, "tenant-a", "dup")
+	require.NoError(t, err)
+	_, err = s.device.Register(ctx, "tenant-a", "dup")
+	require.NoError(t, err)
+
+	c, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-a")))
+	require.NoError(t, err)
+
+	all, err := c.ListDevices(context.Background(), api.DeviceFilter{})
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	filtered, err := c.ListDevices(context.Background(), api.DeviceFilter{
+		Status: device.StatusOnline,
+		Tags:   map[string]string{"site": "lab"},
+	})
+	require.NoError(t, err)
+	require.Len(t, filtered, 1)
+	assert.Equal(t, edge.ID, filtered[0].ID)
+
+	matched, err := c.ListDevices(context.Background(), api.DeviceFilter{
+		Match: `tags.site == "lab" || name =~ "^d"`,
+	})
+	require.NoError(t, err)
+	assert.Len(t, matched, 3)
+	matched, err = c.ListDevices(context.Background(), api.DeviceFilter{
+		Name:  "dup",
+		Match: `status in ["online", "maintenance"]`,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, matched)
+
+	// Devices resolve by ID or by unique name.
+	byID, err := c.FindDevice(context.Background(), edge.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "edge-1", byID.Name)
+	byName, err := c.FindDevice(context.Background(), "edge-1")
+	require.NoError(t, err)
+	assert.Equal(t, edge.ID, byName.ID)
+
+	var apiErr *apierror.Error
+	_, err = c.FindDevice(context.Background(), "dup")
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusConflict, apiErr.Status)
+
+	_, err = c.FindDevice(context.Background(), "missing")
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	// Other tenants' devices are invisible.
+	other, err := client.New(ts.URL, client.WithAPIKey(testAPIKey("tenant-b")))
+	require.NoError(t, err)
+	_, err = other.FindDevice(context.Background(), "edge-1")
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	// Unknown statuses and malformed tag filters are rejected.
+	rec := doDeviceRequest(t, s.routes(), "tenant-a", http.MethodGet, "/api/v1/devices?status=sideways", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = doDeviceRequest(t, s.routes(), "tenant-a", http.MethodGet, "/api/v1/devices?tag=site", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// So are expressions that do not compile.
+	_, err = c.ListDevices(context.Background(), api.DeviceFilter{Match: `site == "lab"`})
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+	assert.Equal(t, expr.ErrCodeUnknownField, apiErr.Code)
+}
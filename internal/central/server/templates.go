@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/wrale/wrale-fleet/internal/apierror"
+	"github.com/wrale/wrale-fleet/internal/central/api"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+// handleTemplates handles configuration template list and creation requests.
+// - GET: List the tenant's templates
+// - POST: Create a template (body is an api.TemplateRequest)
+func (s *Server) handleTemplates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if s.config == nil {
+			apierror.Write(w, apierror.Unavailable("configuration management is not available"))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			templates, err := s.config.ListTemplates(ctx, tenantID)
+			if err != nil {
+				apierror.Write(w, err)
+				return
+			}
+			if err := apierror.WriteJSON(w, http.StatusOK, api.TemplateListResponse{
+				Templates: templates,
+			}); err != nil {
+				s.logger.Error("failed to encode template list response",
+					zap.Error(err),
+					zap.String("tenant_id", tenantID))
+			}
+
+		case http.MethodPost:
+			if err := requireOperator(r); err != nil {
+				apierror.Write(w, err)
+				return
+			}
+
+			var req api.TemplateRequest
+			if err := decodeJSON(w, r, &req); err != nil {
+				apierror.Write(w, err)
+				return
+			}
+
+			template := config.NewTemplate(tenantID, req.Name, req.Schema)
+			template.Description = req.Description
+			template.Default = req.Default
+			for _, v := range req.Variables {
+				if err := template.AddVariable(v); err != nil {
+					apierror.Write(w, err)
+					return
+				}
+			}
+
+			created, err := s.config.AddTemplate(ctx, template)
+			if err != nil {
+				apierror.Write(w, err)
+				return
+			}
+
+			w.Header().Set("Location", api.TemplatePath(created.ID))
+			if err := apierror.WriteJSON(w, http.StatusCreated, api.TemplateResponse{
+				Template: created,
+			}); err != nil {
+				s.logger.Error("failed to encode template creation response",
+					zap.Error(err),
+					zap.String("template_id", created.ID),
+					zap.String("tenant_id", tenantID))
+			}
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			apierror.Write(w, apierror.MethodNotAllowed())
+		}
+	}
+}
+
+// handleTemplateByID returns a single configuration template, found by ID
+// or name.
+// - GET: Retrieve template details
+func (s *Server) handleTemplateByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		templateID := strings.TrimPrefix(r.URL.Path, api.PathTemplates+"/")
+
+		tenantID, err := device.TenantFromContext(ctx)
+		if err != nil {
+			apierror.Write(w, apierror.Unauthenticated("unauthorized"))
+			return
+		}
+		if templateID == "" || strings.Contains(templateID, "/") {
+			apierror.Write(w, apierror.NotFound("not found"))
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			apierror.Write(w, apierror.MethodNotAllowed())
+			return
+		}
+		if s.config == nil {
+			apierror.Write(w, apierror.Unavailable("configuration management is not available"))
+			return
+		}
+
+		template, err := s.config.FindTemplate(ctx, tenantID, templateID)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if err := apierror.WriteJSON(w, http.StatusOK, api.TemplateResponse{
+			Template: template,
+		}); err != nil {
+			s.logger.Error("failed to encode template response",
+				zap.Error(err),
+				zap.String("template_id", template.ID),
+				zap.String("tenant_id", tenantID))
+		}
+	}
+}
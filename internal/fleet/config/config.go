@@ -19,6 +19,14 @@ const (
 	ValidationStatusRollback ValidationStatus = "rollback"
 )
 
+// Deployment statuses
+const (
+	DeploymentStatusPending    = "pending"
+	DeploymentStatusCompleted  = "completed"
+	DeploymentStatusFailed     = "failed"
+	DeploymentStatusSuperseded = "superseded" // Replaced by a newer deployment before it was applied
+)
+
 // Template represents a reusable configuration template
 type Template struct {
 	ID          string          `json:"id"`
@@ -44,14 +52,15 @@ type Variable struct {
 
 // Version represents a specific configuration version
 type Version struct {
-	Number      int              `json:"version"`
-	Config      json.RawMessage  `json:"config"`
-	Hash        string           `json:"hash"`
-	TemplateID  string           `json:"template_id,omitempty"`
-	CreatedAt   time.Time        `json:"created_at"`
-	CreatedBy   string           `json:"created_by"`
-	ValidatedAt *time.Time       `json:"validated_at,omitempty"`
-	Status      ValidationStatus `json:"status"`
+	Number           int              `json:"version"`
+	Config           json.RawMessage  `json:"config"`
+	Hash             string           `json:"hash"`
+	TemplateID       string           `json:"template_id,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	CreatedBy        string           `json:"created_by"`
+	ValidatedAt      *time.Time       `json:"validated_at,omitempty"`
+	Status           ValidationStatus `json:"status"`
+	ValidationErrors []Violation      `json:"validation_errors,omitempty"` // Reasons for ValidationStatusInvalid
 }
 
 // Deployment tracks configuration deployment to devices
@@ -64,6 +73,8 @@ type Deployment struct {
 	DeployedAt    time.Time  `json:"deployed_at"`
 	CompletedAt   *time.Time `json:"completed_at,omitempty"`
 	Error         string     `json:"error,omitempty"`
+	RolledBack    bool       `json:"rolled_back,omitempty"`   // Applied, then undone by the device
+	SupersededBy  string     `json:"superseded_by,omitempty"` // Deployment that replaced this one
 }
 
 // NewTemplate creates a new configuration template
@@ -99,7 +110,7 @@ func NewDeployment(tenantID, deviceID string, version *Version) *Deployment {
 		TenantID:      tenantID,
 		ConfigVersion: version,
 		DeviceID:      deviceID,
-		Status:        "pending",
+		Status:        DeploymentStatusPending,
 		DeployedAt:    time.Now().UTC(),
 	}
 }
@@ -118,6 +129,12 @@ func (t *Template) Validate() error {
 	if len(t.Schema) == 0 {
 		return NewError("validate template", ErrInvalidTemplate, "template schema cannot be empty")
 	}
+	if _, err := t.compileSchema("validate template"); err != nil {
+		return err
+	}
+	if _, err := t.compileVariables("validate template"); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -150,17 +167,33 @@ func (t *Template) SetDefault(config json.RawMessage) error {
 func (d *Deployment) Complete() {
 	now := time.Now().UTC()
 	d.CompletedAt = &now
-	d.Status = "completed"
+	d.Status = DeploymentStatusCompleted
 }
 
 // Fail marks a deployment as failed with an error
 func (d *Deployment) Fail(err string) {
 	now := time.Now().UTC()
 	d.CompletedAt = &now
-	d.Status = "failed"
+	d.Status = DeploymentStatusFailed
 	d.Error = err
 }
 
+// RollBack marks a deployment as failed because the device applied it,
+// found it unhealthy and returned to its previous configuration
+func (d *Deployment) RollBack(reason string) {
+	d.Fail(reason)
+	d.RolledBack = true
+}
+
+// Supersede marks a pending deployment as superseded by the deployment
+// byID, which replaces it
+func (d *Deployment) Supersede(byID string) {
+	now := time.Now().UTC()
+	d.CompletedAt = &now
+	d.Status = DeploymentStatusSuperseded
+	d.SupersededBy = byID
+}
+
 // calculateHash generates a SHA-256 hash of the configuration
 func calculateHash(config json.RawMessage) string {
 	hash := sha256.Sum256(config)
@@ -74,6 +74,27 @@ func TestTemplate_Validate(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "invalid schema",
+			template: &Template{
+				ID:       "template-1",
+				TenantID: "tenant-1",
+				Name:     "test-template",
+				Schema:   json.RawMessage(`{"type": "float"}`),
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid variable validation",
+			template: &Template{
+				ID:        "template-1",
+				TenantID:  "tenant-1",
+				Name:      "test-template",
+				Schema:    json.RawMessage(`{"type": "object"}`),
+				Variables: []Variable{{Name: "port", Type: "integer", Validation: `{"minimum": "one"}`}},
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -193,3 +214,65 @@ func Test_calculateHash(t *testing.T) {
 	hash3 := calculateHash(differentConfig)
 	assert.NotEqual(t, hash1, hash3)
 }
+
+func TestTemplate_ValidateConfig(t *testing.T) {
+	template := NewTemplate("tenant-1", "test-template", json.RawMessage(`{
+		"type": "object",
+		"required": ["name", "port"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string"},
+			"port": {"type": "integer"},
+			"mode": {"enum": ["active", "standby"]},
+			"servers": {"type": "array", "items": {"type": "string"}}
+		}
+	}`))
+
+	violations, err := template.ValidateConfig(json.RawMessage(`{"name":"edge","port":8080,"servers":["a"]}`))
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+
+	violations, err = template.ValidateConfig(json.RawMessage(
+		`{"port":80.5,"mode":"off","servers":["a",1],"extra":true}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Violation{
+		{Path: "", Keyword: "required", Message: `missing properties: 'name'`},
+		{Path: "", Keyword: "additionalProperties", Message: "additionalProperties 'extra' not allowed"},
+		{Path: "/mode", Keyword: "enum", Message: `value must be one of "active", "standby"`},
+		{Path: "/port", Keyword: "type", Message: "expected integer, but got number"},
+		{Path: "/servers/1", Keyword: "type", Message: "expected string, but got number"},
+	}, violations)
+
+	violations, err = template.ValidateConfig(json.RawMessage(`not json`))
+	require.NoError(t, err)
+	assert.Len(t, violations, 1)
+
+	template.Schema = json.RawMessage(`[]`)
+	_, err = template.ValidateConfig(json.RawMessage(`{}`))
+	assert.Error(t, err)
+}
+
+func TestTemplate_ValidateConfigVariables(t *testing.T) {
+	template := NewTemplate("tenant-1", "test-template", json.RawMessage(`{"type": "object"}`))
+	require.NoError(t, template.AddVariable(Variable{Name: "network.port", Type: "integer", Required: true,
+		Validation: `{"minimum": 1, "maximum": 65535}`}))
+	require.NoError(t, template.AddVariable(Variable{Name: "hostname", Type: "string",
+		Validation: `{"format": "hostname"}`}))
+
+	violations, err := template.ValidateConfig(json.RawMessage(`{"network":{"port":8080},"hostname":"edge-1"}`))
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+
+	violations, err = template.ValidateConfig(json.RawMessage(`{"hostname":"-bad-"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Violation{
+		{Path: "/hostname", Keyword: "format", Message: "'-bad-' is not valid 'hostname'"},
+		{Path: "/network/port", Keyword: "required", Message: `missing required variable "network.port"`},
+	}, violations)
+
+	violations, err = template.ValidateConfig(json.RawMessage(`{"network":{"port":70000}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Violation{
+		{Path: "/network/port", Keyword: "maximum", Message: "must be <= 65535 but found 70000"},
+	}, violations)
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Delivery returns the configuration in the form it is delivered to
+// devices, without insignificant whitespace, and the hash of those bytes.
+// A device acknowledges a deployment with the hash of what it applied, so
+// the two hashes must match for the deployment to complete.
+func (v *Version) Delivery() (json.RawMessage, string, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, v.Config); err != nil {
+		return nil, "", &Error{Op: "deliver version", Code: ErrInvalidVersion, Message: "configuration is not valid JSON", Err: err}
+	}
+	delivered := json.RawMessage(buf.Bytes())
+	return delivered, calculateHash(delivered), nil
+}
+
+// PendingDeployment returns the deployment a device should apply next: its
+// most recent pending deployment. Older pending deployments are superseded
+// when a new one is created, so there is at most one.
+func (s *Service) PendingDeployment(ctx context.Context, tenantID, deviceID string) (*Deployment, error) {
+	deployments, err := s.store.ListDeployments(ctx, ListOptions{
+		TenantID: tenantID,
+		DeviceID: deviceID,
+		Status:   DeploymentStatusPending,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(deployments) == 0 {
+		return nil, NewError("pending deployment", ErrDeploymentNotFound, "device has no pending deployment")
+	}
+	return deployments[len(deployments)-1], nil
+}
+
+// AcknowledgeDeployment records a device's report on a deployment. An
+// empty errMsg means the device applied the configuration and hash is the
+// hash of what it now runs: the deployment completes when the hash matches
+// the delivered configuration and fails otherwise. A non-empty errMsg fails
+// the deployment with that message.
+//
+// Acknowledging a finished deployment again with the same outcome succeeds
+// without changes, so agents can safely retry.
+func (s *Service) AcknowledgeDeployment(ctx context.Context, tenantID, deviceID, deploymentID, hash, errMsg string) (*Deployment, error) {
+	return s.acknowledge(ctx, tenantID, deviceID, deploymentID, hash, errMsg, false)
+}
+
+// RollBackDeployment records that a device applied a deployment, found it
+// unhealthy and returned to its previous configuration. The deployment
+// fails with the device's reason and is flagged as rolled back. The
+// version is left alone: one device's health checks say nothing about how
+// the configuration behaves elsewhere, and rollouts already stop on their
+// failure threshold.
+func (s *Service) RollBackDeployment(ctx context.Context, tenantID, deviceID, deploymentID, reason string) (*Deployment, error) {
+	if reason == "" {
+		reason = "configuration rolled back by device"
+	}
+	return s.acknowledge(ctx, tenantID, deviceID, deploymentID, "", reason, true)
+}
+
+// acknowledge records a device's report on a deployment, failing it as
+// rolled back when rolledBack is set
+func (s *Service) acknowledge(ctx context.Context, tenantID, deviceID, deploymentID, hash, errMsg string, rolledBack bool) (*Deployment, error) {
+	const op = "acknowledge deployment"
+
+	deployment, err := s.store.GetDeployment(ctx, tenantID, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	if deployment.DeviceID != deviceID {
+		return nil, NewError(op, ErrDeploymentNotFound, "deployment not found")
+	}
+	if deployment.ConfigVersion == nil {
+		return nil, NewError(op, ErrInvalidDeployment, "deployment has no configuration version")
+	}
+	_, want, err := deployment.ConfigVersion.Delivery()
+	if err != nil {
+		return nil, err
+	}
+	applied := errMsg == "" && hash == want
+
+	switch deployment.Status {
+	case DeploymentStatusPending:
+	case DeploymentStatusCompleted:
+		if applied {
+			return deployment, nil
+		}
+		return nil, NewError(op, ErrInvalidDeployment, "deployment has already completed").
+			WithField("status", deployment.Status)
+	case DeploymentStatusSuperseded:
+		if errMsg != "" {
+			return deployment, nil
+		}
+		return nil, NewError(op, ErrInvalidDeployment, "deployment was superseded by deployment "+deployment.SupersededBy).
+			WithField("status", deployment.Status)
+	default:
+		if errMsg != "" {
+			return deployment, nil
+		}
+		return nil, NewError(op, ErrInvalidDeployment, "deployment has already "+deployment.Status).
+			WithField("status", deployment.Status)
+	}
+
+	switch {
+	case rolledBack:
+		deployment.RollBack(errMsg)
+	case errMsg != "":
+		deployment.Fail(errMsg)
+	case !applied:
+		deployment.Fail(fmt.Sprintf("device reported configuration hash %q, expected %q", hash, want))
+	default:
+		deployment.Complete()
+	}
+
+	if err := s.store.UpdateDeployment(ctx, deployment); err != nil {
+		return nil, err
+	}
+
+	if deployment.Status == DeploymentStatusCompleted {
+		s.logger.Info("device applied configuration deployment",
+			zap.String("deployment_id", deploymentID),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID),
+			zap.Int("version", deployment.ConfigVersion.Number),
+		)
+	} else {
+		s.logger.Error("device failed configuration deployment",
+			zap.String("deployment_id", deploymentID),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID),
+			zap.String("error", deployment.Error),
+			zap.Bool("rolled_back", deployment.RolledBack),
+		)
+	}
+
+	return deployment, nil
+}
+
+// supersedePending marks the device's pending deployments other than
+// keepID as superseded by it.
+func (s *Service) supersedePending(ctx context.Context, tenantID, deviceID, keepID string) error {
+	deployments, err := s.store.ListDeployments(ctx, ListOptions{
+		TenantID: tenantID,
+		DeviceID: deviceID,
+		Status:   DeploymentStatusPending,
+	})
+	if err != nil {
+		return err
+	}
+	for _, d := range deployments {
+		if d.ID == keepID {
+			continue
+		}
+		d.Supersede(keepID)
+		if err := s.store.UpdateDeployment(ctx, d); err != nil {
+			return err
+		}
+		s.logger.Info("superseded pending configuration deployment",
+			zap.String("deployment_id", d.ID),
+			zap.String("superseded_by", keepID),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID),
+		)
+	}
+	return nil
+}
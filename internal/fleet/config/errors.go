@@ -6,14 +6,15 @@ import "fmt"
 type ErrorCode string
 
 const (
-	ErrInvalidTemplate    ErrorCode = "invalid_template"
-	ErrInvalidVersion     ErrorCode = "invalid_version"
-	ErrInvalidDeployment  ErrorCode = "invalid_deployment"
-	ErrTemplateNotFound   ErrorCode = "template_not_found"
-	ErrVersionNotFound    ErrorCode = "version_not_found"
-	ErrDeploymentNotFound ErrorCode = "deployment_not_found"
-	ErrValidationFailed   ErrorCode = "validation_failed"
-	ErrStoreOperation     ErrorCode = "store_operation_failed"
+	ErrInvalidTemplate     ErrorCode = "invalid_template"
+	ErrInvalidVersion      ErrorCode = "invalid_version"
+	ErrInvalidDeployment   ErrorCode = "invalid_deployment"
+	ErrTemplateNotFound    ErrorCode = "template_not_found"
+	ErrVersionNotFound     ErrorCode = "version_not_found"
+	ErrDeploymentNotFound  ErrorCode = "deployment_not_found"
+	ErrValidationFailed    ErrorCode = "validation_failed"
+	ErrUnresolvedVariables ErrorCode = "unresolved_variables"
+	ErrStoreOperation      ErrorCode = "store_operation_failed"
 )
 
 // Error represents a configuration management error
@@ -22,6 +23,7 @@ type Error struct {
 	Code    ErrorCode // Machine-readable error code
 	Message string    // Human-readable error message
 	Err     error     // Underlying error if any
+	Fields  map[string]interface{}
 }
 
 // NewError creates a new configuration error
@@ -45,3 +47,12 @@ func (e *Error) Error() string {
 func (e *Error) Unwrap() error {
 	return e.Err
 }
+
+// WithField adds a field to the error
+func (e *Error) WithField(key string, value interface{}) *Error {
+	if e.Fields == nil {
+		e.Fields = make(map[string]interface{})
+	}
+	e.Fields[key] = value
+	return e
+}
@@ -0,0 +1,400 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config/schema"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+)
+
+// RenderValues are the inputs Template.Render merges into a concrete
+// configuration. Values are keyed by variable name; values for variables
+// the template does not declare are ignored, so one set of group values
+// can serve several templates.
+type RenderValues struct {
+	// Config is the configuration to render, the template Default when
+	// empty
+	Config json.RawMessage
+
+	// Groups holds the variable values set on the device's groups,
+	// outermost group first, so nested groups override their ancestors
+	Groups []map[string]interface{}
+
+	// Device holds per-device variable values, which override group values
+	Device map[string]interface{}
+
+	// Target is the device the configuration is rendered for. Its identity,
+	// tags and NetworkInfo are available to ${...} placeholders.
+	Target *device.Device
+}
+
+// UnresolvedVariable explains why part of a template could not be
+// rendered.
+type UnresolvedVariable struct {
+	// Name is the variable, "" for a placeholder in the template default
+	Name string `json:"name,omitempty"`
+
+	// Path is a JSON pointer to the value in the rendered configuration
+	Path string `json:"path"`
+
+	Reason string `json:"reason"`
+}
+
+// String renders the entry as "name: reason", falling back to the path.
+func (u UnresolvedVariable) String() string {
+	if u.Name != "" {
+		return u.Name + ": " + u.Reason
+	}
+	return u.Path + ": " + u.Reason
+}
+
+// Render produces a concrete configuration for a device. It starts from
+// values.Config, or the template Default when that is empty, and sets each
+// variable at the path named by its dot-separated name, taking the first
+// value found in the device values and the group values (innermost first).
+// A variable without such a value keeps whatever the document holds at its
+// path, falling back to the variable default; a required variable missing
+// from all of them is unresolved.
+//
+// Strings in the document and in variable values may reference
+// device facts with ${...} placeholders:
+//
+//	${device.id}, ${device.name}, ${device.tenant_id}
+//	${device.tags.<key>}
+//	${device.network.ip_address}, ${device.network.mac_address},
+//	${device.network.hostname}, ${device.network.port},
+//	${device.network.metadata.<key>}
+//
+// "$${" produces a literal "${". A value that is exactly one placeholder
+// is converted to the variable type when the fact parses as one, so a tag
+// "8080" can fill an integer variable. Every value must match its variable
+// Type.
+//
+// When anything cannot be resolved, Render returns an ErrUnresolvedVariables
+// error listing each problem in its "unresolved" field.
+func (t *Template) Render(values RenderValues) (json.RawMessage, error) {
+	const op = "render config"
+
+	rules, err := t.compileVariables(op)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &renderer{facts: deviceFacts(values.Target)}
+
+	base, code, what := t.Default, ErrInvalidTemplate, "template default"
+	if len(values.Config) > 0 {
+		base, code, what = values.Config, ErrInvalidVersion, "configuration"
+	}
+
+	doc := map[string]interface{}{}
+	if len(base) > 0 && string(base) != "null" {
+		decoded, err := schema.Decode(base)
+		if err != nil {
+			return nil, &Error{Op: op, Code: code, Message: what + " is not valid JSON", Err: err}
+		}
+		obj, ok := decoded.(map[string]interface{})
+		if !ok {
+			return nil, NewError(op, code, what+" must be a JSON object")
+		}
+		expanded, missing := r.expandValue(obj, "")
+		doc = expanded.(map[string]interface{})
+		for _, m := range missing {
+			r.unresolved = append(r.unresolved, UnresolvedVariable{Path: m.path, Reason: m.reason()})
+		}
+	}
+
+	for _, rule := range rules {
+		v := rule.variable
+		value, ok := lookupValue(values, v)
+		if !ok {
+			_, present := lookupPath(doc, rule.segments)
+			switch {
+			case present:
+				continue
+			case v.Default != nil:
+				value = v.Default
+			default:
+				if v.Required {
+					r.fail(rule, "no value provided")
+				}
+				continue
+			}
+		}
+
+		value, err := normalizeValue(value)
+		if err != nil {
+			r.fail(rule, fmt.Sprintf("value is not JSON-encodable: %v", err))
+			continue
+		}
+
+		isWhole := singlePlaceholder(value)
+		value, missing := r.expandValue(value, rule.pointer)
+		if len(missing) > 0 {
+			for _, m := range missing {
+				r.fail(rule, m.reason())
+			}
+			continue
+		}
+		if isWhole {
+			value = coerceFact(value, v.Type)
+		}
+
+		if rule.typ != nil {
+			if errs := rule.typ.Validate(value); len(errs) > 0 {
+				r.fail(rule, errs[0].Message)
+				continue
+			}
+		}
+
+		if conflict := setPath(doc, rule.segments, value); conflict != "" {
+			r.fail(rule, fmt.Sprintf("cannot set value: %s is not an object", pointerOrRoot(conflict)))
+		}
+	}
+
+	if len(r.unresolved) > 0 {
+		sort.SliceStable(r.unresolved, func(i, j int) bool {
+			return r.unresolved[i].Path < r.unresolved[j].Path
+		})
+		names := make([]string, len(r.unresolved))
+		for i, u := range r.unresolved {
+			names[i] = u.String()
+		}
+		return nil, NewError(op, ErrUnresolvedVariables,
+			fmt.Sprintf("%d template value(s) could not be resolved: %s", len(r.unresolved), strings.Join(names, "; "))).
+			WithField("unresolved", r.unresolved)
+	}
+
+	rendered, err := json.Marshal(doc)
+	if err != nil {
+		return nil, &Error{Op: op, Code: ErrInvalidTemplate, Message: "failed to encode rendered configuration", Err: err}
+	}
+	return rendered, nil
+}
+
+// renderer collects the state of a single Render call.
+type renderer struct {
+	facts      map[string]interface{}
+	unresolved []UnresolvedVariable
+}
+
+func (r *renderer) fail(rule variableRule, reason string) {
+	r.unresolved = append(r.unresolved, UnresolvedVariable{
+		Name:   rule.variable.Name,
+		Path:   rule.pointer,
+		Reason: reason,
+	})
+}
+
+// missingFact records a placeholder that referenced an unknown fact.
+type missingFact struct {
+	path string
+	fact string
+	err  string
+}
+
+func (m missingFact) reason() string {
+	if m.err != "" {
+		return m.err
+	}
+	return fmt.Sprintf("unknown device fact %q", m.fact)
+}
+
+// expandValue replaces placeholders in every string within v. path is the
+// JSON pointer of v, used to report missing facts.
+func (r *renderer) expandValue(v interface{}, path string) (interface{}, []missingFact) {
+	var missing []missingFact
+	var walk func(v interface{}, path string) interface{}
+	walk = func(v interface{}, path string) interface{} {
+		switch x := v.(type) {
+		case string:
+			expanded, facts, err := r.expandString(x)
+			if err != "" {
+				missing = append(missing, missingFact{path: path, err: err})
+			}
+			for _, f := range facts {
+				missing = append(missing, missingFact{path: path, fact: f})
+			}
+			return expanded
+		case []interface{}:
+			out := make([]interface{}, len(x))
+			for i, item := range x {
+				out[i] = walk(item, path+"/"+strconv.Itoa(i))
+			}
+			return out
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(x))
+			for k, item := range x {
+				out[k] = walk(item, path+"/"+escapePointer(k))
+			}
+			return out
+		default:
+			return v
+		}
+	}
+	return walk(v, path), missing
+}
+
+// expandString replaces ${...} placeholders in s with device facts. It
+// returns the names of unknown facts, or a syntax error message.
+func (r *renderer) expandString(s string) (string, []string, string) {
+	if !strings.Contains(s, "${") {
+		return s, nil, ""
+	}
+
+	var (
+		b       strings.Builder
+		missing []string
+	)
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			// "$${" escapes a literal "${"
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", nil, fmt.Sprintf("unterminated placeholder in %q", s[i:])
+		}
+		name := strings.TrimSpace(s[i+2 : i+end])
+		if fact, ok := r.facts[name]; ok {
+			fmt.Fprint(&b, fact)
+		} else {
+			missing = append(missing, name)
+		}
+		s = s[i+end+1:]
+	}
+	return b.String(), missing, ""
+}
+
+// singlePlaceholder reports whether v is a string holding exactly one
+// placeholder.
+func singlePlaceholder(v interface{}) bool {
+	s, ok := v.(string)
+	if !ok || !strings.HasPrefix(s, "${") || !strings.HasSuffix(s, "}") {
+		return false
+	}
+	return !strings.ContainsAny(s[2:len(s)-1], "${}")
+}
+
+// coerceFact converts an expanded single-placeholder string to the
+// variable type when it parses as that type, leaving it unchanged
+// otherwise so the type check reports the mismatch.
+func coerceFact(v interface{}, typ string) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	switch typ {
+	case "integer", "number", "boolean":
+		parsed, err := schema.Decode([]byte(s))
+		if err != nil {
+			return v
+		}
+		if _, isString := parsed.(string); isString {
+			return v
+		}
+		return parsed
+	default:
+		return v
+	}
+}
+
+// lookupValue returns the value set for a variable by the device or its
+// groups, by precedence.
+func lookupValue(values RenderValues, v Variable) (interface{}, bool) {
+	if value, ok := values.Device[v.Name]; ok {
+		return value, true
+	}
+	for i := len(values.Groups) - 1; i >= 0; i-- {
+		if value, ok := values.Groups[i][v.Name]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// normalizeValue converts a Go value into the decoded JSON form used for
+// validation, with numbers as json.Number.
+func normalizeValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return schema.Decode(data)
+}
+
+// setPath stores value at the object path, creating intermediate objects.
+// It returns the pointer of a non-object value in the way, or "".
+func setPath(doc map[string]interface{}, segments []string, value interface{}) string {
+	obj := doc
+	pointer := ""
+	for _, s := range segments[:len(segments)-1] {
+		pointer += "/" + escapePointer(s)
+		next, ok := obj[s]
+		if !ok {
+			child := map[string]interface{}{}
+			obj[s] = child
+			obj = child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return pointer
+		}
+		obj = child
+	}
+	obj[segments[len(segments)-1]] = value
+	return ""
+}
+
+// deviceFacts returns the facts a device exposes to placeholders.
+func deviceFacts(d *device.Device) map[string]interface{} {
+	facts := make(map[string]interface{})
+	if d == nil {
+		return facts
+	}
+
+	facts["device.id"] = d.ID
+	facts["device.name"] = d.Name
+	facts["device.tenant_id"] = d.TenantID
+	for k, v := range d.Tags {
+		facts["device.tags."+k] = v
+	}
+	if n := d.NetworkInfo; n != nil {
+		set := func(name, value string) {
+			if value != "" {
+				facts["device.network."+name] = value
+			}
+		}
+		set("ip_address", n.IPAddress)
+		set("mac_address", n.MACAddress)
+		set("hostname", n.Hostname)
+		if n.Port != 0 {
+			facts["device.network.port"] = n.Port
+		}
+		for k, v := range n.Metadata {
+			facts["device.network.metadata."+k] = v
+		}
+	}
+	return facts
+}
+
+func pointerOrRoot(ptr string) string {
+	if ptr == "" {
+		return "/"
+	}
+	return ptr
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+)
+
+func newRenderTemplate(t *testing.T) *Template {
+	t.Helper()
+	template := NewTemplate("tenant-1", "edge", json.RawMessage(`{"type": "object"}`))
+	require.NoError(t, template.SetDefault(json.RawMessage(
+		`{"log_level":"info","agent":{"name":"${device.name}","literal":"$${device.name}"}}`)))
+	for _, v := range []Variable{
+		{Name: "log_level", Type: "string"},
+		{Name: "network.port", Type: "integer", Default: 8080},
+		{Name: "network.address", Type: "string", Default: "${device.network.ip_address}"},
+		{Name: "site", Type: "string", Required: true},
+		{Name: "workers", Type: "integer", Required: true},
+	} {
+		require.NoError(t, template.AddVariable(v))
+	}
+	return template
+}
+
+func TestTemplate_Render(t *testing.T) {
+	template := newRenderTemplate(t)
+	target := device.New("tenant-1", "edge-1")
+	target.Tags = map[string]string{"site": "plant-a", "workers": "4"}
+	target.NetworkInfo = &device.NetworkInfo{IPAddress: "10.0.0.5"}
+
+	rendered, err := template.Render(RenderValues{
+		Groups: []map[string]interface{}{
+			{"log_level": "warn", "workers": 2, "unrelated": true},
+			{"log_level": "debug", "site": "${device.tags.site}"},
+		},
+		Device: map[string]interface{}{"workers": "${device.tags.workers}"},
+		Target: target,
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"log_level": "debug",
+		"agent": {"name": "edge-1", "literal": "${device.name}"},
+		"network": {"port": 8080, "address": "10.0.0.5"},
+		"site": "plant-a",
+		"workers": 4
+	}`, string(rendered))
+}
+
+func TestTemplate_RenderUnresolved(t *testing.T) {
+	template := newRenderTemplate(t)
+	target := device.New("tenant-1", "edge-1")
+
+	_, err := template.Render(RenderValues{
+		Device: map[string]interface{}{"log_level": 3},
+		Target: target,
+	})
+	require.Error(t, err)
+
+	var cfgErr *Error
+	require.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, ErrUnresolvedVariables, cfgErr.Code)
+	assert.Equal(t, []UnresolvedVariable{
+		{Name: "log_level", Path: "/log_level", Reason: "expected string, but got number"},
+		{Name: "network.address", Path: "/network/address", Reason: `unknown device fact "device.network.ip_address"`},
+		{Name: "site", Path: "/site", Reason: "no value provided"},
+		{Name: "workers", Path: "/workers", Reason: "no value provided"},
+	}, cfgErr.Fields["unresolved"])
+
+	// A placeholder fact that does not parse as the variable type is a type error
+	target.Tags = map[string]string{"workers": "many"}
+	_, err = template.Render(RenderValues{
+		Device: map[string]interface{}{"site": "a", "workers": "${device.tags.workers}", "network.address": "x"},
+		Target: target,
+	})
+	require.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, []UnresolvedVariable{
+		{Name: "workers", Path: "/workers", Reason: "expected integer, but got string"},
+	}, cfgErr.Fields["unresolved"])
+}
+
+func TestTemplate_RenderConfig(t *testing.T) {
+	template := newRenderTemplate(t)
+	target := device.New("tenant-1", "edge-1")
+
+	// Values in the configuration are kept over variable defaults but not
+	// over values set for the device or its groups.
+	rendered, err := template.Render(RenderValues{
+		Config: json.RawMessage(`{"log_level":"info","network":{"port":80,"address":"10.0.0.9"},"name":"${device.name}"}`),
+		Groups: []map[string]interface{}{{"log_level": "warn", "site": "plant-a"}},
+		Device: map[string]interface{}{"workers": 2},
+		Target: target,
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"log_level": "warn",
+		"network": {"port": 80, "address": "10.0.0.9"},
+		"name": "edge-1",
+		"site": "plant-a",
+		"workers": 2
+	}`, string(rendered))
+
+	_, err = template.Render(RenderValues{Config: json.RawMessage(`[]`), Target: target})
+	var cfgErr *Error
+	require.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, ErrInvalidVersion, cfgErr.Code)
+}
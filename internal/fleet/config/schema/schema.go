@@ -0,0 +1,250 @@
+// Package schema validates JSON documents against JSON Schema draft 2020-12
+// with github.com/santhosh-tekuri/jsonschema.
+//
+// Schemas are self-contained: $ref, $dynamicRef, $anchor and $defs resolve
+// within the schema document, and remote references are a compile error.
+// Formats are asserted, because a configuration that names a format is
+// expected to honour it; unknown formats are ignored. Regular expressions
+// use Go's RE2 syntax.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Draft is the dialect URI of the supported draft.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// defaultBase is the base URI schemas are compiled under. It only serves
+// to resolve relative references and never leaves the package.
+const defaultBase = "https://schema.invalid/root.json"
+
+// maxExponent bounds the decimal exponent of numbers accepted by Decode, so
+// a value such as 1e1000000000 cannot exhaust memory when it is compared
+// exactly.
+const maxExponent = 10000
+
+// Error is a single validation failure.
+type Error struct {
+	// InstanceLocation is a JSON pointer to the failing value, "" for the
+	// whole document
+	InstanceLocation string `json:"instance_location"`
+
+	// KeywordLocation is a JSON pointer to the failing keyword, following
+	// references, for example "/properties/port/$ref/minimum"
+	KeywordLocation string `json:"keyword_location"`
+
+	// Keyword is the keyword that failed, such as "minimum"
+	Keyword string `json:"keyword,omitempty"`
+
+	// Message describes the failure
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e Error) Error() string {
+	loc := e.InstanceLocation
+	if loc == "" {
+		loc = "/"
+	}
+	return loc + ": " + e.Message
+}
+
+// Schema is a compiled JSON Schema, safe for concurrent use.
+type Schema struct {
+	s *jsonschema.Schema
+}
+
+// Compile parses and compiles a JSON Schema document.
+func Compile(data []byte) (*Schema, error) {
+	doc, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	return CompileValue(doc)
+}
+
+// CompileValue compiles a schema already decoded with Decode.
+func CompileValue(doc interface{}) (*Schema, error) {
+	switch x := doc.(type) {
+	case bool:
+	case map[string]interface{}:
+		if dialect, ok := x["$schema"]; ok && dialect != Draft {
+			return nil, fmt.Errorf("unsupported dialect %v; only %s is supported", dialect, Draft)
+		}
+	default:
+		return nil, errors.New("schema must be an object or a boolean")
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("encoding schema: %w", err)
+	}
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = true
+	c.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("remote reference %s is not supported", url)
+	}
+	if err := c.AddResource(defaultBase, bytes.NewReader(data)); err != nil {
+		return nil, compileError(err)
+	}
+	s, err := c.Compile(defaultBase)
+	if err != nil {
+		return nil, compileError(err)
+	}
+	return &Schema{s: s}, nil
+}
+
+// Validate checks a document decoded with Decode and returns every
+// failure, ordered by instance location. A nil result means the document
+// is valid.
+func (s *Schema) Validate(doc interface{}) []Error {
+	err := s.s.Validate(doc)
+	if err == nil {
+		return nil
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		// Values of Go types JSON does not have fail the document as a
+		// whole.
+		return []Error{{Message: cleanMessage(err)}}
+	}
+
+	var errs []Error
+	collectLeaves(ve, &errs)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].InstanceLocation < errs[j].InstanceLocation
+	})
+	return errs
+}
+
+// ValidateJSON decodes and validates a JSON document. The error is only
+// set when data is not valid JSON.
+func (s *Schema) ValidateJSON(data []byte) ([]Error, error) {
+	doc, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.Validate(doc), nil
+}
+
+// Decode parses a JSON document keeping numbers as json.Number, the
+// representation Validate expects.
+func Decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	if err := checkNumbers(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// checkNumbers rejects numbers whose exponent exceeds maxExponent.
+func checkNumbers(v interface{}) error {
+	switch x := v.(type) {
+	case json.Number:
+		s := x.String()
+		if i := strings.IndexAny(s, "eE"); i >= 0 {
+			exp, ok := new(big.Int).SetString(strings.TrimPrefix(s[i+1:], "+"), 10)
+			if !ok || exp.CmpAbs(big.NewInt(maxExponent)) > 0 {
+				return fmt.Errorf("number %s is out of range", s)
+			}
+		}
+	case []interface{}:
+		for _, item := range x {
+			if err := checkNumbers(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, member := range x {
+			if err := checkNumbers(member); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectLeaves appends the failures at the leaves of a validation error
+// tree. Inner errors only summarize their causes, as in "allOf failed".
+func collectLeaves(ve *jsonschema.ValidationError, errs *[]Error) {
+	if len(ve.Causes) == 0 {
+		*errs = append(*errs, Error{
+			InstanceLocation: ve.InstanceLocation,
+			KeywordLocation:  ve.KeywordLocation,
+			Keyword:          keyword(ve.KeywordLocation),
+			Message:          ve.Message,
+		})
+		return
+	}
+	for _, cause := range ve.Causes {
+		collectLeaves(cause, errs)
+	}
+}
+
+// subschemaKeywords are the keywords whose value holds named or indexed
+// subschemas, with how many location segments name the subschema.
+var subschemaKeywords = map[string]int{
+	"$defs":             1,
+	"properties":        1,
+	"patternProperties": 1,
+	"dependentSchemas":  1,
+	"dependentRequired": 2,
+	"allOf":             1,
+	"anyOf":             1,
+	"oneOf":             1,
+	"prefixItems":       1,
+}
+
+// keyword returns the keyword a keyword location ends in, skipping the
+// property names and indexes that select subschemas.
+func keyword(location string) string {
+	var kw string
+	parts := strings.Split(strings.TrimPrefix(location, "/"), "/")
+	for i := 0; i < len(parts); i++ {
+		kw = parts[i]
+		i += subschemaKeywords[kw]
+	}
+	return kw
+}
+
+// compileError unwraps a compile error to its cause. A schema failing the
+// draft's meta-schema reports the first offending location in the schema.
+func compileError(err error) error {
+	var se *jsonschema.SchemaError
+	if errors.As(err, &se) && se.Err != nil {
+		err = se.Err
+	}
+	var ve *jsonschema.ValidationError
+	if errors.As(err, &ve) {
+		var errs []Error
+		collectLeaves(ve, &errs)
+		return fmt.Errorf("invalid schema: %s", errs[0].Error())
+	}
+	return errors.New(cleanMessage(err))
+}
+
+// cleanMessage renders an error of the jsonschema package without its
+// prefix and the internal base URI.
+func cleanMessage(err error) string {
+	msg := strings.TrimPrefix(err.Error(), "jsonschema: ")
+	return strings.ReplaceAll(msg, defaultBase, "")
+}
@@ -0,0 +1,245 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		valid   []string
+		invalid []string
+	}{
+		{
+			name:    "boolean schemas",
+			schema:  `{"properties": {"a": true, "b": false}}`,
+			valid:   []string{`{"a": 1}`, `{}`},
+			invalid: []string{`{"b": 1}`},
+		},
+		{
+			name:    "integer accepts whole numbers",
+			schema:  `{"type": "integer"}`,
+			valid:   []string{`1`, `1.0`, `-3e2`},
+			invalid: []string{`1.5`, `"1"`},
+		},
+		{
+			name:    "numeric limits",
+			schema:  `{"minimum": 1, "exclusiveMaximum": 10, "multipleOf": 0.5}`,
+			valid:   []string{`1`, `9.5`, `"not a number"`},
+			invalid: []string{`0.5`, `10`, `1.25`},
+		},
+		{
+			name:    "string keywords",
+			schema:  `{"minLength": 2, "maxLength": 3, "pattern": "^[a-zé]+$"}`,
+			valid:   []string{`"ab"`, `"éé"`, `"abc"`},
+			invalid: []string{`"a"`, `"abcd"`, `"AB"`},
+		},
+		{
+			name:    "formats",
+			schema:  `{"type": "array", "prefixItems": [{"format": "ipv4"}, {"format": "date-time"}, {"format": "hostname"}, {"format": "duration"}]}`,
+			valid:   []string{`["10.0.0.1", "2024-05-01T10:00:00Z", "edge-1.example.com", "PT5M"]`},
+			invalid: []string{`["10.0.0.256"]`, `[null, "2024-05-01 10:00"]`, `[null, null, "-bad-"]`, `[null, null, null, "P"]`},
+		},
+		{
+			name:    "const and enum compare numbers by value",
+			schema:  `{"properties": {"a": {"const": 1}, "b": {"enum": [[1, 2], {"x": 1}]}}}`,
+			valid:   []string{`{"a": 1.0, "b": [1.0, 2]}`, `{"b": {"x": 1}}`},
+			invalid: []string{`{"a": 2}`, `{"b": [2, 1]}`},
+		},
+		{
+			name:    "arrays",
+			schema:  `{"prefixItems": [{"type": "string"}], "items": {"type": "integer"}, "uniqueItems": true, "maxItems": 3}`,
+			valid:   []string{`["a", 1, 2]`, `[]`},
+			invalid: []string{`[1]`, `["a", "b"]`, `["a", 1, 1]`, `["a", 1, 2, 3]`},
+		},
+		{
+			name:    "contains",
+			schema:  `{"contains": {"const": "x"}, "minContains": 2, "maxContains": 3}`,
+			valid:   []string{`["x", "x"]`, `["x", 1, "x", "x"]`},
+			invalid: []string{`["x"]`, `["x", "x", "x", "x"]`},
+		},
+		{
+			name:    "objects",
+			schema:  `{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": {"type": "integer"}, "propertyNames": {"maxLength": 5}, "dependentRequired": {"a": ["b"]}, "minProperties": 1}`,
+			valid:   []string{`{"x-a": "s", "n": 1}`, `{"a": 1, "b": 2}`},
+			invalid: []string{`{}`, `{"x-a": 1}`, `{"n": "s"}`, `{"toolong": 1}`, `{"a": 1}`},
+		},
+		{
+			name:    "combinators",
+			schema:  `{"anyOf": [{"type": "string"}, {"type": "integer"}], "oneOf": [{"minimum": 5}, {"type": "integer"}], "not": {"const": "no"}}`,
+			valid:   []string{`"yes"`, `3`},
+			invalid: []string{`null`, `"no"`, `7`},
+		},
+		{
+			name:    "if then else",
+			schema:  `{"if": {"properties": {"mode": {"const": "tls"}}}, "then": {"required": ["cert"]}, "else": {"not": {"required": ["cert"]}}}`,
+			valid:   []string{`{"mode": "tls", "cert": "c"}`, `{"mode": "plain"}`},
+			invalid: []string{`{"mode": "tls"}`, `{"mode": "plain", "cert": "c"}`},
+		},
+		{
+			name:    "dependent schemas",
+			schema:  `{"dependentSchemas": {"proxy": {"required": ["proxy_port"]}}}`,
+			valid:   []string{`{}`, `{"proxy": "p", "proxy_port": 1}`},
+			invalid: []string{`{"proxy": "p"}`},
+		},
+		{
+			name:    "refs and defs",
+			schema:  `{"$defs": {"port": {"type": "integer", "minimum": 1, "maximum": 65535}, "node": {"$anchor": "node", "properties": {"next": {"$ref": "#node"}, "v": {"$ref": "#/$defs/port"}}}}, "$ref": "#/$defs/node"}`,
+			valid:   []string{`{"v": 80, "next": {"v": 443, "next": {}}}`},
+			invalid: []string{`{"next": {"next": {"v": 0}}}`},
+		},
+		{
+			name:    "embedded resources",
+			schema:  `{"$id": "https://example.com/root", "properties": {"a": {"$ref": "item"}}, "$defs": {"item": {"$id": "item", "type": "string"}}}`,
+			valid:   []string{`{"a": "s"}`},
+			invalid: []string{`{"a": 1}`},
+		},
+		{
+			name:    "unevaluated properties see through applicators",
+			schema:  `{"allOf": [{"properties": {"a": true}}], "anyOf": [{"properties": {"b": true}, "required": ["b"]}, {"properties": {"c": true}, "required": ["c"]}], "unevaluatedProperties": false}`,
+			valid:   []string{`{"a": 1, "b": 1}`, `{"c": 1}`},
+			invalid: []string{`{"b": 1, "d": 1}`, `{"a": 1, "c": 1, "b": 1, "e": 1}`},
+		},
+		{
+			name:    "unevaluated properties ignore failed branches",
+			schema:  `{"anyOf": [{"properties": {"a": {"type": "string"}}}, {"properties": {"b": true}}], "unevaluatedProperties": false}`,
+			valid:   []string{`{"a": "s"}`},
+			invalid: []string{`{"a": 1}`},
+		},
+		{
+			name:    "unevaluated items",
+			schema:  `{"prefixItems": [true], "contains": {"type": "string"}, "unevaluatedItems": false}`,
+			valid:   []string{`[1, "a", "b"]`},
+			invalid: []string{`[1, "a", 2]`},
+		},
+		{
+			name: "dynamic refs resolve to the outermost anchor",
+			schema: `{
+				"$id": "https://example.com/strict-tree",
+				"$dynamicAnchor": "node",
+				"$ref": "tree",
+				"unevaluatedProperties": false,
+				"$defs": {
+					"tree": {
+						"$id": "tree",
+						"$dynamicAnchor": "node",
+						"properties": {
+							"data": true,
+							"children": {"type": "array", "items": {"$dynamicRef": "#node"}}
+						}
+					}
+				}
+			}`,
+			valid:   []string{`{"data": 1, "children": [{"data": 2}]}`},
+			invalid: []string{`{"children": [{"daat": 2}]}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile([]byte(tt.schema))
+			require.NoError(t, err)
+
+			for _, doc := range tt.valid {
+				errs, err := s.ValidateJSON([]byte(doc))
+				require.NoError(t, err)
+				assert.Empty(t, errs, "expected %s to be valid", doc)
+			}
+			for _, doc := range tt.invalid {
+				errs, err := s.ValidateJSON([]byte(doc))
+				require.NoError(t, err)
+				assert.NotEmpty(t, errs, "expected %s to be invalid", doc)
+			}
+		})
+	}
+}
+
+func TestValidate_Errors(t *testing.T) {
+	s, err := Compile([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"required": ["name"],
+		"properties": {
+			"port": {"$ref": "#/$defs/port"},
+			"tags": {"type": "array", "items": false}
+		},
+		"additionalProperties": false,
+		"$defs": {"port": {"type": "integer", "minimum": 1}}
+	}`))
+	require.NoError(t, err)
+
+	errs, err := s.ValidateJSON([]byte(`{"port": 0, "tags": ["a"], "extra": 1}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Error{
+		{InstanceLocation: "", KeywordLocation: "/required", Keyword: "required", Message: "missing properties: 'name'"},
+		{InstanceLocation: "", KeywordLocation: "/additionalProperties", Keyword: "additionalProperties", Message: "additionalProperties 'extra' not allowed"},
+		{InstanceLocation: "/port", KeywordLocation: "/properties/port/$ref/minimum", Keyword: "minimum", Message: "must be >= 1 but found 0"},
+		{InstanceLocation: "/tags/0", KeywordLocation: "/properties/tags/items", Keyword: "items", Message: "not allowed"},
+	}, errs)
+	assert.Equal(t, "/port: must be >= 1 but found 0", errs[2].Error())
+
+	_, err = s.ValidateJSON([]byte(`{"port": 1} trailing`))
+	assert.Error(t, err)
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := map[string]string{
+		"not a schema":        `[]`,
+		"invalid json":        `{`,
+		"unsupported dialect": `{"$schema": "http://json-schema.org/draft-07/schema#"}`,
+		"unknown type":        `{"type": "float"}`,
+		"bad pattern":         `{"pattern": "(?=x)"}`,
+		"bad count":           `{"minLength": -1}`,
+		"zero multipleOf":     `{"multipleOf": 0}`,
+		"unresolvable ref":    `{"$ref": "#/$defs/missing"}`,
+		"remote ref":          `{"$ref": "https://example.com/other.json"}`,
+		"bad subschema":       `{"properties": {"a": 1}}`,
+		"empty allOf":         `{"allOf": []}`,
+		"reference loop":      `{"$ref": "#"}`,
+	}
+	for name, schema := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Compile([]byte(schema))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCompile_ErrorsStayInternal(t *testing.T) {
+	_, err := Compile([]byte(`{"$ref": "#/$defs/missing"}`))
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), defaultBase)
+
+	_, err = Compile([]byte(`{"properties": {"port": {"minimum": "one"}}}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/properties/port/minimum")
+}
+
+func TestDecode_RejectsHugeExponents(t *testing.T) {
+	_, err := Decode([]byte(`{"n": [1e1000000000]}`))
+	assert.Error(t, err)
+
+	doc, err := Decode([]byte(`{"n": 1e300}`))
+	require.NoError(t, err)
+	assert.NotNil(t, doc)
+}
+
+func TestKeyword(t *testing.T) {
+	tests := map[string]string{
+		"":                                 "",
+		"/required":                        "required",
+		"/properties/port/$ref/minimum":    "minimum",
+		"/properties/minimum":              "properties",
+		"/properties/properties/type":      "type",
+		"/dependentRequired/a/0":           "dependentRequired",
+		"/allOf/1/prefixItems/0":           "prefixItems",
+		"/$defs/node/properties/next/$ref": "$ref",
+	}
+	for location, want := range tests {
+		assert.Equal(t, want, keyword(location), location)
+	}
+}
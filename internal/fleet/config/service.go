@@ -3,6 +3,9 @@ package config
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -42,6 +45,31 @@ func (s *Service) CreateTemplate(ctx context.Context, tenantID, name string, sch
 	return template, nil
 }
 
+// AddTemplate stores a fully populated template, such as one carrying a
+// description, defaults and variables.
+func (s *Service) AddTemplate(ctx context.Context, template *Template) (*Template, error) {
+	if err := template.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.CreateTemplate(ctx, template); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("created configuration template",
+		zap.String("template_id", template.ID),
+		zap.String("tenant_id", template.TenantID),
+		zap.String("name", template.Name),
+	)
+
+	return template, nil
+}
+
+// ListTemplates returns the templates of a tenant, oldest first.
+func (s *Service) ListTemplates(ctx context.Context, tenantID string) ([]*Template, error) {
+	return s.store.ListTemplates(ctx, ListOptions{TenantID: tenantID})
+}
+
 // CreateVersion creates a new configuration version from a template
 func (s *Service) CreateVersion(ctx context.Context, tenantID, templateID string, config json.RawMessage, createdBy string) (*Version, error) {
 	template, err := s.store.GetTemplate(ctx, tenantID, templateID)
@@ -65,13 +93,218 @@ func (s *Service) CreateVersion(ctx context.Context, tenantID, templateID string
 	return version, nil
 }
 
-// DeployConfiguration deploys a configuration version to a device
+// FindTemplate returns a template by ID or, failing that, by name. A name
+// shared by several templates is rejected as ambiguous.
+func (s *Service) FindTemplate(ctx context.Context, tenantID, nameOrID string) (*Template, error) {
+	template, err := s.store.GetTemplate(ctx, tenantID, nameOrID)
+	if err == nil {
+		return template, nil
+	}
+	var configErr *Error
+	if !errors.As(err, &configErr) || configErr.Code != ErrTemplateNotFound {
+		return nil, err
+	}
+
+	templates, err := s.store.ListTemplates(ctx, ListOptions{TenantID: tenantID})
+	if err != nil {
+		return nil, err
+	}
+	var found *Template
+	for _, t := range templates {
+		if t.Name != nameOrID {
+			continue
+		}
+		if found != nil {
+			return nil, NewError("find template", ErrInvalidTemplate,
+				fmt.Sprintf("template name %q is ambiguous; use the template ID", nameOrID))
+		}
+		found = t
+	}
+	if found == nil {
+		return nil, NewError("find template", ErrTemplateNotFound, fmt.Sprintf("template %q not found", nameOrID))
+	}
+	return found, nil
+}
+
+// ValidateConfig checks a configuration against a template's schema
+// without storing anything. An empty result means the configuration is
+// valid.
+func (s *Service) ValidateConfig(ctx context.Context, tenantID, templateID string, config json.RawMessage) ([]Violation, error) {
+	template, err := s.store.GetTemplate(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	return template.ValidateConfig(config)
+}
+
+// RenderConfig renders a configuration for a device; see Template.Render.
+func (s *Service) RenderConfig(ctx context.Context, tenantID, templateID string, values RenderValues) (json.RawMessage, error) {
+	template, err := s.store.GetTemplate(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := template.Render(values)
+	if err != nil {
+		return nil, err
+	}
+
+	if values.Target != nil {
+		s.logger.Debug("rendered configuration",
+			zap.String("template_id", templateID),
+			zap.String("tenant_id", tenantID),
+			zap.String("device_id", values.Target.ID),
+		)
+	}
+
+	return rendered, nil
+}
+
+// PreviewConfig renders a configuration for a device and checks the result
+// against the template without storing anything. Variables that cannot be
+// resolved are reported as violations with the keyword "variable". An
+// empty violation list means ApplyConfig would accept the configuration.
+func (s *Service) PreviewConfig(ctx context.Context, tenantID, templateID string, values RenderValues) (json.RawMessage, []Violation, error) {
+	template, err := s.store.GetTemplate(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rendered, err := template.Render(values)
+	var renderErr *Error
+	if errors.As(err, &renderErr) && renderErr.Code == ErrUnresolvedVariables {
+		unresolved, _ := renderErr.Fields["unresolved"].([]UnresolvedVariable)
+		violations := make([]Violation, len(unresolved))
+		for i, u := range unresolved {
+			violations[i] = Violation{Path: u.Path, Keyword: "variable", Message: u.String()}
+		}
+		return nil, violations, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	violations, err := template.ValidateConfig(rendered)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rendered, violations, nil
+}
+
+// LatestDeployment returns the most recent deployment to a device.
+func (s *Service) LatestDeployment(ctx context.Context, tenantID, deviceID string) (*Deployment, error) {
+	deployments, err := s.store.LatestDeployments(ctx, ListOptions{
+		TenantID: tenantID,
+		DeviceID: deviceID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(deployments) == 0 {
+		return nil, NewError("latest deployment", ErrDeploymentNotFound, "device has no deployments")
+	}
+	return deployments[0], nil
+}
+
+// ApplyConfig renders a configuration for a device, validates the result
+// against its template, stores it as a new validated version and creates a
+// pending deployment of that version to the device. Nothing is stored when
+// rendering or validation fails; the returned error then carries the
+// unresolved variables in its "unresolved" field or the violations in its
+// "violations" field.
+func (s *Service) ApplyConfig(ctx context.Context, tenantID, templateID, deviceID string, values RenderValues, createdBy string) (*Deployment, error) {
+	config, err := s.RenderConfig(ctx, tenantID, templateID, values)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.CreateValidatedVersion(ctx, tenantID, templateID, config, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	return s.DeployConfiguration(ctx, tenantID, templateID, version, deviceID)
+}
+
+// CreateValidatedVersion validates a configuration against its template and
+// stores it as a new version marked valid. Nothing is stored when
+// validation fails; the returned error then carries the violations in its
+// "violations" field.
+func (s *Service) CreateValidatedVersion(ctx context.Context, tenantID, templateID string, config json.RawMessage, createdBy string) (*Version, error) {
+	violations, err := s.ValidateConfig(ctx, tenantID, templateID, config)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		return nil, NewError("create version", ErrValidationFailed, "configuration does not match the template schema").
+			WithField("violations", violations)
+	}
+
+	version := NewVersion(config, templateID, createdBy)
+	validatedAt := time.Now().UTC()
+	version.ValidatedAt = &validatedAt
+	version.Status = ValidationStatusValid
+
+	if err := s.store.CreateVersion(ctx, tenantID, templateID, version); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("created configuration version",
+		zap.String("template_id", templateID),
+		zap.String("tenant_id", tenantID),
+		zap.Int("version", version.Number),
+		zap.String("created_by", createdBy),
+	)
+
+	return version, nil
+}
+
+// GetVersion returns a configuration version of a template
+func (s *Service) GetVersion(ctx context.Context, tenantID, templateID string, versionNumber int) (*Version, error) {
+	return s.store.GetVersion(ctx, tenantID, templateID, versionNumber)
+}
+
+// GetDeployment returns a configuration deployment
+func (s *Service) GetDeployment(ctx context.Context, tenantID, deploymentID string) (*Deployment, error) {
+	return s.store.GetDeployment(ctx, tenantID, deploymentID)
+}
+
+// ListDeployments returns deployments matching opts, oldest first. An
+// empty TenantID lists the deployments of every tenant.
+func (s *Service) ListDeployments(ctx context.Context, opts ListOptions) ([]*Deployment, error) {
+	return s.store.ListDeployments(ctx, opts)
+}
+
+// LatestDeployments returns the most recent deployment of each device
+// among those matching opts, oldest first. An empty TenantID covers every
+// tenant.
+func (s *Service) LatestDeployments(ctx context.Context, opts ListOptions) ([]*Deployment, error) {
+	return s.store.LatestDeployments(ctx, opts)
+}
+
+// DeployConfiguration deploys a configuration version to a device. Any
+// pending deployment to the device that was not delivered yet is failed as
+// superseded, so the device only ever applies the newest configuration.
+// When superseding them fails the new deployment is failed too, so it is
+// never delivered alongside the deployments it was meant to replace.
 func (s *Service) DeployConfiguration(ctx context.Context, tenantID, templateID string, version *Version, deviceID string) (*Deployment, error) {
 	deployment := NewDeployment(tenantID, deviceID, version)
 
 	if err := s.store.CreateDeployment(ctx, deployment); err != nil {
 		return nil, err
 	}
+	if err := s.supersedePending(ctx, tenantID, deviceID, deployment.ID); err != nil {
+		// The new deployment must not be delivered next to the pending
+		// ones it failed to replace
+		deployment.Fail(fmt.Sprintf("superseding pending deployments: %v", err))
+		if updateErr := s.store.UpdateDeployment(ctx, deployment); updateErr != nil {
+			s.logger.Error("failed to fail deployment after superseding pending deployments failed",
+				zap.Error(updateErr),
+				zap.String("deployment_id", deployment.ID),
+				zap.String("device_id", deviceID),
+				zap.String("tenant_id", tenantID),
+			)
+		}
+		return nil, err
+	}
 
 	s.logger.Info("initiated configuration deployment",
 		zap.String("deployment_id", deployment.ID),
@@ -128,19 +361,49 @@ func (s *Service) FailDeployment(ctx context.Context, tenantID, deploymentID, er
 	return nil
 }
 
-// ValidateVersion marks a configuration version as validated
+// ValidateVersion validates a configuration version against its template
+// schema and variable rules. The version is marked valid or invalid, with
+// the violations stored on it, and saved. An invalid version is reported
+// as an ErrValidationFailed error carrying the violations in its
+// "violations" field.
 func (s *Service) ValidateVersion(ctx context.Context, tenantID, templateID string, versionNumber int) error {
+	template, err := s.store.GetTemplate(ctx, tenantID, templateID)
+	if err != nil {
+		return err
+	}
 	version, err := s.store.GetVersion(ctx, tenantID, templateID, versionNumber)
 	if err != nil {
 		return err
 	}
 
+	violations, err := template.ValidateConfig(version.Config)
+	if err != nil {
+		return err
+	}
+
+	validatedAt := time.Now().UTC()
+	version.ValidatedAt = &validatedAt
+	version.ValidationErrors = violations
 	version.Status = ValidationStatusValid
+	if len(violations) > 0 {
+		version.Status = ValidationStatusInvalid
+	}
 
 	if err := s.store.UpdateVersion(ctx, tenantID, templateID, version); err != nil {
 		return err
 	}
 
+	if len(violations) > 0 {
+		s.logger.Warn("configuration version failed validation",
+			zap.String("template_id", templateID),
+			zap.String("tenant_id", tenantID),
+			zap.Int("version", versionNumber),
+			zap.Int("violations", len(violations)),
+		)
+		return NewError("validate version", ErrValidationFailed, "configuration does not match the template schema").
+			WithField("violations", violations)
+	}
+
 	s.logger.Info("validated configuration version",
 		zap.String("template_id", templateID),
 		zap.String("tenant_id", tenantID),
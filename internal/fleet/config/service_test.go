@@ -0,0 +1,60 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/config/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+// failingList fails the next deployment listing once fail is set
+type failingList struct {
+	config.Store
+	fail bool
+}
+
+func (s *failingList) ListDeployments(ctx context.Context, opts config.ListOptions) ([]*config.Deployment, error) {
+	if s.fail {
+		s.fail = false
+		return nil, errors.New("disk full")
+	}
+	return s.Store.ListDeployments(ctx, opts)
+}
+
+func TestDeployConfigurationFailsWhenSupersedingFails(t *testing.T) {
+	ctx := context.Background()
+	store := &failingList{Store: memory.New()}
+	service := config.NewService(store, zaptest.NewLogger(t))
+	version := &config.Version{Number: 1, Hash: "abc"}
+
+	first, err := service.DeployConfiguration(ctx, "tenant-1", "tpl-1", version, "dev-1")
+	require.NoError(t, err)
+
+	store.fail = true
+	_, err = service.DeployConfiguration(ctx, "tenant-1", "tpl-1", version, "dev-1")
+	require.Error(t, err)
+
+	// Only the deployment that was already pending can be delivered
+	pending, err := service.ListDeployments(ctx, config.ListOptions{
+		TenantID: "tenant-1",
+		DeviceID: "dev-1",
+		Status:   config.DeploymentStatusPending,
+	})
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, first.ID, pending[0].ID)
+
+	failed, err := service.ListDeployments(ctx, config.ListOptions{
+		TenantID: "tenant-1",
+		DeviceID: "dev-1",
+		Status:   config.DeploymentStatusFailed,
+	})
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Contains(t, failed[0].Error, "superseding pending deployments: disk full")
+}
@@ -31,4 +31,9 @@ type Store interface {
 	GetDeployment(ctx context.Context, tenantID, deploymentID string) (*Deployment, error)
 	UpdateDeployment(ctx context.Context, deployment *Deployment) error
 	ListDeployments(ctx context.Context, opts ListOptions) ([]*Deployment, error)
+
+	// LatestDeployments returns the most recent deployment of each device
+	// among those matching opts, ordered like ListDeployments. Offset and
+	// Limit page through the devices.
+	LatestDeployments(ctx context.Context, opts ListOptions) ([]*Deployment, error)
 }
@@ -0,0 +1,44 @@
+// Package factory provides creation functions for config store implementations.
+package factory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/config/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/config/store/postgres"
+	"github.com/wrale/wrale-fleet/internal/fleet/storage"
+)
+
+// Built-in backend names
+const (
+	// Memory keeps templates and deployments in memory; data is lost on restart
+	Memory = "memory"
+	// Postgres stores configuration in a PostgreSQL database
+	Postgres = "postgres"
+)
+
+// Options configures config store backends.
+type Options struct {
+	// DataDir is the directory file-backed stores keep their data in
+	DataDir string
+
+	// DSN is the connection string for database-backed stores
+	DSN string
+}
+
+// Registry holds the available config store backends.
+var Registry = storage.NewRegistry[config.Store, Options]("config")
+
+func init() {
+	Registry.Register(Memory, func(ctx context.Context, opts Options) (config.Store, error) {
+		return memory.New(), nil
+	})
+	Registry.Register(Postgres, func(ctx context.Context, opts Options) (config.Store, error) {
+		if opts.DSN == "" {
+			return nil, fmt.Errorf("database DSN is required")
+		}
+		return postgres.Open(ctx, opts.DSN)
+	})
+}
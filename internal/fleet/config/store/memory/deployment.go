@@ -156,3 +156,36 @@ func (s *Store) ListDeployments(ctx context.Context, opts config.ListOptions) ([
 
 	return deployments[start:end], nil
 }
+
+// LatestDeployments retrieves the most recent deployment of each device
+// among those matching the given criteria, with pagination over devices.
+func (s *Store) LatestDeployments(ctx context.Context, opts config.ListOptions) ([]*config.Deployment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deployments := make([]*config.Deployment, 0, len(s.deployments))
+	for _, d := range s.deployments {
+		deployments = append(deployments, d)
+	}
+	deployments = s.filterDeployments(deployments, opts)
+
+	// Sorted oldest first, so the last deployment seen per device wins
+	s.sortDeployments(deployments)
+	latest := make(map[string]*config.Deployment)
+	for _, d := range deployments {
+		latest[d.TenantID+"/"+d.DeviceID] = d
+	}
+
+	deployments = deployments[:0]
+	for _, d := range latest {
+		deployments = append(deployments, d)
+	}
+	s.sortDeployments(deployments)
+
+	start, end := s.applyPagination(len(deployments), opts)
+	if start >= len(deployments) {
+		return []*config.Deployment{}, nil
+	}
+
+	return deployments[start:end], nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/config/storetest"
 )
 
 // TestNew verifies proper store initialization
@@ -270,3 +271,10 @@ func TestStore_ErrorHandling(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+// TestConformance runs the shared config.Store conformance suite
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) config.Store {
+		return New()
+	})
+}
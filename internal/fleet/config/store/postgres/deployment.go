@@ -0,0 +1,205 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+)
+
+// deploymentColumns lists the columns read by scanDeployment, in order
+const deploymentColumns = `tenant_id, id, device_id, status, config_version, deployed_at, completed_at, error, rolled_back, superseded_by`
+
+// validateDeployment ensures the deployment is valid before storage operations.
+func validateDeployment(deployment *config.Deployment) error {
+	if deployment == nil {
+		return config.NewError("validate deployment", config.ErrInvalidDeployment, "deployment is nil")
+	}
+	return validateInput("validate deployment", map[string]string{
+		"deployment ID": deployment.ID,
+		"tenant ID":     deployment.TenantID,
+		"device ID":     deployment.DeviceID,
+	})
+}
+
+// scanDeployment decodes a single deployment row
+func scanDeployment(row rowScanner) (*config.Deployment, error) {
+	var (
+		d           config.Deployment
+		version     []byte
+		completedAt sql.NullTime
+	)
+	if err := row.Scan(&d.TenantID, &d.ID, &d.DeviceID, &d.Status,
+		&version, &d.DeployedAt, &completedAt, &d.Error, &d.RolledBack, &d.SupersededBy); err != nil {
+		return nil, err
+	}
+
+	if len(version) > 0 {
+		d.ConfigVersion = &config.Version{}
+		if err := json.Unmarshal(version, d.ConfigVersion); err != nil {
+			return nil, err
+		}
+	}
+	d.DeployedAt = d.DeployedAt.UTC()
+	if completedAt.Valid {
+		t := completedAt.Time.UTC()
+		d.CompletedAt = &t
+	}
+	return &d, nil
+}
+
+// encodeVersion serializes the deployed version snapshot for storage
+func encodeVersion(version *config.Version) (interface{}, error) {
+	if version == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// CreateDeployment stores a new configuration deployment, initializing the
+// deployment time if it is unset.
+func (s *Store) CreateDeployment(ctx context.Context, deployment *config.Deployment) error {
+	if err := validateDeployment(deployment); err != nil {
+		return err
+	}
+
+	version, err := encodeVersion(deployment.ConfigVersion)
+	if err != nil {
+		return storeError("create deployment", "failed to encode version", err)
+	}
+
+	deployedAt := deployment.DeployedAt
+	if deployedAt.IsZero() {
+		deployedAt = time.Now().UTC()
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO config_deployments (`+deploymentColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		deployment.TenantID, deployment.ID, deployment.DeviceID, deployment.Status,
+		version, deployedAt, nullTime(deployment.CompletedAt), deployment.Error, deployment.RolledBack, deployment.SupersededBy)
+	if isUniqueViolation(err) {
+		return config.NewError("create deployment", config.ErrInvalidDeployment, "deployment already exists")
+	}
+	if err != nil {
+		return storeError("create deployment", "failed to insert deployment", err)
+	}
+
+	deployment.DeployedAt = deployedAt
+	return nil
+}
+
+// GetDeployment retrieves a specific deployment by tenant and deployment ID.
+func (s *Store) GetDeployment(ctx context.Context, tenantID, deploymentID string) (*config.Deployment, error) {
+	if err := validateInput("get deployment", map[string]string{
+		"tenant ID":     tenantID,
+		"deployment ID": deploymentID,
+	}); err != nil {
+		return nil, err
+	}
+
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+deploymentColumns+` FROM config_deployments WHERE tenant_id = $1 AND id = $2`,
+		tenantID, deploymentID)
+	deployment, err := scanDeployment(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, config.NewError("get deployment", config.ErrDeploymentNotFound, "deployment not found")
+	}
+	if err != nil {
+		return nil, storeError("get deployment", "failed to read deployment", err)
+	}
+	return deployment, nil
+}
+
+// UpdateDeployment updates an existing deployment with new information.
+func (s *Store) UpdateDeployment(ctx context.Context, deployment *config.Deployment) error {
+	if err := validateDeployment(deployment); err != nil {
+		return err
+	}
+
+	version, err := encodeVersion(deployment.ConfigVersion)
+	if err != nil {
+		return storeError("update deployment", "failed to encode version", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE config_deployments
+		SET device_id = $3, status = $4, config_version = $5, deployed_at = $6,
+			completed_at = $7, error = $8, rolled_back = $9,
+			superseded_by = $10
+		WHERE tenant_id = $1 AND id = $2`,
+		deployment.TenantID, deployment.ID, deployment.DeviceID, deployment.Status,
+		version, deployment.DeployedAt, nullTime(deployment.CompletedAt), deployment.Error, deployment.RolledBack, deployment.SupersededBy)
+	if err != nil {
+		return storeError("update deployment", "failed to update deployment", err)
+	}
+	return requireAffected(result, "update deployment", config.ErrDeploymentNotFound, "deployment not found")
+}
+
+// ListDeployments retrieves deployments matching the given criteria. Device
+// and status filters are served by the (tenant_id, device_id) and
+// (tenant_id, status) indexes.
+func (s *Store) ListDeployments(ctx context.Context, opts config.ListOptions) ([]*config.Deployment, error) {
+	qb := deploymentFilters(opts)
+	query, args := qb.build(`SELECT `+deploymentColumns+` FROM config_deployments`, "deployed_at, id", opts)
+	return s.queryDeployments(ctx, "list deployments", query, args)
+}
+
+// LatestDeployments retrieves the most recent deployment of each device
+// among those matching the given criteria. DISTINCT ON keeps the first row
+// per device in descending order, which the (tenant_id, device_id) index
+// serves by scanning backwards; pagination applies to the devices.
+func (s *Store) LatestDeployments(ctx context.Context, opts config.ListOptions) ([]*config.Deployment, error) {
+	qb := deploymentFilters(opts)
+	latest, args := qb.build(`SELECT DISTINCT ON (tenant_id, device_id) `+deploymentColumns+` FROM config_deployments`,
+		"tenant_id DESC, device_id DESC, deployed_at DESC, id DESC", config.ListOptions{})
+
+	outer := queryBuilder{args: args}
+	query, args := outer.build(`SELECT `+deploymentColumns+` FROM (`+latest+`) latest`, "deployed_at, id", opts)
+	return s.queryDeployments(ctx, "latest deployments", query, args)
+}
+
+// deploymentFilters builds the WHERE conditions shared by the deployment
+// list queries
+func deploymentFilters(opts config.ListOptions) queryBuilder {
+	var qb queryBuilder
+	if opts.TenantID != "" {
+		qb.where("tenant_id = ?", opts.TenantID)
+	}
+	if opts.DeviceID != "" {
+		qb.where("device_id = ?", opts.DeviceID)
+	}
+	if opts.Status != "" {
+		qb.where("status = ?", opts.Status)
+	}
+	return qb
+}
+
+// queryDeployments runs a deployment query and decodes every row
+func (s *Store) queryDeployments(ctx context.Context, op, query string, args []interface{}) ([]*config.Deployment, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, storeError(op, "failed to query deployments", err)
+	}
+	defer rows.Close()
+
+	deployments := make([]*config.Deployment, 0)
+	for rows.Next() {
+		deployment, err := scanDeployment(rows)
+		if err != nil {
+			return nil, storeError(op, "failed to read deployment", err)
+		}
+		deployments = append(deployments, deployment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storeError(op, "failed to read deployments", err)
+	}
+	return deployments, nil
+}
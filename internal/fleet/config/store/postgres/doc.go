@@ -0,0 +1,24 @@
+// Package postgres provides a PostgreSQL-backed implementation of the
+// config.Store interface.
+//
+// Templates, versions and deployments live in their own tables. The schema
+// is shipped as embedded SQL migrations that New applies on startup, so a
+// fresh database needs no manual preparation. Applied migrations are
+// recorded in the config_schema_migrations table and an advisory lock
+// serializes concurrent migrators.
+//
+// Version numbers are assigned inside a transaction that locks the parent
+// template row, keeping numbering gapless when several writers create
+// versions for the same template at once. Deployment listing is backed by
+// indexes on device and status so filtered queries stay cheap as history
+// grows.
+//
+// Example usage:
+//
+//	store, err := postgres.Open(ctx, "postgres://fleet@localhost/fleet?sslmode=disable")
+//	if err != nil {
+//		return err
+//	}
+//	defer store.Close()
+//	service := config.NewService(store, logger)
+package postgres
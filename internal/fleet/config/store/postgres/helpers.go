@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+)
+
+// uniqueViolation is the PostgreSQL SQLSTATE for duplicate keys
+const uniqueViolation = "23505"
+
+// validateInput checks that required string fields are not empty.
+// It returns an error if any required field is empty.
+func validateInput(op string, fields map[string]string) error {
+	for field, value := range fields {
+		if value == "" {
+			return config.NewError(op, config.ErrValidationFailed, fmt.Sprintf("%s is required", field))
+		}
+	}
+	return nil
+}
+
+// storeError wraps a database failure in a config.Error
+func storeError(op, message string, err error) error {
+	return &config.Error{
+		Op:      op,
+		Code:    config.ErrStoreOperation,
+		Message: message,
+		Err:     err,
+	}
+}
+
+// isUniqueViolation reports whether err is a duplicate key error
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// nullJSON converts a raw document into a column value, mapping an empty
+// document to NULL.
+func nullJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// rawJSON converts a scanned column back into a raw document
+func rawJSON(data []byte) json.RawMessage {
+	if data == nil {
+		return nil
+	}
+	return json.RawMessage(data)
+}
+
+// queryBuilder accumulates WHERE conditions with positional arguments.
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// where adds a condition; the placeholder in cond is written as "?" and
+// rewritten to the next positional parameter.
+func (b *queryBuilder) where(cond string, arg interface{}) {
+	b.args = append(b.args, arg)
+	b.conditions = append(b.conditions, strings.Replace(cond, "?", fmt.Sprintf("$%d", len(b.args)), 1))
+}
+
+// build renders the final query from a base SELECT and ORDER BY clause,
+// applying pagination. As with the in-memory store, a non-positive limit
+// returns every matching row.
+func (b *queryBuilder) build(base, orderBy string, opts config.ListOptions) (string, []interface{}) {
+	var sb strings.Builder
+	sb.WriteString(base)
+	if len(b.conditions) > 0 {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(strings.Join(b.conditions, " AND "))
+	}
+	sb.WriteString(" ORDER BY ")
+	sb.WriteString(orderBy)
+
+	args := b.args
+	if opts.Limit > 0 {
+		offset := opts.Offset
+		if offset < 0 {
+			offset = 0
+		}
+		args = append(args, opts.Limit, offset)
+		fmt.Fprintf(&sb, " LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+	return sb.String(), args
+}
@@ -0,0 +1,56 @@
+-- Configuration templates, their numbered versions and device deployments.
+--
+-- Raw configuration documents use the json type rather than jsonb so the
+-- stored bytes match the hash recorded on each version.
+
+CREATE TABLE config_templates (
+    tenant_id      TEXT        NOT NULL,
+    id             TEXT        NOT NULL,
+    name           TEXT        NOT NULL,
+    description    TEXT        NOT NULL DEFAULT '',
+    schema         JSON,
+    default_config JSON,
+    variables      JSON        NOT NULL DEFAULT '[]',
+    created_at     TIMESTAMPTZ NOT NULL,
+    updated_at     TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (tenant_id, id)
+);
+
+CREATE INDEX config_templates_created_idx
+    ON config_templates (tenant_id, created_at, id);
+
+CREATE TABLE config_versions (
+    tenant_id    TEXT        NOT NULL,
+    template_id  TEXT        NOT NULL,
+    number       INTEGER     NOT NULL,
+    config       JSON,
+    hash         TEXT        NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL,
+    created_by   TEXT        NOT NULL DEFAULT '',
+    validated_at TIMESTAMPTZ,
+    status       TEXT        NOT NULL DEFAULT '',
+    PRIMARY KEY (tenant_id, template_id, number),
+    FOREIGN KEY (tenant_id, template_id)
+        REFERENCES config_templates (tenant_id, id) ON DELETE CASCADE
+);
+
+CREATE TABLE config_deployments (
+    tenant_id      TEXT        NOT NULL,
+    id             TEXT        NOT NULL,
+    device_id      TEXT        NOT NULL,
+    status         TEXT        NOT NULL DEFAULT '',
+    config_version JSON,
+    deployed_at    TIMESTAMPTZ NOT NULL,
+    completed_at   TIMESTAMPTZ,
+    error          TEXT        NOT NULL DEFAULT '',
+    PRIMARY KEY (tenant_id, id)
+);
+
+CREATE INDEX config_deployments_deployed_idx
+    ON config_deployments (tenant_id, deployed_at, id);
+
+CREATE INDEX config_deployments_device_idx
+    ON config_deployments (tenant_id, device_id, deployed_at, id);
+
+CREATE INDEX config_deployments_status_idx
+    ON config_deployments (tenant_id, status, deployed_at, id);
@@ -0,0 +1,4 @@
+-- Reasons a configuration version failed validation against its template.
+
+ALTER TABLE config_versions
+    ADD COLUMN validation_errors JSON NOT NULL DEFAULT '[]';
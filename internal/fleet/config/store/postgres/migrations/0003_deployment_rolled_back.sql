@@ -0,0 +1,4 @@
+-- Deployments a device applied, found unhealthy and undid.
+
+ALTER TABLE config_deployments
+    ADD COLUMN rolled_back BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,12 @@
+-- Deployments replaced by a newer deployment to the device get their own
+-- status and record the replacement. They used to be failed with an error
+-- naming it, which is converted here.
+
+ALTER TABLE config_deployments
+    ADD COLUMN superseded_by TEXT NOT NULL DEFAULT '';
+
+UPDATE config_deployments
+SET status = 'superseded',
+    superseded_by = substr(error, length('superseded by deployment ') + 1),
+    error = ''
+WHERE status = 'failed' AND error LIKE 'superseded by deployment %';
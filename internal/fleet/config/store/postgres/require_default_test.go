@@ -0,0 +1,6 @@
+//go:build !postgres
+
+package postgres
+
+// requireDSN makes the tests fail rather than skip without a server
+const requireDSN = false
@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+
+	// Register the "postgres" database/sql driver
+	_ "github.com/lib/pq"
+)
+
+// driverName is the database/sql driver used by Open
+const driverName = "postgres"
+
+// Store provides a PostgreSQL-backed implementation of config.Store.
+type Store struct {
+	db *sql.DB
+}
+
+// Ensure Store implements config.Store
+var _ config.Store = (*Store)(nil)
+
+// Open connects to the database identified by dsn, applies pending
+// migrations and returns a ready store. The store owns the connection pool
+// and releases it on Close.
+func Open(ctx context.Context, dsn string) (*Store, error) {
+	if dsn == "" {
+		return nil, config.NewError("open store", config.ErrValidationFailed, "database DSN is required")
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, storeError("open store", "failed to open database", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, storeError("open store", "failed to connect to database", err)
+	}
+
+	store, err := New(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// New wraps an existing connection pool, applying pending migrations before
+// returning. Closing the store closes db.
+func New(ctx context.Context, db *sql.DB) (*Store, error) {
+	if db == nil {
+		return nil, config.NewError("new store", config.ErrValidationFailed, "database handle is required")
+	}
+	if err := migrate(ctx, db); err != nil {
+		return nil, storeError("new store", "failed to migrate schema", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close releases the underlying connection pool.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
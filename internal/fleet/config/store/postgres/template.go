@@ -0,0 +1,198 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+)
+
+// templateColumns lists the columns read by scanTemplate, in order
+const templateColumns = `tenant_id, id, name, description, schema, default_config, variables, created_at, updated_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// validateTemplate ensures the template is valid before storage operations
+func validateTemplate(template *config.Template) error {
+	if template == nil {
+		return config.NewError("validate template", config.ErrInvalidTemplate, "template is nil")
+	}
+	return validateInput("validate template", map[string]string{
+		"template ID": template.ID,
+		"tenant ID":   template.TenantID,
+		"name":        template.Name,
+	})
+}
+
+// scanTemplate decodes a single template row
+func scanTemplate(row rowScanner) (*config.Template, error) {
+	var (
+		t                      config.Template
+		schema, def, variables []byte
+	)
+	if err := row.Scan(&t.TenantID, &t.ID, &t.Name, &t.Description,
+		&schema, &def, &variables, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	t.Schema = rawJSON(schema)
+	t.Default = rawJSON(def)
+	t.Variables = make([]config.Variable, 0)
+	if len(variables) > 0 {
+		if err := json.Unmarshal(variables, &t.Variables); err != nil {
+			return nil, err
+		}
+	}
+	t.CreatedAt = t.CreatedAt.UTC()
+	t.UpdatedAt = t.UpdatedAt.UTC()
+	return &t, nil
+}
+
+// encodeVariables serializes template variables for storage
+func encodeVariables(variables []config.Variable) (string, error) {
+	if variables == nil {
+		variables = []config.Variable{}
+	}
+	data, err := json.Marshal(variables)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// CreateTemplate stores a new configuration template
+func (s *Store) CreateTemplate(ctx context.Context, template *config.Template) error {
+	if err := validateTemplate(template); err != nil {
+		return err
+	}
+
+	variables, err := encodeVariables(template.Variables)
+	if err != nil {
+		return storeError("create template", "failed to encode variables", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO config_templates (`+templateColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		template.TenantID, template.ID, template.Name, template.Description,
+		nullJSON(template.Schema), nullJSON(template.Default), variables,
+		template.CreatedAt, template.UpdatedAt)
+	if isUniqueViolation(err) {
+		return config.NewError("create template", config.ErrInvalidTemplate, "template already exists")
+	}
+	if err != nil {
+		return storeError("create template", "failed to insert template", err)
+	}
+	return nil
+}
+
+// GetTemplate retrieves a specific configuration template
+func (s *Store) GetTemplate(ctx context.Context, tenantID, templateID string) (*config.Template, error) {
+	if err := validateInput("get template", map[string]string{
+		"tenant ID":   tenantID,
+		"template ID": templateID,
+	}); err != nil {
+		return nil, err
+	}
+
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+templateColumns+` FROM config_templates WHERE tenant_id = $1 AND id = $2`,
+		tenantID, templateID)
+	template, err := scanTemplate(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, config.NewError("get template", config.ErrTemplateNotFound, "template not found")
+	}
+	if err != nil {
+		return nil, storeError("get template", "failed to read template", err)
+	}
+	return template, nil
+}
+
+// UpdateTemplate updates an existing configuration template
+func (s *Store) UpdateTemplate(ctx context.Context, template *config.Template) error {
+	if err := validateTemplate(template); err != nil {
+		return err
+	}
+
+	variables, err := encodeVariables(template.Variables)
+	if err != nil {
+		return storeError("update template", "failed to encode variables", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE config_templates
+		SET name = $3, description = $4, schema = $5, default_config = $6,
+			variables = $7, created_at = $8, updated_at = $9
+		WHERE tenant_id = $1 AND id = $2`,
+		template.TenantID, template.ID, template.Name, template.Description,
+		nullJSON(template.Schema), nullJSON(template.Default), variables,
+		template.CreatedAt, template.UpdatedAt)
+	if err != nil {
+		return storeError("update template", "failed to update template", err)
+	}
+	return requireAffected(result, "update template", config.ErrTemplateNotFound, "template not found")
+}
+
+// DeleteTemplate removes a configuration template and its versions
+func (s *Store) DeleteTemplate(ctx context.Context, tenantID, templateID string) error {
+	if err := validateInput("delete template", map[string]string{
+		"tenant ID":   tenantID,
+		"template ID": templateID,
+	}); err != nil {
+		return err
+	}
+
+	// Versions are removed by the ON DELETE CASCADE foreign key
+	result, err := s.db.ExecContext(ctx,
+		`DELETE FROM config_templates WHERE tenant_id = $1 AND id = $2`, tenantID, templateID)
+	if err != nil {
+		return storeError("delete template", "failed to delete template", err)
+	}
+	return requireAffected(result, "delete template", config.ErrTemplateNotFound, "template not found")
+}
+
+// ListTemplates retrieves templates matching the given criteria
+func (s *Store) ListTemplates(ctx context.Context, opts config.ListOptions) ([]*config.Template, error) {
+	var qb queryBuilder
+	if opts.TenantID != "" {
+		qb.where("tenant_id = ?", opts.TenantID)
+	}
+	query, args := qb.build(`SELECT `+templateColumns+` FROM config_templates`, "created_at, id", opts)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, storeError("list templates", "failed to query templates", err)
+	}
+	defer rows.Close()
+
+	templates := make([]*config.Template, 0)
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, storeError("list templates", "failed to read template", err)
+		}
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storeError("list templates", "failed to read templates", err)
+	}
+	return templates, nil
+}
+
+// requireAffected maps an update or delete that matched no rows to a
+// not-found error.
+func requireAffected(result sql.Result, op string, code config.ErrorCode, message string) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return storeError(op, "failed to read affected rows", err)
+	}
+	if n == 0 {
+		return config.NewError(op, code, message)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+)
+
+// versionColumns lists the columns read by scanVersion, in order
+const versionColumns = `template_id, number, config, hash, created_at, created_by, validated_at, status, validation_errors`
+
+// scanVersion decodes a single version row
+func scanVersion(row rowScanner) (*config.Version, error) {
+	var (
+		v           config.Version
+		cfg         []byte
+		validatedAt sql.NullTime
+		status      string
+		violations  []byte
+	)
+	if err := row.Scan(&v.TemplateID, &v.Number, &cfg, &v.Hash,
+		&v.CreatedAt, &v.CreatedBy, &validatedAt, &status, &violations); err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		if err := json.Unmarshal(violations, &v.ValidationErrors); err != nil {
+			return nil, err
+		}
+	}
+	if len(v.ValidationErrors) == 0 {
+		v.ValidationErrors = nil
+	}
+
+	v.Config = rawJSON(cfg)
+	v.CreatedAt = v.CreatedAt.UTC()
+	v.Status = config.ValidationStatus(status)
+	if validatedAt.Valid {
+		t := validatedAt.Time.UTC()
+		v.ValidatedAt = &t
+	}
+	return &v, nil
+}
+
+// nullTime converts an optional timestamp into a column value
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+// encodeViolations serializes validation errors for storage
+func encodeViolations(violations []config.Violation) (string, error) {
+	if violations == nil {
+		violations = []config.Violation{}
+	}
+	data, err := json.Marshal(violations)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// CreateVersion stores a new configuration version. The version number is
+// assigned from the highest existing number while the template row is
+// locked, so concurrent writers always receive consecutive numbers.
+func (s *Store) CreateVersion(ctx context.Context, tenantID, templateID string, version *config.Version) error {
+	if err := validateInput("create version", map[string]string{
+		"tenant ID":   tenantID,
+		"template ID": templateID,
+	}); err != nil {
+		return err
+	}
+	if version == nil {
+		return config.NewError("create version", config.ErrInvalidVersion, "version is nil")
+	}
+	violations, err := encodeViolations(version.ValidationErrors)
+	if err != nil {
+		return storeError("create version", "failed to encode validation errors", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storeError("create version", "failed to begin transaction", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	var locked string
+	err = tx.QueryRowContext(ctx,
+		`SELECT id FROM config_templates WHERE tenant_id = $1 AND id = $2 FOR UPDATE`,
+		tenantID, templateID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return config.NewError("create version", config.ErrTemplateNotFound, "template not found")
+	}
+	if err != nil {
+		return storeError("create version", "failed to lock template", err)
+	}
+
+	var number int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(number), 0) + 1 FROM config_versions WHERE tenant_id = $1 AND template_id = $2`,
+		tenantID, templateID).Scan(&number); err != nil {
+		return storeError("create version", "failed to allocate version number", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO config_versions (tenant_id, `+versionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		tenantID, templateID, number, nullJSON(version.Config), version.Hash,
+		version.CreatedAt, version.CreatedBy, nullTime(version.ValidatedAt), string(version.Status),
+		violations); err != nil {
+		return storeError("create version", "failed to insert version", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return storeError("create version", "failed to commit version", err)
+	}
+
+	version.Number = number
+	return nil
+}
+
+// GetVersion retrieves a specific configuration version
+func (s *Store) GetVersion(ctx context.Context, tenantID, templateID string, versionNumber int) (*config.Version, error) {
+	if err := validateInput("get version", map[string]string{
+		"tenant ID":   tenantID,
+		"template ID": templateID,
+	}); err != nil {
+		return nil, err
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+versionColumns+` FROM config_versions
+		WHERE tenant_id = $1 AND template_id = $2 AND number = $3`,
+		tenantID, templateID, versionNumber)
+	version, err := scanVersion(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, config.NewError("get version", config.ErrVersionNotFound, "version not found")
+	}
+	if err != nil {
+		return nil, storeError("get version", "failed to read version", err)
+	}
+	return version, nil
+}
+
+// ListVersions retrieves all versions for a template
+func (s *Store) ListVersions(ctx context.Context, tenantID, templateID string) ([]*config.Version, error) {
+	if err := validateInput("list versions", map[string]string{
+		"tenant ID":   tenantID,
+		"template ID": templateID,
+	}); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM config_templates WHERE tenant_id = $1 AND id = $2)`,
+		tenantID, templateID).Scan(&exists); err != nil {
+		return nil, storeError("list versions", "failed to read template", err)
+	}
+	if !exists {
+		return nil, config.NewError("list versions", config.ErrTemplateNotFound, "template not found")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+versionColumns+` FROM config_versions
+		WHERE tenant_id = $1 AND template_id = $2
+		ORDER BY number`,
+		tenantID, templateID)
+	if err != nil {
+		return nil, storeError("list versions", "failed to query versions", err)
+	}
+	defer rows.Close()
+
+	versions := make([]*config.Version, 0)
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, storeError("list versions", "failed to read version", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storeError("list versions", "failed to read versions", err)
+	}
+	return versions, nil
+}
+
+// UpdateVersion updates an existing configuration version
+func (s *Store) UpdateVersion(ctx context.Context, tenantID, templateID string, version *config.Version) error {
+	if err := validateInput("update version", map[string]string{
+		"tenant ID":   tenantID,
+		"template ID": templateID,
+	}); err != nil {
+		return err
+	}
+	if version == nil {
+		return config.NewError("update version", config.ErrInvalidVersion, "version is nil")
+	}
+	violations, err := encodeViolations(version.ValidationErrors)
+	if err != nil {
+		return storeError("update version", "failed to encode validation errors", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE config_versions
+		SET config = $4, hash = $5, created_at = $6, created_by = $7,
+			validated_at = $8, status = $9, validation_errors = $10
+		WHERE tenant_id = $1 AND template_id = $2 AND number = $3`,
+		tenantID, templateID, version.Number, nullJSON(version.Config), version.Hash,
+		version.CreatedAt, version.CreatedBy, nullTime(version.ValidatedAt), string(version.Status),
+		violations)
+	if err != nil {
+		return storeError("update version", "failed to update version", err)
+	}
+	return requireAffected(result, "update version", config.ErrVersionNotFound, "version not found")
+}
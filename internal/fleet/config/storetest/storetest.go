@@ -0,0 +1,561 @@
+// Package storetest provides a conformance suite for config.Store
+// implementations.
+//
+// Every backend should run the suite from its own tests so that all stores
+// share a single definition of correct behavior:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) config.Store {
+//			return memory.New()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+)
+
+// Factory creates an empty store for a single test. Implementations that
+// hold resources should release them through t.Cleanup.
+type Factory func(t *testing.T) config.Store
+
+// Run executes the full conformance suite against stores created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("Templates", func(t *testing.T) { testTemplates(t, factory(t)) })
+	t.Run("TemplatePagination", func(t *testing.T) { testTemplatePagination(t, factory(t)) })
+	t.Run("Versions", func(t *testing.T) { testVersions(t, factory(t)) })
+	t.Run("Deployments", func(t *testing.T) { testDeployments(t, factory(t)) })
+	t.Run("DeploymentFilters", func(t *testing.T) { testDeploymentFilters(t, factory(t)) })
+	t.Run("DeploymentPagination", func(t *testing.T) { testDeploymentPagination(t, factory(t)) })
+	t.Run("LatestDeployments", func(t *testing.T) { testLatestDeployments(t, factory(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, factory(t)) })
+	t.Run("ConcurrentVersions", func(t *testing.T) { testConcurrentVersions(t, factory(t)) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory(t)) })
+}
+
+// baseTime anchors fixture timestamps so ordering is deterministic
+var baseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTemplate builds a valid template with a deterministic ID
+func newTemplate(tenantID, id string, offset int) *config.Template {
+	t := config.NewTemplate(tenantID, "template "+id, json.RawMessage(`{"type":"object"}`))
+	t.ID = id
+	t.CreatedAt = baseTime.Add(time.Duration(offset) * time.Minute)
+	t.UpdatedAt = t.CreatedAt
+	return t
+}
+
+// newVersion builds a pending version for the given template
+func newVersion(templateID, body string) *config.Version {
+	return config.NewVersion(json.RawMessage(body), templateID, "storetest")
+}
+
+// newDeployment builds a pending deployment with a deterministic ID
+func newDeployment(tenantID, id, deviceID string, offset int) *config.Deployment {
+	v := newVersion("tmpl-1", `{"key":"value"}`)
+	v.Number = 1
+	d := config.NewDeployment(tenantID, deviceID, v)
+	d.ID = id
+	d.DeployedAt = baseTime.Add(time.Duration(offset) * time.Minute)
+	return d
+}
+
+// requireCode asserts that err is a *config.Error carrying the given code
+func requireCode(t *testing.T, err error, code config.ErrorCode) {
+	t.Helper()
+	require.Error(t, err)
+	var cfgErr *config.Error
+	require.True(t, errors.As(err, &cfgErr), "expected *config.Error, got %T: %v", err, err)
+	assert.Equal(t, code, cfgErr.Code)
+}
+
+func testTemplates(t *testing.T, store config.Store) {
+	ctx := context.Background()
+
+	tmpl := newTemplate("tenant-1", "tmpl-1", 0)
+	tmpl.Description = "conformance"
+	tmpl.Default = json.RawMessage(`{"interval":30}`)
+	require.NoError(t, tmpl.AddVariable(config.Variable{Name: "interval", Type: "integer", Required: true}))
+	require.NoError(t, store.CreateTemplate(ctx, tmpl))
+
+	got, err := store.GetTemplate(ctx, "tenant-1", "tmpl-1")
+	require.NoError(t, err)
+	assert.Equal(t, tmpl.Name, got.Name)
+	assert.Equal(t, tmpl.Description, got.Description)
+	assert.JSONEq(t, `{"type":"object"}`, string(got.Schema))
+	assert.JSONEq(t, `{"interval":30}`, string(got.Default))
+	require.Len(t, got.Variables, 1)
+	assert.Equal(t, "interval", got.Variables[0].Name)
+	assert.True(t, got.Variables[0].Required)
+
+	requireCode(t, store.CreateTemplate(ctx, newTemplate("tenant-1", "tmpl-1", 0)), config.ErrInvalidTemplate)
+
+	updated := newTemplate("tenant-1", "tmpl-1", 0)
+	updated.Name = "renamed"
+	require.NoError(t, store.UpdateTemplate(ctx, updated))
+	got, err = store.GetTemplate(ctx, "tenant-1", "tmpl-1")
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", got.Name)
+
+	requireCode(t, store.UpdateTemplate(ctx, newTemplate("tenant-1", "missing", 0)), config.ErrTemplateNotFound)
+
+	_, err = store.GetTemplate(ctx, "tenant-1", "missing")
+	requireCode(t, err, config.ErrTemplateNotFound)
+
+	_, err = store.GetTemplate(ctx, "", "tmpl-1")
+	requireCode(t, err, config.ErrValidationFailed)
+
+	// Deleting a template also removes its versions
+	require.NoError(t, store.CreateVersion(ctx, "tenant-1", "tmpl-1", newVersion("tmpl-1", `{"a":1}`)))
+	require.NoError(t, store.DeleteTemplate(ctx, "tenant-1", "tmpl-1"))
+
+	_, err = store.GetTemplate(ctx, "tenant-1", "tmpl-1")
+	requireCode(t, err, config.ErrTemplateNotFound)
+	_, err = store.GetVersion(ctx, "tenant-1", "tmpl-1", 1)
+	requireCode(t, err, config.ErrVersionNotFound)
+	requireCode(t, store.DeleteTemplate(ctx, "tenant-1", "tmpl-1"), config.ErrTemplateNotFound)
+}
+
+func testTemplatePagination(t *testing.T, store config.Store) {
+	ctx := context.Background()
+
+	// Insert out of order to verify results are sorted by creation time
+	for _, i := range []int{3, 1, 5, 2, 4} {
+		require.NoError(t, store.CreateTemplate(ctx, newTemplate("tenant-1", fmt.Sprintf("tmpl-%d", i), i)))
+	}
+
+	tests := []struct {
+		name    string
+		offset  int
+		limit   int
+		wantIDs []string
+	}{
+		{"no limit", 0, 0, []string{"tmpl-1", "tmpl-2", "tmpl-3", "tmpl-4", "tmpl-5"}},
+		{"first page", 0, 2, []string{"tmpl-1", "tmpl-2"}},
+		{"second page", 2, 2, []string{"tmpl-3", "tmpl-4"}},
+		{"last partial page", 4, 2, []string{"tmpl-5"}},
+		{"offset past end", 5, 2, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.ListTemplates(ctx, config.ListOptions{
+				TenantID: "tenant-1",
+				Offset:   tt.offset,
+				Limit:    tt.limit,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, templateIDs(got))
+		})
+	}
+}
+
+func testVersions(t *testing.T, store config.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.CreateTemplate(ctx, newTemplate("tenant-1", "tmpl-1", 0)))
+
+	versions, err := store.ListVersions(ctx, "tenant-1", "tmpl-1")
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+
+	// Version numbers are assigned by the store, sequentially from 1
+	for i, body := range []string{`{"v":1}`, `{"v":2}`, `{"v":3}`} {
+		v := newVersion("tmpl-1", body)
+		require.NoError(t, store.CreateVersion(ctx, "tenant-1", "tmpl-1", v))
+		assert.Equal(t, i+1, v.Number)
+	}
+
+	v2, err := store.GetVersion(ctx, "tenant-1", "tmpl-1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, v2.Number)
+	assert.JSONEq(t, `{"v":2}`, string(v2.Config))
+	assert.Equal(t, config.ValidationStatusPending, v2.Status)
+	assert.NotEmpty(t, v2.Hash)
+
+	versions, err = store.ListVersions(ctx, "tenant-1", "tmpl-1")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, v := range versions {
+		assert.Equal(t, i+1, v.Number)
+	}
+
+	now := time.Now().UTC()
+	v2.Status = config.ValidationStatusValid
+	v2.ValidatedAt = &now
+	require.NoError(t, store.UpdateVersion(ctx, "tenant-1", "tmpl-1", v2))
+
+	got, err := store.GetVersion(ctx, "tenant-1", "tmpl-1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, config.ValidationStatusValid, got.Status)
+	require.NotNil(t, got.ValidatedAt)
+	assert.Empty(t, got.ValidationErrors)
+
+	// Validation errors are kept with an invalid version
+	v3, err := store.GetVersion(ctx, "tenant-1", "tmpl-1", 3)
+	require.NoError(t, err)
+	v3.Status = config.ValidationStatusInvalid
+	v3.ValidatedAt = &now
+	v3.ValidationErrors = []config.Violation{{Path: "/port", Keyword: "minimum", Message: "must be at least 1"}}
+	require.NoError(t, store.UpdateVersion(ctx, "tenant-1", "tmpl-1", v3))
+
+	got, err = store.GetVersion(ctx, "tenant-1", "tmpl-1", 3)
+	require.NoError(t, err)
+	assert.Equal(t, config.ValidationStatusInvalid, got.Status)
+	assert.Equal(t, v3.ValidationErrors, got.ValidationErrors)
+
+	_, err = store.GetVersion(ctx, "tenant-1", "tmpl-1", 4)
+	requireCode(t, err, config.ErrVersionNotFound)
+	_, err = store.GetVersion(ctx, "tenant-1", "tmpl-1", 0)
+	requireCode(t, err, config.ErrVersionNotFound)
+
+	missing := newVersion("tmpl-1", `{}`)
+	missing.Number = 9
+	requireCode(t, store.UpdateVersion(ctx, "tenant-1", "tmpl-1", missing), config.ErrVersionNotFound)
+
+	requireCode(t, store.CreateVersion(ctx, "tenant-1", "missing", newVersion("missing", `{}`)), config.ErrTemplateNotFound)
+	_, err = store.ListVersions(ctx, "tenant-1", "missing")
+	requireCode(t, err, config.ErrTemplateNotFound)
+}
+
+func testDeployments(t *testing.T, store config.Store) {
+	ctx := context.Background()
+
+	d := newDeployment("tenant-1", "dep-1", "dev-1", 0)
+	require.NoError(t, store.CreateDeployment(ctx, d))
+
+	got, err := store.GetDeployment(ctx, "tenant-1", "dep-1")
+	require.NoError(t, err)
+	assert.Equal(t, "dev-1", got.DeviceID)
+	assert.Equal(t, "pending", got.Status)
+	require.NotNil(t, got.ConfigVersion)
+	assert.Equal(t, 1, got.ConfigVersion.Number)
+	assert.Equal(t, d.ConfigVersion.Hash, got.ConfigVersion.Hash)
+
+	requireCode(t, store.CreateDeployment(ctx, newDeployment("tenant-1", "dep-1", "dev-1", 0)), config.ErrInvalidDeployment)
+
+	completed := time.Now().UTC()
+	got.Status = "failed"
+	got.Error = "apply failed"
+	got.CompletedAt = &completed
+	got.RolledBack = true
+	require.NoError(t, store.UpdateDeployment(ctx, got))
+
+	got, err = store.GetDeployment(ctx, "tenant-1", "dep-1")
+	require.NoError(t, err)
+	assert.Equal(t, "failed", got.Status)
+	assert.Equal(t, "apply failed", got.Error)
+	assert.True(t, got.RolledBack)
+	require.NotNil(t, got.CompletedAt)
+
+	superseded := newDeployment("tenant-1", "dep-3", "dev-1", 1)
+	require.NoError(t, store.CreateDeployment(ctx, superseded))
+	superseded.Supersede("dep-1")
+	require.NoError(t, store.UpdateDeployment(ctx, superseded))
+
+	got, err = store.GetDeployment(ctx, "tenant-1", "dep-3")
+	require.NoError(t, err)
+	assert.Equal(t, config.DeploymentStatusSuperseded, got.Status)
+	assert.Equal(t, "dep-1", got.SupersededBy)
+
+	_, err = store.GetDeployment(ctx, "tenant-1", "missing")
+	requireCode(t, err, config.ErrDeploymentNotFound)
+	requireCode(t, store.UpdateDeployment(ctx, newDeployment("tenant-1", "missing", "dev-1", 0)), config.ErrDeploymentNotFound)
+	requireCode(t, store.CreateDeployment(ctx, newDeployment("tenant-1", "dep-2", "", 0)), config.ErrValidationFailed)
+}
+
+func testDeploymentFilters(t *testing.T, store config.Store) {
+	ctx := context.Background()
+
+	fixtures := []struct {
+		id, device, status string
+	}{
+		{"dep-1", "dev-1", "pending"},
+		{"dep-2", "dev-1", "completed"},
+		{"dep-3", "dev-2", "completed"},
+		{"dep-4", "dev-2", "failed"},
+	}
+	for i, f := range fixtures {
+		d := newDeployment("tenant-1", f.id, f.device, i)
+		d.Status = f.status
+		require.NoError(t, store.CreateDeployment(ctx, d))
+	}
+
+	tests := []struct {
+		name    string
+		opts    config.ListOptions
+		wantIDs []string
+	}{
+		{"all", config.ListOptions{TenantID: "tenant-1"}, []string{"dep-1", "dep-2", "dep-3", "dep-4"}},
+		{"device", config.ListOptions{TenantID: "tenant-1", DeviceID: "dev-2"}, []string{"dep-3", "dep-4"}},
+		{"status", config.ListOptions{TenantID: "tenant-1", Status: "completed"}, []string{"dep-2", "dep-3"}},
+		{"device and status", config.ListOptions{TenantID: "tenant-1", DeviceID: "dev-1", Status: "pending"}, []string{"dep-1"}},
+		{"no match", config.ListOptions{TenantID: "tenant-1", DeviceID: "dev-9"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.ListDeployments(ctx, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, deploymentIDs(got))
+		})
+	}
+}
+
+func testDeploymentPagination(t *testing.T, store config.Store) {
+	ctx := context.Background()
+
+	// Insert out of order to verify results are sorted by deployment time
+	for _, i := range []int{3, 1, 5, 2, 4} {
+		require.NoError(t, store.CreateDeployment(ctx, newDeployment("tenant-1", fmt.Sprintf("dep-%d", i), "dev-1", i)))
+	}
+
+	tests := []struct {
+		name    string
+		offset  int
+		limit   int
+		wantIDs []string
+	}{
+		{"no limit", 0, 0, []string{"dep-1", "dep-2", "dep-3", "dep-4", "dep-5"}},
+		{"first page", 0, 2, []string{"dep-1", "dep-2"}},
+		{"second page", 2, 2, []string{"dep-3", "dep-4"}},
+		{"last partial page", 4, 2, []string{"dep-5"}},
+		{"offset past end", 5, 2, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.ListDeployments(ctx, config.ListOptions{
+				TenantID: "tenant-1",
+				Offset:   tt.offset,
+				Limit:    tt.limit,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, deploymentIDs(got))
+		})
+	}
+}
+
+func testLatestDeployments(t *testing.T, store config.Store) {
+	ctx := context.Background()
+
+	fixtures := []struct {
+		tenant, id, device, status string
+		offset                     int
+	}{
+		{"tenant-1", "dep-1", "dev-1", "completed", 0},
+		{"tenant-1", "dep-2", "dev-2", "completed", 1},
+		{"tenant-1", "dep-3", "dev-1", "failed", 2},
+		{"tenant-1", "dep-4", "dev-3", "pending", 3},
+		{"tenant-1", "dep-5", "dev-2", "completed", 4},
+		{"tenant-2", "dep-6", "dev-1", "completed", 5},
+		// Same deployment time as dep-5; the higher ID is the latest
+		{"tenant-1", "dep-7", "dev-2", "pending", 4},
+	}
+	for _, f := range fixtures {
+		d := newDeployment(f.tenant, f.id, f.device, f.offset)
+		d.Status = f.status
+		require.NoError(t, store.CreateDeployment(ctx, d))
+	}
+
+	tests := []struct {
+		name    string
+		opts    config.ListOptions
+		wantIDs []string
+	}{
+		{"all tenants", config.ListOptions{}, []string{"dep-3", "dep-4", "dep-7", "dep-6"}},
+		{"tenant", config.ListOptions{TenantID: "tenant-1"}, []string{"dep-3", "dep-4", "dep-7"}},
+		{"status", config.ListOptions{TenantID: "tenant-1", Status: "completed"}, []string{"dep-1", "dep-5"}},
+		{"device", config.ListOptions{TenantID: "tenant-1", DeviceID: "dev-1"}, []string{"dep-3"}},
+		{"page", config.ListOptions{TenantID: "tenant-1", Offset: 1, Limit: 1}, []string{"dep-4"}},
+		{"no match", config.ListOptions{TenantID: "tenant-1", DeviceID: "dev-9"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.LatestDeployments(ctx, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, deploymentIDs(got))
+		})
+	}
+}
+
+func testTenantIsolation(t *testing.T, store config.Store) {
+	ctx := context.Background()
+
+	t1 := newTemplate("tenant-1", "shared", 0)
+	t1.Name = "tenant one"
+	t2 := newTemplate("tenant-2", "shared", 1)
+	t2.Name = "tenant two"
+	require.NoError(t, store.CreateTemplate(ctx, t1))
+	require.NoError(t, store.CreateTemplate(ctx, t2))
+	require.NoError(t, store.CreateTemplate(ctx, newTemplate("tenant-1", "only-1", 2)))
+
+	got, err := store.GetTemplate(ctx, "tenant-2", "shared")
+	require.NoError(t, err)
+	assert.Equal(t, "tenant two", got.Name)
+
+	_, err = store.GetTemplate(ctx, "tenant-2", "only-1")
+	requireCode(t, err, config.ErrTemplateNotFound)
+	requireCode(t, store.DeleteTemplate(ctx, "tenant-2", "only-1"), config.ErrTemplateNotFound)
+
+	// Version numbering is independent per tenant
+	require.NoError(t, store.CreateVersion(ctx, "tenant-1", "shared", newVersion("shared", `{"t":1}`)))
+	require.NoError(t, store.CreateVersion(ctx, "tenant-1", "shared", newVersion("shared", `{"t":1}`)))
+	v := newVersion("shared", `{"t":2}`)
+	require.NoError(t, store.CreateVersion(ctx, "tenant-2", "shared", v))
+	assert.Equal(t, 1, v.Number)
+
+	_, err = store.GetVersion(ctx, "tenant-2", "shared", 2)
+	requireCode(t, err, config.ErrVersionNotFound)
+	requireCode(t, store.CreateVersion(ctx, "tenant-2", "only-1", newVersion("only-1", `{}`)), config.ErrTemplateNotFound)
+
+	templates, err := store.ListTemplates(ctx, config.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shared", "only-1"}, templateIDs(templates))
+
+	require.NoError(t, store.CreateDeployment(ctx, newDeployment("tenant-1", "dep-1", "dev-1", 0)))
+	require.NoError(t, store.CreateDeployment(ctx, newDeployment("tenant-2", "dep-1", "dev-1", 1)))
+
+	_, err = store.GetDeployment(ctx, "tenant-3", "dep-1")
+	requireCode(t, err, config.ErrDeploymentNotFound)
+	requireCode(t, store.UpdateDeployment(ctx, newDeployment("tenant-3", "dep-1", "dev-1", 0)), config.ErrDeploymentNotFound)
+
+	deployments, err := store.ListDeployments(ctx, config.ListOptions{TenantID: "tenant-2"})
+	require.NoError(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, "tenant-2", deployments[0].TenantID)
+}
+
+func testConcurrentVersions(t *testing.T, store config.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.CreateTemplate(ctx, newTemplate("tenant-1", "tmpl-1", 0)))
+
+	const workers = 20
+	var wg sync.WaitGroup
+	numbers := make(chan int, workers)
+	errs := make(chan error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v := newVersion("tmpl-1", fmt.Sprintf(`{"writer":%d}`, i))
+			if err := store.CreateVersion(ctx, "tenant-1", "tmpl-1", v); err != nil {
+				errs <- err
+				return
+			}
+			numbers <- v.Number
+		}(i)
+	}
+
+	waitOrFail(t, &wg)
+	close(numbers)
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Concurrent creation must yield a gapless, duplicate-free sequence
+	got := make([]int, 0, workers)
+	for n := range numbers {
+		got = append(got, n)
+	}
+	sort.Ints(got)
+	want := make([]int, workers)
+	for i := range want {
+		want[i] = i + 1
+	}
+	assert.Equal(t, want, got)
+
+	versions, err := store.ListVersions(ctx, "tenant-1", "tmpl-1")
+	require.NoError(t, err)
+	assert.Len(t, versions, workers)
+}
+
+func testConcurrency(t *testing.T, store config.Store) {
+	ctx := context.Background()
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*3)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := fmt.Sprintf("%02d", i)
+			if err := store.CreateTemplate(ctx, newTemplate("tenant-1", "tmpl-"+id, i)); err != nil {
+				errs <- fmt.Errorf("create template: %w", err)
+			}
+			if err := store.CreateDeployment(ctx, newDeployment("tenant-1", "dep-"+id, "dev-1", i)); err != nil {
+				errs <- fmt.Errorf("create deployment: %w", err)
+			}
+			if _, err := store.ListDeployments(ctx, config.ListOptions{TenantID: "tenant-1"}); err != nil {
+				errs <- fmt.Errorf("list deployments: %w", err)
+			}
+		}(i)
+	}
+
+	waitOrFail(t, &wg)
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	templates, err := store.ListTemplates(ctx, config.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Len(t, templates, workers)
+
+	deployments, err := store.ListDeployments(ctx, config.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Len(t, deployments, workers)
+}
+
+// waitOrFail waits for wg, failing the test if it does not finish in time
+func waitOrFail(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for concurrent operations")
+	}
+}
+
+// templateIDs extracts template IDs preserving order
+func templateIDs(templates []*config.Template) []string {
+	result := make([]string, 0, len(templates))
+	for _, t := range templates {
+		result = append(result, t.ID)
+	}
+	return result
+}
+
+// deploymentIDs extracts deployment IDs preserving order
+func deploymentIDs(deployments []*config.Deployment) []string {
+	result := make([]string, 0, len(deployments))
+	for _, d := range deployments {
+		result = append(result, d.ID)
+	}
+	return result
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config/schema"
+)
+
+// Violation describes one way a configuration fails its template schema or
+// variable rules.
+type Violation struct {
+	// Path is a JSON pointer to the offending value, "" for the document
+	Path string `json:"path"`
+
+	// Keyword is the JSON Schema keyword that failed, such as "minimum"
+	Keyword string `json:"keyword,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// String renders the violation as "path: message".
+func (v Violation) String() string {
+	path := v.Path
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + v.Message
+}
+
+// ValidateConfig checks a configuration document against the template
+// schema (JSON Schema draft 2020-12) and its variable rules, and returns
+// every violation found ordered by path. An empty result means the
+// configuration is valid. The error is only set when the template itself
+// is invalid.
+//
+// Variables are addressed in the configuration by their dot-separated
+// name, so variable "network.port" is the value at /network/port. A
+// required variable must be present; when present, its value must be of the
+// variable's JSON Schema type and match its Validation schema fragment.
+func (t *Template) ValidateConfig(config json.RawMessage) ([]Violation, error) {
+	s, err := t.compileSchema("validate config")
+	if err != nil {
+		return nil, err
+	}
+	rules, err := t.compileVariables("validate config")
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := schema.Decode(config)
+	if err != nil {
+		return []Violation{{Message: "configuration is not valid JSON: " + err.Error()}}, nil
+	}
+
+	var violations []Violation
+	seen := make(map[Violation]bool)
+	add := func(v Violation) {
+		if !seen[v] {
+			seen[v] = true
+			violations = append(violations, v)
+		}
+	}
+
+	for _, e := range s.Validate(doc) {
+		add(Violation{Path: e.InstanceLocation, Keyword: e.Keyword, Message: e.Message})
+	}
+	for _, rule := range rules {
+		value, ok := lookupPath(doc, rule.segments)
+		if !ok {
+			if rule.variable.Required {
+				add(Violation{
+					Path:    rule.pointer,
+					Keyword: "required",
+					Message: fmt.Sprintf("missing required variable %q", rule.variable.Name),
+				})
+			}
+			continue
+		}
+		for _, s := range []*schema.Schema{rule.typ, rule.validation} {
+			if s == nil {
+				continue
+			}
+			for _, e := range s.Validate(value) {
+				add(Violation{Path: rule.pointer + e.InstanceLocation, Keyword: e.Keyword, Message: e.Message})
+			}
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return violations, nil
+}
+
+// variableRule is a template variable compiled for validation.
+type variableRule struct {
+	variable Variable
+	segments []string
+	pointer  string
+
+	// typ checks the variable type and validation its Validation fragment;
+	// either is nil when the variable does not set it
+	typ        *schema.Schema
+	validation *schema.Schema
+}
+
+// compileSchema compiles the template schema, which must be a JSON object.
+func (t *Template) compileSchema(op string) (*schema.Schema, error) {
+	doc, err := schema.Decode(t.Schema)
+	if err != nil {
+		return nil, &Error{Op: op, Code: ErrInvalidTemplate, Message: "template schema is not valid JSON", Err: err}
+	}
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return nil, NewError(op, ErrInvalidTemplate, "template schema must be a JSON object")
+	}
+	s, err := schema.CompileValue(doc)
+	if err != nil {
+		return nil, &Error{Op: op, Code: ErrInvalidTemplate, Message: "template schema is not a valid JSON Schema: " + err.Error(), Err: err}
+	}
+	return s, nil
+}
+
+// compileVariables compiles the type and validation fragment of each
+// variable.
+func (t *Template) compileVariables(op string) ([]variableRule, error) {
+	rules := make([]variableRule, 0, len(t.Variables))
+	for _, v := range t.Variables {
+		invalid := func(format string, args ...interface{}) error {
+			return NewError(op, ErrInvalidTemplate, fmt.Sprintf("variable %q: ", v.Name)+fmt.Sprintf(format, args...)).
+				WithField("variable", v.Name)
+		}
+
+		segments := strings.Split(v.Name, ".")
+		for _, s := range segments {
+			if s == "" {
+				return nil, invalid("name must be a dot-separated path")
+			}
+		}
+
+		rule := variableRule{variable: v, segments: segments}
+		for _, s := range segments {
+			rule.pointer += "/" + escapePointer(s)
+		}
+
+		if v.Type != "" {
+			s, err := schema.CompileValue(map[string]interface{}{"type": v.Type})
+			if err != nil {
+				return nil, invalid("%v", err)
+			}
+			rule.typ = s
+		}
+		if v.Validation != "" {
+			doc, err := schema.Decode([]byte(v.Validation))
+			if err != nil {
+				return nil, invalid("validation is not valid JSON: %v", err)
+			}
+			if _, ok := doc.(map[string]interface{}); !ok {
+				return nil, invalid("validation must be a JSON Schema object")
+			}
+			s, err := schema.CompileValue(doc)
+			if err != nil {
+				return nil, invalid("%v", err)
+			}
+			rule.validation = s
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// lookupPath returns the value at a path of object members.
+func lookupPath(doc interface{}, segments []string) (interface{}, bool) {
+	for _, s := range segments {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = obj[s]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// escapePointer escapes a property name for use in a JSON pointer.
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,61 @@
+package expr
+
+import "fmt"
+
+// Error codes for the expr package
+const (
+	ErrCodeSyntax       = "INVALID_EXPRESSION"
+	ErrCodeUnknownField = "UNKNOWN_FIELD"
+	ErrCodeType         = "TYPE_MISMATCH"
+)
+
+// FieldPosition is the error field holding the byte offset in the
+// expression the error refers to
+const FieldPosition = "position"
+
+// Error represents an expression compilation error
+type Error struct {
+	Code    string
+	Message string
+	Op      string
+	Err     error
+	Fields  map[string]interface{}
+}
+
+// Error returns the string representation of the error
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Op, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Op, e.Message)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithField adds a field to the error
+func (e *Error) WithField(key string, value interface{}) *Error {
+	if e.Fields == nil {
+		e.Fields = make(map[string]interface{})
+	}
+	e.Fields[key] = value
+	return e
+}
+
+// E creates a new Error
+func E(op, code, message string, err error) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+		Op:      op,
+		Err:     err,
+	}
+}
+
+// errorAt creates an Error pointing at a position in the expression
+func errorAt(code string, pos int, format string, args ...interface{}) *Error {
+	return E("expr.Compile", code, fmt.Sprintf("at position %d: %s", pos, fmt.Sprintf(format, args...)), nil).
+		WithField(FieldPosition, pos)
+}
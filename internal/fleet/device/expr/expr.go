@@ -0,0 +1,356 @@
+// Package expr implements a small typed expression language over device
+// fields, used to select devices for dynamic groups and device listings.
+//
+// An expression combines comparisons of device fields with &&, || and !:
+//
+//	tags.site == "plant-3" && status in ["online", "maintenance"] && network.hostname =~ "^pi-"
+//
+// Expressions are parsed and type checked once by Compile. The resulting
+// Program evaluates against devices without allocating or failing, so it
+// can be shared by anything matching devices.
+package expr
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+)
+
+// MaxLength is the longest expression Compile accepts
+const MaxLength = 4096
+
+// Type is the type of an expression value
+type Type string
+
+// Expression value types
+const (
+	TypeString Type = "string"
+	TypeInt    Type = "int"
+	TypeBool   Type = "bool"
+)
+
+// Program is a compiled expression. It is safe for concurrent use.
+type Program struct {
+	source string
+	match  func(*device.Device) bool
+}
+
+// Compile parses and type checks an expression. The expression must
+// evaluate to a boolean.
+func Compile(source string) (*Program, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, E("expr.Compile", ErrCodeSyntax, "expression cannot be empty", nil)
+	}
+	if len(source) > MaxLength {
+		return nil, E("expr.Compile", ErrCodeSyntax,
+			"expression is longer than "+strconv.Itoa(MaxLength)+" bytes", nil)
+	}
+
+	tree, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	v, err := compile(tree)
+	if err != nil {
+		return nil, err
+	}
+	if v.typ != TypeBool {
+		return nil, errorAt(ErrCodeType, tree.position(), "expression must be a condition, not %s", v.typ)
+	}
+	return &Program{source: source, match: v.boolean}, nil
+}
+
+// Match reports whether a device satisfies the expression. A nil device
+// never matches.
+func (p *Program) Match(d *device.Device) bool {
+	if d == nil {
+		return false
+	}
+	return p.match(d)
+}
+
+// String returns the source of the expression
+func (p *Program) String() string {
+	return p.source
+}
+
+// Fields lists the field names expressions can reference. Tag and network
+// metadata fields take the key after the prefix, as in tags.site.
+func Fields() []string {
+	names := make([]string, 0, len(fields)+2)
+	for name := range fields {
+		names = append(names, name)
+	}
+	names = append(names, "tags.<key>", "network.metadata.<key>")
+	sort.Strings(names)
+	return names
+}
+
+// value is a compiled, typed expression. Exactly one of the accessors is
+// set, matching typ.
+type value struct {
+	typ     Type
+	str     func(*device.Device) string
+	num     func(*device.Device) int64
+	boolean func(*device.Device) bool
+}
+
+// fields maps field names to their accessors
+var fields = map[string]value{
+	"id":               stringField(func(d *device.Device) string { return d.ID }),
+	"name":             stringField(func(d *device.Device) string { return d.Name }),
+	"status":           stringField(func(d *device.Device) string { return string(d.Status) }),
+	"security_version": stringField(func(d *device.Device) string { return d.SecurityVersion }),
+	"discovery_method": stringField(func(d *device.Device) string { return string(d.DiscoveryMethod) }),
+	"config_hash":      stringField(func(d *device.Device) string { return d.LastConfigHash }),
+	"secure_boot": {typ: TypeBool, boolean: func(d *device.Device) bool {
+		return d.SecureBootEnabled
+	}},
+	"network.hostname": networkField(func(n *device.NetworkInfo) string { return n.Hostname }),
+	"network.ip_address": networkField(func(n *device.NetworkInfo) string {
+		return n.IPAddress
+	}),
+	"network.mac_address": networkField(func(n *device.NetworkInfo) string {
+		return n.MACAddress
+	}),
+	"network.port": {typ: TypeInt, num: func(d *device.Device) int64 {
+		if d.NetworkInfo == nil {
+			return 0
+		}
+		return int64(d.NetworkInfo.Port)
+	}},
+}
+
+func stringField(get func(*device.Device) string) value {
+	return value{typ: TypeString, str: get}
+}
+
+// networkField reads a network field, which is empty for devices without
+// network information
+func networkField(get func(*device.NetworkInfo) string) value {
+	return stringField(func(d *device.Device) string {
+		if d.NetworkInfo == nil {
+			return ""
+		}
+		return get(d.NetworkInfo)
+	})
+}
+
+// resolveField looks up the accessor for a field path. Missing tags and
+// metadata keys read as the empty string.
+func resolveField(n *fieldNode) (value, error) {
+	switch {
+	case len(n.path) == 2 && n.path[0] == "tags":
+		key := n.path[1]
+		return stringField(func(d *device.Device) string { return d.Tags[key] }), nil
+	case len(n.path) == 3 && n.path[0] == "network" && n.path[1] == "metadata":
+		key := n.path[2]
+		return networkField(func(n *device.NetworkInfo) string { return n.Metadata[key] }), nil
+	}
+
+	name := strings.Join(n.path, ".")
+	if v, ok := fields[name]; ok {
+		return v, nil
+	}
+	return value{}, errorAt(ErrCodeUnknownField, n.pos, "unknown field %s", quote(name))
+}
+
+// compile type checks a node and builds its evaluator
+func compile(n node) (value, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		return constant(n), nil
+	case *fieldNode:
+		return resolveField(n)
+	case *notNode:
+		operand, err := compileBool(n.operand, "!")
+		if err != nil {
+			return value{}, err
+		}
+		return boolValue(func(d *device.Device) bool { return !operand(d) }), nil
+	case *inNode:
+		return compileIn(n)
+	case *binaryNode:
+		switch n.op {
+		case "&&", "||":
+			return compileLogical(n)
+		case "=~", "!~":
+			return compileRegexp(n)
+		default:
+			return compileComparison(n)
+		}
+	}
+	return value{}, errorAt(ErrCodeSyntax, n.position(), "unsupported expression")
+}
+
+// compileBool compiles a node that must be a condition
+func compileBool(n node, op string) (func(*device.Device) bool, error) {
+	v, err := compile(n)
+	if err != nil {
+		return nil, err
+	}
+	if v.typ != TypeBool {
+		return nil, errorAt(ErrCodeType, n.position(), "%s needs a condition, not %s", quote(op), v.typ)
+	}
+	return v.boolean, nil
+}
+
+func compileLogical(n *binaryNode) (value, error) {
+	left, err := compileBool(n.left, n.op)
+	if err != nil {
+		return value{}, err
+	}
+	right, err := compileBool(n.right, n.op)
+	if err != nil {
+		return value{}, err
+	}
+	if n.op == "&&" {
+		return boolValue(func(d *device.Device) bool { return left(d) && right(d) }), nil
+	}
+	return boolValue(func(d *device.Device) bool { return left(d) || right(d) }), nil
+}
+
+func compileRegexp(n *binaryNode) (value, error) {
+	left, err := compile(n.left)
+	if err != nil {
+		return value{}, err
+	}
+	if left.typ != TypeString {
+		return value{}, errorAt(ErrCodeType, n.left.position(), "%s needs a string, not %s", quote(n.op), left.typ)
+	}
+	pattern, ok := n.right.(*literalNode)
+	if !ok || pattern.typ != TypeString {
+		return value{}, errorAt(ErrCodeType, n.right.position(), "%s needs a string literal pattern", quote(n.op))
+	}
+	re, err := regexp.Compile(pattern.str)
+	if err != nil {
+		return value{}, E("expr.Compile", ErrCodeSyntax, "at position "+strconv.Itoa(pattern.pos)+": invalid pattern", err).
+			WithField(FieldPosition, pattern.pos)
+	}
+
+	get := left.str
+	if n.op == "=~" {
+		return boolValue(func(d *device.Device) bool { return re.MatchString(get(d)) }), nil
+	}
+	return boolValue(func(d *device.Device) bool { return !re.MatchString(get(d)) }), nil
+}
+
+func compileComparison(n *binaryNode) (value, error) {
+	left, err := compile(n.left)
+	if err != nil {
+		return value{}, err
+	}
+	right, err := compile(n.right)
+	if err != nil {
+		return value{}, err
+	}
+	if left.typ != right.typ {
+		return value{}, errorAt(ErrCodeType, n.pos, "cannot compare %s with %s", left.typ, right.typ)
+	}
+
+	ordered := n.op != "==" && n.op != "!="
+	if ordered && left.typ != TypeInt {
+		return value{}, errorAt(ErrCodeType, n.pos, "%s needs numbers, not %s", quote(n.op), left.typ)
+	}
+
+	switch left.typ {
+	case TypeString:
+		l, r := left.str, right.str
+		if n.op == "==" {
+			return boolValue(func(d *device.Device) bool { return l(d) == r(d) }), nil
+		}
+		return boolValue(func(d *device.Device) bool { return l(d) != r(d) }), nil
+	case TypeBool:
+		l, r := left.boolean, right.boolean
+		if n.op == "==" {
+			return boolValue(func(d *device.Device) bool { return l(d) == r(d) }), nil
+		}
+		return boolValue(func(d *device.Device) bool { return l(d) != r(d) }), nil
+	}
+
+	l, r := left.num, right.num
+	var cmp func(a, b int64) bool
+	switch n.op {
+	case "==":
+		cmp = func(a, b int64) bool { return a == b }
+	case "!=":
+		cmp = func(a, b int64) bool { return a != b }
+	case "<":
+		cmp = func(a, b int64) bool { return a < b }
+	case "<=":
+		cmp = func(a, b int64) bool { return a <= b }
+	case ">":
+		cmp = func(a, b int64) bool { return a > b }
+	default:
+		cmp = func(a, b int64) bool { return a >= b }
+	}
+	return boolValue(func(d *device.Device) bool { return cmp(l(d), r(d)) }), nil
+}
+
+func compileIn(n *inNode) (value, error) {
+	left, err := compile(n.value)
+	if err != nil {
+		return value{}, err
+	}
+	if left.typ == TypeBool {
+		return value{}, errorAt(ErrCodeType, n.pos, "\"in\" needs a string or number, not bool")
+	}
+	for _, item := range n.list {
+		if item.typ != left.typ {
+			return value{}, errorAt(ErrCodeType, item.pos, "list item is %s, expected %s", item.typ, left.typ)
+		}
+	}
+
+	var contains func(d *device.Device) bool
+	if left.typ == TypeString {
+		set := make(map[string]struct{}, len(n.list))
+		for _, item := range n.list {
+			set[item.str] = struct{}{}
+		}
+		get := left.str
+		contains = func(d *device.Device) bool {
+			_, ok := set[get(d)]
+			return ok
+		}
+	} else {
+		set := make(map[int64]struct{}, len(n.list))
+		for _, item := range n.list {
+			set[item.num] = struct{}{}
+		}
+		get := left.num
+		contains = func(d *device.Device) bool {
+			_, ok := set[get(d)]
+			return ok
+		}
+	}
+
+	if n.negate {
+		return boolValue(func(d *device.Device) bool { return !contains(d) }), nil
+	}
+	return boolValue(contains), nil
+}
+
+// constant compiles a literal
+func constant(n *literalNode) value {
+	switch n.typ {
+	case TypeString:
+		s := n.str
+		return stringField(func(*device.Device) string { return s })
+	case TypeInt:
+		i := n.num
+		return value{typ: TypeInt, num: func(*device.Device) int64 { return i }}
+	}
+	b := n.b
+	return boolValue(func(*device.Device) bool { return b })
+}
+
+func boolValue(f func(*device.Device) bool) value {
+	return value{typ: TypeBool, boolean: f}
+}
+
+func quote(s string) string {
+	return strconv.Quote(s)
+}
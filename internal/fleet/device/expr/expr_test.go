@@ -0,0 +1,106 @@
+package expr_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/expr"
+)
+
+func TestMatch(t *testing.T) {
+	pi := device.New("tenant-1", "pi-7")
+	pi.Status = device.StatusMaintenance
+	pi.Tags = map[string]string{"site": "plant-3", "rack id": "r1"}
+	pi.SecureBootEnabled = true
+	pi.NetworkInfo = &device.NetworkInfo{
+		Hostname: "pi-7.plant-3",
+		Port:     8443,
+		Metadata: map[string]string{"vlan": "20"},
+	}
+	bare := device.New("tenant-1", "gateway")
+	bare.Status = device.StatusOffline
+
+	tests := []struct {
+		source string
+		pi     bool
+		bare   bool
+	}{
+		{`tags.site == "plant-3" && status in ["online", "maintenance"] && network.hostname =~ "^pi-"`, true, false},
+		{`tags.site != "plant-3"`, false, true},
+		{`tags["rack id"] == "r1"`, true, false},
+		{`tags.missing == ""`, true, true},
+		{`status not in ["offline"]`, true, false},
+		{`status in []`, false, false},
+		{`name !~ "^pi-" || secure_boot`, true, true},
+		{`!(secure_boot == true)`, false, true},
+		{`network.port >= 8000 && network.port < 9000`, true, false},
+		{`network.port in [0, 22]`, false, true},
+		{`network.metadata.vlan == "20"`, true, false},
+		{`true || name == "x" && false`, true, true},
+		{`(true || name == "x") && false`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			prog, err := expr.Compile(tt.source)
+			require.NoError(t, err)
+			assert.Equal(t, tt.source, prog.String())
+			assert.Equal(t, tt.pi, prog.Match(pi), "pi")
+			assert.Equal(t, tt.bare, prog.Match(bare), "bare")
+			assert.False(t, prog.Match(nil))
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		code     string
+		position int
+		message  string
+	}{
+		{`name == "a" &&`, expr.ErrCodeSyntax, 14, "found end of expression"},
+		{`name == "unterminated`, expr.ErrCodeSyntax, 8, "unterminated string"},
+		{`name = "a"`, expr.ErrCodeSyntax, 5, `unexpected character '='`},
+		{`(name == "a"`, expr.ErrCodeSyntax, 12, `expected ")"`},
+		{`status in "online"`, expr.ErrCodeSyntax, 10, `expected "["`},
+		{`name == "a" name`, expr.ErrCodeSyntax, 12, `unexpected "name"`},
+		{`serial == "a"`, expr.ErrCodeUnknownField, 0, `unknown field "serial"`},
+		{`tags.a.b == "a"`, expr.ErrCodeUnknownField, 0, `unknown field "tags.a.b"`},
+		{`name`, expr.ErrCodeType, 0, "must be a condition"},
+		{`name == 3`, expr.ErrCodeType, 5, "cannot compare string with int"},
+		{`name < "b"`, expr.ErrCodeType, 5, `"<" needs numbers`},
+		{`network.port =~ "1"`, expr.ErrCodeType, 0, `"=~" needs a string`},
+		{`name =~ tags.pattern`, expr.ErrCodeType, 8, "string literal pattern"},
+		{`name =~ "("`, expr.ErrCodeSyntax, 8, "invalid pattern"},
+		{`status in ["online", 1]`, expr.ErrCodeType, 21, "list item is int, expected string"},
+		{`secure_boot in [true]`, expr.ErrCodeType, 12, `"in" needs a string or number`},
+		{`name == "a" && 1`, expr.ErrCodeType, 15, `"&&" needs a condition`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			_, err := expr.Compile(tt.source)
+			require.Error(t, err)
+
+			var exprErr *expr.Error
+			require.True(t, errors.As(err, &exprErr), "got %v", err)
+			assert.Equal(t, tt.code, exprErr.Code)
+			assert.Equal(t, tt.position, exprErr.Fields[expr.FieldPosition])
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+
+	_, err := expr.Compile("  ")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression cannot be empty")
+
+	deep := ""
+	for i := 0; i < 100; i++ {
+		deep += "!"
+	}
+	_, err = expr.Compile(deep + "secure_boot")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nests more than")
+}
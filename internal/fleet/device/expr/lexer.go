@@ -0,0 +1,135 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind classifies lexical tokens
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokOperator
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokDot
+)
+
+// token is a lexical token with the byte offset it starts at
+type token struct {
+	kind tokenKind
+	text string // identifier, operator or unquoted string value
+	num  int64
+	pos  int
+}
+
+// operators lists the operators, longest first so "==" wins over "="
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!"}
+
+// lex splits an expression into tokens
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			continue
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, pos: i})
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, pos: i})
+		case r == '[':
+			tokens = append(tokens, token{kind: tokLBracket, pos: i})
+		case r == ']':
+			tokens = append(tokens, token{kind: tokRBracket, pos: i})
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, pos: i})
+		case r == '.':
+			tokens = append(tokens, token{kind: tokDot, pos: i})
+		case r == '"':
+			tok, end, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			i = end
+			continue
+		case r >= '0' && r <= '9' || r == '-':
+			end := i + 1
+			for end < len(src) && src[end] >= '0' && src[end] <= '9' {
+				end++
+			}
+			n, err := strconv.ParseInt(src[i:end], 10, 64)
+			if err != nil {
+				return nil, errorAt(ErrCodeSyntax, i, "invalid number %q", src[i:end])
+			}
+			tokens = append(tokens, token{kind: tokInt, text: src[i:end], num: n, pos: i})
+			i = end
+			continue
+		case isIdentStart(r):
+			end := i + size
+			for end < len(src) {
+				r, size := utf8.DecodeRuneInString(src[end:])
+				if !isIdentPart(r) {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:end], pos: i})
+			i = end
+			continue
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, errorAt(ErrCodeSyntax, i, "unexpected character %q", r)
+			}
+			tokens = append(tokens, token{kind: tokOperator, text: op, pos: i})
+			i += len(op)
+			continue
+		}
+		i += size
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// lexString reads a double-quoted string with Go escape sequences starting
+// at start, returning the token and the offset after the closing quote
+func lexString(src string, start int) (token, int, error) {
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(src[start : i+1])
+			if err != nil {
+				return token{}, 0, errorAt(ErrCodeSyntax, start, "invalid string %s", src[start:i+1])
+			}
+			return token{kind: tokString, text: value, pos: start}, i + 1, nil
+		}
+	}
+	return token{}, 0, errorAt(ErrCodeSyntax, start, "unterminated string")
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,360 @@
+package expr
+
+// maxDepth bounds how deeply expressions may nest so a hostile expression
+// cannot exhaust the stack
+const maxDepth = 64
+
+// node is a parsed expression
+type node interface {
+	position() int
+}
+
+// binaryNode is a logical or comparison operation
+type binaryNode struct {
+	op          string
+	left, right node
+	pos         int
+}
+
+// notNode negates its operand
+type notNode struct {
+	operand node
+	pos     int
+}
+
+// inNode tests membership of a value in a list literal
+type inNode struct {
+	value  node
+	list   []*literalNode
+	negate bool
+	pos    int
+}
+
+// fieldNode references a device field by its path
+type fieldNode struct {
+	path []string
+	pos  int
+}
+
+// literalNode is a string, integer or boolean constant
+type literalNode struct {
+	typ Type
+	str string
+	num int64
+	b   bool
+	pos int
+}
+
+func (n *binaryNode) position() int  { return n.pos }
+func (n *notNode) position() int     { return n.pos }
+func (n *inNode) position() int      { return n.pos }
+func (n *fieldNode) position() int   { return n.pos }
+func (n *literalNode) position() int { return n.pos }
+
+// parser is a recursive descent parser over the token stream. The grammar,
+// loosest binding first:
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = operand [ compare-op operand | [ "not" ] "in" list ]
+//	operand    = literal | field | "(" or ")"
+//	field      = ident { "." ident | "[" string "]" }
+type parser struct {
+	tokens []token
+	next   int
+	depth  int
+}
+
+// parse parses a complete expression
+func parse(src string) (node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, errorAt(ErrCodeSyntax, tok.pos, "unexpected %s", describe(tok))
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokEOF {
+		p.next++
+	}
+	return tok
+}
+
+// acceptOp consumes the next token if it is the given operator
+func (p *parser) acceptOp(op string) (token, bool) {
+	tok := p.peek()
+	if tok.kind == tokOperator && tok.text == op {
+		return p.advance(), true
+	}
+	return tok, false
+}
+
+// expect consumes a token of the given kind or fails with what was wanted
+func (p *parser) expect(kind tokenKind, want string) (token, error) {
+	tok := p.advance()
+	if tok.kind != kind {
+		return tok, errorAt(ErrCodeSyntax, tok.pos, "expected %s, found %s", want, describe(tok))
+	}
+	return tok, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.acceptOp("||")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "||", left: left, right: right, pos: tok.pos}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.acceptOp("&&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "&&", left: left, right: right, pos: tok.pos}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	tok, ok := p.acceptOp("!")
+	if !ok {
+		return p.parseComparison()
+	}
+	if err := p.enter(tok); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &notNode{operand: operand, pos: tok.pos}, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch {
+	case tok.kind == tokOperator && isComparison(tok.text):
+		p.advance()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: tok.text, left: left, right: right, pos: tok.pos}, nil
+
+	case tok.kind == tokIdent && (tok.text == "in" || tok.text == "not"):
+		p.advance()
+		negate := tok.text == "not"
+		if negate {
+			if _, err := p.expectKeyword("in"); err != nil {
+				return nil, err
+			}
+		}
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{value: left, list: list, negate: negate, pos: tok.pos}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) expectKeyword(word string) (token, error) {
+	tok := p.advance()
+	if tok.kind != tokIdent || tok.text != word {
+		return tok, errorAt(ErrCodeSyntax, tok.pos, "expected %q, found %s", word, describe(tok))
+	}
+	return tok, nil
+}
+
+func (p *parser) parseList() ([]*literalNode, error) {
+	if _, err := p.expect(tokLBracket, `"["`); err != nil {
+		return nil, err
+	}
+	list := []*literalNode{}
+	if p.peek().kind == tokRBracket {
+		p.advance()
+		return list, nil
+	}
+	for {
+		tok := p.advance()
+		lit, ok := literal(tok)
+		if !ok {
+			return nil, errorAt(ErrCodeSyntax, tok.pos, "expected a literal in list, found %s", describe(tok))
+		}
+		list = append(list, lit)
+
+		tok = p.advance()
+		switch tok.kind {
+		case tokComma:
+			continue
+		case tokRBracket:
+			return list, nil
+		default:
+			return nil, errorAt(ErrCodeSyntax, tok.pos, `expected "," or "]", found %s`, describe(tok))
+		}
+	}
+}
+
+func (p *parser) parseOperand() (node, error) {
+	tok := p.advance()
+	if lit, ok := literal(tok); ok {
+		return lit, nil
+	}
+
+	switch tok.kind {
+	case tokLParen:
+		if err := p.enter(tok); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return inner, nil
+
+	case tokIdent:
+		if isKeyword(tok.text) {
+			break
+		}
+		field := &fieldNode{path: []string{tok.text}, pos: tok.pos}
+		for {
+			switch p.peek().kind {
+			case tokDot:
+				p.advance()
+				name, err := p.expect(tokIdent, "a field name")
+				if err != nil {
+					return nil, err
+				}
+				field.path = append(field.path, name.text)
+			case tokLBracket:
+				p.advance()
+				key, err := p.expect(tokString, "a quoted key")
+				if err != nil {
+					return nil, err
+				}
+				if _, err := p.expect(tokRBracket, `"]"`); err != nil {
+					return nil, err
+				}
+				field.path = append(field.path, key.text)
+			default:
+				return field, nil
+			}
+		}
+	}
+	return nil, errorAt(ErrCodeSyntax, tok.pos, "expected a field, literal or \"(\", found %s", describe(tok))
+}
+
+// enter records one more level of nesting
+func (p *parser) enter(tok token) error {
+	p.depth++
+	if p.depth > maxDepth {
+		return errorAt(ErrCodeSyntax, tok.pos, "expression nests more than %d levels deep", maxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// literal converts a literal token into a node
+func literal(tok token) (*literalNode, bool) {
+	switch {
+	case tok.kind == tokString:
+		return &literalNode{typ: TypeString, str: tok.text, pos: tok.pos}, true
+	case tok.kind == tokInt:
+		return &literalNode{typ: TypeInt, num: tok.num, pos: tok.pos}, true
+	case tok.kind == tokIdent && (tok.text == "true" || tok.text == "false"):
+		return &literalNode{typ: TypeBool, b: tok.text == "true", pos: tok.pos}, true
+	}
+	return nil, false
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+		return true
+	}
+	return false
+}
+
+func isKeyword(word string) bool {
+	switch word {
+	case "in", "not", "true", "false":
+		return true
+	}
+	return false
+}
+
+// describe names a token for error messages
+func describe(tok token) string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return "string " + quote(tok.text)
+	case tokInt:
+		return "number " + tok.text
+	case tokIdent, tokOperator:
+		return quote(tok.text)
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	case tokLBracket:
+		return `"["`
+	case tokRBracket:
+		return `"]"`
+	case tokComma:
+		return `","`
+	case tokDot:
+		return `"."`
+	}
+	return "token"
+}
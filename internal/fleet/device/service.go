@@ -4,6 +4,8 @@ package device
 import (
 	"context"
 	"fmt"
+	"sync"
+
 	"go.uber.org/zap"
 )
 
@@ -14,8 +16,15 @@ type Service struct {
 	store   Store
 	logger  *zap.Logger
 	monitor *SecurityMonitor
+
+	changeMu sync.RWMutex
+	onChange []ChangeFunc
 }
 
+// ChangeFunc is called after a device is registered, updated or deleted.
+// It runs synchronously with the change, so it should return quickly.
+type ChangeFunc func(ctx context.Context, tenantID, deviceID string)
+
 // NewService creates a new device management service with the provided
 // storage backend and logger. It initializes security monitoring for
 // audit and compliance tracking.
@@ -34,6 +43,32 @@ func (s *Service) Store() Store {
 	return s.store
 }
 
+// Monitor returns the security monitor recording device audit events.
+// Components outside the device service, such as API authentication, use it
+// to keep all security events in a single trail.
+func (s *Service) Monitor() *SecurityMonitor {
+	return s.monitor
+}
+
+// OnChange registers fn to be called after every device registration,
+// update and deletion made through the service.
+func (s *Service) OnChange(fn ChangeFunc) {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+// notifyChange calls the registered change functions
+func (s *Service) notifyChange(ctx context.Context, tenantID, deviceID string) {
+	s.changeMu.RLock()
+	handlers := s.onChange
+	s.changeMu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(ctx, tenantID, deviceID)
+	}
+}
+
 // CheckHealth performs health validation of the device service and its dependencies.
 // It implements the health.HealthChecker interface to participate in system-wide
 // health monitoring. This enables both connected and airgapped operation modes to
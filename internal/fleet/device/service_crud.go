@@ -9,30 +9,38 @@ import (
 
 // Register creates a new device in the system with proper tenant isolation.
 func (s *Service) Register(ctx context.Context, tenantID, name string) (*Device, error) {
-	if err := s.validateSecurityContext(ctx); err != nil {
+	device := New(tenantID, name)
+	if err := s.Create(ctx, device); err != nil {
 		return nil, err
 	}
+	return device, nil
+}
 
-	if err := s.validateTenantOperation(ctx, "Register", tenantID); err != nil {
-		return nil, err
+// Create stores a fully built device, such as one from New with further
+// fields set, in a single step with proper tenant isolation.
+func (s *Service) Create(ctx context.Context, device *Device) error {
+	if err := s.validateSecurityContext(ctx); err != nil {
+		return err
 	}
 
-	device := New(tenantID, name)
+	if err := s.validateTenantOperation(ctx, "Register", device.TenantID); err != nil {
+		return err
+	}
 
 	if err := s.validateDeviceUpdate(ctx, device); err != nil {
-		s.monitor.RecordAuthAttempt(ctx, "", tenantID, "system", false, map[string]string{
+		s.monitor.RecordAuthAttempt(ctx, "", device.TenantID, "system", false, map[string]string{
 			"action": "register",
 			"error":  err.Error(),
 		})
-		return nil, err
+		return err
 	}
 
 	if err := s.store.Create(ctx, device); err != nil {
-		s.monitor.RecordAuthAttempt(ctx, device.ID, tenantID, "system", false, map[string]string{
+		s.monitor.RecordAuthAttempt(ctx, device.ID, device.TenantID, "system", false, map[string]string{
 			"action": "register",
 			"error":  err.Error(),
 		})
-		return nil, fmt.Errorf("failed to create device: %w", err)
+		return fmt.Errorf("failed to create device: %w", err)
 	}
 
 	s.logInfo("Register",
@@ -43,8 +51,9 @@ func (s *Service) Register(ctx context.Context, tenantID, name string) (*Device,
 	s.recordDeviceAccess(ctx, device, "register", true, map[string]string{
 		"name": device.Name,
 	})
+	s.notifyChange(ctx, device.TenantID, device.ID)
 
-	return device, nil
+	return nil
 }
 
 // Get retrieves a device by ID with tenant validation.
@@ -103,6 +112,7 @@ func (s *Service) Delete(ctx context.Context, tenantID, deviceID string) error {
 	s.logInfo("Delete",
 		zap.String("device_id", deviceID),
 		zap.String("tenant_id", tenantID))
+	s.notifyChange(ctx, tenantID, deviceID)
 
 	return nil
 }
@@ -99,6 +99,7 @@ func (s *Service) Update(ctx context.Context, device *Device) error {
 		zap.String("device_id", device.ID),
 		zap.String("tenant_id", device.TenantID),
 		zap.Time("updated_at", device.UpdatedAt))
+	s.notifyChange(ctx, device.TenantID, device.ID)
 
 	return nil
 }
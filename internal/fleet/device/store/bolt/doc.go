@@ -0,0 +1,22 @@
+// Package bolt provides a durable, file-backed implementation of the
+// device.Store interface built on an embedded bbolt database.
+//
+// Devices are persisted as JSON documents in a single database file, so the
+// fleet inventory survives process restarts without requiring an external
+// database server. Tenant isolation is enforced structurally: every tenant
+// owns a nested bucket below the root devices bucket, and all lookups are
+// scoped to that bucket.
+//
+// The store holds an exclusive file lock on the database while it is open,
+// so only one process may use a given database file at a time. Callers must
+// call Close to release the lock and flush pending state.
+//
+// Example usage:
+//
+//	store, err := bolt.New(filepath.Join(dataDir, "devices.db"))
+//	if err != nil {
+//		return err
+//	}
+//	defer store.Close()
+//	service := device.NewService(store, logger)
+package bolt
@@ -0,0 +1,257 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	bbolt "go.etcd.io/bbolt"
+)
+
+const (
+	// dirPermissions restricts the database directory to the service user
+	dirPermissions = 0750
+	// filePermissions restricts the database file to the service user
+	filePermissions = 0600
+	// openTimeout bounds how long New waits for the database file lock
+	openTimeout = 5 * time.Second
+)
+
+// devicesBucket is the root bucket holding one nested bucket per tenant
+var devicesBucket = []byte("devices")
+
+// Store provides a bbolt-backed implementation of device.Store.
+// Devices are stored as JSON documents keyed by device ID inside a
+// per-tenant bucket.
+type Store struct {
+	db *bbolt.DB
+}
+
+// Ensure Store implements device.Store
+var _ device.Store = (*Store)(nil)
+
+// New opens (or creates) the device database at the given path.
+func New(path string) (*Store, error) {
+	if path == "" {
+		return nil, device.E("Store.New", device.ErrCodeStorageError, "database path is required", nil)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), dirPermissions); err != nil {
+		return nil, device.E("Store.New", device.ErrCodeStorageError, "failed to create database directory", err)
+	}
+
+	db, err := bbolt.Open(path, filePermissions, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, device.E("Store.New", device.ErrCodeStorageError, "failed to open database", err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(devicesBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, device.E("Store.New", device.ErrCodeStorageError, "failed to initialize database", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the database file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Create stores a new device
+func (s *Store) Create(ctx context.Context, d *device.Device) error {
+	if err := ctx.Err(); err != nil {
+		return device.E("Store.Create", device.ErrCodeStorageError, "context cancelled", err)
+	}
+	if err := d.Validate(); err != nil {
+		return device.E("Store.Create", device.ErrCodeInvalidDevice, "invalid device", err)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return device.E("Store.Create", device.ErrCodeStorageError, "failed to encode device", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		tenant, err := tx.Bucket(devicesBucket).CreateBucketIfNotExists([]byte(d.TenantID))
+		if err != nil {
+			return device.E("Store.Create", device.ErrCodeStorageError, "failed to create tenant bucket", err)
+		}
+
+		if tenant.Get([]byte(d.ID)) != nil {
+			return device.E("Store.Create", device.ErrCodeDeviceExists, "device already exists", nil)
+		}
+
+		if err := tenant.Put([]byte(d.ID), data); err != nil {
+			return device.E("Store.Create", device.ErrCodeStorageError, "failed to write device", err)
+		}
+		return nil
+	})
+}
+
+// Get retrieves a device by ID
+func (s *Store) Get(ctx context.Context, tenantID, deviceID string) (*device.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, device.E("Store.Get", device.ErrCodeStorageError, "context cancelled", err)
+	}
+
+	var d *device.Device
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := s.lookup(tx, tenantID, deviceID)
+		if data == nil {
+			return device.E("Store.Get", device.ErrCodeDeviceNotFound, "device not found", nil)
+		}
+
+		decoded, err := decode(data)
+		if err != nil {
+			return device.E("Store.Get", device.ErrCodeStorageError, "failed to decode device", err)
+		}
+		d = decoded
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Update modifies an existing device
+func (s *Store) Update(ctx context.Context, d *device.Device) error {
+	if err := ctx.Err(); err != nil {
+		return device.E("Store.Update", device.ErrCodeStorageError, "context cancelled", err)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return device.E("Store.Update", device.ErrCodeStorageError, "failed to encode device", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		tenant := tx.Bucket(devicesBucket).Bucket([]byte(d.TenantID))
+		if tenant == nil || tenant.Get([]byte(d.ID)) == nil {
+			return device.E("Store.Update", device.ErrCodeDeviceNotFound, "device not found", nil)
+		}
+
+		if err := tenant.Put([]byte(d.ID), data); err != nil {
+			return device.E("Store.Update", device.ErrCodeStorageError, "failed to write device", err)
+		}
+		return nil
+	})
+}
+
+// Delete removes a device
+func (s *Store) Delete(ctx context.Context, tenantID, deviceID string) error {
+	if err := ctx.Err(); err != nil {
+		return device.E("Store.Delete", device.ErrCodeStorageError, "context cancelled", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		tenant := tx.Bucket(devicesBucket).Bucket([]byte(tenantID))
+		if tenant == nil || tenant.Get([]byte(deviceID)) == nil {
+			return device.E("Store.Delete", device.ErrCodeDeviceNotFound, "device not found", nil)
+		}
+
+		if err := tenant.Delete([]byte(deviceID)); err != nil {
+			return device.E("Store.Delete", device.ErrCodeStorageError, "failed to delete device", err)
+		}
+		return nil
+	})
+}
+
+// List retrieves devices matching the given options. Results are sorted by
+// device ID to match the ordering guarantees of the memory store.
+func (s *Store) List(ctx context.Context, opts device.ListOptions) ([]*device.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, device.E("Store.List", device.ErrCodeStorageError, "context cancelled", err)
+	}
+
+	result := make([]*device.Device, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(devicesBucket)
+
+		collect := func(tenant *bbolt.Bucket) error {
+			return tenant.ForEach(func(_, data []byte) error {
+				d, err := decode(data)
+				if err != nil {
+					return device.E("Store.List", device.ErrCodeStorageError, "failed to decode device", err)
+				}
+				if matches(d, opts) {
+					result = append(result, d)
+				}
+				return nil
+			})
+		}
+
+		if opts.TenantID != "" {
+			tenant := root.Bucket([]byte(opts.TenantID))
+			if tenant == nil {
+				return nil
+			}
+			return collect(tenant)
+		}
+
+		return root.ForEachBucket(func(name []byte) error {
+			return collect(root.Bucket(name))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	// Apply pagination
+	if opts.Offset >= len(result) {
+		return make([]*device.Device, 0), nil
+	}
+
+	end := opts.Offset + opts.Limit
+	if opts.Limit <= 0 || end > len(result) {
+		end = len(result)
+	}
+
+	return result[opts.Offset:end], nil
+}
+
+// lookup returns the raw device document, or nil if it does not exist.
+// The returned slice is only valid for the lifetime of the transaction.
+func (s *Store) lookup(tx *bbolt.Tx, tenantID, deviceID string) []byte {
+	tenant := tx.Bucket(devicesBucket).Bucket([]byte(tenantID))
+	if tenant == nil {
+		return nil
+	}
+	return tenant.Get([]byte(deviceID))
+}
+
+// matches reports whether a device satisfies the status and tag filters
+func matches(d *device.Device, opts device.ListOptions) bool {
+	if opts.Status != "" && d.Status != opts.Status {
+		return false
+	}
+
+	for k, v := range opts.Tags {
+		if d.Tags[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decode unmarshals a stored device document
+func decode(data []byte) (*device.Device, error) {
+	var d device.Device
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("unmarshaling device: %w", err)
+	}
+	return &d, nil
+}
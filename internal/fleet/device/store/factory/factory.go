@@ -0,0 +1,45 @@
+// Package factory provides creation functions for device store implementations.
+package factory
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/store/bolt"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/storage"
+)
+
+// Built-in backend names
+const (
+	// Memory keeps devices in memory; data is lost on restart
+	Memory = "memory"
+	// Bolt persists devices in an embedded bbolt database
+	Bolt = "bolt"
+)
+
+// dbFile is the device database file name within Options.DataDir
+const dbFile = "devices.db"
+
+// Options configures device store backends.
+type Options struct {
+	// DataDir is the directory file-backed stores keep their data in
+	DataDir string
+}
+
+// Registry holds the available device store backends.
+var Registry = storage.NewRegistry[device.Store, Options]("device")
+
+func init() {
+	Registry.Register(Memory, func(ctx context.Context, opts Options) (device.Store, error) {
+		return memory.New(), nil
+	})
+	Registry.Register(Bolt, func(ctx context.Context, opts Options) (device.Store, error) {
+		if opts.DataDir == "" {
+			return nil, fmt.Errorf("data directory is required")
+		}
+		return bolt.New(filepath.Join(opts.DataDir, dbFile))
+	})
+}
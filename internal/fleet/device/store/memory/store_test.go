@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/storetest"
 )
 
 func TestNew(t *testing.T) {
@@ -406,3 +407,9 @@ func TestStore_Concurrency(t *testing.T) {
 	require.NoError(t, err)
 	assert.NotNil(t, stored)
 }
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) device.Store {
+		return New()
+	})
+}
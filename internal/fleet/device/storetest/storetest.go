@@ -0,0 +1,333 @@
+// Package storetest provides a conformance suite for device.Store
+// implementations.
+//
+// Every backend should run the suite from its own tests so that all stores
+// share a single definition of correct behavior:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) device.Store {
+//			return memory.New()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+)
+
+// Factory creates an empty store for a single test. Implementations that
+// hold resources should release them through t.Cleanup.
+type Factory func(t *testing.T) device.Store
+
+// Run executes the full conformance suite against stores created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("Create", func(t *testing.T) { testCreate(t, factory(t)) })
+	t.Run("Get", func(t *testing.T) { testGet(t, factory(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, factory(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, factory(t)) })
+	t.Run("ListFilters", func(t *testing.T) { testListFilters(t, factory(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, factory(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, factory(t)) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory(t)) })
+}
+
+// newDevice builds a valid device with a deterministic ID
+func newDevice(tenantID, id string) *device.Device {
+	d := device.New(tenantID, "device "+id)
+	d.ID = id
+	return d
+}
+
+// requireCode asserts that err is a *device.Error carrying the given code
+func requireCode(t *testing.T, err error, code string) {
+	t.Helper()
+	require.Error(t, err)
+	var devErr *device.Error
+	require.True(t, errors.As(err, &devErr), "expected *device.Error, got %T: %v", err, err)
+	assert.Equal(t, code, devErr.Code)
+}
+
+func testCreate(t *testing.T, store device.Store) {
+	ctx := context.Background()
+
+	d := newDevice("tenant-1", "dev-1")
+	d.Tags["env"] = "prod"
+	d.NetworkInfo = &device.NetworkInfo{IPAddress: "10.0.0.1", Hostname: "dev-1"}
+	require.NoError(t, d.SetConfig([]byte(`{"interval":30}`), "storetest"))
+	require.NoError(t, store.Create(ctx, d))
+
+	got, err := store.Get(ctx, "tenant-1", "dev-1")
+	require.NoError(t, err)
+	assert.Equal(t, d.Name, got.Name)
+	assert.Equal(t, d.Status, got.Status)
+	assert.Equal(t, d.Tags, got.Tags)
+	assert.Equal(t, d.LastConfigHash, got.LastConfigHash)
+	assert.JSONEq(t, string(d.Config), string(got.Config))
+	require.NotNil(t, got.NetworkInfo)
+	assert.Equal(t, "10.0.0.1", got.NetworkInfo.IPAddress)
+
+	t.Run("duplicate", func(t *testing.T) {
+		requireCode(t, store.Create(ctx, newDevice("tenant-1", "dev-1")), device.ErrCodeDeviceExists)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		requireCode(t, store.Create(ctx, &device.Device{Name: "no ids"}), device.ErrCodeInvalidDevice)
+	})
+}
+
+func testGet(t *testing.T, store device.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.Create(ctx, newDevice("tenant-1", "dev-1")))
+
+	got, err := store.Get(ctx, "tenant-1", "dev-1")
+	require.NoError(t, err)
+	assert.Equal(t, "dev-1", got.ID)
+	assert.Equal(t, "tenant-1", got.TenantID)
+
+	_, err = store.Get(ctx, "tenant-1", "missing")
+	requireCode(t, err, device.ErrCodeDeviceNotFound)
+}
+
+func testUpdate(t *testing.T, store device.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.Create(ctx, newDevice("tenant-1", "dev-1")))
+
+	d, err := store.Get(ctx, "tenant-1", "dev-1")
+	require.NoError(t, err)
+	d.Name = "renamed"
+	d.SetStatus(device.StatusOnline)
+	require.NoError(t, d.AddTag("role", "gateway"))
+	require.NoError(t, store.Update(ctx, d))
+
+	got, err := store.Get(ctx, "tenant-1", "dev-1")
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", got.Name)
+	assert.Equal(t, device.StatusOnline, got.Status)
+	assert.Equal(t, "gateway", got.Tags["role"])
+
+	requireCode(t, store.Update(ctx, newDevice("tenant-1", "missing")), device.ErrCodeDeviceNotFound)
+}
+
+func testDelete(t *testing.T, store device.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.Create(ctx, newDevice("tenant-1", "dev-1")))
+
+	require.NoError(t, store.Delete(ctx, "tenant-1", "dev-1"))
+
+	_, err := store.Get(ctx, "tenant-1", "dev-1")
+	requireCode(t, err, device.ErrCodeDeviceNotFound)
+
+	requireCode(t, store.Delete(ctx, "tenant-1", "dev-1"), device.ErrCodeDeviceNotFound)
+
+	// A deleted ID can be reused
+	require.NoError(t, store.Create(ctx, newDevice("tenant-1", "dev-1")))
+}
+
+func testListFilters(t *testing.T, store device.Store) {
+	ctx := context.Background()
+
+	fixtures := []struct {
+		id     string
+		status device.Status
+		env    string
+	}{
+		{"dev-1", device.StatusOnline, "prod"},
+		{"dev-2", device.StatusOffline, "prod"},
+		{"dev-3", device.StatusOnline, "staging"},
+	}
+	for _, f := range fixtures {
+		d := newDevice("tenant-1", f.id)
+		d.Status = f.status
+		d.Tags["env"] = f.env
+		require.NoError(t, store.Create(ctx, d))
+	}
+
+	tests := []struct {
+		name    string
+		opts    device.ListOptions
+		wantIDs []string
+	}{
+		{
+			name:    "all",
+			opts:    device.ListOptions{TenantID: "tenant-1"},
+			wantIDs: []string{"dev-1", "dev-2", "dev-3"},
+		},
+		{
+			name:    "status",
+			opts:    device.ListOptions{TenantID: "tenant-1", Status: device.StatusOnline},
+			wantIDs: []string{"dev-1", "dev-3"},
+		},
+		{
+			name:    "tags",
+			opts:    device.ListOptions{TenantID: "tenant-1", Tags: map[string]string{"env": "prod"}},
+			wantIDs: []string{"dev-1", "dev-2"},
+		},
+		{
+			name: "status and tags",
+			opts: device.ListOptions{
+				TenantID: "tenant-1",
+				Status:   device.StatusOnline,
+				Tags:     map[string]string{"env": "prod"},
+			},
+			wantIDs: []string{"dev-1"},
+		},
+		{
+			name:    "no match",
+			opts:    device.ListOptions{TenantID: "tenant-1", Tags: map[string]string{"env": "dev"}},
+			wantIDs: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.List(ctx, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, ids(got))
+		})
+	}
+}
+
+func testPagination(t *testing.T, store device.Store) {
+	ctx := context.Background()
+
+	// Insert out of order to verify results are sorted by ID
+	for _, id := range []string{"dev-3", "dev-1", "dev-5", "dev-2", "dev-4"} {
+		require.NoError(t, store.Create(ctx, newDevice("tenant-1", id)))
+	}
+
+	tests := []struct {
+		name    string
+		offset  int
+		limit   int
+		wantIDs []string
+	}{
+		{"no limit", 0, 0, []string{"dev-1", "dev-2", "dev-3", "dev-4", "dev-5"}},
+		{"first page", 0, 2, []string{"dev-1", "dev-2"}},
+		{"second page", 2, 2, []string{"dev-3", "dev-4"}},
+		{"last partial page", 4, 2, []string{"dev-5"}},
+		{"offset without limit", 3, 0, []string{"dev-4", "dev-5"}},
+		{"offset past end", 5, 2, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.List(ctx, device.ListOptions{
+				TenantID: "tenant-1",
+				Offset:   tt.offset,
+				Limit:    tt.limit,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, ids(got))
+		})
+	}
+}
+
+func testTenantIsolation(t *testing.T, store device.Store) {
+	ctx := context.Background()
+
+	// The same device ID may exist independently in two tenants
+	d1 := newDevice("tenant-1", "shared")
+	d1.Name = "tenant one"
+	d2 := newDevice("tenant-2", "shared")
+	d2.Name = "tenant two"
+	require.NoError(t, store.Create(ctx, d1))
+	require.NoError(t, store.Create(ctx, d2))
+	require.NoError(t, store.Create(ctx, newDevice("tenant-1", "only-1")))
+
+	got, err := store.Get(ctx, "tenant-2", "shared")
+	require.NoError(t, err)
+	assert.Equal(t, "tenant two", got.Name)
+
+	_, err = store.Get(ctx, "tenant-2", "only-1")
+	requireCode(t, err, device.ErrCodeDeviceNotFound)
+
+	// Updates and deletes scoped to one tenant must not leak into another
+	cross := newDevice("tenant-2", "only-1")
+	requireCode(t, store.Update(ctx, cross), device.ErrCodeDeviceNotFound)
+	requireCode(t, store.Delete(ctx, "tenant-2", "only-1"), device.ErrCodeDeviceNotFound)
+
+	require.NoError(t, store.Delete(ctx, "tenant-1", "shared"))
+	got, err = store.Get(ctx, "tenant-2", "shared")
+	require.NoError(t, err)
+	assert.Equal(t, "tenant two", got.Name)
+
+	list, err := store.List(ctx, device.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"only-1"}, ids(list))
+
+	list, err = store.List(ctx, device.ListOptions{TenantID: "tenant-3"})
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func testConcurrency(t *testing.T, store device.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.Create(ctx, newDevice("tenant-1", "shared")))
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*4)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := fmt.Sprintf("dev-%02d", i)
+			if err := store.Create(ctx, newDevice("tenant-1", id)); err != nil {
+				errs <- fmt.Errorf("create %s: %w", id, err)
+				return
+			}
+			if _, err := store.Get(ctx, "tenant-1", "shared"); err != nil {
+				errs <- fmt.Errorf("get shared: %w", err)
+			}
+			update := newDevice("tenant-1", "shared")
+			update.Name = fmt.Sprintf("writer %d", i)
+			if err := store.Update(ctx, update); err != nil {
+				errs <- fmt.Errorf("update shared: %w", err)
+			}
+			if _, err := store.List(ctx, device.ListOptions{TenantID: "tenant-1"}); err != nil {
+				errs <- fmt.Errorf("list: %w", err)
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for concurrent operations")
+	}
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	list, err := store.List(ctx, device.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Len(t, list, workers+1)
+}
+
+// ids extracts device IDs preserving order
+func ids(devices []*device.Device) []string {
+	result := make([]string, 0, len(devices))
+	for _, d := range devices {
+		result = append(result, d.ID)
+	}
+	return result
+}
@@ -0,0 +1,335 @@
+// Package drift detects devices whose running configuration has drifted
+// from the configuration last deployed to them.
+package drift
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"go.uber.org/zap"
+)
+
+// DefaultInterval is how often reported configuration hashes are compared
+// with deployments
+const DefaultInterval = time.Minute
+
+// componentID identifies the detector in audit events
+const componentID = "config-drift"
+
+// Drift records a device reporting a configuration hash other than the
+// hash of its latest completed deployment
+type Drift struct {
+	TenantID     string    `json:"tenant_id"`
+	DeviceID     string    `json:"device_id"`
+	DeploymentID string    `json:"deployment_id"`
+	TemplateID   string    `json:"template_id"`
+	Version      int       `json:"version"`
+	ExpectedHash string    `json:"expected_hash"`
+	ReportedHash string    `json:"reported_hash"`
+	DetectedAt   time.Time `json:"detected_at"`
+
+	// RemediationID is the deployment created to restore the expected
+	// configuration, when remediation is enabled
+	RemediationID string `json:"remediation_id,omitempty"`
+}
+
+// Deployments lists configuration deployments and re-deploys versions. It
+// is implemented by config.Service.
+type Deployments interface {
+	ListDeployments(ctx context.Context, opts config.ListOptions) ([]*config.Deployment, error)
+	LatestDeployments(ctx context.Context, opts config.ListOptions) ([]*config.Deployment, error)
+	DeployConfiguration(ctx context.Context, tenantID, templateID string, version *config.Version, deviceID string) (*config.Deployment, error)
+}
+
+// Devices looks up the configuration hash devices last reported. It is
+// implemented by device.Service.
+type Devices interface {
+	Get(ctx context.Context, tenantID, deviceID string) (*device.Device, error)
+}
+
+// Auditor records audit events. It is implemented by logging.Service.
+type Auditor interface {
+	Log(ctx context.Context, tenantID string, eventType logging.EventType, level logging.Level, message string, opts ...logging.EventOption) error
+}
+
+// Detector periodically compares the configuration hash each device
+// reports with the hash of its latest completed deployment. Drifted devices
+// are flagged until they report the expected hash again.
+type Detector struct {
+	deployments Deployments
+	devices     Devices
+	auditor     Auditor
+	logger      *zap.Logger
+	interval    time.Duration
+	remediate   bool
+
+	// checkMu serializes checks, so only one check at a time replaces
+	// drifts
+	checkMu sync.Mutex
+
+	// mu guards drifts, keyed by tenant and device ID. It is never held
+	// across I/O, so List and Drifted do not wait for a check. Stored
+	// drifts are not modified, only replaced.
+	mu     sync.Mutex
+	drifts map[string]*Drift
+}
+
+// Option configures a Detector
+type Option func(*Detector)
+
+// WithInterval sets how often Run checks for drift
+func WithInterval(d time.Duration) Option {
+	return func(det *Detector) {
+		if d > 0 {
+			det.interval = d
+		}
+	}
+}
+
+// WithRemediation enables re-deploying the expected configuration to a
+// device once when it is found drifted
+func WithRemediation(enabled bool) Option {
+	return func(det *Detector) {
+		det.remediate = enabled
+	}
+}
+
+// WithAuditor records drift detection and resolution as audit events
+func WithAuditor(auditor Auditor) Option {
+	return func(det *Detector) {
+		det.auditor = auditor
+	}
+}
+
+// NewDetector creates a new drift detector
+func NewDetector(deployments Deployments, devices Devices, logger *zap.Logger, opts ...Option) *Detector {
+	det := &Detector{
+		deployments: deployments,
+		devices:     devices,
+		logger:      logger,
+		interval:    DefaultInterval,
+		drifts:      make(map[string]*Drift),
+	}
+	for _, opt := range opts {
+		opt(det)
+	}
+	return det
+}
+
+// Interval returns how often Run checks for drift
+func (d *Detector) Interval() time.Duration {
+	return d.interval
+}
+
+// List returns the drifted devices of a tenant, ordered by device ID
+func (d *Detector) List(tenantID string) []Drift {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := make([]Drift, 0)
+	for _, drift := range d.drifts {
+		if drift.TenantID == tenantID {
+			out = append(out, *drift)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out
+}
+
+// Drifted reports whether a device is currently flagged as drifted. It
+// lets group rollups count drifted devices.
+func (d *Detector) Drifted(tenantID, deviceID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.drifts[driftKey(tenantID, deviceID)]
+	return ok
+}
+
+// Check compares every device that has a completed deployment with the
+// hash it last reported. Devices with a deployment still pending, or that
+// have not reported a hash, are left alone until the next check.
+//
+// Devices are loaded, remediated and audited without holding d.mu: the
+// check works from a snapshot of the current drifts and swaps in the
+// result once every device has been compared.
+func (d *Detector) Check(ctx context.Context, now time.Time) error {
+	d.checkMu.Lock()
+	defer d.checkMu.Unlock()
+
+	// Only the latest completed deployment per device and the pending
+	// ones are loaded, so a check does not grow with deployment history.
+	completed, err := d.deployments.LatestDeployments(ctx, config.ListOptions{
+		Status: config.DeploymentStatusCompleted,
+	})
+	if err != nil {
+		return err
+	}
+	waiting, err := d.deployments.ListDeployments(ctx, config.ListOptions{
+		Status: config.DeploymentStatusPending,
+	})
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]*config.Deployment, len(completed))
+	for _, dep := range completed {
+		latest[driftKey(dep.TenantID, dep.DeviceID)] = dep
+	}
+	pending := make(map[string]bool, len(waiting))
+	for _, dep := range waiting {
+		pending[driftKey(dep.TenantID, dep.DeviceID)] = true
+	}
+
+	d.mu.Lock()
+	existing := make(map[string]*Drift, len(d.drifts))
+	for key, drift := range d.drifts {
+		existing[key] = drift
+	}
+	d.mu.Unlock()
+
+	// Drifts of devices without a completed deployment are dropped
+	drifts := make(map[string]*Drift, len(existing))
+	for key, dep := range latest {
+		drift := existing[key]
+		if !pending[key] && dep.ConfigVersion != nil {
+			drift = d.check(ctx, dep, drift, now)
+		}
+		if drift != nil {
+			drifts[key] = drift
+		}
+	}
+
+	d.mu.Lock()
+	d.drifts = drifts
+	d.mu.Unlock()
+	return nil
+}
+
+// check compares a single device with its latest completed deployment and
+// returns its drift after the check: nil when the device is in sync or no
+// longer exists, existing when nothing changed.
+func (d *Detector) check(ctx context.Context, dep *config.Deployment, existing *Drift, now time.Time) *Drift {
+	tenantCtx := device.ContextWithTenant(ctx, dep.TenantID)
+	dev, err := d.devices.Get(tenantCtx, dep.TenantID, dep.DeviceID)
+	if err != nil {
+		var devErr *device.Error
+		if errors.As(err, &devErr) && devErr.Code == device.ErrCodeDeviceNotFound {
+			return nil
+		}
+		d.logger.Warn("drift check failed to load device",
+			zap.Error(err),
+			zap.String("device_id", dep.DeviceID),
+			zap.String("tenant_id", dep.TenantID))
+		return existing
+	}
+	if dev.LastConfigHash == "" {
+		return existing
+	}
+
+	// Devices report the hash of the configuration as delivered, which
+	// Delivery compacts, so Version.Hash of the submitted bytes would
+	// not match whenever the operator's JSON carried whitespace.
+	version := dep.ConfigVersion
+	_, expected, err := version.Delivery()
+	if err != nil {
+		d.logger.Warn("drift check failed to hash deployed configuration",
+			zap.Error(err),
+			zap.String("deployment_id", dep.ID),
+			zap.String("tenant_id", dep.TenantID))
+		return existing
+	}
+
+	if dev.LastConfigHash == expected {
+		if existing != nil {
+			d.audit(tenantCtx, existing, logging.LevelInfo, "device configuration back in sync with deployment")
+		}
+		return nil
+	}
+	if existing != nil && existing.DeploymentID == dep.ID && existing.ReportedHash == dev.LastConfigHash {
+		return existing
+	}
+
+	drift := &Drift{
+		TenantID:     dep.TenantID,
+		DeviceID:     dep.DeviceID,
+		DeploymentID: dep.ID,
+		TemplateID:   version.TemplateID,
+		Version:      version.Number,
+		ExpectedHash: expected,
+		ReportedHash: dev.LastConfigHash,
+		DetectedAt:   now,
+	}
+	// A deployment is remediated at most once; a device that drifts again
+	// afterwards is only flagged.
+	if existing != nil && existing.DeploymentID == dep.ID {
+		drift.RemediationID = existing.RemediationID
+	}
+
+	d.logger.Warn("device configuration drifted from deployment",
+		zap.String("device_id", drift.DeviceID),
+		zap.String("tenant_id", drift.TenantID),
+		zap.String("deployment_id", drift.DeploymentID),
+		zap.String("expected_hash", drift.ExpectedHash),
+		zap.String("reported_hash", drift.ReportedHash))
+
+	if d.remediate && drift.RemediationID == "" {
+		remediation, err := d.deployments.DeployConfiguration(tenantCtx, dep.TenantID, version.TemplateID, version, dep.DeviceID)
+		if err != nil {
+			d.logger.Error("failed to deploy drift remediation",
+				zap.Error(err),
+				zap.String("device_id", drift.DeviceID),
+				zap.String("tenant_id", drift.TenantID))
+		} else {
+			drift.RemediationID = remediation.ID
+		}
+	}
+
+	d.audit(tenantCtx, drift, logging.LevelWarn, "device configuration drifted from deployment")
+	return drift
+}
+
+// audit records a drift change as an audit event
+func (d *Detector) audit(ctx context.Context, drift *Drift, level logging.Level, message string) {
+	if d.auditor == nil {
+		return
+	}
+	if err := d.auditor.Log(ctx, drift.TenantID, logging.EventAudit, level, message,
+		logging.WithEventContext(logging.EventContext{
+			ComponentID: componentID,
+			DeviceID:    drift.DeviceID,
+		}),
+		logging.WithEventMetadata(drift),
+	); err != nil {
+		d.logger.Warn("failed to record drift audit event",
+			zap.Error(err),
+			zap.String("device_id", drift.DeviceID),
+			zap.String("tenant_id", drift.TenantID))
+	}
+}
+
+// Run checks for drift every interval until ctx is cancelled
+func (d *Detector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := d.Check(ctx, now.UTC()); err != nil {
+				d.logger.Error("config drift check failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func driftKey(tenantID, deviceID string) string {
+	return tenantID + "/" + deviceID
+}
@@ -0,0 +1,197 @@
+package drift_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	configmemory "github.com/wrale/wrale-fleet/internal/fleet/config/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	devicememory "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/drift"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"go.uber.org/zap/zaptest"
+)
+
+const tenantID = "tenant-1"
+
+// auditEvent is an event recorded by recordingAuditor
+type auditEvent struct {
+	level   logging.Level
+	message string
+}
+
+// recordingAuditor keeps the audit events it is given
+type recordingAuditor struct {
+	events []auditEvent
+}
+
+func (a *recordingAuditor) Log(ctx context.Context, tenantID string, eventType logging.EventType, level logging.Level, message string, opts ...logging.EventOption) error {
+	a.events = append(a.events, auditEvent{level: level, message: message})
+	return nil
+}
+
+type fixture struct {
+	t       *testing.T
+	ctx     context.Context
+	config  *config.Service
+	store   device.Store
+	devices *device.Service
+	version *config.Version
+	hash    string
+}
+
+func newFixture(t *testing.T) *fixture {
+	ctx := device.ContextWithTenant(context.Background(), tenantID)
+	logger := zaptest.NewLogger(t)
+	cfg := config.NewService(configmemory.New(), logger)
+	store := devicememory.New()
+
+	template, err := cfg.CreateTemplate(ctx, tenantID, "edge", json.RawMessage(`{"type":"object"}`))
+	require.NoError(t, err)
+	version, err := cfg.CreateValidatedVersion(ctx, tenantID, template.ID, json.RawMessage(`{"workers": 4}`), "operator")
+	require.NoError(t, err)
+	_, hash, err := version.Delivery()
+	require.NoError(t, err)
+
+	return &fixture{
+		t:       t,
+		ctx:     ctx,
+		config:  cfg,
+		store:   store,
+		devices: device.NewService(store, logger),
+		version: version,
+		hash:    hash,
+	}
+}
+
+// applied registers a device that completed a deployment of the fixture
+// version and now reports running the given hash
+func (f *fixture) applied(name, reported string) (*device.Device, *config.Deployment) {
+	dev, err := f.devices.Register(f.ctx, tenantID, name)
+	require.NoError(f.t, err)
+
+	deployment, err := f.config.DeployConfiguration(f.ctx, tenantID, f.version.TemplateID, f.version, dev.ID)
+	require.NoError(f.t, err)
+	_, err = f.config.AcknowledgeDeployment(f.ctx, tenantID, dev.ID, deployment.ID, f.hash, "")
+	require.NoError(f.t, err)
+
+	f.report(dev.ID, reported)
+	return dev, deployment
+}
+
+// report records the configuration hash a device reports running
+func (f *fixture) report(deviceID, hash string) {
+	dev, err := f.store.Get(f.ctx, tenantID, deviceID)
+	require.NoError(f.t, err)
+	dev.LastConfigHash = hash
+	require.NoError(f.t, f.store.Update(f.ctx, dev))
+}
+
+func TestDetectorFlagsAndRemediatesDrift(t *testing.T) {
+	f := newFixture(t)
+	auditor := &recordingAuditor{}
+	detector := drift.NewDetector(f.config, f.devices, zaptest.NewLogger(t),
+		drift.WithRemediation(true), drift.WithAuditor(auditor))
+
+	f.applied("in-sync", f.hash)
+	f.applied("silent", "")
+	drifted, deployment := f.applied("drifted", "locally-edited")
+
+	now := time.Now().UTC()
+	require.NoError(t, detector.Check(context.Background(), now))
+
+	drifts := detector.List(tenantID)
+	require.Len(t, drifts, 1)
+	got := drifts[0]
+	assert.Equal(t, drifted.ID, got.DeviceID)
+	assert.Equal(t, deployment.ID, got.DeploymentID)
+	assert.Equal(t, f.version.Number, got.Version)
+	assert.Equal(t, f.hash, got.ExpectedHash)
+	assert.Equal(t, "locally-edited", got.ReportedHash)
+	assert.Equal(t, now, got.DetectedAt)
+	require.NotEmpty(t, got.RemediationID)
+	assert.Empty(t, detector.List("tenant-2"))
+	assert.True(t, detector.Drifted(tenantID, drifted.ID))
+	assert.False(t, detector.Drifted("tenant-2", drifted.ID))
+
+	require.Len(t, auditor.events, 1)
+	assert.Equal(t, logging.LevelWarn, auditor.events[0].level)
+
+	// The remediation re-deploys the same version.
+	pending, err := f.config.PendingDeployment(f.ctx, tenantID, drifted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, got.RemediationID, pending.ID)
+	assert.Equal(t, f.version.Number, pending.ConfigVersion.Number)
+
+	// Nothing changes while the remediation is pending.
+	require.NoError(t, detector.Check(context.Background(), now.Add(time.Minute)))
+	assert.Len(t, detector.List(tenantID), 1)
+	assert.Len(t, auditor.events, 1)
+
+	// A failed remediation is not retried.
+	require.NoError(t, f.config.FailDeployment(f.ctx, tenantID, pending.ID, "device offline"))
+	require.NoError(t, detector.Check(context.Background(), now.Add(2*time.Minute)))
+	assert.Equal(t, got.RemediationID, detector.List(tenantID)[0].RemediationID)
+	assert.Len(t, auditor.events, 1)
+
+	// The flag clears once the device reports the expected hash.
+	f.report(drifted.ID, f.hash)
+	require.NoError(t, detector.Check(context.Background(), now.Add(3*time.Minute)))
+	assert.Empty(t, detector.List(tenantID))
+	assert.False(t, detector.Drifted(tenantID, drifted.ID))
+	require.Len(t, auditor.events, 2)
+	assert.Equal(t, logging.LevelInfo, auditor.events[1].level)
+}
+
+func TestDetectorWithoutRemediation(t *testing.T) {
+	f := newFixture(t)
+	detector := drift.NewDetector(f.config, f.devices, zaptest.NewLogger(t))
+
+	dev, _ := f.applied("drifted", "locally-edited")
+	require.NoError(t, detector.Check(context.Background(), time.Now().UTC()))
+
+	drifts := detector.List(tenantID)
+	require.Len(t, drifts, 1)
+	assert.Empty(t, drifts[0].RemediationID)
+	_, err := f.config.PendingDeployment(f.ctx, tenantID, dev.ID)
+	require.Error(t, err)
+
+	// Flags of removed devices are dropped.
+	require.NoError(t, f.devices.Delete(f.ctx, tenantID, dev.ID))
+	require.NoError(t, detector.Check(context.Background(), time.Now().UTC()))
+	assert.Empty(t, detector.List(tenantID))
+}
+
+// probingDevices asks the detector whether a device is drifted while a
+// check loads it, as a group rollup might
+type probingDevices struct {
+	drift.Devices
+	detector *drift.Detector
+	answered chan bool
+}
+
+func (p *probingDevices) Get(ctx context.Context, tenantID, deviceID string) (*device.Device, error) {
+	go func() { p.answered <- p.detector.Drifted(tenantID, deviceID) }()
+	select {
+	case <-p.answered:
+	case <-time.After(5 * time.Second):
+		return nil, errors.New("Drifted blocked while the device was loaded")
+	}
+	return p.Devices.Get(ctx, tenantID, deviceID)
+}
+
+func TestDetectorDoesNotBlockReadersDuringCheck(t *testing.T) {
+	f := newFixture(t)
+	devices := &probingDevices{Devices: f.devices, answered: make(chan bool)}
+	devices.detector = drift.NewDetector(f.config, devices, zaptest.NewLogger(t))
+
+	dev, _ := f.applied("drifted", "locally-edited")
+	require.NoError(t, devices.detector.Check(context.Background(), time.Now().UTC()))
+	assert.True(t, devices.detector.Drifted(tenantID, dev.ID))
+}
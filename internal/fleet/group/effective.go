@@ -0,0 +1,175 @@
+package group
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Configuration layers an effective configuration value can come from
+const (
+	// SourceConfigTemplate values come from a group's config template
+	SourceConfigTemplate = "config_template"
+	// SourcePolicyOverride values come from a group's policy overrides
+	SourcePolicyOverride = "policy_override"
+	// SourceDevice values come from the device's own configuration
+	SourceDevice = "device"
+)
+
+// Source identifies the layer a key of an effective configuration was last
+// set by
+type Source struct {
+	Layer     string `json:"layer"`
+	GroupID   string `json:"group_id,omitempty"`
+	GroupName string `json:"group_name,omitempty"`
+}
+
+// EffectiveConfig is the configuration a device ends up with once the
+// configuration of every group it belongs to is applied beneath its own
+type EffectiveConfig struct {
+	DeviceID string          `json:"device_id"`
+	Config   json.RawMessage `json:"config"`
+
+	// Groups lists the IDs of the groups applied, in the order they were
+	// applied
+	Groups []string `json:"groups"`
+
+	// Sources maps each leaf key, as a dot-separated path, to the layer
+	// its value came from
+	Sources map[string]Source `json:"sources"`
+}
+
+// MergeConfig computes the effective configuration of a device from the
+// groups it inherits configuration from, ordered from least to most
+// specific, and its own configuration.
+//
+// Each group contributes its config template, then its policy overrides.
+// Override keys are dot-separated paths into the configuration. The
+// device's own configuration is applied last. Objects are merged key by
+// key; any other value replaces what an earlier layer set.
+func MergeConfig(deviceID string, groups []*Group, deviceConfig json.RawMessage) (*EffectiveConfig, error) {
+	const op = "group.MergeConfig"
+
+	m := &merger{config: map[string]interface{}{}, sources: map[string]Source{}}
+	out := &EffectiveConfig{DeviceID: deviceID, Groups: make([]string, 0, len(groups))}
+
+	for _, g := range groups {
+		out.Groups = append(out.Groups, g.ID)
+
+		template, err := decodeObject(g.Properties.ConfigTemplate)
+		if err != nil {
+			return nil, E(op, ErrCodeInvalidGroup,
+				fmt.Sprintf("config template of group %s must be a JSON object", g.Name), err).
+				WithField(FieldGroupID, g.ID)
+		}
+		m.mergeObject(nil, template, Source{Layer: SourceConfigTemplate, GroupID: g.ID, GroupName: g.Name})
+
+		keys := make([]string, 0, len(g.Properties.PolicyOverrides))
+		for key := range g.Properties.PolicyOverrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			var value interface{}
+			if err := decode(g.Properties.PolicyOverrides[key], &value); err != nil {
+				return nil, E(op, ErrCodeInvalidGroup,
+					fmt.Sprintf("policy override %q of group %s is not valid JSON", key, g.Name), err).
+					WithField(FieldGroupID, g.ID)
+			}
+			path := strings.Split(key, ".")
+			m.set(path, value, Source{Layer: SourcePolicyOverride, GroupID: g.ID, GroupName: g.Name})
+		}
+	}
+
+	own, err := decodeObject(deviceConfig)
+	if err != nil {
+		return nil, E(op, ErrCodeInvalidInput, "device configuration must be a JSON object", err)
+	}
+	m.mergeObject(nil, own, Source{Layer: SourceDevice})
+
+	config, err := json.Marshal(m.config)
+	if err != nil {
+		return nil, E(op, ErrCodeInvalidInput, "failed to encode effective configuration", err)
+	}
+	out.Config = config
+	out.Sources = m.sources
+	return out, nil
+}
+
+// merger applies configuration layers and tracks the source of each leaf
+type merger struct {
+	config  map[string]interface{}
+	sources map[string]Source
+}
+
+// mergeObject merges obj into the configuration at path
+func (m *merger) mergeObject(path []string, obj map[string]interface{}, src Source) {
+	for key, value := range obj {
+		m.set(append(path[:len(path):len(path)], key), value, src)
+	}
+}
+
+// set applies a value at path. Objects are merged into an object already
+// at the path; anything else replaces it along with the sources beneath it.
+func (m *merger) set(path []string, value interface{}, src Source) {
+	parent := m.config
+	for i, key := range path[:len(path)-1] {
+		next, ok := parent[key].(map[string]interface{})
+		if !ok {
+			m.clear(path[:i+1])
+			next = map[string]interface{}{}
+			parent[key] = next
+		}
+		parent = next
+	}
+
+	key := path[len(path)-1]
+	if obj, ok := value.(map[string]interface{}); ok {
+		_, isObj := parent[key].(map[string]interface{})
+		if isObj || len(obj) > 0 {
+			if !isObj {
+				m.clear(path)
+				parent[key] = map[string]interface{}{}
+			}
+			m.mergeObject(path, obj, src)
+			return
+		}
+	}
+
+	m.clear(path)
+	parent[key] = value
+	m.sources[strings.Join(path, ".")] = src
+}
+
+// clear forgets the sources recorded at and beneath path
+func (m *merger) clear(path []string) {
+	prefix := strings.Join(path, ".")
+	delete(m.sources, prefix)
+	for key := range m.sources {
+		if strings.HasPrefix(key, prefix+".") {
+			delete(m.sources, key)
+		}
+	}
+}
+
+// decodeObject parses a configuration layer. An empty layer or JSON null
+// contributes nothing.
+func decodeObject(data json.RawMessage) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return obj, nil
+	}
+	if err := decode(data, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// decode parses JSON keeping numbers exact
+func decode(data json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -0,0 +1,96 @@
+package group_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	devmem "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	grpmem "github.com/wrale/wrale-fleet/internal/fleet/group/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestMergeConfig(t *testing.T) {
+	site := group.New("tenant-1", "site", group.TypeStatic)
+	site.Properties.ConfigTemplate = json.RawMessage(`{"interval":30,"log":{"level":"info","file":"/var/log/agent"},"proxy":"none"}`)
+	site.Properties.PolicyOverrides["log.level"] = json.RawMessage(`"warn"`)
+
+	line := group.New("tenant-1", "line", group.TypeStatic)
+	line.Properties.ConfigTemplate = json.RawMessage(`{"interval":10,"log":{"file":"/data/agent.log"},"proxy":{"host":"10.0.0.1"}}`)
+
+	effective, err := group.MergeConfig("dev-1", []*group.Group{site, line}, json.RawMessage(`{"interval":5}`))
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"interval":5,"log":{"level":"warn","file":"/data/agent.log"},"proxy":{"host":"10.0.0.1"}}`,
+		string(effective.Config))
+	assert.Equal(t, []string{site.ID, line.ID}, effective.Groups)
+	assert.Equal(t, map[string]group.Source{
+		"interval":   {Layer: group.SourceDevice},
+		"log.level":  {Layer: group.SourcePolicyOverride, GroupID: site.ID, GroupName: "site"},
+		"log.file":   {Layer: group.SourceConfigTemplate, GroupID: line.ID, GroupName: "line"},
+		"proxy.host": {Layer: group.SourceConfigTemplate, GroupID: line.ID, GroupName: "line"},
+	}, effective.Sources)
+
+	// A value replacing an object takes the keys beneath it along.
+	line.Properties.PolicyOverrides["log"] = json.RawMessage(`"off"`)
+	effective, err = group.MergeConfig("dev-1", []*group.Group{site, line}, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"interval":10,"log":"off","proxy":{"host":"10.0.0.1"}}`, string(effective.Config))
+	assert.Equal(t, group.Source{Layer: group.SourcePolicyOverride, GroupID: line.ID, GroupName: "line"},
+		effective.Sources["log"])
+	assert.NotContains(t, effective.Sources, "log.file")
+
+	line.Properties.ConfigTemplate = json.RawMessage(`[1]`)
+	_, err = group.MergeConfig("dev-1", []*group.Group{site, line}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "config template of group line must be a JSON object")
+}
+
+func TestServiceEffectiveConfig(t *testing.T) {
+	ctx := device.ContextWithTenant(context.Background(), "tenant-1")
+	logger := zaptest.NewLogger(t)
+	deviceStore := devmem.New()
+	devices := device.NewService(deviceStore, logger)
+	service := group.NewService(grpmem.New(deviceStore), deviceStore, logger)
+
+	dev, err := devices.Register(ctx, "tenant-1", "edge-1")
+	require.NoError(t, err)
+	require.NoError(t, dev.SetConfig(json.RawMessage(`{"port":8443}`), "operator"))
+	require.NoError(t, deviceStore.Update(ctx, dev))
+
+	site, err := service.Create(ctx, "tenant-1", "site", group.TypeStatic)
+	require.NoError(t, err)
+	site.Properties.ConfigTemplate = json.RawMessage(`{"port":443,"region":"eu"}`)
+	require.NoError(t, service.Update(ctx, site))
+
+	line, err := service.Create(ctx, "tenant-1", "line", group.TypeStatic)
+	require.NoError(t, err)
+	require.NoError(t, service.UpdateHierarchy(ctx, line, site.ID))
+	line, err = service.Get(ctx, "tenant-1", line.ID)
+	require.NoError(t, err)
+	line.Properties.ConfigTemplate = json.RawMessage(`{"region":"eu-west"}`)
+	require.NoError(t, service.Update(ctx, line))
+	require.NoError(t, service.AddDevice(ctx, "tenant-1", line.ID, dev))
+
+	// Groups the device is not in contribute nothing.
+	other, err := service.Create(ctx, "tenant-1", "other", group.TypeStatic)
+	require.NoError(t, err)
+	other.Properties.ConfigTemplate = json.RawMessage(`{"region":"us"}`)
+	require.NoError(t, service.Update(ctx, other))
+
+	groups, err := service.DeviceGroups(ctx, "tenant-1", dev.ID)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, site.ID, groups[0].ID)
+	assert.Equal(t, line.ID, groups[1].ID)
+
+	effective, err := service.EffectiveConfig(ctx, dev)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"port":8443,"region":"eu-west"}`, string(effective.Config))
+	assert.Equal(t, group.SourceDevice, effective.Sources["port"].Layer)
+	assert.Equal(t, line.ID, effective.Sources["region"].GroupID)
+}
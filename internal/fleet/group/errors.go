@@ -1,6 +1,9 @@
 package group
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // Error codes for the group package
 const (
@@ -18,6 +21,10 @@ const (
 const (
 	FieldGroupID  = "group_id"
 	FieldTenantID = "tenant_id"
+
+	// FieldRepairedGroups lists the groups a failed hierarchy repair had
+	// already written
+	FieldRepairedGroups = "repaired_groups"
 )
 
 // Error represents a group management error
@@ -71,6 +78,40 @@ func E(op, code, message string, err error) *Error {
 	}
 }
 
+// codeOf returns the code of the most specific group error in err's chain,
+// looking through store operation wrappers, or fallback when there is none
+func codeOf(err error, fallback string) string {
+	var groupErr *Error
+	for errors.As(err, &groupErr) {
+		if groupErr.Code != ErrCodeStoreOperation {
+			return groupErr.Code
+		}
+		err = groupErr.Err
+	}
+	return fallback
+}
+
+// storeError wraps a store failure. Group errors reported by the store,
+// such as a missing group, keep their code so callers can tell them apart
+// from a failing store.
+func storeError(op, message string, err error) *Error {
+	return E(op, codeOf(err, ErrCodeStoreOperation), message, err)
+}
+
+// invalidInput wraps a validation failure, keeping the reason and fields
+// of the underlying group error in the message
+func invalidInput(op string, err error) *Error {
+	out := E(op, ErrCodeInvalidInput, "invalid group data", err)
+	var groupErr *Error
+	if errors.As(err, &groupErr) {
+		out.Message += ": " + groupErr.Message
+		for key, value := range groupErr.Fields {
+			out.WithField(key, value)
+		}
+	}
+	return out
+}
+
 // Common error variables
 var (
 	ErrGroupExists      = E("group", ErrCodeGroupExists, "group already exists", nil)
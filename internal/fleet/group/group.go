@@ -2,6 +2,7 @@ package group
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 	"time"
 
@@ -24,7 +25,7 @@ type MembershipQuery struct {
 	Tags    map[string]string `json:"tags,omitempty"`            // Tag-based matching
 	Status  device.Status     `json:"status,omitempty"`          // Status-based matching
 	Regions []string          `json:"regions,omitempty"`         // Region-based matching
-	Custom  json.RawMessage   `json:"custom_criteria,omitempty"` // Custom query criteria
+	Custom  json.RawMessage   `json:"custom_criteria,omitempty"` // Expression over device fields, as a JSON string
 }
 
 // Properties represents group configuration properties
@@ -32,6 +33,7 @@ type Properties struct {
 	ConfigTemplate  json.RawMessage            `json:"config_template,omitempty"`  // Base configuration for group devices
 	PolicyOverrides map[string]json.RawMessage `json:"policy_overrides,omitempty"` // Policy overrides for the group
 	Metadata        map[string]string          `json:"metadata,omitempty"`         // Additional group metadata
+	Variables       map[string]json.RawMessage `json:"variables,omitempty"`        // Configuration template variable values for group devices
 }
 
 // AncestryInfo contains information about a group's position in the hierarchy
@@ -134,6 +136,15 @@ func (g *Group) DeepCopy() *Group {
 		result.Properties.Metadata[k] = v
 	}
 
+	if g.Properties.Variables != nil {
+		result.Properties.Variables = make(map[string]json.RawMessage, len(g.Properties.Variables))
+		for k, v := range g.Properties.Variables {
+			newValue := make(json.RawMessage, len(v))
+			copy(newValue, v)
+			result.Properties.Variables[k] = newValue
+		}
+	}
+
 	return result
 }
 
@@ -187,6 +198,21 @@ func (g *Group) Validate() error {
 	if g.Type == TypeDynamic && g.Query == nil {
 		return E(op, ErrCodeInvalidGroup, "dynamic group must have query criteria", nil)
 	}
+	if g.Query != nil {
+		if _, err := g.Query.Compile(); err != nil {
+			return err
+		}
+	}
+
+	// Validate configuration variable values
+	for name, value := range g.Properties.Variables {
+		if name == "" {
+			return E(op, ErrCodeInvalidGroup, "variable name cannot be empty", nil)
+		}
+		if !json.Valid(value) {
+			return E(op, ErrCodeInvalidGroup, fmt.Sprintf("value of variable %q is not valid JSON", name), nil)
+		}
+	}
 
 	// Validate ancestry information
 	if g.Ancestry.Path == "" {
@@ -212,6 +238,9 @@ func (g *Group) SetQuery(query *MembershipQuery) error {
 	if query == nil {
 		return E(op, ErrCodeInvalidOperation, "query cannot be nil", nil)
 	}
+	if _, err := query.Compile(); err != nil {
+		return err
+	}
 
 	g.Query = query
 	g.UpdatedAt = time.Now().UTC()
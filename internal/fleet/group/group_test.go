@@ -115,6 +115,23 @@ func TestGroup_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "variable value is not JSON",
+			group: &Group{
+				ID:       "test-id",
+				TenantID: "test-tenant",
+				Name:     "test-group",
+				Type:     TypeStatic,
+				Ancestry: AncestryInfo{
+					Path:      "/test-id",
+					PathParts: []string{"test-id"},
+				},
+				Properties: Properties{
+					Variables: map[string]json.RawMessage{"site": json.RawMessage(`plant-3`)},
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
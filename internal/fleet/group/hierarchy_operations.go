@@ -38,9 +38,11 @@ func (h *HierarchyManager) GetDescendants(ctx context.Context, group *Group) ([]
 
 // buildAncestry constructs the ancestry information for a group
 func (h *HierarchyManager) buildAncestry(ctx context.Context, group *Group, parent *Group) (*AncestryInfo, error) {
+	// Moving a group keeps its children, wherever it moves to
 	ancestry := &AncestryInfo{
-		Children: make([]string, 0),
+		Children: make([]string, len(group.Ancestry.Children)),
 	}
+	copy(ancestry.Children, group.Ancestry.Children)
 
 	if parent == nil {
 		// Root node
@@ -56,10 +58,6 @@ func (h *HierarchyManager) buildAncestry(ctx context.Context, group *Group, pare
 		// Build the full path string
 		ancestry.Path = parent.Ancestry.Path + "/" + group.ID
 		ancestry.Depth = parent.Ancestry.Depth + 1
-
-		// Keep the existing children list
-		ancestry.Children = make([]string, len(group.Ancestry.Children))
-		copy(ancestry.Children, group.Ancestry.Children)
 	}
 
 	return ancestry, nil
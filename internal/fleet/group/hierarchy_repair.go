@@ -0,0 +1,368 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuarantineGroupName names the root group that hierarchy repair moves
+// orphaned groups beneath
+const QuarantineGroupName = "quarantine"
+
+// RepairKind describes what a hierarchy repair changed
+type RepairKind string
+
+const (
+	// RepairQuarantineCreated means the quarantine root group was created
+	RepairQuarantineCreated RepairKind = "create_quarantine"
+	// RepairReparented means an orphaned group was moved beneath the
+	// quarantine root
+	RepairReparented RepairKind = "reparent"
+	// RepairAncestryRebuilt means a group's path and depth were rebuilt
+	RepairAncestryRebuilt RepairKind = "ancestry"
+	// RepairChildrenRebuilt means a group's list of children was rebuilt
+	RepairChildrenRebuilt RepairKind = "children"
+)
+
+// RepairAction records one change made, or that would be made, by a
+// hierarchy repair
+type RepairAction struct {
+	GroupID   string     `json:"group_id"`
+	GroupName string     `json:"group_name"`
+	Kind      RepairKind `json:"kind"`
+	Detail    string     `json:"detail"`
+}
+
+// RepairReport is the outcome of a hierarchy repair
+type RepairReport struct {
+	TenantID string `json:"tenant_id"`
+	DryRun   bool   `json:"dry_run"`
+
+	// Groups is the number of groups checked
+	Groups int `json:"groups"`
+
+	// QuarantineID is the ID of the group orphans were moved beneath, if
+	// there were any. In a dry run, a quarantine group that does not exist
+	// yet has an ID that is not stored.
+	QuarantineID string `json:"quarantine_id,omitempty"`
+
+	Actions []RepairAction `json:"actions"`
+}
+
+// RepairHierarchy rebuilds the ancestry of every group of a tenant from
+// ParentID, which is taken as the source of truth. Groups whose parent is
+// missing, and one group of each cycle of parents, are orphans: they are
+// moved beneath a root group named QuarantineGroupName, which is created
+// when the tenant has none. Every group's Path, PathParts and Depth then
+// follow from its parents, and its Children from the groups naming it as
+// their parent.
+//
+// A dry run reports the repairs without storing them. Repairs are written
+// group by group, parents first, and are not rolled back: when a write
+// fails, the groups already written keep their repaired ancestry and the
+// error lists them in its FieldRepairedGroups field. Running the repair
+// again completes it.
+func (h *HierarchyManager) RepairHierarchy(ctx context.Context, tenantID string, dryRun bool) (*RepairReport, error) {
+	const op = "HierarchyManager.RepairHierarchy"
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	groups, err := h.store.List(ctx, tenantID, ListOptions{})
+	if err != nil {
+		return nil, storeError(op, "failed to list groups", err)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+
+	report := &RepairReport{
+		TenantID: tenantID,
+		DryRun:   dryRun,
+		Groups:   len(groups),
+		Actions:  make([]RepairAction, 0),
+	}
+
+	byID := make(map[string]*Group, len(groups))
+	parents := make(map[string]string, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+		parents[g.ID] = g.ParentID
+	}
+
+	orphans := findOrphans(groups, byID, parents)
+
+	var created *Group
+	if len(orphans) > 0 {
+		quarantine := findQuarantine(groups, orphans, parents)
+		if quarantine == nil {
+			created = New(tenantID, QuarantineGroupName, TypeStatic)
+			created.Description = "Groups whose parent was lost, moved here by hierarchy repair"
+			quarantine = created
+			byID[created.ID] = created
+			parents[created.ID] = ""
+			groups = append(groups, created)
+			report.Actions = append(report.Actions, RepairAction{
+				GroupID:   created.ID,
+				GroupName: created.Name,
+				Kind:      RepairQuarantineCreated,
+				Detail:    "created quarantine root group for orphaned groups",
+			})
+		}
+		report.QuarantineID = quarantine.ID
+
+		ids := make([]string, 0, len(orphans))
+		for id := range orphans {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			parents[id] = quarantine.ID
+			report.Actions = append(report.Actions, RepairAction{
+				GroupID:   id,
+				GroupName: byID[id].Name,
+				Kind:      RepairReparented,
+				Detail:    orphans[id] + "; moved beneath quarantine group",
+			})
+		}
+	}
+
+	repaired := rebuildAncestry(groups, parents)
+	sort.Slice(groups, func(i, j int) bool {
+		return repaired[groups[i].ID].Ancestry.Path < repaired[groups[j].ID].Ancestry.Path
+	})
+
+	now := time.Now().UTC()
+	written := make([]string, 0)
+	for _, g := range groups {
+		fixed := repaired[g.ID]
+		if g == created {
+			if !dryRun {
+				if err := h.store.Create(ctx, fixed); err != nil {
+					return nil, partialRepairError(op, "failed to create quarantine group", err, written)
+				}
+				written = append(written, fixed.ID)
+			}
+			continue
+		}
+
+		actions := compareAncestry(g, fixed)
+		if len(actions) == 0 && g.ParentID == fixed.ParentID {
+			continue
+		}
+		report.Actions = append(report.Actions, actions...)
+		if dryRun {
+			continue
+		}
+		fixed.UpdatedAt = now
+		if err := h.store.Update(ctx, fixed); err != nil {
+			return nil, partialRepairError(op, fmt.Sprintf("failed to update group %s", g.ID), err, written).
+				WithGroupID(g.ID)
+		}
+		written = append(written, g.ID)
+	}
+
+	return report, nil
+}
+
+// partialRepairError reports a failed repair write along with the groups
+// written before it
+func partialRepairError(op, message string, err error, written []string) *Error {
+	if len(written) > 0 {
+		message = fmt.Sprintf("%s after repairing %d groups", message, len(written))
+	}
+	return storeError(op, message, err).WithField(FieldRepairedGroups, written)
+}
+
+// findOrphans returns the groups whose parent is missing and one group of
+// each cycle of parents, with why each is an orphan. Orphans are made
+// roots in parents.
+func findOrphans(groups []*Group, byID map[string]*Group, parents map[string]string) map[string]string {
+	orphans := make(map[string]string)
+	for _, g := range groups {
+		switch {
+		case g.ParentID == "":
+		case g.ParentID == g.ID:
+			orphans[g.ID] = "group is its own parent"
+		case byID[g.ParentID] == nil:
+			orphans[g.ID] = fmt.Sprintf("parent %s not found", g.ParentID)
+		default:
+			continue
+		}
+		parents[g.ID] = ""
+	}
+
+	// Walk up from every group; reaching a group on the current walk
+	// again closes a cycle, which is broken at its smallest ID.
+	const (
+		unvisited = iota
+		walking
+		done
+	)
+	state := make(map[string]int, len(groups))
+	for _, g := range groups {
+		var walk []string
+		id := g.ID
+		for id != "" && state[id] == unvisited {
+			state[id] = walking
+			walk = append(walk, id)
+			id = parents[id]
+		}
+		if id != "" && state[id] == walking {
+			cycle := walk
+			for i, member := range walk {
+				if member == id {
+					cycle = walk[i:]
+					break
+				}
+			}
+			breakAt := cycle[0]
+			for _, member := range cycle[1:] {
+				if member < breakAt {
+					breakAt = member
+				}
+			}
+			orphans[breakAt] = fmt.Sprintf("parent chain forms a cycle through %d groups", len(cycle))
+			parents[breakAt] = ""
+		}
+		for _, member := range walk {
+			state[member] = done
+		}
+	}
+	return orphans
+}
+
+// findQuarantine returns an existing quarantine root group, or nil
+func findQuarantine(groups []*Group, orphans map[string]string, parents map[string]string) *Group {
+	for _, g := range groups {
+		if g.Name != QuarantineGroupName || g.Type != TypeStatic || parents[g.ID] != "" {
+			continue
+		}
+		if _, ok := orphans[g.ID]; ok {
+			continue
+		}
+		return g
+	}
+	return nil
+}
+
+// rebuildAncestry returns copies of the groups with their ancestry rebuilt
+// from parents, which must be free of cycles and missing groups
+func rebuildAncestry(groups []*Group, parents map[string]string) map[string]*Group {
+	byID := make(map[string]*Group, len(groups))
+	children := make(map[string][]string, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+		if parent := parents[g.ID]; parent != "" {
+			children[parent] = append(children[parent], g.ID)
+		}
+	}
+
+	repaired := make(map[string]*Group, len(groups))
+	var build func(id string) *Group
+	build = func(id string) *Group {
+		if fixed, ok := repaired[id]; ok {
+			return fixed
+		}
+		g := byID[id]
+		fixed := g.DeepCopy()
+		fixed.ParentID = parents[id]
+		ancestry := AncestryInfo{
+			Path:      "/" + id,
+			PathParts: []string{id},
+			Children:  orderChildren(g.Ancestry.Children, children[id]),
+		}
+		if fixed.ParentID != "" {
+			parent := build(fixed.ParentID)
+			ancestry.PathParts = append(append([]string{}, parent.Ancestry.PathParts...), id)
+			ancestry.Path = parent.Ancestry.Path + "/" + id
+			ancestry.Depth = parent.Ancestry.Depth + 1
+		}
+		fixed.Ancestry = ancestry
+		repaired[id] = fixed
+		return fixed
+	}
+	for _, g := range groups {
+		build(g.ID)
+	}
+	return repaired
+}
+
+// orderChildren keeps the children already listed in their order, without
+// stale or duplicate entries, followed by the missing ones by ID
+func orderChildren(listed, actual []string) []string {
+	want := make(map[string]bool, len(actual))
+	for _, id := range actual {
+		want[id] = true
+	}
+	out := make([]string, 0, len(actual))
+	for _, id := range listed {
+		if want[id] {
+			out = append(out, id)
+			delete(want, id)
+		}
+	}
+	missing := make([]string, 0, len(want))
+	for id := range want {
+		missing = append(missing, id)
+	}
+	sort.Strings(missing)
+	return append(out, missing...)
+}
+
+// compareAncestry describes how a group's ancestry differs from its
+// repaired ancestry
+func compareAncestry(g, fixed *Group) []RepairAction {
+	var actions []RepairAction
+	old, repaired := g.Ancestry, fixed.Ancestry
+
+	if old.Path != repaired.Path || old.Depth != repaired.Depth ||
+		strings.Join(old.PathParts, "/") != strings.Join(repaired.PathParts, "/") {
+		actions = append(actions, RepairAction{
+			GroupID:   g.ID,
+			GroupName: g.Name,
+			Kind:      RepairAncestryRebuilt,
+			Detail: fmt.Sprintf("path %s at depth %d, was %s at depth %d",
+				repaired.Path, repaired.Depth, orNone(old.Path), old.Depth),
+		})
+	}
+
+	if strings.Join(old.Children, ",") != strings.Join(repaired.Children, ",") {
+		var added, removed []string
+		for _, id := range repaired.Children {
+			if !contains(old.Children, id) {
+				added = append(added, id)
+			}
+		}
+		for _, id := range old.Children {
+			if !contains(repaired.Children, id) && !contains(removed, id) {
+				removed = append(removed, id)
+			}
+		}
+		var parts []string
+		if len(added) > 0 {
+			parts = append(parts, "added "+strings.Join(added, ","))
+		}
+		if len(removed) > 0 {
+			parts = append(parts, "removed "+strings.Join(removed, ","))
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "removed duplicate entries")
+		}
+		actions = append(actions, RepairAction{
+			GroupID:   g.ID,
+			GroupName: g.Name,
+			Kind:      RepairChildrenRebuilt,
+			Detail:    "children " + strings.Join(parts, "; "),
+		})
+	}
+	return actions
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,171 @@
+package group_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	devmem "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestRepairHierarchy(t *testing.T) {
+	env := setupTestEnv(t)
+
+	create := func(name string) *group.Group {
+		g := group.New(env.tenantID, name, group.TypeStatic)
+		require.NoError(t, env.store.Create(env.ctx, g))
+		return g
+	}
+	get := func(g *group.Group) *group.Group {
+		got, err := env.store.Get(env.ctx, env.tenantID, g.ID)
+		require.NoError(t, err)
+		return got
+	}
+	corrupt := func(g *group.Group, fn func(g *group.Group)) {
+		current := get(g)
+		fn(current)
+		require.NoError(t, env.store.Update(env.ctx, current))
+	}
+	kinds := func(report *group.RepairReport) map[string][]group.RepairKind {
+		out := make(map[string][]group.RepairKind)
+		for _, action := range report.Actions {
+			out[action.GroupName] = append(out[action.GroupName], action.Kind)
+		}
+		return out
+	}
+
+	root, a, b := create("root"), create("a"), create("b")
+	require.NoError(t, env.hierarchy.UpdateHierarchy(env.ctx, a, root.ID))
+	require.NoError(t, env.hierarchy.UpdateHierarchy(env.ctx, b, a.ID))
+	orphan, x, y := create("orphan"), create("x"), create("y")
+
+	corrupt(b, func(g *group.Group) {
+		g.Ancestry.Path = "/" + g.ID
+		g.Ancestry.PathParts = []string{g.ID}
+		g.Ancestry.Depth = 0
+	})
+	corrupt(root, func(g *group.Group) {
+		g.Ancestry.Children = []string{a.ID, "ghost", a.ID}
+	})
+	corrupt(orphan, func(g *group.Group) { g.ParentID = "missing" })
+	corrupt(x, func(g *group.Group) { g.ParentID = y.ID })
+	corrupt(y, func(g *group.Group) { g.ParentID = x.ID })
+	require.Error(t, env.hierarchy.ValidateHierarchyIntegrity(env.ctx, env.tenantID))
+
+	// A dry run reports the repairs and changes nothing.
+	before, err := env.store.List(env.ctx, env.tenantID, group.ListOptions{})
+	require.NoError(t, err)
+	report, err := env.hierarchy.RepairHierarchy(env.ctx, env.tenantID, true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 6, report.Groups)
+	assert.NotEmpty(t, report.QuarantineID)
+
+	cycleBreak := x
+	if y.ID < x.ID {
+		cycleBreak = y
+	}
+	got := kinds(report)
+	assert.Equal(t, []group.RepairKind{group.RepairQuarantineCreated}, got[group.QuarantineGroupName])
+	assert.Equal(t, []group.RepairKind{group.RepairReparented, group.RepairAncestryRebuilt}, got["orphan"])
+	// The other group of the cycle stays beneath the one the cycle was
+	// broken at.
+	assert.Equal(t, []group.RepairKind{group.RepairReparented, group.RepairAncestryRebuilt, group.RepairChildrenRebuilt},
+		got[cycleBreak.Name])
+	assert.Equal(t, []group.RepairKind{group.RepairAncestryRebuilt}, got["b"])
+	assert.Equal(t, []group.RepairKind{group.RepairChildrenRebuilt}, got["root"])
+	assert.NotContains(t, got, "a")
+
+	after, err := env.store.List(env.ctx, env.tenantID, group.ListOptions{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, before, after)
+
+	// The repair leaves a hierarchy that validates.
+	report, err = env.hierarchy.RepairHierarchy(env.ctx, env.tenantID, false)
+	require.NoError(t, err)
+	require.NoError(t, env.hierarchy.ValidateHierarchyIntegrity(env.ctx, env.tenantID))
+
+	quarantine, err := env.store.Get(env.ctx, env.tenantID, report.QuarantineID)
+	require.NoError(t, err)
+	assert.Equal(t, group.QuarantineGroupName, quarantine.Name)
+	assert.Empty(t, quarantine.ParentID)
+	assert.Equal(t, quarantine.ID, get(orphan).ParentID)
+	assert.Equal(t, quarantine.ID, get(cycleBreak).ParentID)
+	assert.Equal(t, "/"+root.ID+"/"+a.ID+"/"+b.ID, get(b).Ancestry.Path)
+	assert.Equal(t, 2, get(b).Ancestry.Depth)
+	assert.Equal(t, []string{a.ID}, get(root).Ancestry.Children)
+	for _, g := range []*group.Group{root, a, b, orphan, x, y, quarantine} {
+		verifyHierarchyState(t, env, g)
+	}
+
+	// A consistent hierarchy needs no repairs.
+	report, err = env.hierarchy.RepairHierarchy(env.ctx, env.tenantID, false)
+	require.NoError(t, err)
+	assert.Equal(t, 7, report.Groups)
+	assert.Empty(t, report.Actions)
+	assert.Empty(t, report.QuarantineID)
+
+	// Later orphans join the existing quarantine group with their subtree.
+	require.NoError(t, env.store.Delete(env.ctx, env.tenantID, root.ID))
+	report, err = env.hierarchy.RepairHierarchy(env.ctx, env.tenantID, false)
+	require.NoError(t, err)
+	assert.Equal(t, quarantine.ID, report.QuarantineID)
+	assert.Equal(t, []group.RepairKind{group.RepairChildrenRebuilt}, kinds(report)[group.QuarantineGroupName])
+	assert.Equal(t, "/"+quarantine.ID+"/"+a.ID+"/"+b.ID, get(b).Ancestry.Path)
+	require.NoError(t, env.hierarchy.ValidateHierarchyIntegrity(env.ctx, env.tenantID))
+}
+
+// failingUpdates fails updates of one group
+type failingUpdates struct {
+	group.Store
+	groupID string
+}
+
+func (s *failingUpdates) Update(ctx context.Context, g *group.Group) error {
+	if g.ID == s.groupID {
+		return errors.New("disk full")
+	}
+	return s.Store.Update(ctx, g)
+}
+
+func TestRepairHierarchyPartialFailure(t *testing.T) {
+	env := setupTestEnv(t)
+
+	root := group.New(env.tenantID, "root", group.TypeStatic)
+	require.NoError(t, env.store.Create(env.ctx, root))
+	a := group.New(env.tenantID, "a", group.TypeStatic)
+	require.NoError(t, env.store.Create(env.ctx, a))
+	b := group.New(env.tenantID, "b", group.TypeStatic)
+	require.NoError(t, env.store.Create(env.ctx, b))
+	require.NoError(t, env.hierarchy.UpdateHierarchy(env.ctx, a, root.ID))
+	require.NoError(t, env.hierarchy.UpdateHierarchy(env.ctx, b, a.ID))
+
+	// Losing the root orphans a, whose subtree is rewritten beneath the
+	// quarantine group; the write of b fails.
+	require.NoError(t, env.store.Delete(env.ctx, env.tenantID, root.ID))
+	service := group.NewService(&failingUpdates{Store: env.store, groupID: b.ID}, devmem.New(), zaptest.NewLogger(t))
+	_, err := service.RepairHierarchy(env.ctx, env.tenantID, false)
+	require.Error(t, err)
+	var groupErr *group.Error
+	require.True(t, errors.As(err, &groupErr), "got %v", err)
+	assert.Equal(t, group.ErrCodeStoreOperation, groupErr.Code)
+	written, ok := groupErr.Fields[group.FieldRepairedGroups].([]string)
+	require.True(t, ok, "fields %v", groupErr.Fields)
+	require.Len(t, written, 2, "the quarantine group and a")
+	assert.Equal(t, a.ID, written[1])
+	require.Error(t, env.hierarchy.ValidateHierarchyIntegrity(env.ctx, env.tenantID))
+
+	// Running the repair again completes it.
+	report, err := env.hierarchy.RepairHierarchy(env.ctx, env.tenantID, false)
+	require.NoError(t, err)
+	require.Len(t, report.Actions, 1)
+	assert.Equal(t, group.RepairAncestryRebuilt, report.Actions[0].Kind)
+	got, err := env.store.Get(env.ctx, env.tenantID, b.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "/"+written[0]+"/"+a.ID+"/"+b.ID, got.Ancestry.Path)
+	require.NoError(t, env.hierarchy.ValidateHierarchyIntegrity(env.ctx, env.tenantID))
+}
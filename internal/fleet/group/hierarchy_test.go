@@ -96,6 +96,15 @@ func TestHierarchy(t *testing.T) {
 
 		// Verify complete hierarchy
 		require.NoError(t, env.hierarchy.ValidateHierarchyIntegrity(env.ctx, env.tenantID))
+
+		// A subtree moved to the root keeps its children
+		require.NoError(t, env.hierarchy.UpdateHierarchy(env.ctx, child1, ""))
+		moved, err := env.store.Get(env.ctx, env.tenantID, child1.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{grandchild.ID}, moved.Ancestry.Children)
+		verifyHierarchyState(t, env, child1)
+		verifyHierarchyState(t, env, grandchild)
+		require.NoError(t, env.hierarchy.ValidateHierarchyIntegrity(env.ctx, env.tenantID))
 	})
 
 	t.Run("HierarchyModificationScenarios", func(t *testing.T) {
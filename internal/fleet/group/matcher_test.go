@@ -0,0 +1,46 @@
+package group
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestMembershipMatcherCache(t *testing.T) {
+	e := newMembershipEvaluator(nil, nil, nil, zaptest.NewLogger(t))
+	g := New("tenant-1", "prod", TypeDynamic)
+	g.Query = &MembershipQuery{Tags: map[string]string{"env": "prod"}}
+
+	prod := device.New("tenant-1", "edge-1")
+	prod.Tags["env"] = "prod"
+
+	// The compiled query is reused while the group is unchanged.
+	m := e.matcher(g)
+	require.NotNil(t, m)
+	assert.True(t, m.Matches(prod))
+	assert.Same(t, m, e.matcher(g))
+
+	// Invalidation picks up a query changed in place.
+	g.Query = &MembershipQuery{Tags: map[string]string{"env": "staging"}}
+	e.invalidate(g.TenantID, g.ID)
+	m = e.matcher(g)
+	assert.False(t, m.Matches(prod))
+
+	// So does a group updated elsewhere.
+	updated := g.DeepCopy()
+	updated.Query = &MembershipQuery{Tags: map[string]string{"env": "prod"}}
+	updated.UpdatedAt = g.UpdatedAt.Add(time.Second)
+	assert.True(t, e.matcher(updated).Matches(prod))
+
+	// Invalid queries match nothing.
+	updated.Query = &MembershipQuery{Custom: CustomExpression(`tags.env ==`)}
+	e.invalidate(g.TenantID, g.ID)
+	assert.Nil(t, e.matcher(updated))
+
+	e.forget(g.TenantID, g.ID)
+	assert.Empty(t, e.matchers)
+}
@@ -0,0 +1,373 @@
+package group
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/expr"
+	"go.uber.org/zap"
+)
+
+// RegionTag is the device tag a membership query's regions are matched
+// against
+const RegionTag = "region"
+
+// MembershipChange describes how a device's membership in a group changed
+type MembershipChange string
+
+const (
+	// MembershipJoined means the device started matching the group's query
+	MembershipJoined MembershipChange = "joined"
+	// MembershipLeft means the device stopped matching the group's query,
+	// or was deleted
+	MembershipLeft MembershipChange = "left"
+)
+
+// MembershipEvent records a device joining or leaving a dynamic group
+type MembershipEvent struct {
+	TenantID  string           `json:"tenant_id"`
+	GroupID   string           `json:"group_id"`
+	GroupName string           `json:"group_name"`
+	DeviceID  string           `json:"device_id"`
+	Change    MembershipChange `json:"change"`
+	At        time.Time        `json:"at"`
+}
+
+// MembershipHandler receives membership changes of dynamic groups
+type MembershipHandler func(ctx context.Context, event MembershipEvent)
+
+// CustomExpression encodes an expression as the custom criteria of a
+// membership query
+func CustomExpression(source string) json.RawMessage {
+	data, _ := json.Marshal(source)
+	return data
+}
+
+// Expression returns the expression held in the query's custom criteria,
+// which must be a JSON string. It is empty when no custom criteria are set.
+func (q *MembershipQuery) Expression() (string, error) {
+	const op = "MembershipQuery.Expression"
+
+	if len(bytes.TrimSpace(q.Custom)) == 0 || bytes.Equal(bytes.TrimSpace(q.Custom), []byte("null")) {
+		return "", nil
+	}
+	var source string
+	if err := json.Unmarshal(q.Custom, &source); err != nil {
+		return "", E(op, ErrCodeInvalidGroup, "custom criteria must be an expression string", err)
+	}
+	return source, nil
+}
+
+// Matcher is a membership query prepared for matching devices
+type Matcher struct {
+	query  *MembershipQuery
+	custom *expr.Program
+}
+
+// Compile validates the query and prepares it for matching. The custom
+// criteria are compiled as an expression over device fields.
+func (q *MembershipQuery) Compile() (*Matcher, error) {
+	const op = "MembershipQuery.Compile"
+
+	if q == nil {
+		return nil, E(op, ErrCodeInvalidGroup, "query cannot be nil", nil)
+	}
+	m := &Matcher{query: q}
+	source, err := q.Expression()
+	if err != nil {
+		return nil, err
+	}
+	if source != "" {
+		m.custom, err = expr.Compile(source)
+		if err != nil {
+			out := E(op, ErrCodeInvalidGroup, "invalid custom criteria", err)
+			var exprErr *expr.Error
+			if errors.As(err, &exprErr) {
+				out.Message += ": " + exprErr.Message
+				for key, value := range exprErr.Fields {
+					out.WithField(key, value)
+				}
+			}
+			return nil, out
+		}
+	}
+	return m, nil
+}
+
+// Matches reports whether a device satisfies every criterion set in the
+// query. Tags must all be present with equal values, the status must be
+// equal, the device's region tag must be one of the regions and the custom
+// expression must hold. A query without criteria matches every device of
+// the tenant.
+func (m *Matcher) Matches(d *device.Device) bool {
+	q := m.query
+	for key, value := range q.Tags {
+		if got, ok := d.Tags[key]; !ok || got != value {
+			return false
+		}
+	}
+	if q.Status != "" && d.Status != q.Status {
+		return false
+	}
+	if len(q.Regions) > 0 {
+		region, ok := d.Tags[RegionTag]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, r := range q.Regions {
+			if r == region {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.custom != nil && !m.custom.Match(d) {
+		return false
+	}
+	return true
+}
+
+// Matches reports whether a device satisfies the query, as Matcher.Matches
+// does. An invalid query matches no device. Compile the query instead when
+// matching many devices.
+func (q *MembershipQuery) Matches(d *device.Device) bool {
+	m, err := q.Compile()
+	if err != nil {
+		return false
+	}
+	return m.Matches(d)
+}
+
+// membershipEvaluator keeps the membership of dynamic groups current. It
+// remembers the members each group had when last evaluated, so changes can
+// be reported as events, and refreshes a group whenever a device may have
+// joined or left it.
+//
+// Members are only remembered in memory. The first evaluation of a group
+// after a restart cannot tell which members are new, so it records them
+// without reporting events. Groups created since start-up are known to
+// have started out empty.
+type membershipEvaluator struct {
+	store       Store
+	deviceStore device.Store
+	rollups     *rollupCache
+	logger      *zap.Logger
+	handlers    []MembershipHandler
+
+	// evalMu serializes evaluations, from listing a group's members to
+	// reporting how they changed, so an evaluation that read the devices
+	// earlier cannot overwrite the members recorded by a later one.
+	// Handlers run while it is held and must not evaluate membership.
+	evalMu sync.Mutex
+
+	// mu guards members and matchers, keyed by tenant and group ID
+	mu       sync.Mutex
+	members  map[string]map[string]struct{}
+	matchers map[string]cachedMatcher
+}
+
+// cachedMatcher is the compiled query of a dynamic group as of the group's
+// last update. A nil matcher stands for an invalid query.
+type cachedMatcher struct {
+	matcher   *Matcher
+	updatedAt time.Time
+}
+
+func newMembershipEvaluator(store Store, deviceStore device.Store, rollups *rollupCache, logger *zap.Logger) *membershipEvaluator {
+	return &membershipEvaluator{
+		store:       store,
+		deviceStore: deviceStore,
+		rollups:     rollups,
+		logger:      logger,
+		members:     make(map[string]map[string]struct{}),
+		matchers:    make(map[string]cachedMatcher),
+	}
+}
+
+// evaluate resolves a dynamic group's members through the store, which
+// also updates its device count, records them in the group rollups and
+// reports how they changed since the last evaluation.
+func (e *membershipEvaluator) evaluate(ctx context.Context, g *Group) ([]*device.Device, error) {
+	const op = "group.evaluateMembership"
+
+	e.evalMu.Lock()
+	defer e.evalMu.Unlock()
+
+	devices, err := e.store.ListDevices(ctx, g.TenantID, g.ID)
+	if err != nil {
+		return nil, E(op, ErrCodeStoreOperation, "failed to evaluate dynamic group members", err).
+			WithField(FieldGroupID, g.ID)
+	}
+
+	e.rollups.membersEvaluated(g.TenantID, g.ID, devices)
+
+	current := make(map[string]struct{}, len(devices))
+	for _, d := range devices {
+		current[d.ID] = struct{}{}
+	}
+
+	e.mu.Lock()
+	key := membershipKey(g.TenantID, g.ID)
+	previous, known := e.members[key]
+	e.members[key] = current
+	e.mu.Unlock()
+	if !known {
+		return devices, nil
+	}
+
+	now := time.Now().UTC()
+	var events []MembershipEvent
+	for id := range current {
+		if _, ok := previous[id]; !ok {
+			events = append(events, e.event(g, id, MembershipJoined, now))
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			events = append(events, e.event(g, id, MembershipLeft, now))
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].DeviceID < events[j].DeviceID })
+
+	for _, event := range events {
+		e.logger.Info("dynamic group membership changed",
+			zap.String("group_id", event.GroupID),
+			zap.String("device_id", event.DeviceID),
+			zap.String("tenant_id", event.TenantID),
+			zap.String("change", string(event.Change)),
+		)
+		for _, handle := range e.handlers {
+			handle(ctx, event)
+		}
+	}
+	return devices, nil
+}
+
+func (e *membershipEvaluator) event(g *Group, deviceID string, change MembershipChange, at time.Time) MembershipEvent {
+	return MembershipEvent{
+		TenantID:  g.TenantID,
+		GroupID:   g.ID,
+		GroupName: g.Name,
+		DeviceID:  deviceID,
+		Change:    change,
+		At:        at,
+	}
+}
+
+// deviceChanged records the device's new state in the group rollups and
+// re-evaluates the dynamic groups of a tenant whose membership of the
+// device no longer matches what was last evaluated.
+func (e *membershipEvaluator) deviceChanged(ctx context.Context, tenantID, deviceID string) error {
+	const op = "group.deviceChanged"
+
+	// A device that cannot be found was deleted and leaves every group.
+	dev, err := e.deviceStore.Get(ctx, tenantID, deviceID)
+	if err != nil {
+		var devErr *device.Error
+		if !errors.As(err, &devErr) || devErr.Code != device.ErrCodeDeviceNotFound {
+			return E(op, ErrCodeStoreOperation, "failed to get device", err)
+		}
+		dev = nil
+	}
+	e.rollups.deviceChanged(tenantID, deviceID, dev)
+
+	groups, err := e.store.List(ctx, tenantID, ListOptions{Type: TypeDynamic})
+	if err != nil {
+		return E(op, ErrCodeStoreOperation, "failed to list dynamic groups", err)
+	}
+
+	for _, g := range groups {
+		m := e.matcher(g)
+		matches := dev != nil && m != nil && m.Matches(dev)
+		// The rollups may have been built from groups never evaluated
+		// here, so they are told about the match either way.
+		if matches {
+			e.rollups.membersAdded(tenantID, g.ID, dev)
+		} else {
+			e.rollups.memberRemoved(tenantID, g.ID, deviceID)
+		}
+		if matches == e.isMember(g, deviceID) {
+			continue
+		}
+		if _, err := e.evaluate(ctx, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// created records that a new group has no members yet, so its first
+// evaluation reports the devices that join it
+func (e *membershipEvaluator) created(tenantID, groupID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.members[membershipKey(tenantID, groupID)] = make(map[string]struct{})
+}
+
+// evaluated reports whether the group's members are known from an earlier
+// evaluation
+func (e *membershipEvaluator) evaluated(tenantID, groupID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.members[membershipKey(tenantID, groupID)]
+	return ok
+}
+
+// isMember reports whether the device was a member of the group when it was
+// last evaluated
+func (e *membershipEvaluator) isMember(g *Group, deviceID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.members[membershipKey(g.TenantID, g.ID)][deviceID]
+	return ok
+}
+
+// matcher returns the compiled query of a dynamic group, compiling it when
+// the group was changed since it was last compiled. Device updates match
+// every dynamic group of the tenant, so queries are not compiled each time.
+// It returns nil when the query is invalid.
+func (e *membershipEvaluator) matcher(g *Group) *Matcher {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := membershipKey(g.TenantID, g.ID)
+	if cached, ok := e.matchers[key]; ok && cached.updatedAt.Equal(g.UpdatedAt) {
+		return cached.matcher
+	}
+	m, err := g.Query.Compile()
+	if err != nil {
+		m = nil
+	}
+	e.matchers[key] = cachedMatcher{matcher: m, updatedAt: g.UpdatedAt}
+	return m
+}
+
+// invalidate drops the compiled query of an updated group
+func (e *membershipEvaluator) invalidate(tenantID, groupID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.matchers, membershipKey(tenantID, groupID))
+}
+
+// forget drops what is remembered about a deleted group
+func (e *membershipEvaluator) forget(tenantID, groupID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := membershipKey(tenantID, groupID)
+	delete(e.members, key)
+	delete(e.matchers, key)
+}
+
+func membershipKey(tenantID, groupID string) string {
+	return tenantID + "/" + groupID
+}
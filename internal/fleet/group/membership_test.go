@@ -0,0 +1,333 @@
+package group_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/device/expr"
+	devmem "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	grpmem "github.com/wrale/wrale-fleet/internal/fleet/group/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestMembershipQueryMatches(t *testing.T) {
+	dev := device.New("tenant-1", "edge-1")
+	dev.Status = device.StatusOnline
+	dev.Tags = map[string]string{"env": "prod", group.RegionTag: "eu-west-1"}
+
+	tests := []struct {
+		name  string
+		query *group.MembershipQuery
+		want  bool
+	}{
+		{"empty", &group.MembershipQuery{}, true},
+		{"nil", nil, false},
+		{"tags", &group.MembershipQuery{Tags: map[string]string{"env": "prod"}}, true},
+		{"tag mismatch", &group.MembershipQuery{Tags: map[string]string{"env": "staging"}}, false},
+		{"missing tag", &group.MembershipQuery{Tags: map[string]string{"rack": "a1"}}, false},
+		{"status", &group.MembershipQuery{Status: device.StatusOnline}, true},
+		{"status mismatch", &group.MembershipQuery{Status: device.StatusOffline}, false},
+		{"region", &group.MembershipQuery{Regions: []string{"us-east-1", "eu-west-1"}}, true},
+		{"region mismatch", &group.MembershipQuery{Regions: []string{"us-east-1"}}, false},
+		{"all criteria", &group.MembershipQuery{
+			Tags:    map[string]string{"env": "prod"},
+			Status:  device.StatusOnline,
+			Regions: []string{"eu-west-1"},
+		}, true},
+		{"custom", &group.MembershipQuery{Custom: group.CustomExpression(`tags.env == "prod" && status != "offline"`)}, true},
+		{"custom mismatch", &group.MembershipQuery{Custom: group.CustomExpression(`name =~ "^pi-"`)}, false},
+		{"custom with tags", &group.MembershipQuery{
+			Tags:   map[string]string{"env": "staging"},
+			Custom: group.CustomExpression(`tags.env == "prod"`),
+		}, false},
+		{"custom invalid", &group.MembershipQuery{Custom: group.CustomExpression(`serial == "1"`)}, false},
+		{"custom null", &group.MembershipQuery{Custom: json.RawMessage(`null`)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.query.Matches(dev))
+		})
+	}
+}
+
+func TestMembershipQueryCompile(t *testing.T) {
+	_, err := (&group.MembershipQuery{Custom: json.RawMessage(`{"expr":"x"}`)}).Compile()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "custom criteria must be an expression string")
+
+	_, err = (&group.MembershipQuery{Custom: group.CustomExpression(`status in ["online"`)}).Compile()
+	require.Error(t, err)
+	var groupErr *group.Error
+	require.True(t, errors.As(err, &groupErr))
+	assert.Equal(t, group.ErrCodeInvalidGroup, groupErr.Code)
+	assert.Equal(t, 19, groupErr.Fields[expr.FieldPosition])
+	var exprErr *expr.Error
+	require.True(t, errors.As(err, &exprErr))
+	assert.Equal(t, expr.ErrCodeSyntax, exprErr.Code)
+
+	source, err := (&group.MembershipQuery{Custom: group.CustomExpression("secure_boot")}).Expression()
+	require.NoError(t, err)
+	assert.Equal(t, "secure_boot", source)
+}
+
+func TestDynamicMembership(t *testing.T) {
+	ctx := device.ContextWithTenant(context.Background(), "tenant-1")
+	logger := zaptest.NewLogger(t)
+	deviceStore := devmem.New()
+	devices := device.NewService(deviceStore, logger)
+
+	var events []group.MembershipEvent
+	service := group.NewService(grpmem.New(deviceStore), deviceStore, logger,
+		group.WithMembershipHandler(func(ctx context.Context, event group.MembershipEvent) {
+			events = append(events, event)
+		}))
+	devices.OnChange(service.DeviceChanged)
+
+	existing, err := devices.Register(ctx, "tenant-1", "edge-1")
+	require.NoError(t, err)
+	existing.Tags["env"] = "prod"
+	require.NoError(t, devices.Update(ctx, existing))
+
+	online, err := service.CreateDynamic(ctx, "tenant-1", "online prod", &group.MembershipQuery{
+		Tags:   map[string]string{"env": "prod"},
+		Status: device.StatusOnline,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, online.DeviceCount)
+	assert.Empty(t, events)
+
+	// Devices join as they change status or are re-tagged.
+	require.NoError(t, devices.UpdateStatus(ctx, "tenant-1", existing.ID, device.StatusOnline))
+	require.Len(t, events, 1)
+	assert.Equal(t, group.MembershipEvent{
+		TenantID:  "tenant-1",
+		GroupID:   online.ID,
+		GroupName: "online prod",
+		DeviceID:  existing.ID,
+		Change:    group.MembershipJoined,
+		At:        events[0].At,
+	}, events[0])
+
+	added, err := devices.Register(ctx, "tenant-1", "edge-2")
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+	added.Tags["env"] = "prod"
+	added.Status = device.StatusOnline
+	require.NoError(t, devices.Update(ctx, added))
+	require.Len(t, events, 2)
+	assert.Equal(t, added.ID, events[1].DeviceID)
+
+	got, err := service.Get(ctx, "tenant-1", online.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.DeviceCount)
+
+	// Changes that keep the device matching are not reported.
+	added.Tags["rack"] = "a1"
+	require.NoError(t, devices.Update(ctx, added))
+	assert.Len(t, events, 2)
+
+	// Devices leave when they stop matching or are deleted.
+	require.NoError(t, devices.UpdateStatus(ctx, "tenant-1", existing.ID, device.StatusOffline))
+	require.NoError(t, devices.Delete(ctx, "tenant-1", added.ID))
+	require.Len(t, events, 4)
+	assert.Equal(t, group.MembershipLeft, events[2].Change)
+	assert.Equal(t, existing.ID, events[2].DeviceID)
+	assert.Equal(t, group.MembershipLeft, events[3].Change)
+	assert.Equal(t, added.ID, events[3].DeviceID)
+
+	got, err = service.Get(ctx, "tenant-1", online.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.DeviceCount)
+
+	// Changing the query re-evaluates membership.
+	got.Query = &group.MembershipQuery{Tags: map[string]string{"env": "prod"}}
+	require.NoError(t, service.Update(ctx, got))
+	assert.Equal(t, 1, got.DeviceCount)
+	require.Len(t, events, 5)
+	assert.Equal(t, group.MembershipJoined, events[4].Change)
+
+	members, err := service.ListDevices(ctx, "tenant-1", online.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, existing.ID, members[0].ID)
+}
+
+func TestDynamicMembershipCustomCriteria(t *testing.T) {
+	ctx := device.ContextWithTenant(context.Background(), "tenant-1")
+	logger := zaptest.NewLogger(t)
+	deviceStore := devmem.New()
+	devices := device.NewService(deviceStore, logger)
+	service := group.NewService(grpmem.New(deviceStore), deviceStore, logger)
+	devices.OnChange(service.DeviceChanged)
+
+	// Groups with invalid expressions are rejected when created.
+	_, err := service.CreateDynamic(ctx, "tenant-1", "broken", &group.MembershipQuery{
+		Custom: group.CustomExpression(`network.port == "80"`),
+	})
+	require.Error(t, err)
+	var exprErr *expr.Error
+	require.True(t, errors.As(err, &exprErr), "got %v", err)
+	assert.Equal(t, expr.ErrCodeType, exprErr.Code)
+
+	pis, err := service.CreateDynamic(ctx, "tenant-1", "plant-3 pis", &group.MembershipQuery{
+		Custom: group.CustomExpression(`tags.site == "plant-3" && network.hostname =~ "^pi-"`),
+	})
+	require.NoError(t, err)
+
+	pi, err := devices.Register(ctx, "tenant-1", "pi-1")
+	require.NoError(t, err)
+	pi.Tags["site"] = "plant-3"
+	pi.NetworkInfo = &device.NetworkInfo{Hostname: "pi-1.local"}
+	require.NoError(t, devices.Update(ctx, pi))
+
+	other, err := devices.Register(ctx, "tenant-1", "gw-1")
+	require.NoError(t, err)
+	other.Tags["site"] = "plant-3"
+	other.NetworkInfo = &device.NetworkInfo{Hostname: "gw-1.local"}
+	require.NoError(t, devices.Update(ctx, other))
+
+	members, err := service.ListDevices(ctx, "tenant-1", pis.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, pi.ID, members[0].ID)
+
+	// Updates with invalid expressions are rejected too.
+	got, err := service.Get(ctx, "tenant-1", pis.ID)
+	require.NoError(t, err)
+	got.Query.Custom = group.CustomExpression(`tags.site ==`)
+	require.Error(t, service.Update(ctx, got))
+}
+
+func TestDynamicMembershipAfterRestart(t *testing.T) {
+	ctx := device.ContextWithTenant(context.Background(), "tenant-1")
+	logger := zaptest.NewLogger(t)
+	deviceStore := devmem.New()
+	groupStore := grpmem.New(deviceStore)
+
+	before := group.NewService(groupStore, deviceStore, logger)
+	prod, err := before.CreateDynamic(ctx, "tenant-1", "prod", &group.MembershipQuery{
+		Tags: map[string]string{"env": "prod"},
+	})
+	require.NoError(t, err)
+	var members []*device.Device
+	for _, name := range []string{"edge-1", "edge-2"} {
+		dev := device.New("tenant-1", name)
+		dev.Tags["env"] = "prod"
+		require.NoError(t, deviceStore.Create(ctx, dev))
+		members = append(members, dev)
+	}
+
+	// A new service stands in for the server after a restart.
+	var events []group.MembershipEvent
+	after := group.NewService(groupStore, deviceStore, logger,
+		group.WithMembershipHandler(func(ctx context.Context, event group.MembershipEvent) {
+			events = append(events, event)
+		}))
+	devices := device.NewService(deviceStore, logger)
+	devices.OnChange(after.DeviceChanged)
+
+	// Existing members are not reported as joining.
+	got, err := after.ListDevices(ctx, "tenant-1", prod.ID)
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+	members[0].Tags["rack"] = "a1"
+	require.NoError(t, devices.Update(ctx, members[0]))
+	assert.Empty(t, events)
+
+	// Changes from then on are.
+	members[1].Tags["env"] = "staging"
+	require.NoError(t, devices.Update(ctx, members[1]))
+	require.Len(t, events, 1)
+	assert.Equal(t, group.MembershipLeft, events[0].Change)
+	assert.Equal(t, members[1].ID, events[0].DeviceID)
+}
+
+func TestDynamicMembershipQueryChangeAfterRestart(t *testing.T) {
+	ctx := device.ContextWithTenant(context.Background(), "tenant-1")
+	logger := zaptest.NewLogger(t)
+	deviceStore := devmem.New()
+	groupStore := grpmem.New(deviceStore)
+
+	byEnv := make(map[string]string)
+	for _, env := range []string{"prod", "staging"} {
+		dev := device.New("tenant-1", "edge-"+env)
+		dev.Tags["env"] = env
+		require.NoError(t, deviceStore.Create(ctx, dev))
+		byEnv[env] = dev.ID
+	}
+	before := group.NewService(groupStore, deviceStore, logger)
+	prod, err := before.CreateDynamic(ctx, "tenant-1", "prod", &group.MembershipQuery{
+		Tags: map[string]string{"env": "prod"},
+	})
+	require.NoError(t, err)
+
+	var events []group.MembershipEvent
+	after := group.NewService(groupStore, deviceStore, logger,
+		group.WithMembershipHandler(func(ctx context.Context, event group.MembershipEvent) {
+			events = append(events, event)
+		}))
+
+	// The first update after a restart reports the devices its new query
+	// moves in and out, not every member.
+	prod.Query = &group.MembershipQuery{Tags: map[string]string{"env": "staging"}}
+	require.NoError(t, after.Update(ctx, prod))
+	changes := make(map[string]group.MembershipChange)
+	for _, event := range events {
+		changes[event.DeviceID] = event.Change
+	}
+	assert.Equal(t, map[string]group.MembershipChange{
+		byEnv["prod"]:    group.MembershipLeft,
+		byEnv["staging"]: group.MembershipJoined,
+	}, changes)
+}
+
+// countingStore records how many ListDevices calls run at once
+type countingStore struct {
+	group.Store
+	inFlight, most int32
+}
+
+func (s *countingStore) ListDevices(ctx context.Context, tenantID, groupID string) ([]*device.Device, error) {
+	n := atomic.AddInt32(&s.inFlight, 1)
+	defer atomic.AddInt32(&s.inFlight, -1)
+	for {
+		most := atomic.LoadInt32(&s.most)
+		if n <= most || atomic.CompareAndSwapInt32(&s.most, most, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return s.Store.ListDevices(ctx, tenantID, groupID)
+}
+
+func TestDynamicMembershipEvaluationsAreSerialized(t *testing.T) {
+	ctx := device.ContextWithTenant(context.Background(), "tenant-1")
+	logger := zaptest.NewLogger(t)
+	deviceStore := devmem.New()
+	store := &countingStore{Store: grpmem.New(deviceStore)}
+	service := group.NewService(store, deviceStore, logger)
+
+	all, err := service.CreateDynamic(ctx, "tenant-1", "all", &group.MembershipQuery{})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.ListDevices(ctx, "tenant-1", all.ID)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.most))
+}
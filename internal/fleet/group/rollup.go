@@ -0,0 +1,395 @@
+package group
+
+import (
+	"context"
+	"sync"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+)
+
+// DriftChecker reports whether a device's configuration has drifted from
+// its latest deployment. It is implemented by drift.Detector.
+type DriftChecker interface {
+	Drifted(tenantID, deviceID string) bool
+}
+
+// Rollup aggregates the devices of a group and all groups beneath it. A
+// device in several groups of the subtree is counted once.
+type Rollup struct {
+	GroupID string `json:"group_id"`
+	Devices int    `json:"devices"`
+
+	// ByStatus counts the devices in each status; statuses without devices
+	// are left out
+	ByStatus map[device.Status]int `json:"by_status"`
+
+	// Compliant counts the devices whose last compliance check passed.
+	// Devices never checked are not compliant.
+	Compliant int `json:"compliant"`
+
+	// ComplianceRatio is Compliant divided by Devices, or 0 without devices
+	ComplianceRatio float64 `json:"compliance_ratio"`
+
+	// Drifted counts the devices whose configuration drifted from their
+	// latest deployment. It is always 0 when the service has no
+	// DriftChecker.
+	Drifted int `json:"drifted"`
+}
+
+// deviceState is the part of a device a rollup aggregates
+type deviceState struct {
+	status    device.Status
+	compliant bool
+}
+
+func stateOf(d *device.Device) deviceState {
+	return deviceState{
+		status:    d.Status,
+		compliant: d.ComplianceStatus != nil && d.ComplianceStatus.IsCompliant,
+	}
+}
+
+// groupRollup holds the aggregates of one group's subtree
+type groupRollup struct {
+	parentID string
+
+	// members are the devices directly in the group
+	members map[string]struct{}
+
+	// subtree maps each device in the group or beneath it to the number of
+	// groups in the subtree holding it
+	subtree map[string]int
+
+	byStatus  map[device.Status]int
+	compliant int
+}
+
+func newGroupRollup(parentID string) *groupRollup {
+	return &groupRollup{
+		parentID: parentID,
+		members:  make(map[string]struct{}),
+		subtree:  make(map[string]int),
+		byStatus: make(map[device.Status]int),
+	}
+}
+
+// count adds n devices in state s to the aggregates
+func (r *groupRollup) count(s deviceState, n int) {
+	r.byStatus[s.status] += n
+	if r.byStatus[s.status] <= 0 {
+		delete(r.byStatus, s.status)
+	}
+	if s.compliant {
+		r.compliant += n
+	}
+}
+
+// gain records k more groups of the subtree holding the device
+func (r *groupRollup) gain(deviceID string, k int, s deviceState) {
+	if r.subtree[deviceID] == 0 {
+		r.count(s, 1)
+	}
+	r.subtree[deviceID] += k
+}
+
+// drop records k fewer groups of the subtree holding the device
+func (r *groupRollup) drop(deviceID string, k int, s deviceState) {
+	if _, ok := r.subtree[deviceID]; !ok {
+		return
+	}
+	r.subtree[deviceID] -= k
+	if r.subtree[deviceID] <= 0 {
+		delete(r.subtree, deviceID)
+		r.count(s, -1)
+	}
+}
+
+// tenantRollups holds the rollups of every group of a tenant
+type tenantRollups struct {
+	groups map[string]*groupRollup
+
+	// devices holds the last known state of every device counted
+	devices map[string]deviceState
+}
+
+// lineage returns the rollup of a group followed by those of its
+// ancestors. Unknown groups end the walk, and so does a cycle.
+func (t *tenantRollups) lineage(groupID string) []*groupRollup {
+	var out []*groupRollup
+	seen := make(map[string]bool)
+	for groupID != "" && !seen[groupID] {
+		seen[groupID] = true
+		r, ok := t.groups[groupID]
+		if !ok {
+			break
+		}
+		out = append(out, r)
+		groupID = r.parentID
+	}
+	return out
+}
+
+// join adds a device directly to a group
+func (t *tenantRollups) join(groupID string, d *device.Device) {
+	t.update(d.ID, stateOf(d))
+	r, ok := t.groups[groupID]
+	if !ok {
+		return
+	}
+	if _, ok := r.members[d.ID]; ok {
+		return
+	}
+	r.members[d.ID] = struct{}{}
+	s := t.devices[d.ID]
+	for _, ancestor := range t.lineage(groupID) {
+		ancestor.gain(d.ID, 1, s)
+	}
+}
+
+// leave removes a device from a group
+func (t *tenantRollups) leave(groupID, deviceID string) {
+	r, ok := t.groups[groupID]
+	if !ok {
+		return
+	}
+	if _, ok := r.members[deviceID]; !ok {
+		return
+	}
+	delete(r.members, deviceID)
+	s := t.devices[deviceID]
+	for _, ancestor := range t.lineage(groupID) {
+		ancestor.drop(deviceID, 1, s)
+	}
+}
+
+// update records a device's current state in every rollup counting it
+func (t *tenantRollups) update(deviceID string, s deviceState) {
+	old, ok := t.devices[deviceID]
+	t.devices[deviceID] = s
+	if !ok || old == s {
+		return
+	}
+	for _, r := range t.groups {
+		if _, ok := r.subtree[deviceID]; ok {
+			r.count(old, -1)
+			r.count(s, 1)
+		}
+	}
+}
+
+// rollupCache keeps the rollups of each tenant current as membership and
+// the hierarchy change. A tenant's rollups are built from the store the
+// first time they are read; changes to tenants not built yet are ignored,
+// since the build reads them from the store. Every change is applied
+// idempotently, so one the build already saw does no harm.
+type rollupCache struct {
+	store Store
+	drift DriftChecker
+
+	// mu guards tenants and serializes builds
+	mu      sync.Mutex
+	tenants map[string]*tenantRollups
+}
+
+func newRollupCache(store Store) *rollupCache {
+	return &rollupCache{
+		store:   store,
+		tenants: make(map[string]*tenantRollups),
+	}
+}
+
+// with runs fn on the tenant's rollups when they have been built
+func (c *rollupCache) with(tenantID string, fn func(t *tenantRollups)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tenants[tenantID]; ok {
+		fn(t)
+	}
+}
+
+// groupAdded starts an empty rollup for a new group
+func (c *rollupCache) groupAdded(g *Group) {
+	c.with(g.TenantID, func(t *tenantRollups) {
+		if _, ok := t.groups[g.ID]; !ok {
+			t.groups[g.ID] = newGroupRollup(g.ParentID)
+		}
+	})
+}
+
+// groupRemoved takes a deleted group's devices out of its ancestors'
+// rollups. Groups are deleted from the bottom up, so the group has no
+// subgroups left.
+func (c *rollupCache) groupRemoved(tenantID, groupID string) {
+	c.with(tenantID, func(t *tenantRollups) {
+		r, ok := t.groups[groupID]
+		if !ok {
+			return
+		}
+		for deviceID := range r.members {
+			t.leave(groupID, deviceID)
+		}
+		delete(t.groups, groupID)
+	})
+}
+
+// groupMoved moves a group's subtree devices from the rollups of its old
+// ancestors to those of its new ones
+func (c *rollupCache) groupMoved(tenantID, groupID, newParentID string) {
+	c.with(tenantID, func(t *tenantRollups) {
+		r, ok := t.groups[groupID]
+		if !ok {
+			return
+		}
+		for _, ancestor := range t.lineage(r.parentID) {
+			for deviceID, k := range r.subtree {
+				ancestor.drop(deviceID, k, t.devices[deviceID])
+			}
+		}
+		r.parentID = newParentID
+		for _, ancestor := range t.lineage(newParentID) {
+			if ancestor == r {
+				// A cycle; the hierarchy manager refuses these, so the
+				// stored hierarchy cannot have one either.
+				break
+			}
+			for deviceID, k := range r.subtree {
+				ancestor.gain(deviceID, k, t.devices[deviceID])
+			}
+		}
+	})
+}
+
+// membersAdded records devices added to a group
+func (c *rollupCache) membersAdded(tenantID, groupID string, devices ...*device.Device) {
+	c.with(tenantID, func(t *tenantRollups) {
+		for _, d := range devices {
+			t.join(groupID, d)
+		}
+	})
+}
+
+// memberRemoved records a device removed from a group
+func (c *rollupCache) memberRemoved(tenantID, groupID, deviceID string) {
+	c.with(tenantID, func(t *tenantRollups) {
+		t.leave(groupID, deviceID)
+	})
+}
+
+// membersEvaluated replaces a group's members with the devices it was
+// evaluated to hold
+func (c *rollupCache) membersEvaluated(tenantID, groupID string, devices []*device.Device) {
+	c.with(tenantID, func(t *tenantRollups) {
+		r, ok := t.groups[groupID]
+		if !ok {
+			return
+		}
+		current := make(map[string]struct{}, len(devices))
+		for _, d := range devices {
+			current[d.ID] = struct{}{}
+			t.join(groupID, d)
+		}
+		for deviceID := range r.members {
+			if _, ok := current[deviceID]; !ok {
+				t.leave(groupID, deviceID)
+			}
+		}
+	})
+}
+
+// deviceChanged records a device's new state, or takes a deleted device,
+// given as nil, out of every group
+func (c *rollupCache) deviceChanged(tenantID, deviceID string, d *device.Device) {
+	c.with(tenantID, func(t *tenantRollups) {
+		if d != nil {
+			t.update(deviceID, stateOf(d))
+			return
+		}
+		for groupID, r := range t.groups {
+			if _, ok := r.members[deviceID]; ok {
+				t.leave(groupID, deviceID)
+			}
+		}
+		delete(t.devices, deviceID)
+	})
+}
+
+// forget drops a tenant's rollups, to be built again when next read
+func (c *rollupCache) forget(tenantID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tenants, tenantID)
+}
+
+// rollup returns the rollup of a group, building the tenant's rollups
+// first when needed. The group must exist.
+func (c *rollupCache) rollup(ctx context.Context, tenantID, groupID string) (*Rollup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.tenants[tenantID]
+	if !ok || t.groups[groupID] == nil {
+		// A group missing from built rollups was created while they were
+		// being read; building them again picks it up.
+		var err error
+		if t, err = c.build(ctx, tenantID); err != nil {
+			return nil, err
+		}
+		c.tenants[tenantID] = t
+	}
+	r, ok := t.groups[groupID]
+	if !ok {
+		return nil, E("group.rollup", ErrCodeGroupNotFound, "group not found", nil).
+			WithGroupID(groupID)
+	}
+
+	out := &Rollup{
+		GroupID:   groupID,
+		Devices:   len(r.subtree),
+		ByStatus:  make(map[device.Status]int, len(r.byStatus)),
+		Compliant: r.compliant,
+	}
+	for status, n := range r.byStatus {
+		out.ByStatus[status] = n
+	}
+	if out.Devices > 0 {
+		out.ComplianceRatio = float64(out.Compliant) / float64(out.Devices)
+	}
+	if c.drift != nil {
+		for deviceID := range r.subtree {
+			if c.drift.Drifted(tenantID, deviceID) {
+				out.Drifted++
+			}
+		}
+	}
+	return out, nil
+}
+
+// build reads the rollups of a tenant from the store. The caller must
+// hold c.mu.
+func (c *rollupCache) build(ctx context.Context, tenantID string) (*tenantRollups, error) {
+	const op = "group.buildRollups"
+
+	groups, err := c.store.List(ctx, tenantID, ListOptions{})
+	if err != nil {
+		return nil, storeError(op, "failed to list groups", err)
+	}
+
+	t := &tenantRollups{
+		groups:  make(map[string]*groupRollup, len(groups)),
+		devices: make(map[string]deviceState),
+	}
+	for _, g := range groups {
+		t.groups[g.ID] = newGroupRollup(g.ParentID)
+	}
+	for _, g := range groups {
+		devices, err := c.store.ListDevices(ctx, tenantID, g.ID)
+		if err != nil {
+			return nil, storeError(op, "failed to list devices in group", err).
+				WithField(FieldGroupID, g.ID)
+		}
+		for _, d := range devices {
+			t.join(g.ID, d)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,128 @@
+package group_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	devmem "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	grpmem "github.com/wrale/wrale-fleet/internal/fleet/group/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+// driftSet flags the devices it holds as drifted
+type driftSet map[string]bool
+
+func (s driftSet) Drifted(tenantID, deviceID string) bool {
+	return s[tenantID+"/"+deviceID]
+}
+
+func TestServiceRollup(t *testing.T) {
+	ctx := device.ContextWithTenant(context.Background(), "tenant-1")
+	logger := zaptest.NewLogger(t)
+	deviceStore := devmem.New()
+	groupStore := grpmem.New(deviceStore)
+	devices := device.NewService(deviceStore, logger)
+	drifted := driftSet{}
+	service := group.NewService(groupStore, deviceStore, logger, group.WithDriftChecker(drifted))
+	devices.OnChange(service.DeviceChanged)
+
+	register := func(name string, status device.Status, tags map[string]string) *device.Device {
+		d, err := devices.Register(ctx, "tenant-1", name)
+		require.NoError(t, err)
+		d.Status = status
+		for key, value := range tags {
+			d.Tags[key] = value
+		}
+		require.NoError(t, devices.Update(ctx, d))
+		return d
+	}
+	a := register("a", device.StatusOnline, map[string]string{"site": "plant-3"})
+	b := register("b", device.StatusOffline, map[string]string{"site": "plant-3"})
+	c := register("c", device.StatusOnline, nil)
+	d := register("d", device.StatusError, nil)
+
+	require.NoError(t, a.UpdateComplianceStatus(&device.ComplianceStatus{IsCompliant: true, LastCheck: time.Now()}))
+	require.NoError(t, devices.Update(ctx, a))
+	drifted["tenant-1/"+b.ID] = true
+
+	region, err := service.Create(ctx, "tenant-1", "eu", group.TypeStatic)
+	require.NoError(t, err)
+	site, err := service.CreateDynamic(ctx, "tenant-1", "plant-3",
+		&group.MembershipQuery{Tags: map[string]string{"site": "plant-3"}})
+	require.NoError(t, err)
+	require.NoError(t, service.UpdateHierarchy(ctx, site, region.ID))
+	lab, err := service.Create(ctx, "tenant-1", "lab", group.TypeStatic)
+	require.NoError(t, err)
+	require.NoError(t, service.UpdateHierarchy(ctx, lab, region.ID))
+	require.NoError(t, service.AddDevice(ctx, "tenant-1", lab.ID, c))
+	require.NoError(t, service.AddDevice(ctx, "tenant-1", lab.ID, a))
+
+	// rollup reads a group's rollup and checks that a service reading
+	// the store afresh agrees with the incrementally kept one.
+	rollup := func(groupID string) *group.Rollup {
+		got, err := service.Rollup(ctx, "tenant-1", groupID)
+		require.NoError(t, err)
+		fresh := group.NewService(groupStore, deviceStore, logger, group.WithDriftChecker(drifted))
+		want, err := fresh.Rollup(ctx, "tenant-1", groupID)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "cached rollup differs from a fresh one")
+		return got
+	}
+
+	// A device in two subgroups is counted once.
+	got := rollup(region.ID)
+	assert.Equal(t, &group.Rollup{
+		GroupID:         region.ID,
+		Devices:         3,
+		ByStatus:        map[device.Status]int{device.StatusOnline: 2, device.StatusOffline: 1},
+		Compliant:       1,
+		ComplianceRatio: 1.0 / 3,
+		Drifted:         1,
+	}, got)
+	assert.Equal(t, 2, rollup(site.ID).Devices)
+	assert.Equal(t, 2, rollup(lab.ID).Devices)
+
+	// Device changes update every rollup counting the device.
+	b.Status = device.StatusOnline
+	require.NoError(t, devices.Update(ctx, b))
+	assert.Equal(t, map[device.Status]int{device.StatusOnline: 3}, rollup(region.ID).ByStatus)
+
+	d.Tags["site"] = "plant-3"
+	require.NoError(t, devices.Update(ctx, d))
+	got = rollup(region.ID)
+	assert.Equal(t, 4, got.Devices)
+	assert.Equal(t, 1, got.ByStatus[device.StatusError])
+	assert.Equal(t, 0.25, got.ComplianceRatio)
+
+	// Leaving one subgroup keeps a device counted through the other.
+	require.NoError(t, service.RemoveDevice(ctx, "tenant-1", lab.ID, a.ID))
+	assert.Equal(t, 4, rollup(region.ID).Devices)
+	assert.Equal(t, 1, rollup(lab.ID).Devices)
+
+	// Moving a subgroup takes its devices to the new ancestors.
+	require.NoError(t, service.UpdateHierarchy(ctx, lab, ""))
+	assert.Equal(t, 3, rollup(region.ID).Devices)
+	require.NoError(t, service.UpdateHierarchy(ctx, region, lab.ID))
+	got = rollup(lab.ID)
+	assert.Equal(t, 4, got.Devices)
+	assert.Equal(t, 1, got.Drifted)
+
+	// Deleted devices and groups leave the rollups.
+	require.NoError(t, devices.Delete(ctx, "tenant-1", c.ID))
+	assert.Equal(t, 3, rollup(lab.ID).Devices)
+	require.NoError(t, service.Delete(ctx, "tenant-1", site.ID))
+	got = rollup(lab.ID)
+	assert.Equal(t, 0, got.Devices)
+	assert.Empty(t, got.ByStatus)
+	assert.Zero(t, got.ComplianceRatio)
+
+	_, err = service.Rollup(ctx, "tenant-1", site.ID)
+	require.Error(t, err)
+	_, err = service.Rollup(ctx, "tenant-2", lab.ID)
+	require.Error(t, err)
+}
@@ -2,6 +2,9 @@ package group
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 
 	"github.com/wrale/wrale-fleet/internal/fleet/device"
 	"go.uber.org/zap"
@@ -13,16 +16,43 @@ type Service struct {
 	deviceStore  device.Store
 	logger       *zap.Logger
 	hierarchyMgr *HierarchyManager
+	membership   *membershipEvaluator
+	rollups      *rollupCache
+}
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithMembershipHandler registers a handler for devices joining and
+// leaving dynamic groups
+func WithMembershipHandler(handler MembershipHandler) Option {
+	return func(s *Service) {
+		s.membership.handlers = append(s.membership.handlers, handler)
+	}
+}
+
+// WithDriftChecker counts the drifted devices of each group in its rollup
+func WithDriftChecker(checker DriftChecker) Option {
+	return func(s *Service) {
+		s.rollups.drift = checker
+	}
 }
 
 // NewService creates a new group management service
-func NewService(store Store, deviceStore device.Store, logger *zap.Logger) *Service {
-	return &Service{
+func NewService(store Store, deviceStore device.Store, logger *zap.Logger, opts ...Option) *Service {
+	rollups := newRollupCache(store)
+	s := &Service{
 		store:        store,
 		deviceStore:  deviceStore,
 		logger:       logger,
 		hierarchyMgr: NewHierarchyManager(store),
+		membership:   newMembershipEvaluator(store, deviceStore, rollups, logger),
+		rollups:      rollups,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // Create creates a new device group
@@ -31,12 +61,13 @@ func (s *Service) Create(ctx context.Context, tenantID, name string, groupType T
 
 	group := New(tenantID, name, groupType)
 	if err := group.Validate(); err != nil {
-		return nil, E(op, ErrCodeInvalidInput, "invalid group data", err)
+		return nil, invalidInput(op, err)
 	}
 
 	if err := s.store.Create(ctx, group); err != nil {
-		return nil, E(op, ErrCodeStoreOperation, "failed to create group", err)
+		return nil, storeError(op, "failed to create group", err)
 	}
+	s.rollups.groupAdded(group)
 
 	s.logger.Info("created new group",
 		zap.String("group_id", group.ID),
@@ -48,13 +79,45 @@ func (s *Service) Create(ctx context.Context, tenantID, name string, groupType T
 	return group, nil
 }
 
+// CreateDynamic creates a dynamic group whose members are the devices
+// matching query, and evaluates its initial membership
+func (s *Service) CreateDynamic(ctx context.Context, tenantID, name string, query *MembershipQuery) (*Group, error) {
+	const op = "group.Service.CreateDynamic"
+
+	group := New(tenantID, name, TypeDynamic)
+	group.Query = query
+	if err := group.Validate(); err != nil {
+		return nil, invalidInput(op, err)
+	}
+
+	if err := s.store.Create(ctx, group); err != nil {
+		return nil, storeError(op, "failed to create group", err)
+	}
+	s.rollups.groupAdded(group)
+	s.membership.created(group.TenantID, group.ID)
+
+	s.logger.Info("created new group",
+		zap.String("group_id", group.ID),
+		zap.String("tenant_id", group.TenantID),
+		zap.String("name", group.Name),
+		zap.String("type", string(group.Type)),
+	)
+
+	devices, err := s.membership.evaluate(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	group.DeviceCount = len(devices)
+	return group, nil
+}
+
 // Get retrieves a group by ID
 func (s *Service) Get(ctx context.Context, tenantID, groupID string) (*Group, error) {
 	const op = "group.Service.Get"
 
 	group, err := s.store.Get(ctx, tenantID, groupID)
 	if err != nil {
-		return nil, E(op, ErrCodeStoreOperation, "failed to get group", err)
+		return nil, storeError(op, "failed to get group", err)
 	}
 	return group, nil
 }
@@ -64,7 +127,7 @@ func (s *Service) Update(ctx context.Context, group *Group) error {
 	const op = "group.Service.Update"
 
 	if err := group.Validate(); err != nil {
-		return E(op, ErrCodeInvalidInput, "invalid group data", err)
+		return invalidInput(op, err)
 	}
 
 	// If parent ID is changing, validate hierarchy change
@@ -74,8 +137,22 @@ func (s *Service) Update(ctx context.Context, group *Group) error {
 		}
 	}
 
+	// Members not evaluated since start-up are recorded under the stored
+	// query first, so devices a new query adds or drops are reported
+	if group.Type == TypeDynamic && !s.membership.evaluated(group.TenantID, group.ID) {
+		stored, err := s.store.Get(ctx, group.TenantID, group.ID)
+		if err != nil {
+			return storeError(op, "failed to get group", err)
+		}
+		if stored.Type == TypeDynamic {
+			if _, err := s.membership.evaluate(ctx, stored); err != nil {
+				return err
+			}
+		}
+	}
+
 	if err := s.store.Update(ctx, group); err != nil {
-		return E(op, ErrCodeStoreOperation, "failed to update group", err)
+		return storeError(op, "failed to update group", err)
 	}
 
 	s.logger.Info("updated group",
@@ -84,6 +161,16 @@ func (s *Service) Update(ctx context.Context, group *Group) error {
 		zap.Time("updated_at", group.UpdatedAt),
 	)
 
+	// The query may have changed, so dynamic membership is evaluated again
+	s.membership.invalidate(group.TenantID, group.ID)
+	if group.Type == TypeDynamic {
+		devices, err := s.membership.evaluate(ctx, group)
+		if err != nil {
+			return err
+		}
+		group.DeviceCount = len(devices)
+	}
+
 	return nil
 }
 
@@ -94,25 +181,29 @@ func (s *Service) Delete(ctx context.Context, tenantID, groupID string) error {
 	// Get descendants to ensure we delete from bottom up
 	group, err := s.store.Get(ctx, tenantID, groupID)
 	if err != nil {
-		return E(op, ErrCodeStoreOperation, "failed to get group", err)
+		return storeError(op, "failed to get group", err)
 	}
 
 	descendants, err := s.hierarchyMgr.GetDescendants(ctx, group)
 	if err != nil {
-		return E(op, ErrCodeStoreOperation, "failed to list descendant groups", err)
+		return storeError(op, "failed to list descendant groups", err)
 	}
 
 	// Delete descendants from deepest to shallowest
 	for i := len(descendants) - 1; i >= 0; i-- {
 		if err := s.store.Delete(ctx, tenantID, descendants[i].ID); err != nil {
-			return E(op, ErrCodeStoreOperation, "failed to delete descendant group", err)
+			return storeError(op, "failed to delete descendant group", err)
 		}
+		s.membership.forget(tenantID, descendants[i].ID)
+		s.rollups.groupRemoved(tenantID, descendants[i].ID)
 	}
 
 	// Delete the group itself
 	if err := s.store.Delete(ctx, tenantID, groupID); err != nil {
-		return E(op, ErrCodeStoreOperation, "failed to delete group", err)
+		return storeError(op, "failed to delete group", err)
 	}
+	s.membership.forget(tenantID, groupID)
+	s.rollups.groupRemoved(tenantID, groupID)
 
 	s.logger.Info("deleted group",
 		zap.String("group_id", groupID),
@@ -128,7 +219,7 @@ func (s *Service) List(ctx context.Context, tenantID string, opts ListOptions) (
 	const op = "group.Service.List"
 	groups, err := s.store.List(ctx, tenantID, opts)
 	if err != nil {
-		return nil, E(op, ErrCodeStoreOperation, "failed to list groups", err)
+		return nil, storeError(op, "failed to list groups", err)
 	}
 	return groups, nil
 }
@@ -139,7 +230,7 @@ func (s *Service) AddDevice(ctx context.Context, tenantID, groupID string, devic
 
 	group, err := s.store.Get(ctx, tenantID, groupID)
 	if err != nil {
-		return E(op, ErrCodeStoreOperation, "failed to get group", err)
+		return storeError(op, "failed to get group", err)
 	}
 
 	if group.Type != TypeStatic {
@@ -147,8 +238,9 @@ func (s *Service) AddDevice(ctx context.Context, tenantID, groupID string, devic
 	}
 
 	if err := s.store.AddDevice(ctx, tenantID, groupID, device); err != nil {
-		return E(op, ErrCodeStoreOperation, "failed to add device to group", err)
+		return storeError(op, "failed to add device to group", err)
 	}
+	s.rollups.membersAdded(tenantID, groupID, device)
 
 	s.logger.Info("added device to group",
 		zap.String("group_id", groupID),
@@ -165,7 +257,7 @@ func (s *Service) RemoveDevice(ctx context.Context, tenantID, groupID, deviceID
 
 	group, err := s.store.Get(ctx, tenantID, groupID)
 	if err != nil {
-		return E(op, ErrCodeStoreOperation, "failed to get group", err)
+		return storeError(op, "failed to get group", err)
 	}
 
 	if group.Type != TypeStatic {
@@ -173,8 +265,9 @@ func (s *Service) RemoveDevice(ctx context.Context, tenantID, groupID, deviceID
 	}
 
 	if err := s.store.RemoveDevice(ctx, tenantID, groupID, deviceID); err != nil {
-		return E(op, ErrCodeStoreOperation, "failed to remove device from group", err)
+		return storeError(op, "failed to remove device from group", err)
 	}
+	s.rollups.memberRemoved(tenantID, groupID, deviceID)
 
 	s.logger.Info("removed device from group",
 		zap.String("group_id", groupID),
@@ -185,17 +278,131 @@ func (s *Service) RemoveDevice(ctx context.Context, tenantID, groupID, deviceID
 	return nil
 }
 
-// ListDevices lists all devices in a group
+// ListDevices lists all devices in a group. The members of a dynamic
+// group are evaluated from its query.
 func (s *Service) ListDevices(ctx context.Context, tenantID, groupID string) ([]*device.Device, error) {
 	const op = "group.Service.ListDevices"
 
+	group, err := s.store.Get(ctx, tenantID, groupID)
+	if err != nil {
+		return nil, storeError(op, "failed to get group", err)
+	}
+	if group.Type == TypeDynamic {
+		return s.membership.evaluate(ctx, group)
+	}
+
 	devices, err := s.store.ListDevices(ctx, tenantID, groupID)
 	if err != nil {
-		return nil, E(op, ErrCodeStoreOperation, "failed to list devices in group", err)
+		return nil, storeError(op, "failed to list devices in group", err)
 	}
 	return devices, nil
 }
 
+// DeviceChanged updates the membership of dynamic groups after a device
+// was registered, updated or deleted. It has the signature of
+// device.ChangeFunc so it can be registered with device.Service.OnChange.
+func (s *Service) DeviceChanged(ctx context.Context, tenantID, deviceID string) {
+	if err := s.membership.deviceChanged(ctx, tenantID, deviceID); err != nil {
+		s.logger.Error("failed to update dynamic group membership",
+			zap.Error(err),
+			zap.String("device_id", deviceID),
+			zap.String("tenant_id", tenantID),
+		)
+	}
+}
+
+// DeviceGroups returns the groups a device belongs to, directly or through
+// a dynamic group's query, together with all their ancestors. Groups are
+// ordered from least to most specific: by depth in the hierarchy, then by
+// name and ID, so configuration applied in this order lets descendants
+// refine what their ancestors set.
+func (s *Service) DeviceGroups(ctx context.Context, tenantID, deviceID string) ([]*Group, error) {
+	const op = "group.Service.DeviceGroups"
+
+	groups, err := s.store.List(ctx, tenantID, ListOptions{})
+	if err != nil {
+		return nil, storeError(op, "failed to list groups", err)
+	}
+	byID := make(map[string]*Group, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+	}
+
+	selected := make(map[string]*Group)
+	for _, g := range groups {
+		members, err := s.store.ListDevices(ctx, tenantID, g.ID)
+		if err != nil {
+			return nil, storeError(op, "failed to list devices in group", err).
+				WithField(FieldGroupID, g.ID)
+		}
+		if !containsDevice(members, deviceID) {
+			continue
+		}
+		for _, id := range g.Ancestry.PathParts {
+			ancestor, ok := byID[id]
+			if !ok {
+				return nil, E(op, ErrCodeInvalidHierarchy,
+					fmt.Sprintf("ancestor %s of group %s not found", id, g.ID), nil).
+					WithField(FieldGroupID, g.ID)
+			}
+			selected[id] = ancestor
+		}
+	}
+
+	out := make([]*Group, 0, len(selected))
+	for _, g := range selected {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Ancestry.Depth != b.Ancestry.Depth {
+			return a.Ancestry.Depth < b.Ancestry.Depth
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	return out, nil
+}
+
+// EffectiveConfig computes a device's configuration with the configuration
+// of its groups applied beneath it. See MergeConfig for how the layers
+// combine.
+func (s *Service) EffectiveConfig(ctx context.Context, dev *device.Device) (*EffectiveConfig, error) {
+	groups, err := s.DeviceGroups(ctx, dev.TenantID, dev.ID)
+	if err != nil {
+		return nil, err
+	}
+	return MergeConfig(dev.ID, groups, dev.Config)
+}
+
+func containsDevice(devices []*device.Device, deviceID string) bool {
+	for _, d := range devices {
+		if d.ID == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// Rollup returns the device counts by status, compliance and config drift
+// of a group and all groups beneath it. Rollups are cached and kept
+// current as membership and the hierarchy change; drift is looked up when
+// the rollup is read.
+func (s *Service) Rollup(ctx context.Context, tenantID, groupID string) (*Rollup, error) {
+	const op = "group.Service.Rollup"
+
+	if _, err := s.store.Get(ctx, tenantID, groupID); err != nil {
+		return nil, storeError(op, "failed to get group", err)
+	}
+	rollup, err := s.rollups.rollup(ctx, tenantID, groupID)
+	if err != nil {
+		return nil, storeError(op, "failed to compute group rollup", err)
+	}
+	return rollup, nil
+}
+
 // ValidateHierarchy validates the group hierarchy for a tenant
 func (s *Service) ValidateHierarchy(ctx context.Context, tenantID string) error {
 	const op = "group.Service.ValidateHierarchy"
@@ -207,13 +414,53 @@ func (s *Service) ValidateHierarchy(ctx context.Context, tenantID string) error
 	return nil
 }
 
+// RepairHierarchy rebuilds the ancestry of a tenant's groups from their
+// parents and moves orphaned groups beneath a quarantine root group. A dry
+// run reports the repairs without making them. See
+// HierarchyManager.RepairHierarchy.
+func (s *Service) RepairHierarchy(ctx context.Context, tenantID string, dryRun bool) (*RepairReport, error) {
+	const op = "group.Service.RepairHierarchy"
+
+	report, err := s.hierarchyMgr.RepairHierarchy(ctx, tenantID, dryRun)
+	if err != nil {
+		out := storeError(op, "failed to repair hierarchy", err)
+		if dryRun {
+			return nil, out
+		}
+
+		// Groups written before the failure stay repaired, so the rollups
+		// are built afresh here too
+		s.rollups.forget(tenantID)
+		var repairErr *Error
+		if errors.As(err, &repairErr) {
+			if written, ok := repairErr.Fields[FieldRepairedGroups].([]string); ok {
+				out.WithField(FieldRepairedGroups, written)
+				s.logger.Error("group hierarchy repair stopped part way",
+					zap.Error(err),
+					zap.String("tenant_id", tenantID),
+					zap.Strings("repaired_groups", written),
+				)
+			}
+		}
+		return nil, out
+	}
+	if dryRun || len(report.Actions) == 0 {
+		return report, nil
+	}
+
+	// Groups may have moved anywhere, so the rollups are built afresh
+	s.rollups.forget(tenantID)
+	return report, nil
+}
+
 // UpdateHierarchy updates a group's position in the hierarchy
 func (s *Service) UpdateHierarchy(ctx context.Context, group *Group, newParentID string) error {
 	const op = "group.Service.UpdateHierarchy"
 
 	if err := s.hierarchyMgr.UpdateHierarchy(ctx, group, newParentID); err != nil {
-		return E(op, ErrCodeInvalidOperation, "failed to update hierarchy", err)
+		return E(op, codeOf(err, ErrCodeInvalidOperation), "failed to update hierarchy", err)
 	}
+	s.rollups.groupMoved(group.TenantID, group.ID, newParentID)
 
 	s.logger.Info("updated group hierarchy",
 		zap.String("group_id", group.ID),
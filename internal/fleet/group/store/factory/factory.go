@@ -0,0 +1,39 @@
+// Package factory provides creation functions for group store implementations.
+package factory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"github.com/wrale/wrale-fleet/internal/fleet/group/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/storage"
+)
+
+// Built-in backend names
+const (
+	// Memory keeps groups in memory; data is lost on restart
+	Memory = "memory"
+)
+
+// Options configures group store backends.
+type Options struct {
+	// DataDir is the directory file-backed stores keep their data in
+	DataDir string
+
+	// DeviceStore resolves dynamic group membership
+	DeviceStore device.Store
+}
+
+// Registry holds the available group store backends.
+var Registry = storage.NewRegistry[group.Store, Options]("group")
+
+func init() {
+	Registry.Register(Memory, func(ctx context.Context, opts Options) (group.Store, error) {
+		if opts.DeviceStore == nil {
+			return nil, fmt.Errorf("device store is required")
+		}
+		return memory.New(opts.DeviceStore), nil
+	})
+}
@@ -89,45 +89,50 @@ func (s *Store) RemoveDevice(ctx context.Context, tenantID, groupID, deviceID st
 // ListDevices implements group.Store
 func (s *Store) ListDevices(ctx context.Context, tenantID, groupID string) ([]*device.Device, error) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
 
 	// Get the group
 	tenantGroups, exists := s.groups[tenantID]
 	if !exists {
+		s.mu.RUnlock()
 		return nil, group.E("Store.ListDevices", group.ErrCodeGroupNotFound,
 			"group not found", nil)
 	}
 
 	g, exists := tenantGroups[groupID]
 	if !exists {
+		s.mu.RUnlock()
 		return nil, group.E("Store.ListDevices", group.ErrCodeGroupNotFound,
 			"group not found", nil)
 	}
 
-	var devices []*device.Device
+	if g.Type != group.TypeStatic {
+		// Dynamic evaluation updates the stored device count, so the read
+		// lock must be released before it runs
+		g = g.DeepCopy()
+		s.mu.RUnlock()
 
-	if g.Type == group.TypeStatic {
-		key := s.groupKey(tenantID, groupID)
-		members := s.memberships[key]
-		if members == nil {
-			return []*device.Device{}, nil
+		devices, err := s.evaluateDynamicGroupMembers(ctx, g)
+		if err != nil {
+			return nil, fmt.Errorf("evaluate dynamic members: %w", err)
 		}
+		return devices, nil
+	}
 
-		for deviceID := range members {
-			device, err := s.deviceStore.Get(ctx, tenantID, deviceID)
-			if err != nil {
-				// Log error but continue - the device might have been deleted
-				continue
-			}
-			devices = append(devices, device)
-		}
-	} else {
-		// For dynamic groups, evaluate the query
-		var err error
-		devices, err = s.evaluateDynamicGroupMembers(ctx, g)
+	key := s.groupKey(tenantID, groupID)
+	memberIDs := make([]string, 0, len(s.memberships[key]))
+	for deviceID := range s.memberships[key] {
+		memberIDs = append(memberIDs, deviceID)
+	}
+	s.mu.RUnlock()
+
+	devices := make([]*device.Device, 0, len(memberIDs))
+	for _, deviceID := range memberIDs {
+		device, err := s.deviceStore.Get(ctx, tenantID, deviceID)
 		if err != nil {
-			return nil, fmt.Errorf("evaluate dynamic members: %w", err)
+			// Skip devices that have been deleted since they were added
+			continue
 		}
+		devices = append(devices, device)
 	}
 
 	return devices, nil
@@ -139,21 +144,31 @@ func (s *Store) evaluateDynamicGroupMembers(ctx context.Context, g *group.Group)
 		return []*device.Device{}, nil
 	}
 
-	// Prepare device list options from group query
+	// Narrow the candidates by tags, then apply the full query
 	opts := device.ListOptions{
 		TenantID: g.TenantID,
 		Tags:     g.Query.Tags,
 	}
 
-	devices, err := s.deviceStore.List(ctx, opts)
+	matcher, err := g.Query.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("compile query: %w", err)
+	}
+	candidates, err := s.deviceStore.List(ctx, opts)
 	if err != nil {
 		return nil, fmt.Errorf("list devices: %w", err)
 	}
+	devices := make([]*device.Device, 0, len(candidates))
+	for _, d := range candidates {
+		if matcher.Matches(d) {
+			devices = append(devices, d)
+		}
+	}
 
 	// Update the group's device count
 	s.mu.Lock()
-	if _, exists := s.groups[g.TenantID][g.ID]; exists {
-		groupCopy := g.DeepCopy()
+	if current, exists := s.groups[g.TenantID][g.ID]; exists {
+		groupCopy := current.DeepCopy()
 		groupCopy.DeviceCount = len(devices)
 		s.groups[g.TenantID][g.ID] = groupCopy
 	}
@@ -3,6 +3,7 @@ package memory
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/wrale/wrale-fleet/internal/fleet/device"
@@ -133,14 +134,27 @@ func (s *Store) List(ctx context.Context, tenantID string, opts group.ListOption
 		return []*group.Group{}, nil
 	}
 
-	var result []*group.Group
+	result := make([]*group.Group, 0, len(tenantGroups))
 	for _, g := range tenantGroups {
 		if matchesListOptions(g, opts) {
 			result = append(result, g.DeepCopy())
 		}
 	}
 
-	return result, nil
+	// Sort by ID for consistent ordering across calls
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	// Apply pagination
+	if opts.Offset >= len(result) {
+		return []*group.Group{}, nil
+	}
+
+	end := opts.Offset + opts.Limit
+	if opts.Limit <= 0 || end > len(result) {
+		end = len(result)
+	}
+
+	return result[opts.Offset:end], nil
 }
 
 // Clear removes all groups (used for testing)
@@ -6,8 +6,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
 	devmem "github.com/wrale/wrale-fleet/internal/fleet/device/store/memory"
 	"github.com/wrale/wrale-fleet/internal/fleet/group"
+	"github.com/wrale/wrale-fleet/internal/fleet/group/storetest"
 )
 
 func TestStore(t *testing.T) {
@@ -133,3 +135,10 @@ func TestHierarchy(t *testing.T) {
 		assert.Equal(t, 1, child2.Ancestry.Depth)
 	})
 }
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (group.Store, device.Store) {
+		devices := devmem.New()
+		return New(devices), devices
+	})
+}
@@ -0,0 +1,460 @@
+// Package storetest provides a conformance suite for group.Store
+// implementations.
+//
+// Group stores resolve memberships through a device store, so the factory
+// returns both. Backends run the suite from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) (group.Store, device.Store) {
+//			devices := devmem.New()
+//			return memory.New(devices), devices
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/group"
+)
+
+// Factory creates an empty group store together with the device store it
+// resolves memberships against.
+type Factory func(t *testing.T) (group.Store, device.Store)
+
+// Run executes the full conformance suite against stores created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("Create", func(t *testing.T) { testCreate(t, factory) })
+	t.Run("Get", func(t *testing.T) { testGet(t, factory) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, factory) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, factory) })
+	t.Run("ListFilters", func(t *testing.T) { testListFilters(t, factory) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, factory) })
+	t.Run("StaticMembership", func(t *testing.T) { testStaticMembership(t, factory) })
+	t.Run("DynamicMembership", func(t *testing.T) { testDynamicMembership(t, factory) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, factory) })
+	t.Run("Clear", func(t *testing.T) { testClear(t, factory) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+}
+
+// newGroup builds a valid root group with a deterministic ID
+func newGroup(tenantID, id string, groupType group.Type) *group.Group {
+	g := group.New(tenantID, "group "+id, groupType)
+	g.ID = id
+	g.Ancestry.Path = "/" + id
+	g.Ancestry.PathParts = []string{id}
+	if groupType == group.TypeDynamic {
+		g.Query = &group.MembershipQuery{}
+	}
+	return g
+}
+
+// newDevice builds a valid device with a deterministic ID
+func newDevice(tenantID, id string) *device.Device {
+	d := device.New(tenantID, "device "+id)
+	d.ID = id
+	return d
+}
+
+// requireCode asserts that err is a *group.Error carrying the given code
+func requireCode(t *testing.T, err error, code string) {
+	t.Helper()
+	require.Error(t, err)
+	var groupErr *group.Error
+	require.True(t, errors.As(err, &groupErr), "expected *group.Error, got %T: %v", err, err)
+	assert.Equal(t, code, groupErr.Code)
+}
+
+func testCreate(t *testing.T, factory Factory) {
+	store, _ := factory(t)
+	ctx := context.Background()
+
+	g := newGroup("tenant-1", "grp-1", group.TypeStatic)
+	g.Description = "conformance"
+	g.Properties.Metadata["env"] = "prod"
+	g.Properties.ConfigTemplate = []byte(`{"interval":30}`)
+	require.NoError(t, store.Create(ctx, g))
+
+	got, err := store.Get(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, g.Name, got.Name)
+	assert.Equal(t, g.Description, got.Description)
+	assert.Equal(t, group.TypeStatic, got.Type)
+	assert.Equal(t, "prod", got.Properties.Metadata["env"])
+	assert.JSONEq(t, `{"interval":30}`, string(got.Properties.ConfigTemplate))
+	assert.Equal(t, "/grp-1", got.Ancestry.Path)
+
+	// Mutating the caller's copy must not change stored state
+	g.Name = "mutated"
+	got, err = store.Get(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, "group grp-1", got.Name)
+
+	t.Run("duplicate", func(t *testing.T) {
+		requireCode(t, store.Create(ctx, newGroup("tenant-1", "grp-1", group.TypeStatic)), group.ErrCodeGroupExists)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		invalid := newGroup("tenant-1", "grp-2", group.TypeStatic)
+		invalid.Name = ""
+		requireCode(t, store.Create(ctx, invalid), group.ErrCodeInvalidGroup)
+	})
+}
+
+func testGet(t *testing.T, factory Factory) {
+	store, _ := factory(t)
+	ctx := context.Background()
+	require.NoError(t, store.Create(ctx, newGroup("tenant-1", "grp-1", group.TypeStatic)))
+
+	got, err := store.Get(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, "grp-1", got.ID)
+	assert.Equal(t, "tenant-1", got.TenantID)
+
+	_, err = store.Get(ctx, "tenant-1", "missing")
+	requireCode(t, err, group.ErrCodeGroupNotFound)
+}
+
+func testUpdate(t *testing.T, factory Factory) {
+	store, _ := factory(t)
+	ctx := context.Background()
+	require.NoError(t, store.Create(ctx, newGroup("tenant-1", "grp-1", group.TypeStatic)))
+
+	g, err := store.Get(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	g.Name = "renamed"
+	g.Properties.Metadata["tier"] = "edge"
+	require.NoError(t, store.Update(ctx, g))
+
+	got, err := store.Get(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", got.Name)
+	assert.Equal(t, "edge", got.Properties.Metadata["tier"])
+
+	requireCode(t, store.Update(ctx, newGroup("tenant-1", "missing", group.TypeStatic)), group.ErrCodeGroupNotFound)
+}
+
+func testDelete(t *testing.T, factory Factory) {
+	store, _ := factory(t)
+	ctx := context.Background()
+	require.NoError(t, store.Create(ctx, newGroup("tenant-1", "grp-1", group.TypeStatic)))
+
+	require.NoError(t, store.Delete(ctx, "tenant-1", "grp-1"))
+
+	_, err := store.Get(ctx, "tenant-1", "grp-1")
+	requireCode(t, err, group.ErrCodeGroupNotFound)
+
+	requireCode(t, store.Delete(ctx, "tenant-1", "grp-1"), group.ErrCodeGroupNotFound)
+}
+
+func testListFilters(t *testing.T, factory Factory) {
+	store, _ := factory(t)
+	ctx := context.Background()
+
+	root := newGroup("tenant-1", "grp-1", group.TypeStatic)
+	root.Properties.Metadata["env"] = "prod"
+	require.NoError(t, store.Create(ctx, root))
+
+	child := newGroup("tenant-1", "grp-2", group.TypeStatic)
+	require.NoError(t, child.SetParent(root.ID, &root.Ancestry))
+	require.NoError(t, store.Create(ctx, child))
+
+	dynamic := newGroup("tenant-1", "grp-3", group.TypeDynamic)
+	dynamic.Properties.Metadata["env"] = "prod"
+	require.NoError(t, store.Create(ctx, dynamic))
+
+	tests := []struct {
+		name    string
+		opts    group.ListOptions
+		wantIDs []string
+	}{
+		{"all", group.ListOptions{}, []string{"grp-1", "grp-2", "grp-3"}},
+		{"type", group.ListOptions{Type: group.TypeDynamic}, []string{"grp-3"}},
+		{"parent", group.ListOptions{ParentID: "grp-1"}, []string{"grp-2"}},
+		{"metadata tags", group.ListOptions{Tags: map[string]string{"env": "prod"}}, []string{"grp-1", "grp-3"}},
+		{"no match", group.ListOptions{ParentID: "grp-3"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.List(ctx, "tenant-1", tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, groupIDs(got))
+		})
+	}
+}
+
+func testPagination(t *testing.T, factory Factory) {
+	store, _ := factory(t)
+	ctx := context.Background()
+
+	// Insert out of order to verify results are sorted by ID
+	for _, id := range []string{"grp-3", "grp-1", "grp-5", "grp-2", "grp-4"} {
+		require.NoError(t, store.Create(ctx, newGroup("tenant-1", id, group.TypeStatic)))
+	}
+
+	tests := []struct {
+		name    string
+		offset  int
+		limit   int
+		wantIDs []string
+	}{
+		{"no limit", 0, 0, []string{"grp-1", "grp-2", "grp-3", "grp-4", "grp-5"}},
+		{"first page", 0, 2, []string{"grp-1", "grp-2"}},
+		{"second page", 2, 2, []string{"grp-3", "grp-4"}},
+		{"last partial page", 4, 2, []string{"grp-5"}},
+		{"offset without limit", 3, 0, []string{"grp-4", "grp-5"}},
+		{"offset past end", 5, 2, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.List(ctx, "tenant-1", group.ListOptions{Offset: tt.offset, Limit: tt.limit})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, groupIDs(got))
+		})
+	}
+}
+
+func testStaticMembership(t *testing.T, factory Factory) {
+	store, devices := factory(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.Create(ctx, newGroup("tenant-1", "grp-1", group.TypeStatic)))
+	for _, id := range []string{"dev-1", "dev-2"} {
+		require.NoError(t, devices.Create(ctx, newDevice("tenant-1", id)))
+	}
+
+	for _, id := range []string{"dev-1", "dev-2"} {
+		d, err := devices.Get(ctx, "tenant-1", id)
+		require.NoError(t, err)
+		require.NoError(t, store.AddDevice(ctx, "tenant-1", "grp-1", d))
+	}
+
+	members, err := store.ListDevices(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev-1", "dev-2"}, deviceIDs(members))
+
+	g, err := store.Get(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, g.DeviceCount)
+
+	require.NoError(t, store.RemoveDevice(ctx, "tenant-1", "grp-1", "dev-1"))
+	members, err = store.ListDevices(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev-2"}, deviceIDs(members))
+
+	g, err = store.Get(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, g.DeviceCount)
+
+	t.Run("missing group", func(t *testing.T) {
+		d := newDevice("tenant-1", "dev-1")
+		requireCode(t, store.AddDevice(ctx, "tenant-1", "missing", d), group.ErrCodeGroupNotFound)
+		requireCode(t, store.RemoveDevice(ctx, "tenant-1", "missing", "dev-1"), group.ErrCodeGroupNotFound)
+		_, err := store.ListDevices(ctx, "tenant-1", "missing")
+		requireCode(t, err, group.ErrCodeGroupNotFound)
+	})
+
+	t.Run("cross tenant device", func(t *testing.T) {
+		foreign := newDevice("tenant-2", "dev-9")
+		requireCode(t, store.AddDevice(ctx, "tenant-1", "grp-1", foreign), group.ErrCodeInvalidOperation)
+	})
+}
+
+func testDynamicMembership(t *testing.T, factory Factory) {
+	store, devices := factory(t)
+	ctx := context.Background()
+
+	for id, env := range map[string]string{"dev-1": "prod", "dev-2": "staging", "dev-3": "prod"} {
+		d := newDevice("tenant-1", id)
+		d.Tags["env"] = env
+		require.NoError(t, devices.Create(ctx, d))
+	}
+
+	g := newGroup("tenant-1", "grp-1", group.TypeDynamic)
+	g.Query = &group.MembershipQuery{Tags: map[string]string{"env": "prod"}}
+	require.NoError(t, store.Create(ctx, g))
+
+	members, err := store.ListDevices(ctx, "tenant-1", "grp-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev-1", "dev-3"}, deviceIDs(members))
+
+	// Status and region criteria narrow the members further, and the
+	// device count follows the evaluated membership
+	d, err := devices.Get(ctx, "tenant-1", "dev-3")
+	require.NoError(t, err)
+	d.Status = device.StatusOnline
+	d.Tags[group.RegionTag] = "eu-west-1"
+	require.NoError(t, devices.Update(ctx, d))
+
+	narrow := newGroup("tenant-1", "grp-2", group.TypeDynamic)
+	narrow.Query = &group.MembershipQuery{
+		Tags:    map[string]string{"env": "prod"},
+		Status:  device.StatusOnline,
+		Regions: []string{"eu-west-1"},
+	}
+	require.NoError(t, store.Create(ctx, narrow))
+	members, err = store.ListDevices(ctx, "tenant-1", "grp-2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev-3"}, deviceIDs(members))
+	got, err := store.Get(ctx, "tenant-1", "grp-2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.DeviceCount)
+
+	// Dynamic membership is query driven and cannot be edited directly
+	requireCode(t, store.AddDevice(ctx, "tenant-1", "grp-1", newDevice("tenant-1", "dev-2")), group.ErrCodeInvalidOperation)
+	requireCode(t, store.RemoveDevice(ctx, "tenant-1", "grp-1", "dev-1"), group.ErrCodeInvalidOperation)
+}
+
+func testTenantIsolation(t *testing.T, factory Factory) {
+	store, devices := factory(t)
+	ctx := context.Background()
+
+	g1 := newGroup("tenant-1", "shared", group.TypeStatic)
+	g1.Name = "tenant one"
+	g2 := newGroup("tenant-2", "shared", group.TypeStatic)
+	g2.Name = "tenant two"
+	require.NoError(t, store.Create(ctx, g1))
+	require.NoError(t, store.Create(ctx, g2))
+	require.NoError(t, store.Create(ctx, newGroup("tenant-1", "only-1", group.TypeStatic)))
+
+	d := newDevice("tenant-1", "dev-1")
+	require.NoError(t, devices.Create(ctx, d))
+	require.NoError(t, store.AddDevice(ctx, "tenant-1", "shared", d))
+
+	got, err := store.Get(ctx, "tenant-2", "shared")
+	require.NoError(t, err)
+	assert.Equal(t, "tenant two", got.Name)
+	assert.Equal(t, 0, got.DeviceCount)
+
+	members, err := store.ListDevices(ctx, "tenant-2", "shared")
+	require.NoError(t, err)
+	assert.Empty(t, members)
+
+	_, err = store.Get(ctx, "tenant-2", "only-1")
+	requireCode(t, err, group.ErrCodeGroupNotFound)
+	requireCode(t, store.Update(ctx, newGroup("tenant-2", "only-1", group.TypeStatic)), group.ErrCodeGroupNotFound)
+	requireCode(t, store.Delete(ctx, "tenant-2", "only-1"), group.ErrCodeGroupNotFound)
+
+	require.NoError(t, store.Delete(ctx, "tenant-1", "shared"))
+	_, err = store.Get(ctx, "tenant-2", "shared")
+	require.NoError(t, err)
+
+	list, err := store.List(ctx, "tenant-1", group.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"only-1"}, groupIDs(list))
+
+	list, err = store.List(ctx, "tenant-3", group.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func testClear(t *testing.T, factory Factory) {
+	store, _ := factory(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.Create(ctx, newGroup("tenant-1", "grp-1", group.TypeStatic)))
+	require.NoError(t, store.Create(ctx, newGroup("tenant-2", "grp-1", group.TypeStatic)))
+	require.NoError(t, store.Clear(ctx))
+
+	for _, tenantID := range []string{"tenant-1", "tenant-2"} {
+		list, err := store.List(ctx, tenantID, group.ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, list)
+	}
+}
+
+func testConcurrency(t *testing.T, factory Factory) {
+	store, devices := factory(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.Create(ctx, newGroup("tenant-1", "static", group.TypeStatic)))
+	dynamic := newGroup("tenant-1", "dynamic", group.TypeDynamic)
+	dynamic.Query = &group.MembershipQuery{Tags: map[string]string{"env": "prod"}}
+	require.NoError(t, store.Create(ctx, dynamic))
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*4)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			d := newDevice("tenant-1", fmt.Sprintf("dev-%02d", i))
+			d.Tags["env"] = "prod"
+			if err := devices.Create(ctx, d); err != nil {
+				errs <- fmt.Errorf("create device: %w", err)
+				return
+			}
+			if err := store.AddDevice(ctx, "tenant-1", "static", d); err != nil {
+				errs <- fmt.Errorf("add device: %w", err)
+			}
+			if _, err := store.ListDevices(ctx, "tenant-1", "dynamic"); err != nil {
+				errs <- fmt.Errorf("list dynamic: %w", err)
+			}
+			if err := store.Create(ctx, newGroup("tenant-1", fmt.Sprintf("grp-%02d", i), group.TypeStatic)); err != nil {
+				errs <- fmt.Errorf("create group: %w", err)
+			}
+			if _, err := store.List(ctx, "tenant-1", group.ListOptions{}); err != nil {
+				errs <- fmt.Errorf("list groups: %w", err)
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for concurrent operations")
+	}
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	members, err := store.ListDevices(ctx, "tenant-1", "static")
+	require.NoError(t, err)
+	assert.Len(t, members, workers)
+
+	list, err := store.List(ctx, "tenant-1", group.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, list, workers+2)
+}
+
+// groupIDs extracts group IDs preserving order
+func groupIDs(groups []*group.Group) []string {
+	result := make([]string, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g.ID)
+	}
+	return result
+}
+
+// deviceIDs extracts device IDs in sorted order, since membership
+// listings carry no ordering guarantee
+func deviceIDs(devices []*device.Device) []string {
+	result := make([]string, 0, len(devices))
+	for _, d := range devices {
+		result = append(result, d.ID)
+	}
+	sort.Strings(result)
+	return result
+}
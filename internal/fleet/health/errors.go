@@ -0,0 +1,33 @@
+package health
+
+import "errors"
+
+// Common error definitions for the health domain. Store implementations
+// wrap these so callers can test for them with errors.Is.
+var (
+	// ErrComponentNotRegistered indicates an operation referenced a component
+	// that has not been registered for monitoring
+	ErrComponentNotRegistered = errors.New("component not registered")
+
+	// ErrComponentExists indicates a component is already registered
+	ErrComponentExists = errors.New("component already registered")
+
+	// ErrStatusNotFound indicates no status has been recorded for a component
+	ErrStatusNotFound = errors.New("component status not found")
+)
+
+// Device health report errors.
+var (
+	// ErrReportNotFound indicates no health report has been received from
+	// a device
+	ErrReportNotFound = errors.New("health report not found")
+
+	// ErrInvalidReport indicates a health report failed validation
+	ErrInvalidReport = errors.New("invalid health report")
+
+	// ErrMaintenanceNotFound indicates a device has no maintenance window
+	ErrMaintenanceNotFound = errors.New("maintenance window not found")
+
+	// ErrInvalidMaintenance indicates a maintenance window failed validation
+	ErrInvalidMaintenance = errors.New("invalid maintenance window")
+)
@@ -67,6 +67,10 @@ type HealthResponse struct {
 	LastChecked time.Time                `json:"last_checked"`
 	Version     *Version                 `json:"version,omitempty"`
 	Uptime      time.Duration            `json:"uptime,omitempty"`
+
+	// ConfigHash is the hash of the configuration a device agent is
+	// running. It is only set in reports submitted to the control plane.
+	ConfigHash string `json:"config_hash,omitempty"`
 }
 
 // ComponentInfo contains metadata about a monitored component
@@ -0,0 +1,122 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DeviceReport is a health report submitted periodically by a device agent.
+// It carries the agent's own HealthResponse so the control plane sees the
+// same component detail the device sees locally.
+type DeviceReport struct {
+	TenantID   string                   `json:"tenant_id"`
+	DeviceID   string                   `json:"device_id"`
+	Status     ComponentStatus          `json:"status"`
+	Ready      bool                     `json:"ready"`
+	Components map[string]*HealthStatus `json:"components,omitempty"`
+	Version    *Version                 `json:"version,omitempty"`
+	Uptime     time.Duration            `json:"uptime,omitempty"`
+	ConfigHash string                   `json:"config_hash,omitempty"`
+
+	// ReportedAt is when the device produced the report, by its own clock
+	ReportedAt time.Time `json:"reported_at"`
+
+	// ReceivedAt is when the control plane accepted the report. Staleness
+	// is judged by this time so device clock drift cannot hide a device.
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// NewDeviceReport builds a report for a device from its health response.
+func NewDeviceReport(tenantID, deviceID string, resp *HealthResponse) *DeviceReport {
+	return &DeviceReport{
+		TenantID:   tenantID,
+		DeviceID:   deviceID,
+		Status:     resp.Status,
+		Ready:      resp.Ready,
+		Components: resp.Components,
+		Version:    resp.Version,
+		Uptime:     resp.Uptime,
+		ConfigHash: resp.ConfigHash,
+		ReportedAt: resp.LastChecked,
+	}
+}
+
+// Validate checks that the report identifies a device and carries a known
+// overall status.
+func (r *DeviceReport) Validate() error {
+	if r.TenantID == "" || r.DeviceID == "" {
+		return fmt.Errorf("%w: tenant and device are required", ErrInvalidReport)
+	}
+	if !validStatus(r.Status) {
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidReport, r.Status)
+	}
+	for name, component := range r.Components {
+		if component == nil || !validStatus(component.Status) {
+			return fmt.Errorf("%w: component %q has no valid status", ErrInvalidReport, name)
+		}
+	}
+	if r.Uptime < 0 {
+		return fmt.Errorf("%w: uptime cannot be negative", ErrInvalidReport)
+	}
+	return nil
+}
+
+func validStatus(status ComponentStatus) bool {
+	switch status {
+	case StatusHealthy, StatusDegraded, StatusUnhealthy, StatusStarting:
+		return true
+	default:
+		return false
+	}
+}
+
+// MaintenanceWindow is a period during which a device is expected to be
+// silent, typically announced by the device before a planned shutdown.
+// Missed reports inside the window do not mark the device offline.
+type MaintenanceWindow struct {
+	TenantID  string    `json:"tenant_id"`
+	DeviceID  string    `json:"device_id"`
+	Reason    string    `json:"reason,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Until     time.Time `json:"until"`
+}
+
+// Active reports whether the window still covers now.
+func (w *MaintenanceWindow) Active(now time.Time) bool {
+	return now.Before(w.Until)
+}
+
+// ReportStore persists device health reports, partitioned by tenant.
+type ReportStore interface {
+	// SaveReport appends a report to the device's history
+	SaveReport(ctx context.Context, report *DeviceReport) error
+
+	// LatestReport returns the most recently received report for a device
+	LatestReport(ctx context.Context, tenantID, deviceID string) (*DeviceReport, error)
+
+	// ListReports returns up to limit reports for a device, newest first.
+	// A limit of zero or less returns the full retained history.
+	ListReports(ctx context.Context, tenantID, deviceID string, limit int) ([]*DeviceReport, error)
+
+	// ListLatestReports returns the latest report of every device in every
+	// tenant. It is used to find devices that stopped reporting.
+	ListLatestReports(ctx context.Context) ([]*DeviceReport, error)
+
+	// DeleteReports removes the report history and any maintenance window
+	// of a device
+	DeleteReports(ctx context.Context, tenantID, deviceID string) error
+
+	// SetMaintenance records a maintenance window, replacing any previous
+	// window of the same device
+	SetMaintenance(ctx context.Context, window *MaintenanceWindow) error
+
+	// GetMaintenance returns the maintenance window of a device
+	GetMaintenance(ctx context.Context, tenantID, deviceID string) (*MaintenanceWindow, error)
+
+	// ListMaintenance returns every recorded maintenance window
+	ListMaintenance(ctx context.Context) ([]*MaintenanceWindow, error)
+
+	// ClearMaintenance removes the maintenance window of a device
+	ClearMaintenance(ctx context.Context, tenantID, deviceID string) error
+}
@@ -0,0 +1,361 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultReportInterval is how often device agents are expected to
+	// submit health reports
+	DefaultReportInterval = time.Minute
+
+	// DefaultMissedReports is how many consecutive reports a device may
+	// miss before it is marked offline
+	DefaultMissedReports = 3
+
+	// DefaultMaintenanceWindow is used when a shutdown notice does not say
+	// when the device will return
+	DefaultMaintenanceWindow = time.Hour
+
+	// MaxMaintenanceWindow bounds how long a single notice may suppress
+	// missed-report detection
+	MaxMaintenanceWindow = 7 * 24 * time.Hour
+)
+
+// DeviceStatusUpdater applies device status changes derived from health
+// reports. It is satisfied by *device.Service.
+type DeviceStatusUpdater interface {
+	Get(ctx context.Context, tenantID, deviceID string) (*device.Device, error)
+	List(ctx context.Context, opts device.ListOptions) ([]*device.Device, error)
+	UpdateStatus(ctx context.Context, tenantID, deviceID string, status device.Status) error
+}
+
+// ReportService ingests device health reports, keeps device status in step
+// with them and marks devices offline once they stop reporting.
+type ReportService struct {
+	store         ReportStore
+	devices       DeviceStatusUpdater
+	logger        *zap.Logger
+	interval      time.Duration
+	missedReports int
+
+	// started bounds how long ago a device without reports can have been
+	// seen, since reports do not survive a restart
+	started time.Time
+}
+
+// ReportOption configures a ReportService.
+type ReportOption func(*ReportService)
+
+// WithReportInterval sets the expected interval between device reports.
+func WithReportInterval(interval time.Duration) ReportOption {
+	return func(s *ReportService) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// WithMissedReports sets how many reports a device may miss before it is
+// marked offline.
+func WithMissedReports(n int) ReportOption {
+	return func(s *ReportService) {
+		if n > 0 {
+			s.missedReports = n
+		}
+	}
+}
+
+// NewReportService creates a report service storing reports in store and
+// updating device status through devices.
+func NewReportService(store ReportStore, devices DeviceStatusUpdater, logger *zap.Logger, opts ...ReportOption) *ReportService {
+	s := &ReportService{
+		store:         store,
+		devices:       devices,
+		logger:        logger,
+		interval:      DefaultReportInterval,
+		missedReports: DefaultMissedReports,
+		started:       time.Now().UTC(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Interval returns how often devices are expected to report.
+func (s *ReportService) Interval() time.Duration {
+	return s.interval
+}
+
+// OfflineAfter returns how long a device may go without reporting before
+// it is marked offline.
+func (s *ReportService) OfflineAfter() time.Duration {
+	return s.interval * time.Duration(s.missedReports)
+}
+
+// Submit stores a report and updates the device status to match it. The
+// returned status is the device status after the report was applied.
+func (s *ReportService) Submit(ctx context.Context, report *DeviceReport) (device.Status, error) {
+	if err := report.Validate(); err != nil {
+		return "", err
+	}
+	ctx = device.ContextWithTenant(ctx, report.TenantID)
+
+	// Reports are only accepted for devices the tenant owns.
+	dev, err := s.devices.Get(ctx, report.TenantID, report.DeviceID)
+	if err != nil {
+		return "", err
+	}
+
+	report.ReceivedAt = time.Now().UTC()
+	if err := s.store.SaveReport(ctx, report); err != nil {
+		return "", fmt.Errorf("saving health report: %w", err)
+	}
+
+	// A report from a device in an announced maintenance window means the
+	// device is back, so the window ends and normal status tracking resumes.
+	current := dev.Status
+	if current == device.StatusMaintenance {
+		if _, err := s.store.GetMaintenance(ctx, report.TenantID, report.DeviceID); err == nil {
+			if err := s.store.ClearMaintenance(ctx, report.TenantID, report.DeviceID); err != nil {
+				return "", fmt.Errorf("clearing maintenance window: %w", err)
+			}
+			current = device.StatusUnknown
+		}
+	}
+
+	status := dev.Status
+	if want := deviceStatusFor(report.Status); shouldApply(current, want) {
+		if err := s.devices.UpdateStatus(ctx, report.TenantID, report.DeviceID, want); err != nil {
+			return "", err
+		}
+		status = want
+	}
+
+	s.logger.Debug("device health report received",
+		zap.String("tenant_id", report.TenantID),
+		zap.String("device_id", report.DeviceID),
+		zap.String("health", string(report.Status)),
+		zap.String("device_status", string(status)),
+	)
+	return status, nil
+}
+
+// Latest returns the most recent report received from a device.
+func (s *ReportService) Latest(ctx context.Context, tenantID, deviceID string) (*DeviceReport, error) {
+	return s.store.LatestReport(ctx, tenantID, deviceID)
+}
+
+// History returns up to limit reports from a device, newest first.
+func (s *ReportService) History(ctx context.Context, tenantID, deviceID string, limit int) ([]*DeviceReport, error) {
+	return s.store.ListReports(ctx, tenantID, deviceID, limit)
+}
+
+// Forget discards the reports of a device that left the fleet.
+func (s *ReportService) Forget(ctx context.Context, tenantID, deviceID string) error {
+	return s.store.DeleteReports(ctx, tenantID, deviceID)
+}
+
+// BeginMaintenance moves a device into maintenance until the given time,
+// typically in response to a shutdown notice. Missed reports are ignored
+// until the window ends; a device that has not reported again by then is
+// marked offline. A zero until selects DefaultMaintenanceWindow.
+func (s *ReportService) BeginMaintenance(ctx context.Context, tenantID, deviceID, reason string, until time.Time) (*MaintenanceWindow, error) {
+	now := time.Now().UTC()
+	if until.IsZero() {
+		until = now.Add(DefaultMaintenanceWindow)
+	}
+	if !until.After(now) {
+		return nil, fmt.Errorf("%w: expected return must be in the future", ErrInvalidMaintenance)
+	}
+	if until.Sub(now) > MaxMaintenanceWindow {
+		return nil, fmt.Errorf("%w: expected return must be within %s", ErrInvalidMaintenance, MaxMaintenanceWindow)
+	}
+
+	ctx = device.ContextWithTenant(ctx, tenantID)
+	dev, err := s.devices.Get(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	window := &MaintenanceWindow{
+		TenantID:  tenantID,
+		DeviceID:  deviceID,
+		Reason:    reason,
+		StartedAt: now,
+		Until:     until.UTC(),
+	}
+	if err := s.store.SetMaintenance(ctx, window); err != nil {
+		return nil, fmt.Errorf("saving maintenance window: %w", err)
+	}
+	if dev.Status != device.StatusMaintenance {
+		if err := s.devices.UpdateStatus(ctx, tenantID, deviceID, device.StatusMaintenance); err != nil {
+			return nil, err
+		}
+	}
+
+	s.logger.Info("device entered maintenance",
+		zap.String("tenant_id", tenantID),
+		zap.String("device_id", deviceID),
+		zap.String("reason", reason),
+		zap.Time("until", window.Until),
+	)
+	return window, nil
+}
+
+// Maintenance returns the maintenance window of a device.
+func (s *ReportService) Maintenance(ctx context.Context, tenantID, deviceID string) (*MaintenanceWindow, error) {
+	return s.store.GetMaintenance(ctx, tenantID, deviceID)
+}
+
+// SweepStale marks every online or failing device that has not been seen
+// for OfflineAfter as offline and returns how many devices were changed. A
+// device is seen when it reports. Without a report, because the device
+// never reported or its reports were lost in a restart, it was last seen
+// when it was last updated but not before the service started, so every
+// device has a full allowance to report after a restart. Devices inside an
+// announced maintenance window are left alone until the window ends, as
+// are devices an operator put into maintenance.
+func (s *ReportService) SweepStale(ctx context.Context, now time.Time) (int, error) {
+	devices, err := s.devices.List(ctx, device.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("listing devices: %w", err)
+	}
+	reports, err := s.store.ListLatestReports(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing latest health reports: %w", err)
+	}
+	windows, err := s.store.ListMaintenance(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing maintenance windows: %w", err)
+	}
+
+	marked := s.sweepMaintenance(ctx, windows, now)
+	inWindow := make(map[string]bool, len(windows))
+	for _, w := range windows {
+		inWindow[w.TenantID+"/"+w.DeviceID] = true
+	}
+	lastReport := make(map[string]time.Time, len(reports))
+	for _, report := range reports {
+		lastReport[report.TenantID+"/"+report.DeviceID] = report.ReceivedAt
+	}
+
+	cutoff := now.Add(-s.OfflineAfter())
+	for _, dev := range devices {
+		if dev.Status != device.StatusOnline && dev.Status != device.StatusError {
+			continue
+		}
+		key := dev.TenantID + "/" + dev.ID
+		if inWindow[key] {
+			continue
+		}
+		lastSeen, reported := lastReport[key]
+		if !reported {
+			lastSeen = dev.UpdatedAt
+			if lastSeen.Before(s.started) {
+				lastSeen = s.started
+			}
+		}
+		if lastSeen.After(cutoff) {
+			continue
+		}
+
+		tenantCtx := device.ContextWithTenant(ctx, dev.TenantID)
+		if err := s.devices.UpdateStatus(tenantCtx, dev.TenantID, dev.ID, device.StatusOffline); err != nil {
+			s.logger.Error("failed to mark device offline",
+				zap.String("tenant_id", dev.TenantID),
+				zap.String("device_id", dev.ID),
+				zap.Error(err))
+			continue
+		}
+		marked++
+
+		s.logger.Warn("device marked offline after missed health reports",
+			zap.String("tenant_id", dev.TenantID),
+			zap.String("device_id", dev.ID),
+			zap.Time("last_seen", lastSeen),
+			zap.Bool("reported", reported),
+			zap.Int("missed_reports", s.missedReports),
+		)
+	}
+	return marked, nil
+}
+
+// sweepMaintenance ends expired maintenance windows. The device did not
+// report again before its announced return, so it is marked offline.
+func (s *ReportService) sweepMaintenance(ctx context.Context, windows []*MaintenanceWindow, now time.Time) int {
+	marked := 0
+	for _, w := range windows {
+		if w.Active(now) {
+			continue
+		}
+
+		tenantCtx := device.ContextWithTenant(ctx, w.TenantID)
+		if err := s.store.ClearMaintenance(tenantCtx, w.TenantID, w.DeviceID); err != nil {
+			s.logger.Error("failed to clear maintenance window",
+				zap.String("tenant_id", w.TenantID),
+				zap.String("device_id", w.DeviceID),
+				zap.Error(err))
+			continue
+		}
+
+		dev, err := s.devices.Get(tenantCtx, w.TenantID, w.DeviceID)
+		if err != nil || dev.Status != device.StatusMaintenance {
+			continue
+		}
+		if err := s.devices.UpdateStatus(tenantCtx, w.TenantID, w.DeviceID, device.StatusOffline); err != nil {
+			s.logger.Error("failed to mark device offline",
+				zap.String("tenant_id", w.TenantID),
+				zap.String("device_id", w.DeviceID),
+				zap.Error(err))
+			continue
+		}
+		marked++
+
+		s.logger.Warn("device did not return from maintenance",
+			zap.String("tenant_id", w.TenantID),
+			zap.String("device_id", w.DeviceID),
+			zap.String("reason", w.Reason),
+			zap.Time("expected_return", w.Until),
+		)
+	}
+	return marked
+}
+
+// Run sweeps for stale devices once per report interval until ctx is done.
+func (s *ReportService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := s.SweepStale(ctx, now); err != nil {
+				s.logger.Error("health report sweep failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// deviceStatusFor maps a reported overall health to a device status.
+func deviceStatusFor(status ComponentStatus) device.Status {
+	if status == StatusUnhealthy {
+		return device.StatusError
+	}
+	return device.StatusOnline
+}
+
+// shouldApply reports whether a status derived from health reports should
+// replace the current one. Maintenance is set by operators and is never
+// overridden by reports.
+func shouldApply(current, want device.Status) bool {
+	return current != want && current != device.StatusMaintenance
+}
@@ -0,0 +1,211 @@
+package health_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	devicetesting "github.com/wrale/wrale-fleet/internal/fleet/device/testing"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"github.com/wrale/wrale-fleet/internal/fleet/health/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+func newReport(tenantID, deviceID string, status health.ComponentStatus) *health.DeviceReport {
+	return health.NewDeviceReport(tenantID, deviceID, &health.HealthResponse{
+		Status: status,
+		Ready:  true,
+		Components: map[string]*health.HealthStatus{
+			"device_agent": {Status: status},
+		},
+		Version:     &health.Version{Version: "1.0.0", Stage: 1},
+		Uptime:      time.Hour,
+		LastChecked: time.Now().UTC(),
+	})
+}
+
+func TestReportServiceSubmit(t *testing.T) {
+	devices := devicetesting.NewTestService(t)
+	svc := health.NewReportService(memory.NewReportStore(0), devices, zaptest.NewLogger(t))
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	dev, err := devices.Register(ctx, "tenant-a", "edge-1")
+	require.NoError(t, err)
+
+	status, err := svc.Submit(context.Background(), newReport("tenant-a", dev.ID, health.StatusHealthy))
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusOnline, status)
+
+	status, err = svc.Submit(context.Background(), newReport("tenant-a", dev.ID, health.StatusUnhealthy))
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusError, status)
+
+	stored, err := devices.Get(ctx, "tenant-a", dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusError, stored.Status)
+
+	latest, err := svc.Latest(ctx, "tenant-a", dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, health.StatusUnhealthy, latest.Status)
+	assert.False(t, latest.ReceivedAt.IsZero())
+
+	history, err := svc.History(ctx, "tenant-a", dev.ID, 0)
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	// Reports are scoped to the device's tenant.
+	_, err = svc.Submit(context.Background(), newReport("tenant-b", dev.ID, health.StatusHealthy))
+	assert.Error(t, err)
+
+	// Invalid reports are rejected before anything is stored.
+	_, err = svc.Submit(context.Background(), newReport("tenant-a", dev.ID, "sideways"))
+	assert.ErrorIs(t, err, health.ErrInvalidReport)
+}
+
+func TestReportServiceSweepStale(t *testing.T) {
+	devices := devicetesting.NewTestService(t)
+	svc := health.NewReportService(memory.NewReportStore(0), devices, zaptest.NewLogger(t),
+		health.WithReportInterval(10*time.Second),
+		health.WithMissedReports(3),
+	)
+	assert.Equal(t, 30*time.Second, svc.OfflineAfter())
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	active, err := devices.Register(ctx, "tenant-a", "active")
+	require.NoError(t, err)
+	maintained, err := devices.Register(ctx, "tenant-a", "maintained")
+	require.NoError(t, err)
+
+	for _, id := range []string{active.ID, maintained.ID} {
+		_, err := svc.Submit(ctx, newReport("tenant-a", id, health.StatusHealthy))
+		require.NoError(t, err)
+	}
+	require.NoError(t, devices.UpdateStatus(ctx, "tenant-a", maintained.ID, device.StatusMaintenance))
+
+	// Within the allowance nothing changes.
+	marked, err := svc.SweepStale(ctx, time.Now().Add(20*time.Second))
+	require.NoError(t, err)
+	assert.Zero(t, marked)
+
+	// After three missed reports the device is offline; maintenance wins.
+	marked, err = svc.SweepStale(ctx, time.Now().Add(31*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, marked)
+
+	got, err := devices.Get(ctx, "tenant-a", active.ID)
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusOffline, got.Status)
+
+	got, err = devices.Get(ctx, "tenant-a", maintained.ID)
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusMaintenance, got.Status)
+
+	// A device that has already been marked offline is not marked again.
+	marked, err = svc.SweepStale(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, marked)
+
+	// A fresh report brings the device back online.
+	status, err := svc.Submit(ctx, newReport("tenant-a", active.ID, health.StatusDegraded))
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusOnline, status)
+}
+
+func TestReportServiceSweepStaleWithoutReports(t *testing.T) {
+	devices := devicetesting.NewTestService(t)
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+
+	// Devices left online by an earlier run of the control plane, whose
+	// reports were lost when it restarted.
+	before, err := devices.Register(ctx, "tenant-a", "before-restart")
+	require.NoError(t, err)
+	require.NoError(t, devices.UpdateStatus(ctx, "tenant-a", before.ID, device.StatusOnline))
+	failing, err := devices.Register(ctx, "tenant-a", "failing")
+	require.NoError(t, err)
+	require.NoError(t, devices.UpdateStatus(ctx, "tenant-a", failing.ID, device.StatusError))
+	unmanaged, err := devices.Register(ctx, "tenant-a", "never-online")
+	require.NoError(t, err)
+
+	svc := health.NewReportService(memory.NewReportStore(0), devices, zaptest.NewLogger(t),
+		health.WithReportInterval(10*time.Second),
+		health.WithMissedReports(3),
+	)
+
+	// After the restart every device gets a full allowance to report.
+	marked, err := svc.SweepStale(ctx, time.Now().Add(20*time.Second))
+	require.NoError(t, err)
+	assert.Zero(t, marked)
+
+	// A device registered since the restart that never reports is swept
+	// like any other.
+	silent, err := devices.Register(ctx, "tenant-a", "silent")
+	require.NoError(t, err)
+	require.NoError(t, devices.UpdateStatus(ctx, "tenant-a", silent.ID, device.StatusOnline))
+
+	marked, err = svc.SweepStale(ctx, time.Now().Add(31*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 3, marked)
+
+	for _, d := range []*device.Device{before, failing, silent} {
+		got, err := devices.Get(ctx, "tenant-a", d.ID)
+		require.NoError(t, err)
+		assert.Equal(t, device.StatusOffline, got.Status, d.Name)
+	}
+	got, err := devices.Get(ctx, "tenant-a", unmanaged.ID)
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusUnknown, got.Status, "devices never online are not swept")
+}
+
+func TestReportServiceMaintenanceWindow(t *testing.T) {
+	devices := devicetesting.NewTestService(t)
+	svc := health.NewReportService(memory.NewReportStore(0), devices, zaptest.NewLogger(t),
+		health.WithReportInterval(10*time.Second),
+		health.WithMissedReports(1),
+	)
+
+	ctx := device.ContextWithTenant(context.Background(), "tenant-a")
+	dev, err := devices.Register(ctx, "tenant-a", "edge-1")
+	require.NoError(t, err)
+	_, err = svc.Submit(ctx, newReport("tenant-a", dev.ID, health.StatusHealthy))
+	require.NoError(t, err)
+
+	_, err = svc.BeginMaintenance(ctx, "tenant-a", dev.ID, "reboot", time.Now().Add(-time.Minute))
+	assert.ErrorIs(t, err, health.ErrInvalidMaintenance)
+	_, err = svc.BeginMaintenance(ctx, "tenant-a", dev.ID, "reboot", time.Now().Add(30*24*time.Hour))
+	assert.ErrorIs(t, err, health.ErrInvalidMaintenance)
+
+	window, err := svc.BeginMaintenance(ctx, "tenant-a", dev.ID, "reboot", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "reboot", window.Reason)
+
+	got, err := devices.Get(ctx, "tenant-a", dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusMaintenance, got.Status)
+
+	// Missed reports inside the window are not an outage.
+	marked, err := svc.SweepStale(ctx, time.Now().Add(4*time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, marked)
+
+	// Once the window passes without a report the device is offline.
+	marked, err = svc.SweepStale(ctx, time.Now().Add(6*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, marked)
+	got, err = devices.Get(ctx, "tenant-a", dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusOffline, got.Status)
+	_, err = svc.Maintenance(ctx, "tenant-a", dev.ID)
+	assert.ErrorIs(t, err, health.ErrMaintenanceNotFound)
+
+	// A device reporting back within its window leaves maintenance.
+	_, err = svc.BeginMaintenance(ctx, "tenant-a", dev.ID, "update", time.Time{})
+	require.NoError(t, err)
+	status, err := svc.Submit(ctx, newReport("tenant-a", dev.ID, health.StatusHealthy))
+	require.NoError(t, err)
+	assert.Equal(t, device.StatusOnline, status)
+	_, err = svc.Maintenance(ctx, "tenant-a", dev.ID)
+	assert.ErrorIs(t, err, health.ErrMaintenanceNotFound)
+}
@@ -0,0 +1,31 @@
+// Package factory provides creation functions for health store implementations.
+package factory
+
+import (
+	"context"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"github.com/wrale/wrale-fleet/internal/fleet/health/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/storage"
+)
+
+// Built-in backend names
+const (
+	// Memory keeps component health in memory
+	Memory = "memory"
+)
+
+// Options configures health store backends.
+type Options struct {
+	// StoreOptions are passed through to the backend
+	StoreOptions []health.StoreOption
+}
+
+// Registry holds the available health store backends.
+var Registry = storage.NewRegistry[health.Store, Options]("health")
+
+func init() {
+	Registry.Register(Memory, func(ctx context.Context, opts Options) (health.Store, error) {
+		return memory.New(opts.StoreOptions...), nil
+	})
+}
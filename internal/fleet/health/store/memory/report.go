@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+)
+
+// DefaultReportHistory is the number of reports retained per device.
+const DefaultReportHistory = 100
+
+// ReportStore provides an in-memory implementation of health.ReportStore.
+// It retains a bounded history per device, oldest reports first.
+type ReportStore struct {
+	mu          sync.RWMutex
+	history     int
+	reports     map[string][]*health.DeviceReport    // tenantID/deviceID -> reports
+	maintenance map[string]*health.MaintenanceWindow // tenantID/deviceID -> window
+}
+
+// NewReportStore creates an in-memory report store retaining up to history
+// reports per device. A history of zero or less selects
+// DefaultReportHistory.
+func NewReportStore(history int) *ReportStore {
+	if history <= 0 {
+		history = DefaultReportHistory
+	}
+	return &ReportStore{
+		history:     history,
+		reports:     make(map[string][]*health.DeviceReport),
+		maintenance: make(map[string]*health.MaintenanceWindow),
+	}
+}
+
+func reportKey(tenantID, deviceID string) string {
+	return tenantID + "/" + deviceID
+}
+
+// SaveReport appends a report to the device's history
+func (s *ReportStore) SaveReport(ctx context.Context, report *health.DeviceReport) error {
+	if report.TenantID == "" || report.DeviceID == "" {
+		return fmt.Errorf("%w: tenant and device are required", health.ErrInvalidReport)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *report
+	key := reportKey(report.TenantID, report.DeviceID)
+	reports := append(s.reports[key], &copied)
+	if len(reports) > s.history {
+		reports = reports[len(reports)-s.history:]
+	}
+	s.reports[key] = reports
+	return nil
+}
+
+// LatestReport returns the most recently received report for a device
+func (s *ReportStore) LatestReport(ctx context.Context, tenantID, deviceID string) (*health.DeviceReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reports := s.reports[reportKey(tenantID, deviceID)]
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("device %s: %w", deviceID, health.ErrReportNotFound)
+	}
+	copied := *reports[len(reports)-1]
+	return &copied, nil
+}
+
+// ListReports returns up to limit reports for a device, newest first
+func (s *ReportStore) ListReports(ctx context.Context, tenantID, deviceID string, limit int) ([]*health.DeviceReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reports := s.reports[reportKey(tenantID, deviceID)]
+	if limit <= 0 || limit > len(reports) {
+		limit = len(reports)
+	}
+
+	result := make([]*health.DeviceReport, 0, limit)
+	for i := len(reports) - 1; i >= 0 && len(result) < limit; i-- {
+		copied := *reports[i]
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// ListLatestReports returns the latest report of every device
+func (s *ReportStore) ListLatestReports(ctx context.Context) ([]*health.DeviceReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*health.DeviceReport, 0, len(s.reports))
+	for _, reports := range s.reports {
+		if len(reports) == 0 {
+			continue
+		}
+		copied := *reports[len(reports)-1]
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// DeleteReports removes the report history and maintenance window of a device
+func (s *ReportStore) DeleteReports(ctx context.Context, tenantID, deviceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := reportKey(tenantID, deviceID)
+	delete(s.reports, key)
+	delete(s.maintenance, key)
+	return nil
+}
+
+// SetMaintenance records a maintenance window
+func (s *ReportStore) SetMaintenance(ctx context.Context, window *health.MaintenanceWindow) error {
+	if window.TenantID == "" || window.DeviceID == "" {
+		return fmt.Errorf("%w: tenant and device are required", health.ErrInvalidMaintenance)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *window
+	s.maintenance[reportKey(window.TenantID, window.DeviceID)] = &copied
+	return nil
+}
+
+// GetMaintenance returns the maintenance window of a device
+func (s *ReportStore) GetMaintenance(ctx context.Context, tenantID, deviceID string) (*health.MaintenanceWindow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	window, exists := s.maintenance[reportKey(tenantID, deviceID)]
+	if !exists {
+		return nil, fmt.Errorf("device %s: %w", deviceID, health.ErrMaintenanceNotFound)
+	}
+	copied := *window
+	return &copied, nil
+}
+
+// ListMaintenance returns every recorded maintenance window
+func (s *ReportStore) ListMaintenance(ctx context.Context) ([]*health.MaintenanceWindow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*health.MaintenanceWindow, 0, len(s.maintenance))
+	for _, window := range s.maintenance {
+		copied := *window
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// ClearMaintenance removes the maintenance window of a device
+func (s *ReportStore) ClearMaintenance(ctx context.Context, tenantID, deviceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.maintenance, reportKey(tenantID, deviceID))
+	return nil
+}
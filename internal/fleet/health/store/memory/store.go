@@ -41,7 +41,7 @@ func (s *Store) UpdateComponentStatus(ctx context.Context, component string, sta
 	defer s.mu.Unlock()
 
 	if _, exists := s.components[component]; !exists {
-		return fmt.Errorf("component %s not registered", component)
+		return fmt.Errorf("component %s: %w", component, health.ErrComponentNotRegistered)
 	}
 
 	s.statuses[component] = status
@@ -55,7 +55,7 @@ func (s *Store) GetComponentStatus(ctx context.Context, component string) (*heal
 
 	status, exists := s.statuses[component]
 	if !exists {
-		return nil, fmt.Errorf("no status found for component %s", component)
+		return nil, fmt.Errorf("component %s: %w", component, health.ErrStatusNotFound)
 	}
 
 	return status, nil
@@ -99,7 +99,7 @@ func (s *Store) RegisterComponent(ctx context.Context, component string, info he
 	defer s.mu.Unlock()
 
 	if _, exists := s.components[component]; exists {
-		return fmt.Errorf("component %s already registered", component)
+		return fmt.Errorf("component %s: %w", component, health.ErrComponentExists)
 	}
 
 	s.components[component] = info
@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+	"github.com/wrale/wrale-fleet/internal/fleet/health/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) health.Store {
+		return New()
+	})
+}
+
+func TestReportStoreRetainsBoundedHistory(t *testing.T) {
+	ctx := context.Background()
+	store := NewReportStore(2)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.SaveReport(ctx, &health.DeviceReport{
+			TenantID: "tenant-a",
+			DeviceID: "dev-1",
+			Status:   health.StatusHealthy,
+			Uptime:   time.Duration(i) * time.Minute,
+		}))
+	}
+
+	reports, err := store.ListReports(ctx, "tenant-a", "dev-1", 0)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	assert.Equal(t, 2*time.Minute, reports[0].Uptime)
+	assert.Equal(t, time.Minute, reports[1].Uptime)
+
+	_, err = store.LatestReport(ctx, "tenant-b", "dev-1")
+	assert.ErrorIs(t, err, health.ErrReportNotFound)
+
+	latest, err := store.ListLatestReports(ctx)
+	require.NoError(t, err)
+	assert.Len(t, latest, 1)
+
+	require.NoError(t, store.DeleteReports(ctx, "tenant-a", "dev-1"))
+	_, err = store.LatestReport(ctx, "tenant-a", "dev-1")
+	assert.ErrorIs(t, err, health.ErrReportNotFound)
+}
@@ -0,0 +1,207 @@
+// Package storetest provides a conformance suite for health.Store
+// implementations.
+//
+// Health stores track the components of a single process and are not
+// partitioned by tenant, so the suite covers component lifecycle, status
+// round-trips, not-found errors, readiness and concurrent access. Backends
+// run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) health.Store {
+//			return memory.New()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/health"
+)
+
+// Factory creates an empty store for a single test. Implementations that
+// hold resources should release them through t.Cleanup.
+type Factory func(t *testing.T) health.Store
+
+// Run executes the full conformance suite against stores created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("Register", func(t *testing.T) { testRegister(t, factory(t)) })
+	t.Run("ComponentStatus", func(t *testing.T) { testComponentStatus(t, factory(t)) })
+	t.Run("Unregister", func(t *testing.T) { testUnregister(t, factory(t)) })
+	t.Run("ListComponentStatuses", func(t *testing.T) { testList(t, factory(t)) })
+	t.Run("Ready", func(t *testing.T) { testReady(t, factory(t)) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory(t)) })
+}
+
+// newStatus builds a status snapshot for a component
+func newStatus(status health.ComponentStatus, message string) *health.HealthStatus {
+	return &health.HealthStatus{
+		TenantID:    "tenant-1",
+		Status:      status,
+		Message:     message,
+		LastChecked: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func testRegister(t *testing.T, store health.Store) {
+	ctx := context.Background()
+
+	info := health.ComponentInfo{Name: "api", Category: "core", Critical: true}
+	require.NoError(t, store.RegisterComponent(ctx, "api", info))
+
+	err := store.RegisterComponent(ctx, "api", info)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, health.ErrComponentExists)
+
+	// Registration alone records no status
+	_, err = store.GetComponentStatus(ctx, "api")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, health.ErrStatusNotFound)
+}
+
+func testComponentStatus(t *testing.T, store health.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.RegisterComponent(ctx, "api", health.ComponentInfo{Name: "api"}))
+
+	require.NoError(t, store.UpdateComponentStatus(ctx, "api", newStatus(health.StatusDegraded, "slow")))
+
+	got, err := store.GetComponentStatus(ctx, "api")
+	require.NoError(t, err)
+	assert.Equal(t, health.StatusDegraded, got.Status)
+	assert.Equal(t, "slow", got.Message)
+	assert.Equal(t, "tenant-1", got.TenantID)
+
+	require.NoError(t, store.UpdateComponentStatus(ctx, "api", newStatus(health.StatusHealthy, "")))
+	got, err = store.GetComponentStatus(ctx, "api")
+	require.NoError(t, err)
+	assert.Equal(t, health.StatusHealthy, got.Status)
+
+	err = store.UpdateComponentStatus(ctx, "missing", newStatus(health.StatusHealthy, ""))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, health.ErrComponentNotRegistered)
+
+	_, err = store.GetComponentStatus(ctx, "missing")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, health.ErrStatusNotFound)
+}
+
+func testUnregister(t *testing.T, store health.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.RegisterComponent(ctx, "api", health.ComponentInfo{Name: "api"}))
+	require.NoError(t, store.UpdateComponentStatus(ctx, "api", newStatus(health.StatusHealthy, "")))
+
+	require.NoError(t, store.UnregisterComponent(ctx, "api"))
+
+	_, err := store.GetComponentStatus(ctx, "api")
+	assert.ErrorIs(t, err, health.ErrStatusNotFound)
+
+	err = store.UpdateComponentStatus(ctx, "api", newStatus(health.StatusHealthy, ""))
+	assert.ErrorIs(t, err, health.ErrComponentNotRegistered)
+
+	// A component can be registered again after removal
+	require.NoError(t, store.RegisterComponent(ctx, "api", health.ComponentInfo{Name: "api"}))
+}
+
+func testList(t *testing.T, store health.Store) {
+	ctx := context.Background()
+
+	statuses, err := store.ListComponentStatuses(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, statuses)
+
+	for _, name := range []string{"api", "db", "queue"} {
+		require.NoError(t, store.RegisterComponent(ctx, name, health.ComponentInfo{Name: name}))
+	}
+	require.NoError(t, store.UpdateComponentStatus(ctx, "api", newStatus(health.StatusHealthy, "")))
+	require.NoError(t, store.UpdateComponentStatus(ctx, "db", newStatus(health.StatusUnhealthy, "down")))
+
+	statuses, err = store.ListComponentStatuses(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, health.StatusHealthy, statuses["api"].Status)
+	assert.Equal(t, health.StatusUnhealthy, statuses["db"].Status)
+
+	// The returned map must not alias store state
+	statuses["api"].Status = health.StatusUnhealthy
+	delete(statuses, "db")
+
+	again, err := store.ListComponentStatuses(ctx)
+	require.NoError(t, err)
+	require.Len(t, again, 2)
+	assert.Equal(t, health.StatusHealthy, again["api"].Status)
+}
+
+func testReady(t *testing.T, store health.Store) {
+	ctx := context.Background()
+
+	ready, err := store.GetReadyStatus(ctx)
+	require.NoError(t, err)
+	assert.False(t, ready)
+
+	require.NoError(t, store.SetReadyStatus(ctx, true))
+	ready, err = store.GetReadyStatus(ctx)
+	require.NoError(t, err)
+	assert.True(t, ready)
+
+	require.NoError(t, store.SetReadyStatus(ctx, false))
+	ready, err = store.GetReadyStatus(ctx)
+	require.NoError(t, err)
+	assert.False(t, ready)
+}
+
+func testConcurrency(t *testing.T, store health.Store) {
+	ctx := context.Background()
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*4)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("component-%02d", i)
+			if err := store.RegisterComponent(ctx, name, health.ComponentInfo{Name: name}); err != nil {
+				errs <- fmt.Errorf("register %s: %w", name, err)
+				return
+			}
+			if err := store.UpdateComponentStatus(ctx, name, newStatus(health.StatusHealthy, "")); err != nil {
+				errs <- fmt.Errorf("update %s: %w", name, err)
+			}
+			if _, err := store.ListComponentStatuses(ctx); err != nil {
+				errs <- fmt.Errorf("list: %w", err)
+			}
+			if err := store.SetReadyStatus(ctx, i%2 == 0); err != nil {
+				errs <- fmt.Errorf("set ready: %w", err)
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for concurrent operations")
+	}
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	statuses, err := store.ListComponentStatuses(ctx)
+	require.NoError(t, err)
+	assert.Len(t, statuses, workers)
+}
@@ -154,6 +154,23 @@ func (s *Service) Retention(ctx context.Context, tenantID string) error {
 	return nil
 }
 
+// ApplyRetentionPolicy removes all of a tenant's events older than maxAge,
+// regardless of event type. It is used for explicit, operator-driven cleanup.
+func (s *Service) ApplyRetentionPolicy(ctx context.Context, tenantID string, maxAge time.Duration) error {
+	if tenantID == "" {
+		return ErrMissingTenant
+	}
+	if maxAge < 0 {
+		return ErrInvalidRetention
+	}
+
+	cutoff := time.Now().UTC().Add(-maxAge)
+	if err := s.store.DeleteBefore(ctx, tenantID, cutoff); err != nil {
+		return fmt.Errorf("applying retention policy: %w", err)
+	}
+	return nil
+}
+
 // Sync ensures all events are durably stored
 func (s *Service) Sync(ctx context.Context) error {
 	return s.store.Sync(ctx)
@@ -14,7 +14,6 @@ import (
 )
 
 func TestService_Log(t *testing.T) {
-	logger := zaptest.NewLogger(t)
 	service := loggingtest.NewTestService(t)
 
 	tests := []struct {
@@ -144,7 +143,6 @@ func TestService_BatchLog(t *testing.T) {
 }
 
 func TestService_RetentionPolicy(t *testing.T) {
-	logger := zaptest.NewLogger(t)
 	service := loggingtest.NewTestService(t)
 
 	ctx := context.Background()
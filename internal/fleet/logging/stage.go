@@ -25,41 +25,28 @@ type stageCoreWrapper struct {
 }
 
 func (w *stageCoreWrapper) With(fields []zapcore.Field) zapcore.Core {
-	// Check fields for stage information
+	// A stage field on a derived logger sets the stage of that logger only
+	stage := w.stage
 	for i := range fields {
-		if fields[i].Key == stageKey {
-			if stage, ok := fields[i].Interface.(int); ok {
-				atomic.StoreInt32(w.stage, int32(stage))
-			}
+		if fields[i].Key == stageKey && fields[i].Type == zapcore.Int64Type {
+			stage = new(int32)
+			atomic.StoreInt32(stage, int32(fields[i].Integer))
 		}
 	}
-	return &stageCoreWrapper{w.Core.With(fields), w.stage}
+	return &stageCoreWrapper{w.Core.With(fields), stage}
 }
 
 // WithStage adds stage information to a logger, enabling stage-aware logging
 // and proper capability gating. The stage value is constrained to be between
-// MinStage and MaxStage inclusive.
+// MinStage and MaxStage inclusive, and is attached to every entry the logger
+// writes as the stage field.
 func WithStage(logger *zap.Logger, stage int) *zap.Logger {
-	if stage < MinStage {
-		stage = MinStage // Ensure minimum of Stage 1
-	}
-	if stage > MaxStage {
-		stage = MaxStage // Cap at maximum Stage 6
-	}
-
-	// Store stage in atomic value for thread-safe access
-	stageVal := new(int32)
-	atomic.StoreInt32(stageVal, int32(stage))
-
-	// Create wrapped core
-	wrappedCore := &stageCoreWrapper{
-		Core:  logger.Core(),
-		stage: stageVal,
-	}
+	field := StageField(stage)
 
-	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
-		return wrappedCore
+	wrapped := logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &stageCoreWrapper{Core: c, stage: new(int32)}
 	}))
+	return wrapped.With(field)
 }
 
 // StageCheck verifies if a requested operation is supported in the current stage.
@@ -47,8 +47,8 @@ func TestStageCheck(t *testing.T) {
 			currentStage:  3,
 			requiredStage: 3,
 			operation:     "current_op",
-			want:          false,
-			wantWarning:   true,
+			want:          true,
+			wantWarning:   false,
 			wantError:     false,
 		},
 		{
@@ -178,10 +178,11 @@ func TestGetStage(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			stagedLogger := logger
 			if tt.inputStage > 0 {
-				logger = WithStage(logger, tt.inputStage)
+				stagedLogger = WithStage(logger, tt.inputStage)
 			}
-			got := GetStage(logger)
+			got := GetStage(stagedLogger)
 			assert.Equal(t, tt.want, got)
 		})
 	}
@@ -2,12 +2,63 @@
 package factory
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+
 	"github.com/wrale/wrale-fleet/internal/fleet/logging"
 	"github.com/wrale/wrale-fleet/internal/fleet/logging/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging/store/wal"
+	"github.com/wrale/wrale-fleet/internal/fleet/storage"
+)
+
+// Built-in backend names
+const (
+	// Memory keeps events in memory; events are lost on restart
+	Memory = "memory"
+	// WAL appends events to segment files on local disk
+	WAL = "wal"
 )
 
+// walDir is the event log directory within Options.DataDir
+const walDir = "events"
+
+// Options configures logging store backends.
+type Options struct {
+	// DataDir is the directory file-backed stores keep their data in
+	DataDir string
+
+	// MaxSegmentSize overrides the WAL segment rotation size when positive
+	MaxSegmentSize int64
+}
+
+// Registry holds the available logging store backends.
+var Registry = storage.NewRegistry[logging.Store, Options]("logging")
+
+func init() {
+	Registry.Register(Memory, func(ctx context.Context, opts Options) (logging.Store, error) {
+		return NewMemoryStore(), nil
+	})
+	Registry.Register(WAL, func(ctx context.Context, opts Options) (logging.Store, error) {
+		if opts.DataDir == "" {
+			return nil, fmt.Errorf("data directory is required")
+		}
+		var walOpts []wal.Option
+		if opts.MaxSegmentSize > 0 {
+			walOpts = append(walOpts, wal.WithMaxSegmentSize(opts.MaxSegmentSize))
+		}
+		return NewWALStore(filepath.Join(opts.DataDir, walDir), walOpts...)
+	})
+}
+
 // NewMemoryStore creates a new in-memory logging store.
 // This is primarily used for testing and development purposes.
 func NewMemoryStore() logging.Store {
 	return memory.New()
 }
+
+// NewWALStore opens a durable, append-only logging store in dir.
+// The returned store must be closed to release its segment files.
+func NewWALStore(dir string, opts ...wal.Option) (*wal.Store, error) {
+	return wal.New(dir, opts...)
+}
@@ -73,7 +73,7 @@ func (s *Store) List(ctx context.Context, opts logging.ListOptions) ([]*logging.
 
 	// Collect all matching events
 	for _, event := range tenant {
-		if matchesListOptions(event, opts) {
+		if opts.Matches(event) {
 			results = append(results, event)
 		}
 	}
@@ -147,13 +147,13 @@ func (s *Store) Query(ctx context.Context, query logging.QueryOptions) ([]*loggi
 
 	// Collect all matching events
 	for _, event := range tenant {
-		if matchesQueryOptions(event, query) {
+		if query.Matches(event) {
 			results = append(results, event)
 		}
 	}
 
 	// Apply sorting
-	sortEvents(results, query.OrderBy, query.OrderDirection)
+	logging.SortEvents(results, query.OrderBy, query.OrderDirection)
 
 	// Apply pagination
 	if query.Offset >= len(results) {
@@ -193,190 +193,3 @@ func (s *Store) BatchStore(ctx context.Context, events []*logging.Event) error {
 func (s *Store) Sync(ctx context.Context) error {
 	return nil
 }
-
-// matchesListOptions checks if an event matches the list options by comparing
-// each filter criteria
-func matchesListOptions(event *logging.Event, opts logging.ListOptions) bool {
-	if opts.Type != "" && event.Type != opts.Type {
-		return false
-	}
-	if opts.Level != "" && event.Level != opts.Level {
-		return false
-	}
-	if opts.Source != "" && event.Source != opts.Source {
-		return false
-	}
-	if opts.StartTime != nil && event.Timestamp.Before(*opts.StartTime) {
-		return false
-	}
-	if opts.EndTime != nil && event.Timestamp.After(*opts.EndTime) {
-		return false
-	}
-	if opts.ComponentID != "" && event.Context.ComponentID != opts.ComponentID {
-		return false
-	}
-	if opts.DeviceID != "" && event.Context.DeviceID != opts.DeviceID {
-		return false
-	}
-	if len(opts.Tags) > 0 {
-		for k, v := range opts.Tags {
-			if event.Tags[k] != v {
-				return false
-			}
-		}
-	}
-	return true
-}
-
-// matchesQueryOptions checks if an event matches the query options by evaluating
-// all query criteria including type, level, time range, and tag queries
-func matchesQueryOptions(event *logging.Event, query logging.QueryOptions) bool {
-	// Check event type
-	if len(query.Types) > 0 {
-		typeMatch := false
-		for _, t := range query.Types {
-			if event.Type == t {
-				typeMatch = true
-				break
-			}
-		}
-		if !typeMatch {
-			return false
-		}
-	}
-
-	// Check level
-	if len(query.Levels) > 0 {
-		levelMatch := false
-		for _, l := range query.Levels {
-			if event.Level == l {
-				levelMatch = true
-				break
-			}
-		}
-		if !levelMatch {
-			return false
-		}
-	}
-
-	// Check time range
-	if query.TimeRange != nil {
-		if event.Timestamp.Before(query.TimeRange.Start) ||
-			event.Timestamp.After(query.TimeRange.End) {
-			return false
-		}
-	}
-
-	// Check sources
-	if len(query.Sources) > 0 {
-		sourceMatch := false
-		for _, s := range query.Sources {
-			if event.Source == s {
-				sourceMatch = true
-				break
-			}
-		}
-		if !sourceMatch {
-			return false
-		}
-	}
-
-	// Check tag query
-	if query.TagQuery != nil {
-		// Must match all required tags
-		for k, v := range query.TagQuery.Must {
-			if event.Tags[k] != v {
-				return false
-			}
-		}
-
-		// Must not match any excluded tags
-		for k, v := range query.TagQuery.MustNot {
-			if event.Tags[k] == v {
-				return false
-			}
-		}
-
-		// Should match at least one optional tag if specified
-		if len(query.TagQuery.Should) > 0 {
-			shouldMatch := false
-			for k, v := range query.TagQuery.Should {
-				if event.Tags[k] == v {
-					shouldMatch = true
-					break
-				}
-			}
-			if !shouldMatch {
-				return false
-			}
-		}
-	}
-
-	// Check context query
-	if query.ContextQuery != nil {
-		if len(query.ContextQuery.ComponentIDs) > 0 {
-			componentMatch := false
-			for _, id := range query.ContextQuery.ComponentIDs {
-				if event.Context.ComponentID == id {
-					componentMatch = true
-					break
-				}
-			}
-			if !componentMatch {
-				return false
-			}
-		}
-
-		if len(query.ContextQuery.DeviceIDs) > 0 {
-			deviceMatch := false
-			for _, id := range query.ContextQuery.DeviceIDs {
-				if event.Context.DeviceID == id {
-					deviceMatch = true
-					break
-				}
-			}
-			if !deviceMatch {
-				return false
-			}
-		}
-
-		if query.ContextQuery.MinStage > 0 && event.Context.Stage < query.ContextQuery.MinStage {
-			return false
-		}
-		if query.ContextQuery.MaxStage > 0 && event.Context.Stage > query.ContextQuery.MaxStage {
-			return false
-		}
-	}
-
-	return true
-}
-
-// sortEvents sorts events based on query options. It supports sorting by
-// timestamp, level, or type in either ascending or descending order.
-func sortEvents(events []*logging.Event, orderBy, direction string) {
-	if orderBy == "" {
-		orderBy = "timestamp"
-	}
-	if direction == "" {
-		direction = "desc"
-	}
-
-	sort.Slice(events, func(i, j int) bool {
-		var less bool
-		switch orderBy {
-		case "timestamp":
-			less = events[i].Timestamp.Before(events[j].Timestamp)
-		case "level":
-			less = string(events[i].Level) < string(events[j].Level)
-		case "type":
-			less = string(events[i].Type) < string(events[j].Type)
-		default:
-			less = events[i].Timestamp.Before(events[j].Timestamp)
-		}
-
-		if direction == "asc" {
-			return less
-		}
-		return !less
-	})
-}
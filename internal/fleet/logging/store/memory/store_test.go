@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging/storetest"
 )
 
 func createTestEvent(tenantID string, eventType logging.EventType, level logging.Level, message string) *logging.Event {
@@ -150,3 +151,9 @@ func TestStore_Sync(t *testing.T) {
 	// Sync should always succeed for memory store
 	assert.NoError(t, store.Sync(ctx))
 }
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) logging.Store {
+		return New()
+	})
+}
@@ -0,0 +1,33 @@
+// Package wal provides a durable, append-only implementation of the
+// logging.Store interface backed by segment files on local disk.
+//
+// Every mutation is appended to the active segment as a length-prefixed,
+// checksummed record before it is applied to the in-memory indexes. Deletes
+// are recorded as tombstones rather than rewriting history, which keeps the
+// log suitable for audit trails. Writes reach the operating system
+// immediately; Sync forces them to stable storage with fsync.
+//
+// Segments rotate once they reach a configurable size. Because the log is
+// never rewritten, space is reclaimed by dropping whole segments: once every
+// event in the oldest segment has been removed, by Delete or by the
+// DeleteBefore retention sweep, the file is deleted. Retention therefore
+// frees disk space fastest when events arrive in roughly time order, which
+// is the normal case for audit logs.
+//
+// On startup the store replays all segments in order to rebuild its
+// indexes. A record torn by a crash at the tail of the newest segment is
+// truncated away; corruption anywhere else is reported as an error.
+//
+// The in-memory indexes hold every live event, so the store is sized for
+// the audit and operational volumes of a single fleet controller rather
+// than bulk telemetry. Only one process may open a directory at a time.
+//
+// Example usage:
+//
+//	store, err := wal.New(filepath.Join(dataDir, "events"))
+//	if err != nil {
+//		return err
+//	}
+//	defer store.Close()
+//	service, err := logging.NewService(store, logger)
+package wal
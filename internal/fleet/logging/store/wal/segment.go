@@ -0,0 +1,167 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+)
+
+const (
+	// segmentExt is the file extension of segment files
+	segmentExt = ".wal"
+	// headerSize is the length prefix plus checksum preceding each record
+	headerSize = 8
+	// maxRecordSize bounds a single record so a corrupt length prefix
+	// cannot trigger a huge allocation during replay
+	maxRecordSize = 64 << 20
+)
+
+// Record operations
+const (
+	opPut          = "put"
+	opDelete       = "delete"
+	opDeleteBefore = "delete_before"
+)
+
+// crcTable is the Castagnoli polynomial table used for record checksums
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord marks a record that was only partially written
+var errTornRecord = errors.New("torn record")
+
+// record is a single entry in the log
+type record struct {
+	Op       string         `json:"op"`
+	Event    *logging.Event `json:"event,omitempty"`
+	TenantID string         `json:"tenant_id,omitempty"`
+	ID       string         `json:"id,omitempty"`
+	Before   *time.Time     `json:"before,omitempty"`
+}
+
+// segment tracks a single log file and how many live events it holds
+type segment struct {
+	seq  uint64
+	path string
+	live int
+}
+
+// segmentPath returns the file name for a segment sequence number. Names
+// are zero padded so lexical and numeric order agree.
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// listSegments returns the segments in dir ordered by sequence number
+func listSegments(dir string) ([]*segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []*segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, &segment{seq: seq, path: filepath.Join(dir, name)})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].seq < segments[j].seq
+	})
+	return segments, nil
+}
+
+// encodeRecords frames records for appending to a segment
+func encodeRecords(records []record) ([]byte, error) {
+	var buf []byte
+	for _, rec := range records {
+		payload, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) > maxRecordSize {
+			return nil, fmt.Errorf("record of %d bytes exceeds limit", len(payload))
+		}
+
+		var header [headerSize]byte
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+		buf = append(buf, header[:]...)
+		buf = append(buf, payload...)
+	}
+	return buf, nil
+}
+
+// readSegment replays every record in the file at path through fn. It
+// returns the offset just past the last intact record; when the file ends
+// in a torn or corrupt record the error wraps errTornRecord.
+func readSegment(path string, fn func(record) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		var header [headerSize]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("%w: short header at offset %d", errTornRecord, offset)
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return offset, fmt.Errorf("%w: invalid length %d at offset %d", errTornRecord, size, offset)
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, fmt.Errorf("%w: short payload at offset %d", errTornRecord, offset)
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return offset, fmt.Errorf("%w: checksum mismatch at offset %d", errTornRecord, offset)
+		}
+
+		var rec record
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return offset, fmt.Errorf("%w: undecodable record at offset %d", errTornRecord, offset)
+		}
+		if err := fn(rec); err != nil {
+			return offset, err
+		}
+		offset += int64(headerSize) + int64(size)
+	}
+}
+
+// syncDir fsyncs a directory so that file creations and removals are
+// durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
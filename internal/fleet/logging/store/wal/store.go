@@ -0,0 +1,532 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+)
+
+const (
+	// dirPermissions restricts the log directory to the service user
+	dirPermissions = 0750
+	// filePermissions restricts segment files to the service user
+	filePermissions = 0600
+	// DefaultMaxSegmentSize is the size at which segments rotate
+	DefaultMaxSegmentSize = 64 << 20
+)
+
+// Option configures a Store
+type Option func(*options) error
+
+type options struct {
+	maxSegmentSize int64
+}
+
+// WithMaxSegmentSize sets the size in bytes at which the active segment is
+// sealed and a new one started. Smaller segments let retention reclaim
+// space sooner at the cost of more files.
+func WithMaxSegmentSize(size int64) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return fmt.Errorf("max segment size must be positive")
+		}
+		o.maxSegmentSize = size
+		return nil
+	}
+}
+
+// entry is an indexed event together with the segment holding it
+type entry struct {
+	event *logging.Event
+	seg   *segment
+}
+
+// Store implements logging.Store on top of an append-only segment log.
+type Store struct {
+	mu             sync.RWMutex
+	dir            string
+	maxSegmentSize int64
+
+	segments   []*segment // ordered oldest first; the last is active
+	active     *os.File
+	activeSize int64
+	closed     bool
+
+	events map[string]map[string]*entry // tenant -> id -> entry
+}
+
+// Ensure Store implements logging.Store
+var _ logging.Store = (*Store)(nil)
+
+// New opens (or creates) an event log in dir, replaying existing segments
+// to rebuild the indexes.
+func New(dir string, opts ...Option) (*Store, error) {
+	if dir == "" {
+		return nil, logging.E("Store.New", logging.ErrCodeInvalidInput, "log directory is required", nil)
+	}
+
+	o := options{maxSegmentSize: DefaultMaxSegmentSize}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, logging.E("Store.New", logging.ErrCodeInvalidInput, "invalid option", err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, dirPermissions); err != nil {
+		return nil, logging.E("Store.New", logging.ErrCodeStoreFailure, "failed to create log directory", err)
+	}
+
+	s := &Store{
+		dir:            dir,
+		maxSegmentSize: o.maxSegmentSize,
+		events:         make(map[string]map[string]*entry),
+	}
+	if err := s.recover(); err != nil {
+		return nil, logging.E("Store.New", logging.ErrCodeStoreFailure, "failed to recover event log", err)
+	}
+	return s, nil
+}
+
+// recover replays every segment into the indexes and opens the newest
+// segment for appending.
+func (s *Store) recover() error {
+	segments, err := listSegments(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		offset, err := readSegment(seg.path, func(rec record) error {
+			s.apply(rec, seg)
+			return nil
+		})
+		if err != nil {
+			// Only the tail of the newest segment can legitimately be torn
+			// by a crash mid-append; discard it and carry on.
+			if !last || !errors.Is(err, errTornRecord) {
+				return fmt.Errorf("segment %s: %w", seg.path, err)
+			}
+			if err := os.Truncate(seg.path, offset); err != nil {
+				return fmt.Errorf("truncate torn segment %s: %w", seg.path, err)
+			}
+		}
+	}
+	s.segments = segments
+
+	if len(segments) == 0 {
+		return s.openSegment(1)
+	}
+
+	active := segments[len(segments)-1]
+	f, err := os.OpenFile(active.path, os.O_WRONLY|os.O_APPEND, filePermissions)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.active = f
+	s.activeSize = info.Size()
+
+	s.compact()
+	return nil
+}
+
+// openSegment creates a new active segment with the given sequence number.
+func (s *Store) openSegment(seq uint64) error {
+	path := segmentPath(s.dir, seq)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, filePermissions)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.segments = append(s.segments, &segment{seq: seq, path: path})
+	s.active = f
+	s.activeSize = 0
+	return nil
+}
+
+// rotate seals the active segment and starts the next one. The sealed
+// segment is fsynced so rotation never leaves unsynced data behind a newer
+// file.
+func (s *Store) rotate() error {
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	return s.openSegment(s.activeSegment().seq + 1)
+}
+
+// activeSegment returns the segment currently receiving appends
+func (s *Store) activeSegment() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// append writes records to the active segment, rotating first if they
+// would push it past the size limit. A failed write is truncated away so
+// the segment never holds a partial record.
+func (s *Store) append(records []record) error {
+	data, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+
+	if s.activeSize > 0 && s.activeSize+int64(len(data)) > s.maxSegmentSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotate segment: %w", err)
+		}
+	}
+
+	n, err := s.active.Write(data)
+	if err != nil {
+		if n > 0 {
+			_ = s.active.Truncate(s.activeSize)
+		}
+		return err
+	}
+	s.activeSize += int64(n)
+	return nil
+}
+
+// apply updates the indexes for a record stored in seg. It is shared by
+// live writes and startup replay so both produce identical state.
+func (s *Store) apply(rec record, seg *segment) {
+	switch rec.Op {
+	case opPut:
+		if rec.Event == nil {
+			return
+		}
+		tenant := s.events[rec.Event.TenantID]
+		if tenant == nil {
+			tenant = make(map[string]*entry)
+			s.events[rec.Event.TenantID] = tenant
+		}
+		if old, exists := tenant[rec.Event.ID]; exists {
+			old.seg.live--
+		}
+		tenant[rec.Event.ID] = &entry{event: rec.Event, seg: seg}
+		seg.live++
+
+	case opDelete:
+		if tenant, exists := s.events[rec.TenantID]; exists {
+			if e, exists := tenant[rec.ID]; exists {
+				e.seg.live--
+				delete(tenant, rec.ID)
+			}
+		}
+
+	case opDeleteBefore:
+		if rec.Before == nil {
+			return
+		}
+		for id, e := range s.events[rec.TenantID] {
+			if e.event.Timestamp.Before(*rec.Before) {
+				e.seg.live--
+				delete(s.events[rec.TenantID], id)
+			}
+		}
+	}
+}
+
+// compact drops sealed segments from the head of the log while they hold
+// no live events. Segments are only ever dropped oldest first: a tombstone
+// can only refer to events in its own or earlier segments, so removing a
+// prefix of the log can never resurrect a deleted event on replay.
+func (s *Store) compact() {
+	dropped := false
+	for len(s.segments) > 1 && s.segments[0].live <= 0 {
+		if err := os.Remove(s.segments[0].path); err != nil && !os.IsNotExist(err) {
+			break
+		}
+		s.segments = s.segments[1:]
+		dropped = true
+	}
+	if dropped {
+		_ = syncDir(s.dir)
+	}
+}
+
+// checkOpen returns an error if the store has been closed
+func (s *Store) checkOpen(op string) error {
+	if s.closed {
+		return logging.E(op, logging.ErrCodeInvalidOperation, "store is closed", nil)
+	}
+	return nil
+}
+
+// Store appends a new event to the log after validating its contents.
+func (s *Store) Store(ctx context.Context, event *logging.Event) error {
+	if err := ctx.Err(); err != nil {
+		return logging.E("Store.Store", logging.ErrCodeStoreFailure, "context cancelled", err)
+	}
+	if err := event.Validate(); err != nil {
+		return logging.E("Store.Store", logging.ErrCodeValidation, "invalid event", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkOpen("Store.Store"); err != nil {
+		return err
+	}
+
+	rec := record{Op: opPut, Event: copyEvent(event)}
+	if err := s.append([]record{rec}); err != nil {
+		return logging.E("Store.Store", logging.ErrCodeStoreFailure, "failed to append event", err)
+	}
+	s.apply(rec, s.activeSegment())
+	return nil
+}
+
+// Get retrieves an event by ID
+func (s *Store) Get(ctx context.Context, tenantID, eventID string) (*logging.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, logging.E("Store.Get", logging.ErrCodeStoreFailure, "context cancelled", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if tenant, exists := s.events[tenantID]; exists {
+		if e, exists := tenant[eventID]; exists {
+			return copyEvent(e.event), nil
+		}
+	}
+
+	return nil, logging.E("Store.Get", logging.ErrCodeNotFound, "event not found", logging.ErrEventNotFound)
+}
+
+// List retrieves events matching the given options. Results are sorted
+// by timestamp in descending order and paginated according to the options.
+func (s *Store) List(ctx context.Context, opts logging.ListOptions) ([]*logging.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, logging.E("Store.List", logging.ErrCodeStoreFailure, "context cancelled", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]*logging.Event, 0)
+	for _, e := range s.events[opts.TenantID] {
+		if opts.Matches(e.event) {
+			results = append(results, e.event)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Timestamp.Equal(results[j].Timestamp) {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].Timestamp.After(results[j].Timestamp)
+	})
+
+	return copyEvents(paginate(results, opts.Offset, opts.Limit)), nil
+}
+
+// Delete records a tombstone for an event, returning an error if it does
+// not exist.
+func (s *Store) Delete(ctx context.Context, tenantID, eventID string) error {
+	if err := ctx.Err(); err != nil {
+		return logging.E("Store.Delete", logging.ErrCodeStoreFailure, "context cancelled", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkOpen("Store.Delete"); err != nil {
+		return err
+	}
+
+	if _, exists := s.events[tenantID][eventID]; !exists {
+		return logging.E("Store.Delete", logging.ErrCodeNotFound, "event not found", logging.ErrEventNotFound)
+	}
+
+	rec := record{Op: opDelete, TenantID: tenantID, ID: eventID}
+	if err := s.append([]record{rec}); err != nil {
+		return logging.E("Store.Delete", logging.ErrCodeStoreFailure, "failed to append tombstone", err)
+	}
+	s.apply(rec, s.activeSegment())
+	s.compact()
+	return nil
+}
+
+// DeleteBefore removes all events for a tenant that are older than the
+// specified time, dropping any segments left without live events.
+func (s *Store) DeleteBefore(ctx context.Context, tenantID string, before time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return logging.E("Store.DeleteBefore", logging.ErrCodeStoreFailure, "context cancelled", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkOpen("Store.DeleteBefore"); err != nil {
+		return err
+	}
+
+	// Skip the tombstone entirely when nothing would be removed
+	matched := false
+	for _, e := range s.events[tenantID] {
+		if e.event.Timestamp.Before(before) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return nil
+	}
+
+	rec := record{Op: opDeleteBefore, TenantID: tenantID, Before: &before}
+	if err := s.append([]record{rec}); err != nil {
+		return logging.E("Store.DeleteBefore", logging.ErrCodeStoreFailure, "failed to append tombstone", err)
+	}
+	s.apply(rec, s.activeSegment())
+	s.compact()
+	return nil
+}
+
+// Query performs a structured query on events using the provided query options.
+func (s *Store) Query(ctx context.Context, query logging.QueryOptions) ([]*logging.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, logging.E("Store.Query", logging.ErrCodeStoreFailure, "context cancelled", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]*logging.Event, 0)
+	for _, e := range s.events[query.TenantID] {
+		if query.Matches(e.event) {
+			results = append(results, e.event)
+		}
+	}
+
+	logging.SortEvents(results, query.OrderBy, query.OrderDirection)
+
+	return copyEvents(paginate(results, query.Offset, query.Limit)), nil
+}
+
+// BatchStore appends multiple events as a single write. All events are
+// validated before any is stored.
+func (s *Store) BatchStore(ctx context.Context, events []*logging.Event) error {
+	if err := ctx.Err(); err != nil {
+		return logging.E("Store.BatchStore", logging.ErrCodeStoreFailure, "context cancelled", err)
+	}
+
+	records := make([]record, 0, len(events))
+	for _, event := range events {
+		if err := event.Validate(); err != nil {
+			return logging.E("Store.BatchStore", logging.ErrCodeValidation, "invalid event", err)
+		}
+		records = append(records, record{Op: opPut, Event: copyEvent(event)})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkOpen("Store.BatchStore"); err != nil {
+		return err
+	}
+
+	if err := s.append(records); err != nil {
+		return logging.E("Store.BatchStore", logging.ErrCodeStoreFailure, "failed to append events", err)
+	}
+	seg := s.activeSegment()
+	for _, rec := range records {
+		s.apply(rec, seg)
+	}
+	return nil
+}
+
+// Sync flushes the active segment to stable storage.
+func (s *Store) Sync(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return logging.E("Store.Sync", logging.ErrCodeStoreFailure, "context cancelled", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkOpen("Store.Sync"); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return logging.E("Store.Sync", logging.ErrCodeStoreFailure, "failed to sync segment", err)
+	}
+	return nil
+}
+
+// Close syncs and closes the active segment. The store cannot be used
+// afterwards.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	syncErr := s.active.Sync()
+	closeErr := s.active.Close()
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
+
+// paginate returns the requested window of events. A zero limit returns
+// everything after offset.
+func paginate(events []*logging.Event, offset, limit int) []*logging.Event {
+	if offset >= len(events) {
+		return []*logging.Event{}
+	}
+	end := offset + limit
+	if end > len(events) || limit == 0 {
+		end = len(events)
+	}
+	return events[offset:end]
+}
+
+// copyEvent returns a copy of an event that shares no mutable state with
+// the original, so callers cannot alter indexed events.
+func copyEvent(e *logging.Event) *logging.Event {
+	c := *e
+	if e.Tags != nil {
+		c.Tags = make(map[string]string, len(e.Tags))
+		for k, v := range e.Tags {
+			c.Tags[k] = v
+		}
+	}
+	if e.Metadata != nil {
+		c.Metadata = append([]byte(nil), e.Metadata...)
+	}
+	return &c
+}
+
+// copyEvents copies each event in a result slice
+func copyEvents(events []*logging.Event) []*logging.Event {
+	result := make([]*logging.Event, len(events))
+	for i, e := range events {
+		result[i] = copyEvent(e)
+	}
+	return result
+}
@@ -0,0 +1,281 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging/storetest"
+)
+
+// baseTime anchors fixture timestamps so ordering is deterministic
+var baseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestStore opens a fresh store in a temporary directory that is
+// closed when the test completes.
+func newTestStore(t *testing.T, dir string, opts ...Option) *Store {
+	t.Helper()
+	store, err := New(dir, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// newEvent builds a valid event with a deterministic ID and timestamp
+func newEvent(tenantID, id string, offset int) *logging.Event {
+	e := logging.New(tenantID, logging.EventAudit, logging.LevelInfo, "event "+id)
+	e.ID = id
+	e.Timestamp = baseTime.Add(time.Duration(offset) * time.Minute)
+	return e
+}
+
+// segmentFiles returns the names of segment files in dir
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	names := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		names = append(names, filepath.Base(seg.path))
+	}
+	return names
+}
+
+// TestConformance runs the shared logging.Store conformance suite
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) logging.Store {
+		return newTestStore(t, t.TempDir())
+	})
+}
+
+func TestNew_Validation(t *testing.T) {
+	_, err := New("")
+	require.Error(t, err)
+
+	_, err = New(t.TempDir(), WithMaxSegmentSize(0))
+	require.Error(t, err)
+}
+
+func TestStore_Persistence(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := New(dir)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.Store(ctx, newEvent("tenant-1", fmt.Sprintf("evt-%d", i), i)))
+	}
+	require.NoError(t, store.Delete(ctx, "tenant-1", "evt-2"))
+	require.NoError(t, store.BatchStore(ctx, []*logging.Event{
+		newEvent("tenant-2", "evt-a", 10),
+		newEvent("tenant-2", "evt-b", 11),
+	}))
+	require.NoError(t, store.Sync(ctx))
+	require.NoError(t, store.Close())
+
+	reopened := newTestStore(t, dir)
+
+	got, err := reopened.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-4", "evt-3", "evt-1", "evt-0"}, ids(got))
+
+	_, err = reopened.Get(ctx, "tenant-1", "evt-2")
+	assert.ErrorIs(t, err, logging.ErrEventNotFound)
+
+	got, err = reopened.List(ctx, logging.ListOptions{TenantID: "tenant-2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-b", "evt-a"}, ids(got))
+}
+
+func TestStore_Rotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// A tiny limit forces a new segment for nearly every append
+	store := newTestStore(t, dir, WithMaxSegmentSize(256))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, store.Store(ctx, newEvent("tenant-1", fmt.Sprintf("evt-%d", i), i)))
+	}
+	assert.Greater(t, len(segmentFiles(t, dir)), 1)
+	require.NoError(t, store.Close())
+
+	reopened := newTestStore(t, dir, WithMaxSegmentSize(256))
+	got, err := reopened.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Len(t, got, 10)
+}
+
+func TestStore_DeleteBeforeDropsSegments(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store := newTestStore(t, dir, WithMaxSegmentSize(256))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, store.Store(ctx, newEvent("tenant-1", fmt.Sprintf("evt-%d", i), i)))
+	}
+	before := segmentFiles(t, dir)
+	require.Greater(t, len(before), 2)
+
+	require.NoError(t, store.DeleteBefore(ctx, "tenant-1", baseTime.Add(8*time.Minute)))
+
+	after := segmentFiles(t, dir)
+	assert.Less(t, len(after), len(before))
+	// The oldest surviving segment is never older than the first one
+	assert.NotEqual(t, before[0], after[0])
+
+	got, err := store.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-9", "evt-8"}, ids(got))
+	require.NoError(t, store.Close())
+
+	// Replaying the remaining segments yields the same state
+	reopened := newTestStore(t, dir, WithMaxSegmentSize(256))
+	got, err = reopened.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-9", "evt-8"}, ids(got))
+}
+
+func TestStore_SegmentsWithLiveEventsAreKept(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store := newTestStore(t, dir, WithMaxSegmentSize(256))
+	// An old event from another tenant pins the first segment
+	require.NoError(t, store.Store(ctx, newEvent("tenant-2", "pinned", 0)))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.Store(ctx, newEvent("tenant-1", fmt.Sprintf("evt-%d", i), i)))
+	}
+	first := segmentFiles(t, dir)[0]
+
+	require.NoError(t, store.DeleteBefore(ctx, "tenant-1", baseTime.Add(time.Hour)))
+	assert.Equal(t, first, segmentFiles(t, dir)[0])
+	require.NoError(t, store.Close())
+
+	reopened := newTestStore(t, dir, WithMaxSegmentSize(256))
+	_, err := reopened.Get(ctx, "tenant-2", "pinned")
+	require.NoError(t, err)
+	got, err := reopened.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestStore_DeleteBeforeOnlyAffectsEarlierEvents(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store := newTestStore(t, dir)
+	require.NoError(t, store.Store(ctx, newEvent("tenant-1", "old", 0)))
+	require.NoError(t, store.DeleteBefore(ctx, "tenant-1", baseTime.Add(time.Hour)))
+
+	// A backdated event stored after the sweep must survive replay
+	require.NoError(t, store.Store(ctx, newEvent("tenant-1", "backdated", 1)))
+	require.NoError(t, store.Close())
+
+	reopened := newTestStore(t, dir)
+	_, err := reopened.Get(ctx, "tenant-1", "backdated")
+	require.NoError(t, err)
+	_, err = reopened.Get(ctx, "tenant-1", "old")
+	assert.ErrorIs(t, err, logging.ErrEventNotFound)
+}
+
+func TestStore_RecoversTornTail(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := New(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.Store(ctx, newEvent("tenant-1", "evt-1", 0)))
+	require.NoError(t, store.Store(ctx, newEvent("tenant-1", "evt-2", 1)))
+	require.NoError(t, store.Close())
+
+	// Simulate a crash part way through the final append
+	path := filepath.Join(dir, segmentFiles(t, dir)[0])
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-5))
+
+	reopened := newTestStore(t, dir)
+	got, err := reopened.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-1"}, ids(got))
+
+	// The log stays appendable after truncation
+	require.NoError(t, reopened.Store(ctx, newEvent("tenant-1", "evt-3", 2)))
+	require.NoError(t, reopened.Close())
+
+	again := newTestStore(t, dir)
+	got, err = again.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-3", "evt-1"}, ids(got))
+}
+
+func TestStore_RejectsCorruptSealedSegment(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := New(dir, WithMaxSegmentSize(256))
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.Store(ctx, newEvent("tenant-1", fmt.Sprintf("evt-%d", i), i)))
+	}
+	require.NoError(t, store.Close())
+
+	files := segmentFiles(t, dir)
+	require.Greater(t, len(files), 1)
+
+	// Flip a payload byte in the oldest sealed segment
+	path := filepath.Join(dir, files[0])
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[headerSize+2] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, filePermissions))
+
+	_, err = New(dir, WithMaxSegmentSize(256))
+	require.Error(t, err)
+}
+
+func TestStore_ReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir())
+
+	e := newEvent("tenant-1", "evt-1", 0).WithTag("env", "prod")
+	require.NoError(t, store.Store(ctx, e))
+
+	// Mutating the stored or returned event must not change indexed state
+	e.Tags["env"] = "changed"
+	got, err := store.Get(ctx, "tenant-1", "evt-1")
+	require.NoError(t, err)
+	assert.Equal(t, "prod", got.Tags["env"])
+
+	got.Tags["env"] = "changed"
+	again, err := store.Get(ctx, "tenant-1", "evt-1")
+	require.NoError(t, err)
+	assert.Equal(t, "prod", again.Tags["env"])
+}
+
+func TestStore_Closed(t *testing.T) {
+	ctx := context.Background()
+	store, err := New(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	require.NoError(t, store.Close())
+
+	err = store.Store(ctx, newEvent("tenant-1", "evt-1", 0))
+	require.Error(t, err)
+	assert.Error(t, store.Sync(ctx))
+}
+
+// ids extracts event IDs preserving order
+func ids(events []*logging.Event) []string {
+	result := make([]string, 0, len(events))
+	for _, e := range events {
+		result = append(result, e.ID)
+	}
+	return result
+}
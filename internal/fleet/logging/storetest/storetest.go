@@ -0,0 +1,401 @@
+// Package storetest provides a conformance suite for logging.Store
+// implementations.
+//
+// Every backend should run the suite from its own tests so that all stores
+// share a single definition of correct behavior:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) logging.Store {
+//			return memory.New()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+)
+
+// Factory creates an empty store for a single test. Implementations that
+// hold resources should release them through t.Cleanup.
+type Factory func(t *testing.T) logging.Store
+
+// Run executes the full conformance suite against stores created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("StoreAndGet", func(t *testing.T) { testStoreAndGet(t, factory(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, factory(t)) })
+	t.Run("DeleteBefore", func(t *testing.T) { testDeleteBefore(t, factory(t)) })
+	t.Run("ListFilters", func(t *testing.T) { testListFilters(t, factory(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, factory(t)) })
+	t.Run("Query", func(t *testing.T) { testQuery(t, factory(t)) })
+	t.Run("BatchStore", func(t *testing.T) { testBatchStore(t, factory(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, factory(t)) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory(t)) })
+}
+
+// baseTime anchors fixture timestamps so ordering is deterministic
+var baseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newEvent builds a valid event with a deterministic ID and timestamp
+func newEvent(tenantID, id string, offset int) *logging.Event {
+	e := logging.New(tenantID, logging.EventSystem, logging.LevelInfo, "event "+id)
+	e.ID = id
+	e.Timestamp = baseTime.Add(time.Duration(offset) * time.Minute)
+	return e
+}
+
+// requireNotFound asserts that err reports a missing event
+func requireNotFound(t *testing.T, err error) {
+	t.Helper()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, logging.ErrEventNotFound)
+	var domainErr *logging.DomainError
+	require.True(t, errors.As(err, &domainErr), "expected *logging.DomainError, got %T: %v", err, err)
+	assert.Equal(t, logging.ErrCodeNotFound, domainErr.Code)
+}
+
+func testStoreAndGet(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+
+	e := newEvent("tenant-1", "evt-1", 0)
+	e.Level = logging.LevelWarn
+	e.Context = logging.EventContext{ComponentID: "api", DeviceID: "dev-1"}
+	e.WithSource("host-1").WithTag("env", "prod")
+	require.NoError(t, e.WithMetadata(map[string]string{"key": "value"}))
+	require.NoError(t, store.Store(ctx, e))
+
+	got, err := store.Get(ctx, "tenant-1", "evt-1")
+	require.NoError(t, err)
+	assert.Equal(t, e.Message, got.Message)
+	assert.Equal(t, logging.LevelWarn, got.Level)
+	assert.Equal(t, logging.EventSystem, got.Type)
+	assert.True(t, e.Timestamp.Equal(got.Timestamp))
+	assert.Equal(t, "api", got.Context.ComponentID)
+	assert.Equal(t, "dev-1", got.Context.DeviceID)
+	assert.Equal(t, "host-1", got.Source)
+	assert.Equal(t, "prod", got.Tags["env"])
+	assert.JSONEq(t, `{"key":"value"}`, string(got.Metadata))
+
+	_, err = store.Get(ctx, "tenant-1", "missing")
+	requireNotFound(t, err)
+
+	invalid := newEvent("tenant-1", "evt-2", 0)
+	invalid.Message = ""
+	err = store.Store(ctx, invalid)
+	require.Error(t, err)
+	var domainErr *logging.DomainError
+	require.True(t, errors.As(err, &domainErr))
+	assert.Equal(t, logging.ErrCodeValidation, domainErr.Code)
+
+	require.NoError(t, store.Sync(ctx))
+}
+
+func testDelete(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.Store(ctx, newEvent("tenant-1", "evt-1", 0)))
+
+	require.NoError(t, store.Delete(ctx, "tenant-1", "evt-1"))
+
+	_, err := store.Get(ctx, "tenant-1", "evt-1")
+	requireNotFound(t, err)
+	requireNotFound(t, store.Delete(ctx, "tenant-1", "evt-1"))
+}
+
+func testDeleteBefore(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.Store(ctx, newEvent("tenant-1", fmt.Sprintf("evt-%d", i), i)))
+	}
+	require.NoError(t, store.Store(ctx, newEvent("tenant-2", "evt-0", 0)))
+
+	require.NoError(t, store.DeleteBefore(ctx, "tenant-1", baseTime.Add(3*time.Minute)))
+
+	got, err := store.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-4", "evt-3"}, eventIDs(got))
+
+	// Other tenants are unaffected
+	_, err = store.Get(ctx, "tenant-2", "evt-0")
+	require.NoError(t, err)
+
+	// Unknown tenants are a no-op
+	require.NoError(t, store.DeleteBefore(ctx, "tenant-3", baseTime))
+}
+
+func testListFilters(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+
+	e1 := newEvent("tenant-1", "evt-1", 1)
+	e1.Context.DeviceID = "dev-1"
+	e1.WithTag("env", "prod")
+	e2 := newEvent("tenant-1", "evt-2", 2)
+	e2.Type = logging.EventSecurity
+	e2.Level = logging.LevelError
+	e2.WithSource("host-2")
+	e3 := newEvent("tenant-1", "evt-3", 3)
+	e3.Context.ComponentID = "api"
+	for _, e := range []*logging.Event{e1, e2, e3} {
+		require.NoError(t, store.Store(ctx, e))
+	}
+
+	start := baseTime.Add(2 * time.Minute)
+	tests := []struct {
+		name    string
+		opts    logging.ListOptions
+		wantIDs []string
+	}{
+		{"all newest first", logging.ListOptions{TenantID: "tenant-1"}, []string{"evt-3", "evt-2", "evt-1"}},
+		{"type", logging.ListOptions{TenantID: "tenant-1", Type: logging.EventSecurity}, []string{"evt-2"}},
+		{"level", logging.ListOptions{TenantID: "tenant-1", Level: logging.LevelError}, []string{"evt-2"}},
+		{"source", logging.ListOptions{TenantID: "tenant-1", Source: "host-2"}, []string{"evt-2"}},
+		{"device", logging.ListOptions{TenantID: "tenant-1", DeviceID: "dev-1"}, []string{"evt-1"}},
+		{"component", logging.ListOptions{TenantID: "tenant-1", ComponentID: "api"}, []string{"evt-3"}},
+		{"tags", logging.ListOptions{TenantID: "tenant-1", Tags: map[string]string{"env": "prod"}}, []string{"evt-1"}},
+		{"start time", logging.ListOptions{TenantID: "tenant-1", StartTime: &start}, []string{"evt-3", "evt-2"}},
+		{"end time", logging.ListOptions{TenantID: "tenant-1", EndTime: &start}, []string{"evt-2", "evt-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.List(ctx, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, eventIDs(got))
+		})
+	}
+}
+
+func testPagination(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+
+	// Insert out of order to verify results are sorted newest first
+	for _, i := range []int{3, 1, 5, 2, 4} {
+		require.NoError(t, store.Store(ctx, newEvent("tenant-1", fmt.Sprintf("evt-%d", i), i)))
+	}
+
+	tests := []struct {
+		name    string
+		offset  int
+		limit   int
+		wantIDs []string
+	}{
+		{"no limit", 0, 0, []string{"evt-5", "evt-4", "evt-3", "evt-2", "evt-1"}},
+		{"first page", 0, 2, []string{"evt-5", "evt-4"}},
+		{"second page", 2, 2, []string{"evt-3", "evt-2"}},
+		{"last partial page", 4, 2, []string{"evt-1"}},
+		{"offset without limit", 3, 0, []string{"evt-2", "evt-1"}},
+		{"offset past end", 5, 2, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.List(ctx, logging.ListOptions{
+				TenantID: "tenant-1",
+				Offset:   tt.offset,
+				Limit:    tt.limit,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, eventIDs(got))
+
+			queried, err := store.Query(ctx, logging.QueryOptions{
+				TenantID: "tenant-1",
+				Offset:   tt.offset,
+				Limit:    tt.limit,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, eventIDs(queried))
+		})
+	}
+}
+
+func testQuery(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+
+	e1 := newEvent("tenant-1", "evt-1", 1)
+	e1.WithTag("env", "prod").WithTag("role", "edge")
+	e1.Context = logging.EventContext{DeviceID: "dev-1", Stage: 1}
+	e2 := newEvent("tenant-1", "evt-2", 2)
+	e2.Type = logging.EventAudit
+	e2.Level = logging.LevelWarn
+	e2.WithTag("env", "prod")
+	e2.Context = logging.EventContext{DeviceID: "dev-2", Stage: 3}
+	e3 := newEvent("tenant-1", "evt-3", 3)
+	e3.WithTag("env", "dev").WithSource("host-3")
+	for _, e := range []*logging.Event{e1, e2, e3} {
+		require.NoError(t, store.Store(ctx, e))
+	}
+
+	tests := []struct {
+		name    string
+		query   logging.QueryOptions
+		wantIDs []string
+	}{
+		{
+			name:    "types",
+			query:   logging.QueryOptions{Types: []logging.EventType{logging.EventAudit}},
+			wantIDs: []string{"evt-2"},
+		},
+		{
+			name:    "levels",
+			query:   logging.QueryOptions{Levels: []logging.Level{logging.LevelInfo}},
+			wantIDs: []string{"evt-3", "evt-1"},
+		},
+		{
+			name:    "sources",
+			query:   logging.QueryOptions{Sources: []string{"host-3"}},
+			wantIDs: []string{"evt-3"},
+		},
+		{
+			name: "time range",
+			query: logging.QueryOptions{TimeRange: &logging.TimeRange{
+				Start: baseTime.Add(2 * time.Minute),
+				End:   baseTime.Add(3 * time.Minute),
+			}},
+			wantIDs: []string{"evt-3", "evt-2"},
+		},
+		{
+			name: "tag must and must not",
+			query: logging.QueryOptions{TagQuery: &logging.TagQuery{
+				Must:    map[string]string{"env": "prod"},
+				MustNot: map[string]string{"role": "edge"},
+			}},
+			wantIDs: []string{"evt-2"},
+		},
+		{
+			name: "context devices and stage",
+			query: logging.QueryOptions{ContextQuery: &logging.ContextQuery{
+				DeviceIDs: []string{"dev-1", "dev-2"},
+				MinStage:  2,
+			}},
+			wantIDs: []string{"evt-2"},
+		},
+		{
+			name:    "ascending order",
+			query:   logging.QueryOptions{OrderBy: "timestamp", OrderDirection: "asc"},
+			wantIDs: []string{"evt-1", "evt-2", "evt-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.TenantID = "tenant-1"
+			got, err := store.Query(ctx, tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, eventIDs(got))
+		})
+	}
+}
+
+func testBatchStore(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+
+	events := []*logging.Event{
+		newEvent("tenant-1", "evt-1", 1),
+		newEvent("tenant-1", "evt-2", 2),
+		newEvent("tenant-2", "evt-3", 3),
+	}
+	require.NoError(t, store.BatchStore(ctx, events))
+
+	got, err := store.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-2", "evt-1"}, eventIDs(got))
+
+	invalid := newEvent("tenant-1", "evt-4", 4)
+	invalid.TenantID = ""
+	require.Error(t, store.BatchStore(ctx, []*logging.Event{invalid}))
+}
+
+func testTenantIsolation(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+
+	e1 := newEvent("tenant-1", "shared", 0)
+	e1.Message = "tenant one"
+	e2 := newEvent("tenant-2", "shared", 0)
+	e2.Message = "tenant two"
+	require.NoError(t, store.Store(ctx, e1))
+	require.NoError(t, store.Store(ctx, e2))
+	require.NoError(t, store.Store(ctx, newEvent("tenant-1", "only-1", 1)))
+
+	got, err := store.Get(ctx, "tenant-2", "shared")
+	require.NoError(t, err)
+	assert.Equal(t, "tenant two", got.Message)
+
+	_, err = store.Get(ctx, "tenant-2", "only-1")
+	requireNotFound(t, err)
+	requireNotFound(t, store.Delete(ctx, "tenant-2", "only-1"))
+
+	require.NoError(t, store.Delete(ctx, "tenant-1", "shared"))
+	_, err = store.Get(ctx, "tenant-2", "shared")
+	require.NoError(t, err)
+
+	list, err := store.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"only-1"}, eventIDs(list))
+
+	queried, err := store.Query(ctx, logging.QueryOptions{TenantID: "tenant-3"})
+	require.NoError(t, err)
+	assert.Empty(t, queried)
+}
+
+func testConcurrency(t *testing.T, store logging.Store) {
+	ctx := context.Background()
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*3)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if err := store.Store(ctx, newEvent("tenant-1", fmt.Sprintf("evt-%02d", i), i)); err != nil {
+				errs <- fmt.Errorf("store: %w", err)
+				return
+			}
+			if _, err := store.List(ctx, logging.ListOptions{TenantID: "tenant-1"}); err != nil {
+				errs <- fmt.Errorf("list: %w", err)
+			}
+			if err := store.Sync(ctx); err != nil {
+				errs <- fmt.Errorf("sync: %w", err)
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for concurrent operations")
+	}
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	list, err := store.List(ctx, logging.ListOptions{TenantID: "tenant-1"})
+	require.NoError(t, err)
+	assert.Len(t, list, workers)
+}
+
+// eventIDs extracts event IDs preserving order
+func eventIDs(events []*logging.Event) []string {
+	result := make([]string, 0, len(events))
+	for _, e := range events {
+		result = append(result, e.ID)
+	}
+	return result
+}
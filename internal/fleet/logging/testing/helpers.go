@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/wrale/wrale-fleet/internal/fleet/logging"
+	"github.com/wrale/wrale-fleet/internal/fleet/logging/store/factory"
 	"go.uber.org/zap/zaptest"
 )
 
@@ -25,7 +26,7 @@ func NewTestService(t *testing.T) *logging.Service {
 // NewTestStore creates a new memory store for testing.
 // This is the recommended way to create a store for testing purposes.
 func NewTestStore() logging.Store {
-	return logging.NewMemoryStore()
+	return factory.NewMemoryStore()
 }
 
 // CreateTestEvent creates a new event for testing with the given parameters.
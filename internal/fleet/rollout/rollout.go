@@ -0,0 +1,312 @@
+// Package rollout deploys configuration versions to device groups in
+// waves. A rollout starts with a canary wave, continues in batches with a
+// pause between them and halts on its own when too many devices in a wave
+// fail to apply the configuration.
+package rollout
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+)
+
+// Status represents the state of a rollout
+type Status string
+
+const (
+	// StatusRunning rollouts start waves as they become due
+	StatusRunning Status = "running"
+	// StatusPaused rollouts start no new waves until resumed
+	StatusPaused Status = "paused"
+	// StatusHalted rollouts stopped because a wave exceeded the failure
+	// threshold; they can be resumed or aborted
+	StatusHalted Status = "halted"
+	// StatusAborted rollouts were stopped for good by an operator
+	StatusAborted Status = "aborted"
+	// StatusCompleted rollouts finished all their waves
+	StatusCompleted Status = "completed"
+)
+
+// Terminal reports whether a rollout in this status can no longer change
+func (s Status) Terminal() bool {
+	return s == StatusAborted || s == StatusCompleted
+}
+
+// WaveStatus represents the state of a single wave
+type WaveStatus string
+
+const (
+	// WaveStatusPending waves have not been deployed yet
+	WaveStatusPending WaveStatus = "pending"
+	// WaveStatusRunning waves wait for their devices to acknowledge
+	WaveStatusRunning WaveStatus = "running"
+	// WaveStatusSucceeded waves stayed within the failure threshold
+	WaveStatusSucceeded WaveStatus = "succeeded"
+	// WaveStatusFailed waves exceeded the failure threshold
+	WaveStatusFailed WaveStatus = "failed"
+)
+
+// Finished reports whether every device of a wave in this status has
+// reported an outcome
+func (s WaveStatus) Finished() bool {
+	return s == WaveStatusSucceeded || s == WaveStatusFailed
+}
+
+// Defaults applied to strategies that leave a setting unset
+const (
+	// DefaultWaveTimeout bounds how long a wave waits for acknowledgements
+	DefaultWaveTimeout = 30 * time.Minute
+)
+
+// Strategy controls how a rollout is split into waves and when it halts
+type Strategy struct {
+	// CanaryPercent is the share of the group, from 0 to 100, deployed to
+	// in the first wave. Any non-zero share selects at least one device.
+	// Zero skips the canary wave.
+	CanaryPercent int `json:"canary_percent"`
+
+	// BatchSize is the number of devices in each wave after the canary.
+	// Zero deploys to all remaining devices in a single wave.
+	BatchSize int `json:"batch_size"`
+
+	// Pause is the time to wait after a wave finishes before the next
+	// wave starts
+	Pause time.Duration `json:"pause"`
+
+	// FailureThreshold is the highest percentage of failed devices a wave
+	// may have without halting the rollout. Zero halts on any failure.
+	FailureThreshold int `json:"failure_threshold"`
+
+	// WaveTimeout is how long a wave waits for its devices to acknowledge
+	// the deployment. Devices that have not acknowledged by then count as
+	// failed. Zero selects DefaultWaveTimeout.
+	WaveTimeout time.Duration `json:"wave_timeout"`
+}
+
+// Validate checks the strategy settings are within range
+func (s Strategy) Validate() error {
+	const op = "Strategy.Validate"
+
+	switch {
+	case s.CanaryPercent < 0 || s.CanaryPercent > 100:
+		return E(op, ErrCodeInvalidRollout, "canary percent must be between 0 and 100", nil)
+	case s.BatchSize < 0:
+		return E(op, ErrCodeInvalidRollout, "batch size cannot be negative", nil)
+	case s.Pause < 0:
+		return E(op, ErrCodeInvalidRollout, "pause cannot be negative", nil)
+	case s.FailureThreshold < 0 || s.FailureThreshold > 100:
+		return E(op, ErrCodeInvalidRollout, "failure threshold must be between 0 and 100", nil)
+	case s.WaveTimeout < 0:
+		return E(op, ErrCodeInvalidRollout, "wave timeout cannot be negative", nil)
+	}
+	return nil
+}
+
+// Target is a device in a wave and the deployment created for it
+type Target struct {
+	DeviceID     string `json:"device_id"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	Status       string `json:"status"` // Deployment status, pending until acknowledged
+	Error        string `json:"error,omitempty"`
+	SupersededBy string `json:"superseded_by,omitempty"` // Deployment that replaced DeploymentID
+}
+
+// TargetStatusSuperseded marks a target whose deployment was replaced by a
+// newer deployment to the same device, such as a manual apply or drift
+// remediation. Superseded targets count neither as succeeded nor as failed.
+const TargetStatusSuperseded = config.DeploymentStatusSuperseded
+
+// Wave is a set of devices deployed to together
+type Wave struct {
+	Number      int        `json:"number"`
+	Canary      bool       `json:"canary,omitempty"`
+	Status      WaveStatus `json:"status"`
+	Targets     []Target   `json:"targets"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Progress summarizes the outcome of a rollout across all waves
+type Progress struct {
+	Devices    int `json:"devices"`
+	Succeeded  int `json:"succeeded"`
+	Failed     int `json:"failed"`
+	Superseded int `json:"superseded"`
+	Pending    int `json:"pending"`
+}
+
+// Rollout deploys a configuration version to the devices of a group
+type Rollout struct {
+	ID          string     `json:"id"`
+	TenantID    string     `json:"tenant_id"`
+	GroupID     string     `json:"group_id"`
+	TemplateID  string     `json:"template_id"`
+	Version     int        `json:"version"`
+	Strategy    Strategy   `json:"strategy"`
+	Status      Status     `json:"status"`
+	Reason      string     `json:"reason,omitempty"` // Why the rollout halted or was aborted
+	Waves       []Wave     `json:"waves"`
+	CurrentWave int        `json:"current_wave"` // Index of the wave in progress or due next
+	Progress    Progress   `json:"progress"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// New creates a running rollout of a version to the given devices, split
+// into waves by the strategy. Devices are deployed to in the order given.
+func New(tenantID, groupID, templateID string, version int, strategy Strategy, deviceIDs []string) (*Rollout, error) {
+	const op = "rollout.New"
+
+	if err := strategy.Validate(); err != nil {
+		return nil, err
+	}
+	if len(deviceIDs) == 0 {
+		return nil, E(op, ErrCodeInvalidRollout, "group has no devices to roll out to", nil)
+	}
+	if strategy.WaveTimeout == 0 {
+		strategy.WaveTimeout = DefaultWaveTimeout
+	}
+
+	now := time.Now().UTC()
+	r := &Rollout{
+		ID:         uuid.New().String(),
+		TenantID:   tenantID,
+		GroupID:    groupID,
+		TemplateID: templateID,
+		Version:    version,
+		Strategy:   strategy,
+		Status:     StatusRunning,
+		Waves:      plan(deviceIDs, strategy),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	r.updateProgress()
+	return r, nil
+}
+
+// plan splits devices into a canary wave and batches
+func plan(deviceIDs []string, strategy Strategy) []Wave {
+	var waves []Wave
+	add := func(ids []string, canary bool) {
+		targets := make([]Target, len(ids))
+		for i, id := range ids {
+			targets[i] = Target{DeviceID: id, Status: config.DeploymentStatusPending}
+		}
+		waves = append(waves, Wave{
+			Number:  len(waves) + 1,
+			Canary:  canary,
+			Status:  WaveStatusPending,
+			Targets: targets,
+		})
+	}
+
+	remaining := deviceIDs
+	if strategy.CanaryPercent > 0 {
+		n := (len(deviceIDs)*strategy.CanaryPercent + 99) / 100
+		add(remaining[:n], true)
+		remaining = remaining[n:]
+	}
+	for len(remaining) > 0 {
+		n := strategy.BatchSize
+		if n == 0 || n > len(remaining) {
+			n = len(remaining)
+		}
+		add(remaining[:n], false)
+		remaining = remaining[n:]
+	}
+	return waves
+}
+
+// Validate checks if the rollout data is valid
+func (r *Rollout) Validate() error {
+	const op = "Rollout.Validate"
+
+	switch {
+	case r.ID == "":
+		return E(op, ErrCodeInvalidRollout, "rollout id cannot be empty", nil)
+	case r.TenantID == "":
+		return E(op, ErrCodeInvalidRollout, "tenant id cannot be empty", nil)
+	case r.GroupID == "":
+		return E(op, ErrCodeInvalidRollout, "group id cannot be empty", nil)
+	case r.TemplateID == "":
+		return E(op, ErrCodeInvalidRollout, "template id cannot be empty", nil)
+	case r.Version < 1:
+		return E(op, ErrCodeInvalidRollout, "version must be positive", nil)
+	case len(r.Waves) == 0:
+		return E(op, ErrCodeInvalidRollout, "rollout has no waves", nil)
+	case r.CurrentWave < 0 || r.CurrentWave >= len(r.Waves):
+		return E(op, ErrCodeInvalidRollout, fmt.Sprintf("current wave %d is out of range", r.CurrentWave), nil)
+	}
+	return r.Strategy.Validate()
+}
+
+// Clone returns a deep copy of the rollout
+func (r *Rollout) Clone() *Rollout {
+	if r == nil {
+		return nil
+	}
+	out := *r
+	out.Waves = make([]Wave, len(r.Waves))
+	for i, w := range r.Waves {
+		w.Targets = append([]Target(nil), w.Targets...)
+		w.StartedAt = cloneTime(w.StartedAt)
+		w.CompletedAt = cloneTime(w.CompletedAt)
+		out.Waves[i] = w
+	}
+	out.CompletedAt = cloneTime(r.CompletedAt)
+	return &out
+}
+
+// updateProgress recomputes the progress summary from the wave targets
+func (r *Rollout) updateProgress() {
+	var p Progress
+	for _, w := range r.Waves {
+		for _, t := range w.Targets {
+			p.Devices++
+			switch t.Status {
+			case config.DeploymentStatusCompleted:
+				p.Succeeded++
+			case config.DeploymentStatusFailed:
+				p.Failed++
+			case TargetStatusSuperseded:
+				p.Superseded++
+			default:
+				p.Pending++
+			}
+		}
+	}
+	r.Progress = p
+}
+
+// counts returns the number of succeeded, failed, superseded and pending
+// targets
+func (w *Wave) counts() (succeeded, failed, superseded, pending int) {
+	for _, t := range w.Targets {
+		switch t.Status {
+		case config.DeploymentStatusCompleted:
+			succeeded++
+		case config.DeploymentStatusFailed:
+			failed++
+		case TargetStatusSuperseded:
+			superseded++
+		default:
+			pending++
+		}
+	}
+	return succeeded, failed, superseded, pending
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := *t
+	return &v
+}
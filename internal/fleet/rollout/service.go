@@ -0,0 +1,540 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"go.uber.org/zap"
+)
+
+// DefaultInterval is how often running rollouts are advanced
+const DefaultInterval = 10 * time.Second
+
+// Deployer creates configuration deployments and reports their outcome.
+// It is implemented by config.Service.
+type Deployer interface {
+	GetVersion(ctx context.Context, tenantID, templateID string, versionNumber int) (*config.Version, error)
+	DeployConfiguration(ctx context.Context, tenantID, templateID string, version *config.Version, deviceID string) (*config.Deployment, error)
+	GetDeployment(ctx context.Context, tenantID, deploymentID string) (*config.Deployment, error)
+	FailDeployment(ctx context.Context, tenantID, deploymentID, errorMsg string) error
+}
+
+// Members lists the devices of a group. It is implemented by group.Service.
+type Members interface {
+	ListDevices(ctx context.Context, tenantID, groupID string) ([]*device.Device, error)
+}
+
+// Service runs configuration rollouts. Waves are started and evaluated by
+// Advance, which Run calls periodically.
+type Service struct {
+	store    Store
+	deployer Deployer
+	members  Members
+	logger   *zap.Logger
+	interval time.Duration
+
+	// mu serializes changes to rollouts between Advance and operator
+	// actions
+	mu sync.Mutex
+}
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithInterval sets how often Run advances running rollouts
+func WithInterval(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
+
+// NewService creates a new rollout service
+func NewService(store Store, deployer Deployer, members Members, logger *zap.Logger, opts ...Option) *Service {
+	s := &Service{
+		store:    store,
+		deployer: deployer,
+		members:  members,
+		logger:   logger,
+		interval: DefaultInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Start creates a rollout of a validated configuration version to the
+// current members of a group and deploys its first wave. Members are
+// ordered by device ID, so the canary wave is predictable. A group or
+// device can only be in one unfinished rollout at a time, because the
+// deployments of overlapping rollouts would supersede each other.
+func (s *Service) Start(ctx context.Context, tenantID, groupID, templateID string, versionNumber int, strategy Strategy, createdBy string) (*Rollout, error) {
+	const op = "Service.Start"
+
+	version, err := s.deployer.GetVersion(ctx, tenantID, templateID, versionNumber)
+	if err != nil {
+		return nil, err
+	}
+	if version.Status != config.ValidationStatusValid {
+		return nil, E(op, ErrCodeInvalidRollout,
+			fmt.Sprintf("version %d is %s; only valid versions can be rolled out", versionNumber, version.Status), nil)
+	}
+
+	devices, err := s.members.ListDevices(ctx, tenantID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(devices))
+	for i, d := range devices {
+		ids[i] = d.ID
+	}
+	sort.Strings(ids)
+
+	r, err := New(tenantID, groupID, templateID, versionNumber, strategy, ids)
+	if err != nil {
+		return nil, err
+	}
+	r.CreatedBy = createdBy
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkOverlap(ctx, r); err != nil {
+		return nil, err
+	}
+	if err := s.store.Create(ctx, r); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("started configuration rollout",
+		zap.String("rollout_id", r.ID),
+		zap.String("group_id", groupID),
+		zap.String("template_id", templateID),
+		zap.String("tenant_id", tenantID),
+		zap.Int("version", versionNumber),
+		zap.Int("devices", len(ids)),
+		zap.Int("waves", len(r.Waves)),
+	)
+
+	if err := s.advance(ctx, r, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// checkOverlap rejects a new rollout whose group or devices are already in
+// a rollout that is running, paused or halted. The caller must hold s.mu.
+func (s *Service) checkOverlap(ctx context.Context, r *Rollout) error {
+	const op = "Service.Start"
+
+	rollouts, err := s.store.List(ctx, ListOptions{TenantID: r.TenantID})
+	if err != nil {
+		return err
+	}
+
+	devices := make(map[string]bool, r.Progress.Devices)
+	for _, w := range r.Waves {
+		for _, t := range w.Targets {
+			devices[t.DeviceID] = true
+		}
+	}
+
+	for _, other := range rollouts {
+		if other.Status.Terminal() {
+			continue
+		}
+		if other.GroupID == r.GroupID {
+			return E(op, ErrCodeInvalidOperation,
+				fmt.Sprintf("group is already in %s rollout %s; abort it first", other.Status, other.ID), nil).
+				WithField("rollout_id", other.ID)
+		}
+		for _, w := range other.Waves {
+			for _, t := range w.Targets {
+				if devices[t.DeviceID] {
+					return E(op, ErrCodeInvalidOperation,
+						fmt.Sprintf("device %s is already in %s rollout %s; abort it first", t.DeviceID, other.Status, other.ID), nil).
+						WithField("rollout_id", other.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Get returns a rollout with its progress
+func (s *Service) Get(ctx context.Context, tenantID, id string) (*Rollout, error) {
+	return s.store.Get(ctx, tenantID, id)
+}
+
+// List returns the rollouts of a tenant, oldest first
+func (s *Service) List(ctx context.Context, tenantID string, opts ListOptions) ([]*Rollout, error) {
+	opts.TenantID = tenantID
+	return s.store.List(ctx, opts)
+}
+
+// Pause stops a running rollout from starting new waves. Deployments of
+// the wave in progress continue and are evaluated once resumed.
+func (s *Service) Pause(ctx context.Context, tenantID, id string) (*Rollout, error) {
+	return s.transition(ctx, tenantID, id, func(r *Rollout) error {
+		if r.Status != StatusRunning {
+			return invalidTransition("Service.Pause", r, "only running rollouts can be paused")
+		}
+		r.Status = StatusPaused
+		return nil
+	})
+}
+
+// Resume continues a paused or halted rollout. A halted rollout moves past
+// the wave that exceeded the failure threshold.
+func (s *Service) Resume(ctx context.Context, tenantID, id string) (*Rollout, error) {
+	return s.transition(ctx, tenantID, id, func(r *Rollout) error {
+		if r.Status != StatusPaused && r.Status != StatusHalted {
+			return invalidTransition("Service.Resume", r, "only paused or halted rollouts can be resumed")
+		}
+		r.Status = StatusRunning
+		r.Reason = ""
+		return nil
+	})
+}
+
+// Abort stops a rollout for good. Deployments of the wave in progress that
+// devices have not acknowledged yet are failed so they are not applied.
+func (s *Service) Abort(ctx context.Context, tenantID, id, reason string) (*Rollout, error) {
+	if reason == "" {
+		reason = "aborted by operator"
+	}
+	return s.transition(ctx, tenantID, id, func(r *Rollout) error {
+		if r.Status.Terminal() {
+			return invalidTransition("Service.Abort", r, "rollout has already "+string(r.Status))
+		}
+		wave := &r.Waves[r.CurrentWave]
+		if wave.Status == WaveStatusRunning {
+			if err := s.refreshWave(ctx, r, wave); err != nil {
+				return err
+			}
+			s.failPending(ctx, r, wave, "rollout aborted: "+reason)
+		}
+		now := time.Now().UTC()
+		r.Status = StatusAborted
+		r.Reason = reason
+		r.CompletedAt = &now
+		return nil
+	})
+}
+
+// transition applies an operator action to a rollout and, for running
+// rollouts, immediately advances it
+func (s *Service) transition(ctx context.Context, tenantID, id string, change func(*Rollout) error) (*Rollout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.store.Get(ctx, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	from := r.Status
+	if err := change(r); err != nil {
+		return nil, err
+	}
+	r.UpdatedAt = time.Now().UTC()
+	r.updateProgress()
+
+	if err := s.store.Update(ctx, r); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("changed configuration rollout status",
+		zap.String("rollout_id", r.ID),
+		zap.String("tenant_id", tenantID),
+		zap.String("from", string(from)),
+		zap.String("to", string(r.Status)),
+	)
+
+	if err := s.advance(ctx, r, r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// invalidTransition reports an operator action the rollout's status does
+// not allow
+func invalidTransition(op string, r *Rollout, message string) error {
+	return E(op, ErrCodeInvalidOperation, message, nil).WithField("status", r.Status)
+}
+
+// Run advances running rollouts until the context is canceled
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.Advance(ctx, now.UTC()); err != nil {
+				s.logger.Error("rollout advance failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Advance evaluates every running rollout of every tenant as of now:
+// finished waves are scored, due waves are started and rollouts whose last
+// wave finished are completed. A failure on one rollout does not stop the
+// others; the first error is returned.
+func (s *Service) Advance(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rollouts, err := s.store.List(ctx, ListOptions{Status: StatusRunning})
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, r := range rollouts {
+		if err := s.advance(ctx, r, now); err != nil {
+			s.logger.Error("failed to advance configuration rollout",
+				zap.String("rollout_id", r.ID),
+				zap.String("tenant_id", r.TenantID),
+				zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// advance moves a running rollout forward as far as it can go now and
+// stores the result. The caller must hold s.mu.
+func (s *Service) advance(ctx context.Context, r *Rollout, now time.Time) error {
+	if r.Status != StatusRunning {
+		return nil
+	}
+	before := r.Clone()
+
+	for r.Status == StatusRunning {
+		wave := &r.Waves[r.CurrentWave]
+		progressed, err := s.step(ctx, r, wave, now)
+		if err != nil {
+			return err
+		}
+		if !progressed {
+			break
+		}
+	}
+
+	r.updateProgress()
+	if sameState(before, r) {
+		return nil
+	}
+	r.UpdatedAt = now
+	return s.store.Update(ctx, r)
+}
+
+// step performs the next action on the current wave and reports whether
+// the rollout moved on, so the caller should look at it again
+func (s *Service) step(ctx context.Context, r *Rollout, wave *Wave, now time.Time) (bool, error) {
+	switch wave.Status {
+	case WaveStatusPending:
+		if r.CurrentWave > 0 {
+			prev := r.Waves[r.CurrentWave-1]
+			if prev.CompletedAt != nil && now.Before(prev.CompletedAt.Add(r.Strategy.Pause)) {
+				return false, nil
+			}
+		}
+		return true, s.startWave(ctx, r, wave, now)
+
+	case WaveStatusRunning:
+		if err := s.refreshWave(ctx, r, wave); err != nil {
+			return false, err
+		}
+		_, _, _, pending := wave.counts()
+		if pending > 0 {
+			if wave.StartedAt != nil && now.Before(wave.StartedAt.Add(r.Strategy.WaveTimeout)) {
+				return false, nil
+			}
+			s.failPending(ctx, r, wave, fmt.Sprintf("not acknowledged within %s", r.Strategy.WaveTimeout))
+		}
+		s.finishWave(r, wave, now)
+		return true, nil
+
+	default:
+		if r.CurrentWave == len(r.Waves)-1 {
+			r.Status = StatusCompleted
+			r.CompletedAt = &now
+			r.updateProgress()
+			s.logger.Info("completed configuration rollout",
+				zap.String("rollout_id", r.ID),
+				zap.String("tenant_id", r.TenantID),
+				zap.Int("succeeded", r.Progress.Succeeded),
+				zap.Int("failed", r.Progress.Failed),
+			)
+			return false, nil
+		}
+		r.CurrentWave++
+		return true, nil
+	}
+}
+
+// startWave deploys the version to every device of a wave. A device that
+// cannot be deployed to counts as failed. The rollout halts when its
+// version no longer exists, as after a restart with in-memory
+// configuration storage.
+func (s *Service) startWave(ctx context.Context, r *Rollout, wave *Wave, now time.Time) error {
+	version, err := s.deployer.GetVersion(ctx, r.TenantID, r.TemplateID, r.Version)
+	if isConfigCode(err, config.ErrVersionNotFound) {
+		r.Status = StatusHalted
+		r.Reason = fmt.Sprintf("version %d no longer exists", r.Version)
+		s.logger.Warn("halted configuration rollout",
+			zap.String("rollout_id", r.ID),
+			zap.String("tenant_id", r.TenantID),
+			zap.String("reason", r.Reason),
+		)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range wave.Targets {
+		t := &wave.Targets[i]
+		deployment, err := s.deployer.DeployConfiguration(ctx, r.TenantID, r.TemplateID, version, t.DeviceID)
+		if err != nil {
+			t.Status = config.DeploymentStatusFailed
+			t.Error = err.Error()
+			continue
+		}
+		t.DeploymentID = deployment.ID
+		t.Status = deployment.Status
+	}
+
+	started := now
+	wave.StartedAt = &started
+	wave.Status = WaveStatusRunning
+
+	s.logger.Info("started configuration rollout wave",
+		zap.String("rollout_id", r.ID),
+		zap.String("tenant_id", r.TenantID),
+		zap.Int("wave", wave.Number),
+		zap.Bool("canary", wave.Canary),
+		zap.Int("devices", len(wave.Targets)),
+	)
+	return nil
+}
+
+// refreshWave copies the outcome of each pending deployment into the wave.
+// Deployments replaced by a newer deployment to the device are marked
+// superseded; deployments that no longer exist count as failed.
+func (s *Service) refreshWave(ctx context.Context, r *Rollout, wave *Wave) error {
+	for i := range wave.Targets {
+		t := &wave.Targets[i]
+		if t.Status != config.DeploymentStatusPending || t.DeploymentID == "" {
+			continue
+		}
+		deployment, err := s.deployer.GetDeployment(ctx, r.TenantID, t.DeploymentID)
+		if isConfigCode(err, config.ErrDeploymentNotFound) {
+			t.Status = config.DeploymentStatusFailed
+			t.Error = "deployment no longer exists"
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		t.Status = deployment.Status
+		t.Error = deployment.Error
+		if deployment.Status == config.DeploymentStatusSuperseded {
+			t.Status = TargetStatusSuperseded
+			t.SupersededBy = deployment.SupersededBy
+		}
+	}
+	return nil
+}
+
+// failPending fails the deployments of a wave that are still pending
+func (s *Service) failPending(ctx context.Context, r *Rollout, wave *Wave, reason string) {
+	for i := range wave.Targets {
+		t := &wave.Targets[i]
+		if t.Status != config.DeploymentStatusPending {
+			continue
+		}
+		if t.DeploymentID != "" {
+			if err := s.deployer.FailDeployment(ctx, r.TenantID, t.DeploymentID, reason); err != nil {
+				s.logger.Error("failed to fail pending rollout deployment",
+					zap.String("rollout_id", r.ID),
+					zap.String("deployment_id", t.DeploymentID),
+					zap.Error(err))
+			}
+		}
+		t.Status = config.DeploymentStatusFailed
+		t.Error = reason
+	}
+}
+
+// finishWave scores a wave whose devices all reported, halting the rollout
+// when the failure rate exceeds the threshold. Superseded devices are left
+// out of the rate, so a wave of only superseded devices succeeds.
+func (s *Service) finishWave(r *Rollout, wave *Wave, now time.Time) {
+	completed := now
+	wave.CompletedAt = &completed
+
+	_, failed, superseded, _ := wave.counts()
+	total := len(wave.Targets) - superseded
+	if failed*100 <= r.Strategy.FailureThreshold*total {
+		wave.Status = WaveStatusSucceeded
+		s.logger.Info("configuration rollout wave succeeded",
+			zap.String("rollout_id", r.ID),
+			zap.String("tenant_id", r.TenantID),
+			zap.Int("wave", wave.Number),
+			zap.Int("failed", failed),
+			zap.Int("superseded", superseded),
+			zap.Int("devices", total),
+		)
+		return
+	}
+
+	wave.Status = WaveStatusFailed
+	r.Status = StatusHalted
+	r.Reason = fmt.Sprintf("wave %d: %d of %d devices failed, above the %d%% threshold",
+		wave.Number, failed, total, r.Strategy.FailureThreshold)
+	s.logger.Warn("halted configuration rollout",
+		zap.String("rollout_id", r.ID),
+		zap.String("tenant_id", r.TenantID),
+		zap.String("reason", r.Reason),
+	)
+}
+
+// sameState reports whether advancing changed nothing worth storing
+func sameState(a, b *Rollout) bool {
+	if a.Status != b.Status || a.CurrentWave != b.CurrentWave || a.Reason != b.Reason {
+		return false
+	}
+	for i := range a.Waves {
+		if a.Waves[i].Status != b.Waves[i].Status {
+			return false
+		}
+		for j := range a.Waves[i].Targets {
+			if a.Waves[i].Targets[j] != b.Waves[i].Targets[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isConfigCode reports whether err is a configuration error with the given
+// code
+func isConfigCode(err error, code config.ErrorCode) bool {
+	var configErr *config.Error
+	return errors.As(err, &configErr) && configErr.Code == code
+}
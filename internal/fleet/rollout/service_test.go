@@ -0,0 +1,329 @@
+package rollout_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	configmemory "github.com/wrale/wrale-fleet/internal/fleet/config/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/device"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+const tenantID = "tenant-1"
+
+// staticMembers serves a fixed device list for every group
+type staticMembers []*device.Device
+
+func (m staticMembers) ListDevices(ctx context.Context, tenantID, groupID string) ([]*device.Device, error) {
+	return m, nil
+}
+
+type fixture struct {
+	t       *testing.T
+	config  *config.Service
+	store   rollout.Store
+	members staticMembers
+	service *rollout.Service
+	version *config.Version
+	hash    string
+}
+
+func newFixture(t *testing.T, devices int) *fixture {
+	ctx := context.Background()
+	logger := zaptest.NewLogger(t)
+	cfg := config.NewService(configmemory.New(), logger)
+
+	template, err := cfg.CreateTemplate(ctx, tenantID, "edge", json.RawMessage(`{"type":"object"}`))
+	require.NoError(t, err)
+	version, err := cfg.CreateValidatedVersion(ctx, tenantID, template.ID, json.RawMessage(`{"workers":4}`), "operator")
+	require.NoError(t, err)
+	_, hash, err := version.Delivery()
+	require.NoError(t, err)
+
+	members := make(staticMembers, devices)
+	for i := range members {
+		members[i] = device.New(tenantID, fmt.Sprintf("edge-%02d", i))
+		members[i].ID = fmt.Sprintf("dev-%02d", i)
+	}
+
+	store := memory.New()
+	return &fixture{
+		t:       t,
+		config:  cfg,
+		store:   store,
+		members: members,
+		service: rollout.NewService(store, cfg, members, logger),
+		version: version,
+		hash:    hash,
+	}
+}
+
+func (f *fixture) start(strategy rollout.Strategy) *rollout.Rollout {
+	r, err := f.service.Start(context.Background(), tenantID, "group-1", f.version.TemplateID, f.version.Number, strategy, "operator")
+	require.NoError(f.t, err)
+	return r
+}
+
+// ack reports the outcome of the current wave: the listed devices fail and
+// every other device applies the configuration
+func (f *fixture) ack(r *rollout.Rollout, failing ...string) {
+	failed := make(map[string]bool, len(failing))
+	for _, id := range failing {
+		failed[id] = true
+	}
+	for _, target := range r.Waves[r.CurrentWave].Targets {
+		errMsg := ""
+		if failed[target.DeviceID] {
+			errMsg = "apply failed"
+		}
+		_, err := f.config.AcknowledgeDeployment(context.Background(), tenantID, target.DeviceID,
+			target.DeploymentID, f.hash, errMsg)
+		require.NoError(f.t, err)
+	}
+}
+
+func (f *fixture) advance(r *rollout.Rollout, now time.Time) *rollout.Rollout {
+	require.NoError(f.t, f.service.Advance(context.Background(), now))
+	r, err := f.service.Get(context.Background(), tenantID, r.ID)
+	require.NoError(f.t, err)
+	return r
+}
+
+func waveSizes(r *rollout.Rollout) []int {
+	sizes := make([]int, len(r.Waves))
+	for i, w := range r.Waves {
+		sizes[i] = len(w.Targets)
+	}
+	return sizes
+}
+
+func TestRolloutWaves(t *testing.T) {
+	f := newFixture(t, 10)
+	r := f.start(rollout.Strategy{CanaryPercent: 10, BatchSize: 4, Pause: time.Minute, FailureThreshold: 25})
+
+	assert.Equal(t, []int{1, 4, 4, 1}, waveSizes(r))
+	assert.True(t, r.Waves[0].Canary)
+	assert.Equal(t, rollout.WaveStatusRunning, r.Waves[0].Status)
+	assert.Equal(t, "dev-00", r.Waves[0].Targets[0].DeviceID)
+	assert.NotEmpty(t, r.Waves[0].Targets[0].DeploymentID)
+	assert.Equal(t, rollout.Progress{Devices: 10, Pending: 10}, r.Progress)
+
+	// The next wave waits for the pause after the canary succeeds.
+	now := time.Now().UTC()
+	f.ack(r)
+	r = f.advance(r, now)
+	assert.Equal(t, rollout.WaveStatusSucceeded, r.Waves[0].Status)
+	assert.Equal(t, rollout.WaveStatusPending, r.Waves[1].Status)
+	assert.Equal(t, 1, r.CurrentWave)
+
+	r = f.advance(r, now.Add(time.Minute))
+	assert.Equal(t, rollout.WaveStatusRunning, r.Waves[1].Status)
+
+	// One failure in four is within the threshold.
+	f.ack(r, "dev-01")
+	r = f.advance(r, now.Add(2*time.Minute))
+	assert.Equal(t, rollout.WaveStatusSucceeded, r.Waves[1].Status)
+
+	// Two failures in four halt the rollout.
+	r = f.advance(r, now.Add(3*time.Minute))
+	require.Equal(t, rollout.WaveStatusRunning, r.Waves[2].Status)
+	f.ack(r, "dev-05", "dev-06")
+	r = f.advance(r, now.Add(4*time.Minute))
+	assert.Equal(t, rollout.StatusHalted, r.Status)
+	assert.Equal(t, rollout.WaveStatusFailed, r.Waves[2].Status)
+	assert.Equal(t, "wave 3: 2 of 4 devices failed, above the 25% threshold", r.Reason)
+
+	// Halted rollouts start no waves until resumed.
+	r = f.advance(r, now.Add(time.Hour))
+	assert.Equal(t, rollout.WaveStatusPending, r.Waves[3].Status)
+
+	r, err := f.service.Resume(context.Background(), tenantID, r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusRunning, r.Status)
+	assert.Empty(t, r.Reason)
+
+	r = f.advance(r, now.Add(2*time.Hour))
+	require.Equal(t, rollout.WaveStatusRunning, r.Waves[3].Status)
+	f.ack(r)
+	r = f.advance(r, now.Add(3*time.Hour))
+	assert.Equal(t, rollout.StatusCompleted, r.Status)
+	assert.NotNil(t, r.CompletedAt)
+	assert.Equal(t, rollout.Progress{Devices: 10, Succeeded: 7, Failed: 3}, r.Progress)
+}
+
+func TestRolloutPauseAndAbort(t *testing.T) {
+	f := newFixture(t, 4)
+	r := f.start(rollout.Strategy{BatchSize: 2})
+	assert.Equal(t, []int{2, 2}, waveSizes(r))
+
+	r, err := f.service.Pause(context.Background(), tenantID, r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusPaused, r.Status)
+
+	_, err = f.service.Pause(context.Background(), tenantID, r.ID)
+	var rolloutErr *rollout.Error
+	require.True(t, errors.As(err, &rolloutErr), "got %v", err)
+	assert.Equal(t, rollout.ErrCodeInvalidOperation, rolloutErr.Code)
+
+	// Acknowledgements during the pause are picked up, but no new wave
+	// starts.
+	f.ack(r, "dev-01")
+	r = f.advance(r, time.Now().Add(time.Hour))
+	assert.Equal(t, rollout.WaveStatusRunning, r.Waves[0].Status)
+
+	r, err = f.service.Resume(context.Background(), tenantID, r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusHalted, r.Status)
+	assert.Equal(t, rollout.WaveStatusFailed, r.Waves[0].Status)
+
+	r, err = f.service.Resume(context.Background(), tenantID, r.ID)
+	require.NoError(t, err)
+	require.Equal(t, rollout.WaveStatusRunning, r.Waves[1].Status)
+
+	// Aborting fails the deployments devices have not acknowledged.
+	r, err = f.service.Abort(context.Background(), tenantID, r.ID, "bad config")
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusAborted, r.Status)
+	for _, target := range r.Waves[1].Targets {
+		assert.Equal(t, config.DeploymentStatusFailed, target.Status)
+		deployment, err := f.config.GetDeployment(context.Background(), tenantID, target.DeploymentID)
+		require.NoError(t, err)
+		assert.Equal(t, "rollout aborted: bad config", deployment.Error)
+	}
+
+	_, err = f.service.Abort(context.Background(), tenantID, r.ID, "")
+	require.True(t, errors.As(err, &rolloutErr), "got %v", err)
+	assert.Equal(t, rollout.ErrCodeInvalidOperation, rolloutErr.Code)
+}
+
+func TestRolloutWaveTimeout(t *testing.T) {
+	f := newFixture(t, 3)
+	r := f.start(rollout.Strategy{FailureThreshold: 50, WaveTimeout: time.Minute})
+	require.Len(t, r.Waves, 1)
+
+	// Waiting devices are not failed before the timeout.
+	start := *r.Waves[0].StartedAt
+	r = f.advance(r, start.Add(30*time.Second))
+	assert.Equal(t, rollout.WaveStatusRunning, r.Waves[0].Status)
+
+	_, err := f.config.AcknowledgeDeployment(context.Background(), tenantID, "dev-00",
+		r.Waves[0].Targets[0].DeploymentID, f.hash, "")
+	require.NoError(t, err)
+
+	r = f.advance(r, start.Add(time.Minute))
+	assert.Equal(t, rollout.StatusHalted, r.Status)
+	assert.Equal(t, rollout.Progress{Devices: 3, Succeeded: 1, Failed: 2}, r.Progress)
+	assert.Equal(t, "not acknowledged within 1m0s", r.Waves[0].Targets[2].Error)
+}
+
+func TestRolloutStartErrors(t *testing.T) {
+	f := newFixture(t, 2)
+	ctx := context.Background()
+
+	_, err := f.service.Start(ctx, tenantID, "group-1", f.version.TemplateID, f.version.Number,
+		rollout.Strategy{CanaryPercent: 150}, "operator")
+	var rolloutErr *rollout.Error
+	require.True(t, errors.As(err, &rolloutErr), "got %v", err)
+	assert.Equal(t, rollout.ErrCodeInvalidRollout, rolloutErr.Code)
+
+	draft, err := f.config.CreateVersion(ctx, tenantID, f.version.TemplateID, json.RawMessage(`{}`), "operator")
+	require.NoError(t, err)
+	_, err = f.service.Start(ctx, tenantID, "group-1", f.version.TemplateID, draft.Number,
+		rollout.Strategy{}, "operator")
+	require.True(t, errors.As(err, &rolloutErr), "got %v", err)
+	assert.Contains(t, rolloutErr.Message, "only valid versions")
+
+	empty := rollout.NewService(memory.New(), f.config, staticMembers{}, zaptest.NewLogger(t))
+	_, err = empty.Start(ctx, tenantID, "group-1", f.version.TemplateID, f.version.Number,
+		rollout.Strategy{}, "operator")
+	require.True(t, errors.As(err, &rolloutErr), "got %v", err)
+	assert.Equal(t, "group has no devices to roll out to", rolloutErr.Message)
+}
+
+func TestRolloutOverlap(t *testing.T) {
+	f := newFixture(t, 2)
+	ctx := context.Background()
+	r := f.start(rollout.Strategy{})
+
+	requireOverlap := func(groupID, message string) {
+		t.Helper()
+		_, err := f.service.Start(ctx, tenantID, groupID, f.version.TemplateID, f.version.Number,
+			rollout.Strategy{}, "operator")
+		var rolloutErr *rollout.Error
+		require.True(t, errors.As(err, &rolloutErr), "got %v", err)
+		assert.Equal(t, rollout.ErrCodeInvalidOperation, rolloutErr.Code)
+		assert.Equal(t, message, rolloutErr.Message)
+		assert.Equal(t, r.ID, rolloutErr.Fields["rollout_id"])
+	}
+
+	requireOverlap("group-1", "group is already in running rollout "+r.ID+"; abort it first")
+	requireOverlap("group-2", "device dev-00 is already in running rollout "+r.ID+"; abort it first")
+
+	// Halted rollouts can be resumed, so they still hold their devices.
+	f.ack(r, "dev-00")
+	r = f.advance(r, time.Now())
+	require.Equal(t, rollout.StatusHalted, r.Status)
+	requireOverlap("group-2", "device dev-00 is already in halted rollout "+r.ID+"; abort it first")
+
+	_, err := f.service.Abort(ctx, tenantID, r.ID, "")
+	require.NoError(t, err)
+	f.start(rollout.Strategy{})
+}
+
+func TestRolloutSupersededTargets(t *testing.T) {
+	f := newFixture(t, 2)
+	ctx := context.Background()
+	r := f.start(rollout.Strategy{})
+	require.Len(t, r.Waves, 1)
+
+	// A manual apply replaces the rollout's deployment to dev-00.
+	manual, err := f.config.DeployConfiguration(ctx, tenantID, f.version.TemplateID, f.version, "dev-00")
+	require.NoError(t, err)
+	_, err = f.config.AcknowledgeDeployment(ctx, tenantID, "dev-01", r.Waves[0].Targets[1].DeploymentID, f.hash, "")
+	require.NoError(t, err)
+
+	r = f.advance(r, time.Now())
+	assert.Equal(t, rollout.StatusCompleted, r.Status, r.Reason)
+	assert.Equal(t, rollout.WaveStatusSucceeded, r.Waves[0].Status)
+	assert.Equal(t, rollout.TargetStatusSuperseded, r.Waves[0].Targets[0].Status)
+	assert.Equal(t, manual.ID, r.Waves[0].Targets[0].SupersededBy)
+	assert.Equal(t, rollout.Progress{Devices: 2, Succeeded: 1, Superseded: 1}, r.Progress)
+}
+
+func TestRolloutRestart(t *testing.T) {
+	f := newFixture(t, 6)
+	r := f.start(rollout.Strategy{BatchSize: 2, FailureThreshold: 50})
+
+	// A service over the same stores carries on with the rollout.
+	restarted := rollout.NewService(f.store, f.config, f.members, zaptest.NewLogger(t))
+	f.ack(r)
+	require.NoError(t, restarted.Advance(context.Background(), time.Now()))
+	r, err := restarted.Get(context.Background(), tenantID, r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.WaveStatusSucceeded, r.Waves[0].Status)
+	assert.Equal(t, rollout.WaveStatusRunning, r.Waves[1].Status)
+
+	// Deployments lost with the configuration store fail, and the
+	// rollout halts once the version is gone as well.
+	lost := config.NewService(configmemory.New(), zaptest.NewLogger(t))
+	restarted = rollout.NewService(f.store, lost, f.members, zaptest.NewLogger(t))
+	require.NoError(t, restarted.Advance(context.Background(), time.Now()))
+	r, err = restarted.Get(context.Background(), tenantID, r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusHalted, r.Status)
+	assert.Equal(t, "deployment no longer exists", r.Waves[1].Targets[0].Error)
+
+	r, err = restarted.Resume(context.Background(), tenantID, r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusHalted, r.Status)
+	assert.Equal(t, fmt.Sprintf("version %d no longer exists", f.version.Number), r.Reason)
+}
@@ -0,0 +1,25 @@
+package rollout
+
+import "context"
+
+// Store defines the interface for rollout storage operations
+type Store interface {
+	// Create stores a new rollout
+	Create(ctx context.Context, rollout *Rollout) error
+
+	// Get retrieves a rollout by ID and tenant
+	Get(ctx context.Context, tenantID, id string) (*Rollout, error)
+
+	// Update replaces an existing rollout
+	Update(ctx context.Context, rollout *Rollout) error
+
+	// List returns rollouts matching the options, oldest first
+	List(ctx context.Context, opts ListOptions) ([]*Rollout, error)
+}
+
+// ListOptions defines criteria for listing rollouts
+type ListOptions struct {
+	TenantID string // Filter by tenant; empty lists every tenant
+	GroupID  string // Filter by target group
+	Status   Status // Filter by rollout status
+}
@@ -0,0 +1,22 @@
+// Package bolt provides a durable, file-backed implementation of the
+// rollout.Store interface built on an embedded bbolt database.
+//
+// Rollouts are persisted as JSON documents in per-tenant buckets, so
+// rollouts in progress continue where they left off after a restart: waves
+// that were waiting for acknowledgements pick up the outcome of their
+// deployments, and waves that timed out in the meantime are scored on the
+// next advance.
+//
+// The store holds an exclusive file lock on the database while it is open,
+// so only one process may use a given database file at a time. Callers must
+// call Close to release the lock.
+//
+// Example usage:
+//
+//	store, err := bolt.New(filepath.Join(dataDir, "rollouts.db"))
+//	if err != nil {
+//		return err
+//	}
+//	defer store.Close()
+//	service := rollout.NewService(store, deployer, members, logger)
+package bolt
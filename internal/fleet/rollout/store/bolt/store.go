@@ -0,0 +1,217 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	bbolt "go.etcd.io/bbolt"
+)
+
+const (
+	// dirPermissions restricts the database directory to the service user
+	dirPermissions = 0750
+	// filePermissions restricts the database file to the service user
+	filePermissions = 0600
+	// openTimeout bounds how long New waits for the database file lock
+	openTimeout = 5 * time.Second
+)
+
+// rolloutsBucket is the root bucket holding one nested bucket per tenant
+var rolloutsBucket = []byte("rollouts")
+
+// Store provides a bbolt-backed implementation of rollout.Store.
+// Rollouts are stored as JSON documents keyed by rollout ID inside a
+// per-tenant bucket.
+type Store struct {
+	db *bbolt.DB
+}
+
+// Ensure Store implements rollout.Store
+var _ rollout.Store = (*Store)(nil)
+
+// New opens (or creates) the rollout database at the given path.
+func New(path string) (*Store, error) {
+	if path == "" {
+		return nil, rollout.E("Store.New", rollout.ErrCodeStoreOperation, "database path is required", nil)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), dirPermissions); err != nil {
+		return nil, rollout.E("Store.New", rollout.ErrCodeStoreOperation, "failed to create database directory", err)
+	}
+
+	db, err := bbolt.Open(path, filePermissions, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, rollout.E("Store.New", rollout.ErrCodeStoreOperation, "failed to open database", err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(rolloutsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, rollout.E("Store.New", rollout.ErrCodeStoreOperation, "failed to initialize database", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the database file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Create implements rollout.Store
+func (s *Store) Create(ctx context.Context, r *rollout.Rollout) error {
+	if err := ctx.Err(); err != nil {
+		return rollout.E("Store.Create", rollout.ErrCodeStoreOperation, "context cancelled", err)
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return rollout.E("Store.Create", rollout.ErrCodeStoreOperation, "failed to encode rollout", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		tenant, err := tx.Bucket(rolloutsBucket).CreateBucketIfNotExists([]byte(r.TenantID))
+		if err != nil {
+			return rollout.E("Store.Create", rollout.ErrCodeStoreOperation, "failed to create tenant bucket", err)
+		}
+
+		if tenant.Get([]byte(r.ID)) != nil {
+			return rollout.E("Store.Create", rollout.ErrCodeInvalidRollout, "rollout already exists", nil)
+		}
+
+		if err := tenant.Put([]byte(r.ID), data); err != nil {
+			return rollout.E("Store.Create", rollout.ErrCodeStoreOperation, "failed to write rollout", err)
+		}
+		return nil
+	})
+}
+
+// Get implements rollout.Store
+func (s *Store) Get(ctx context.Context, tenantID, id string) (*rollout.Rollout, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, rollout.E("Store.Get", rollout.ErrCodeStoreOperation, "context cancelled", err)
+	}
+
+	var r *rollout.Rollout
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		tenant := tx.Bucket(rolloutsBucket).Bucket([]byte(tenantID))
+		var data []byte
+		if tenant != nil {
+			data = tenant.Get([]byte(id))
+		}
+		if data == nil {
+			return rollout.E("Store.Get", rollout.ErrCodeRolloutNotFound, "rollout not found", nil)
+		}
+
+		decoded, err := decode(data)
+		if err != nil {
+			return rollout.E("Store.Get", rollout.ErrCodeStoreOperation, "failed to decode rollout", err)
+		}
+		r = decoded
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Update implements rollout.Store
+func (s *Store) Update(ctx context.Context, r *rollout.Rollout) error {
+	if err := ctx.Err(); err != nil {
+		return rollout.E("Store.Update", rollout.ErrCodeStoreOperation, "context cancelled", err)
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return rollout.E("Store.Update", rollout.ErrCodeStoreOperation, "failed to encode rollout", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		tenant := tx.Bucket(rolloutsBucket).Bucket([]byte(r.TenantID))
+		if tenant == nil || tenant.Get([]byte(r.ID)) == nil {
+			return rollout.E("Store.Update", rollout.ErrCodeRolloutNotFound, "rollout not found", nil)
+		}
+
+		if err := tenant.Put([]byte(r.ID), data); err != nil {
+			return rollout.E("Store.Update", rollout.ErrCodeStoreOperation, "failed to write rollout", err)
+		}
+		return nil
+	})
+}
+
+// List implements rollout.Store. Results are sorted by creation time, then
+// ID, to match the ordering of the memory store.
+func (s *Store) List(ctx context.Context, opts rollout.ListOptions) ([]*rollout.Rollout, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, rollout.E("Store.List", rollout.ErrCodeStoreOperation, "context cancelled", err)
+	}
+
+	var result []*rollout.Rollout
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(rolloutsBucket)
+
+		collect := func(tenant *bbolt.Bucket) error {
+			return tenant.ForEach(func(_, data []byte) error {
+				r, err := decode(data)
+				if err != nil {
+					return rollout.E("Store.List", rollout.ErrCodeStoreOperation, "failed to decode rollout", err)
+				}
+				if opts.GroupID != "" && r.GroupID != opts.GroupID {
+					return nil
+				}
+				if opts.Status != "" && r.Status != opts.Status {
+					return nil
+				}
+				result = append(result, r)
+				return nil
+			})
+		}
+
+		if opts.TenantID != "" {
+			tenant := root.Bucket([]byte(opts.TenantID))
+			if tenant == nil {
+				return nil
+			}
+			return collect(tenant)
+		}
+
+		return root.ForEachBucket(func(name []byte) error {
+			return collect(root.Bucket(name))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// decode unmarshals a stored rollout document
+func decode(data []byte) (*rollout.Rollout, error) {
+	var r rollout.Rollout
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("unmarshaling rollout: %w", err)
+	}
+	return &r, nil
+}
@@ -0,0 +1,62 @@
+package bolt
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/config"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout/storetest"
+)
+
+// newTestStore opens a fresh store in a temporary directory that is
+// closed and removed when the test completes.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := New(filepath.Join(t.TempDir(), "rollouts.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestStore_Persistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data", "rollouts.db")
+
+	store, err := New(path)
+	require.NoError(t, err)
+
+	r, err := rollout.New("tenant-1", "group-1", "template-1", 2, rollout.Strategy{CanaryPercent: 50}, []string{"a", "b"})
+	require.NoError(t, err)
+	r.Waves[0].Targets[0].DeploymentID = "dep-1"
+	r.Waves[0].Targets[0].Status = config.DeploymentStatusCompleted
+	require.NoError(t, store.Create(ctx, r))
+	require.NoError(t, store.Close())
+
+	// Reopen and verify the rollout survived the restart
+	reopened, err := New(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	stored, err := reopened.Get(ctx, "tenant-1", r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusRunning, stored.Status)
+	assert.Equal(t, r.Strategy, stored.Strategy)
+	require.Len(t, stored.Waves, 2)
+	assert.True(t, stored.Waves[0].Canary)
+	assert.Equal(t, r.Waves[0].Targets, stored.Waves[0].Targets)
+
+	running, err := reopened.List(ctx, rollout.ListOptions{Status: rollout.StatusRunning})
+	require.NoError(t, err)
+	require.Len(t, running, 1)
+	assert.Equal(t, r.ID, running[0].ID)
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) rollout.Store {
+		return newTestStore(t)
+	})
+}
@@ -0,0 +1,45 @@
+// Package factory provides creation functions for rollout store implementations.
+package factory
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout/store/bolt"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout/store/memory"
+	"github.com/wrale/wrale-fleet/internal/fleet/storage"
+)
+
+// Built-in backend names
+const (
+	// Memory keeps rollouts in memory; rollouts in progress stop on restart
+	Memory = "memory"
+	// Bolt persists rollouts in an embedded bbolt database
+	Bolt = "bolt"
+)
+
+// dbFile is the rollout database file name within Options.DataDir
+const dbFile = "rollouts.db"
+
+// Options configures rollout store backends.
+type Options struct {
+	// DataDir is the directory file-backed stores keep their data in
+	DataDir string
+}
+
+// Registry holds the available rollout store backends.
+var Registry = storage.NewRegistry[rollout.Store, Options]("rollout")
+
+func init() {
+	Registry.Register(Memory, func(ctx context.Context, opts Options) (rollout.Store, error) {
+		return memory.New(), nil
+	})
+	Registry.Register(Bolt, func(ctx context.Context, opts Options) (rollout.Store, error) {
+		if opts.DataDir == "" {
+			return nil, fmt.Errorf("data directory is required")
+		}
+		return bolt.New(filepath.Join(opts.DataDir, dbFile))
+	})
+}
@@ -0,0 +1,102 @@
+// Package memory provides an in-memory implementation of the rollout.Store
+// interface. Rollouts are returned as copies so callers cannot change
+// stored state without an Update.
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+)
+
+// Store implements an in-memory rollout store
+type Store struct {
+	mu       sync.RWMutex
+	rollouts map[string]map[string]*rollout.Rollout // tenant -> id -> rollout
+}
+
+// New creates a new memory store instance
+func New() *Store {
+	return &Store{
+		rollouts: make(map[string]map[string]*rollout.Rollout),
+	}
+}
+
+// Create implements rollout.Store
+func (s *Store) Create(ctx context.Context, r *rollout.Rollout) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.rollouts[r.TenantID]; !exists {
+		s.rollouts[r.TenantID] = make(map[string]*rollout.Rollout)
+	}
+	if _, exists := s.rollouts[r.TenantID][r.ID]; exists {
+		return rollout.E("Store.Create", rollout.ErrCodeInvalidRollout, "rollout already exists", nil)
+	}
+	s.rollouts[r.TenantID][r.ID] = r.Clone()
+	return nil
+}
+
+// Get implements rollout.Store
+func (s *Store) Get(ctx context.Context, tenantID, id string) (*rollout.Rollout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, exists := s.rollouts[tenantID][id]
+	if !exists {
+		return nil, rollout.E("Store.Get", rollout.ErrCodeRolloutNotFound, "rollout not found", nil)
+	}
+	return r.Clone(), nil
+}
+
+// Update implements rollout.Store
+func (s *Store) Update(ctx context.Context, r *rollout.Rollout) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.rollouts[r.TenantID][r.ID]; !exists {
+		return rollout.E("Store.Update", rollout.ErrCodeRolloutNotFound, "rollout not found", nil)
+	}
+	s.rollouts[r.TenantID][r.ID] = r.Clone()
+	return nil
+}
+
+// List implements rollout.Store
+func (s *Store) List(ctx context.Context, opts rollout.ListOptions) ([]*rollout.Rollout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*rollout.Rollout
+	for tenantID, tenantRollouts := range s.rollouts {
+		if opts.TenantID != "" && tenantID != opts.TenantID {
+			continue
+		}
+		for _, r := range tenantRollouts {
+			if opts.GroupID != "" && r.GroupID != opts.GroupID {
+				continue
+			}
+			if opts.Status != "" && r.Status != opts.Status {
+				continue
+			}
+			result = append(result, r.Clone())
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) rollout.Store {
+		return New()
+	})
+}
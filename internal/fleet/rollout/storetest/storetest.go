@@ -0,0 +1,153 @@
+// Package storetest provides a conformance suite for rollout.Store
+// implementations.
+//
+// Every backend should run the suite from its own tests so that all stores
+// share a single definition of correct behavior:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) rollout.Store {
+//			return memory.New()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wrale/wrale-fleet/internal/fleet/rollout"
+)
+
+// Factory creates an empty store for a single test. Implementations that
+// hold resources should release them through t.Cleanup.
+type Factory func(t *testing.T) rollout.Store
+
+// Run executes the full conformance suite against stores created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("CreateAndGet", func(t *testing.T) { testCreateAndGet(t, factory(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, factory(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, factory(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, factory(t)) })
+}
+
+// newRollout builds a valid rollout with one device per wave
+func newRollout(t *testing.T, tenantID, groupID string, deviceIDs ...string) *rollout.Rollout {
+	t.Helper()
+	r, err := rollout.New(tenantID, groupID, "template-1", 1, rollout.Strategy{BatchSize: 1}, deviceIDs)
+	require.NoError(t, err)
+	return r
+}
+
+// requireCode asserts that err is a *rollout.Error carrying the given code
+func requireCode(t *testing.T, err error, code string) {
+	t.Helper()
+	require.Error(t, err)
+	var rolloutErr *rollout.Error
+	require.True(t, errors.As(err, &rolloutErr), "expected *rollout.Error, got %T: %v", err, err)
+	assert.Equal(t, code, rolloutErr.Code)
+}
+
+func testCreateAndGet(t *testing.T, store rollout.Store) {
+	ctx := context.Background()
+
+	r := newRollout(t, "tenant-1", "group-1", "a", "b")
+	require.NoError(t, store.Create(ctx, r))
+	requireCode(t, store.Create(ctx, r), rollout.ErrCodeInvalidRollout)
+
+	got, err := store.Get(ctx, "tenant-1", r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, r.GroupID, got.GroupID)
+	assert.Equal(t, r.Strategy, got.Strategy)
+	assert.Equal(t, r.Progress, got.Progress)
+	require.Len(t, got.Waves, 2)
+	assert.Equal(t, "a", got.Waves[0].Targets[0].DeviceID)
+	assert.True(t, r.CreatedAt.Equal(got.CreatedAt))
+
+	// Stored rollouts are isolated from the caller's copy.
+	r.Waves[0].Targets[0].Status = "completed"
+	got, err = store.Get(ctx, "tenant-1", r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", got.Waves[0].Targets[0].Status)
+
+	got.Waves[0].Targets[0].Status = "failed"
+	again, err := store.Get(ctx, "tenant-1", r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", again.Waves[0].Targets[0].Status)
+
+	_, err = store.Get(ctx, "tenant-1", "missing")
+	requireCode(t, err, rollout.ErrCodeRolloutNotFound)
+
+	t.Run("invalid", func(t *testing.T) {
+		invalid := newRollout(t, "tenant-1", "group-1", "c")
+		invalid.TenantID = ""
+		require.Error(t, store.Create(ctx, invalid))
+	})
+}
+
+func testUpdate(t *testing.T, store rollout.Store) {
+	ctx := context.Background()
+
+	r := newRollout(t, "tenant-1", "group-1", "a")
+	requireCode(t, store.Update(ctx, r), rollout.ErrCodeRolloutNotFound)
+	require.NoError(t, store.Create(ctx, r))
+
+	r.Status = rollout.StatusHalted
+	r.Reason = "wave 1 failed"
+	r.Waves[0].Targets[0].DeploymentID = "dep-1"
+	require.NoError(t, store.Update(ctx, r))
+
+	got, err := store.Get(ctx, "tenant-1", r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rollout.StatusHalted, got.Status)
+	assert.Equal(t, "wave 1 failed", got.Reason)
+	assert.Equal(t, "dep-1", got.Waves[0].Targets[0].DeploymentID)
+}
+
+func testList(t *testing.T, store rollout.Store) {
+	ctx := context.Background()
+
+	first := newRollout(t, "tenant-1", "group-1", "a")
+	second := newRollout(t, "tenant-1", "group-2", "b")
+	second.CreatedAt = first.CreatedAt.Add(1)
+	second.Status = rollout.StatusPaused
+	third := newRollout(t, "tenant-2", "group-1", "c")
+	third.CreatedAt = first.CreatedAt.Add(2)
+	for _, r := range []*rollout.Rollout{third, second, first} {
+		require.NoError(t, store.Create(ctx, r))
+	}
+
+	ids := func(opts rollout.ListOptions) []string {
+		t.Helper()
+		list, err := store.List(ctx, opts)
+		require.NoError(t, err)
+		result := make([]string, 0, len(list))
+		for _, r := range list {
+			result = append(result, r.ID)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{first.ID, second.ID, third.ID}, ids(rollout.ListOptions{}), "ordered by creation time")
+	assert.Equal(t, []string{first.ID, second.ID}, ids(rollout.ListOptions{TenantID: "tenant-1"}))
+	assert.Equal(t, []string{first.ID, third.ID}, ids(rollout.ListOptions{GroupID: "group-1"}))
+	assert.Equal(t, []string{second.ID}, ids(rollout.ListOptions{Status: rollout.StatusPaused}))
+	assert.Equal(t, []string{first.ID}, ids(rollout.ListOptions{TenantID: "tenant-1", Status: rollout.StatusRunning}))
+	assert.Empty(t, ids(rollout.ListOptions{TenantID: "tenant-3"}))
+}
+
+func testTenantIsolation(t *testing.T, store rollout.Store) {
+	ctx := context.Background()
+
+	r := newRollout(t, "tenant-1", "group-1", "a")
+	require.NoError(t, store.Create(ctx, r))
+
+	_, err := store.Get(ctx, "tenant-2", r.ID)
+	requireCode(t, err, rollout.ErrCodeRolloutNotFound)
+
+	other := r.Clone()
+	other.TenantID = "tenant-2"
+	requireCode(t, store.Update(ctx, other), rollout.ErrCodeRolloutNotFound)
+}
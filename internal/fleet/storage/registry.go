@@ -0,0 +1,136 @@
+// Package storage provides a registry that maps storage backend names to
+// store constructors.
+//
+// Each domain keeps its own registry, typed by the domain's store interface
+// and an options struct describing what its backends may need. Backends
+// register themselves by name, and callers such as wfcentral select one
+// from configuration:
+//
+//	var Registry = storage.NewRegistry[device.Store, Options]("device")
+//
+//	func init() {
+//		Registry.Register("memory", func(ctx context.Context, opts Options) (device.Store, error) {
+//			return memory.New(), nil
+//		})
+//	}
+//
+//	store, err := factory.Registry.Open(ctx, cfg.DeviceStorage, factory.Options{DataDir: dir})
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory constructs a store of type S from options of type O.
+type Factory[S, O any] func(ctx context.Context, opts O) (S, error)
+
+// UnknownBackendError reports a backend name that has not been registered.
+type UnknownBackendError struct {
+	Domain    string   // Domain whose registry was consulted
+	Name      string   // Requested backend name
+	Available []string // Registered backend names, sorted
+}
+
+// Error implements the error interface
+func (e *UnknownBackendError) Error() string {
+	return fmt.Sprintf("unknown %s storage backend %q (available: %s)",
+		e.Domain, e.Name, strings.Join(e.Available, ", "))
+}
+
+// Registry maps backend names to factories for a single domain.
+// It is safe for concurrent use.
+type Registry[S, O any] struct {
+	domain    string
+	mu        sync.RWMutex
+	factories map[string]Factory[S, O]
+}
+
+// NewRegistry creates an empty registry for the named domain. The domain
+// name only appears in error messages.
+func NewRegistry[S, O any](domain string) *Registry[S, O] {
+	return &Registry[S, O]{
+		domain:    domain,
+		factories: make(map[string]Factory[S, O]),
+	}
+}
+
+// Register makes a backend available under name. Like database/sql's
+// Register, it panics if name is empty, factory is nil or the name is
+// already taken, since these are programming errors caught at init time.
+func (r *Registry[S, O]) Register(name string, factory Factory[S, O]) {
+	if name == "" {
+		panic(fmt.Sprintf("storage: %s backend name is empty", r.domain))
+	}
+	if factory == nil {
+		panic(fmt.Sprintf("storage: %s backend %q has a nil factory", r.domain, name))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.factories[name]; exists {
+		panic(fmt.Sprintf("storage: %s backend %q registered twice", r.domain, name))
+	}
+	r.factories[name] = factory
+}
+
+// Validate returns an *UnknownBackendError if name is not registered. It
+// lets callers reject bad configuration before opening any store.
+func (r *Registry[S, O]) Validate(name string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, exists := r.factories[name]; !exists {
+		return r.unknown(name)
+	}
+	return nil
+}
+
+// Open constructs the store registered under name.
+func (r *Registry[S, O]) Open(ctx context.Context, name string, opts O) (S, error) {
+	r.mu.RLock()
+	factory, exists := r.factories[name]
+	r.mu.RUnlock()
+
+	if !exists {
+		var zero S
+		return zero, r.unknown(name)
+	}
+
+	store, err := factory(ctx, opts)
+	if err != nil {
+		var zero S
+		return zero, fmt.Errorf("opening %s storage backend %q: %w", r.domain, name, err)
+	}
+	return store, nil
+}
+
+// Names returns the registered backend names in sorted order.
+func (r *Registry[S, O]) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.names()
+}
+
+// names returns sorted backend names; the caller must hold r.mu
+func (r *Registry[S, O]) names() []string {
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unknown builds the error for an unregistered name; the caller must hold r.mu
+func (r *Registry[S, O]) unknown(name string) error {
+	return &UnknownBackendError{
+		Domain:    r.domain,
+		Name:      name,
+		Available: r.names(),
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testStore struct{ path string }
+
+type testOptions struct{ Dir string }
+
+func newTestRegistry() *Registry[*testStore, testOptions] {
+	r := NewRegistry[*testStore, testOptions]("widget")
+	r.Register("memory", func(ctx context.Context, opts testOptions) (*testStore, error) {
+		return &testStore{}, nil
+	})
+	r.Register("file", func(ctx context.Context, opts testOptions) (*testStore, error) {
+		if opts.Dir == "" {
+			return nil, errors.New("directory is required")
+		}
+		return &testStore{path: opts.Dir}, nil
+	})
+	return r
+}
+
+func TestRegistry_Open(t *testing.T) {
+	r := newTestRegistry()
+	ctx := context.Background()
+
+	store, err := r.Open(ctx, "file", testOptions{Dir: "/data"})
+	require.NoError(t, err)
+	assert.Equal(t, "/data", store.path)
+
+	_, err = r.Open(ctx, "file", testOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `widget storage backend "file"`)
+	assert.Contains(t, err.Error(), "directory is required")
+}
+
+func TestRegistry_Unknown(t *testing.T) {
+	r := newTestRegistry()
+
+	_, err := r.Open(context.Background(), "etcd", testOptions{})
+	require.Error(t, err)
+
+	var unknown *UnknownBackendError
+	require.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "widget", unknown.Domain)
+	assert.Equal(t, "etcd", unknown.Name)
+	assert.Equal(t, []string{"file", "memory"}, unknown.Available)
+	assert.Equal(t, `unknown widget storage backend "etcd" (available: file, memory)`, err.Error())
+
+	assert.ErrorAs(t, r.Validate("etcd"), &unknown)
+	assert.NoError(t, r.Validate("memory"))
+}
+
+func TestRegistry_Names(t *testing.T) {
+	assert.Equal(t, []string{"file", "memory"}, newTestRegistry().Names())
+	assert.Empty(t, NewRegistry[*testStore, testOptions]("empty").Names())
+}
+
+func TestRegistry_RegisterPanics(t *testing.T) {
+	factory := func(ctx context.Context, opts testOptions) (*testStore, error) { return nil, nil }
+
+	r := newTestRegistry()
+	assert.Panics(t, func() { r.Register("memory", factory) }, "duplicate name")
+	assert.Panics(t, func() { r.Register("", factory) }, "empty name")
+	assert.Panics(t, func() { r.Register("nil", nil) }, "nil factory")
+}
+
+func TestRegistry_Concurrency(t *testing.T) {
+	r := newTestRegistry()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = r.Open(ctx, "memory", testOptions{})
+			_ = r.Names()
+		}()
+	}
+	wg.Wait()
+}